@@ -14,7 +14,9 @@ import (
 	"log"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"runtime"
+	"strings"
 	"syscall"
 	"time"
 
@@ -23,11 +25,15 @@ import (
 	"emu/plugins/appsim"
 	"emu/plugins/arp"
 	"emu/plugins/cdp"
+	"emu/plugins/dhcprelay"
 	dhcp "emu/plugins/dhcpv4"
 	dhcpsrv "emu/plugins/dhcpv4srv"
 	"emu/plugins/dhcpv6"
+	"emu/plugins/dhcpv6srv"
 	"emu/plugins/dns"
 	"emu/plugins/dot1x"
+	"emu/plugins/dot1xauth"
+	"emu/plugins/http"
 	"emu/plugins/icmp"
 	"emu/plugins/igmp"
 	"emu/plugins/ipfix"
@@ -35,6 +41,7 @@ import (
 	"emu/plugins/lldp"
 	"emu/plugins/mdns"
 	ppp "emu/plugins/point2point"
+	"emu/plugins/pppoesrv"
 	"emu/plugins/tdl"
 	"emu/plugins/transport"
 	"emu/plugins/transport_example"
@@ -49,11 +56,15 @@ func RegisterPlugins(tctx *core.CThreadCtx) {
 	appsim.Register(tctx)
 	arp.Register(tctx)
 	cdp.Register(tctx)
+	dhcprelay.Register(tctx)
 	dhcp.Register(tctx)
 	dhcpsrv.Register(tctx)
 	dhcpv6.Register(tctx)
+	dhcpv6srv.Register(tctx)
 	dns.Register(tctx)
 	dot1x.Register(tctx)
+	dot1xauth.Register(tctx)
+	http.Register(tctx)
 	icmp.Register(tctx)
 	igmp.Register(tctx)
 	ipfix.Register(tctx)
@@ -62,6 +73,7 @@ func RegisterPlugins(tctx *core.CThreadCtx) {
 	mdns.Register(tctx)
 	tdl.Register(tctx)
 	ppp.Register(tctx)
+	pppoesrv.Register(tctx)
 	transport.Register(tctx)
 	transport_example.Register(tctx)
 }
@@ -75,6 +87,7 @@ type MainArgs struct {
 	captureJson    *string // filename for the capture
 	monitor        *bool   // monitor traffic in K12 mode and dump in pcapFile
 	monitorFile    *string // filename for the monitored traffic to be dumped
+	monitorPcapNg  *string // filename for the monitored traffic in pcapng format
 	verbose        *bool   // verbose mode, will print details
 	version        *bool   // print version of EMU and exit
 	emuTCPoZMQ     *bool   // use TCP over ZMQ instead of the classic IPC to connect with TRex.
@@ -82,6 +95,8 @@ type MainArgs struct {
 	simulation     *bool
 	lockMainThread *bool
 	maxCores       *int
+	afPacket       *string // list of vport:interface to run on with AF_PACKET instead of ZMQ
+	threads        *int    // number of worker threads, the namespaces are sharded between them
 }
 
 func printVersion() {
@@ -118,6 +133,7 @@ func parseMainArgs() *MainArgs {
 	args.captureJson = parser.String("C", "capture-json", &argparse.Options{Default: "capture.json", Help: "Path to save the JSON with capture details"})
 	args.monitor = parser.Flag("m", "monitor", &argparse.Options{Default: false, Help: "Run server in K12 monitor mode"})
 	args.monitorFile = parser.String("M", "monitor-pcap", &argparse.Options{Default: "stdout", Help: "Path to save monitored traffic (PCAP)"})
+	args.monitorPcapNg = parser.String("", "monitor-pcapng", &argparse.Options{Default: "", Help: "Path to save all the traffic in pcapng format with direction, vport and tunnel metadata"})
 	args.verbose = parser.Flag("v", "verbose", &argparse.Options{Default: false, Help: "Run server in verbose mode"})
 	args.version = parser.Flag("V", "version", &argparse.Options{Default: false, Help: "Show TRex-Emu version"})
 	args.emuTCPoZMQ = parser.Flag("", "emu-zmq-tcp", &argparse.Options{Default: false, Help: "Run TCP over ZMQ. Default is IPC"})
@@ -125,6 +141,8 @@ func parseMainArgs() *MainArgs {
 	args.simulation = parser.Flag("s", "simulation", &argparse.Options{Default: false, Help: "Run server in simulation mode"})
 	args.lockMainThread = parser.Flag("", "lock-main-thread", &argparse.Options{Default: false, Help: "Run the main-thread in a dedicated OS thread"})
 	args.maxCores = parser.Int("", "max-cores", &argparse.Options{Default: 0, Help: "Set the max number of CPUs that can be executing simultaneously (GOMAXPROCS)"})
+	args.afPacket = parser.String("i", "af-packet", &argparse.Options{Default: "", Help: "Run on linux interfaces using AF_PACKET instead of ZMQ, list of vport:interface e.g. 0:veth0,1:veth1"})
+	args.threads = parser.Int("", "threads", &argparse.Options{Default: 1, Help: "Number of worker threads, the namespaces are sharded between them by the tunnel key"})
 
 	err := parser.Parse(os.Args)
 	if err != nil {
@@ -181,6 +199,8 @@ func SetOsMaxFilesLimit() error {
 
 func RunCoreZmq(args *MainArgs) {
 	var zmqVeth core.VethIFZmq
+	var afPacketVeth core.VethIFAfPacket
+	var afPacketPorts map[uint16]string
 	var dummyVeth bool
 	var simulation bool
 	var simrx core.VethIFSim
@@ -198,7 +218,15 @@ func RunCoreZmq(args *MainArgs) {
 	}
 
 	rpcPort := uint16(*args.rpcPort)
-	if *args.emuTCPoZMQ {
+	if *args.afPacket != "" {
+		afPacketPorts, err = core.ParseAfPacketPorts(*args.afPacket)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if afPacketPorts != nil {
+		fmt.Printf("Run AF_PACKET server on [RPC:%d, Interfaces:%s]\n", rpcPort, *args.afPacket)
+	} else if *args.emuTCPoZMQ {
 		fmt.Printf("Run ZMQ server on [RPC:%d, RX: TCP:%d, TX: TCP:%d]\n", rpcPort, *args.vethPort, *args.vethPort+1)
 	} else {
 		fmt.Printf("Run ZMQ server on [RPC:%d, RX: IPC, TX:IPC]\n", rpcPort)
@@ -209,6 +237,14 @@ func RunCoreZmq(args *MainArgs) {
 	simulation = *args.simulation
 	dummyVeth = *args.dummyVeth || *args.kernelMode
 
+	if *args.threads > 1 {
+		if dummyVeth || afPacketPorts != nil || *args.capture || *args.monitor {
+			log.Fatal("--threads is supported only with the ZMQ veth, without capture and monitor modes")
+		}
+		RunThreadPool(args, rpcPort, simulation)
+		return
+	}
+
 	if dummyVeth {
 		var simVeth core.VethSink
 		simrx = &simVeth
@@ -219,7 +255,14 @@ func RunCoreZmq(args *MainArgs) {
 	tctx.SetKernelMode(*args.kernelMode)
 	tctx.SetLockMainThread(*args.lockMainThread)
 
-	if !dummyVeth {
+	if !dummyVeth && afPacketPorts != nil {
+		err = afPacketVeth.Create(tctx, afPacketPorts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		afPacketVeth.StartRxThread()
+		tctx.SetZmqVeth(&afPacketVeth)
+	} else if !dummyVeth {
 		zmqVeth.Create(tctx, uint16(*args.vethPort), *args.zmqServer, *args.emuTCPoZMQ, false)
 		zmqVeth.StartRxThread()
 		tctx.SetZmqVeth(&zmqVeth)
@@ -239,6 +282,11 @@ func RunCoreZmq(args *MainArgs) {
 		defer monitorFile.Close()
 	}
 	tctx.Veth.SetDebug(*args.monitor, monitorFile, *args.capture)
+	if *args.monitorPcapNg != "" {
+		if _, err = tctx.StartCapture(nil, "", *args.monitorPcapNg); err != nil {
+			log.Fatal(err)
+		}
+	}
 	tctx.StartRxThread()
 	defer tctx.Delete()
 
@@ -249,6 +297,39 @@ func RunCoreZmq(args *MainArgs) {
 	}
 }
 
+// RunThreadPool run the namespaces in several worker threads with one ZMQ veth
+func RunThreadPool(args *MainArgs, rpcPort uint16, simulation bool) {
+	var zmqVeth core.VethIFZmq
+
+	pool := core.NewThreadPool(*args.threads, rpcPort, simulation)
+	pool.SetVerbose(*args.verbose)
+	pool.SetKernelMode(*args.kernelMode)
+	pool.SetLockMainThread(*args.lockMainThread)
+
+	zmqVeth.Create(pool.Workers[0], uint16(*args.vethPort), *args.zmqServer, *args.emuTCPoZMQ, false)
+	pool.SetZmqVeth(&zmqVeth)
+
+	for _, tctx := range pool.Workers {
+		RegisterPlugins(tctx)
+	}
+	pool.SetRpcParams(*args.verbose, false)
+
+	if *args.monitorPcapNg != "" {
+		// a file per worker, e.g. emu-0.pcapng
+		ext := filepath.Ext(*args.monitorPcapNg)
+		base := strings.TrimSuffix(*args.monitorPcapNg, ext)
+		for i, tctx := range pool.Workers {
+			if _, err := tctx.StartCapture(nil, "", fmt.Sprintf("%s-%d%s", base, i, ext)); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	pool.StartRxThread()
+	defer pool.Delete()
+
+	pool.MainLoop()
+}
+
 func main() {
 	RunCoreZmq(parseMainArgs())
 }
@@ -0,0 +1,427 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"bytes"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"external/google/gopacket/pcapgo"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"time"
+)
+
+/*
+Packet capture
+
+The thread holds a list of active captures, each one matches the packets of one namespace (or all of them) against a
+filter and writes them to a sink. The packets are captured in the thread context, on rx before they are parsed and on tx
+when they are handed to the veth, so it works with every veth (simulator, ZMQ, AF_PACKET and pcap).
+
+The pcapng sink writes an interface block per vport, the EPB flags holds the direction (inbound for rx, outbound for tx)
+and the packet comment holds the tunnel key and the MAC of the emulated client, for example
+
+	tun=1,{8100:0064},{0000:0000} mac=00:00:01:00:00:01
+
+A thread holds at most CAPTURE_MAX captures and the captures of a namespace are stopped when the namespace is removed.
+
+	id, err := tctx.StartCapture(nil, "udp port 67", "/tmp/dhcp.pcapng")
+	...
+	tctx.StopCapture(id)
+*/
+
+const (
+	CAPTURE_MAX = 16 // active captures of a thread
+)
+
+type CCaptureStats struct {
+	captureStart    uint64
+	captureStop     uint64
+	capturePkts     uint64
+	captureBytes    uint64
+	captureWriteErr uint64
+}
+
+func NewCaptureStatsDb(o *CCaptureStats) *CCounterDb {
+	db := NewCCounterDb("capture")
+
+	db.Add(&CCounterRec{
+		Counter:  &o.captureStart,
+		Name:     "captureStart",
+		Help:     "captures started",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.captureStop,
+		Name:     "captureStop",
+		Help:     "captures stopped",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.capturePkts,
+		Name:     "capturePkts",
+		Help:     "captured packets",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.captureBytes,
+		Name:     "captureBytes",
+		Help:     "captured bytes",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.captureWriteErr,
+		Name:     "captureWriteErr",
+		Help:     "error writing a captured packet",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	return db
+}
+
+// captureMeta is the metadata of a captured packet
+type captureMeta struct {
+	ts     time.Time
+	rx     bool
+	vport  uint16
+	tun    CTunnelKey
+	client *CClient // the emulated client that sent/receives the packet, nil in case there is no such client
+}
+
+// comment return the tunnel key and client MAC as a pcapng comment
+func (o *captureMeta) comment() string {
+	s := "tun=" + o.tun.StringRpc()
+	if o.client != nil {
+		s += " mac=" + net.HardwareAddr(o.client.Mac[:]).String()
+	}
+	return s
+}
+
+// captureSink stores the captured packets, the data is valid only during the call
+type captureSink interface {
+	write(data []byte, meta *captureMeta) error
+	close() error
+}
+
+// capturePcapNg write the packets to a pcapng file
+type capturePcapNg struct {
+	file  *os.File
+	w     *pcapgo.NgWriter
+	intfs map[uint16]int // vport to pcapng interface id
+}
+
+func newCapturePcapNg(filename string) (*capturePcapNg, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &capturePcapNg{file: f, intfs: make(map[uint16]int)}, nil
+}
+
+func (o *capturePcapNg) interfaceOf(vport uint16) (int, error) {
+	if id, ok := o.intfs[vport]; ok {
+		return id, nil
+	}
+	intf := pcapgo.DefaultNgInterface
+	intf.Name = fmt.Sprintf("vport-%d", vport)
+	intf.LinkType = layers.LinkTypeEthernet
+	var id int
+	var err error
+	if o.w == nil {
+		// the writer is created with the first interface
+		options := pcapgo.DefaultNgWriterOptions
+		options.SectionInfo.Application = "trex-emu"
+		o.w, err = pcapgo.NewNgWriterInterface(o.file, intf, options)
+	} else {
+		id, err = o.w.AddInterface(intf)
+	}
+	if err != nil {
+		return 0, err
+	}
+	o.intfs[vport] = id
+	return id, nil
+}
+
+func (o *capturePcapNg) write(data []byte, meta *captureMeta) error {
+	id, err := o.interfaceOf(meta.vport)
+	if err != nil {
+		return err
+	}
+	flags := pcapgo.NgEPBFlagDirectionOutbound
+	if meta.rx {
+		flags = pcapgo.NgEPBFlagDirectionInbound
+	}
+	return o.w.WritePacketWithOptions(gopacket.CaptureInfo{
+		Timestamp:      meta.ts,
+		CaptureLength:  len(data),
+		Length:         len(data),
+		InterfaceIndex: id,
+	}, data, pcapgo.NgPacketOptions{Flags: flags, Comment: meta.comment()})
+}
+
+func (o *capturePcapNg) close() error {
+	if o.w == nil {
+		// nothing was captured, write a valid empty file
+		var err error
+		intf := pcapgo.DefaultNgInterface
+		intf.LinkType = layers.LinkTypeEthernet
+		if o.w, err = pcapgo.NewNgWriterInterface(o.file, intf, pcapgo.DefaultNgWriterOptions); err != nil {
+			o.file.Close()
+			return err
+		}
+	}
+	err := o.w.Flush()
+	if cerr := o.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// CCapture is one active capture
+type CCapture struct {
+	Id     uint32
+	tun    *CTunnelKey // nil for all the namespaces
+	mac    *MACKey     // nil for all the clients
+	filter *CaptureFilter
+	sink   captureSink
+	pkts   uint64
+	bytes  uint64
+}
+
+// CCaptureInfo is the RPC representation of a capture
+type CCaptureInfo struct {
+	Id     uint32           `json:"id"`
+	Tun    *CTunnelDataJson `json:"tun,omitempty"`
+	Mac    *MACKey          `json:"mac,omitempty"`
+	Filter string           `json:"filter"`
+	Pkts   uint64           `json:"pkts"`
+	Bytes  uint64           `json:"bytes"`
+}
+
+func (o *CCapture) match(data []byte, meta *captureMeta) bool {
+	if o.tun != nil && *o.tun != meta.tun {
+		return false
+	}
+	if o.mac != nil && !bytes.Equal(data[0:6], o.mac[:]) && !bytes.Equal(data[6:12], o.mac[:]) {
+		return false
+	}
+	return o.filter.Match(data)
+}
+
+func (o *CCapture) GetInfo() *CCaptureInfo {
+	info := &CCaptureInfo{Id: o.Id, Mac: o.mac, Filter: o.filter.String(), Pkts: o.pkts, Bytes: o.bytes}
+	if o.tun != nil {
+		info.Tun = new(CTunnelDataJson)
+		o.tun.GetJson(info.Tun)
+	}
+	return info
+}
+
+// CCaptureCtx holds the active captures of a thread
+type CCaptureCtx struct {
+	tctx     *CThreadCtx
+	captures map[uint32]*CCapture
+	nextId   uint32
+	ringPkts int // packets reserved by the ring captures
+	stats    CCaptureStats
+	Cdb      *CCounterDb
+}
+
+func (o *CCaptureCtx) Init(tctx *CThreadCtx) {
+	o.tctx = tctx
+	o.captures = make(map[uint32]*CCapture)
+	o.nextId = 1
+	o.Cdb = NewCaptureStatsDb(&o.stats)
+}
+
+// reserve check that one more capture with a ring of ringPkts packets (0 for other sinks) does not cross the limits
+func (o *CCaptureCtx) reserve(ringPkts int) error {
+	if len(o.captures) >= CAPTURE_MAX {
+		return fmt.Errorf("there are already %d active captures", CAPTURE_MAX)
+	}
+	if o.ringPkts+ringPkts > CAPTURE_RING_TOTAL_LIMIT {
+		return fmt.Errorf("capture limit %d crosses the total of %d packets, %d are in use", ringPkts, CAPTURE_RING_TOTAL_LIMIT, o.ringPkts)
+	}
+	return nil
+}
+
+// Add start a new capture, tun nil captures all the namespaces and mac nil captures all the clients
+func (o *CCaptureCtx) Add(tun *CTunnelKey, mac *MACKey, filter string, sink captureSink) (*CCapture, error) {
+	ringPkts := 0
+	if ring, ok := sink.(*captureRing); ok {
+		ringPkts = len(ring.pkts)
+	}
+	err := o.reserve(ringPkts)
+	if err != nil {
+		sink.close()
+		return nil, err
+	}
+	f, err := NewCaptureFilter(filter)
+	if err != nil {
+		sink.close()
+		return nil, err
+	}
+	c := &CCapture{Id: o.nextId, filter: f, sink: sink}
+	if tun != nil {
+		key := *tun
+		c.tun = &key
+	}
+	if mac != nil {
+		m := *mac
+		c.mac = &m
+	}
+	o.nextId++
+	o.ringPkts += ringPkts
+	o.captures[c.Id] = c
+	o.stats.captureStart++
+	return c, nil
+}
+
+// Get return an active capture, nil in case there is no such capture
+func (o *CCaptureCtx) Get(id uint32) *CCapture {
+	return o.captures[id]
+}
+
+// Remove stop a capture and close its sink
+func (o *CCaptureCtx) Remove(id uint32) error {
+	c, ok := o.captures[id]
+	if !ok {
+		return fmt.Errorf("capture %d does not exist", id)
+	}
+	delete(o.captures, id)
+	if ring, ok := c.sink.(*captureRing); ok {
+		o.ringPkts -= len(ring.pkts)
+	}
+	o.stats.captureStop++
+	return c.sink.close()
+}
+
+// RemoveNs stop the captures of a namespace
+func (o *CCaptureCtx) RemoveNs(key *CTunnelKey) {
+	for id, c := range o.captures {
+		if c.tun != nil && *c.tun == *key {
+			o.Remove(id)
+		}
+	}
+}
+
+// RemoveAll stop all the captures
+func (o *CCaptureCtx) RemoveAll() {
+	for id := range o.captures {
+		o.Remove(id)
+	}
+}
+
+// GetInfo return the active captures ordered by id
+func (o *CCaptureCtx) GetInfo() []*CCaptureInfo {
+	ids := make([]int, 0, len(o.captures))
+	for id := range o.captures {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+	res := make([]*CCaptureInfo, 0, len(ids))
+	for _, id := range ids {
+		res = append(res, o.captures[uint32(id)].GetInfo())
+	}
+	return res
+}
+
+func (o *CCaptureCtx) now() time.Time {
+	if o.tctx.Simulation {
+		return time.Unix(0, 0).Add(time.Duration(o.tctx.GetTickSimInSec() * float64(time.Second)))
+	}
+	return time.Now()
+}
+
+// OnPacket capture a packet, rx is true for received packets. The mbuf is not changed
+func (o *CCaptureCtx) OnPacket(m *Mbuf, rx bool) {
+	if len(o.captures) == 0 {
+		return
+	}
+	var p []byte
+	if m.IsContiguous() {
+		p = m.GetData()
+	} else {
+		m1 := m.GetContiguous(&o.tctx.MPool)
+		defer m1.FreeMbuf()
+		p = m1.GetData()
+	}
+	if len(p) < 14 {
+		return
+	}
+
+	meta := captureMeta{rx: rx, vport: m.VPort()}
+	packetTunnelKey(m.VPort(), p, &meta.tun)
+	resolved := false
+	for _, c := range o.captures {
+		if !c.match(p, &meta) {
+			continue
+		}
+		if !resolved {
+			resolved = true
+			meta.ts = o.now()
+			meta.client = o.lookupClient(p, &meta)
+		}
+		c.pkts++
+		c.bytes += uint64(len(p))
+		o.stats.capturePkts++
+		o.stats.captureBytes += uint64(len(p))
+		if err := c.sink.write(p, &meta); err != nil {
+			o.stats.captureWriteErr++
+		}
+	}
+}
+
+// lookupClient return the emulated client of a packet, destination MAC on rx and source MAC on tx
+func (o *CCaptureCtx) lookupClient(p []byte, meta *captureMeta) *CClient {
+	ns := o.tctx.GetNs(&meta.tun)
+	if ns == nil {
+		return nil
+	}
+	var mac MACKey
+	if meta.rx {
+		copy(mac[:], p[0:6])
+	} else {
+		copy(mac[:], p[6:12])
+	}
+	return ns.CLookupByMac(&mac)
+}
+
+// StartCapture start a pcapng capture to a file, tun nil captures all the namespaces. return the capture id
+func (o *CThreadCtx) StartCapture(tun *CTunnelKey, filter string, filename string) (uint32, error) {
+	if tun != nil && !o.HasNs(tun) {
+		return 0, fmt.Errorf("error can't find a valid namespace for this tunnel")
+	}
+	if err := o.capture.reserve(0); err != nil {
+		return 0, err
+	}
+	sink, err := newCapturePcapNg(filename)
+	if err != nil {
+		return 0, err
+	}
+	c, err := o.capture.Add(tun, nil, filter, sink)
+	if err != nil {
+		return 0, err
+	}
+	return c.Id, nil
+}
+
+// StopCapture stop a capture and close its file
+func (o *CThreadCtx) StopCapture(id uint32) error {
+	return o.capture.Remove(id)
+}
@@ -0,0 +1,379 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"external/google/gopacket/layers"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+/*
+CaptureFilter is a small subset of the BPF (tcpdump) filter syntax, it is compiled once and matched against each captured packet.
+
+	expr      := term { ("or" | "||") term }
+	term      := factor { ("and" | "&&") factor }
+	factor    := ("not" | "!") factor | "(" expr ")" | primitive
+	primitive := "arp" | "ip" | "ip6" | "icmp" | "icmp6" | "igmp" | "eapol" | "pppoe"
+	           | "vlan" [id]
+	           | ("udp" | "tcp") [["src" | "dst"] "port" number]
+	           | ["src" | "dst"] "port" number
+	           | ["src" | "dst"] "host" ipv4/ipv6
+	           | "ether" ["src" | "dst"] "host" mac
+
+For example "udp port 67 or arp", "vlan 100 and not icmp6", "ether src host 00:00:01:00:00:01".
+An empty filter matches all the packets.
+*/
+type CaptureFilter struct {
+	expr string
+	root captureFilterNode
+}
+
+// capturePkt holds the fields of a packet that the filter could match
+type capturePkt struct {
+	srcMac   []byte
+	dstMac   []byte
+	ethType  uint16
+	vlans    [2]uint16
+	vlanCnt  int
+	srcIP    net.IP
+	dstIP    net.IP
+	l3       uint16 // ethernet type of the ip header, zero in case there is no ip header
+	proto    uint8
+	hasPorts bool
+	srcPort  uint16
+	dstPort  uint16
+}
+
+func (o *capturePkt) decode(p []byte) {
+	*o = capturePkt{}
+	if len(p) < 14 {
+		return
+	}
+	o.dstMac = p[0:6]
+	o.srcMac = p[6:12]
+	o.ethType = binary.BigEndian.Uint16(p[12:14])
+	offset := 14
+	for (o.ethType == uint16(layers.EthernetTypeDot1Q) || o.ethType == uint16(layers.EthernetTypeQinQ)) && o.vlanCnt < 2 {
+		if len(p) < offset+4 {
+			return
+		}
+		o.vlans[o.vlanCnt] = binary.BigEndian.Uint16(p[offset:offset+2]) & 0xfff
+		o.vlanCnt++
+		o.ethType = binary.BigEndian.Uint16(p[offset+2 : offset+4])
+		offset += 4
+	}
+
+	l4 := 0
+	switch layers.EthernetType(o.ethType) {
+	case layers.EthernetTypeIPv4:
+		if len(p) < offset+20 {
+			return
+		}
+		ipv4 := layers.IPv4Header(p[offset : offset+20])
+		o.l3 = o.ethType
+		o.srcIP = net.IP(p[offset+12 : offset+16])
+		o.dstIP = net.IP(p[offset+16 : offset+20])
+		o.proto = ipv4.GetNextProtocol()
+		// ports only in the first fragment
+		if binary.BigEndian.Uint16(p[offset+6:offset+8])&0x1fff == 0 {
+			l4 = offset + int(ipv4.GetHeaderLen())
+		}
+	case layers.EthernetTypeIPv6:
+		if len(p) < offset+IPV6_HEADER_SIZE {
+			return
+		}
+		o.l3 = o.ethType
+		o.srcIP = net.IP(p[offset+8 : offset+24])
+		o.dstIP = net.IP(p[offset+24 : offset+40])
+		o.proto = p[offset+6]
+		l4 = offset + IPV6_HEADER_SIZE
+	ext:
+		for l4 > 0 {
+			switch o.proto {
+			case IPV6_EXT_HOP_BY_HOP, IPV6_EXT_ROUTING, IPV6_EXT_DST:
+				if len(p) < l4+8 {
+					return
+				}
+				o.proto = p[l4]
+				l4 += 8 + int(p[l4+1])*8
+				continue
+			case IPV6_EXT_Fragment:
+				if len(p) < l4+8 {
+					return
+				}
+				o.proto = p[l4]
+				if binary.BigEndian.Uint16(p[l4+2:l4+4])&0xfff8 != 0 {
+					l4 = 0
+				} else {
+					l4 += 8
+				}
+				continue
+			}
+			break ext
+		}
+	}
+
+	if l4 > 0 && (o.proto == uint8(layers.IPProtocolUDP) || o.proto == uint8(layers.IPProtocolTCP)) && len(p) >= l4+4 {
+		o.hasPorts = true
+		o.srcPort = binary.BigEndian.Uint16(p[l4 : l4+2])
+		o.dstPort = binary.BigEndian.Uint16(p[l4+2 : l4+4])
+	}
+}
+
+type captureFilterNode interface {
+	match(p *capturePkt) bool
+}
+
+type captureFilterAnd struct{ a, b captureFilterNode }
+type captureFilterOr struct{ a, b captureFilterNode }
+type captureFilterNot struct{ a captureFilterNode }
+type captureFilterFunc func(p *capturePkt) bool
+
+func (o *captureFilterAnd) match(p *capturePkt) bool { return o.a.match(p) && o.b.match(p) }
+func (o *captureFilterOr) match(p *capturePkt) bool  { return o.a.match(p) || o.b.match(p) }
+func (o *captureFilterNot) match(p *capturePkt) bool { return !o.a.match(p) }
+func (o captureFilterFunc) match(p *capturePkt) bool { return o(p) }
+
+type captureFilterParser struct {
+	tokens []string
+	pos    int
+}
+
+func (o *captureFilterParser) peek() string {
+	if o.pos < len(o.tokens) {
+		return o.tokens[o.pos]
+	}
+	return ""
+}
+
+func (o *captureFilterParser) next() string {
+	t := o.peek()
+	if t != "" {
+		o.pos++
+	}
+	return t
+}
+
+func (o *captureFilterParser) expr() (captureFilterNode, error) {
+	a, err := o.term()
+	if err != nil {
+		return nil, err
+	}
+	for o.peek() == "or" || o.peek() == "||" {
+		o.next()
+		b, err := o.term()
+		if err != nil {
+			return nil, err
+		}
+		a = &captureFilterOr{a, b}
+	}
+	return a, nil
+}
+
+func (o *captureFilterParser) term() (captureFilterNode, error) {
+	a, err := o.factor()
+	if err != nil {
+		return nil, err
+	}
+	for o.peek() == "and" || o.peek() == "&&" {
+		o.next()
+		b, err := o.factor()
+		if err != nil {
+			return nil, err
+		}
+		a = &captureFilterAnd{a, b}
+	}
+	return a, nil
+}
+
+func (o *captureFilterParser) factor() (captureFilterNode, error) {
+	switch o.peek() {
+	case "not", "!":
+		o.next()
+		a, err := o.factor()
+		if err != nil {
+			return nil, err
+		}
+		return &captureFilterNot{a}, nil
+	case "(":
+		o.next()
+		a, err := o.expr()
+		if err != nil {
+			return nil, err
+		}
+		if o.next() != ")" {
+			return nil, fmt.Errorf("missing ')'")
+		}
+		return a, nil
+	}
+	return o.primitive()
+}
+
+func captureFilterIPProto(l3 layers.EthernetType, proto layers.IPProtocol) captureFilterFunc {
+	return func(p *capturePkt) bool {
+		return p.l3 != 0 && (l3 == 0 || p.l3 == uint16(l3)) && p.proto == uint8(proto)
+	}
+}
+
+func captureFilterEthType(ethType layers.EthernetType) captureFilterFunc {
+	return func(p *capturePkt) bool {
+		return p.ethType == uint16(ethType)
+	}
+}
+
+func (o *captureFilterParser) primitive() (captureFilterNode, error) {
+	t := o.next()
+	switch t {
+	case "":
+		return nil, fmt.Errorf("unexpected end of filter")
+	case "arp":
+		return captureFilterEthType(layers.EthernetTypeARP), nil
+	case "ip":
+		return captureFilterEthType(layers.EthernetTypeIPv4), nil
+	case "ip6":
+		return captureFilterEthType(layers.EthernetTypeIPv6), nil
+	case "eapol":
+		return captureFilterEthType(layers.EthernetTypeEAPOL), nil
+	case "pppoe":
+		return captureFilterFunc(func(p *capturePkt) bool {
+			return p.ethType == uint16(layers.EthernetTypePPPoEDiscovery) || p.ethType == uint16(layers.EthernetTypePPPoESession)
+		}), nil
+	case "icmp":
+		return captureFilterIPProto(layers.EthernetTypeIPv4, layers.IPProtocolICMPv4), nil
+	case "igmp":
+		return captureFilterIPProto(layers.EthernetTypeIPv4, layers.IPProtocolIGMP), nil
+	case "icmp6":
+		return captureFilterIPProto(layers.EthernetTypeIPv6, layers.IPProtocolICMPv6), nil
+	case "udp", "tcp":
+		proto := layers.IPProtocolUDP
+		if t == "tcp" {
+			proto = layers.IPProtocolTCP
+		}
+		var a captureFilterNode = captureFilterIPProto(0, proto)
+		// "udp port 67" is "udp and port 67"
+		if n := o.peek(); n == "port" || n == "src" || n == "dst" {
+			b, err := o.primitive()
+			if err != nil {
+				return nil, err
+			}
+			a = &captureFilterAnd{a, b}
+		}
+		return a, nil
+	case "vlan":
+		id, err := strconv.ParseUint(o.peek(), 10, 12)
+		if err != nil {
+			return captureFilterFunc(func(p *capturePkt) bool { return p.vlanCnt > 0 }), nil
+		}
+		o.next()
+		return captureFilterFunc(func(p *capturePkt) bool {
+			for i := 0; i < p.vlanCnt; i++ {
+				if p.vlans[i] == uint16(id) {
+					return true
+				}
+			}
+			return false
+		}), nil
+	case "ether":
+		dir := o.direction()
+		if o.next() != "host" {
+			return nil, fmt.Errorf("expected 'host' after 'ether'")
+		}
+		mac, err := net.ParseMAC(o.next())
+		if err != nil || len(mac) != 6 {
+			return nil, fmt.Errorf("invalid mac address in ether host")
+		}
+		return captureFilterFunc(func(p *capturePkt) bool {
+			return (dir != "dst" && bytes.Equal(p.srcMac, mac)) || (dir != "src" && bytes.Equal(p.dstMac, mac))
+		}), nil
+	case "src", "dst", "port", "host":
+		dir := ""
+		if t == "src" || t == "dst" {
+			dir = t
+			t = o.next()
+		}
+		switch t {
+		case "port":
+			port, err := strconv.ParseUint(o.next(), 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("invalid port number")
+			}
+			return captureFilterFunc(func(p *capturePkt) bool {
+				return p.hasPorts && ((dir != "dst" && p.srcPort == uint16(port)) || (dir != "src" && p.dstPort == uint16(port)))
+			}), nil
+		case "host":
+			ip := net.ParseIP(o.next())
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip address in host")
+			}
+			return captureFilterFunc(func(p *capturePkt) bool {
+				return p.l3 != 0 && ((dir != "dst" && ip.Equal(p.srcIP)) || (dir != "src" && ip.Equal(p.dstIP)))
+			}), nil
+		}
+		return nil, fmt.Errorf("expected 'port' or 'host' after '%s'", dir)
+	}
+	return nil, fmt.Errorf("unknown filter primitive '%s'", t)
+}
+
+func (o *captureFilterParser) direction() string {
+	if t := o.peek(); t == "src" || t == "dst" {
+		return o.next()
+	}
+	return ""
+}
+
+func tokenizeCaptureFilter(expr string) []string {
+	expr = strings.NewReplacer("(", " ( ", ")", " ) ", "&&", " && ", "||", " || ").Replace(expr)
+	tokens := strings.Fields(expr)
+	// "!" could be attached to the next token
+	var r []string
+	for _, t := range tokens {
+		for len(t) > 1 && t[0] == '!' {
+			r = append(r, "!")
+			t = t[1:]
+		}
+		r = append(r, strings.ToLower(t))
+	}
+	return r
+}
+
+// NewCaptureFilter compile a filter expression
+func NewCaptureFilter(expr string) (*CaptureFilter, error) {
+	o := &CaptureFilter{expr: expr}
+	tokens := tokenizeCaptureFilter(expr)
+	if len(tokens) == 0 {
+		return o, nil
+	}
+	parser := captureFilterParser{tokens: tokens}
+	root, err := parser.expr()
+	if err != nil {
+		return nil, fmt.Errorf("invalid capture filter %q: %s", expr, err.Error())
+	}
+	if parser.pos != len(tokens) {
+		return nil, fmt.Errorf("invalid capture filter %q: unexpected '%s'", expr, parser.peek())
+	}
+	o.root = root
+	return o, nil
+}
+
+// String return the filter expression
+func (o *CaptureFilter) String() string {
+	return o.expr
+}
+
+// Match return true in case the packet matches the filter
+func (o *CaptureFilter) Match(p []byte) bool {
+	if o.root == nil {
+		return true
+	}
+	var pkt capturePkt
+	pkt.decode(p)
+	return o.root.match(&pkt)
+}
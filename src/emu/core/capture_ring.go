@@ -0,0 +1,148 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"bytes"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"external/google/gopacket/pcapgo"
+	"fmt"
+	"time"
+)
+
+/*
+On-demand capture into a bounded in-memory ring
+
+The ring keeps the last Limit packets of a namespace (or of one client in the namespace), when it is full the oldest
+packet is dropped. The packets are fetched as a pcap file, so the automation could take a trace only when a test fails.
+The limits of all the rings of a thread are at most CAPTURE_RING_TOTAL_LIMIT packets.
+
+	id, err := tctx.StartCaptureRing(&key, &mac, "", 1000)
+	...
+	pcap, res, err := tctx.FetchCapture(id, true)
+*/
+
+const (
+	CAPTURE_RING_DEF_LIMIT   = 1024
+	CAPTURE_RING_MAX_LIMIT   = 65536
+	CAPTURE_RING_TOTAL_LIMIT = 2 * CAPTURE_RING_MAX_LIMIT // packets of all the rings of a thread
+)
+
+type captureRingPkt struct {
+	ts   time.Time
+	data []byte
+}
+
+// captureRing is a sink that keeps the last limit packets
+type captureRing struct {
+	pkts    []captureRingPkt
+	head    int // index of the oldest packet
+	cnt     int
+	dropped uint64 // packets that were overwritten
+}
+
+func newCaptureRing(limit int) *captureRing {
+	return &captureRing{pkts: make([]captureRingPkt, limit)}
+}
+
+func (o *captureRing) write(data []byte, meta *captureMeta) error {
+	i := (o.head + o.cnt) % len(o.pkts)
+	if o.cnt == len(o.pkts) {
+		o.head = (o.head + 1) % len(o.pkts)
+		o.dropped++
+	} else {
+		o.cnt++
+	}
+	// reuse the buffer of the overwritten packet
+	o.pkts[i].ts = meta.ts
+	o.pkts[i].data = append(o.pkts[i].data[:0], data...)
+	return nil
+}
+
+func (o *captureRing) close() error {
+	o.pkts = nil
+	o.head = 0
+	o.cnt = 0
+	return nil
+}
+
+// pcap return the packets as a pcap file
+func (o *captureRing) pcap() ([]byte, error) {
+	var b bytes.Buffer
+	w := pcapgo.NewWriterNanos(&b)
+	if err := w.WriteFileHeader(uint32(MAX_PACKET_SIZE), layers.LinkTypeEthernet); err != nil {
+		return nil, err
+	}
+	for j := 0; j < o.cnt; j++ {
+		pkt := &o.pkts[(o.head+j)%len(o.pkts)]
+		err := w.WritePacket(gopacket.CaptureInfo{
+			Timestamp:     pkt.ts,
+			CaptureLength: len(pkt.data),
+			Length:        len(pkt.data),
+		}, pkt.data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b.Bytes(), nil
+}
+
+func (o *captureRing) clear() {
+	for j := range o.pkts {
+		o.pkts[j].data = o.pkts[j].data[:0]
+	}
+	o.head = 0
+	o.cnt = 0
+}
+
+// CCaptureFetchInfo is the state of a ring capture at the time of fetch
+type CCaptureFetchInfo struct {
+	Pkts    int    // packets in the pcap
+	Dropped uint64 // packets that were overwritten since the start of the capture
+}
+
+// StartCaptureRing start a capture of a namespace into a ring of limit packets, mac nil captures all the clients. return the capture id
+func (o *CThreadCtx) StartCaptureRing(tun *CTunnelKey, mac *MACKey, filter string, limit int) (uint32, error) {
+	if !o.HasNs(tun) {
+		return 0, fmt.Errorf("error can't find a valid namespace for this tunnel")
+	}
+	if limit == 0 {
+		limit = CAPTURE_RING_DEF_LIMIT
+	}
+	if limit < 0 || limit > CAPTURE_RING_MAX_LIMIT {
+		return 0, fmt.Errorf("capture limit %d should be between 1 and %d", limit, CAPTURE_RING_MAX_LIMIT)
+	}
+	if err := o.capture.reserve(limit); err != nil {
+		return 0, err
+	}
+	c, err := o.capture.Add(tun, mac, filter, newCaptureRing(limit))
+	if err != nil {
+		return 0, err
+	}
+	return c.Id, nil
+}
+
+// FetchCapture return the packets of a ring capture as a pcap file, clear removes the returned packets from the ring
+func (o *CThreadCtx) FetchCapture(id uint32, clear bool) ([]byte, *CCaptureFetchInfo, error) {
+	c := o.capture.Get(id)
+	if c == nil {
+		return nil, nil, fmt.Errorf("capture %d does not exist", id)
+	}
+	ring, ok := c.sink.(*captureRing)
+	if !ok {
+		return nil, nil, fmt.Errorf("capture %d is not a ring capture", id)
+	}
+	b, err := ring.pcap()
+	if err != nil {
+		return nil, nil, err
+	}
+	info := &CCaptureFetchInfo{Pkts: ring.cnt, Dropped: ring.dropped}
+	if clear {
+		ring.clear()
+	}
+	return b, info, nil
+}
@@ -0,0 +1,322 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"bytes"
+	"encoding/base64"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"external/google/gopacket/pcapgo"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/intel-go/fastjson"
+)
+
+func buildCaptureUdpIPv4(vlan uint16, sport, dport uint16) []byte {
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 0, 1, 0, 0, 1},
+		DstMAC:       net.HardwareAddr{0, 0, 2, 0, 0, 1},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	l := []gopacket.SerializableLayer{eth}
+	if vlan != 0 {
+		eth.EthernetType = layers.EthernetTypeDot1Q
+		l = append(l, &layers.Dot1Q{VLANIdentifier: vlan, Type: layers.EthernetTypeIPv4})
+	}
+	l = append(l,
+		&layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolUDP,
+			SrcIP: net.IPv4(16, 0, 0, 1), DstIP: net.IPv4(16, 0, 0, 2)},
+		&layers.UDP{SrcPort: layers.UDPPort(sport), DstPort: layers.UDPPort(dport)},
+		gopacket.Payload(reassPayload(8)))
+	return PacketUtlBuild(l...)
+}
+
+func buildCaptureIcmpIPv6() []byte {
+	return PacketUtlBuild(
+		&layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0, 0, 1, 0, 0, 1},
+			DstMAC:       net.HardwareAddr{0x33, 0x33, 0, 0, 0, 1},
+			EthernetType: layers.EthernetTypeIPv6,
+		},
+		&layers.IPv6{Version: 6, HopLimit: 255, NextHeader: layers.IPProtocolICMPv6,
+			SrcIP: net.ParseIP("2001:db8::1"), DstIP: net.ParseIP("ff02::1")},
+		&layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeEchoRequest, 0)},
+		gopacket.Payload(reassPayload(8)))
+}
+
+func TestCaptureFilter(t *testing.T) {
+	udp := buildCaptureUdpIPv4(0, 68, 67)
+	udpVlan := buildCaptureUdpIPv4(100, 1025, 53)
+	icmp6 := buildCaptureIcmpIPv6()
+
+	tests := []struct {
+		filter string
+		pkt    []byte
+		exp    bool
+	}{
+		{"", udp, true},
+		{"udp", udp, true},
+		{"tcp", udp, false},
+		{"ip and udp port 67", udp, true},
+		{"dst port 68", udp, false},
+		{"src port 68 && dst port 67", udp, true},
+		{"vlan", udp, false},
+		{"vlan 100 and udp port 53", udpVlan, true},
+		{"vlan 101", udpVlan, false},
+		{"host 16.0.0.2", udpVlan, true},
+		{"src host 16.0.0.2", udpVlan, false},
+		{"ether src host 00:00:01:00:00:01", udp, true},
+		{"ether dst host 00:00:01:00:00:01", udp, false},
+		{"icmp6", icmp6, true},
+		{"ip6 and not udp", icmp6, true},
+		{"!icmp6", icmp6, false},
+		{"arp or (ip6 and host ff02::1)", icmp6, true},
+		{"icmp or udp port 53", icmp6, false},
+	}
+	for _, tc := range tests {
+		f, err := NewCaptureFilter(tc.filter)
+		if err != nil {
+			t.Fatalf(" ERROR filter %q: %s ", tc.filter, err.Error())
+		}
+		if f.Match(tc.pkt) != tc.exp {
+			t.Fatalf(" ERROR filter %q expected %v ", tc.filter, tc.exp)
+		}
+	}
+
+	for _, bad := range []string{"udp and", "(udp", "port x", "foo", "ether host 1.1.1.1", "udp udp"} {
+		if _, err := NewCaptureFilter(bad); err == nil {
+			t.Fatalf(" ERROR filter %q should not compile ", bad)
+		}
+	}
+}
+
+func TestCapturePcapNg(t *testing.T) {
+	dir := t.TempDir()
+	input := dir + "/in.pcap"
+	output := dir + "/capture.pcapng"
+	writePcapInput(t, input, []time.Duration{0, time.Second, 2 * time.Second})
+
+	tctx := NewThreadCtx(0, 4510, true, nil)
+	defer tctx.Delete()
+	var veth VethIFPcap
+	if err := veth.Create(tctx, input, "", 3); err != nil {
+		t.Fatal(err)
+	}
+	tctx.SetZmqVeth(&veth)
+	tctx.parser.RegisterCb("arp", pcapArpEchoCb, []ParserMatch{{EthType: uint16(layers.EthernetTypeARP)}})
+
+	var tun CTunnelData
+	tun.Vport = 3
+	var key CTunnelKey
+	key.Set(&tun)
+	ns := NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+	ns.AddClient(NewClient(ns, MACKey{0, 0, 2, 0, 0, 1}, Ipv4Key{16, 0, 0, 1}, Ipv6Key{}, Ipv4Key{16, 0, 0, 2}))
+
+	tun.Vport = 4
+	var other CTunnelKey
+	other.Set(&tun)
+	tctx.AddNs(&other, NewNSCtx(tctx, &other))
+	if _, err := tctx.StartCapture(&other, "arp", dir+"/other.pcapng"); err != nil {
+		t.Fatal(err)
+	}
+	id, err := tctx.StartCapture(&key, "arp", output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tctx.MainLoopSim(5 * time.Second)
+
+	info := tctx.capture.GetInfo()
+	if len(info) != 2 || info[0].Pkts != 0 || info[1].Pkts != 6 {
+		t.Fatalf(" ERROR unexpected captures %+v ", info)
+	}
+	if err := tctx.StopCapture(id); err != nil {
+		t.Fatal(err)
+	}
+	if err := tctx.StopCapture(id); err == nil {
+		t.Fatalf(" ERROR capture %d was already stopped ", id)
+	}
+
+	f, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := pcapgo.NewNgReader(f, pcapgo.DefaultNgReaderOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkts := 0
+	for {
+		_, _, err := r.ReadPacketData()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkts++
+	}
+	intf, _ := r.Interface(0)
+	if pkts != 6 || intf.Name != "vport-3" {
+		t.Fatalf(" ERROR expected 6 packets on vport-3, got %d on %s ", pkts, intf.Name)
+	}
+
+	b, _ := ioutil.ReadFile(output)
+	// rx to broadcast, tx from the client
+	if !bytes.Contains(b, []byte("tun=3,{0000:0000},{0000:0000}\x00")) || !bytes.Contains(b, []byte("tun=3,{0000:0000},{0000:0000} mac=00:00:02:00:00:01")) {
+		t.Fatalf(" ERROR packet comments are missing ")
+	}
+}
+
+func TestCaptureRing(t *testing.T) {
+	dir := t.TempDir()
+	input := dir + "/in.pcap"
+	writePcapInput(t, input, []time.Duration{0, time.Second, 2 * time.Second})
+
+	tctx := NewThreadCtx(0, 4510, true, nil)
+	defer tctx.Delete()
+	var veth VethIFPcap
+	if err := veth.Create(tctx, input, "", 3); err != nil {
+		t.Fatal(err)
+	}
+	tctx.SetZmqVeth(&veth)
+	tctx.parser.RegisterCb("arp", pcapArpEchoCb, []ParserMatch{{EthType: uint16(layers.EthernetTypeARP)}})
+
+	var tun CTunnelData
+	tun.Vport = 3
+	var key CTunnelKey
+	key.Set(&tun)
+	tctx.AddNs(&key, NewNSCtx(tctx, &key))
+
+	if _, err := tctx.StartCaptureRing(&key, nil, "", CAPTURE_RING_MAX_LIMIT+1); err == nil {
+		t.Fatalf(" ERROR limit should be checked ")
+	}
+	other := MACKey{0, 0, 3, 0, 0, 1}
+	otherId, err := tctx.StartCaptureRing(&key, &other, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := MACKey{0, 0, 2, 0, 0, 1}
+	id, err := tctx.StartCaptureRing(&key, &mac, "arp", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tctx.MainLoopSim(5 * time.Second)
+
+	params := fastjson.RawMessage(fmt.Sprintf(`{"id": %d, "clear": true}`, id))
+	res, rerr := ApiCaptureFetchHandler{}.ServeJSONRPC(tctx, &params)
+	if rerr != nil {
+		t.Fatal(rerr.Message)
+	}
+	fetch := res.(*ApiCaptureFetchResult)
+	if fetch.Pkts != 4 || fetch.Dropped != 2 {
+		t.Fatalf(" ERROR expected 4 packets and 2 dropped, got %+v ", fetch)
+	}
+	b, err := base64.StdEncoding.DecodeString(fetch.Pcap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := pcapgo.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkts := 0
+	for {
+		data, _, err := r.ReadPacketData()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data[6:12], mac[:]) {
+			t.Fatalf(" ERROR packet %d is not of the client ", pkts)
+		}
+		pkts++
+	}
+	if pkts != 4 {
+		t.Fatalf(" ERROR expected 4 packets in the pcap, got %d ", pkts)
+	}
+
+	// cleared
+	_, info, err := tctx.FetchCapture(id, false)
+	if err != nil || info.Pkts != 0 {
+		t.Fatalf(" ERROR the ring should be empty ")
+	}
+	_, info, err = tctx.FetchCapture(otherId, false)
+	if err != nil || info.Pkts != 0 {
+		t.Fatalf(" ERROR nothing should match the other client ")
+	}
+	if err := tctx.StopCapture(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := tctx.FetchCapture(id, false); err == nil {
+		t.Fatalf(" ERROR capture %d was stopped ", id)
+	}
+}
+
+func TestCaptureLimits(t *testing.T) {
+	tctx := NewThreadCtx(0, 4510, true, nil)
+	defer tctx.Delete()
+
+	var tun CTunnelData
+	tun.Vport = 3
+	var key CTunnelKey
+	key.Set(&tun)
+	tctx.AddNs(&key, NewNSCtx(tctx, &key))
+
+	// the rings of a thread share a total limit
+	ids := []uint32{}
+	for i := 0; i < CAPTURE_RING_TOTAL_LIMIT/CAPTURE_RING_MAX_LIMIT; i++ {
+		id, err := tctx.StartCaptureRing(&key, nil, "", CAPTURE_RING_MAX_LIMIT)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if _, err := tctx.StartCaptureRing(&key, nil, "", 1); err == nil {
+		t.Fatalf(" ERROR total ring limit should be checked ")
+	}
+	if err := tctx.StopCapture(ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	ids = ids[1:]
+
+	// the number of captures is limited
+	for len(ids) < CAPTURE_MAX {
+		id, err := tctx.StartCaptureRing(&key, nil, "", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if _, err := tctx.StartCaptureRing(&key, nil, "", 1); err == nil {
+		t.Fatalf(" ERROR number of captures should be checked ")
+	}
+
+	// the captures are freed with the namespace
+	if err := tctx.RemoveNs(&key); err != nil {
+		t.Fatal(err)
+	}
+	if len(tctx.capture.captures) != 0 || tctx.capture.ringPkts != 0 {
+		t.Fatalf(" ERROR captures of the namespace should be stopped, %d are active ", len(tctx.capture.captures))
+	}
+	for _, id := range ids {
+		if _, _, err := tctx.FetchCapture(id, false); err == nil {
+			t.Fatalf(" ERROR capture %d was stopped ", id)
+		}
+	}
+}
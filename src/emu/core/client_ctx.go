@@ -82,6 +82,7 @@ type CClient struct {
 	Ipv6       Ipv6Key    // set the self ipv6 by user
 	DgIpv6     Ipv6Key    // default gateway if provided would be in highest priority
 	Dhcpv6     Ipv6Key    // the dhcpv6 ipv6, another ipv6 would be the one that was learned from the router
+	Ipv6IfId   [8]byte    // interface identifier of the link local and slaac, zero for the EUI-64 of the MAC
 
 	Ipv6ForceDGW   bool /* true in case we want to enforce default gateway MAC */
 	Ipv6ForcedgMac MACKey
@@ -89,6 +90,8 @@ type CClient struct {
 	ForceDGW       bool /* true in case we want to enforce default gateway MAC */
 	Ipv4ForcedgMac MACKey
 
+	PPPoE *CClientPPPoE // PPPoE session of the client, nil in case the client isn't over PPPoE
+
 	PluginCtx *PluginCtx
 
 	transport interface{} // pointer to transport, allocated only if needed
@@ -121,6 +124,127 @@ type CClientCmds struct {
 	Clients []CClientCmd `json:"clients" validate:"required"`
 }
 
+/*
+CClientRangeCmd is a template of Count clients, the addresses of client i are the base addresses plus i*step.
+
+	{"count": 1000, "mac": [0, 0, 1, 0, 0, 1], "ipv4": [16, 0, 0, 1], "ipv4_dg": [16, 0, 0, 254],
+	 "ipv6": [32, 1, 13, 184, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0], "ipv6_from_mac": true}
+*/
+type CClientRangeCmd struct {
+	Count    uint32  `json:"count" validate:"required,gte=1,lte=1000000"`
+	Mac      MACKey  `json:"mac" validate:"required"`
+	MacStep  uint32  `json:"mac_step"` // 1 in case it is zero
+	Ipv4     Ipv4Key `json:"ipv4"`     // zero for clients without IPv4
+	Ipv4Step uint32  `json:"ipv4_step"`
+	DgIpv4   Ipv4Key `json:"ipv4_dg"`
+	MTU      uint16  `json:"ipv4_mtu"`
+
+	Ipv6        Ipv6Key `json:"ipv6"`          // zero for clients without IPv6
+	Ipv6Step    uint32  `json:"ipv6_step"`     // added to the low 32 bits
+	Ipv6FromMac bool    `json:"ipv6_from_mac"` // the prefix is the high 64 bits of ipv6 and the interface id is the EUI-64 of the MAC
+	DgIpv6      Ipv6Key `json:"dg_ipv6"`
+
+	Ipv6ForceDGW   bool   `json:"ipv6_force_dg"`
+	Ipv6ForcedgMac MACKey `json:"ipv6_force_mac"`
+	ForceDGW       bool   `json:"ipv4_force_dg"`
+	Ipv4ForcedgMac MACKey `json:"ipv4_force_mac"`
+
+	Plugins *MapJsonPlugs `json:"plugs"` // the plugins of all the clients
+}
+
+func (o *CClientRangeCmd) macStep() uint64 {
+	if o.MacStep == 0 {
+		return 1
+	}
+	return uint64(o.MacStep)
+}
+
+func (o *CClientRangeCmd) ipv4Step() uint64 {
+	if o.Ipv4Step == 0 {
+		return 1
+	}
+	return uint64(o.Ipv4Step)
+}
+
+func (o *CClientRangeCmd) ipv6Step() uint64 {
+	if o.Ipv6Step == 0 {
+		return 1
+	}
+	return uint64(o.Ipv6Step)
+}
+
+// Validate check that the addresses of the last client don't wrap around
+func (o *CClientRangeCmd) Validate() error {
+	last := uint64(o.Count - 1)
+	if o.Mac.Uint64()+last*o.macStep() > 0xffffffffffff {
+		return fmt.Errorf("MAC range of %d clients overflows", o.Count)
+	}
+	if !o.Ipv4.IsZero() && uint64(o.Ipv4.Uint32())+last*o.ipv4Step() > 0xffffffff {
+		return fmt.Errorf("IPv4 range of %d clients overflows", o.Count)
+	}
+	if o.Ipv6FromMac {
+		if o.Ipv6.IsZero() {
+			return fmt.Errorf("ipv6_from_mac requires the ipv6 prefix")
+		}
+	} else if !o.Ipv6.IsZero() && uint64(binary.BigEndian.Uint32(o.Ipv6[12:16]))+last*o.ipv6Step() > 0xffffffff {
+		return fmt.Errorf("IPv6 range of %d clients overflows", o.Count)
+	}
+	return nil
+}
+
+// Get build the command of client i, Validate should be called first
+func (o *CClientRangeCmd) Get(i uint32, cmd *CClientCmd) {
+	*cmd = CClientCmd{
+		DgIpv4:         o.DgIpv4,
+		MTU:            o.MTU,
+		DgIpv6:         o.DgIpv6,
+		Ipv6ForceDGW:   o.Ipv6ForceDGW,
+		Ipv6ForcedgMac: o.Ipv6ForcedgMac,
+		ForceDGW:       o.ForceDGW,
+		Ipv4ForcedgMac: o.Ipv4ForcedgMac,
+		Plugins:        o.Plugins,
+	}
+	cmd.Mac.SetUint64(o.Mac.Uint64() + uint64(i)*o.macStep())
+	if !o.Ipv4.IsZero() {
+		cmd.Ipv4.SetUint32(o.Ipv4.Uint32() + uint32(uint64(i)*o.ipv4Step()))
+	}
+	if o.Ipv6FromMac {
+		copy(cmd.Ipv6[0:8], o.Ipv6[0:8])
+		cmd.Ipv6[8] = cmd.Mac[0] ^ 2
+		cmd.Ipv6[9] = cmd.Mac[1]
+		cmd.Ipv6[10] = cmd.Mac[2]
+		cmd.Ipv6[11] = 0xff
+		cmd.Ipv6[12] = 0xfe
+		cmd.Ipv6[13] = cmd.Mac[3]
+		cmd.Ipv6[14] = cmd.Mac[4]
+		cmd.Ipv6[15] = cmd.Mac[5]
+	} else if !o.Ipv6.IsZero() {
+		cmd.Ipv6 = o.Ipv6
+		binary.BigEndian.PutUint32(cmd.Ipv6[12:16], binary.BigEndian.Uint32(o.Ipv6[12:16])+uint32(uint64(i)*o.ipv6Step()))
+	}
+}
+
+// CClientUpdateCmd is the JSON-RPC information to update a client, the fields that are missing are not changed
+type CClientUpdateCmd struct {
+	Mac    MACKey   `json:"mac" validate:"required"`
+	Ipv4   *Ipv4Key `json:"ipv4"`
+	Maskv4 *Ipv4Key `json:"ipv4_mask"`
+	DgIpv4 *Ipv4Key `json:"ipv4_dg"`
+	MTU    *uint16  `json:"ipv4_mtu"`
+
+	Ipv6   *Ipv6Key `json:"ipv6"`
+	DgIpv6 *Ipv6Key `json:"dg_ipv6"`
+
+	Ipv6ForceDGW   *bool   `json:"ipv6_force_dg"`
+	Ipv6ForcedgMac *MACKey `json:"ipv6_force_mac"`
+	ForceDGW       *bool   `json:"ipv4_force_dg"`
+	Ipv4ForcedgMac *MACKey `json:"ipv4_force_mac"`
+}
+
+type CClientUpdateCmds struct {
+	Clients []CClientUpdateCmd `json:"clients" validate:"required"`
+}
+
 type CClientInfo struct {
 	Mac    MACKey  `json:"mac"`
 	Ipv4   Ipv4Key `json:"ipv4"`
@@ -198,6 +322,7 @@ func (o *CClient) OnRemove() {
 		o.timerw.Stop(&o.timer)
 	}
 	o.PluginCtx.OnRemove()
+	o.SetPPPoE(nil)
 }
 
 // OnEvent serves as a callback for the timer, which every 1 sec verifies if the default gateway
@@ -244,14 +369,7 @@ func (o *CClient) GetIpv6Slaac(l6 *Ipv6Key) bool {
 	}
 	if o.Ipv6Router.PrefixLen == 64 && !o.Ipv6Router.PrefixIpv6.IsZero() {
 		copy(l6[:], o.Ipv6Router.PrefixIpv6[:])
-		l6[8] = o.Mac[0] ^ 0x2
-		l6[9] = o.Mac[1]
-		l6[10] = o.Mac[2]
-		l6[11] = 0xFF
-		l6[12] = 0xFE
-		l6[13] = o.Mac[3]
-		l6[14] = o.Mac[4]
-		l6[15] = o.Mac[5]
+		o.getIpv6IfId(l6)
 		return true
 	}
 	return false
@@ -266,6 +384,16 @@ func (o *CClient) GetIpv6LocalLink(l6 *Ipv6Key) {
 	l6[5] = 0
 	l6[6] = 0
 	l6[7] = 0
+	o.getIpv6IfId(l6)
+}
+
+// getIpv6IfId sets the interface identifier of the client in the low 64 bits of l6
+func (o *CClient) getIpv6IfId(l6 *Ipv6Key) {
+	var zero [8]byte
+	if o.Ipv6IfId != zero {
+		copy(l6[8:], o.Ipv6IfId[:])
+		return
+	}
 	l6[8] = o.Mac[0] ^ 0x2
 	l6[9] = o.Mac[1]
 	l6[10] = o.Mac[2]
@@ -339,6 +467,14 @@ func (o *CClient) UpdateIPv4(NewIpv4 Ipv4Key) error {
 	return o.Ns.UpdateClientIpv4(o, NewIpv4)
 }
 
+// UpdateDgIPv6 update the ipv6 default gateway
+func (o *CClient) UpdateDgIPv6(NewDgIpv6 Ipv6Key) error {
+	old := o.DgIpv6
+	o.DgIpv6 = NewDgIpv6
+	o.PluginCtx.BroadcastMsg(nil, MSG_UPDATE_DGIPV6_ADDR, old, NewDgIpv6)
+	return nil
+}
+
 // UpdateIPv6 update static  ipv6
 func (o *CClient) UpdateIPv6(NewIpv6 Ipv6Key) error {
 	return o.Ns.UpdateClientIpv6(o, NewIpv6)
@@ -349,6 +485,91 @@ func (o *CClient) UpdateDIPv6(NewIpv6 Ipv6Key) error {
 	return o.Ns.UpdateClientDIpv6(o, NewIpv6)
 }
 
+/*
+Update change the addresses of the client, the fields that are nil are not changed. The plugins get the update_*
+message of each address that was changed and then MSG_UPDATE_CLIENT, so they could react to the move (e.g. gratuitous
+ARP). The default gateway is resolved again in case an address or the gateway was changed.
+*/
+func (o *CClient) Update(cmd *CClientUpdateCmd) error {
+	// check the conflicts first, a failure should not leave a partial update
+	if cmd.Ipv4 != nil && *cmd.Ipv4 != o.Ipv4 && !cmd.Ipv4.IsZero() && o.Ns.CLookupByIPv4(cmd.Ipv4) != nil {
+		return fmt.Errorf(" client with the same IPv4 %v already exist", *cmd.Ipv4)
+	}
+	if cmd.Ipv6 != nil && *cmd.Ipv6 != o.Ipv6 && !cmd.Ipv6.IsZero() && o.Ns.CLookupByIPv6(cmd.Ipv6) != nil {
+		return fmt.Errorf(" client with the same IPv6 %v already exist", *cmd.Ipv6)
+	}
+
+	ev := CClientUpdate{OldIpv4: o.Ipv4, OldIpv6: o.Ipv6}
+	if cmd.Ipv4 != nil && *cmd.Ipv4 != o.Ipv4 {
+		if err := o.UpdateIPv4(*cmd.Ipv4); err != nil {
+			return err
+		}
+		ev.Changed |= CLIENT_UPDATE_IPV4
+	}
+	if cmd.Maskv4 != nil && *cmd.Maskv4 != o.Maskv4 {
+		o.Maskv4 = *cmd.Maskv4
+		ev.Changed |= CLIENT_UPDATE_MASKV4
+	}
+	if cmd.DgIpv4 != nil && *cmd.DgIpv4 != o.DgIpv4 {
+		o.UpdateDgIPv4(*cmd.DgIpv4)
+		ev.Changed |= CLIENT_UPDATE_DGIPV4
+	}
+	if cmd.MTU != nil && *cmd.MTU != o.MTU {
+		o.MTU = *cmd.MTU
+		ev.Changed |= CLIENT_UPDATE_MTU
+	}
+	if cmd.Ipv6 != nil && *cmd.Ipv6 != o.Ipv6 {
+		if err := o.UpdateIPv6(*cmd.Ipv6); err != nil {
+			return err
+		}
+		ev.Changed |= CLIENT_UPDATE_IPV6
+	}
+	if cmd.DgIpv6 != nil && *cmd.DgIpv6 != o.DgIpv6 {
+		o.UpdateDgIPv6(*cmd.DgIpv6)
+		ev.Changed |= CLIENT_UPDATE_DGIPV6
+	}
+	if cmd.ForceDGW != nil && *cmd.ForceDGW != o.ForceDGW {
+		o.ForceDGW = *cmd.ForceDGW
+		ev.Changed |= CLIENT_UPDATE_FORCE_DG
+	}
+	if cmd.Ipv4ForcedgMac != nil && *cmd.Ipv4ForcedgMac != o.Ipv4ForcedgMac {
+		o.Ipv4ForcedgMac = *cmd.Ipv4ForcedgMac
+		ev.Changed |= CLIENT_UPDATE_FORCE_DG
+	}
+	if cmd.Ipv6ForceDGW != nil && *cmd.Ipv6ForceDGW != o.Ipv6ForceDGW {
+		o.Ipv6ForceDGW = *cmd.Ipv6ForceDGW
+		ev.Changed |= CLIENT_UPDATE_FORCE_DG
+	}
+	if cmd.Ipv6ForcedgMac != nil && *cmd.Ipv6ForcedgMac != o.Ipv6ForcedgMac {
+		o.Ipv6ForcedgMac = *cmd.Ipv6ForcedgMac
+		ev.Changed |= CLIENT_UPDATE_FORCE_DG
+	}
+
+	if ev.Changed == 0 {
+		return nil
+	}
+	o.Ns.stats.updateClient++
+	o.PluginCtx.BroadcastMsg(nil, MSG_UPDATE_CLIENT, &ev, nil)
+	if ev.Changed&(CLIENT_UPDATE_IPV4|CLIENT_UPDATE_DGIPV4|CLIENT_UPDATE_IPV6|CLIENT_UPDATE_DGIPV6|CLIENT_UPDATE_FORCE_DG) != 0 {
+		o.restartResolve()
+	}
+	return nil
+}
+
+// restartResolve resolve the default gateway again, MSG_DG_MAC_RESOLVED is sent when it is resolved
+func (o *CClient) restartResolve() {
+	if o.timerw == nil {
+		// the client never started to resolve
+		return
+	}
+	if o.timer.IsRunning() {
+		o.timerw.Stop(&o.timer)
+	}
+	o.bitMask = 0
+	o.resolveAttempts = 0
+	o.OnEvent(0, 0)
+}
+
 // GetL2Header get L2 header
 func (o *CClient) GetL2Header(broadcast bool, next uint16) []byte {
 	var tund CTunnelData
@@ -428,7 +649,9 @@ func (o *CClient) GetInfo() *CClientInfo {
 }
 
 func (o *CClient) ResolveIPv4DGMac() (mac MACKey, ok bool) {
-	if o.ForceDGW {
+	if o.PPPoE != nil {
+		mac, ok = o.PPPoE.ServerMac, true
+	} else if o.ForceDGW {
 		mac, ok = o.Ipv4ForcedgMac, true
 	} else if o.DGW != nil && o.DGW.IpdgResolved {
 		mac, ok = o.DGW.IpdgMac, true
@@ -437,7 +660,9 @@ func (o *CClient) ResolveIPv4DGMac() (mac MACKey, ok bool) {
 }
 
 func (o *CClient) ResolveIPv6DGMac() (mac MACKey, ok bool) {
-	if o.Ipv6ForceDGW {
+	if o.PPPoE != nil {
+		mac, ok = o.PPPoE.ServerMac, true
+	} else if o.Ipv6ForceDGW {
 		mac, ok = o.Ipv6ForcedgMac, true
 	} else if o.Ipv6DGW != nil && o.Ipv6DGW.IpdgResolved {
 		mac, ok = o.Ipv6DGW.IpdgMac, true
@@ -0,0 +1,228 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"encoding/binary"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"net"
+	"testing"
+
+	"github.com/intel-go/fastjson"
+)
+
+func clientRangeAdd(t *testing.T, tctx *CThreadCtx, params string) (*ApiClientAddRangeResult, *string) {
+	raw := fastjson.RawMessage(`{"tun": {"vport": 1}, "range": ` + params + `}`)
+	res, err := ApiClientAddHandler{}.ServeJSONRPC(tctx, &raw)
+	if err != nil {
+		return nil, &err.Message
+	}
+	return res.(*ApiClientAddRangeResult), nil
+}
+
+func TestClientAddRange(t *testing.T) {
+	tctx := NewThreadCtx(0, 4510, true, nil)
+	defer tctx.Delete()
+	var key CTunnelKey
+	key.SetJson(&CTunnelDataJson{Vport: 1})
+	ns := NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+
+	// the 6th client conflicts with this one
+	ns.AddClient(NewClient(ns, MACKey{0, 0, 2, 0, 0, 1}, Ipv4Key{16, 0, 0, 6}, Ipv6Key{}, Ipv4Key{}))
+
+	res, msg := clientRangeAdd(t, tctx, `{"count": 100, "mac": [0, 0, 1, 0, 0, 1], "ipv4": [16, 0, 0, 1], "ipv4_dg": [16, 0, 0, 254],
+		"ipv6": [32, 1, 13, 184, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0], "ipv6_from_mac": true}`)
+	if msg != nil {
+		t.Fatal(*msg)
+	}
+	if res.Created != 99 || res.Failed != 1 || len(res.Errors) != 1 || res.Errors[0].Mac != (MACKey{0, 0, 1, 0, 0, 6}) {
+		t.Fatalf(" ERROR unexpected result %+v ", res)
+	}
+	c := ns.CLookupByMac(&MACKey{0, 0, 1, 0, 0, 0x64})
+	if c == nil || c.Ipv4 != (Ipv4Key{16, 0, 0, 100}) || c.DgIpv4 != (Ipv4Key{16, 0, 0, 254}) {
+		t.Fatalf(" ERROR the last client is missing or has a wrong IPv4 ")
+	}
+	if !c.Ipv6.ToIP().Equal(net.ParseIP("2001:db8::200:1ff:fe00:64")) {
+		t.Fatalf(" ERROR unexpected IPv6 %v ", c.Ipv6.ToIP())
+	}
+
+	// steps
+	res, msg = clientRangeAdd(t, tctx, `{"count": 3, "mac": [0, 0, 3, 0, 0, 0], "mac_step": 256,
+		"ipv6": [32, 1, 13, 184, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1], "ipv6_step": 16}`)
+	if msg != nil || res.Created != 3 {
+		t.Fatalf(" ERROR range with steps failed ")
+	}
+	c = ns.CLookupByMac(&MACKey{0, 0, 3, 0, 2, 0})
+	if c == nil || !c.Ipv6.ToIP().Equal(net.ParseIP("2001:db8::21")) || !c.Ipv4.IsZero() {
+		t.Fatalf(" ERROR unexpected client with steps ")
+	}
+
+	// a client that fails on the plugins is not added
+	res, msg = clientRangeAdd(t, tctx, `{"count": 2, "mac": [0, 0, 4, 0, 0, 1], "plugs": {"no_such_plugin": {}}}`)
+	if msg != nil || res.Created != 0 || res.Failed != 2 || ns.CLookupByMac(&MACKey{0, 0, 4, 0, 0, 1}) != nil {
+		t.Fatalf(" ERROR clients with invalid plugins should not be added %+v ", res)
+	}
+
+	for _, bad := range []string{
+		`{"count": 2, "mac": [255, 255, 255, 255, 255, 255]}`,
+		`{"count": 2, "mac": [0, 0, 5, 0, 0, 1], "ipv4": [255, 255, 255, 255]}`,
+		`{"count": 2, "mac": [0, 0, 5, 0, 0, 1], "ipv6_from_mac": true}`,
+		`{"count": 0, "mac": [0, 0, 5, 0, 0, 1]}`,
+	} {
+		if _, msg = clientRangeAdd(t, tctx, bad); msg == nil {
+			t.Fatalf(" ERROR range %s should fail ", bad)
+		}
+	}
+}
+
+func clientUpdate(tctx *CThreadCtx, clients string) *string {
+	raw := fastjson.RawMessage(`{"tun": {"vport": 1}, "clients": ` + clients + `}`)
+	_, err := ApiClientUpdateHandler{}.ServeJSONRPC(tctx, &raw)
+	if err != nil {
+		return &err.Message
+	}
+	return nil
+}
+
+func TestClientUpdate(t *testing.T) {
+	tctx := NewThreadCtx(0, 4510, true, nil)
+	defer tctx.Delete()
+	var key CTunnelKey
+	key.SetJson(&CTunnelDataJson{Vport: 1})
+	ns := NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+
+	c := NewClient(ns, MACKey{0, 0, 1, 0, 0, 1}, Ipv4Key{16, 0, 0, 1}, Ipv6Key{}, Ipv4Key{16, 0, 0, 254})
+	ns.AddClient(c)
+	ns.AddClient(NewClient(ns, MACKey{0, 0, 1, 0, 0, 2}, Ipv4Key{16, 0, 0, 2}, Ipv6Key{}, Ipv4Key{16, 0, 0, 254}))
+
+	msg := clientUpdate(tctx, `[{"mac": [0, 0, 1, 0, 0, 1], "ipv4": [16, 0, 1, 1], "ipv4_mask": [255, 255, 0, 0],
+		"ipv4_dg": [16, 0, 1, 254], "ipv4_mtu": 9000, "ipv6": [32, 1, 13, 184, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1],
+		"ipv4_force_dg": true, "ipv4_force_mac": [0, 0, 2, 0, 0, 1]}]`)
+	if msg != nil {
+		t.Fatal(*msg)
+	}
+	if c.Ipv4 != (Ipv4Key{16, 0, 1, 1}) || c.Maskv4 != (Ipv4Key{255, 255, 0, 0}) || c.DgIpv4 != (Ipv4Key{16, 0, 1, 254}) ||
+		c.MTU != 9000 || !c.ForceDGW || c.Ipv4ForcedgMac != (MACKey{0, 0, 2, 0, 0, 1}) {
+		t.Fatalf(" ERROR the client was not updated %+v ", c)
+	}
+	if ns.CLookupByIPv4(&Ipv4Key{16, 0, 1, 1}) != c || ns.CLookupByIPv4(&Ipv4Key{16, 0, 0, 1}) != nil {
+		t.Fatalf(" ERROR the IPv4 lookup was not updated ")
+	}
+	if ns.CLookupByIPv6(&Ipv6Key{32, 1, 13, 184, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}) != c {
+		t.Fatalf(" ERROR the IPv6 lookup was not updated ")
+	}
+	if ns.stats.updateClient != 1 {
+		t.Fatalf(" ERROR expected one update, got %d ", ns.stats.updateClient)
+	}
+
+	// a conflict does not leave a partial update
+	msg = clientUpdate(tctx, `[{"mac": [0, 0, 1, 0, 0, 1], "ipv4_mtu": 1500, "ipv4": [16, 0, 0, 2]}]`)
+	if msg == nil || c.MTU != 9000 || c.Ipv4 != (Ipv4Key{16, 0, 1, 1}) {
+		t.Fatalf(" ERROR an update with a conflict should fail ")
+	}
+
+	// nothing changed
+	if msg = clientUpdate(tctx, `[{"mac": [0, 0, 1, 0, 0, 1], "ipv4_mtu": 9000}]`); msg != nil || ns.stats.updateClient != 1 {
+		t.Fatalf(" ERROR an update without a change should not be counted ")
+	}
+
+	if msg = clientUpdate(tctx, `[{"mac": [0, 0, 1, 0, 0, 9], "ipv4_mtu": 1500}]`); msg == nil {
+		t.Fatalf(" ERROR an update of an unknown client should fail ")
+	}
+}
+
+func TestClientPPPoE(t *testing.T) {
+	tctx := NewThreadCtx(0, 4510, true, nil)
+	defer tctx.Delete()
+	var key CTunnelKey
+	key.SetJson(&CTunnelDataJson{Vport: 1})
+	ns := NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+
+	c := NewClient(ns, MACKey{0, 0, 1, 0, 0, 1}, Ipv4Key{16, 0, 0, 1}, Ipv6Key{}, Ipv4Key{})
+	ns.AddClient(c)
+
+	// the interface identifier that was negotiated by IPv6CP replaces the EUI-64
+	ifId := [8]byte{0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88}
+	if err := ns.UpdateClientIpv6IfId(c, ifId); err != nil {
+		t.Fatal(err)
+	}
+	l6 := Ipv6Key{0xfe, 0x80, 0, 0, 0, 0, 0, 0, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88}
+	var have Ipv6Key
+	c.GetIpv6LocalLink(&have)
+	if have != l6 {
+		t.Fatalf(" ERROR bad link local %v ", have.ToIP())
+	}
+	if ns.CLookupByIPv6LocalGlobal(&l6) != c {
+		t.Fatalf(" ERROR the link local lookup was not updated ")
+	}
+
+	// IPv4/IPv6 of the client are encapsulated in the session
+	c.SetPPPoE(&CClientPPPoE{SessionId: 0x40, ServerMac: MACKey{0, 0, 2, 0, 0, 1}})
+	if mac, ok := c.ResolveIPv6DGMac(); !ok || mac != c.PPPoE.ServerMac {
+		t.Fatalf(" ERROR the DG of a PPPoE client is the server ")
+	}
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	gopacket.SerializeLayers(buf, opts,
+		&layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0, 0, 1, 0, 0, 1},
+			DstMAC:       net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			EthernetType: layers.EthernetTypeIPv4,
+		},
+		&layers.IPv4{Version: 4, IHL: 5, TTL: 128, SrcIP: net.IPv4(16, 0, 0, 1), DstIP: net.IPv4(48, 0, 0, 1),
+			Protocol: layers.IPProtocolICMPv4},
+		&layers.ICMPv4{TypeCode: layers.ICMPv4TypeEchoRequest, Id: 1, Seq: 0x11},
+		gopacket.Payload([]byte{1, 2, 3, 4}),
+	)
+	m := tctx.MPool.Alloc(128)
+	m.SetVPort(1)
+	m.Append(buf.Bytes())
+	m = tctx.encapPPPoE(m)
+	p := m.GetData()
+	if len(p) != len(buf.Bytes())+PPPOE_SESSION_HDR_SIZE || net.HardwareAddr(p[0:6]).String() != "00:00:02:00:00:01" ||
+		binary.BigEndian.Uint16(p[12:14]) != uint16(layers.EthernetTypePPPoESession) ||
+		binary.BigEndian.Uint16(p[16:18]) != 0x40 || int(binary.BigEndian.Uint16(p[18:20])) != len(p)-20 ||
+		binary.BigEndian.Uint16(p[20:22]) != uint16(layers.PPPTypeIPv4) {
+		t.Fatalf(" ERROR bad PPPoE encapsulation %v ", p)
+	}
+
+	// the same MAC on another tunnel is not attached to the session
+	var key2 CTunnelKey
+	key2.SetJson(&CTunnelDataJson{Vport: 1, Tci: [2]uint16{100, 0}})
+	ns2 := NewNSCtx(tctx, &key2)
+	tctx.AddNs(&key2, ns2)
+	c2 := NewClient(ns2, c.Mac, Ipv4Key{16, 0, 0, 1}, Ipv6Key{}, Ipv4Key{})
+	ns2.AddClient(c2)
+	m2 := tctx.MPool.Alloc(128)
+	m2.SetVPort(1)
+	m2.Append(buf.Bytes()[:12])
+	m2.Append([]byte{0x81, 0x00, 0x00, 100})
+	m2.Append(buf.Bytes()[12:])
+	if tctx.encapPPPoE(m2) != m2 {
+		t.Fatalf(" ERROR a client of another tunnel was encapsulated ")
+	}
+	m2.FreeMbuf()
+
+	// the session frame is parsed as IPv4
+	var parser Parser
+	parser.Init(tctx)
+	parser.RegisterCb("icmp", arpSupported, []ParserMatch{{IPProto: uint8(layers.IPProtocolICMPv4)}})
+	arp = 0
+	parser.ParsePacket(m)
+	if arp != 1 || lastL3 != 22 {
+		t.Fatalf(" ERROR the session frame was not parsed as IPv4, L3 %d ", lastL3)
+	}
+	m.FreeMbuf()
+
+	c.SetPPPoE(nil)
+	if len(tctx.pppoeClients) != 0 {
+		t.Fatalf(" ERROR the client was not detached ")
+	}
+}
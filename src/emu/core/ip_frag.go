@@ -0,0 +1,134 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"encoding/binary"
+	"external/google/gopacket/layers"
+)
+
+/*
+IP fragmentation on transmit
+
+The datagram is given as a template header (L2 + IP header) and the IP payload (L4 header + data) with a valid L4 checksum.
+Each fragment is built in a new mbuf from the namespace pool, so the datagram itself can be bigger than MAX_PACKET_SIZE.
+
+1. IPv4 fragments copy the header and update the total length, fragment offset, MF flag and checksum. IPv4 options are not supported
+2. IPv6 fragments get a Fragment extension header after the fixed IPv6 header. Extension headers in the template are not supported
+3. The caller provides the identification of the datagram
+*/
+
+const (
+	IPV4_MAX_PAYLOAD       = 0xffff - 20 // max IPv4 payload (L4 header + data)
+	IPV6_MAX_PAYLOAD       = 0xffff - 8  // max IPv6 payload of a fragmented datagram (L4 header + data)
+	IPV6_FRAG_HEADER_SIZE  = 8
+	ipv4FlagMoreFragments  = 0x2000
+	ipv6FlagMoreFragments  = 0x1
+	ipFragOffsetAlignBytes = 8
+)
+
+// IPv4FragmentCount return the number of fragments of an IPv4 payload for an L3 mtu, zero in case it can't be fragmented
+func IPv4FragmentCount(payloadLen uint32, mtu uint16) uint32 {
+	if mtu < 20+ipFragOffsetAlignBytes || payloadLen > IPV4_MAX_PAYLOAD {
+		return 0
+	}
+	size := uint32(mtu-20) &^ (ipFragOffsetAlignBytes - 1)
+	return (payloadLen + size - 1) / size
+}
+
+// IPv6FragmentCount return the number of fragments of an IPv6 payload for an L3 mtu, zero in case it can't be fragmented
+func IPv6FragmentCount(payloadLen uint32, mtu uint16) uint32 {
+	if mtu < IPV6_HEADER_SIZE+IPV6_FRAG_HEADER_SIZE+ipFragOffsetAlignBytes || payloadLen > IPV6_MAX_PAYLOAD {
+		return 0
+	}
+	size := uint32(mtu-IPV6_HEADER_SIZE-IPV6_FRAG_HEADER_SIZE) &^ (ipFragOffsetAlignBytes - 1)
+	return (payloadLen + size - 1) / size
+}
+
+/*
+IPv4Fragment build the fragments of an IPv4 datagram
+
+	hdr     - L2 + IPv4 header of the datagram, the IPv4 header starts at l3
+	payload - the IPv4 payload
+	mtu     - L3 mtu
+	id      - the identification of the datagram
+
+return the fragments in order, the caller should send them. nil in case the datagram can't be fragmented (DF is set, mtu is too small)
+*/
+func IPv4Fragment(ns *CNSCtx, hdr []byte, l3 uint16, payload []byte, mtu uint16, id uint16) []*Mbuf {
+	if IPv4FragmentCount(uint32(len(payload)), mtu) == 0 {
+		return nil
+	}
+	ipv4 := layers.IPv4Header(hdr[l3 : l3+20])
+	if ipv4.GetHeaderLen() != 20 || (binary.BigEndian.Uint16(hdr[l3+6:l3+8])&0x4000) != 0 {
+		return nil
+	}
+	size := int(mtu-20) &^ (ipFragOffsetAlignBytes - 1)
+	hlen := int(l3) + 20
+	var frags []*Mbuf
+	for off := 0; off < len(payload); off += size {
+		end := off + size
+		flags := uint16(ipv4FlagMoreFragments)
+		if end >= len(payload) {
+			end = len(payload)
+			flags = 0
+		}
+		m := ns.AllocMbuf(uint16(hlen + end - off))
+		m.Append(hdr[:hlen])
+		m.Append(payload[off:end])
+		p := m.GetData()
+		fipv4 := layers.IPv4Header(p[l3 : l3+20])
+		fipv4.SetLength(uint16(20 + end - off))
+		binary.BigEndian.PutUint16(p[l3+4:l3+6], id)
+		binary.BigEndian.PutUint16(p[l3+6:l3+8], flags|uint16(off/ipFragOffsetAlignBytes))
+		fipv4.UpdateChecksum()
+		frags = append(frags, m)
+	}
+	return frags
+}
+
+/*
+IPv6Fragment build the fragments of an IPv6 datagram
+
+	hdr     - L2 + IPv6 header of the datagram, the IPv6 header starts at l3
+	payload - the IPv6 payload, the next header in hdr describes it
+	mtu     - L3 mtu
+	id      - the identification of the datagram
+
+return the fragments in order, the caller should send them. nil in case the datagram can't be fragmented
+*/
+func IPv6Fragment(ns *CNSCtx, hdr []byte, l3 uint16, payload []byte, mtu uint16, id uint32) []*Mbuf {
+	if IPv6FragmentCount(uint32(len(payload)), mtu) == 0 {
+		return nil
+	}
+	ipv6 := layers.IPv6Header(hdr[l3 : l3+IPV6_HEADER_SIZE])
+	nh := ipv6.NextHeader()
+	size := int(mtu-IPV6_HEADER_SIZE-IPV6_FRAG_HEADER_SIZE) &^ (ipFragOffsetAlignBytes - 1)
+	hlen := int(l3) + IPV6_HEADER_SIZE
+	var fh [IPV6_FRAG_HEADER_SIZE]byte
+	fh[0] = nh
+	binary.BigEndian.PutUint32(fh[4:8], id)
+	var frags []*Mbuf
+	for off := 0; off < len(payload); off += size {
+		end := off + size
+		flags := uint16(ipv6FlagMoreFragments)
+		if end >= len(payload) {
+			end = len(payload)
+			flags = 0
+		}
+		binary.BigEndian.PutUint16(fh[2:4], uint16(off)|flags)
+		m := ns.AllocMbuf(uint16(hlen + IPV6_FRAG_HEADER_SIZE + end - off))
+		m.Append(hdr[:hlen])
+		m.Append(fh[:])
+		m.Append(payload[off:end])
+		p := m.GetData()
+		fipv6 := layers.IPv6Header(p[l3 : l3+IPV6_HEADER_SIZE])
+		fipv6.SetNextHeader(IPV6_EXT_Fragment)
+		fipv6.SetPyloadLength(uint16(IPV6_FRAG_HEADER_SIZE + end - off))
+		frags = append(frags, m)
+	}
+	return frags
+}
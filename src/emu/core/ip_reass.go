@@ -0,0 +1,409 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"encoding/binary"
+	"external/google/gopacket/layers"
+	"time"
+	"unsafe"
+)
+
+/*
+IP reassembly per namespace
+
+Fragments of IPv4 and IPv6 datagrams are kept per namespace until the datagram is complete.
+The reassembled packet is rebuilt as an unfragmented packet (L2 header, IP header without fragmentation
+and the full payload) and handed back to the parser, so the L4 callbacks see it as a regular packet.
+
+1. The memory is bounded per namespace, both in datagrams and in bytes. The oldest datagram is dropped in case of overflow
+2. Each datagram has a timer, on timeout the fragments are freed
+3. Overlapping fragments drop the datagram (RFC 5722)
+4. The reassembled packet can't be bigger than MAX_PACKET_SIZE
+*/
+
+const (
+	IP_REASS_TIMEOUT       = 30 * time.Second // datagram reassembly timeout
+	IP_REASS_MAX_DATAGRAMS = 64               // max datagrams in reassembly per namespace
+	IP_REASS_MAX_BYTES     = 256 * 1024       // max fragment bytes held per namespace
+)
+
+type IpReassStats struct {
+	ipv4Frags      uint64
+	ipv6Frags      uint64
+	reassOk        uint64
+	reassTimeout   uint64
+	reassOverlap   uint64
+	reassNoMem     uint64
+	reassTooBig    uint64
+	reassInvalid   uint64
+	reassDatagrams uint64 // datagrams in reassembly
+}
+
+func newIpReassStatsDb(o *IpReassStats) *CCounterDb {
+	db := NewCCounterDb("ipreass")
+
+	db.Add(&CCounterRec{
+		Counter:  &o.ipv4Frags,
+		Name:     "ipv4Frags",
+		Help:     "ipv4 fragments received",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.ipv6Frags,
+		Name:     "ipv6Frags",
+		Help:     "ipv6 fragments received",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.reassOk,
+		Name:     "reassOk",
+		Help:     "datagrams reassembled",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.reassDatagrams,
+		Name:     "reassDatagrams",
+		Help:     "datagrams waiting for fragments",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.reassTimeout,
+		Name:     "reassTimeout",
+		Help:     "datagrams dropped on reassembly timeout",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.reassOverlap,
+		Name:     "reassOverlap",
+		Help:     "datagrams dropped due to overlapping fragments",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.reassNoMem,
+		Name:     "reassNoMem",
+		Help:     "datagrams dropped, reassembly memory is full",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.reassTooBig,
+		Name:     "reassTooBig",
+		Help:     "datagrams dropped, reassembled packet is too big",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.reassInvalid,
+		Name:     "reassInvalid",
+		Help:     "invalid fragments",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	return db
+}
+
+type ipReassKey struct {
+	src   Ipv6Key // ipv4 uses the first 4 bytes
+	dst   Ipv6Key
+	id    uint32
+	proto uint8 // ipv4 protocol, zero for ipv6
+	ipv6  bool
+}
+
+type ipFragment struct {
+	offset uint16
+	end    uint16
+	m      *Mbuf // fragment payload
+}
+
+type ipReassDatagram struct {
+	dlist  DList // must be first, list of datagrams by age
+	key    ipReassKey
+	timer  CHTimerObj
+	hdr    []byte // L2 + unfragmentable IP header, taken from the first fragment
+	nhOff  uint16 // ipv6 offset of the next header field to fix, relative to hdr
+	nh     uint8  // ipv6 next header of the fragmentable part
+	total  uint16 // payload size, known when the last fragment arrives
+	last   bool
+	bytes  uint32
+	frags  []ipFragment
+	vport  uint16
+	parent *CIpReass
+}
+
+func castDlistIpReassDatagram(dlist *DList) *ipReassDatagram {
+	return (*ipReassDatagram)(unsafe.Pointer(dlist))
+}
+
+// OnEvent reassembly timeout
+func (o *ipReassDatagram) OnEvent(a, b interface{}) {
+	o.parent.stats.reassTimeout++
+	o.parent.remove(o)
+}
+
+func (o *ipReassDatagram) freeFrags() {
+	for i := range o.frags {
+		o.frags[i].m.FreeMbuf()
+	}
+	o.frags = nil
+}
+
+func (o *ipReassDatagram) isComplete() bool {
+	if !o.last || o.hdr == nil {
+		return false
+	}
+	return o.bytes == uint32(o.total)
+}
+
+// add the fragment in order, return false in case of overlap
+func (o *ipReassDatagram) add(frag ipFragment) bool {
+	i := 0
+	for ; i < len(o.frags); i++ {
+		if frag.offset < o.frags[i].offset {
+			break
+		}
+	}
+	if i > 0 && o.frags[i-1].end > frag.offset {
+		return false
+	}
+	if i < len(o.frags) && frag.end > o.frags[i].offset {
+		return false
+	}
+	o.frags = append(o.frags, ipFragment{})
+	copy(o.frags[i+1:], o.frags[i:])
+	o.frags[i] = frag
+	o.bytes += uint32(frag.end - frag.offset)
+	return true
+}
+
+// CIpReass reassembly buffer of a namespace
+type CIpReass struct {
+	tctx      *CThreadCtx
+	stats     *IpReassStats
+	m         map[ipReassKey]*ipReassDatagram
+	head      DList // oldest first
+	bytes     uint32
+	timeout   time.Duration
+	maxDgrams uint32
+	maxBytes  uint32
+}
+
+func NewIpReass(tctx *CThreadCtx, stats *IpReassStats) *CIpReass {
+	o := new(CIpReass)
+	o.tctx = tctx
+	o.stats = stats
+	o.m = make(map[ipReassKey]*ipReassDatagram)
+	o.head.SetSelf()
+	o.timeout = IP_REASS_TIMEOUT
+	o.maxDgrams = IP_REASS_MAX_DATAGRAMS
+	o.maxBytes = IP_REASS_MAX_BYTES
+	return o
+}
+
+// OnRemove free all the datagrams, called when the namespace is removed
+func (o *CIpReass) OnRemove() {
+	for !o.head.IsEmpty() {
+		o.remove(castDlistIpReassDatagram(o.head.Next()))
+	}
+}
+
+func (o *CIpReass) remove(d *ipReassDatagram) {
+	timerw := o.tctx.GetTimerCtx()
+	if d.timer.IsRunning() {
+		timerw.Stop(&d.timer)
+	}
+	o.head.RemoveNode(&d.dlist)
+	o.bytes -= d.bytes
+	d.freeFrags()
+	delete(o.m, d.key)
+	o.stats.reassDatagrams--
+}
+
+func (o *CIpReass) lookupOrCreate(key *ipReassKey, vport uint16) *ipReassDatagram {
+	d, ok := o.m[*key]
+	if ok {
+		return d
+	}
+	if uint32(len(o.m)) >= o.maxDgrams {
+		o.stats.reassNoMem++
+		o.remove(castDlistIpReassDatagram(o.head.Next()))
+	}
+	d = new(ipReassDatagram)
+	d.key = *key
+	d.vport = vport
+	d.parent = o
+	d.dlist.SetSelf()
+	d.timer.SetCB(d, nil, nil)
+	o.head.AddLast(&d.dlist)
+	o.m[*key] = d
+	o.stats.reassDatagrams++
+	o.tctx.GetTimerCtx().Start(&d.timer, o.timeout)
+	return d
+}
+
+/*
+addFragment add one fragment to the datagram
+
+	hdr     - L2 + unfragmentable header, valid only for the first fragment
+	payload - the fragment payload
+
+return the reassembled packet in case it is complete, the caller should free it
+*/
+func (o *CIpReass) addFragment(d *ipReassDatagram, offset uint16, more bool, hdr []byte, payload []byte) *Mbuf {
+	end := uint32(offset) + uint32(len(payload))
+	invalid := end > 0xffff || (more && (len(payload)&7) != 0)
+	if d.last {
+		invalid = invalid || end > uint32(d.total) || (!more && end != uint32(d.total))
+	} else if !more && len(d.frags) > 0 {
+		invalid = invalid || uint32(d.frags[len(d.frags)-1].end) > end
+	}
+	if invalid {
+		o.stats.reassInvalid++
+		o.remove(d)
+		return nil
+	}
+
+	for o.bytes+uint32(len(payload)) > o.maxBytes {
+		old := castDlistIpReassDatagram(o.head.Next())
+		o.stats.reassNoMem++
+		o.remove(old)
+		if old == d {
+			return nil
+		}
+	}
+
+	m := o.tctx.MPool.Alloc(uint16(len(payload)))
+	m.Append(payload)
+	if !d.add(ipFragment{offset: offset, end: uint16(end), m: m}) {
+		m.FreeMbuf()
+		o.stats.reassOverlap++
+		o.remove(d)
+		return nil
+	}
+	o.bytes += uint32(len(payload))
+
+	if offset == 0 {
+		d.hdr = append([]byte(nil), hdr...)
+	}
+	if !more {
+		d.last = true
+		d.total = uint16(end)
+	}
+
+	if !d.isComplete() {
+		return nil
+	}
+
+	if uint32(len(d.hdr))+uint32(d.total) > uint32(MAX_PACKET_SIZE) {
+		o.stats.reassTooBig++
+		o.remove(d)
+		return nil
+	}
+
+	// build the chain of the unfragmentable header and the fragments, the parser requires contiguous packet
+	head := o.tctx.MPool.Alloc(uint16(len(d.hdr)))
+	head.Append(d.hdr)
+	for i := range d.frags {
+		head.AppendMbuf(d.frags[i].m)
+	}
+	d.frags = nil
+	r := head.GetContiguous(&o.tctx.MPool)
+	head.FreeMbuf()
+	r.SetVPort(d.vport)
+	o.remove(d)
+	o.stats.reassOk++
+	return r
+}
+
+/*
+AddIPv4 add an IPv4 fragment, l3 is the offset of the IPv4 header in the packet.
+Return the reassembled packet in case the datagram is complete
+*/
+func (o *CIpReass) AddIPv4(m *Mbuf, l3 uint16) *Mbuf {
+	p := m.GetData()
+	ipv4 := layers.IPv4Header(p[l3:])
+	hl := ipv4.GetHeaderLen()
+	frag := binary.BigEndian.Uint16(p[l3+6 : l3+8])
+	offset := (frag & 0x1fff) << 3
+	more := (frag & 0x2000) == 0x2000
+
+	o.stats.ipv4Frags++
+	var key ipReassKey
+	copy(key.src[:], p[l3+12:l3+16])
+	copy(key.dst[:], p[l3+16:l3+20])
+	key.id = uint32(binary.BigEndian.Uint16(p[l3+4 : l3+6]))
+	key.proto = ipv4.GetNextProtocol()
+
+	d := o.lookupOrCreate(&key, m.VPort())
+	r := o.addFragment(d, offset, more, p[:l3+hl], p[l3+hl:l3+ipv4.GetLength()])
+	if r == nil {
+		return nil
+	}
+	/* fix the header as a non fragmented packet */
+	p = r.GetData()
+	ipv4 = layers.IPv4Header(p[l3 : l3+hl])
+	ipv4.SetLength(uint16(r.PktLen()) - l3)
+	binary.BigEndian.PutUint16(p[l3+6:l3+8], frag&0x4000) // keep DF only
+	ipv4.UpdateChecksum()
+	return r
+}
+
+/*
+AddIPv6 add an IPv6 fragment.
+
+	l3    - offset of the IPv6 header
+	nhOff - offset of the next header field that points to the fragment header
+	fh    - offset of the fragment header
+
+Return the reassembled packet in case the datagram is complete
+*/
+func (o *CIpReass) AddIPv6(m *Mbuf, l3 uint16, nhOff uint16, fh uint16) *Mbuf {
+	p := m.GetData()
+	ipv6 := layers.IPv6Header(p[l3 : l3+IPV6_HEADER_SIZE])
+	end := l3 + IPV6_HEADER_SIZE + ipv6.PayloadLength()
+	frag := binary.BigEndian.Uint16(p[fh+2 : fh+4])
+	offset := frag & 0xfff8
+	more := (frag & 0x1) == 0x1
+
+	o.stats.ipv6Frags++
+	var key ipReassKey
+	copy(key.src[:], ipv6.SrcIP())
+	copy(key.dst[:], ipv6.DstIP())
+	key.id = binary.BigEndian.Uint32(p[fh+4 : fh+8])
+	key.ipv6 = true
+
+	d := o.lookupOrCreate(&key, m.VPort())
+	if offset == 0 {
+		d.nhOff = nhOff
+		d.nh = p[fh]
+	}
+	r := o.addFragment(d, offset, more, p[:fh], p[fh+8:end])
+	if r == nil {
+		return nil
+	}
+	/* fix the header as a non fragmented packet */
+	p = r.GetData()
+	p[d.nhOff] = d.nh
+	ipv6 = layers.IPv6Header(p[l3 : l3+IPV6_HEADER_SIZE])
+	ipv6.SetPyloadLength(uint16(r.PktLen()) - l3 - IPV6_HEADER_SIZE)
+	return r
+}
@@ -0,0 +1,294 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"encoding/binary"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"net"
+	"testing"
+)
+
+var reassPkts uint16
+var reassLen uint16
+var reassData []byte
+
+func reassUdpCb(ps *ParserPacketState) int {
+	reassPkts++
+	reassLen = ps.L7Len
+	reassData = append([]byte(nil), ps.M.GetData()[ps.L7:ps.L7+ps.L7Len]...)
+	return 0
+}
+
+func reassPayload(size int) []byte {
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = byte(i)
+	}
+	return b
+}
+
+func newReassTctx(t *testing.T) (*CThreadCtx, *Parser) {
+	tctx := NewThreadCtx(0, 4510, false, nil)
+	var tun CTunnelData
+	tun.Vport = 7
+	var key CTunnelKey
+	key.Set(&tun)
+	tctx.AddNs(&key, NewNSCtx(tctx, &key))
+	parser := &tctx.parser
+	parser.RegisterCb("udp", reassUdpCb, []ParserMatch{{IPProto: uint8(layers.IPProtocolUDP)}})
+	reassPkts = 0
+	reassLen = 0
+	reassData = nil
+	return tctx, parser
+}
+
+// fragmentIPv4 split an ethernet/ipv4 packet to fragments with up to size bytes of payload
+func fragmentIPv4(data []byte, size int) [][]byte {
+	l3 := 14
+	hdr := data[:l3+20]
+	payload := data[l3+20:]
+	r := make([][]byte, 0)
+	for off := 0; off < len(payload); off += size {
+		end := off + size
+		more := uint16(0x2000)
+		if end >= len(payload) {
+			end = len(payload)
+			more = 0
+		}
+		f := append(append([]byte(nil), hdr...), payload[off:end]...)
+		ipv4 := layers.IPv4Header(f[l3 : l3+20])
+		ipv4.SetLength(uint16(20 + end - off))
+		binary.BigEndian.PutUint16(f[l3+6:l3+8], more|uint16(off>>3))
+		ipv4.UpdateChecksum()
+		r = append(r, f)
+	}
+	return r
+}
+
+// fragmentIPv6 split an ethernet/ipv6 packet to fragments using a fragment header
+func fragmentIPv6(data []byte, size int, id uint32) [][]byte {
+	l3 := 14
+	hdr := data[:l3+IPV6_HEADER_SIZE]
+	nh := hdr[l3+6]
+	payload := data[l3+IPV6_HEADER_SIZE:]
+	r := make([][]byte, 0)
+	for off := 0; off < len(payload); off += size {
+		end := off + size
+		more := uint16(1)
+		if end >= len(payload) {
+			end = len(payload)
+			more = 0
+		}
+		fh := make([]byte, 8)
+		fh[0] = nh
+		binary.BigEndian.PutUint16(fh[2:4], uint16(off)|more)
+		binary.BigEndian.PutUint32(fh[4:8], id)
+		f := append(append(append([]byte(nil), hdr...), fh...), payload[off:end]...)
+		ipv6 := layers.IPv6Header(f[l3 : l3+IPV6_HEADER_SIZE])
+		ipv6.SetNextHeader(IPV6_EXT_Fragment)
+		ipv6.SetPyloadLength(uint16(8 + end - off))
+		r = append(r, f)
+	}
+	return r
+}
+
+func buildUdpPacketIPv4(payload []byte) []byte {
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	ipv4 := &layers.IPv4{Version: 4, IHL: 5, TTL: 128, Id: 0x1234, SrcIP: net.IPv4(16, 0, 0, 1), DstIP: net.IPv4(16, 0, 0, 2),
+		Protocol: layers.IPProtocolUDP}
+	udp := &layers.UDP{SrcPort: 1025, DstPort: 53}
+	udp.SetNetworkLayerForChecksum(ipv4)
+	gopacket.SerializeLayers(buf, opts,
+		&layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0, 1, 1, 1, 1, 1},
+			DstMAC:       net.HardwareAddr{0, 2, 2, 2, 2, 2},
+			EthernetType: layers.EthernetTypeIPv4,
+		},
+		ipv4,
+		udp,
+		gopacket.Payload(payload),
+	)
+	return buf.Bytes()
+}
+
+func buildUdpPacketIPv6(payload []byte) []byte {
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	ipv6 := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolUDP,
+		SrcIP: net.ParseIP("2001:db8::1"), DstIP: net.ParseIP("2001:db8::2")}
+	udp := &layers.UDP{SrcPort: 1025, DstPort: 53}
+	udp.SetNetworkLayerForChecksum(ipv6)
+	gopacket.SerializeLayers(buf, opts,
+		&layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0, 1, 1, 1, 1, 1},
+			DstMAC:       net.HardwareAddr{0, 2, 2, 2, 2, 2},
+			EthernetType: layers.EthernetTypeIPv6,
+		},
+		ipv6,
+		udp,
+		gopacket.Payload(payload),
+	)
+	return buf.Bytes()
+}
+
+func parseReassFrags(tctx *CThreadCtx, parser *Parser, frags [][]byte, order []int) {
+	for _, i := range order {
+		m := tctx.MPool.Alloc(uint16(len(frags[i])))
+		m.SetVPort(7)
+		m.Append(frags[i])
+		parser.ParsePacket(m)
+		m.FreeMbuf()
+	}
+}
+
+func checkReassPayload(t *testing.T, payload []byte) {
+	if reassPkts != 1 {
+		t.Fatalf(" ERROR reassembled packet should be delivered once, got %d ", reassPkts)
+	}
+	if int(reassLen) != len(payload) {
+		t.Fatalf(" ERROR reassembled L7 len %d expected %d ", reassLen, len(payload))
+	}
+	for i := range payload {
+		if reassData[i] != payload[i] {
+			t.Fatalf(" ERROR reassembled payload is different at %d ", i)
+		}
+	}
+}
+
+func TestIpReassIPv4(t *testing.T) {
+	tctx, parser := newReassTctx(t)
+	defer tctx.Delete()
+	payload := reassPayload(3000)
+	frags := fragmentIPv4(buildUdpPacketIPv4(payload), 1480)
+
+	parseReassFrags(tctx, parser, frags, []int{2, 0, 1})
+	checkReassPayload(t, payload)
+	if parser.reassStats.reassOk != 1 || parser.reassStats.ipv4Frags != 3 || parser.reassStats.reassDatagrams != 0 {
+		t.Fatalf(" ERROR reassembly counters %+v ", parser.reassStats)
+	}
+	if tctx.MPool.GetStats().InUsed() != 0 {
+		t.Fatalf(" ERROR mbuf leakage ")
+	}
+}
+
+func TestIpReassIPv6(t *testing.T) {
+	tctx, parser := newReassTctx(t)
+	defer tctx.Delete()
+	payload := reassPayload(4000)
+	frags := fragmentIPv6(buildUdpPacketIPv6(payload), 1232, 0x11)
+
+	parseReassFrags(tctx, parser, frags, []int{1, 3, 0, 2})
+	checkReassPayload(t, payload)
+	if parser.reassStats.reassOk != 1 || parser.reassStats.ipv6Frags != 4 {
+		t.Fatalf(" ERROR reassembly counters %+v ", parser.reassStats)
+	}
+}
+
+func TestIpReassOverlap(t *testing.T) {
+	tctx, parser := newReassTctx(t)
+	defer tctx.Delete()
+	payload := reassPayload(3000)
+	frags := fragmentIPv4(buildUdpPacketIPv4(payload), 1480)
+	overlap := fragmentIPv4(buildUdpPacketIPv4(payload), 1000)
+
+	parseReassFrags(tctx, parser, [][]byte{frags[0], overlap[1]}, []int{0, 1})
+	if reassPkts != 0 || parser.reassStats.reassOverlap != 1 || parser.reassStats.reassDatagrams != 0 {
+		t.Fatalf(" ERROR overlap should drop the datagram %+v ", parser.reassStats)
+	}
+	if tctx.MPool.GetStats().InUsed() != 0 {
+		t.Fatalf(" ERROR mbuf leakage ")
+	}
+}
+
+func TestIpReassTimeout(t *testing.T) {
+	tctx, parser := newReassTctx(t)
+	defer tctx.Delete()
+	payload := reassPayload(3000)
+	frags := fragmentIPv4(buildUdpPacketIPv4(payload), 1480)
+
+	parseReassFrags(tctx, parser, frags, []int{0, 1})
+	timerctx := tctx.GetTimerCtx()
+	ticks := timerctx.DurationToTicks(IP_REASS_TIMEOUT) + 10
+	for i := uint32(0); i < ticks; i++ {
+		timerctx.HandleTicks()
+	}
+	if parser.reassStats.reassTimeout != 1 || parser.reassStats.reassDatagrams != 0 {
+		t.Fatalf(" ERROR datagram should timeout %+v ", parser.reassStats)
+	}
+	// the last fragment alone can't complete the datagram
+	parseReassFrags(tctx, parser, frags, []int{2})
+	if reassPkts != 0 || parser.reassStats.reassDatagrams != 1 {
+		t.Fatalf(" ERROR datagram should not be delivered after timeout ")
+	}
+}
+
+func TestIpReassBadLength(t *testing.T) {
+	tctx, parser := newReassTctx(t)
+	defer tctx.Delete()
+	payload := reassPayload(3000)
+	frags := fragmentIPv4(buildUdpPacketIPv4(payload), 1480)
+
+	// total length shorter than the header
+	ipv4 := layers.IPv4Header(frags[0][14 : 14+20])
+	ipv4.SetLength(10)
+	ipv4.UpdateChecksum()
+	parseReassFrags(tctx, parser, frags, []int{0})
+	if parser.stats.errIPv4TooShort != 1 || parser.reassStats.ipv4Frags != 0 {
+		t.Fatalf(" ERROR bad fragment should be dropped by the parser %+v ", parser.reassStats)
+	}
+	if tctx.MPool.GetStats().InUsed() != 0 {
+		t.Fatalf(" ERROR mbuf leakage ")
+	}
+}
+
+func mbufsToBytes(frags []*Mbuf) [][]byte {
+	r := make([][]byte, 0)
+	for _, m := range frags {
+		r = append(r, append([]byte(nil), m.GetData()...))
+		m.FreeMbuf()
+	}
+	return r
+}
+
+func TestIpFragmentRoundTrip(t *testing.T) {
+	tctx, parser := newReassTctx(t)
+	defer tctx.Delete()
+	var tun CTunnelData
+	tun.Vport = 7
+	var key CTunnelKey
+	key.Set(&tun)
+	ns := tctx.GetNs(&key)
+
+	payload := reassPayload(5000)
+	pkt := buildUdpPacketIPv4(payload)
+	frags := IPv4Fragment(ns, pkt[:34], 14, pkt[34:], 1500, 0x55)
+	if len(frags) != int(IPv4FragmentCount(uint32(len(pkt)-34), 1500)) || len(frags) != 4 {
+		t.Fatalf(" ERROR wrong number of ipv4 fragments %d ", len(frags))
+	}
+	parseReassFrags(tctx, parser, mbufsToBytes(frags), []int{3, 2, 1, 0})
+	checkReassPayload(t, payload)
+
+	reassPkts = 0
+	payload = reassPayload(3500)
+	pkt = buildUdpPacketIPv6(payload)
+	l4 := 14 + IPV6_HEADER_SIZE
+	frags = IPv6Fragment(ns, pkt[:l4], 14, pkt[l4:], 1280, 0x66)
+	if len(frags) != int(IPv6FragmentCount(uint32(len(pkt)-l4), 1280)) || len(frags) != 3 {
+		t.Fatalf(" ERROR wrong number of ipv6 fragments %d ", len(frags))
+	}
+	parseReassFrags(tctx, parser, mbufsToBytes(frags), []int{0, 1, 2})
+	checkReassPayload(t, payload)
+
+	if IPv4Fragment(ns, pkt[:34], 14, make([]byte, IPV4_MAX_PAYLOAD+1), 1500, 1) != nil {
+		t.Fatalf(" ERROR ipv4 payload is too big to fragment ")
+	}
+	if tctx.MPool.GetStats().InUsed() != 0 {
+		t.Fatalf(" ERROR mbuf leakage ")
+	}
+}
@@ -5,6 +5,23 @@ const (
 	RESOLVED_IPV6_DG_MAC             // Flag to indicate the IPv6 default gateway mac was resolved
 )
 
+const (
+	CLIENT_UPDATE_IPV4     = 1 << iota // Flag to indicate the IPv4 of the client was changed
+	CLIENT_UPDATE_MASKV4               // Flag to indicate the IPv4 mask was changed
+	CLIENT_UPDATE_DGIPV4               // Flag to indicate the IPv4 default gateway was changed
+	CLIENT_UPDATE_MTU                  // Flag to indicate the MTU was changed
+	CLIENT_UPDATE_IPV6                 // Flag to indicate the IPv6 of the client was changed
+	CLIENT_UPDATE_DGIPV6               // Flag to indicate the IPv6 default gateway was changed
+	CLIENT_UPDATE_FORCE_DG             // Flag to indicate the forced default gateway of IPv4 or IPv6 was changed
+)
+
+// CClientUpdate is the argument of MSG_UPDATE_CLIENT
+type CClientUpdate struct {
+	Changed uint32  // CLIENT_UPDATE_* flags of the fields that were changed
+	OldIpv4 Ipv4Key // the IPv4 before the update
+	OldIpv6 Ipv6Key // the IPv6 before the update
+}
+
 const (
 	MSG_UPDATE_IPV4_ADDR   = "update_ipv4"     // client plugin, source ipv4 addr was changed (oldIpv4, NewIpv4 from type Ipv4Key )
 	MSG_UPDATE_IPV6_ADDR   = "update_ipv6"     // client plugin, ipv6 addr was changed (oldIpv6, NewIpv6 from type Ipv6Key )
@@ -12,4 +29,7 @@ const (
 	MSG_UPDATE_DGIPV4_ADDR = "update_dgipv4"   // client plugin, DG ipv4 addr was changed (oldIpv4, NewIpv4 from type Ipv4Key )
 	MSG_UPDATE_DGIPV6_ADDR = "update_dgipv6"   // client plugin, DG ipv4 addr was changed (oldIpv6, NewIpv6 from type Ipv6Key )
 	MSG_DG_MAC_RESOLVED    = "dg_mac_resolved" // client plugin, DG MAC was resolved. When sending this message, the first broadcast parameter `a` is a bit mask of the previous flags.
+	MSG_UPDATE_CLIENT      = "update_client"   // client plugin, the client was updated by ctx_client_update (*CClientUpdate, nil), sent after the update_* messages
+	MSG_IPV4_CONFLICT      = "ipv4_conflict"   // client plugin, another host uses the ipv4 of the client (Ipv4Key, MACKey of the other host)
+	MSG_UPDATE_IPV6_IFID   = "update_ifid"     // client plugin, the interface identifier was changed (old link local, new link local from type Ipv6Key)
 )
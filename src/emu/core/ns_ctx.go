@@ -114,6 +114,7 @@ type MapClientMAC map[MACKey]*CClient
 type CNSCtxStats struct {
 	addClient        uint64
 	removeClient     uint64
+	updateClient     uint64
 	activeClient     uint64
 	errRemoveIPv4tbl uint64 /* ipv4 does not exits in the IPv4 table */
 	errRemoveMactbl  uint64 /* client MAC does not exits in the MAC table */
@@ -148,6 +149,14 @@ func newNsStats(o *CNSCtxStats) *CCounterDb {
 		DumpZero: false,
 		Info:     ScINFO})
 
+	db.Add(&CCounterRec{
+		Counter:  &o.updateClient,
+		Name:     "updateClient",
+		Help:     "update client",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     ScINFO})
+
 	db.Add(&CCounterRec{
 		Counter:  &o.activeClient,
 		Name:     "activeClient",
@@ -212,6 +221,7 @@ type CNSCtx struct {
 	iter           DListIterHead
 	cdb            *CCounterDb
 	DefClientPlugs *MapJsonPlugs // Default plugins for each new client
+	ipReass        *CIpReass     // created on the first fragment
 }
 
 type CNsInfo struct {
@@ -243,6 +253,17 @@ func NewNSCtx(tctx *CThreadCtx,
 //OnRemove called before remove
 func (o *CNSCtx) OnRemove() {
 	o.PluginCtx.OnRemove()
+	if o.ipReass != nil {
+		o.ipReass.OnRemove()
+	}
+}
+
+// GetIpReass return the fragments reassembly buffer of this namespace
+func (o *CNSCtx) GetIpReass() *CIpReass {
+	if o.ipReass == nil {
+		o.ipReass = NewIpReass(o.ThreadCtx, &o.ThreadCtx.parser.reassStats)
+	}
+	return o.ipReass
 }
 
 func (o *CNSCtx) GetVport() uint16 {
@@ -310,6 +331,15 @@ func (o *CNSCtx) CLookupByIPv6LocalGlobal(ipv6 *Ipv6Key) *CClient {
 			if client != nil {
 				return client
 			}
+			// the interface identifier might be set by UpdateClientIpv6IfId, look for its link local
+			var l6 Ipv6Key
+			l6[0] = 0xFE
+			l6[1] = 0x80
+			copy(l6[8:], tipv6[8:])
+			client = o.CLookupByIPv6(&l6)
+			if client != nil && client.IsValidPrefix(tipv6) {
+				return client
+			}
 		}
 	}
 	return nil
@@ -434,6 +464,13 @@ func (o *CNSCtx) RemoveClient(client *CClient) error {
 		}
 	}
 
+	var zero [8]byte
+	if client.Ipv6IfId != zero {
+		var l6 Ipv6Key
+		client.GetIpv6LocalLink(&l6)
+		delete(o.mapIpv6, l6)
+	}
+
 	o.epoc++
 	o.stats.removeClient++
 	return nil
@@ -532,6 +569,40 @@ func (o *CNSCtx) UpdateClientDIpv6(client *CClient, NewIpv6 Ipv6Key) error {
 	return nil
 }
 
+/*
+UpdateClientIpv6IfId sets the interface identifier of the link local and slaac addresses of the client, for example
+the one that was negotiated by IPv6CP. A zero identifier restores the EUI-64 of the MAC. The link local of an
+identifier that isn't derived from the MAC is kept in the IPv6 table so the client could be looked up by it.
+*/
+func (o *CNSCtx) UpdateClientIpv6IfId(client *CClient, ifId [8]byte) error {
+
+	if client.Ipv6IfId == ifId {
+		return nil
+	}
+
+	var oldIpv6, newIpv6 Ipv6Key
+	client.GetIpv6LocalLink(&oldIpv6)
+	oldIfId := client.Ipv6IfId
+	client.Ipv6IfId = ifId
+	client.GetIpv6LocalLink(&newIpv6)
+
+	var zero [8]byte
+	if ifId != zero {
+		if c := o.CLookupByIPv6(&newIpv6); c != nil && c != client {
+			client.Ipv6IfId = oldIfId
+			return fmt.Errorf(" client with the same link local %v already exist", newIpv6)
+		}
+	}
+	if oldIfId != zero {
+		delete(o.mapIpv6, oldIpv6)
+	}
+	if ifId != zero {
+		o.mapIpv6[newIpv6] = client
+	}
+	client.PluginCtx.BroadcastMsg(nil, MSG_UPDATE_IPV6_IFID, oldIpv6, newIpv6)
+	return nil
+}
+
 // IterReset save the rpc epoc and operate only if there wasn't a change
 func (o *CNSCtx) IterReset() bool {
 
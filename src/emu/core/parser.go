@@ -39,8 +39,14 @@ const (
 )
 
 const (
-	PARSER_ERR = -1
-	PARSER_OK  = 0
+	PARSER_ERR  = -1
+	PARSER_OK   = 0
+	PARSER_NEXT = 1 // the packet is not for this protocol, try the next protocol of a shared match
+)
+
+const (
+	ETH_MAX_LENGTH   = 1500 // an ethernet type field up to this value is the length of an 802.3 frame
+	LLC_SNAP_HDR_LEN = 8    // DSAP, SSAP, Control, OUI and protocol id
 )
 
 // FLAGS of IPv6
@@ -81,21 +87,6 @@ type ParserStats struct {
 	errIPv4cs             uint64
 	errTCP                uint64
 	errUDP                uint64
-	eapolPkts             uint64
-	eapolBytes            uint64
-
-	arpPkts               uint64
-	arpBytes              uint64
-	icmpPkts              uint64
-	icmpBytes             uint64
-	igmpPkts              uint64
-	igmpBytes             uint64
-	dhcpPkts              uint64
-	dhcpBytes             uint64
-	dhcpSrvPkts           uint64
-	dhcpSrvBytes          uint64
-	mDnsPkts              uint64
-	mDnsBytes             uint64
 	tcpPkts               uint64
 	tcpBytes              uint64
 	udpPkts               uint64
@@ -111,11 +102,11 @@ type ParserStats struct {
 	errIcmpv6Cse          uint64
 	errIcmpv4Cse          uint64
 	errIcmpv6Unsupported  uint64
-	Icmpv6Pkt             uint64
-	Icmpv6Bytes           uint64
 	errL4ProtoUnsupported uint64
 	errL3ProtoUnsupported uint64
 	errPacketIsTooShort   uint64
+	errSnapTooShort       uint64
+	errNotHandled         uint64
 }
 
 func newParserStatsDb(o *ParserStats) *CCounterDb {
@@ -130,20 +121,12 @@ func newParserStatsDb(o *ParserStats) *CCounterDb {
 		Info:     ScERROR})
 
 	db.Add(&CCounterRec{
-		Counter:  &o.eapolPkts,
-		Name:     "eapolPkts",
-		Help:     "eapol packets",
+		Counter:  &o.errSnapTooShort,
+		Name:     "errSnapTooShort",
+		Help:     "802.3 llc/snap packets are too short",
 		Unit:     "pkts",
 		DumpZero: false,
-		Info:     ScINFO})
-
-	db.Add(&CCounterRec{
-		Counter:  &o.eapolBytes,
-		Name:     "eapolBytes",
-		Help:     "eapol bytes",
-		Unit:     "bytes",
-		DumpZero: false,
-		Info:     ScINFO})
+		Info:     ScERROR})
 
 	db.Add(&CCounterRec{
 		Counter:  &o.errInternalHandler,
@@ -257,102 +240,6 @@ func newParserStatsDb(o *ParserStats) *CCounterDb {
 		DumpZero: false,
 		Info:     ScERROR})
 
-	db.Add(&CCounterRec{
-		Counter:  &o.arpPkts,
-		Name:     "arpPkts",
-		Help:     "arp packets",
-		Unit:     "pkts",
-		DumpZero: false,
-		Info:     ScINFO})
-
-	db.Add(&CCounterRec{
-		Counter:  &o.arpBytes,
-		Name:     "arpBytes",
-		Help:     "arp bytes",
-		Unit:     "bytes",
-		DumpZero: false,
-		Info:     ScINFO})
-
-	db.Add(&CCounterRec{
-		Counter:  &o.icmpPkts,
-		Name:     "icmpPkts",
-		Help:     "icmp packets",
-		Unit:     "pkts",
-		DumpZero: false,
-		Info:     ScINFO})
-
-	db.Add(&CCounterRec{
-		Counter:  &o.icmpBytes,
-		Name:     "icmpBytes",
-		Help:     "icmp bytes",
-		Unit:     "bytes",
-		DumpZero: false,
-		Info:     ScINFO})
-
-	db.Add(&CCounterRec{
-		Counter:  &o.igmpPkts,
-		Name:     "igmpPkts",
-		Help:     "igmp packets",
-		Unit:     "pkts",
-		DumpZero: false,
-		Info:     ScINFO})
-
-	db.Add(&CCounterRec{
-		Counter:  &o.igmpBytes,
-		Name:     "igmpBytes",
-		Help:     "igmp bytes",
-		Unit:     "bytes",
-		DumpZero: false,
-		Info:     ScINFO})
-
-	db.Add(&CCounterRec{
-		Counter:  &o.dhcpPkts,
-		Name:     "dhcpPkts",
-		Help:     "dhcp packets",
-		Unit:     "pkts",
-		DumpZero: false,
-		Info:     ScINFO})
-
-	db.Add(&CCounterRec{
-		Counter:  &o.dhcpBytes,
-		Name:     "dhcpBytes",
-		Help:     "dhcp bytes",
-		Unit:     "bytes",
-		DumpZero: false,
-		Info:     ScINFO})
-
-	db.Add(&CCounterRec{
-		Counter:  &o.dhcpSrvPkts,
-		Name:     "dhcpSrvPkts",
-		Help:     "Dhcp server packets",
-		Unit:     "pkts",
-		DumpZero: false,
-		Info:     ScINFO})
-
-	db.Add(&CCounterRec{
-		Counter:  &o.dhcpSrvBytes,
-		Name:     "dhcpSrvBytes",
-		Help:     "Dhcp server bytes",
-		Unit:     "bytes",
-		DumpZero: false,
-		Info:     ScINFO})
-
-	db.Add(&CCounterRec{
-		Counter:  &o.mDnsPkts,
-		Name:     "mDnsPkts",
-		Help:     "mDns packets",
-		Unit:     "pkts",
-		DumpZero: false,
-		Info:     ScINFO})
-
-	db.Add(&CCounterRec{
-		Counter:  &o.mDnsBytes,
-		Name:     "mDnsBytes",
-		Help:     "mDns bytes",
-		Unit:     "bytes",
-		DumpZero: false,
-		Info:     ScINFO})
-
 	db.Add(&CCounterRec{
 		Counter:  &o.tcpPkts,
 		Name:     "tcpPkts",
@@ -441,22 +328,6 @@ func newParserStatsDb(o *ParserStats) *CCounterDb {
 		DumpZero: false,
 		Info:     ScERROR})
 
-	db.Add(&CCounterRec{
-		Counter:  &o.Icmpv6Pkt,
-		Name:     "Icmpv6Pkt",
-		Help:     "Icmpv6 pkts",
-		Unit:     "pkts",
-		DumpZero: false,
-		Info:     ScINFO})
-
-	db.Add(&CCounterRec{
-		Counter:  &o.Icmpv6Bytes,
-		Name:     "Icmpv6Bytes",
-		Help:     "Icmpv6 bytes",
-		Unit:     "bytes",
-		DumpZero: false,
-		Info:     ScINFO})
-
 	db.Add(&CCounterRec{
 		Counter:  &o.errIPv6OptJumbo,
 		Name:     "errIPv6OptJumbo",
@@ -468,7 +339,7 @@ func newParserStatsDb(o *ParserStats) *CCounterDb {
 	db.Add(&CCounterRec{
 		Counter:  &o.errIPv6Fragment,
 		Name:     "errIPv6Fragment",
-		Help:     "ipv6 fragment is not supported",
+		Help:     "ipv6 fragment without a namespace",
 		Unit:     "pkt",
 		DumpZero: false,
 		Info:     ScERROR})
@@ -476,7 +347,7 @@ func newParserStatsDb(o *ParserStats) *CCounterDb {
 	db.Add(&CCounterRec{
 		Counter:  &o.errIPv4Fragment,
 		Name:     "errIPv4Fragment",
-		Help:     "ipv4 fragment is not supported",
+		Help:     "ipv4 fragment without a namespace",
 		Unit:     "pkt",
 		DumpZero: false,
 		Info:     ScERROR})
@@ -489,6 +360,22 @@ func newParserStatsDb(o *ParserStats) *CCounterDb {
 		DumpZero: false,
 		Info:     ScERROR})
 
+	db.Add(&CCounterRec{
+		Counter:  &o.errL4ProtoUnsupported,
+		Name:     "errL4ProtoUnsupported",
+		Help:     "L4 proto is not supported",
+		Unit:     "pkt",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.errNotHandled,
+		Name:     "errNotHandled",
+		Help:     "no protocol of a shared match handled the packet",
+		Unit:     "pkt",
+		DumpZero: false,
+		Info:     ScERROR})
+
 	db.Add(&CCounterRec{
 		Counter:  &o.errPacketIsTooShort,
 		Name:     "errPacketIsTooShort",
@@ -500,84 +387,328 @@ func newParserStatsDb(o *ParserStats) *CCounterDb {
 	return db
 }
 
+/*
+ParserMatch declares which packets a protocol callback wants to receive.
+
+	EthType - L2 match on the ethernet type after the vlan tags (e.g. ARP, EAPOL, PPPoE)
+	SnapOUI - L2 match on the organization code of an 802.3 LLC/SNAP frame, valid only with SnapType
+	SnapType - L2 match on the protocol id of an 802.3 LLC/SNAP frame (e.g. CDP), ps.L3 is the LLC header
+	IPProto - L4 match on the IPv4 protocol/IPv6 next header (e.g. ICMP, IGMP, UDP)
+	L3      - restrict an L4 match to EthernetTypeIPv4 or EthernetTypeIPv6, zero matches both
+	SrcPort - TCP/UDP source port, zero matches any port
+	DstPort - TCP/UDP destination port, zero matches any port
+
+A TCP/UDP match with ports has precedence over a match on the bare IP protocol, an exact
+(SrcPort, DstPort) match has precedence over a match on one of the ports only.
+*/
+type ParserMatch struct {
+	EthType  uint16
+	SnapOUI  uint32
+	SnapType uint16
+	IPProto  uint8
+	L3       uint16
+	SrcPort  uint16
+	DstPort  uint16
+}
+
+func (o *ParserMatch) String() string {
+	if o.EthType != 0 {
+		return fmt.Sprintf("eth:%04x", o.EthType)
+	}
+	if o.SnapType != 0 {
+		return fmt.Sprintf("snap:%06x:%04x", o.SnapOUI, o.SnapType)
+	}
+	return fmt.Sprintf("l3:%04x,proto:%d,sport:%d,dport:%d", o.L3, o.IPProto, o.SrcPort, o.DstPort)
+}
+
+func (o *ParserMatch) hasPorts() bool {
+	return o.SrcPort != 0 || o.DstPort != 0
+}
+
+func (o *ParserMatch) snapKey() uint64 {
+	return uint64(o.SnapOUI&0xffffff)<<16 | uint64(o.SnapType)
+}
+
+func (o *ParserMatch) validate() error {
+	if o.EthType != 0 {
+		switch layers.EthernetType(o.EthType) {
+		case layers.EthernetTypeIPv4, layers.EthernetTypeIPv6,
+			layers.EthernetTypeDot1Q, layers.EthernetTypeQinQ:
+			return fmt.Errorf("ethernet type %04x is handled by the parser", o.EthType)
+		}
+		if o.EthType <= ETH_MAX_LENGTH {
+			return fmt.Errorf("ethernet type %04x is an 802.3 length", o.EthType)
+		}
+		if o.SnapOUI != 0 || o.SnapType != 0 || o.IPProto != 0 || o.L3 != 0 || o.hasPorts() {
+			return fmt.Errorf("ethernet type match can't have snap/L3/L4 fields")
+		}
+		return nil
+	}
+	if o.SnapType != 0 || o.SnapOUI != 0 {
+		if o.SnapType == 0 || o.SnapOUI > 0xffffff {
+			return fmt.Errorf("snap match should have a type and a 24 bits organization code")
+		}
+		if o.IPProto != 0 || o.L3 != 0 || o.hasPorts() {
+			return fmt.Errorf("snap match can't have L3/L4 fields")
+		}
+		return nil
+	}
+	if o.IPProto == 0 {
+		return fmt.Errorf("match should have ethernet type or ip protocol")
+	}
+	if o.L3 != 0 && o.L3 != uint16(layers.EthernetTypeIPv4) && o.L3 != uint16(layers.EthernetTypeIPv6) {
+		return fmt.Errorf("L3 %04x should be ipv4 or ipv6", o.L3)
+	}
+	if o.hasPorts() {
+		proto := layers.IPProtocol(o.IPProto)
+		if proto != layers.IPProtocolTCP && proto != layers.IPProtocolUDP {
+			return fmt.Errorf("ports are valid only for tcp/udp")
+		}
+	}
+	return nil
+}
+
+// parserPortKey key of the tcp/udp port table, zero port is a wildcard
+type parserPortKey struct {
+	proto   uint8
+	srcPort uint16
+	dstPort uint16
+}
+
+// parserEntry is a registered protocol callback with its counters
+type parserEntry struct {
+	name     string
+	cb       ParserCb
+	shared   bool
+	priority int
+	pkts     uint64
+	bytes    uint64
+}
+
+// parserChain are the protocols of a match, more than one only in case the match is shared, the higher priority first
+type parserChain []*parserEntry
+
+const (
+	parserL3IPv4 = 0
+	parserL3IPv6 = 1
+	parserL3Max  = 2
+)
+
 /* counters */
 type Parser struct {
 	tctx *CThreadCtx
 
-	stats ParserStats
-	/* call backs */
-	arp     ParserCb
-	icmp    ParserCb
-	igmp    ParserCb
-	dhcp    ParserCb
-	dhcpsrv ParserCb
-	dhcpv6  ParserCb
-	mdns    ParserCb
-	tcp     ParserCb
-	udp     ParserCb
-	icmpv6  ParserCb
-	eapol   ParserCb
-	ppp     ParserCb
-	Cdb     *CCounterDb
+	stats      ParserStats
+	reassStats IpReassStats
+	/* dispatch tables */
+	protos   map[string]*parserEntry
+	l2       map[uint16]parserChain                     // ethernet type
+	snap     map[uint64]parserChain                     // 802.3 llc/snap organization code and type
+	l4       [parserL3Max]map[uint8]parserChain         // ip protocol
+	l4Ports  [parserL3Max]map[parserPortKey]parserChain // tcp/udp ports
+	Cdb      *CCounterDb
+	ReassCdb *CCounterDb
 }
 
-func parserNotSupported(ps *ParserPacketState) int {
-	return -1
+// Register install the callback and matches of a protocol registered by ParserRegister/ParserRegisterShared
+func (o *Parser) Register(protocol string) {
+	proto := getProto(protocol)
+	o.register(&parserEntry{name: protocol, cb: proto.cb, shared: proto.shared, priority: proto.priority}, proto.matches)
 }
 
-func (o *Parser) Register(protocol string) {
-	if protocol == "arp" {
-		o.arp = getProto("arp")
+// parserCounterNames are the packets/bytes counters names of protocols that had fixed counters before the dispatch tables
+var parserCounterNames = map[string][2]string{
+	"dot1x":   {"eapolPkts", "eapolBytes"},
+	"mdns":    {"mDnsPkts", "mDnsBytes"},
+	"dhcpsrv": {"dhcpSrvPkts", "dhcpSrvBytes"},
+	"icmpv6":  {"Icmpv6Pkt", "Icmpv6Bytes"},
+}
+
+/*
+RegisterCb install a callback directly in the dispatch tables of this parser.
+Packets and bytes counters named <protocol>Pkts/<protocol>Bytes are added to the parser counters,
+the protocols of parserCounterNames keep their original counters names.
+Registering the same protocol twice is ignored, two protocols with the same match will panic.
+*/
+func (o *Parser) RegisterCb(protocol string, cb ParserCb, matches []ParserMatch) {
+	o.register(&parserEntry{name: protocol, cb: cb}, matches)
+}
+
+// RegisterSharedCb is RegisterCb of a protocol that shares its matches, see ParserRegisterShared
+func (o *Parser) RegisterSharedCb(protocol string, priority int, cb ParserCb, matches []ParserMatch) {
+	o.register(&parserEntry{name: protocol, cb: cb, shared: true, priority: priority}, matches)
+}
+
+func (o *Parser) register(e *parserEntry, matches []ParserMatch) {
+	protocol := e.name
+	if _, ok := o.protos[protocol]; ok {
+		return
 	}
-	if protocol == "icmp" {
-		o.icmp = getProto("icmp")
+
+	for i := range matches {
+		match := &matches[i]
+		if err := match.validate(); err != nil {
+			panic(fmt.Sprintf(" parser protocol %s has invalid match %s, %s ", protocol, match.String(), err.Error()))
+		}
+		if match.EthType != 0 {
+			o.l2[match.EthType] = addParserEntry(o.l2[match.EthType], e, match)
+			continue
+		}
+		if match.SnapType != 0 {
+			o.snap[match.snapKey()] = addParserEntry(o.snap[match.snapKey()], e, match)
+			continue
+		}
+		for l3 := 0; l3 < parserL3Max; l3++ {
+			if match.L3 != 0 && parserL3Index(match.L3) != l3 {
+				continue
+			}
+			if match.hasPorts() {
+				key := parserPortKey{proto: match.IPProto, srcPort: match.SrcPort, dstPort: match.DstPort}
+				o.l4Ports[l3][key] = addParserEntry(o.l4Ports[l3][key], e, match)
+			} else {
+				o.l4[l3][match.IPProto] = addParserEntry(o.l4[l3][match.IPProto], e, match)
+			}
+		}
 	}
-	if protocol == "igmp" {
-		o.igmp = getProto("igmp")
+	o.protos[protocol] = e
+
+	names, ok := parserCounterNames[protocol]
+	if !ok {
+		names = [2]string{protocol + "Pkts", protocol + "Bytes"}
 	}
-	if protocol == "dhcp" {
-		o.dhcp = getProto("dhcp")
+
+	o.Cdb.Add(&CCounterRec{
+		Counter:  &e.pkts,
+		Name:     names[0],
+		Help:     protocol + " packets",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	o.Cdb.Add(&CCounterRec{
+		Counter:  &e.bytes,
+		Name:     names[1],
+		Help:     protocol + " bytes",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     ScINFO})
+}
+
+// addParserEntry add a protocol to the chain of a match, only shared protocols can share a match
+func addParserEntry(chain parserChain, e *parserEntry, match *ParserMatch) parserChain {
+	for _, old := range chain {
+		if old == e {
+			return chain
+		}
+		if !old.shared || !e.shared {
+			panic(fmt.Sprintf(" parser match %s is already registered by %s, can't register %s ", match.String(), old.name, e.name))
+		}
 	}
-	if protocol == "dhcpsrv" {
-		o.dhcpsrv = getProto("dhcpsrv")
+	i := 0
+	for i < len(chain) && chain[i].priority >= e.priority {
+		i++
 	}
-	if protocol == "icmpv6" {
-		o.icmpv6 = getProto("icmpv6")
+	r := make(parserChain, 0, len(chain)+1)
+	r = append(r, chain[:i]...)
+	r = append(r, e)
+	return append(r, chain[i:]...)
+}
+
+func (o *Parser) Init(tctx *CThreadCtx) {
+	o.tctx = tctx
+	o.protos = make(map[string]*parserEntry)
+	o.l2 = make(map[uint16]parserChain)
+	o.snap = make(map[uint64]parserChain)
+	for l3 := 0; l3 < parserL3Max; l3++ {
+		o.l4[l3] = make(map[uint8]parserChain)
+		o.l4Ports[l3] = make(map[parserPortKey]parserChain)
 	}
-	if protocol == "dhcpv6" {
-		o.dhcpv6 = getProto("dhcpv6")
+	o.Cdb = newParserStatsDb(&o.stats)
+	o.ReassCdb = newIpReassStatsDb(&o.reassStats)
+}
+
+func parserL3Index(layer3 uint16) int {
+	if layer3 == uint16(layers.EthernetTypeIPv6) {
+		return parserL3IPv6
 	}
-	if protocol == "dot1x" {
-		o.eapol = getProto("dot1x")
+	return parserL3IPv4
+}
+
+// dispatch pass the packet to the protocols of the chain until one of them doesn't return PARSER_NEXT,
+// PARSER_NEXT is meaningful only for shared protocols.
+func (o *Parser) dispatch(chain parserChain, ps *ParserPacketState) int {
+	for _, e := range chain {
+		r := e.cb(ps)
+		if r != PARSER_NEXT || !e.shared {
+			e.pkts++
+			e.bytes += uint64(ps.M.PktLen())
+			return r
+		}
 	}
-	if protocol == "mdns" {
-		o.mdns = getProto("mdns")
+	o.stats.errNotHandled++
+	return PARSER_ERR
+}
+
+// lookupL4 return the callbacks of an L4 protocol, nil in case nobody registered it
+func (o *Parser) lookupL4(l3 int, proto uint8) parserChain {
+	return o.l4[l3][proto]
+}
+
+// lookupPorts return the callbacks of a tcp/udp packet, the ports tables are checked before the protocol table
+func (o *Parser) lookupPorts(l3 int, proto uint8, srcPort uint16, dstPort uint16) parserChain {
+	ports := o.l4Ports[l3]
+	if len(ports) > 0 {
+		if e, ok := ports[parserPortKey{proto, srcPort, dstPort}]; ok {
+			return e
+		}
+		if e, ok := ports[parserPortKey{proto, 0, dstPort}]; ok {
+			return e
+		}
+		if e, ok := ports[parserPortKey{proto, srcPort, 0}]; ok {
+			return e
+		}
 	}
+	return o.lookupL4(l3, proto)
+}
 
-	if protocol == "ppp" {
-		o.ppp = getProto("ppp")
+// parseReassembled parse a reassembled packet as if it was received unfragmented
+func (o *Parser) parseReassembled(m *Mbuf) int {
+	if m == nil {
+		return PARSER_OK // fragment is kept by the reassembly
 	}
+	r := o.ParsePacket(m)
+	m.FreeMbuf()
+	return r
+}
 
-	if protocol == "transport" {
-		o.tcp = getProto("transport")
-		o.udp = getProto("transport")
+func (o *Parser) parsePacketL2(ps *ParserPacketState, ethType layers.EthernetType) int {
+	e, ok := o.l2[uint16(ethType)]
+	if !ok {
+		o.stats.errL3ProtoUnsupported++
+		return PARSER_ERR
 	}
+	return o.dispatch(e, ps)
 }
 
-func (o *Parser) Init(tctx *CThreadCtx) {
-	o.tctx = tctx
-	o.arp = parserNotSupported
-	o.icmp = parserNotSupported
-	o.igmp = parserNotSupported
-	o.dhcp = parserNotSupported
-	o.dhcpsrv = parserNotSupported
-	o.tcp = parserNotSupported
-	o.udp = parserNotSupported
-	o.icmpv6 = parserNotSupported
-	o.dhcpv6 = parserNotSupported
-	o.mdns = parserNotSupported
-	o.ppp = parserNotSupported
-	o.Cdb = newParserStatsDb(&o.stats)
+// parsePacketSnap dispatch an 802.3 frame by its LLC/SNAP header, ps.L3 is the LLC header
+func (o *Parser) parsePacketSnap(ps *ParserPacketState) int {
+	p := ps.M.GetData()
+	if ps.M.PktLen() < uint32(ps.L3+LLC_SNAP_HDR_LEN) {
+		o.stats.errSnapTooShort++
+		return PARSER_ERR
+	}
+	llc := p[ps.L3 : ps.L3+LLC_SNAP_HDR_LEN]
+	if llc[0] != 0xaa || llc[1] != 0xaa || llc[2] != 0x03 {
+		o.stats.errL3ProtoUnsupported++
+		return PARSER_ERR
+	}
+	key := uint64(llc[3])<<32 | uint64(llc[4])<<24 | uint64(llc[5])<<16 | uint64(binary.BigEndian.Uint16(llc[6:8]))
+	e, ok := o.snap[key]
+	if !ok {
+		o.stats.errL3ProtoUnsupported++
+		return PARSER_ERR
+	}
+	return o.dispatch(e, ps)
 }
 
 func (o *Parser) parsePacketL4(ps *ParserPacketState,
@@ -586,6 +717,8 @@ func (o *Parser) parsePacketL4(ps *ParserPacketState,
 	packetSize := ps.M.PktLen()
 	p := ps.M.GetData()
 	ps.NextHeader = nextHdr
+	l3 := parserL3Index(layer3)
+	var e parserChain
 
 	switch layers.IPProtocol(nextHdr) {
 	case layers.IPProtocolICMPv4:
@@ -599,18 +732,14 @@ func (o *Parser) parsePacketL4(ps *ParserPacketState,
 			return PARSER_ERR
 		}
 
-		o.stats.icmpPkts++
-		o.stats.icmpBytes += uint64(packetSize)
 		ps.L7 = ps.L4 + 8
-		return o.icmp(ps)
+		e = o.lookupL4(l3, nextHdr)
 	case layers.IPProtocolIGMP:
 		if packetSize < uint32(ps.L4+8) {
 			o.stats.errIcmpv4TooShort++
 			return PARSER_ERR
 		}
-		o.stats.igmpPkts++
-		o.stats.igmpBytes += uint64(packetSize)
-		return o.igmp(ps)
+		e = o.lookupL4(l3, nextHdr)
 	case layers.IPProtocolTCP:
 		if l4len < uint16(20) {
 			o.stats.errTcpTooShort++
@@ -632,7 +761,9 @@ func (o *Parser) parsePacketL4(ps *ParserPacketState,
 
 		o.stats.tcpPkts++
 		o.stats.tcpBytes += uint64(packetSize)
-		return o.tcp(ps)
+		srcPort := binary.BigEndian.Uint16(p[ps.L4 : ps.L4+2])
+		dstPort := binary.BigEndian.Uint16(p[ps.L4+2 : ps.L4+4])
+		e = o.lookupPorts(l3, nextHdr, srcPort, dstPort)
 	case layers.IPProtocolUDP:
 		if packetSize < uint32(ps.L4+8) {
 			o.stats.errUdpTooShort++
@@ -649,37 +780,7 @@ func (o *Parser) parsePacketL4(ps *ParserPacketState,
 		o.stats.udpPkts++
 		o.stats.udpBytes += uint64(packetSize)
 		ps.L7 = ps.L4 + 8
-
-		if udp.DstPort() == 5353 {
-			o.stats.mDnsPkts++
-			o.stats.mDnsBytes += uint64(packetSize)
-			return o.mdns(ps)
-		}
-
-		if layer3 == uint16(layers.EthernetTypeIPv6) {
-			if (udp.SrcPort() == 547) && (udp.DstPort() == 546) {
-				o.stats.dhcpPkts++
-				o.stats.dhcpBytes += uint64(packetSize)
-				return o.dhcpv6(ps)
-			}
-		} else {
-			if (udp.SrcPort() == 67) && (udp.DstPort() == 68) {
-				// S -> C, parse by client
-				o.stats.dhcpPkts++
-				o.stats.dhcpBytes += uint64(packetSize)
-				return o.dhcp(ps)
-			}
-			if udp.DstPort() == 67 && (udp.SrcPort() == 67 || udp.SrcPort() == 68) {
-				// C -> S, parse by server.
-				// If C -> S without relay, the source port is 68.
-				// If C -> S with relay, the relay changes the source port to 67.
-				o.stats.dhcpSrvPkts++
-				o.stats.dhcpSrvBytes += uint64(packetSize)
-				return o.dhcpsrv(ps)
-			}
-		}
-
-		return o.udp(ps)
+		e = o.lookupPorts(l3, nextHdr, udp.SrcPort(), udp.DstPort())
 
 	case layers.IPProtocolICMPv6:
 		if packetSize < uint32(ps.L4+4) {
@@ -708,19 +809,20 @@ func (o *Parser) parsePacketL4(ps *ParserPacketState,
 			layers.ICMPv6TypeRouterAdvertisement,
 			layers.ICMPv6TypeNeighborSolicitation,
 			layers.ICMPv6TypeNeighborAdvertisement:
-			o.stats.Icmpv6Pkt++
-			o.stats.Icmpv6Bytes += uint64(packetSize)
-			return (o.icmpv6(ps))
+			e = o.lookupL4(l3, nextHdr)
 		default:
 			o.stats.errIcmpv6Unsupported++
 			return PARSER_ERR
 		}
-		return -1
 	default:
+		e = o.lookupL4(l3, nextHdr)
+	}
+
+	if e == nil {
 		o.stats.errL4ProtoUnsupported++
 		return PARSER_ERR
 	}
-	return (0)
+	return o.dispatch(e, ps)
 }
 
 func processIpv6Options(p []byte, flags *uint32) int {
@@ -784,9 +886,7 @@ func (o *Parser) ParsePacket(m *Mbuf) int {
 			}
 			ps.L3 = offset
 			tun.Set(&d)
-			o.stats.eapolPkts++
-			o.stats.eapolBytes += uint64(packetSize)
-			return o.eapol(&ps)
+			return o.parsePacketL2(&ps, nextHdr)
 
 		case layers.EthernetTypeARP:
 			if packetSize < uint32(offset+layers.ARPHeaderSize) {
@@ -795,9 +895,7 @@ func (o *Parser) ParsePacket(m *Mbuf) int {
 			}
 			ps.L3 = offset
 			tun.Set(&d)
-			o.stats.arpPkts++
-			o.stats.arpBytes += uint64(packetSize)
-			return o.arp(&ps)
+			return o.parsePacketL2(&ps, nextHdr)
 		case layers.EthernetTypeDot1Q, layers.EthernetTypeQinQ:
 			if packetSize < uint32(offset+4) {
 				o.stats.errDot1qTooShort++
@@ -811,14 +909,24 @@ func (o *Parser) ParsePacket(m *Mbuf) int {
 			d.Vlans[vlanIndex] = val
 			vlanIndex++
 			nextHdr = layers.EthernetType(binary.BigEndian.Uint16(p[offset+2 : offset+4]))
-			if nextHdr == layers.EthernetTypePPPoEDiscovery || nextHdr == layers.EthernetTypePPPoESession {
-				tun.Set(&d)
-				return o.ppp(&ps)
-			}
 			offset += 4
-		case layers.EthernetTypePPPoEDiscovery, layers.EthernetTypePPPoESession:
+		case layers.EthernetTypePPPoESession:
+			// IPv4/IPv6 of a session are parsed as is, the other PPP protocols are handled by the ppp plugin
+			if packetSize >= uint32(offset+PPPOE_SESSION_HDR_SIZE) && p[offset+1] == uint8(layers.PPPoECodeSession) {
+				switch layers.PPPType(binary.BigEndian.Uint16(p[offset+6 : offset+8])) {
+				case layers.PPPTypeIPv4:
+					nextHdr = layers.EthernetTypeIPv4
+					offset += PPPOE_SESSION_HDR_SIZE
+					continue
+				case layers.PPPTypeIPv6:
+					nextHdr = layers.EthernetTypeIPv6
+					offset += PPPOE_SESSION_HDR_SIZE
+					continue
+				}
+			}
+			ps.L3 = offset
 			tun.Set(&d)
-			return o.ppp(&ps)
+			return o.parsePacketL2(&ps, nextHdr)
 		case layers.EthernetTypeIPv4:
 			ps.L3 = offset
 			if packetSize < uint32(offset+20) {
@@ -830,10 +938,6 @@ func (o *Parser) ParsePacket(m *Mbuf) int {
 				o.stats.errIPv4HeaderTooShort++
 				return PARSER_ERR
 			}
-			if ipv4.IsFragment() {
-				o.stats.errIPv4Fragment++
-				return PARSER_ERR
-			}
 			hdr := ipv4.GetHeaderLen()
 			if hdr < 20 {
 				o.stats.errIPv4HeaderTooShort++
@@ -847,6 +951,10 @@ func (o *Parser) ParsePacket(m *Mbuf) int {
 				o.stats.errIPv4TooShort++
 				return PARSER_ERR
 			}
+			if ipv4.GetLength() < hdr {
+				o.stats.errIPv4TooShort++
+				return PARSER_ERR
+			}
 			if hdr != 20 {
 				ipv4 = layers.IPv4Header(p[offset : offset+hdr])
 			}
@@ -855,10 +963,18 @@ func (o *Parser) ParsePacket(m *Mbuf) int {
 				o.stats.errIPv4cs++
 				return PARSER_ERR
 			}
+			tun.Set(&d)
+			if ipv4.IsFragment() {
+				ns := o.tctx.GetNs(&tun)
+				if ns == nil {
+					o.stats.errIPv4Fragment++
+					return PARSER_ERR
+				}
+				return o.parseReassembled(ns.GetIpReass().AddIPv4(m, offset))
+			}
 			l4len := ipv4.GetLength() - ipv4.GetHeaderLen()
 			ps.L4 = offset + hdr
 			offset = ps.L4
-			tun.Set(&d)
 
 			return o.parsePacketL4(&ps, ipv4.GetNextProtocol(), ipv4.GetPhCs(), l4len, uint16(nextHdr))
 		case layers.EthernetTypeIPv6:
@@ -886,6 +1002,7 @@ func (o *Parser) ParsePacket(m *Mbuf) int {
 			tun.Set(&d)
 
 			nh := ipv6.NextHeader()
+			nhOff := ps.L3 + 6 // offset of the field that holds nh
 			var osize uint16
 			doloop := true
 			for doloop {
@@ -904,6 +1021,7 @@ func (o *Parser) ParsePacket(m *Mbuf) int {
 					nh = ipv6ex.NextHeader()
 					processIpv6Options(p[l4+2:l4+hl], &ps.Flags)
 
+					nhOff = l4
 					l4len -= hl
 					osize += hl
 					l4 += hl
@@ -928,12 +1046,21 @@ func (o *Parser) ParsePacket(m *Mbuf) int {
 					nh = ipv6ex.NextHeader()
 					processIpv6Options(p[l4+2:l4+hl], &ps.Flags)
 
+					nhOff = l4
 					l4len -= hl
 					osize += hl
 					l4 += hl
 				case IPV6_EXT_Fragment:
-					o.stats.errIPv6Fragment++
-					return PARSER_ERR
+					if l4len < 8 {
+						o.stats.errIPv6TooShort++
+						return PARSER_ERR
+					}
+					ns := o.tctx.GetNs(&tun)
+					if ns == nil {
+						o.stats.errIPv6Fragment++
+						return PARSER_ERR
+					}
+					return o.parseReassembled(ns.GetIpReass().AddIPv6(m, ps.L3, nhOff, l4))
 
 				case IPV6_EXT_JUMBO:
 					// not supported
@@ -951,20 +1078,31 @@ func (o *Parser) ParsePacket(m *Mbuf) int {
 			ps.L4 = l4
 			return o.parsePacketL4(&ps, nh, ipv6.GetPhCs(osize, nh), l4len, uint16(nextHdr))
 		default:
-			o.stats.errL3ProtoUnsupported++
-			return PARSER_ERR
+			ps.L3 = offset
+			tun.Set(&d)
+			if uint16(nextHdr) <= ETH_MAX_LENGTH {
+				return o.parsePacketSnap(&ps)
+			}
+			return o.parsePacketL2(&ps, nextHdr)
 		}
 	}
 	return 0
 }
 
+type parserProtocol struct {
+	cb       ParserCb
+	matches  []ParserMatch
+	shared   bool
+	priority int
+}
+
 type parserProtocols struct {
-	M map[string]ParserCb
+	M map[string]*parserProtocol
 }
 
 var parserDb parserProtocols
 
-func getProto(proto string) ParserCb {
+func getProto(proto string) *parserProtocol {
 	_, ok := parserDb.M[proto]
 	if !ok {
 		err := fmt.Sprintf(" parser protocol %s is no register ", proto)
@@ -973,19 +1111,50 @@ func getProto(proto string) ParserCb {
 	return parserDb.M[proto]
 }
 
-func ParserRegister(proto string, cb ParserCb) {
+/*
+ParserRegister register the rx callback of a protocol with the packets it wants to receive, for example
+
+	core.ParserRegister("arp", HandleRxArpPacket, core.ParserMatch{EthType: uint16(layers.EthernetTypeARP)})
+
+The callback is installed in the thread parser by CThreadCtx.RegisterParserCb
+*/
+func ParserRegister(proto string, cb ParserCb, matches ...ParserMatch) {
+	parserRegister(&parserProtocol{cb: cb, matches: matches}, proto)
+}
+
+/*
+ParserRegisterShared register the rx callback of a protocol that shares its matches with other shared protocols,
+for example a server and a relay of the same protocol in one namespace. The protocols of a shared match get the
+packet by priority, the higher first, until a callback returns a value other than PARSER_NEXT.
+*/
+func ParserRegisterShared(proto string, priority int, cb ParserCb, matches ...ParserMatch) {
+	parserRegister(&parserProtocol{cb: cb, matches: matches, shared: true, priority: priority}, proto)
+}
+
+func parserRegister(p *parserProtocol, proto string) {
+	matches := p.matches
 	_, ok := parserDb.M[proto]
 	if ok {
 		s := fmt.Sprintf(" Can't register the same protocol twice %s ", proto)
 		panic(s)
 	}
+	if len(matches) == 0 {
+		s := fmt.Sprintf(" Protocol %s should have at least one match ", proto)
+		panic(s)
+	}
+	for i := range matches {
+		if err := matches[i].validate(); err != nil {
+			s := fmt.Sprintf(" Protocol %s has invalid match %s, %s ", proto, matches[i].String(), err.Error())
+			panic(s)
+		}
+	}
 	fmt.Sprintf(" register protocol %s ", proto)
-	parserDb.M[proto] = cb
+	parserDb.M[proto] = p
 }
 
 func init() {
 	if runtime.NumGoroutine() != 1 {
 		panic(" NumGoroutine() should be 1 on init time, require lock  ")
 	}
-	parserDb.M = make(map[string]ParserCb)
+	parserDb.M = make(map[string]*parserProtocol)
 }
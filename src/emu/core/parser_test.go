@@ -8,6 +8,7 @@
 package core
 
 import (
+	"encoding/binary"
 	"external/google/gopacket"
 	"external/google/gopacket/layers"
 	"fmt"
@@ -115,8 +116,8 @@ func TestParserArp(t *testing.T) {
 	tctx := NewThreadCtx(0, 4510, false, nil)
 	defer tctx.Delete()
 	var parser Parser
-	parser.tctx = tctx
-	parser.arp = arpSupported
+	parser.Init(tctx)
+	parser.RegisterCb("arp", arpSupported, []ParserMatch{{EthType: uint16(layers.EthernetTypeARP)}})
 	m1 := tctx.MPool.Alloc(128)
 
 	buf := gopacket.NewSerializeBuffer()
@@ -174,8 +175,8 @@ func TestParserArp1(t *testing.T) {
 	tctx := NewThreadCtx(0, 4510, false, nil)
 	defer tctx.Delete()
 	var parser Parser
-	parser.tctx = tctx
-	parser.arp = arpSupported
+	parser.Init(tctx)
+	parser.RegisterCb("arp", arpSupported, []ParserMatch{{EthType: uint16(layers.EthernetTypeARP)}})
 	m1 := tctx.MPool.Alloc(128)
 
 	buf := gopacket.NewSerializeBuffer()
@@ -237,8 +238,8 @@ func TestParserIcmp(t *testing.T) {
 	tctx := NewThreadCtx(0, 4510, false, nil)
 	defer tctx.Delete()
 	var parser Parser
-	parser.tctx = tctx
-	parser.icmp = arpSupported
+	parser.Init(tctx)
+	parser.RegisterCb("icmp", arpSupported, []ParserMatch{{IPProto: uint8(layers.IPProtocolICMPv4)}})
 	m1 := tctx.MPool.Alloc(128)
 
 	buf := gopacket.NewSerializeBuffer()
@@ -306,8 +307,8 @@ func TestParserDhcp1(t *testing.T) {
 	tctx := NewThreadCtx(0, 4510, false, nil)
 	defer tctx.Delete()
 	var parser Parser
-	parser.tctx = tctx
-	parser.dhcp = arpSupported
+	parser.Init(tctx)
+	parser.RegisterCb("dhcp", arpSupported, []ParserMatch{{IPProto: uint8(layers.IPProtocolUDP), SrcPort: 67, DstPort: 68}})
 
 	buf := gopacket.NewSerializeBuffer()
 	/*opts := gopacket.SerializeOptions{FixLengths: true,
@@ -391,8 +392,8 @@ func TestParserDhcpInvalidCs(t *testing.T) {
 	tctx := NewThreadCtx(0, 4510, false, nil)
 	defer tctx.Delete()
 	var parser Parser
-	parser.tctx = tctx
-	parser.dhcp = arpSupported
+	parser.Init(tctx)
+	parser.RegisterCb("dhcp", arpSupported, []ParserMatch{{IPProto: uint8(layers.IPProtocolUDP), SrcPort: 67, DstPort: 68}})
 
 	buf := gopacket.NewSerializeBuffer()
 	/*opts := gopacket.SerializeOptions{FixLengths: true,
@@ -453,6 +454,196 @@ func TestParserDhcpInvalidCs(t *testing.T) {
 	}
 }
 
+var udpDefault uint16
+
+func udpDefaultSupported(ps *ParserPacketState) int {
+	udpDefault++
+	return -1
+}
+
+func buildUdpPacket(tctx *CThreadCtx, srcPort, dstPort uint16) *Mbuf {
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	ipv4 := &layers.IPv4{Version: 4, IHL: 5, TTL: 128, Id: 0xcc, SrcIP: net.IPv4(16, 0, 0, 1), DstIP: net.IPv4(48, 0, 0, 1),
+		Protocol: layers.IPProtocolUDP}
+	udp := &layers.UDP{SrcPort: layers.UDPPort(srcPort), DstPort: layers.UDPPort(dstPort)}
+	udp.SetNetworkLayerForChecksum(ipv4)
+
+	gopacket.SerializeLayers(buf, opts,
+		&layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0, 1, 1, 1, 1, 1},
+			DstMAC:       net.HardwareAddr{0, 2, 2, 2, 2, 2},
+			EthernetType: layers.EthernetTypeIPv4,
+		},
+		ipv4,
+		udp,
+		gopacket.Payload([]byte{1, 2, 3, 4}),
+	)
+	data := buf.Bytes()
+	m := tctx.MPool.Alloc(uint16(len(data)))
+	m.Append(data)
+	m.SetVPort(7)
+	return m
+}
+
+func TestParserUdpPortMatch(t *testing.T) {
+	tctx := NewThreadCtx(0, 4510, false, nil)
+	defer tctx.Delete()
+	var parser Parser
+	parser.Init(tctx)
+	parser.RegisterCb("snmp", arpSupported, []ParserMatch{{IPProto: uint8(layers.IPProtocolUDP), DstPort: 161}})
+	parser.RegisterCb("transport", udpDefaultSupported, []ParserMatch{{IPProto: uint8(layers.IPProtocolUDP)}})
+
+	arp = 0
+	udpDefault = 0
+	parser.ParsePacket(buildUdpPacket(tctx, 1025, 161))
+	parser.ParsePacket(buildUdpPacket(tctx, 161, 1025))
+	parser.ParsePacket(buildUdpPacket(tctx, 1025, 162))
+
+	if arp != 1 || udpDefault != 2 {
+		t.Fatalf(" ERROR port match snmp:%d transport:%d expected 1,2 ", arp, udpDefault)
+	}
+	if parser.protos["snmp"].pkts != 1 || parser.protos["transport"].pkts != 2 {
+		t.Fatalf(" ERROR per protocol counters are not right ")
+	}
+	cnt := parser.Cdb.MarshalValues(false)
+	if _, ok := cnt["snmpPkts"]; !ok {
+		t.Fatalf(" ERROR snmpPkts counter is missing %v ", cnt)
+	}
+}
+
+func buildSnapPacket(tctx *CThreadCtx, dsap uint8, oui []byte, snapType layers.EthernetType) *Mbuf {
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	gopacket.SerializeLayers(buf, opts,
+		&layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0, 1, 1, 1, 1, 1},
+			DstMAC:       net.HardwareAddr{0x01, 0x00, 0x0c, 0xcc, 0xcc, 0xcc},
+			EthernetType: layers.EthernetTypeLLC,
+			Length:       12,
+		},
+		&layers.Dot1Q{VLANIdentifier: 7, Type: layers.EthernetType(12)},
+		&layers.LLC{DSAP: dsap, SSAP: dsap, Control: 0x3},
+		&layers.SNAP{OrganizationalCode: oui, Type: snapType},
+		gopacket.Payload([]byte{1, 2, 3, 4}),
+	)
+	data := buf.Bytes()
+	// the ethernet type is the vlan, the 802.3 length is after the tag
+	data[12] = 0x81
+	data[13] = 0x00
+	m := tctx.MPool.Alloc(uint16(len(data)))
+	m.Append(data)
+	m.SetVPort(7)
+	return m
+}
+
+func TestParserSnapMatch(t *testing.T) {
+	tctx := NewThreadCtx(0, 4510, false, nil)
+	defer tctx.Delete()
+	var parser Parser
+	parser.Init(tctx)
+	parser.RegisterCb("cdp", arpSupported, []ParserMatch{{SnapOUI: 0xc, SnapType: uint16(layers.EthernetTypeCiscoDiscovery)}})
+
+	var tunData CTunnelData
+	tunData.Vport = 7
+	tunData.Vlans[0] = 0x81000007
+	var extun CTunnelKey
+	extun.Set(&tunData)
+
+	arp = 0
+	parser.ParsePacket(buildSnapPacket(tctx, 0xaa, []byte{0, 0, 0xc}, layers.EthernetTypeCiscoDiscovery))
+	if arp != 1 || lastL3 != 18 || lastTun != extun {
+		t.Fatalf(" ERROR snap match cdp:%d l3:%d expected 1,18 ", arp, lastL3)
+	}
+	parser.ParsePacket(buildSnapPacket(tctx, 0xaa, []byte{0, 0, 0xd}, layers.EthernetTypeCiscoDiscovery))
+	parser.ParsePacket(buildSnapPacket(tctx, 0x42, []byte{0, 0, 0xc}, layers.EthernetTypeCiscoDiscovery))
+	if arp != 1 || parser.stats.errL3ProtoUnsupported != 2 {
+		t.Fatalf(" ERROR other snap/llc frames should not match cdp:%d unsupported:%d ", arp, parser.stats.errL3ProtoUnsupported)
+	}
+	for _, match := range []ParserMatch{{SnapOUI: 0xc}, {SnapType: 0x2000, IPProto: 17}, {EthType: 0x40}} {
+		if match.validate() == nil {
+			t.Fatalf(" ERROR match %s should not be valid ", match.String())
+		}
+	}
+}
+
+func TestParserMatchConflict(t *testing.T) {
+	tctx := NewThreadCtx(0, 4510, false, nil)
+	defer tctx.Delete()
+	var parser Parser
+	parser.Init(tctx)
+	parser.RegisterCb("dhcp", arpSupported, []ParserMatch{{IPProto: uint8(layers.IPProtocolUDP), SrcPort: 67, DstPort: 68}})
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Fatalf(" ERROR registering the same match twice should panic ")
+		}
+	}()
+	parser.RegisterCb("dhcp1", arpSupported, []ParserMatch{{IPProto: uint8(layers.IPProtocolUDP), SrcPort: 67, DstPort: 68}})
+}
+
+var sharedRelay, sharedSrv int
+
+func sharedRelaySupported(ps *ParserPacketState) int {
+	sharedRelay++
+	if binary.BigEndian.Uint16(ps.M.GetData()[ps.L4:ps.L4+2]) == 68 {
+		return PARSER_NEXT
+	}
+	return 0
+}
+
+func sharedSrvSupported(ps *ParserPacketState) int {
+	sharedSrv++
+	return PARSER_NEXT
+}
+
+func TestParserSharedMatch(t *testing.T) {
+	tctx := NewThreadCtx(0, 4510, false, nil)
+	defer tctx.Delete()
+	var parser Parser
+	parser.Init(tctx)
+	match := []ParserMatch{{IPProto: uint8(layers.IPProtocolUDP), DstPort: 67}}
+	parser.RegisterSharedCb("srv", 0, sharedSrvSupported, match)
+	parser.RegisterSharedCb("relay", 1, sharedRelaySupported, match)
+
+	sharedRelay = 0
+	sharedSrv = 0
+	parser.ParsePacket(buildUdpPacket(tctx, 67, 67))
+	parser.ParsePacket(buildUdpPacket(tctx, 68, 67))
+
+	// the relay has the higher priority, the server gets what the relay passed
+	if sharedRelay != 2 || sharedSrv != 1 {
+		t.Fatalf(" ERROR shared match relay:%d srv:%d expected 2,1 ", sharedRelay, sharedSrv)
+	}
+	if parser.protos["relay"].pkts != 1 || parser.protos["srv"].pkts != 0 || parser.stats.errNotHandled != 1 {
+		t.Fatalf(" ERROR shared match counters are not right ")
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Fatalf(" ERROR a shared match can't be registered by an exclusive protocol ")
+		}
+	}()
+	parser.RegisterCb("dhcp", arpSupported, match)
+}
+
+func TestParserCounterNames(t *testing.T) {
+	tctx := NewThreadCtx(0, 4510, false, nil)
+	defer tctx.Delete()
+	var parser Parser
+	parser.Init(tctx)
+	parser.RegisterCb("dot1x", arpSupported, []ParserMatch{{EthType: uint16(layers.EthernetTypeEAPOL)}})
+	parser.RegisterCb("icmpv6", arpSupported, []ParserMatch{{L3: uint16(layers.EthernetTypeIPv6), IPProto: uint8(layers.IPProtocolICMPv6)}})
+	parser.RegisterCb("udp1", arpSupported, []ParserMatch{{IPProto: uint8(layers.IPProtocolUDP)}})
+
+	values := parser.Cdb.MarshalValues(true)
+	for _, name := range []string{"eapolPkts", "eapolBytes", "Icmpv6Pkt", "Icmpv6Bytes", "udp1Pkts", "udp1Bytes"} {
+		if _, ok := values[name]; !ok {
+			t.Fatalf(" ERROR counter %s is missing ", name)
+		}
+	}
+}
+
 func Icmpv6Supported(ps *ParserPacketState) int {
 	arp++
 	lastL3 = ps.L3
@@ -476,8 +667,8 @@ func TestParserIpv6Option(t *testing.T) {
 	tctx := NewThreadCtx(0, 4510, false, nil)
 	defer tctx.Delete()
 	var parser Parser
-	parser.tctx = tctx
-	parser.icmpv6 = Icmpv6Supported
+	parser.Init(tctx)
+	parser.RegisterCb("icmpv6", Icmpv6Supported, []ParserMatch{{IPProto: uint8(layers.IPProtocolICMPv6)}})
 
 	m1 := tctx.MPool.Alloc(uint16(len(packet)))
 	m1.SetVPort(7)
@@ -0,0 +1,120 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+package core
+
+import (
+	"encoding/binary"
+	"external/google/gopacket/layers"
+)
+
+const (
+	PPPOE_SESSION_HDR_SIZE = 8 // PPPoE header and PPP protocol of a session frame
+)
+
+/*
+CClientPPPoE is the PPPoE session of a client. While the client is attached to a session, the IPv4 and IPv6
+packets of the client are encapsulated in PPPoE session frames towards the access concentrator in the Tx path,
+and the session frames that carry IPv4/IPv6 are parsed as regular IP packets in the Rx path. This lets the
+plugins of the client (ipv6, dhcpv6, transport...) run over the PPP link without knowing about it.
+*/
+type CClientPPPoE struct {
+	SessionId uint16 `json:"session_id"`
+	ServerMac MACKey `json:"server_mac"`
+}
+
+// pppoeClientKey is the key of a client that is attached to a PPPoE session, the same MAC can be used in other namespaces.
+type pppoeClientKey struct {
+	tun CTunnelKey
+	mac MACKey
+}
+
+// SetPPPoE attaches the client to a PPPoE session, nil detaches it.
+func (o *CClient) SetPPPoE(session *CClientPPPoE) {
+	tctx := o.Ns.ThreadCtx
+	key := pppoeClientKey{tun: o.Ns.Key, mac: o.Mac}
+	if session == nil {
+		if o.PPPoE != nil {
+			delete(tctx.pppoeClients, key)
+		}
+	} else {
+		if tctx.pppoeClients == nil {
+			tctx.pppoeClients = make(map[pppoeClientKey]*CClient)
+		}
+		tctx.pppoeClients[key] = o
+	}
+	o.PPPoE = session
+}
+
+// encapPPPoE encapsulates the IPv4/IPv6 packets of clients that are attached to a PPPoE session.
+// The packet is returned as is in case it isn't such a packet.
+func (o *CThreadCtx) encapPPPoE(m *Mbuf) *Mbuf {
+	if len(o.pppoeClients) == 0 {
+		return m
+	}
+	p := m.GetData()
+	if len(p) < 14 {
+		return m
+	}
+	// the tunnel of the packet, as the parser builds it in the Rx path
+	var d CTunnelData
+	d.Vport = m.VPort()
+	offset := 12
+	for vlanIndex := 0; ; vlanIndex++ {
+		if len(p) < offset+2 {
+			return m
+		}
+		ethType := layers.EthernetType(binary.BigEndian.Uint16(p[offset : offset+2]))
+		if ethType != layers.EthernetTypeDot1Q && ethType != layers.EthernetTypeQinQ {
+			break
+		}
+		if vlanIndex > 1 || len(p) < offset+4 {
+			return m
+		}
+		d.Vlans[vlanIndex] = binary.BigEndian.Uint32(p[offset:offset+4]) & 0xffff0fff
+		offset += 4
+	}
+
+	var key pppoeClientKey
+	key.tun.Set(&d)
+	copy(key.mac[:], p[6:12])
+	c, ok := o.pppoeClients[key]
+	if !ok {
+		return m
+	}
+
+	var proto layers.PPPType
+	switch layers.EthernetType(binary.BigEndian.Uint16(p[offset : offset+2])) {
+	case layers.EthernetTypeIPv4:
+		proto = layers.PPPTypeIPv4
+	case layers.EthernetTypeIPv6:
+		proto = layers.PPPTypeIPv6
+	default:
+		return m
+	}
+
+	if !m.IsContiguous() {
+		m1 := m.GetContiguous(&o.MPool)
+		m.FreeMbuf()
+		m = m1
+		p = m.GetData()
+	}
+
+	var hdr [PPPOE_SESSION_HDR_SIZE + 2]byte
+	binary.BigEndian.PutUint16(hdr[0:2], uint16(layers.EthernetTypePPPoESession))
+	hdr[2] = 0x11 // version and type
+	hdr[3] = uint8(layers.PPPoECodeSession)
+	binary.BigEndian.PutUint16(hdr[4:6], c.PPPoE.SessionId)
+	binary.BigEndian.PutUint16(hdr[6:8], uint16(len(p)-offset)) // PPP protocol and payload
+	binary.BigEndian.PutUint16(hdr[8:10], uint16(proto))
+
+	n := o.MPool.Alloc(uint16(len(p) + PPPOE_SESSION_HDR_SIZE))
+	n.SetVPort(m.VPort())
+	n.Append(p[:offset])
+	n.Append(hdr[:])
+	n.Append(p[offset+2:])
+	copy(n.GetData()[0:6], c.PPPoE.ServerMac[:]) // everything goes to the access concentrator
+	m.FreeMbuf()
+	return n
+}
@@ -68,7 +68,17 @@ func (o *CZmqJsonRPC2) NewZmqRpc(serverPort uint16) {
 		errStr := fmt.Sprintf("Failed to create ZMQ RPC server - %v", err.Error())
 		log.Fatalln(errStr)
 	}
+	o.newMethodRepository()
+}
+
+// NewChanRpc create an rpc without a zmq server, the requests are sent to GetC() and the responses are read from GetResC()
+func (o *CZmqJsonRPC2) NewChanRpc() {
+	o.chMain2Rx = make(chan []byte)
+	o.chRx2Main = make(chan []byte)
+	o.newMethodRepository()
+}
 
+func (o *CZmqJsonRPC2) newMethodRepository() {
 	mr := jsonrpc.NewMethodRepository()
 	o.mr = mr
 	o.mr.Verbose = false
@@ -102,13 +112,23 @@ func (o *CZmqJsonRPC2) GetC() chan []byte {
 	return o.chRx2Main
 }
 
+// GetResC return the channel of the responses
+func (o *CZmqJsonRPC2) GetResC() chan []byte {
+	return o.chMain2Rx
+}
+
 // StartRxThread start a thread to handle the Req/Res
 func (o *CZmqJsonRPC2) StartRxThread() {
-	go o.rxThread()
+	if o.socket != nil {
+		go o.rxThread()
+	}
 }
 
 // Delete  this is an help
 func (o *CZmqJsonRPC2) Delete() {
+	if o.socket == nil {
+		return
+	}
 	o.socket.Close()
 	o.ctx.Term()
 }
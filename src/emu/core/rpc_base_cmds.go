@@ -8,6 +8,7 @@
 package core
 
 import (
+	"encoding/base64"
 	"external/osamingo/jsonrpc"
 	"fmt"
 	"time"
@@ -15,6 +16,10 @@ import (
 	"github.com/intel-go/fastjson"
 )
 
+const (
+	CLIENT_RANGE_MAX_ERRORS = 64 // failures reported in the response of a client range
+)
+
 type (
 	ApiSyncHandler struct{}
 	ApiSyncParams  struct {
@@ -83,6 +88,25 @@ type (
 	ApiClientAddHandler struct{}
 	ApiClientAddParams  struct{} /* key tunnel, [ClientCmd] */
 
+	ApiClientAddForm struct {
+		Range *fastjson.RawMessage `json:"range"` // range form instead of clients
+	}
+	ApiClientAddRangeParams struct {
+		Range CClientRangeCmd `json:"range" validate:"required"`
+	} /* key tunnel */
+	ApiClientAddRangeError struct {
+		Mac   MACKey `json:"mac"`
+		Error string `json:"error"`
+	}
+	ApiClientAddRangeResult struct {
+		Created uint32                   `json:"created"`
+		Failed  uint32                   `json:"failed"`
+		Errors  []ApiClientAddRangeError `json:"errors"` // the first CLIENT_RANGE_MAX_ERRORS failures
+	}
+
+	ApiClientUpdateHandler struct{}
+	ApiClientUpdateParams  struct{} /* key tunnel, [CClientUpdateCmd] */
+
 	ApiClientRemoveHandler struct{}
 	ApiClientRemoveParams  struct{} /* key tunnel, [MAC] */
 
@@ -124,6 +148,46 @@ type (
 
 	ApiResourceMonitorGetHandler   struct{}
 	ApiResourceMonitorResetHandler struct{}
+
+	/* Capture Commands */
+	ApiPcapNgStartHandler struct{}
+	ApiPcapNgStartParams  struct {
+		Tun    *CTunnelDataJson `json:"tun"`    // capture only this namespace, all the namespaces in case it is missing
+		Filter string           `json:"filter"` // BPF like filter, see CaptureFilter
+		File   string           `json:"file" validate:"required"`
+	}
+	ApiCaptureStartResult struct {
+		Id uint32 `json:"id"`
+	}
+
+	ApiCaptureStopHandler struct{} // stop a pcapng or a ring capture
+	ApiCaptureStopParams  struct {
+		Id uint32 `json:"id" validate:"required"`
+	}
+
+	ApiPcapNgGetInfoHandler struct{}
+	ApiPcapNgGetInfoResult  struct {
+		Captures []*CCaptureInfo `json:"captures"`
+	}
+
+	ApiCaptureStartHandler struct{}
+	ApiCaptureStartParams  struct {
+		Tun    CTunnelDataJson `json:"tun" validate:"required"`
+		Mac    *MACKey         `json:"mac"`    // capture only this client, all the clients in case it is missing
+		Filter string          `json:"filter"` // BPF like filter, see CaptureFilter
+		Limit  int             `json:"limit" validate:"gte=0,lte=65536"`
+	}
+
+	ApiCaptureFetchHandler struct{}
+	ApiCaptureFetchParams  struct {
+		Id    uint32 `json:"id" validate:"required"`
+		Clear bool   `json:"clear"` // remove the fetched packets from the ring
+	}
+	ApiCaptureFetchResult struct {
+		Pkts    int    `json:"pkts"`    // packets in the pcap
+		Dropped uint64 `json:"dropped"` // packets that were overwritten since the start of the capture
+		Pcap    string `json:"pcap"`    // base64 pcap file
+	}
 )
 
 func (h ApiResourceMonitorGetHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
@@ -347,6 +411,44 @@ func (h ApiNsGetDefPlugHandler) ServeJSONRPC(ctx interface{}, params *fastjson.R
 	return res, nil
 }
 
+// addClientCmd add a client with its plugins and start to resolve the default gateway. return the client in case it was added
+func addClientCmd(ns *CNSCtx, c *CClientCmd) (*CClient, *jsonrpc.Error) {
+	client := NewClientCmd(ns, c)
+
+	err := ns.AddClient(client)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+
+	var plugMap *MapJsonPlugs
+	if c.Plugins == nil {
+		/* client didn't supply plugins, use defaults */
+		plugMap = ns.DefClientPlugs
+	} else {
+		/* client supply plugins, use them */
+		plugMap = c.Plugins
+	}
+
+	if plugMap != nil {
+		for plName, plData := range *plugMap {
+			err = client.PluginCtx.addPlugin(plName, *plData)
+			if err != nil {
+				return client, &jsonrpc.Error{
+					Code:    jsonrpc.ErrorCodeInternal,
+					Message: err.Error(),
+				}
+			}
+		}
+	}
+
+	// After creating the clients and adding the plugins, we can try to attempt resolving.
+	client.AttemptResolve()
+	return client, nil
+}
+
 func (h ApiClientAddHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
 
 	tctx := ctx.(*CThreadCtx)
@@ -357,6 +459,12 @@ func (h ApiClientAddHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawM
 			Message: err.Error(),
 		}
 	}
+
+	var form ApiClientAddForm
+	if err = fastjson.Unmarshal(*params, &form); err == nil && form.Range != nil {
+		return addClientRange(tctx, ns, params)
+	}
+
 	var newc CClientCmds
 
 	err = tctx.UnmarshalValidate(*params, &newc)
@@ -368,39 +476,82 @@ func (h ApiClientAddHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawM
 	}
 
 	for _, c := range newc.Clients {
-		client := NewClientCmd(ns, &c)
+		if _, rerr := addClientCmd(ns, &c); rerr != nil {
+			return nil, rerr
+		}
+	}
+	return nil, nil
+}
+
+// addClientRange add the clients of a range, a client that fails is reported and the next clients are added
+func addClientRange(tctx *CThreadCtx, ns *CNSCtx, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p ApiClientAddRangeParams
+	err := tctx.UnmarshalValidate(*params, &p)
+	if err == nil {
+		err = p.Range.Validate()
+	}
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+
+	res := ApiClientAddRangeResult{Errors: make([]ApiClientAddRangeError, 0)}
+	var cmd CClientCmd
+	for i := uint32(0); i < p.Range.Count; i++ {
+		p.Range.Get(i, &cmd)
+		client, rerr := addClientCmd(ns, &cmd)
+		if rerr == nil {
+			res.Created++
+			continue
+		}
+		if client != nil {
+			// don't leave a client without its plugins
+			ns.RemoveClient(client)
+		}
+		res.Failed++
+		if len(res.Errors) < CLIENT_RANGE_MAX_ERRORS {
+			res.Errors = append(res.Errors, ApiClientAddRangeError{Mac: cmd.Mac, Error: rerr.Message})
+		}
+	}
+	return &res, nil
+}
+
+func (h ApiClientUpdateHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
 
-		err = ns.AddClient(client)
+	tctx := ctx.(*CThreadCtx)
+	ns, err := tctx.GetNsRpc(params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	var cmds CClientUpdateCmds
+
+	err = tctx.UnmarshalValidate(*params, &cmds)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+
+	for i := range cmds.Clients {
+		cmd := &cmds.Clients[i]
+		client := ns.CLookupByMac(&cmd.Mac)
+		if client == nil {
+			err = fmt.Errorf(" client with the MAC %v does not exist", cmd.Mac)
+		} else {
+			err = client.Update(cmd)
+		}
 		if err != nil {
 			return nil, &jsonrpc.Error{
 				Code:    jsonrpc.ErrorCodeInvalidRequest,
 				Message: err.Error(),
 			}
 		}
-
-		var plugMap *MapJsonPlugs
-		if c.Plugins == nil {
-			/* client didn't supply plugins, use defaults */
-			plugMap = ns.DefClientPlugs
-		} else {
-			/* client supply plugins, use them */
-			plugMap = c.Plugins
-		}
-
-		if plugMap != nil {
-			for plName, plData := range *plugMap {
-				err = client.PluginCtx.addPlugin(plName, *plData)
-				if err != nil {
-					return nil, &jsonrpc.Error{
-						Code:    jsonrpc.ErrorCodeInternal,
-						Message: err.Error(),
-					}
-				}
-			}
-		}
-
-		// After creating the clients and adding the plugins, we can try to attempt resolving.
-		client.AttemptResolve()
 	}
 	return nil, nil
 }
@@ -585,6 +736,98 @@ func getNsAndMacs(ctx interface{}, params *fastjson.RawMessage) (*CNSCtx, []MACK
 	return ns, keys, nil
 }
 
+func (h ApiPcapNgStartHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	tctx := ctx.(*CThreadCtx)
+	var p ApiPcapNgStartParams
+	err := tctx.UnmarshalValidate(*params, &p)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+
+	var tun *CTunnelKey
+	if p.Tun != nil {
+		tun = new(CTunnelKey)
+		tun.SetJson(p.Tun)
+	}
+	return captureStartResult(tctx.StartCapture(tun, p.Filter, p.File))
+}
+
+// captureStartResult return the id of a started capture, pcapng or ring
+func captureStartResult(id uint32, err error) (interface{}, *jsonrpc.Error) {
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return &ApiCaptureStartResult{Id: id}, nil
+}
+
+func (h ApiCaptureStopHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	tctx := ctx.(*CThreadCtx)
+	var p ApiCaptureStopParams
+	err := tctx.UnmarshalValidate(*params, &p)
+	if err == nil {
+		err = tctx.StopCapture(p.Id)
+	}
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return nil, nil
+}
+
+func (h ApiPcapNgGetInfoHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	tctx := ctx.(*CThreadCtx)
+	return &ApiPcapNgGetInfoResult{Captures: tctx.capture.GetInfo()}, nil
+}
+
+func (h ApiCaptureStartHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	tctx := ctx.(*CThreadCtx)
+	var p ApiCaptureStartParams
+	err := tctx.UnmarshalValidate(*params, &p)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+
+	var tun CTunnelKey
+	tun.SetJson(&p.Tun)
+	return captureStartResult(tctx.StartCaptureRing(&tun, p.Mac, p.Filter, p.Limit))
+}
+
+func (h ApiCaptureFetchHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	tctx := ctx.(*CThreadCtx)
+	var p ApiCaptureFetchParams
+	err := tctx.UnmarshalValidate(*params, &p)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+
+	pcap, info, err := tctx.FetchCapture(p.Id, p.Clear)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return &ApiCaptureFetchResult{
+		Pkts:    info.Pkts,
+		Dropped: info.Dropped,
+		Pcap:    base64.StdEncoding.EncodeToString(pcap),
+	}, nil
+}
+
 func init() {
 	RegisterCB("api_sync_v2", ApiSyncHandler{}, true)
 	RegisterCB("get_version", ApiGetVersionHandler{}, true)
@@ -608,6 +851,13 @@ func init() {
 	RegisterCB("ctx_client_set_def_plugins", ApiClientSetDefPlugHandler{}, false)
 	RegisterCB("ctx_client_get_def_plugins", ApiClientGetDefPlugHandler{}, false)
 	RegisterCB("ctx_client_iter", ApiClientIterHandler{}, false)
-	/* TBD add client_update */
+	RegisterCB("ctx_client_update", ApiClientUpdateHandler{}, false)
+
+	RegisterCB("ctx_pcapng_start", ApiPcapNgStartHandler{}, false)
+	RegisterCB("ctx_pcapng_stop", ApiCaptureStopHandler{}, false)
+	RegisterCB("ctx_pcapng_get_info", ApiPcapNgGetInfoHandler{}, false)
+	RegisterCB("ctx_capture_start", ApiCaptureStartHandler{}, false)
+	RegisterCB("ctx_capture_stop", ApiCaptureStopHandler{}, false)
+	RegisterCB("ctx_capture_fetch", ApiCaptureFetchHandler{}, false)
 
 }
@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"external/google/gopacket/layers"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
@@ -135,6 +136,22 @@ func (o *CTunnelKey) SetJson(d *CTunnelDataJson) {
 	o.Set(&t)
 }
 
+// packetTunnelKey build the tunnel key of an ethernet packet, the same way the parser does
+func packetTunnelKey(vport uint16, p []byte, tun *CTunnelKey) {
+	var d CTunnelData
+	d.Vport = vport
+	offset := 14
+	for i := 0; i < 2 && len(p) >= offset+4; i++ {
+		ethType := layers.EthernetType(binary.BigEndian.Uint16(p[offset-2 : offset]))
+		if ethType != layers.EthernetTypeDot1Q && ethType != layers.EthernetTypeQinQ {
+			break
+		}
+		d.Vlans[i] = binary.BigEndian.Uint32(p[offset-2:offset+2]) & 0xffff0fff
+		offset += 4
+	}
+	tun.Set(&d)
+}
+
 type MapPortT map[uint16]bool
 type MapNsT map[CTunnelKey]*CNSCtx
 
@@ -214,6 +231,7 @@ type CThreadCtx struct {
 	Veth            VethIF
 	validate        *validator.Validate
 	parser          Parser
+	capture         CCaptureCtx
 	simRecorder     []interface{} // record event for simulation
 	cdbv            *CCounterDbVec
 	clientStats     CClientStats
@@ -225,6 +243,7 @@ type CThreadCtx struct {
 	kernelMode      bool
 	resourceMonitor *ResourceMonitor
 	lockMainThread  bool
+	pppoeClients    map[pppoeClientKey]*CClient // clients that are attached to a PPPoE session, by tunnel and MAC
 }
 
 func NewThreadCtxProxy() *CThreadCtx {
@@ -246,18 +265,33 @@ func NewThreadCtxProxy() *CThreadCtx {
 
 func NewThreadCtx(Id uint32, rpcPort uint16, simulation bool, simRx *VethIFSim) *CThreadCtx {
 	o := new(CThreadCtx)
+	o.rpc.NewZmqRpc(rpcPort)
+	o.init(Id, simulation, simRx)
+	return o
+}
+
+// NewThreadCtxWorker create a thread without an RPC server, CThreadPool routes the requests to it
+func NewThreadCtxWorker(Id uint32, simulation bool) *CThreadCtx {
+	o := new(CThreadCtx)
+	o.rpc.NewChanRpc()
+	o.init(Id, simulation, nil)
+	return o
+}
+
+func (o *CThreadCtx) init(Id uint32, simulation bool, simRx *VethIFSim) {
+	o.Id = Id
 	o.timerctx = NewTimerCtx(simulation)
 	o.portMap = make(MapPortT)
 	o.Simulation = simulation
 	o.mapNs = make(MapNsT)
 	o.MPool.Init(mBUFS_CACHE)
-	o.rpc.NewZmqRpc(rpcPort)
 	o.rpc.SetCtx(o) /* back pointer to interface this */
 	o.nsHead.SetSelf()
 	o.PluginCtx = NewPluginCtx(nil, nil, o, PLUGIN_LEVEL_THREAD)
 	o.DefNsPlugs = nil
 	o.validate = validator.New()
 	o.parser.Init(o)
+	o.capture.Init(o)
 	if simRx != nil {
 		var simv VethIFSimulator
 		simv.Create(o)
@@ -282,11 +316,12 @@ func NewThreadCtx(Id uint32, rpcPort uint16, simulation bool, simRx *VethIFSim)
 	o.cdbv.AddVec(o.MPool.Cdbv)
 	o.cdbv.Add(o.MPool.Cdb)
 	o.cdbv.Add(o.parser.Cdb)
+	o.cdbv.Add(o.parser.ReassCdb)
+	o.cdbv.Add(o.capture.Cdb)
 	o.cdbv.Add(o.timerctx.Cdb)
 	cdb := newThreadCtxStats(&o.stats)
 	cdb.IOpt = &o.stats
 	o.cdbv.Add(cdb)
-	return o
 }
 
 func (o *CThreadCtx) SetVerbose(verbose bool) {
@@ -363,6 +398,7 @@ func (o *CThreadCtx) RegisterParserCb(protocol string) {
 }
 
 func (o *CThreadCtx) HandleRxPacket(m *Mbuf) {
+	o.capture.OnPacket(m, true)
 	r := o.parser.ParsePacket(m)
 	if r < 0 {
 		if r == -1 {
@@ -578,12 +614,21 @@ func (o *CThreadCtx) RemoveNsRpcSlice(params *fastjson.RawMessage) error {
 		return err
 	}
 
+	/* validate all the tunnels before removing any of them */
+	seen := make(map[CTunnelKey]bool)
 	for _, key := range keys {
 		ns := o.GetNs(&key)
-		if ns == nil {
+		if ns == nil || seen[key] {
 			return fmt.Errorf(" error can't find a valid namespace for this tunnel")
 		}
+		seen[key] = true
+		ns.stats.PreUpdate()
+		if ns.stats.activeClient > 0 {
+			return fmt.Errorf("ns with tunnel %v still has active clients, remove them", key)
+		}
+	}
 
+	for _, key := range keys {
 		/* add plugin data */
 		err := o.RemoveNs(&key)
 		if err != nil {
@@ -623,20 +668,29 @@ func (o *CThreadCtx) AddNsRpcSlice(params *fastjson.RawMessage) error {
 		return err
 	}
 
-	for i, key := range keys {
-		ns := o.GetNs(&key)
-		if ns != nil {
+	/* validate all the tunnels before adding any of them */
+	seen := make(map[CTunnelKey]bool)
+	for _, key := range keys {
+		if o.HasNs(&key) || seen[key] {
 			err = fmt.Errorf(" error there is a valid namespace for this tunnel: %s, can't add it ", key)
 			return err
 		}
+		seen[key] = true
+	}
 
-		ns = NewNSCtx(o, &key)
+	for i, key := range keys {
+		ns := NewNSCtx(o, &key)
 		err := o.AddNs(&key, ns)
-		if err != nil {
-			return err
+		if err == nil {
+			err = o.addPluginsNs(ns, plugs[i])
 		}
-
-		if err = o.addPluginsNs(ns, plugs[i]); err != nil {
+		if err != nil {
+			/* roll back the namespaces of this request */
+			for _, k := range keys[:i+1] {
+				if o.HasNs(&k) {
+					o.RemoveNs(&k)
+				}
+			}
 			return err
 		}
 	}
@@ -746,6 +800,7 @@ func (o *CThreadCtx) Delete() {
 	if o.shutdownTimer.IsRunning() {
 		o.timerctx.Stop(&o.shutdownTimer)
 	}
+	o.capture.RemoveAll()
 	o.rpc.Delete()
 }
 
@@ -804,6 +859,7 @@ func (o *CThreadCtx) RemoveNs(key *CTunnelKey) error {
 		return fmt.Errorf("ns with tunnel %v still has active clients, remove them", *key)
 	}
 	ns.OnRemove()
+	o.capture.RemoveNs(key)
 	o.stats.removeNs++
 	o.epoc++
 	o.nsHead.RemoveNode(&ns.dlist)
@@ -0,0 +1,738 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"external/osamingo/jsonrpc"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/intel-go/fastjson"
+)
+
+/*
+CThreadPool shards the namespaces across N worker threads (CThreadCtx), each worker owns its namespaces, clients,
+timers and mbuf pool, so the data path is lock free and scales with the number of cores.
+
+The namespace of a tunnel key is always in the same worker, the worker is a hash of the tunnel key. The pool
+
+ 1. steers the rx messages of the ZMQ uplink to the workers by the tunnel key of each packet
+ 2. sends the tx messages of the workers on the ZMQ uplink from one thread
+ 3. serves the RPC server and routes each request to the worker of its tunnel, requests without a tunnel are
+    broadcast and the results are merged (ctx_cnt counters are summed)
+
+	pool := NewThreadPool(4, 4510, false)
+	pool.SetZmqVeth(&uplink)
+	pool.StartRxThread()
+	pool.MainLoop()
+*/
+
+const (
+	THREAD_POOL_MAX_WORKERS   = 64
+	THREAD_POOL_RX_QUEUE_SIZE = 64   // rx messages that wait for a worker
+	THREAD_POOL_TX_QUEUE_SIZE = 1024 // tx messages that wait for the uplink
+	threadPoolCaptureIdShift  = 24   // the worker of a capture id is in the high bits
+)
+
+type CThreadPoolStats struct {
+	rxSteerPkts  uint64
+	rxSteerErr   uint64
+	rpcRouted    uint64
+	rpcSplit     uint64
+	rpcSplitUndo uint64
+	rpcBroadcast uint64
+	rpcErr       uint64
+}
+
+func NewThreadPoolStatsDb(o *CThreadPoolStats) *CCounterDb {
+	db := NewCCounterDb("pool")
+
+	db.Add(&CCounterRec{
+		Counter:  &o.rxSteerPkts,
+		Name:     "rxSteerPkts",
+		Help:     "rx packets steered to a worker",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.rxSteerErr,
+		Name:     "rxSteerErr",
+		Help:     "rx messages with a parse error",
+		Unit:     "msgs",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.rpcRouted,
+		Name:     "rpcRouted",
+		Help:     "rpc requests routed to one worker",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.rpcSplit,
+		Name:     "rpcSplit",
+		Help:     "rpc requests with tunnels split between the workers",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.rpcSplitUndo,
+		Name:     "rpcSplitUndo",
+		Help:     "split rpc requests that were rolled back on the workers",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.rpcBroadcast,
+		Name:     "rpcBroadcast",
+		Help:     "rpc requests sent to all the workers",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.rpcErr,
+		Name:     "rpcErr",
+		Help:     "rpc requests that could not be routed",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	return db
+}
+
+// rpcs that are served by the first worker
+var threadPoolFirstWorkerRpc = map[string]bool{
+	"api_sync_v2":                true,
+	"get_version":                true,
+	"ping":                       true,
+	"ctx_get_def_plugins":        true,
+	"ctx_resource_monitor_get":   true,
+	"ctx_resource_monitor_reset": true,
+}
+
+// rpcs with a capture id, the id holds the worker
+var threadPoolCaptureRpc = map[string]bool{
+	"ctx_pcapng_stop":   true,
+	"ctx_capture_stop":  true,
+	"ctx_capture_fetch": true,
+}
+
+// split rpcs that change the workers, the rpc that undoes the shares of the workers that applied it
+var threadPoolSplitUndo = map[string]string{
+	"ctx_add": "ctx_remove",
+}
+
+// threadPoolResponse is the response of a worker, the result is kept raw
+type threadPoolResponse struct {
+	Result *fastjson.RawMessage `json:"result"`
+	Error  *jsonrpc.Error       `json:"error"`
+}
+
+type CThreadPool struct {
+	Workers    []*CThreadCtx
+	veths      []*VethIFWorker
+	exitC      []chan struct{} // closed when the worker main loop exits
+	doneC      chan int
+	rpc        CZmqJsonRPC2
+	uplink     *VethIFZmq
+	txC        chan []byte
+	stats      CThreadPoolStats
+	cdb        *CCounterDb
+	iterWorker int    // the worker of the ctx_iter
+	iterReset  []bool // the worker iterator should be reset
+}
+
+// NewThreadPool create a pool of workers threads with one RPC server
+func NewThreadPool(workers int, rpcPort uint16, simulation bool) *CThreadPool {
+	if workers < 1 || workers > THREAD_POOL_MAX_WORKERS {
+		panic(fmt.Sprintf("number of threads %d should be between 1 and %d", workers, THREAD_POOL_MAX_WORKERS))
+	}
+	o := new(CThreadPool)
+	o.rpc.NewZmqRpc(rpcPort)
+	o.txC = make(chan []byte, THREAD_POOL_TX_QUEUE_SIZE)
+	o.doneC = make(chan int, workers)
+	o.cdb = NewThreadPoolStatsDb(&o.stats)
+	o.iterReset = make([]bool, workers)
+
+	// the same api handler for all the workers, api_sync_v2 is served by the first one
+	api := RandSeq(10)
+	for i := 0; i < workers; i++ {
+		w := NewThreadCtxWorker(uint32(i), simulation)
+		w.apiHandler = api
+		w.rpc.mr.SetAPI(api)
+		w.capture.nextId = uint32(i)<<threadPoolCaptureIdShift | 1
+		veth := new(VethIFWorker)
+		veth.Create(w, o.txC)
+		w.SetZmqVeth(veth)
+		o.Workers = append(o.Workers, w)
+		o.veths = append(o.veths, veth)
+		o.exitC = append(o.exitC, make(chan struct{}))
+	}
+	return o
+}
+
+func (o *CThreadPool) SetVerbose(verbose bool) {
+	for _, w := range o.Workers {
+		w.SetVerbose(verbose)
+	}
+}
+
+func (o *CThreadPool) SetKernelMode(kernelMode bool) {
+	for _, w := range o.Workers {
+		w.SetKernelMode(kernelMode)
+	}
+}
+
+func (o *CThreadPool) SetLockMainThread(lockMainThread bool) {
+	for _, w := range o.Workers {
+		w.SetLockMainThread(lockMainThread)
+	}
+}
+
+func (o *CThreadPool) SetRpcParams(v, c bool) {
+	o.Workers[0].SetRpcParams(v, c)
+	for _, w := range o.Workers[1:] {
+		w.SetRpcParams(false, false)
+	}
+}
+
+func (o *CThreadPool) SetDebug(monitor bool, monitorFile *os.File, capture bool) {
+	for _, veth := range o.veths {
+		veth.SetDebug(monitor, monitorFile, capture)
+	}
+}
+
+// SetZmqVeth set the uplink veth, the veth should be created with the first worker
+func (o *CThreadPool) SetZmqVeth(uplink *VethIFZmq) {
+	o.uplink = uplink
+}
+
+func (o *CThreadPool) GetCdb() *CCounterDb {
+	return o.cdb
+}
+
+// WorkerOf return the index of the worker that owns the namespace of the tunnel
+func (o *CThreadPool) WorkerOf(key *CTunnelKey) int {
+	if len(o.Workers) == 1 {
+		return 0
+	}
+	// FNV-1a
+	h := uint32(2166136261)
+	for _, b := range key {
+		h ^= uint32(b)
+		h *= 16777619
+	}
+	return int(h % uint32(len(o.Workers)))
+}
+
+func (o *CThreadPool) StartRxThread() {
+	o.rpc.StartRxThread()
+	if o.uplink != nil {
+		o.uplink.StartRxThread()
+		go o.rxSteerThread()
+		go o.txThread()
+	}
+}
+
+func (o *CThreadPool) rxSteerThread() {
+	for msg := range o.uplink.GetC() {
+		o.steer(msg)
+	}
+}
+
+func (o *CThreadPool) txThread() {
+	for msg := range o.txC {
+		o.uplink.SendStream(msg)
+	}
+}
+
+// steer split a rx message between the workers by the tunnel key of each packet
+func (o *CThreadPool) steer(msg []byte) {
+	if len(o.Workers) == 1 {
+		o.steerTo(0, msg)
+		return
+	}
+	blen := uint32(len(msg))
+	if blen < 4 || binary.BigEndian.Uint32(msg[0:4])>>16 != ZMQ_PACKET_HEADER_MAGIC {
+		o.stats.rxSteerErr++
+		return
+	}
+	pkts := int(binary.BigEndian.Uint32(msg[0:4]) & 0xffff)
+	out := make([][]byte, len(o.Workers))
+	var key CTunnelKey
+	of := uint32(4)
+	for i := 0; i < pkts; i++ {
+		if blen < of+4 {
+			o.stats.rxSteerErr++
+			break
+		}
+		header := binary.BigEndian.Uint32(msg[of : of+4])
+		pktLen := header & 0xffff
+		if (header&0xff000000) != 0xAA000000 || blen < of+4+pktLen {
+			o.stats.rxSteerErr++
+			break
+		}
+		packetTunnelKey(uint16((header&0x00ff0000)>>16), msg[of+4:of+4+pktLen], &key)
+		w := o.WorkerOf(&key)
+		if out[w] == nil {
+			out[w] = make([]byte, 4, blen)
+		}
+		out[w] = append(out[w], msg[of:of+4+pktLen]...)
+		binary.BigEndian.PutUint32(out[w][0:4], binary.BigEndian.Uint32(out[w][0:4])+1)
+		o.stats.rxSteerPkts++
+		of += 4 + pktLen
+	}
+	for w, wmsg := range out {
+		if wmsg != nil {
+			binary.BigEndian.PutUint32(wmsg[0:4], (uint32(ZMQ_PACKET_HEADER_MAGIC)<<16)+binary.BigEndian.Uint32(wmsg[0:4]))
+			o.steerTo(w, wmsg)
+		}
+	}
+}
+
+func (o *CThreadPool) steerTo(w int, msg []byte) {
+	select {
+	case o.veths[w].cn <- msg:
+	case <-o.exitC[w]:
+	}
+}
+
+// MainLoop run the workers and serve the RPC, returns when all the workers exit
+func (o *CThreadPool) MainLoop() {
+	for i, w := range o.Workers {
+		go func(i int, w *CThreadCtx) {
+			w.MainLoop()
+			close(o.exitC[i])
+			o.doneC <- i
+		}(i, w)
+	}
+
+	running := len(o.Workers)
+	for running > 0 {
+		select {
+		case req := <-o.rpc.GetC():
+			o.rpc.GetResC() <- o.HandleReq(req)
+		case <-o.doneC:
+			running--
+		}
+	}
+	if o.uplink != nil {
+		o.uplink.SimulatorCleanup()
+	}
+}
+
+func (o *CThreadPool) Delete() {
+	for _, w := range o.Workers {
+		w.Delete()
+	}
+	o.rpc.Delete()
+}
+
+// callWorker send a request to a worker and wait for the response
+func (o *CThreadPool) callWorker(i int, req []byte) ([]byte, error) {
+	w := o.Workers[i]
+	select {
+	case w.rpc.GetC() <- req:
+	case <-o.exitC[i]:
+		return nil, fmt.Errorf("thread %d is not running", i)
+	}
+	return <-w.rpc.GetResC(), nil
+}
+
+// call send a request to a worker and return the result
+func (o *CThreadPool) call(i int, r *jsonrpc.Request, params *fastjson.RawMessage) (*fastjson.RawMessage, *jsonrpc.Error) {
+	sub := jsonrpc.Request{Version: jsonrpc.Version, Method: r.Method, Params: params, ID: r.ID}
+	req, err := fastjson.Marshal(&sub)
+	if err != nil {
+		return nil, &jsonrpc.Error{Code: jsonrpc.ErrorCodeInternal, Message: err.Error()}
+	}
+	b, err := o.callWorker(i, req)
+	if err != nil {
+		return nil, &jsonrpc.Error{Code: jsonrpc.ErrorCodeInternal, Message: err.Error()}
+	}
+	var res threadPoolResponse
+	if err := fastjson.Unmarshal(b, &res); err != nil {
+		return nil, &jsonrpc.Error{Code: jsonrpc.ErrorCodeInternal, Message: err.Error()}
+	}
+	return res.Result, res.Error
+}
+
+// HandleReq serve a request buffer and return the response buffer, the request could be compressed or a batch
+func (o *CThreadPool) HandleReq(req []byte) []byte {
+	compressed := jsonrpc.IsCompress(req)
+	if compressed {
+		req = jsonrpc.Uncompress(req)
+	}
+	var b []byte
+	rs, batch, rerr := jsonrpc.ParseRequestBytes(req)
+	if rerr != nil {
+		b, _ = jsonrpc.GetResponseBytes([]*jsonrpc.Response{{Version: jsonrpc.Version, Error: rerr}}, false)
+	} else {
+		resp := make([]*jsonrpc.Response, len(rs))
+		for i, r := range rs {
+			resp[i] = jsonrpc.NewResponse(r)
+			resp[i].Result, resp[i].Error = o.route(r)
+			if resp[i].Error != nil {
+				resp[i].Result = nil
+			}
+		}
+		b, _ = jsonrpc.GetResponseBytes(resp, batch)
+	}
+	if compressed {
+		return jsonrpc.Compress(b)
+	}
+	return b
+}
+
+func (o *CThreadPool) route(r *jsonrpc.Request) (interface{}, *jsonrpc.Error) {
+	var p map[string]*fastjson.RawMessage
+	if r.Params != nil {
+		fastjson.Unmarshal(*r.Params, &p)
+	}
+
+	if threadPoolFirstWorkerRpc[r.Method] {
+		return o.routeTo(0, r, r.Params)
+	}
+	if r.Method == "ctx_iter" {
+		return o.iterNs(r, p)
+	}
+	if r.Method == "ctx_cnt" {
+		return o.counters(r, p)
+	}
+	if threadPoolCaptureRpc[r.Method] {
+		var c ApiCaptureStopParams
+		w := 0
+		if r.Params != nil && fastjson.Unmarshal(*r.Params, &c) == nil {
+			w = int(c.Id >> threadPoolCaptureIdShift)
+		}
+		if w >= len(o.Workers) {
+			o.stats.rpcErr++
+			return nil, &jsonrpc.Error{Code: jsonrpc.ErrorCodeInvalidRequest, Message: fmt.Sprintf("capture %d does not exist", c.Id)}
+		}
+		return o.routeTo(w, r, r.Params)
+	}
+	if raw, ok := p["tun"]; ok && raw != nil {
+		var tun CTunnelDataJson
+		var key CTunnelKey
+		if fastjson.Unmarshal(*raw, &tun) == nil {
+			key.SetJson(&tun)
+		}
+		return o.routeTo(o.WorkerOf(&key), r, r.Params)
+	}
+	if raw, ok := p["tunnels"]; ok && raw != nil {
+		return o.split(r, p, raw)
+	}
+	if r.Method == "ctx_pcapng_start" && len(o.Workers) > 1 {
+		o.stats.rpcErr++
+		return nil, &jsonrpc.Error{Code: jsonrpc.ErrorCodeInvalidRequest, Message: "tun is required with several threads"}
+	}
+	return o.broadcast(r)
+}
+
+func (o *CThreadPool) routeTo(w int, r *jsonrpc.Request, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	o.stats.rpcRouted++
+	return o.call(w, r, params)
+}
+
+// split send each worker the tunnels it owns, a result per tunnel is merged in the order of the tunnels
+func (o *CThreadPool) split(r *jsonrpc.Request, p map[string]*fastjson.RawMessage, raw *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var tunnels []*fastjson.RawMessage
+	if err := fastjson.Unmarshal(*raw, &tunnels); err != nil {
+		// let the worker report the error
+		return o.routeTo(0, r, r.Params)
+	}
+	o.stats.rpcSplit++
+	shares := make([][]*fastjson.RawMessage, len(o.Workers))
+	index := make([][]int, len(o.Workers))
+	for i, t := range tunnels {
+		var tun CTunnelDataJson
+		var key CTunnelKey
+		if t != nil && fastjson.Unmarshal(*t, &tun) == nil {
+			key.SetJson(&tun)
+		}
+		w := o.WorkerOf(&key)
+		shares[w] = append(shares[w], t)
+		index[w] = append(index[w], i)
+	}
+
+	if r.Method == "ctx_remove" {
+		// a removed namespace can't be restored, check all the shares first
+		if err := o.splitCheckRemove(r, p, shares); err != nil {
+			return nil, err
+		}
+	}
+
+	merged := make([]*fastjson.RawMessage, len(tunnels))
+	var first *fastjson.RawMessage
+	isArray := true
+	for w := range o.Workers {
+		if len(shares[w]) == 0 {
+			continue
+		}
+		res, err := o.call(w, r, splitParams(p, shares[w]))
+		if err != nil {
+			if undo, ok := threadPoolSplitUndo[r.Method]; ok {
+				o.splitUndo(undo, r, p, shares[:w])
+			}
+			return nil, err
+		}
+		if first == nil {
+			first = res
+		}
+		// a result per tunnel
+		var v []*fastjson.RawMessage
+		if res == nil || fastjson.Unmarshal(*res, &v) != nil || len(v) != len(index[w]) {
+			isArray = false
+			continue
+		}
+		for j, i := range index[w] {
+			merged[i] = v[j]
+		}
+	}
+	if !isArray {
+		if first == nil {
+			return nil, nil
+		}
+		return first, nil
+	}
+	return merged, nil
+}
+
+// splitParams return the params of a split request with the tunnels of one worker
+func splitParams(p map[string]*fastjson.RawMessage, share []*fastjson.RawMessage) *fastjson.RawMessage {
+	b, _ := fastjson.Marshal(share)
+	tunnels := fastjson.RawMessage(b)
+	p["tunnels"] = &tunnels
+	b, _ = fastjson.Marshal(p)
+	params := fastjson.RawMessage(b)
+	return &params
+}
+
+// splitCheckRemove check that the namespaces of all the shares exist without clients
+func (o *CThreadPool) splitCheckRemove(r *jsonrpc.Request, p map[string]*fastjson.RawMessage, shares [][]*fastjson.RawMessage) *jsonrpc.Error {
+	info := jsonrpc.Request{Method: "ctx_get_info", ID: r.ID}
+	for w := range o.Workers {
+		if len(shares[w]) == 0 {
+			continue
+		}
+		res, err := o.call(w, &info, splitParams(p, shares[w]))
+		if err != nil {
+			return err
+		}
+		var v []CNsInfo
+		if res == nil || fastjson.Unmarshal(*res, &v) != nil {
+			return &jsonrpc.Error{Code: jsonrpc.ErrorCodeInternal, Message: "invalid namespace info"}
+		}
+		for _, ns := range v {
+			if ns.ActiveClients > 0 {
+				return &jsonrpc.Error{
+					Code:    jsonrpc.ErrorCodeInvalidRequest,
+					Message: fmt.Sprintf("ns with tunnel vport %d tci %v still has active clients, remove them", ns.Port, ns.Tci),
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// splitUndo send the undo rpc to the workers that applied their shares
+func (o *CThreadPool) splitUndo(undo string, r *jsonrpc.Request, p map[string]*fastjson.RawMessage, shares [][]*fastjson.RawMessage) {
+	o.stats.rpcSplitUndo++
+	req := jsonrpc.Request{Method: undo, ID: r.ID}
+	for w := range shares {
+		if len(shares[w]) == 0 {
+			continue
+		}
+		o.call(w, &req, splitParams(p, shares[w]))
+	}
+}
+
+// broadcast send the request to all the workers, the array results (or objects of arrays) are concatenated
+func (o *CThreadPool) broadcast(r *jsonrpc.Request) (interface{}, *jsonrpc.Error) {
+	o.stats.rpcBroadcast++
+	var first *fastjson.RawMessage
+	var vec []*fastjson.RawMessage
+	obj := make(map[string][]*fastjson.RawMessage)
+	isArray, isObj := true, true
+	for w := range o.Workers {
+		res, err := o.call(w, r, r.Params)
+		if err != nil {
+			return nil, err
+		}
+		if w == 0 {
+			first = res
+		}
+		var v []*fastjson.RawMessage
+		if res == nil || fastjson.Unmarshal(*res, &v) != nil {
+			isArray = false
+		}
+		vec = append(vec, v...)
+		var m map[string][]*fastjson.RawMessage
+		if res == nil || fastjson.Unmarshal(*res, &m) != nil {
+			isObj = false
+		}
+		for k, v := range m {
+			obj[k] = append(obj[k], v...)
+		}
+	}
+	if isArray {
+		return vec, nil
+	}
+	if isObj {
+		return obj, nil
+	}
+	if first == nil {
+		return true, nil
+	}
+	return first, nil
+}
+
+// iterNs iterate the namespaces of the workers one after the other
+func (o *CThreadPool) iterNs(r *jsonrpc.Request, p map[string]*fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var params ApiNsIterParams
+	if r.Params == nil || fastjson.Unmarshal(*r.Params, &params) != nil {
+		// let the worker report the error
+		return o.routeTo(0, r, r.Params)
+	}
+	if params.Reset {
+		o.iterWorker = 0
+		for i := range o.iterReset {
+			o.iterReset[i] = true
+		}
+	}
+	o.stats.rpcRouted++
+	res := ApiNsIterResult{Vec: make([]*CTunnelDataJson, 0)}
+	for o.iterWorker < len(o.Workers) && len(res.Vec) < int(params.Count) {
+		w := o.iterWorker
+		count := params.Count - uint16(len(res.Vec))
+		b, _ := fastjson.Marshal(o.iterReset[w])
+		reset := fastjson.RawMessage(b)
+		b, _ = fastjson.Marshal(count)
+		cnt := fastjson.RawMessage(b)
+		p["reset"] = &reset
+		p["count"] = &cnt
+		b, _ = fastjson.Marshal(p)
+		sub := fastjson.RawMessage(b)
+		o.iterReset[w] = false
+
+		raw, err := o.call(w, r, &sub)
+		if err != nil {
+			return nil, err
+		}
+		var wres ApiNsIterResult
+		if raw != nil {
+			fastjson.Unmarshal(*raw, &wres)
+		}
+		res.Vec = append(res.Vec, wres.Vec...)
+		if wres.Empty || wres.Stopped || len(wres.Vec) < int(count) {
+			o.iterWorker++
+		}
+	}
+	if len(res.Vec) == 0 {
+		if params.Reset {
+			res.Empty = true
+		} else {
+			res.Stopped = true
+		}
+	}
+	return &res, nil
+}
+
+// counters sum the counters of all the workers
+func (o *CThreadPool) counters(r *jsonrpc.Request, p map[string]*fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var params ApiCntParams
+	if r.Params != nil {
+		fastjson.Unmarshal(*r.Params, &params)
+	}
+	o.stats.rpcBroadcast++
+
+	if params.Meta {
+		res, err := o.call(0, r, r.Params)
+		if err != nil {
+			return nil, err
+		}
+		var m map[string]interface{}
+		if res != nil {
+			fastjson.Unmarshal(*res, &m)
+		}
+		if m == nil {
+			m = make(map[string]interface{})
+		}
+		m[o.cdb.Name] = o.cdb
+		return m, nil
+	}
+
+	total := make(map[string]map[string]interface{})
+	for w := range o.Workers {
+		res, err := o.call(w, r, r.Params)
+		if err != nil {
+			return nil, err
+		}
+		if params.Clear || res == nil {
+			continue
+		}
+		var m map[string]map[string]fastjson.Number
+		dec := fastjson.NewDecoder(bytes.NewReader(*res))
+		dec.UseNumber()
+		if err := dec.Decode(&m); err != nil {
+			return nil, &jsonrpc.Error{Code: jsonrpc.ErrorCodeInternal, Message: err.Error()}
+		}
+		for table, cnts := range m {
+			if total[table] == nil {
+				total[table] = make(map[string]interface{})
+			}
+			for name, v := range cnts {
+				total[table][name] = threadPoolAddNumber(total[table][name], v)
+			}
+		}
+	}
+	if params.Clear {
+		o.cdb.ClearValues()
+		return true, nil
+	}
+
+	mask := len(params.Mask) == 0
+	for _, name := range params.Mask {
+		if name == o.cdb.Name {
+			mask = true
+		}
+	}
+	if mask {
+		if v := o.cdb.MarshalValues(params.Zero); len(v) > 0 {
+			total[o.cdb.Name] = v
+		}
+	}
+	return total, nil
+}
+
+// threadPoolAddNumber add a json number to a counter, uint64 in case both are integers
+func threadPoolAddNumber(sum interface{}, v fastjson.Number) interface{} {
+	if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+		switch s := sum.(type) {
+		case nil:
+			return u
+		case uint64:
+			return s + u
+		}
+	}
+	f, _ := v.Float64()
+	switch s := sum.(type) {
+	case uint64:
+		return float64(s) + f
+	case float64:
+		return s + f
+	}
+	return f
+}
@@ -0,0 +1,211 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/intel-go/fastjson"
+)
+
+type threadPoolTestRes struct {
+	Result *fastjson.RawMessage `json:"result"`
+	Error  *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func threadPoolCall(t *testing.T, pool *CThreadPool, method string, params string) *fastjson.RawMessage {
+	api := pool.Workers[0].apiHandler
+	req := fmt.Sprintf(`{"jsonrpc": "2.0", "method": "%s", "params": {"api_h": "%s"%s}, "id": 1}`, method, api, params)
+	var res threadPoolTestRes
+	if err := fastjson.Unmarshal(pool.HandleReq([]byte(req)), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Error != nil {
+		t.Fatalf(" ERROR %s failed: %s ", method, res.Error.Message)
+	}
+	return res.Result
+}
+
+func threadPoolCallErr(t *testing.T, pool *CThreadPool, method string, params string) {
+	api := pool.Workers[0].apiHandler
+	req := fmt.Sprintf(`{"jsonrpc": "2.0", "method": "%s", "params": {"api_h": "%s"%s}, "id": 1}`, method, api, params)
+	var res threadPoolTestRes
+	if err := fastjson.Unmarshal(pool.HandleReq([]byte(req)), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Error == nil {
+		t.Fatalf(" ERROR %s should fail ", method)
+	}
+}
+
+func threadPoolNsCnt(pool *CThreadPool) int {
+	cnt := 0
+	for _, w := range pool.Workers {
+		cnt += len(w.mapNs)
+	}
+	return cnt
+}
+
+func TestThreadPoolRpc(t *testing.T) {
+	pool := NewThreadPool(4, 4530, false)
+	defer pool.Delete()
+	done := make(chan bool)
+	go func() {
+		pool.MainLoop()
+		done <- true
+	}()
+
+	// 64 vlans spread over the workers
+	tunnels := ""
+	for i := 1; i <= 64; i++ {
+		if i > 1 {
+			tunnels += ","
+		}
+		tunnels += fmt.Sprintf(`{"vport": 1, "tci": [%d, 0]}`, i)
+	}
+	res := threadPoolCall(t, pool, "ctx_add", fmt.Sprintf(`, "tunnels": [%s]`, tunnels))
+	if res == nil || string(*res) != "true" {
+		t.Fatalf(" ERROR expected the result of the workers ")
+	}
+	for i, w := range pool.Workers {
+		if len(w.mapNs) == 0 || len(w.mapNs) == 64 {
+			t.Fatalf(" ERROR worker %d has %d namespaces ", i, len(w.mapNs))
+		}
+	}
+
+	// the info is in the order of the tunnels
+	var info []CNsInfo
+	res = threadPoolCall(t, pool, "ctx_get_info", `, "tunnels": [{"vport": 1, "tci": [7, 0]}, {"vport": 1, "tci": [3, 0]}, {"vport": 1, "tci": [50, 0]}]`)
+	if err := fastjson.Unmarshal(*res, &info); err != nil {
+		t.Fatal(err)
+	}
+	if len(info) != 3 || info[0].Tci[0] != 7 || info[1].Tci[0] != 3 || info[2].Tci[0] != 50 {
+		t.Fatalf(" ERROR unexpected ns info %+v ", info)
+	}
+
+	// tun routed
+	threadPoolCall(t, pool, "ctx_client_add", `, "tun": {"vport": 1, "tci": [50, 0]}, "clients": [{"mac": [0, 0, 1, 0, 0, 1]}]`)
+	var key CTunnelKey
+	key.SetJson(&CTunnelDataJson{Vport: 1, Tci: [2]uint16{50, 0}})
+	ns := pool.Workers[pool.WorkerOf(&key)].GetNs(&key)
+	if ns == nil || ns.CLookupByMac(&MACKey{0, 0, 1, 0, 0, 1}) == nil {
+		t.Fatalf(" ERROR the client should be added to the namespace worker ")
+	}
+
+	// iterate all the workers
+	seen := make(map[uint16]bool)
+	iter := `, "reset": true, "count": 10`
+	for j := 0; j < 20; j++ {
+		var r ApiNsIterResult
+		res = threadPoolCall(t, pool, "ctx_iter", iter)
+		if err := fastjson.Unmarshal(*res, &r); err != nil {
+			t.Fatal(err)
+		}
+		if r.Stopped {
+			break
+		}
+		for _, tun := range r.Vec {
+			seen[tun.Tci[0]] = true
+		}
+		iter = `, "count": 10`
+	}
+	if len(seen) != 64 {
+		t.Fatalf(" ERROR expected 64 namespaces in the iteration, got %d ", len(seen))
+	}
+
+	// counters are summed
+	var cnt map[string]map[string]uint64
+	res = threadPoolCall(t, pool, "ctx_cnt", `, "mask": ["ctx", "pool"]`)
+	if err := fastjson.Unmarshal(*res, &cnt); err != nil {
+		t.Fatal(err)
+	}
+	if cnt["ctx"]["addNs"] != 64 || cnt["pool"]["rpcSplit"] != 2 {
+		t.Fatalf(" ERROR unexpected counters %+v ", cnt)
+	}
+
+	// a failed split is not applied on any worker
+	threadPoolCallErr(t, pool, "ctx_add", `, "tunnels": [{"vport": 1, "tci": [100, 0]}, {"vport": 1, "tci": [101, 0]}, {"vport": 1, "tci": [102, 0]}, {"vport": 1, "tci": [103, 0]}, {"vport": 1, "tci": [1, 0]}]`)
+	if threadPoolNsCnt(pool) != 64 {
+		t.Fatalf(" ERROR ctx_add should be rolled back, %d namespaces ", threadPoolNsCnt(pool))
+	}
+	if pool.stats.rpcSplitUndo != 1 {
+		t.Fatalf(" ERROR ctx_add should be undone on the workers that applied it ")
+	}
+	threadPoolCallErr(t, pool, "ctx_remove", fmt.Sprintf(`, "tunnels": [%s]`, tunnels))
+	if threadPoolNsCnt(pool) != 64 {
+		t.Fatalf(" ERROR ctx_remove with active clients should not remove namespaces, %d namespaces ", threadPoolNsCnt(pool))
+	}
+
+	threadPoolCall(t, pool, "ctx_client_remove", `, "tun": {"vport": 1, "tci": [50, 0]}, "macs": [[0, 0, 1, 0, 0, 1]]`)
+	threadPoolCall(t, pool, "ctx_remove", fmt.Sprintf(`, "tunnels": [%s]`, tunnels))
+	for i, w := range pool.Workers {
+		if len(w.mapNs) != 0 {
+			t.Fatalf(" ERROR worker %d still has namespaces ", i)
+		}
+	}
+
+	threadPoolCall(t, pool, "shutdown", ``)
+	<-done
+}
+
+func TestThreadPoolSteer(t *testing.T) {
+	pool := NewThreadPool(3, 4531, false)
+	defer pool.Delete()
+	done := make(chan bool)
+	go func() {
+		pool.MainLoop()
+		done <- true
+	}()
+
+	pkts := 0
+	expected := make([]uint64, 3)
+	msg := make([]byte, 4)
+	for vlan := uint16(1); vlan <= 30; vlan++ {
+		pkt := buildCaptureUdpIPv4(vlan, 1025, 53)
+		var hdr [4]byte
+		binary.BigEndian.PutUint32(hdr[:], 0xAA000000|uint32(1)<<16|uint32(len(pkt)))
+		msg = append(msg, hdr[:]...)
+		msg = append(msg, pkt...)
+		pkts++
+
+		var key CTunnelKey
+		key.SetJson(&CTunnelDataJson{Vport: 1, Tci: [2]uint16{vlan, 0}})
+		expected[pool.WorkerOf(&key)]++
+	}
+	binary.BigEndian.PutUint32(msg[0:4], uint32(ZMQ_PACKET_HEADER_MAGIC)<<16|uint32(pkts))
+	pool.steer(msg)
+	pool.steer([]byte{1, 2})
+
+	// wait for the workers to handle the steered messages
+	for j := 0; j < 100; j++ {
+		var cnt map[string]map[string]uint64
+		res := threadPoolCall(t, pool, "ctx_cnt", `, "mask": ["veth"]`)
+		if err := fastjson.Unmarshal(*res, &cnt); err != nil {
+			t.Fatal(err)
+		}
+		if cnt["veth"]["RxPkts"] == 30 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	threadPoolCall(t, pool, "shutdown", ``)
+	<-done
+
+	for i, veth := range pool.veths {
+		if veth.stats.RxPkts != expected[i] {
+			t.Fatalf(" ERROR worker %d got %d packets, expected %d ", i, veth.stats.RxPkts, expected[i])
+		}
+	}
+	if pool.stats.rxSteerPkts != 30 || pool.stats.rxSteerErr != 1 {
+		t.Fatalf(" ERROR unexpected steer counters %+v ", pool.stats)
+	}
+}
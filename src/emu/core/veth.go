@@ -6,6 +6,7 @@
 package core
 
 import (
+	"encoding/binary"
 	"encoding/json"
 	"io"
 	"os"
@@ -21,7 +22,9 @@ type VethStats struct {
 	RxBatch          uint64
 	TxBatch          uint64
 	TxDropNotResolve uint64 /* no resolved dg */
-
+	TxDropNoPort     uint64 /* no interface for the vport */
+	RxSocketErr      uint64
+	TxSocketErr      uint64
 }
 
 func NewVethStatsDb(o *VethStats) *CCounterDb {
@@ -99,9 +102,100 @@ func NewVethStatsDb(o *VethStats) *CCounterDb {
 		DumpZero: false,
 		Info:     ScERROR})
 
+	db.Add(&CCounterRec{
+		Counter:  &o.TxDropNoPort,
+		Name:     "TxDropNoPort",
+		Help:     "TxDropNoPort",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.RxSocketErr,
+		Name:     "RxSocketErr",
+		Help:     "RxSocketErr",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.TxSocketErr,
+		Name:     "TxSocketErr",
+		Help:     "TxSocketErr",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScERROR})
+
 	return db
 }
 
+// vethParseRxStream parse a batch of rx packets in the veth message format (see veth_zmq.go) and call onRx for each packet
+func vethParseRxStream(tctx *CThreadCtx, stats *VethStats, stream []byte, onRx func(m *Mbuf)) {
+	stats.RxBatch++
+	blen := uint32(len(stream))
+	if blen < 4 {
+		stats.RxParseErr++
+		return
+	}
+	header := binary.BigEndian.Uint32(stream[0:4])
+	if ((header & 0xffff0000) >> 16) != ZMQ_PACKET_HEADER_MAGIC {
+		stats.RxParseErr++
+		return
+	}
+	pkts := int(header & 0xffff)
+	var of uint32
+	of = 4
+	var vport uint8
+	var pktLen uint32
+	var m *Mbuf
+	for i := 0; i < pkts; i++ {
+		if blen < of+4 {
+			stats.RxParseErr++
+			return
+		}
+
+		header = binary.BigEndian.Uint32(stream[of : of+4])
+		if (header & 0xff000000) != 0xAA000000 {
+			stats.RxParseErr++
+			return
+		}
+
+		vport = uint8((header & 0x00ff0000) >> 16)
+		pktLen = header & 0x0000ffff
+		if blen < of+4+pktLen {
+			stats.RxParseErr++
+			return
+		}
+
+		m = tctx.MPool.Alloc(uint16(pktLen))
+		m.SetVPort(uint16(vport))
+		m.Append(stream[of+4 : of+4+pktLen])
+		onRx(m)
+		of = of + 4 + pktLen
+	}
+}
+
+// vethBuildTxStream append the packets to buf in the ZMQ message format and free them, onTx is called for each packet in case it is not nil
+func vethBuildTxStream(buf []byte, vec []*Mbuf, onTx func(m *Mbuf)) []byte {
+	var pkth [4]byte
+	binary.BigEndian.PutUint32(pkth[:], (uint32(ZMQ_PACKET_HEADER_MAGIC)<<16)+uint32(len(vec)))
+	buf = append(buf, pkth[:]...) // message header
+
+	for _, m := range vec {
+		if !m.IsContiguous() {
+			panic(" mbuf should be contiguous  ")
+		}
+		if onTx != nil {
+			onTx(m)
+		}
+		binary.BigEndian.PutUint32(pkth[:], (uint32(0xAA)<<24)+uint32((m.VPort()&0xff))<<16+uint32(m.pktLen&0xffff))
+		buf = append(buf, pkth[:]...)     // packet header
+		buf = append(buf, m.GetData()...) // packet itself
+		m.FreeMbuf()
+	}
+	return buf
+}
+
 /*VethIF represent a way to send and receive packet */
 type VethIF interface {
 
@@ -192,8 +286,10 @@ func (o *VethIFSimulator) AppendSimuationRPC(request []byte) {
 
 func (o *VethIFSimulator) Send(m *Mbuf) {
 
+	m = o.tctx.encapPPPoE(m)
 	o.stats.TxPkts++
 	o.stats.TxBytes += uint64(m.PktLen())
+	o.tctx.capture.OnPacket(m, false)
 	if !m.IsContiguous() {
 		m1 := m.GetContiguous(&o.tctx.MPool)
 		m.FreeMbuf()
@@ -0,0 +1,340 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+VethIFAfPacket send and receive packets directly on Linux interfaces using AF_PACKET sockets, there is no need for a TRex server.
+
+Each vport is mapped to one interface. A thread per interface reads a burst of packets with recvmmsg and hands it
+to the main loop in the same message format of the ZMQ veth. The tx packets are queued per interface and sent in a burst
+with sendmmsg on FlushTx.
+
+The sockets have a receive timeout, so a thread that waits for packets checks the stop channel every
+AFPACKET_RX_TIMEOUT. The cleanup stops the threads and waits for them to exit before it closes the sockets.
+
+	var veth core.VethIFAfPacket
+	ports, err := core.ParseAfPacketPorts("0:veth0,1:veth1")
+	err = veth.Create(tctx, ports)
+	veth.StartRxThread()
+	tctx.SetZmqVeth(&veth)
+*/
+
+const (
+	AFPACKET_PKT_BURST_SIZE = 64 // max packets per recvmmsg/sendmmsg
+	AFPACKET_MAX_VPORT      = 0xff
+	AFPACKET_RX_TIMEOUT     = 100 * time.Millisecond // max time a rx thread is blocked before it checks the stop channel
+)
+
+type afPacketPort struct {
+	vport   uint16
+	ifname  string
+	ifindex int
+	fd      int
+	vec     []*Mbuf // tx queue
+}
+
+type VethIFAfPacket struct {
+	ports       map[uint16]*afPacketPort
+	cn          chan []byte
+	stop        chan struct{} // closed to stop the rx threads
+	rxWg        sync.WaitGroup
+	rxSocketErr uint64 // counted by the rx threads, atomic
+	stats       VethStats
+	tctx        *CThreadCtx
+	K12Monitor  bool     // K12 packet monitoring to monitorDest
+	monitorFile *os.File // File to print the K12 packet captured. Default is stdout.
+	cdb         *CCounterDb
+}
+
+// ParseAfPacketPorts parse a list of vport:interface separated by commas, an interface without a vport gets its index in the list.
+func ParseAfPacketPorts(s string) (map[uint16]string, error) {
+	ports := make(map[uint16]string)
+	names := make(map[string]bool)
+	for i, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			return nil, fmt.Errorf("empty interface in %q", s)
+		}
+		vport := i
+		ifname := f
+		if sep := strings.Index(f, ":"); sep >= 0 {
+			v, err := strconv.Atoi(f[:sep])
+			if err != nil {
+				return nil, fmt.Errorf("invalid vport in %q", f)
+			}
+			vport = v
+			ifname = f[sep+1:]
+		}
+		if vport < 0 || vport > AFPACKET_MAX_VPORT {
+			return nil, fmt.Errorf("vport %d in %q is out of range", vport, f)
+		}
+		if ifname == "" {
+			return nil, fmt.Errorf("empty interface in %q", f)
+		}
+		if _, ok := ports[uint16(vport)]; ok {
+			return nil, fmt.Errorf("vport %d is mapped twice", vport)
+		}
+		if names[ifname] {
+			return nil, fmt.Errorf("interface %s is mapped twice", ifname)
+		}
+		ports[uint16(vport)] = ifname
+		names[ifname] = true
+	}
+	return ports, nil
+}
+
+// Create open a socket for each interface, ports maps a vport to an interface name
+func (o *VethIFAfPacket) Create(ctx *CThreadCtx, ports map[uint16]string) error {
+	o.tctx = ctx
+	o.cn = make(chan []byte)
+	o.stop = make(chan struct{})
+	o.cdb = NewVethStatsDb(&o.stats)
+	o.ports = make(map[uint16]*afPacketPort)
+
+	for vport, ifname := range ports {
+		port := &afPacketPort{vport: vport, ifname: ifname, fd: -1}
+		o.ports[vport] = port
+		if err := port.open(); err != nil {
+			o.closePorts()
+			return fmt.Errorf("could not open AF_PACKET socket on %s: %s", ifname, err.Error())
+		}
+		port.vec = make([]*Mbuf, 0, AFPACKET_PKT_BURST_SIZE)
+	}
+	return nil
+}
+
+// String return the vport to interface map, ordered by vport
+func (o *VethIFAfPacket) String() string {
+	vports := make([]int, 0, len(o.ports))
+	for vport := range o.ports {
+		vports = append(vports, int(vport))
+	}
+	sort.Ints(vports)
+	var s []string
+	for _, vport := range vports {
+		s = append(s, fmt.Sprintf("%d:%s", vport, o.ports[uint16(vport)].ifname))
+	}
+	return strings.Join(s, ",")
+}
+
+func (o *VethIFAfPacket) closePorts() {
+	for _, port := range o.ports {
+		port.close()
+	}
+}
+
+func (o *VethIFAfPacket) StartRxThread() {
+	for _, port := range o.ports {
+		o.rxWg.Add(1)
+		go o.rxThread(port, o.stop)
+	}
+}
+
+// stopRxThreads stop the rx threads and wait for them to exit
+func (o *VethIFAfPacket) stopRxThreads() {
+	if o.stop == nil {
+		return
+	}
+	close(o.stop)
+	o.rxWg.Wait()
+	o.stop = nil
+	o.syncRxStats()
+}
+
+// syncRxStats copy the counters of the rx threads to the stats of the main thread
+func (o *VethIFAfPacket) syncRxStats() {
+	o.stats.RxSocketErr = atomic.LoadUint64(&o.rxSocketErr)
+}
+
+// rxThread read bursts of packets from one interface and send them to the main loop
+func (o *VethIFAfPacket) rxThread(port *afPacketPort, stop chan struct{}) {
+	defer o.rxWg.Done()
+	bufs := make([][]byte, AFPACKET_PKT_BURST_SIZE)
+	for i := range bufs {
+		bufs[i] = make([]byte, MAX_PACKET_SIZE)
+	}
+	lens := make([]int, AFPACKET_PKT_BURST_SIZE)
+
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		cnt, err := port.recvBurst(bufs, lens)
+		if err != nil {
+			atomic.AddUint64(&o.rxSocketErr, 1)
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		if cnt == 0 {
+			continue
+		}
+		size := 4
+		for i := 0; i < cnt; i++ {
+			size += 4 + lens[i]
+		}
+		msg := make([]byte, 4, size)
+		binary.BigEndian.PutUint32(msg[0:4], (uint32(ZMQ_PACKET_HEADER_MAGIC)<<16)+uint32(cnt))
+		var pkth [4]byte
+		for i := 0; i < cnt; i++ {
+			binary.BigEndian.PutUint32(pkth[:], (uint32(0xAA)<<24)+uint32(port.vport&0xff)<<16+uint32(lens[i]))
+			msg = append(msg, pkth[:]...)
+			msg = append(msg, bufs[i][:lens[i]]...)
+		}
+		select {
+		case o.cn <- msg:
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (o *VethIFAfPacket) GetC() chan []byte {
+	return o.cn
+}
+
+func (o *VethIFAfPacket) flushPort(port *afPacketPort) {
+	if len(port.vec) == 0 {
+		return
+	}
+	o.stats.TxBatch++
+	if o.K12Monitor {
+		for _, m := range port.vec {
+			m.DumpK12(o.tctx.GetTickSimInSec(), o.monitorFile)
+		}
+	}
+	sent, err := port.sendBurst(port.vec)
+	if err != nil {
+		o.stats.TxSocketErr += uint64(len(port.vec) - sent)
+	}
+	for _, m := range port.vec {
+		m.FreeMbuf()
+	}
+	port.vec = port.vec[:0]
+}
+
+func (o *VethIFAfPacket) FlushTx() {
+	o.syncRxStats()
+	for _, port := range o.ports {
+		o.flushPort(port)
+	}
+}
+
+func (o *VethIFAfPacket) Send(m *Mbuf) {
+	m = o.tctx.encapPPPoE(m)
+	port, ok := o.ports[m.VPort()]
+	if !ok {
+		o.stats.TxDropNoPort++
+		m.FreeMbuf()
+		return
+	}
+
+	o.stats.TxPkts++
+	o.stats.TxBytes += uint64(m.PktLen())
+	o.tctx.capture.OnPacket(m, false)
+
+	if !m.IsContiguous() {
+		m1 := m.GetContiguous(&o.tctx.MPool)
+		m.FreeMbuf()
+		port.vec = append(port.vec, m1)
+	} else {
+		port.vec = append(port.vec, m)
+	}
+	if len(port.vec) == AFPACKET_PKT_BURST_SIZE {
+		o.flushPort(port)
+	}
+}
+
+// SendBuffer get a buffer as input, should allocate mbuf and call send
+func (o *VethIFAfPacket) SendBuffer(unicast bool, c *CClient, b []byte, ipv6 bool) {
+	var vport uint16
+	vport = c.Ns.GetVport()
+	m := o.tctx.MPool.Alloc(uint16(len(b)))
+	m.SetVPort(vport)
+	m.Append(b)
+	if unicast {
+		var dgMac MACKey
+		var ok bool
+		if ipv6 {
+			dgMac, ok = c.ResolveIPv6DGMac()
+		} else {
+			dgMac, ok = c.ResolveIPv4DGMac()
+		}
+		if !ok {
+			m.FreeMbuf()
+			o.stats.TxDropNotResolve++
+			return
+		} else {
+			p := m.GetData()
+			copy(p[6:12], c.Mac[:])
+			copy(p[0:6], dgMac[:])
+		}
+	}
+	o.Send(m)
+}
+
+// get the packet
+func (o *VethIFAfPacket) OnRx(m *Mbuf) {
+	o.stats.RxPkts++
+	o.stats.RxBytes += uint64(m.PktLen())
+	if o.K12Monitor {
+		io.WriteString(o.monitorFile, "\n ->RX<- \n")
+		m.DumpK12(o.tctx.GetTickSimInSec(), o.monitorFile)
+	}
+	o.tctx.HandleRxPacket(m)
+}
+
+func (o *VethIFAfPacket) OnRxStream(stream []byte) {
+	vethParseRxStream(o.tctx, &o.stats, stream, o.OnRx)
+}
+
+/* get the veth stats */
+func (o *VethIFAfPacket) GetStats() *VethStats {
+	o.syncRxStats()
+	return &o.stats
+}
+
+func (o *VethIFAfPacket) SimulatorCleanup() {
+	o.stopRxThreads()
+	for _, port := range o.ports {
+		for _, m := range port.vec {
+			m.FreeMbuf()
+		}
+		port.vec = nil
+	}
+	o.closePorts()
+}
+
+func (o *VethIFAfPacket) SetDebug(monitor bool, monitorFile *os.File, capture bool) {
+	o.K12Monitor = monitor
+	o.monitorFile = monitorFile
+}
+
+func (o *VethIFAfPacket) GetCdb() *CCounterDb {
+	return o.cdb
+}
+
+func (o *VethIFAfPacket) SimulatorCheckRxQueue() {
+
+}
+
+func (o *VethIFAfPacket) AppendSimuationRPC(request []byte) {
+	panic("AppendSimuationRPC should not be called ")
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+//go:build linux
+
+package core
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	afPacketAuxData        = 8       // PACKET_AUXDATA
+	afPacketIgnoreOutgoing = 23      // PACKET_IGNORE_OUTGOING, linux 4.20
+	afPacketOutgoing       = 4       // PACKET_OUTGOING
+	afMsgWaitForOne        = 0x10000 // MSG_WAITFORONE
+	afStatusVlanValid      = 0x10    // TP_STATUS_VLAN_VALID
+	afStatusVlanTpidValid  = 0x40    // TP_STATUS_VLAN_TPID_VALID
+	afAuxDataSize          = 20      // sizeof(struct tpacket_auxdata)
+	afVlanTagSize          = 4       // room for the vlan tag that the kernel strips
+	afEthTypeDot1Q         = 0x8100
+)
+
+type afMmsghdr struct {
+	hdr syscall.Msghdr
+	len uint32
+}
+
+type afPacketMreq struct {
+	ifindex int32
+	typ     uint16
+	alen    uint16
+	address [8]uint8
+}
+
+func afHtons(v uint16) uint16 {
+	return (v << 8) | (v >> 8)
+}
+
+func (o *afPacketPort) open() error {
+	ifi, err := net.InterfaceByName(o.ifname)
+	if err != nil {
+		return err
+	}
+	o.ifindex = ifi.Index
+
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, int(afHtons(syscall.ETH_P_ALL)))
+	if err != nil {
+		return err
+	}
+	o.fd = fd
+
+	sll := syscall.SockaddrLinklayer{Protocol: afHtons(syscall.ETH_P_ALL), Ifindex: ifi.Index}
+	if err = syscall.Bind(fd, &sll); err != nil {
+		o.close()
+		return err
+	}
+
+	// the clients have their own MAC addresses
+	mreq := afPacketMreq{ifindex: int32(ifi.Index), typ: syscall.PACKET_MR_PROMISC}
+	mreqb := (*[unsafe.Sizeof(mreq)]byte)(unsafe.Pointer(&mreq))
+	if err = syscall.SetsockoptString(fd, syscall.SOL_PACKET, syscall.PACKET_ADD_MEMBERSHIP, string(mreqb[:])); err != nil {
+		o.close()
+		return err
+	}
+
+	// the kernel strips the vlan tag, get it back from the aux data
+	if err = syscall.SetsockoptInt(fd, syscall.SOL_PACKET, afPacketAuxData, 1); err != nil {
+		o.close()
+		return err
+	}
+
+	// a blocked read returns so the rx thread could check the stop channel
+	tv := syscall.NsecToTimeval(int64(AFPACKET_RX_TIMEOUT))
+	if err = syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		o.close()
+		return err
+	}
+
+	// best effort, older kernels do not support it and the outgoing packets are filtered on rx
+	syscall.SetsockoptInt(fd, syscall.SOL_PACKET, afPacketIgnoreOutgoing, 1)
+	return nil
+}
+
+func (o *afPacketPort) close() {
+	if o.fd >= 0 {
+		syscall.Close(o.fd)
+		o.fd = -1
+	}
+}
+
+// afVlanFromAuxData return the vlan tag the kernel stripped from the packet, zero tpid in case there is no tag
+func afVlanFromAuxData(control []byte) (tpid uint16, tci uint16) {
+	msgs, err := syscall.ParseSocketControlMessage(control)
+	if err != nil {
+		return 0, 0
+	}
+	for _, msg := range msgs {
+		if msg.Header.Level != syscall.SOL_PACKET || msg.Header.Type != afPacketAuxData || len(msg.Data) < afAuxDataSize {
+			continue
+		}
+		status := *(*uint32)(unsafe.Pointer(&msg.Data[0]))
+		if status&afStatusVlanValid == 0 {
+			return 0, 0
+		}
+		tci = *(*uint16)(unsafe.Pointer(&msg.Data[16]))
+		tpid = afEthTypeDot1Q
+		if status&afStatusVlanTpidValid != 0 {
+			tpid = *(*uint16)(unsafe.Pointer(&msg.Data[18]))
+		}
+		return tpid, tci
+	}
+	return 0, 0
+}
+
+// recvBurst read up to len(bufs) packets, blocks until at least one packet is received. lens is updated with the packets size
+func (o *afPacketPort) recvBurst(bufs [][]byte, lens []int) (int, error) {
+	var msgs [AFPACKET_PKT_BURST_SIZE]afMmsghdr
+	var iovs [AFPACKET_PKT_BURST_SIZE]syscall.Iovec
+	var names [AFPACKET_PKT_BURST_SIZE]syscall.RawSockaddrLinklayer
+	control := make([]byte, AFPACKET_PKT_BURST_SIZE*syscall.CmsgSpace(afAuxDataSize))
+	cspace := syscall.CmsgSpace(afAuxDataSize)
+
+	n := len(bufs)
+	if n > AFPACKET_PKT_BURST_SIZE {
+		n = AFPACKET_PKT_BURST_SIZE
+	}
+	for i := 0; i < n; i++ {
+		iovs[i].Base = &bufs[i][0]
+		iovs[i].SetLen(len(bufs[i]) - afVlanTagSize)
+		msgs[i].hdr.Iov = &iovs[i]
+		msgs[i].hdr.Iovlen = 1
+		msgs[i].hdr.Name = (*byte)(unsafe.Pointer(&names[i]))
+		msgs[i].hdr.Namelen = uint32(unsafe.Sizeof(names[i]))
+		msgs[i].hdr.Control = &control[i*cspace]
+		msgs[i].hdr.SetControllen(cspace)
+	}
+
+	r, _, e := syscall.Syscall6(syscall.SYS_RECVMMSG, uintptr(o.fd), uintptr(unsafe.Pointer(&msgs[0])), uintptr(n), afMsgWaitForOne, 0, 0)
+	if e != 0 {
+		if e == syscall.EINTR || e == syscall.EAGAIN {
+			return 0, nil // interrupted or the receive timeout expired
+		}
+		return 0, e
+	}
+
+	cnt := 0
+	for i := 0; i < int(r); i++ {
+		if names[i].Pkttype == afPacketOutgoing || (msgs[i].hdr.Flags&syscall.MSG_TRUNC) != 0 {
+			continue
+		}
+		b := bufs[i]
+		l := int(msgs[i].len)
+		tpid, tci := afVlanFromAuxData(control[i*cspace : i*cspace+int(msgs[i].hdr.Controllen)])
+		if tpid != 0 && l >= 12 {
+			copy(b[12+afVlanTagSize:l+afVlanTagSize], b[12:l])
+			binary.BigEndian.PutUint16(b[12:14], tpid)
+			binary.BigEndian.PutUint16(b[14:16], tci)
+			l += afVlanTagSize
+		}
+		bufs[cnt], bufs[i] = bufs[i], bufs[cnt]
+		lens[cnt] = l
+		cnt++
+	}
+	return cnt, nil
+}
+
+// sendBurst send the packets with sendmmsg, return the number of packets that were sent
+func (o *afPacketPort) sendBurst(vec []*Mbuf) (int, error) {
+	var msgs [AFPACKET_PKT_BURST_SIZE]afMmsghdr
+	var iovs [AFPACKET_PKT_BURST_SIZE]syscall.Iovec
+
+	sent := 0
+	for sent < len(vec) {
+		n := len(vec) - sent
+		if n > AFPACKET_PKT_BURST_SIZE {
+			n = AFPACKET_PKT_BURST_SIZE
+		}
+		for i := 0; i < n; i++ {
+			p := vec[sent+i].GetData()
+			iovs[i].Base = &p[0]
+			iovs[i].SetLen(len(p))
+			msgs[i].hdr = syscall.Msghdr{}
+			msgs[i].hdr.Iov = &iovs[i]
+			msgs[i].hdr.Iovlen = 1
+		}
+		r, _, e := syscall.Syscall6(sysSendmmsg, uintptr(o.fd), uintptr(unsafe.Pointer(&msgs[0])), uintptr(n), 0, 0, 0)
+		if e != 0 {
+			if e == syscall.EINTR {
+				continue
+			}
+			return sent, e
+		}
+		sent += int(r)
+	}
+	return sent, nil
+}
@@ -0,0 +1,9 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+// the syscall package does not define SYS_SENDMMSG for amd64
+const sysSendmmsg = 307
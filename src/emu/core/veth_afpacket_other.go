@@ -0,0 +1,27 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+//go:build !linux
+
+package core
+
+import "errors"
+
+var errAfPacketNotSupported = errors.New("AF_PACKET is supported only on linux")
+
+func (o *afPacketPort) open() error {
+	return errAfPacketNotSupported
+}
+
+func (o *afPacketPort) close() {
+}
+
+func (o *afPacketPort) recvBurst(bufs [][]byte, lens []int) (int, error) {
+	return 0, errAfPacketNotSupported
+}
+
+func (o *afPacketPort) sendBurst(vec []*Mbuf) (int, error) {
+	return 0, errAfPacketNotSupported
+}
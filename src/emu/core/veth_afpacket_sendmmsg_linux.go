@@ -0,0 +1,12 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+//go:build linux && !amd64 && !386
+
+package core
+
+import "syscall"
+
+const sysSendmmsg = syscall.SYS_SENDMMSG
@@ -0,0 +1,90 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAfPacketParsePorts(t *testing.T) {
+	ports, err := ParseAfPacketPorts("veth0,veth1")
+	if err != nil || len(ports) != 2 || ports[0] != "veth0" || ports[1] != "veth1" {
+		t.Fatalf(" ERROR ports without vport %v %v ", ports, err)
+	}
+	ports, err = ParseAfPacketPorts("3:eth1, 7:eth2")
+	if err != nil || len(ports) != 2 || ports[3] != "eth1" || ports[7] != "eth2" {
+		t.Fatalf(" ERROR ports with vport %v %v ", ports, err)
+	}
+	for _, s := range []string{"", "0:eth0,0:eth1", "0:eth0,1:eth0", "a:eth0", "256:eth0", "1:", "eth0,,eth1"} {
+		if _, err = ParseAfPacketPorts(s); err == nil {
+			t.Fatalf(" ERROR %q should be invalid ", s)
+		}
+	}
+}
+
+// send a packet on the loopback interface and receive it back, requires CAP_NET_RAW
+func TestAfPacketLoopback(t *testing.T) {
+	tctx := NewThreadCtx(0, 4510, false, nil)
+	defer tctx.Delete()
+	var veth VethIFAfPacket
+	if err := veth.Create(tctx, map[uint16]string{5: "lo"}); err != nil {
+		t.Skipf("AF_PACKET is not available: %s", err.Error())
+	}
+	defer veth.SimulatorCleanup()
+	veth.StartRxThread()
+
+	pkt := make([]byte, 64)
+	copy(pkt, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0x88, 0xb5}) // local experimental ethertype
+	pkt[20] = 0xcd
+	m := tctx.MPool.Alloc(uint16(len(pkt)))
+	m.SetVPort(5)
+	m.Append(pkt)
+	veth.Send(m)
+	veth.FlushTx()
+	if veth.stats.TxPkts != 1 || veth.stats.TxSocketErr != 0 {
+		t.Fatalf(" ERROR tx %+v ", veth.stats)
+	}
+
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case msg := <-veth.GetC():
+			veth.OnRxStream(msg)
+			if veth.stats.RxPkts > 0 && tctx.parser.stats.errL3ProtoUnsupported > 0 {
+				return
+			}
+		case <-timeout:
+			t.Fatalf(" ERROR packet was not received %+v ", veth.stats)
+		}
+	}
+}
+
+// the cleanup stops the rx thread even when it is blocked on an idle interface or on a full channel
+func TestAfPacketStop(t *testing.T) {
+	tctx := NewThreadCtx(0, 4510, false, nil)
+	defer tctx.Delete()
+	var veth VethIFAfPacket
+	if err := veth.Create(tctx, map[uint16]string{5: "lo"}); err != nil {
+		t.Skipf("AF_PACKET is not available: %s", err.Error())
+	}
+	veth.StartRxThread()
+	time.Sleep(2 * AFPACKET_RX_TIMEOUT)
+
+	done := make(chan bool)
+	go func() {
+		veth.SimulatorCleanup()
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * AFPACKET_RX_TIMEOUT):
+		t.Fatalf(" ERROR the rx thread did not stop ")
+	}
+	if veth.ports[5].fd >= 0 {
+		t.Fatalf(" ERROR the socket should be closed after the rx thread exits ")
+	}
+}
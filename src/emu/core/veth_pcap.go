@@ -0,0 +1,201 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"bufio"
+	"encoding/binary"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"external/google/gopacket/pcapgo"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+/*
+VethIFPcap replay a pcap/pcapng file on rx in simulation and write the tx packets to a pcapng file.
+
+The rx packets are injected with their original relative timing against the simulated tick clock, the first packet
+is injected Offset after the start of the simulation. The tx packets are written with the same time base as the input
+file, so both files can be merged and compared. RPC requests are handled like in the simulator.
+
+	var veth core.VethIFPcap
+	err := veth.Create(tctx, "dhcp-server.pcap", "emu-tx.pcapng", 1)
+	tctx.SetZmqVeth(&veth)
+	tctx.MainLoopSim(time.Minute)
+*/
+
+const (
+	pcapNgMagic = 0x0A0D0D0A
+)
+
+type vethPcapPkt struct {
+	ts   time.Duration // relative to the first packet
+	data []byte
+}
+
+type VethIFPcap struct {
+	VethIFSimulator
+	Offset   time.Duration // delay of the first rx packet from the start of the simulation
+	vport    uint16
+	rx       []vethPcapPkt
+	rxIndex  int
+	baseTime time.Time // timestamp of the first rx packet
+	outFile  *os.File
+	out      *pcapgo.NgWriter
+}
+
+type vethPcapReader interface {
+	ReadPacketData() (data []byte, ci gopacket.CaptureInfo, err error)
+	LinkType() layers.LinkType
+}
+
+// Create load the input file, all the rx packets get vport. output could be empty in case there is no need for the tx packets
+func (o *VethIFPcap) Create(ctx *CThreadCtx, input string, output string, vport uint16) error {
+	o.VethIFSimulator.Create(ctx)
+	o.vport = vport
+
+	if err := o.load(input); err != nil {
+		return err
+	}
+
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		w, err := pcapgo.NewNgWriter(f, layers.LinkTypeEthernet)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		o.outFile = f
+		o.out = w
+	}
+	return nil
+}
+
+func (o *VethIFPcap) load(input string) error {
+	f, err := os.Open(input)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	magic, err := br.Peek(4)
+	if err != nil {
+		return fmt.Errorf("%s is not a pcap file: %s", input, err.Error())
+	}
+
+	var r vethPcapReader
+	if binary.BigEndian.Uint32(magic) == pcapNgMagic {
+		r, err = pcapgo.NewNgReader(br, pcapgo.DefaultNgReaderOptions)
+	} else {
+		r, err = pcapgo.NewReader(br)
+	}
+	if err != nil {
+		return fmt.Errorf("%s is not a pcap file: %s", input, err.Error())
+	}
+	if r.LinkType() != layers.LinkTypeEthernet {
+		return fmt.Errorf("%s link type %s is not supported, only ethernet", input, r.LinkType().String())
+	}
+
+	o.rx = o.rx[:0]
+	for {
+		data, ci, err := r.ReadPacketData()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%s read error: %s", input, err.Error())
+		}
+		if len(data) > int(MAX_PACKET_SIZE) || ci.CaptureLength < ci.Length {
+			o.stats.RxParseErr++
+			continue
+		}
+		if len(o.rx) == 0 {
+			o.baseTime = ci.Timestamp
+		}
+		ts := ci.Timestamp.Sub(o.baseTime)
+		if ts < 0 {
+			ts = 0
+		}
+		o.rx = append(o.rx, vethPcapPkt{ts: ts, data: data})
+	}
+	return nil
+}
+
+// simTime return the simulation time relative to the input file
+func (o *VethIFPcap) simTime() time.Duration {
+	return time.Duration(o.tctx.GetTickSimInSec()*float64(time.Second)) - o.Offset
+}
+
+// RxPending return the number of rx packets that were not injected yet
+func (o *VethIFPcap) RxPending() int {
+	return len(o.rx) - o.rxIndex
+}
+
+func (o *VethIFPcap) FlushTx() {
+	if len(o.vec) == 0 {
+		return
+	}
+	o.stats.TxBatch++
+	ts := o.baseTime.Add(o.simTime())
+	for _, m := range o.vec {
+		if !m.IsContiguous() {
+			panic(" mbuf should be contiguous  ")
+		}
+		if o.K12Monitor {
+			io.WriteString(o.monitorFile, "\n ->TX<- \n")
+			m.DumpK12(o.tctx.GetTickSimInSec(), o.monitorFile)
+		}
+		if o.Record {
+			o.tctx.SimRecordAppend(m.GetRecord(o.tctx.GetTickSimInSec(), "tx"))
+		}
+		if o.out != nil {
+			p := m.GetData()
+			o.out.WritePacket(gopacket.CaptureInfo{
+				Timestamp:     ts,
+				Length:        len(p),
+				CaptureLength: len(p),
+			}, p)
+		}
+		m.FreeMbuf()
+	}
+	o.vec = o.vec[:0]
+}
+
+// SimulatorCheckRxQueue is called every tick, handle the RPC requests, write the tx packets and inject the rx packets that are due
+func (o *VethIFPcap) SimulatorCheckRxQueue() {
+	o.handleRpcQueue()
+	o.FlushTx()
+
+	now := o.simTime()
+	for o.rxIndex < len(o.rx) && o.rx[o.rxIndex].ts <= now {
+		pkt := &o.rx[o.rxIndex]
+		o.rxIndex++
+		m := o.tctx.MPool.Alloc(uint16(len(pkt.data)))
+		m.SetVPort(o.vport)
+		m.Append(pkt.data)
+		o.OnRx(m)
+		o.FlushTx() // keep the order of the responses
+	}
+}
+
+func (o *VethIFPcap) SimulatorCleanup() {
+	o.handleRpcQueue()
+	o.FlushTx()
+	o.vec = nil
+	o.rxvec = nil
+	if o.out != nil {
+		o.out.Flush()
+		o.outFile.Close()
+		o.out = nil
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"external/google/gopacket/pcapgo"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+var pcapRxTimes []float64
+
+// echo the arp packet back
+func pcapArpEchoCb(ps *ParserPacketState) int {
+	pcapRxTimes = append(pcapRxTimes, ps.Tctx.GetTickSimInSec())
+	p := ps.M.GetData()
+	m := ps.Tctx.MPool.Alloc(uint16(len(p)))
+	m.SetVPort(ps.M.VPort())
+	m.Append(p)
+	ps.Tctx.Veth.Send(m)
+	return 0
+}
+
+func writePcapInput(t *testing.T, filename string, times []time.Duration) {
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := pcapgo.NewWriterNanos(f)
+	w.WriteFileHeader(65536, layers.LinkTypeEthernet)
+	pkt := PacketUtlBuild(
+		&layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0, 0, 2, 0, 0, 1},
+			DstMAC:       net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			EthernetType: layers.EthernetTypeARP,
+		},
+		&layers.ARP{AddrType: layers.LinkTypeEthernet, Protocol: layers.EthernetTypeIPv4, HwAddressSize: 6,
+			ProtAddressSize: 4, Operation: layers.ARPRequest,
+			SourceHwAddress: []byte{0, 0, 2, 0, 0, 1}, SourceProtAddress: []byte{16, 0, 0, 1},
+			DstHwAddress: []byte{0, 0, 0, 0, 0, 0}, DstProtAddress: []byte{16, 0, 0, 2}},
+	)
+	base := time.Unix(1600000000, 0)
+	for _, ts := range times {
+		w.WritePacket(gopacket.CaptureInfo{Timestamp: base.Add(ts), Length: len(pkt), CaptureLength: len(pkt)}, pkt)
+	}
+}
+
+func TestVethPcapReplay(t *testing.T) {
+	dir := t.TempDir()
+	input := dir + "/in.pcap"
+	output := dir + "/out.pcapng"
+	writePcapInput(t, input, []time.Duration{0, time.Second, 2500 * time.Millisecond})
+
+	tctx := NewThreadCtx(0, 4510, true, nil)
+	defer tctx.Delete()
+	var veth VethIFPcap
+	if err := veth.Create(tctx, input, output, 3); err != nil {
+		t.Fatal(err)
+	}
+	veth.Offset = 2 * time.Second
+	tctx.SetZmqVeth(&veth)
+	tctx.parser.RegisterCb("arp", pcapArpEchoCb, []ParserMatch{{EthType: uint16(layers.EthernetTypeARP)}})
+	pcapRxTimes = nil
+
+	tctx.MainLoopSim(10 * time.Second)
+
+	if veth.RxPending() != 0 || len(pcapRxTimes) != 3 {
+		t.Fatalf(" ERROR all the packets should be injected, got %d ", len(pcapRxTimes))
+	}
+	exp := []float64{2, 3, 4.5}
+	for i := range exp {
+		if pcapRxTimes[i] < exp[i]-0.01 || pcapRxTimes[i] > exp[i]+0.11 {
+			t.Fatalf(" ERROR packet %d was injected at %v expected %v ", i, pcapRxTimes[i], exp[i])
+		}
+	}
+
+	f, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := pcapgo.NewNgReader(f, pcapgo.DefaultNgReaderOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var txTimes []time.Time
+	for {
+		_, ci, err := r.ReadPacketData()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		txTimes = append(txTimes, ci.Timestamp)
+	}
+	if len(txTimes) != 3 || veth.stats.TxPkts != 3 {
+		t.Fatalf(" ERROR expected 3 tx packets in the output, got %d ", len(txTimes))
+	}
+	d := txTimes[2].Sub(txTimes[0])
+	if d < 2400*time.Millisecond || d > 2600*time.Millisecond {
+		t.Fatalf(" ERROR tx time base %v ", d)
+	}
+}
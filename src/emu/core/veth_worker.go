@@ -0,0 +1,160 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"io"
+	"os"
+)
+
+/*
+VethIFWorker is the veth of a CThreadPool worker.
+
+The rx messages are steered to the worker by the pool, in the ZMQ message format, and parsed into the worker mbuf pool.
+The tx packets are encoded in the ZMQ message format on FlushTx and handed to the pool, one thread sends them on the
+uplink veth. So the mbufs never cross threads.
+*/
+type VethIFWorker struct {
+	cn          chan []byte   // rx messages steered to this worker
+	txC         chan<- []byte // tx messages to the uplink
+	vec         []*Mbuf
+	txVecSize   uint32
+	stats       VethStats
+	tctx        *CThreadCtx
+	K12Monitor  bool     // K12 packet monitoring to monitorDest
+	monitorFile *os.File // File to print the K12 packet captured. Default is stdout.
+	cdb         *CCounterDb
+}
+
+func (o *VethIFWorker) Create(ctx *CThreadCtx, txC chan<- []byte) {
+	o.tctx = ctx
+	o.cn = make(chan []byte, THREAD_POOL_RX_QUEUE_SIZE)
+	o.txC = txC
+	o.vec = make([]*Mbuf, 0, ZMQ_TX_PKT_BURST_SIZE)
+	o.cdb = NewVethStatsDb(&o.stats)
+}
+
+func (o *VethIFWorker) StartRxThread() {
+	// the pool steers the rx messages
+}
+
+func (o *VethIFWorker) GetC() chan []byte {
+	return o.cn
+}
+
+func (o *VethIFWorker) FlushTx() {
+	if len(o.vec) == 0 {
+		return
+	}
+	o.stats.TxBatch++
+	var onTx func(m *Mbuf)
+	if o.K12Monitor {
+		onTx = func(m *Mbuf) {
+			m.DumpK12(o.tctx.GetTickSimInSec(), o.monitorFile)
+		}
+	}
+	// a new buffer for each message, the uplink thread owns it
+	msg := vethBuildTxStream(make([]byte, 0, 4+o.txVecSize+4*uint32(len(o.vec))), o.vec, onTx)
+	o.vec = o.vec[:0]
+	o.txVecSize = 0
+	o.txC <- msg
+}
+
+func (o *VethIFWorker) Send(m *Mbuf) {
+	m = o.tctx.encapPPPoE(m)
+	pktlen := m.PktLen()
+	o.stats.TxPkts++
+	o.stats.TxBytes += uint64(pktlen)
+
+	if o.txVecSize+pktlen >= ZMQ_TX_MAX_BUFFER_SIZE {
+		o.FlushTx()
+	}
+
+	o.tctx.capture.OnPacket(m, false)
+	if !m.IsContiguous() {
+		m1 := m.GetContiguous(&o.tctx.MPool)
+		m.FreeMbuf()
+		o.vec = append(o.vec, m1)
+	} else {
+		o.vec = append(o.vec, m)
+	}
+	o.txVecSize += pktlen
+	if len(o.vec) == ZMQ_TX_PKT_BURST_SIZE {
+		o.FlushTx()
+	}
+}
+
+// SendBuffer get a buffer as input, should allocate mbuf and call send
+func (o *VethIFWorker) SendBuffer(unicast bool, c *CClient, b []byte, ipv6 bool) {
+	var vport uint16
+	vport = c.Ns.GetVport()
+	m := o.tctx.MPool.Alloc(uint16(len(b)))
+	m.SetVPort(vport)
+	m.Append(b)
+	if unicast {
+		var dgMac MACKey
+		var ok bool
+		if ipv6 {
+			dgMac, ok = c.ResolveIPv6DGMac()
+		} else {
+			dgMac, ok = c.ResolveIPv4DGMac()
+		}
+		if !ok {
+			m.FreeMbuf()
+			o.stats.TxDropNotResolve++
+			return
+		} else {
+			p := m.GetData()
+			copy(p[6:12], c.Mac[:])
+			copy(p[0:6], dgMac[:])
+		}
+	}
+	o.Send(m)
+}
+
+// get the packet
+func (o *VethIFWorker) OnRx(m *Mbuf) {
+	o.stats.RxPkts++
+	o.stats.RxBytes += uint64(m.PktLen())
+	if o.K12Monitor {
+		io.WriteString(o.monitorFile, "\n ->RX<- \n")
+		m.DumpK12(o.tctx.GetTickSimInSec(), o.monitorFile)
+	}
+	o.tctx.HandleRxPacket(m)
+}
+
+func (o *VethIFWorker) OnRxStream(stream []byte) {
+	vethParseRxStream(o.tctx, &o.stats, stream, o.OnRx)
+}
+
+/* get the veth stats */
+func (o *VethIFWorker) GetStats() *VethStats {
+	return &o.stats
+}
+
+func (o *VethIFWorker) SimulatorCleanup() {
+	for _, m := range o.vec {
+		m.FreeMbuf()
+	}
+	o.vec = nil
+}
+
+func (o *VethIFWorker) SetDebug(monitor bool, monitorFile *os.File, capture bool) {
+	o.K12Monitor = monitor
+	o.monitorFile = monitorFile
+}
+
+func (o *VethIFWorker) GetCdb() *CCounterDb {
+	return o.cdb
+}
+
+func (o *VethIFWorker) SimulatorCheckRxQueue() {
+
+}
+
+func (o *VethIFWorker) AppendSimuationRPC(request []byte) {
+	panic("AppendSimuationRPC should not be called ")
+}
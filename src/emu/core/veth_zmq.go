@@ -22,7 +22,6 @@ uint16 pkt_size
 */
 
 import (
-	"encoding/binary"
 	"fmt"
 	"io"
 	"os"
@@ -150,35 +149,28 @@ func (o *VethIFZmq) FlushTx() {
 	if len(o.vec) == 0 {
 		return
 	}
-	o.buf = o.buf[:0]
-	var header uint32
-	var pkth [4]byte
 	o.stats.TxBatch++
-	header = (uint32(0xBEEF) << 16) + uint32(len(o.vec))
-	binary.BigEndian.PutUint32(pkth[:], header)
-	o.buf = append(o.buf, pkth[:]...) // message header
-
-	for _, m := range o.vec {
-		if !m.IsContiguous() {
-			panic(" mbuf should be contiguous  ")
-		}
-		if o.K12Monitor {
+	var onTx func(m *Mbuf)
+	if o.K12Monitor {
+		onTx = func(m *Mbuf) {
 			m.DumpK12(o.tctx.GetTickSimInSec(), o.monitorFile)
 		}
-		var pktHeader uint32
-		pktHeader = (uint32(0xAA) << 24) + uint32((m.VPort()&0xff))<<16 + uint32(m.pktLen&0xffff)
-		binary.BigEndian.PutUint32(pkth[:], pktHeader)
-		o.buf = append(o.buf, pkth[:]...)     // packet header
-		o.buf = append(o.buf, m.GetData()...) // packet itself
-		m.FreeMbuf()
 	}
+	o.buf = vethBuildTxStream(o.buf[:0], o.vec, onTx)
 	o.vec = o.vec[:0]
 	o.txVecSize = 0
 	o.txSocket.SendBytes(o.buf, 0)
 }
 
+// SendStream send a message that is already in the ZMQ format, it should be called from one thread
+func (o *VethIFZmq) SendStream(msg []byte) {
+	o.stats.TxBatch++
+	o.txSocket.SendBytes(msg, 0)
+}
+
 func (o *VethIFZmq) Send(m *Mbuf) {
 
+	m = o.tctx.encapPPPoE(m)
 	pktlen := m.PktLen()
 	o.stats.TxPkts++
 	o.stats.TxBytes += uint64(pktlen)
@@ -187,6 +179,7 @@ func (o *VethIFZmq) Send(m *Mbuf) {
 		o.FlushTx()
 	}
 
+	o.tctx.capture.OnPacket(m, false)
 	if !m.IsContiguous() {
 		m1 := m.GetContiguous(&o.tctx.MPool)
 		m.FreeMbuf()
@@ -275,48 +268,7 @@ func (o *VethIFZmq) SimulatorCheckRxQueue() {
 }
 
 func (o *VethIFZmq) OnRxStream(stream []byte) {
-	o.stats.RxBatch++
-	blen := uint32(len(stream))
-	if blen < 4 {
-		o.stats.RxParseErr++
-		return
-	}
-	header := binary.BigEndian.Uint32(stream[0:4])
-	if ((header & 0xffff0000) >> 16) != ZMQ_PACKET_HEADER_MAGIC {
-		o.stats.RxParseErr++
-		return
-	}
-	pkts := int(header & 0xffff)
-	var of uint16
-	of = 4
-	var vport uint8
-	var pktLen uint16
-	var m *Mbuf
-	for i := 0; i < pkts; i++ {
-		if blen < uint32(of+4) {
-			o.stats.RxParseErr++
-			return
-		}
-
-		header = binary.BigEndian.Uint32(stream[of : of+4])
-		if (header & 0xff000000) != 0xAA000000 {
-			o.stats.RxParseErr++
-			return
-		}
-
-		vport = uint8((header & 0x00ff0000) >> 16)
-		pktLen = uint16((header & 0x0000ffff))
-		if blen < uint32(of+4+pktLen) {
-			o.stats.RxParseErr++
-			return
-		}
-
-		m = o.tctx.MPool.Alloc(pktLen)
-		m.SetVPort(uint16(vport))
-		m.Append(stream[of+4 : of+4+pktLen])
-		o.OnRx(m)
-		of = of + 4 + pktLen
-	}
+	vethParseRxStream(o.tctx, &o.stats, stream, o.OnRx)
 }
 
 func (o *VethIFZmq) AppendSimuationRPC(request []byte) {
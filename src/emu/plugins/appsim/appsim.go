@@ -44,6 +44,7 @@ type AppsimRec struct {
 	Tid    uint32  `json:"tid"`    //template id from global ns program
 	Ipv6   bool    `json:"ipv6"`   //is ipv6
 	Stream bool    `json:"stream"` //udp or tcp
+	Tls    bool    `json:"tls"`    //tls over tcp, stream should be true
 	DestIP string  `json:"dst"`    //dest ip either ipv4 or ipv6 (base on ipv6 value)
 	Limit  uint32  `json:"limit"`  //limit the number of new flows. zero means unlimited
 }
@@ -58,6 +59,7 @@ type appsimStream struct {
 	tid      uint32
 	ipv6     bool
 	stream   bool
+	tls      bool
 	dst      string
 	limit    uint32
 
@@ -104,6 +106,8 @@ func (o *appsimStream) createNewFlow() {
 	net := "tcp"
 	if !o.stream {
 		net = "udp"
+	} else if o.tls {
+		net = "tls"
 	}
 	var ioctl map[string]interface{}
 	ioctl = getAppIoctl(sim.template_id, !o.isClient, sim.program)
@@ -907,6 +911,7 @@ func (o *PluginAppsimClient) createStream(v *AppsimRec) *appsimStream {
 	obj.tid = v.Tid
 	obj.ipv6 = v.Ipv6
 	obj.stream = v.Stream
+	obj.tls = v.Tls
 	obj.dst = v.DestIP
 	obj.limit = v.Limit
 	obj.plug = o
@@ -944,6 +949,8 @@ func (o *PluginAppsimClient) createStream(v *AppsimRec) *appsimStream {
 	net := "tcp"
 	if !obj.stream {
 		net = "udp"
+	} else if obj.tls {
+		net = "tls"
 	}
 	var ioctl map[string]interface{}
 	ioctl = getAppIoctl(sim.template_id, !obj.isClient, sim.program)
@@ -114,6 +114,8 @@ func newSimCtx(app iSockeApp, c *core.CClient, server bool,
 	net := "tcp"
 	if params.udp {
 		net = "udp"
+	} else if params.tls {
+		net = "tls"
 	}
 	var ioctl map[string]interface{}
 	ioctl = getAppIoctl(o.template_id, server, program)
@@ -148,6 +150,7 @@ type transportSimParam struct {
 	debug        bool
 	ipv6         bool
 	udp          bool
+	tls          bool
 	program_json string
 }
 
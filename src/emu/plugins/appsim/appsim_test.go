@@ -341,6 +341,31 @@ func TestPluginAppSim11(t *testing.T) {
 	a.Run(t, false)
 }
 
+// TCP program over TLS, the records are random so the application counters are verified instead of a golden file
+func TestPluginAppSimTls1(t *testing.T) {
+	rand.Seed(0x1234)
+	param := transportSimParam{
+		name:         "a",
+		ipv6:         false,
+		tls:          true,
+		program_json: input_json3,
+	}
+	sim := newTransportSim(&param)
+	sim.tctx.MainLoopSim(10 * time.Second)
+	defer sim.tctx.Delete()
+
+	req := 249  /* buf_list[0] */
+	resp := 128 /* buf_list[1] */
+	c := sim.client.stas
+	s := sim.server.stas
+	if c.BytesTx != uint64(req) || c.BytesRx != uint64(resp) || c.eventNewFlow != 1 || c.eventDelFlow != 1 {
+		t.Fatalf(" bad client counters %+v", *c)
+	}
+	if s.BytesRx != uint64(req) || s.BytesTx != uint64(resp) || s.eventNewFlow != 1 || s.eventDelFlow != 1 {
+		t.Fatalf(" bad server counters %+v", *s)
+	}
+}
+
 // UDP program
 const input_json12 string = `
 {
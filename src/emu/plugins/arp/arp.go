@@ -94,6 +94,7 @@ type ArpNsStats struct {
 	pktRxArpQuery         uint64
 	pktRxArpQueryNotForUs uint64
 	pktRxArpReply         uint64
+	pktRxIpv4Conflict     uint64
 	pktTxArpQuery         uint64
 	pktTxGArp             uint64
 	pktTxReply            uint64
@@ -226,6 +227,13 @@ func NewArpNsStatsDb(o *ArpNsStats) *core.CCounterDb {
 		Unit:     "pkts",
 		DumpZero: false,
 		Info:     core.ScINFO})
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxIpv4Conflict,
+		Name:     "pktRxIpv4Conflict",
+		Help:     "rx arp from another host with the ipv4 of our client",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
 
 	db.Add(&core.CCounterRec{
 		Counter:  &o.pktTxArpQuery,
@@ -701,11 +709,17 @@ func (o *PluginArpClient) OnEvent(msg string, a, b interface{}) {
 				!o.Client.Ipv4.IsZero())
 		}
 
+	case core.MSG_UPDATE_CLIENT:
+		ev := a.(*core.CClientUpdate)
+		if ev.Changed&core.CLIENT_UPDATE_IPV4 != 0 {
+			/* the client moved, update the neighbors caches */
+			o.SendGArp()
+		}
 	}
 
 }
 
-var arpEvents = []string{core.MSG_UPDATE_IPV4_ADDR, core.MSG_UPDATE_DGIPV4_ADDR}
+var arpEvents = []string{core.MSG_UPDATE_IPV4_ADDR, core.MSG_UPDATE_DGIPV4_ADDR, core.MSG_UPDATE_CLIENT}
 
 /*OnChangeDGSrcIPv4 - called in case there is a change in DG or srcIPv4 */
 func (o *PluginArpClient) OnChangeDGSrcIPv4(oldDgIpv4 core.Ipv4Key,
@@ -900,6 +914,33 @@ func (o *PluginArpNs) ArpLearn(arpHeader *layers.ArpHeader) {
 	}
 }
 
+// DetectConflict notifies the client when the sender of the packet uses its ipv4 with another MAC.
+// A reply to a probe (zero target ipv4, RFC 5227) notifies the client that sent it, the client does not use the ipv4 yet.
+func (o *PluginArpNs) DetectConflict(arpHeader *layers.ArpHeader) {
+
+	var ipv4 core.Ipv4Key
+	var mkey core.MACKey
+	ipv4.SetUint32(arpHeader.GetSrcIpAddress())
+
+	client := o.Ns.CLookupByIPv4(&ipv4)
+	if client == nil {
+		if arpHeader.GetOperation() != layers.ARPReply || arpHeader.GetDstIpAddress() != 0 || ipv4.IsZero() {
+			return
+		}
+		var dst core.MACKey
+		copy(dst[:], arpHeader.GetDstAddress())
+		if client = o.Ns.CLookupByMac(&dst); client == nil {
+			return
+		}
+	}
+	copy(mkey[0:6], arpHeader.GetSourceAddress())
+	if mkey == client.Mac {
+		return
+	}
+	o.stats.pktRxIpv4Conflict++
+	client.PluginCtx.BroadcastMsg(nil, core.MSG_IPV4_CONFLICT, ipv4, mkey)
+}
+
 // HandleRxArpPacket there is no need to free  buffer
 func (o *PluginArpNs) HandleRxArpPacket(m *core.Mbuf, l3 uint16) {
 	if m.PktLen() < uint32(layers.ARPHeaderSize+l3) {
@@ -931,6 +972,7 @@ func (o *PluginArpNs) HandleRxArpPacket(m *core.Mbuf, l3 uint16) {
 		} else {
 			o.stats.pktRxArpQueryNotForUs++
 		}
+		o.DetectConflict(&arpHeader)
 
 	case layers.ARPReply:
 		if ethHeader.IsBroadcast() {
@@ -939,6 +981,7 @@ func (o *PluginArpNs) HandleRxArpPacket(m *core.Mbuf, l3 uint16) {
 		}
 		o.stats.pktRxArpReply++
 		o.ArpLearn(&arpHeader)
+		o.DetectConflict(&arpHeader)
 
 	default:
 		o.stats.pktRxErrWrongOp++
@@ -1195,7 +1238,8 @@ func init() {
 	core.RegisterCB("arp_ns_iter", ApiArpNsIterHandler{}, true)
 
 	/* register callback for rx side*/
-	core.ParserRegister("arp", HandleRxArpPacket)
+	core.ParserRegister("arp", HandleRxArpPacket,
+		core.ParserMatch{EthType: uint16(layers.EthernetTypeARP)})
 }
 
 func Register(ctx *core.CThreadCtx) {
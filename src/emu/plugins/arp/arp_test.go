@@ -413,6 +413,44 @@ func TestPluginArp7(t *testing.T) {
 	a.Run(t)*/
 }
 
+type ArpRpcCtx2 struct {
+	tctx  *core.CThreadCtx
+	timer core.CHTimerObj
+}
+
+func (o *ArpRpcCtx2) OnEvent(a, b interface{}) {
+	o.tctx.Veth.AppendSimuationRPC([]byte(`{"jsonrpc": "2.0",
+	"method":"ctx_client_update",
+	"params": {"tun": {"vport":1,"tci":[1,2]}, "clients": [{"mac": [0,0,1,0,0,0], "ipv4": [16,0,0,10]}]},
+	"id": 3 }`))
+}
+
+func rpcQueue3(tctx *core.CThreadCtx, test *ArpTestBase) int {
+	timerw := tctx.GetTimerCtx()
+	ticks := timerw.DurationToTicks(30 * time.Second)
+	var arpctx ArpRpcCtx2
+	arpctx.timer.SetCB(&arpctx, test.cbArg1, test.cbArg2)
+	arpctx.tctx = tctx
+	timerw.StartTicks(&arpctx.timer, ticks)
+	return 0
+}
+
+/*TestPluginArp8 - the client IPv4 is changed by ctx_client_update, should send a gratuitous ARP and resolve again */
+func TestPluginArp8(t *testing.T) {
+	a := &ArpTestBase{
+		testname:     "arp8",
+		dropAll:      false,
+		monitor:      false,
+		match:        0,
+		capture:      true,
+		duration:     1 * time.Minute,
+		clientsToSim: 1,
+		cb:           rpcQueue3,
+		cbArg1:       1,
+	}
+	a.Run(t)
+}
+
 func init() {
 	flag.IntVar(&monitor, "monitor", 0, "monitor")
 }
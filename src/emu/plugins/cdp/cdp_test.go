@@ -165,6 +165,107 @@ func TestPluginCdp2(t *testing.T) {
 	a.Run(t)
 }
 
+// VethCdpLoopback loops the packets back, so the clients get the CDP packets of each other
+type VethCdpLoopback struct {
+}
+
+func (o *VethCdpLoopback) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
+	return m
+}
+
+// runNeighbors runs two cdp clients on the same namespace and returns their plugins
+func runNeighbors(t *testing.T, optionsA, optionsB []byte, duration time.Duration) (*PluginCdpClient, *PluginCdpClient) {
+	var simVeth VethCdpLoopback
+	var simrx core.VethIFSim
+	simrx = &simVeth
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	defer tctx.Delete()
+	Register(tctx)
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: [2]uint32{0x81000001, 0x81000002}})
+	ns := core.NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+
+	var plugs []*PluginCdpClient
+	for i, options := range [][]byte{optionsA, optionsB} {
+		client := core.NewClient(ns, core.MACKey{0, 0, 1, 0, 0, byte(i + 1)},
+			core.Ipv4Key{0, 0, 0, 0},
+			core.Ipv6Key{},
+			core.Ipv4Key{0, 0, 0, 0})
+		if err := ns.AddClient(client); err != nil {
+			t.Fatal(err)
+		}
+		if options == nil {
+			options = []byte("{}")
+		}
+		if err := client.PluginCtx.CreatePlugins([]string{CDP_PLUG}, [][]byte{options}); err != nil {
+			t.Fatal(err)
+		}
+		plugs = append(plugs, client.PluginCtx.Get(CDP_PLUG).Ext.(*PluginCdpClient))
+	}
+	m := false
+	if monitor > 0 {
+		m = true
+	}
+	tctx.Veth.SetDebug(m, os.Stdout, false)
+	tctx.MainLoopSim(duration)
+	for _, plug := range plugs {
+		plug.cdbv.Dump()
+	}
+	tctx.GetCounterDbVec().Dump()
+	return plugs[0], plugs[1]
+}
+
+func TestPluginCdpNeighbors(t *testing.T) {
+	cdp_options, _ := hex.DecodeString("0001000c6d7973776974636800020011000000010101cc0004c0a800fd000300134661737445746865726e6574302f31000400080000002800050114436973636f20496e7465726e6574776f726b204f7065726174696e672053797374656d20536f667477617265200a494f532028746d2920433239353020536f667477617265202843323935302d49364b324c3251342d4d292c2056657273696f6e2031322e3128323229454131342c2052454c4541534520534f4654574152452028666331290a546563686e6963616c20537570706f72743a20687474703a2f2f7777772e636973636f2e636f6d2f74656368737570706f72740a436f707972696768742028632920313938362d3230313020627920636973636f2053797374656d732c20496e632e0a436f6d70696c6564205475652032362d4f63742d31302031303a3335206279206e627572726100060015636973636f2057532d43323935302d31320008002400000c011200000000ffffffff010220ff000000000000000bbe189a40ff00000009000c4d59444f4d41494e000a00060001000b0005010012000500001300050000160011000000010101cc0004c0a800fd")
+	optionsA, _ := json.Marshal(&CdpInit{TimerSec: 10, Options: &CdpOptionsT{Raw: &cdp_options}})
+	// device id only, sent with a bad checksum
+	devId, _ := hex.DecodeString("0001000562")
+	optionsB, _ := json.Marshal(&CdpInit{Ver: 1, Options: &CdpOptionsT{Raw: &devId}, BadCs: 0x1234})
+	a, b := runNeighbors(t, optionsA, optionsB, 45*time.Second)
+
+	expStats := CdpStats{pktTx: 2, pktRx: 5, neighborAdd: 1, neighborUpdate: 4}
+	if b.stats != expStats {
+		t.Fatalf("Bad stats of b %+v, expected %+v", b.stats, expStats)
+	}
+	res := b.GetNeighbors()
+	if len(res) != 1 {
+		t.Fatalf("Bad neighbors of b %+v", res)
+	}
+	n := res[0]
+	if n.DeviceId != "myswitch" || n.PortId != "FastEthernet0/1" || n.Ver != 2 || n.Ttl != 180 ||
+		n.SrcMac != "00:00:01:00:00:01" || n.Platform != "cisco WS-C2950-12" || n.NativeVlan != 1 ||
+		n.VtpDomain != "MYDOMAIN" || n.Rx != 5 || len(n.Addresses) != 1 || n.Addresses[0] != "192.168.0.253" ||
+		len(n.MgmtAddresses) != 1 || n.MgmtAddresses[0] != "192.168.0.253" ||
+		len(n.Capabilities) != 2 || n.Capabilities[0] != "switch" || n.Capabilities[1] != "igmp_filter" {
+		t.Fatalf("Bad neighbor of b %+v", n)
+	}
+
+	expStats = CdpStats{pktTx: 5, pktRx: 2, pktRxBadCs: 2}
+	if a.stats != expStats || len(a.GetNeighbors()) != 0 {
+		t.Fatalf("Bad stats of a %+v, expected %+v", a.stats, expStats)
+	}
+}
+
+func TestPluginCdpNeighborsAging(t *testing.T) {
+	// the TTL is 180 seconds, the neighbor ages before the next packet
+	devId, _ := hex.DecodeString("0001000561")
+	optionsA, _ := json.Marshal(&CdpInit{TimerSec: 200, Options: &CdpOptionsT{Raw: &devId}})
+	// truncated TLV
+	bad, _ := hex.DecodeString("00010010")
+	optionsB, _ := json.Marshal(&CdpInit{TimerSec: 200, Options: &CdpOptionsT{Raw: &bad}})
+	a, b := runNeighbors(t, optionsA, optionsB, 370*time.Second)
+
+	expStats := CdpStats{pktTx: 2, pktRx: 2, neighborAdd: 2, neighborAged: 1}
+	if b.stats != expStats || len(b.GetNeighbors()) != 1 || b.GetNeighbors()[0].DeviceId != "a" {
+		t.Fatalf("Bad stats of b %+v, expected %+v", b.stats, expStats)
+	}
+	expStats = CdpStats{pktTx: 2, pktRx: 2, pktRxMalformed: 2}
+	if a.stats != expStats || len(a.GetNeighbors()) != 0 {
+		t.Fatalf("Bad stats of a %+v, expected %+v", a.stats, expStats)
+	}
+}
+
 func init() {
 	flag.IntVar(&monitor, "monitor", 0, "monitor")
 }
@@ -10,6 +10,8 @@ cdp protocol.
 
 broadcast cisco CDP packet every tick. The CDP TLV information can be tuned by the inijson
 
+The CDP packets of the neighbors are kept in a neighbor table per client, see neighbor.go
+
 */
 
 import (
@@ -41,7 +43,15 @@ type CdpInit struct {
 }
 
 type CdpStats struct {
-	pktTx uint64
+	pktTx            uint64
+	pktRx            uint64
+	pktRxMalformed   uint64
+	pktRxBadCs       uint64
+	neighborAdd      uint64
+	neighborUpdate   uint64
+	neighborAged     uint64
+	neighborShutdown uint64
+	neighborTblFull  uint64
 }
 
 func NewCdpStatsDb(o *CdpStats) *core.CCounterDb {
@@ -55,6 +65,70 @@ func NewCdpStatsDb(o *CdpStats) *core.CCounterDb {
 		DumpZero: false,
 		Info:     core.ScINFO})
 
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRx,
+		Name:     "pktRx",
+		Help:     "received cdp packets",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxMalformed,
+		Name:     "pktRxMalformed",
+		Help:     "malformed cdp packets",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxBadCs,
+		Name:     "pktRxBadCs",
+		Help:     "cdp packets with a bad checksum",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.neighborAdd,
+		Name:     "neighborAdd",
+		Help:     "new neighbors",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.neighborUpdate,
+		Name:     "neighborUpdate",
+		Help:     "neighbor updates",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.neighborAged,
+		Name:     "neighborAged",
+		Help:     "neighbors removed as their ttl expired",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.neighborShutdown,
+		Name:     "neighborShutdown",
+		Help:     "neighbors removed by a zero ttl packet",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.neighborTblFull,
+		Name:     "neighborTblFull",
+		Help:     "neighbors ignored as the table is full",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
 	return db
 }
 
@@ -80,6 +154,7 @@ type PluginCdpClient struct {
 	timerSec    uint32
 	l3Offset    uint16
 	pktTemplate []byte
+	neighbors   map[string]*cdpNeighbor // neighbor table, by Device ID and Port ID
 }
 
 var cdpEvents = []string{}
@@ -97,6 +172,7 @@ func NewCdpClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error
 	o.RegisterEvents(ctx, cdpEvents, o) /* register events, only if exits*/
 	nsplg := o.Ns.PluginCtx.GetOrCreate(CDP_PLUG)
 	o.cdpNsPlug = nsplg.Ext.(*PluginCdpNs)
+	o.cdpNsPlug.clients = append(o.cdpNsPlug.clients, o)
 	o.OnCreate()
 
 	return &o.PluginBase, nil
@@ -104,6 +180,7 @@ func NewCdpClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error
 
 func (o *PluginCdpClient) OnCreate() {
 	o.timerw = o.Tctx.GetTimerCtx()
+	o.neighbors = make(map[string]*cdpNeighbor)
 	o.preparePacketTemplate()
 	o.timerSec = 30
 	if o.init.TimerSec > 0 {
@@ -186,6 +263,8 @@ func (o *PluginCdpClient) OnRemove(ctx *core.PluginCtx) {
 	if o.timer.IsRunning() {
 		o.timerw.Stop(&o.timer)
 	}
+	o.flushNeighbors()
+	o.cdpNsPlug.removeClient(o)
 }
 
 func (o *PluginCdpClient) restartTimer(sec uint32) {
@@ -206,7 +285,8 @@ func (o *PluginCdpClient) onTimerEvent() {
 // PluginCdpNs icmp information per namespace
 type PluginCdpNs struct {
 	core.PluginBase
-	stats CdpStats
+	stats   CdpStats
+	clients []*PluginCdpClient // cdp clients of the namespace, they get the received packets
 }
 
 func NewCdpNs(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
@@ -227,6 +307,15 @@ func (o *PluginCdpNs) SetTruncated() {
 
 }
 
+func (o *PluginCdpNs) removeClient(c *PluginCdpClient) {
+	for i := range o.clients {
+		if o.clients[i] == c {
+			o.clients = append(o.clients[:i], o.clients[i+1:]...)
+			return
+		}
+	}
+}
+
 // Tx side client get an event and decide to act !
 // let's see how it works and add some tests
 
@@ -244,7 +333,8 @@ func (o PluginCdpNsReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) (*core.P
 /*******************************************/
 /*  RPC commands */
 type (
-	ApiCdpClientCntHandler struct{}
+	ApiCdpClientCntHandler          struct{}
+	ApiCdpClientGetNeighborsHandler struct{}
 )
 
 func getNs(ctx interface{}, params *fastjson.RawMessage) (*PluginCdpNs, *jsonrpc.Error) {
@@ -291,6 +381,18 @@ func (h ApiCdpClientCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.R
 	return c.cdbv.GeneralCounters(err, tctx, params, &p)
 }
 
+func (h ApiCdpClientGetNeighborsHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	c, err := getClientPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return c.GetNeighbors(), nil
+}
+
 func init() {
 
 	/* register of plugins callbacks for ns,c level  */
@@ -314,10 +416,14 @@ func init() {
 	  aa - misc
 	*/
 
-	core.RegisterCB("cdp_client_cnt", ApiCdpClientCntHandler{}, false) // get counters/meta
+	core.RegisterCB("cdp_client_cnt", ApiCdpClientCntHandler{}, false)               // get counters/meta
+	core.RegisterCB("cdp_c_get_neighbors", ApiCdpClientGetNeighborsHandler{}, false) // get the neighbor table
 
+	/* register callback for rx side*/
+	core.ParserRegister(CDP_PLUG, HandleRxCdpPacket,
+		core.ParserMatch{SnapOUI: 0x00000c, SnapType: uint16(layers.EthernetTypeCiscoDiscovery)})
 }
 
 func Register(ctx *core.CThreadCtx) {
-	//pass
+	ctx.RegisterParserCb(CDP_PLUG)
 }
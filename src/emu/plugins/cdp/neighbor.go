@@ -0,0 +1,234 @@
+// Copyright (c) 2021 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package cdp
+
+/*
+cdp neighbor table
+
+Each CDP packet received in the namespace is handed to all the cdp clients of the namespace, besides the client that
+sent it. A neighbor is identified by its Device ID and Port ID, it is removed when its TTL expires without a new packet.
+*/
+
+import (
+	"emu/core"
+	"encoding/binary"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"net"
+	"sort"
+	"time"
+)
+
+const (
+	maxNeighbors = 256 // Maximal number of neighbors per client
+	cdpHdrOffset = 8   // CDP header after the LLC/SNAP header
+	cdpHdrLen    = 4   // Version, TTL and Checksum
+)
+
+// CdpNeighbor is the information of a neighbor as returned by cdp_c_get_neighbors.
+type CdpNeighbor struct {
+	DeviceId      string   `json:"device_id"`      // Device ID
+	PortId        string   `json:"port_id"`        // Port ID
+	Ver           uint8    `json:"ver"`            // CDP version
+	Ttl           uint8    `json:"ttl"`            // TTL of the last packet in seconds
+	SrcMac        string   `json:"src_mac"`        // Source MAC of the last packet
+	Version       string   `json:"version"`        // Software version
+	Platform      string   `json:"platform"`       // Platform
+	Capabilities  []string `json:"capabilities"`   // Capabilities
+	Addresses     []string `json:"addresses"`      // Addresses
+	MgmtAddresses []string `json:"mgmt_addresses"` // Management addresses
+	NativeVlan    uint16   `json:"native_vlan"`    // Native VLAN, zero if not advertised
+	VtpDomain     string   `json:"vtp_domain"`     // VTP management domain
+	SysName       string   `json:"sys_name"`       // System name
+	Rx            uint64   `json:"rx"`             // Packets received from the neighbor
+}
+
+// cdpNeighbor is an entry of the neighbor table of a client.
+type cdpNeighbor struct {
+	key   string
+	info  CdpNeighbor
+	timer core.CHTimerObj // TTL of the neighbor
+}
+
+// OnEvent removes a neighbor whose TTL has expired.
+func (o *cdpNeighbor) OnEvent(a, b interface{}) {
+	c := a.(*PluginCdpClient)
+	c.stats.neighborAged++
+	delete(c.neighbors, o.key)
+}
+
+// cdpCapNames converts CDP capabilities to names.
+func cdpCapNames(c layers.CDPCapabilities) []string {
+	res := []string{}
+	caps := []struct {
+		on   bool
+		name string
+	}{
+		{c.L3Router, "router"}, {c.TBBridge, "tb_bridge"}, {c.SPBridge, "sp_bridge"}, {c.L2Switch, "switch"},
+		{c.IsHost, "host"}, {c.IGMPFilter, "igmp_filter"}, {c.L1Repeater, "repeater"}, {c.IsPhone, "phone"},
+		{c.RemotelyManaged, "remote"},
+	}
+	for _, e := range caps {
+		if e.on {
+			res = append(res, e.name)
+		}
+	}
+	return res
+}
+
+// cdpAddrStrings converts addresses to strings.
+func cdpAddrStrings(addrs []net.IP) []string {
+	res := []string{}
+	for _, addr := range addrs {
+		res = append(res, addr.String())
+	}
+	return res
+}
+
+// decodeCdp decodes a CDP packet, returns nil in case it is malformed.
+func decodeCdp(d []byte) (*layers.CiscoDiscovery, *layers.CiscoDiscoveryInfo) {
+	pkt := gopacket.NewPacket(d, layers.LayerTypeCiscoDiscovery, gopacket.Default)
+	if pkt.ErrorLayer() != nil {
+		return nil, nil
+	}
+	cdp, ok := pkt.Layer(layers.LayerTypeCiscoDiscovery).(*layers.CiscoDiscovery)
+	if !ok {
+		return nil, nil
+	}
+	info, ok := pkt.Layer(layers.LayerTypeCiscoDiscoveryInfo).(*layers.CiscoDiscoveryInfo)
+	if !ok {
+		return nil, nil
+	}
+	return cdp, info
+}
+
+// isValidCdpChecksum verifies the checksum of a CDP packet.
+func isValidCdpChecksum(d []byte) bool {
+	b := append([]byte{}, d...)
+	binary.BigEndian.PutUint16(b[2:4], 0)
+	return layers.CdpChecksum(b, 0) == binary.BigEndian.Uint16(d[2:4])
+}
+
+// handleRxCdp updates the neighbor table by a received CDP packet.
+func (o *PluginCdpClient) handleRxCdp(srcMac []byte, badCs bool, cdp *layers.CiscoDiscovery, info *layers.CiscoDiscoveryInfo) {
+	o.stats.pktRx++
+	if badCs {
+		o.stats.pktRxBadCs++
+		return
+	}
+	if cdp == nil {
+		o.stats.pktRxMalformed++
+		return
+	}
+
+	key := info.DeviceID + "/" + info.PortID
+	n, ok := o.neighbors[key]
+	if cdp.TTL == 0 {
+		if ok {
+			o.stats.neighborShutdown++
+			o.timerw.Stop(&n.timer)
+			delete(o.neighbors, key)
+		}
+		return
+	}
+	if !ok {
+		if len(o.neighbors) >= maxNeighbors {
+			o.stats.neighborTblFull++
+			return
+		}
+		n = &cdpNeighbor{key: key}
+		n.timer.SetCB(n, o, nil)
+		o.neighbors[key] = n
+		o.stats.neighborAdd++
+	} else {
+		o.stats.neighborUpdate++
+		o.timerw.Stop(&n.timer)
+	}
+	o.timerw.Start(&n.timer, time.Duration(cdp.TTL)*time.Second)
+
+	n.info = CdpNeighbor{
+		DeviceId:      info.DeviceID,
+		PortId:        info.PortID,
+		Ver:           cdp.Version,
+		Ttl:           cdp.TTL,
+		SrcMac:        net.HardwareAddr(srcMac).String(),
+		Version:       info.Version,
+		Platform:      info.Platform,
+		Capabilities:  cdpCapNames(info.Capabilities),
+		Addresses:     cdpAddrStrings(info.Addresses),
+		MgmtAddresses: cdpAddrStrings(info.MgmtAddresses),
+		NativeVlan:    info.NativeVLAN,
+		VtpDomain:     info.VTPDomain,
+		SysName:       info.SysName,
+		Rx:            n.info.Rx + 1,
+	}
+}
+
+// GetNeighbors returns the neighbors of the client, sorted by Device ID and Port ID.
+func (o *PluginCdpClient) GetNeighbors() []CdpNeighbor {
+	res := make([]CdpNeighbor, 0, len(o.neighbors))
+	for _, n := range o.neighbors {
+		res = append(res, n.info)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].DeviceId != res[j].DeviceId {
+			return res[i].DeviceId < res[j].DeviceId
+		}
+		return res[i].PortId < res[j].PortId
+	})
+	return res
+}
+
+// flushNeighbors removes all the neighbors.
+func (o *PluginCdpClient) flushNeighbors() {
+	for key, n := range o.neighbors {
+		if n.timer.IsRunning() {
+			o.timerw.Stop(&n.timer)
+		}
+		delete(o.neighbors, key)
+	}
+}
+
+// HandleRxCdpPacket hands a CDP packet to the clients of the namespace.
+func (o *PluginCdpNs) HandleRxCdpPacket(ps *core.ParserPacketState) int {
+	p := ps.M.GetData()
+	var srcMac core.MACKey
+	copy(srcMac[:], p[6:12])
+
+	// the 802.3 length is before the LLC header, the frame could be padded
+	var d []byte
+	l := int(binary.BigEndian.Uint16(p[ps.L3-2 : ps.L3]))
+	if l >= cdpHdrOffset+cdpHdrLen && int(ps.L3)+l <= len(p) {
+		d = p[int(ps.L3)+cdpHdrOffset : int(ps.L3)+l]
+	}
+	var cdp *layers.CiscoDiscovery
+	var info *layers.CiscoDiscoveryInfo
+	badCs := false
+	if d != nil {
+		badCs = !isValidCdpChecksum(d)
+		cdp, info = decodeCdp(d)
+	}
+	for _, c := range o.clients {
+		if c.Client.Mac == srcMac {
+			continue // our own packet
+		}
+		c.handleRxCdp(srcMac[:], badCs, cdp, info)
+	}
+	return core.PARSER_OK
+}
+
+// HandleRxCdpPacket Parser call this function with mbuf from the pool
+func HandleRxCdpPacket(ps *core.ParserPacketState) int {
+	ns := ps.Tctx.GetNs(ps.Tun)
+	if ns == nil {
+		return core.PARSER_ERR
+	}
+	nsplg := ns.PluginCtx.Get(CDP_PLUG)
+	if nsplg == nil {
+		return core.PARSER_ERR
+	}
+	return nsplg.Ext.(*PluginCdpNs).HandleRxCdpPacket(ps)
+}
@@ -0,0 +1,674 @@
+/*
+Copyright (c) 2021 Cisco Systems and/or its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+that can be found in the LICENSE file in the root of the source
+tree.
+*/
+
+package dhcprelay
+
+import (
+	"emu/core"
+	"encoding/binary"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"external/osamingo/jsonrpc"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/intel-go/fastjson"
+)
+
+/*
+DHCP Relay Agent - https://datatracker.ietf.org/doc/html/rfc1542
+
+Relay Agent Information Option (Option 82) - https://datatracker.ietf.org/doc/html/rfc3046
+
+The relay agent gets the broadcast DHCP messages of the clients in its namespace, sets giaddr, inserts Option 82
+and unicasts them to the server through the default gateway. The replies of the server are sent to the client
+without Option 82.
+
+The DHCP ports are shared with the DHCPv4 Server, the relay gets the packets first and passes the packets that
+are not for a relay to the server.
+
+Limitations
+ - One server per relay agent.
+ - Option 82 of the clients (giaddr 0) is not trusted, these messages are dropped.
+*/
+
+const (
+	DHCP_RELAY_PLUG    = "dhcprelay"
+	DHCPV4_CLIENT_PORT = 68 // DHCPv4 Client Port
+	DHCPV4_SERVER_PORT = 67 // DHCPv4 Server Port
+	DefaultMaxHops     = 16 // Default maximal hops of a relayed message
+	DefaultCircuitId   = "{vlan}"
+	DefaultRemoteId    = "{mac}"
+	CircuitIdSubOpt    = 1 // Agent Circuit ID Sub-option
+	RemoteIdSubOpt     = 2 // Agent Remote ID Sub-option
+)
+
+/*======================================================================================================
+											Stats
+======================================================================================================*/
+
+// DhcpRelayStats is a struct that consolidates all the counters of a DhcpRelay.
+type DhcpRelayStats struct {
+	invalidInitJson   uint64 // Error while decoding client init Json
+	pktRxClient       uint64 // Num packets received from clients
+	pktRxServer       uint64 // Num packets received from the server
+	pktRxParserErr    uint64 // Num packets that could not be decoded
+	pktRxBadOp        uint64 // Num packets with an unexpected BOOTP operation
+	pktRxMaxHops      uint64 // Num packets dropped since they passed too many relays
+	pktRxUntrustedOpt uint64 // Num client packets dropped since they have Option 82 and giaddr 0
+	pktRxWrongGiaddr  uint64 // Num server packets whose giaddr is not the relay address
+	pktRxRelayed      uint64 // Num client packets with giaddr of another relay
+	pktTxServer       uint64 // Num packets relayed to the server
+	pktTxClient       uint64 // Num packets relayed to clients
+	pktTxNoDgMac      uint64 // Num packets not relayed since the default gateway is not resolved
+	opt82Inserted     uint64 // Num of Option 82 inserted
+	opt82Stripped     uint64 // Num of Option 82 stripped
+	opt82TooLong      uint64 // Num of Option 82 not inserted since it is longer than 255 bytes
+}
+
+// NewDhcpRelayStatsDb creates a new database of DhcpRelay counters.
+func NewDhcpRelayStatsDb(o *DhcpRelayStats) *core.CCounterDb {
+	db := core.NewCCounterDb(DHCP_RELAY_PLUG)
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.invalidInitJson,
+		Name:     "invalidInitJson",
+		Help:     "Error while decoding init Json",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxClient,
+		Name:     "pktRxClient",
+		Help:     "Rx packets from clients",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxServer,
+		Name:     "pktRxServer",
+		Help:     "Rx packets from server",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxParserErr,
+		Name:     "pktRxParserErr",
+		Help:     "Rx DHCP packet decode error",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxBadOp,
+		Name:     "pktRxBadOp",
+		Help:     "Rx unexpected BOOTP operation",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxMaxHops,
+		Name:     "pktRxMaxHops",
+		Help:     "Rx packets that passed too many relays",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxUntrustedOpt,
+		Name:     "pktRxUntrustedOpt",
+		Help:     "Rx client packets with Option 82 and giaddr 0",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxWrongGiaddr,
+		Name:     "pktRxWrongGiaddr",
+		Help:     "Rx server packets with another giaddr",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxRelayed,
+		Name:     "pktRxRelayed",
+		Help:     "Rx client packets relayed by another relay",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxServer,
+		Name:     "pktTxServer",
+		Help:     "Tx packets to server",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxClient,
+		Name:     "pktTxClient",
+		Help:     "Tx packets to clients",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxNoDgMac,
+		Name:     "pktTxNoDgMac",
+		Help:     "Default gateway MAC is not resolved",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.opt82Inserted,
+		Name:     "opt82Inserted",
+		Help:     "Option 82 inserted",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.opt82Stripped,
+		Name:     "opt82Stripped",
+		Help:     "Option 82 stripped",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.opt82TooLong,
+		Name:     "opt82TooLong",
+		Help:     "Option 82 longer than 255 bytes",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	return db
+}
+
+/*======================================================================================================
+										Plugin DhcpRelay Emu Client
+======================================================================================================*/
+
+// DhcpRelayParams represents the init json Api for the DhcpRelay Emu Client.
+type DhcpRelayParams struct {
+	Server     string `json:"server" validate:"required"` // IPv4 of the DHCP server
+	Giaddr     string `json:"giaddr"`                     // Relay agent address. Default to the client IPv4
+	CircuitId  string `json:"circuit_id"`                 // Circuit ID template, empty to omit the sub-option
+	RemoteId   string `json:"remote_id"`                  // Remote ID template, empty to omit the sub-option
+	NoOption82 bool   `json:"no_option82"`                // Don't insert Option 82
+	MaxHops    uint8  `json:"max_hops"`                   // Maximal hops of a relayed message. Default to DefaultMaxHops
+}
+
+// dhcpRelayEvents holds a list of events on which the DhcpRelay plugin is interested.
+var dhcpRelayEvents = []string{}
+
+// PluginDhcpRelayClient represents an Emu Client that acts as a DHCP Relay Agent.
+type PluginDhcpRelayClient struct {
+	core.PluginBase                     // Plugin Base embedded struct so we get all the base functionality
+	params          DhcpRelayParams     // Init Json params
+	stats           DhcpRelayStats      // DhcpRelay counters
+	cdb             *core.CCounterDb    // Counters database
+	cdbv            *core.CCounterDbVec // Counters database vector
+	nsPlug          *PluginDhcpRelayNs  // Namespace plugin
+	server          core.Ipv4Key        // Server IPv4
+	giaddr          core.Ipv4Key        // Relay agent address
+	svlan           string              // Outer VLAN of the namespace
+	cvlan           string              // Inner VLAN of the namespace
+	vlan            string              // VLANs of the namespace
+}
+
+// NewDhcpRelayClient creates a new DhcpRelay Emu Client Plugin.
+func NewDhcpRelayClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
+	o := new(PluginDhcpRelayClient)
+	o.InitPluginBase(ctx, o)                  // Init base object
+	o.RegisterEvents(ctx, dhcpRelayEvents, o) // Register events
+	nsplg := o.Ns.PluginCtx.GetOrCreate(DHCP_RELAY_PLUG)
+	o.nsPlug = nsplg.Ext.(*PluginDhcpRelayNs)
+	o.cdb = NewDhcpRelayStatsDb(&o.stats) // Register Stats immediately so we can fail safely.
+	o.cdbv = core.NewCCounterDbVec(DHCP_RELAY_PLUG)
+	o.cdbv.Add(o.cdb)
+
+	// Set the default paramaters
+	o.params.CircuitId = DefaultCircuitId
+	o.params.RemoteId = DefaultRemoteId
+	o.params.MaxHops = DefaultMaxHops
+
+	err := o.Tctx.UnmarshalValidate(initJson, &o.params) // Unmarshal and validate init json
+	if err != nil {
+		o.stats.invalidInitJson++
+		return nil, err
+	}
+
+	err = o.OnCreate()
+	if err != nil {
+		o.stats.invalidInitJson++
+		return nil, err
+	}
+	o.nsPlug.addRelay(o)
+
+	return &o.PluginBase, nil
+}
+
+// parseIPv4 parses an IPv4 address of the init JSON.
+func parseIPv4(s string) (ipv4 core.Ipv4Key, err error) {
+	ip := net.ParseIP(s).To4()
+	if ip == nil {
+		return ipv4, fmt.Errorf("Invalid IPv4 %s", s)
+	}
+	copy(ipv4[:], ip)
+	return ipv4, nil
+}
+
+// OnCreate is called upon the creation of a new DhcpRelay Emu client.
+func (o *PluginDhcpRelayClient) OnCreate() (err error) {
+	if o.server, err = parseIPv4(o.params.Server); err != nil {
+		return err
+	}
+	if o.params.Giaddr != "" {
+		if o.giaddr, err = parseIPv4(o.params.Giaddr); err != nil {
+			return err
+		}
+	} else {
+		o.giaddr = o.Client.Ipv4
+	}
+	if o.giaddr.IsZero() {
+		return fmt.Errorf("Relay agent address is not set, set giaddr or the client IPv4")
+	}
+
+	// The VLANs of the namespace for the Option 82 templates
+	var tund core.CTunnelData
+	o.Ns.Key.Get(&tund)
+	var vlans []string
+	for _, val := range tund.Vlans {
+		if val != 0 {
+			vlans = append(vlans, strconv.Itoa(int(val&0xfff)))
+		}
+	}
+	o.svlan, o.cvlan, o.vlan = "0", "0", "0"
+	switch len(vlans) {
+	case 1:
+		o.cvlan = vlans[0]
+	case 2:
+		o.svlan, o.cvlan = vlans[0], vlans[1]
+	}
+	if len(vlans) > 0 {
+		o.vlan = strings.Join(vlans, ".")
+	}
+	return nil
+}
+
+// OnRemove is called upon removing the DhcpRelay Emu client.
+func (o *PluginDhcpRelayClient) OnRemove(ctx *core.PluginCtx) {
+	ctx.UnregisterEvents(&o.PluginBase, dhcpRelayEvents)
+	o.nsPlug.removeRelay(o)
+}
+
+// OnEvent for events the client plugin is registered.
+func (o *PluginDhcpRelayClient) OnEvent(msg string, a, b interface{}) {}
+
+// expandTemplate replaces the {vlan}, {svlan}, {cvlan} and {mac} keywords of a template.
+func (o *PluginDhcpRelayClient) expandTemplate(template string, chaddr net.HardwareAddr) string {
+	r := strings.NewReplacer("{vlan}", o.vlan, "{svlan}", o.svlan, "{cvlan}", o.cvlan, "{mac}", chaddr.String())
+	return r.Replace(template)
+}
+
+// option82 builds the Relay Agent Information option of a client, false in case it is too long.
+func (o *PluginDhcpRelayClient) option82(chaddr net.HardwareAddr) (layers.DHCPOption, bool) {
+	var data []byte
+	for _, sub := range []struct {
+		code     byte
+		template string
+	}{{CircuitIdSubOpt, o.params.CircuitId}, {RemoteIdSubOpt, o.params.RemoteId}} {
+		if sub.template == "" {
+			continue
+		}
+		value := o.expandTemplate(sub.template, chaddr)
+		if len(value) > 255 {
+			return layers.DHCPOption{}, false
+		}
+		data = append(data, sub.code, byte(len(value)))
+		data = append(data, value...)
+	}
+	if len(data) == 0 || len(data) > 255 {
+		return layers.DHCPOption{}, len(data) == 0
+	}
+	return layers.NewDHCPOption(layers.DHCPOptRelayAgentInfo, data), true
+}
+
+// send builds a DHCP packet over UDP/IPv4 and sends it. In case dstMac is nil the default gateway MAC is used.
+func (o *PluginDhcpRelayClient) send(dhcp *layers.DHCPv4, dstMac net.HardwareAddr, dstIp net.IP, dstPort uint16) {
+
+	ipv4 := layers.IPv4{
+		Version:  4,
+		IHL:      5,
+		TTL:      128,
+		Id:       0xcc,
+		SrcIP:    o.giaddr.ToIP(),
+		DstIP:    dstIp,
+		Protocol: layers.IPProtocolUDP}
+
+	pkt := core.PacketUtlBuild(
+		&ipv4,
+		&layers.UDP{SrcPort: DHCPV4_SERVER_PORT, DstPort: layers.UDPPort(dstPort)},
+		dhcp,
+	)
+
+	// Fix Ipv4 length and checksum
+	ipv4Header := layers.IPv4Header(pkt[0:20])
+	ipv4Header.SetLength(uint16(len(pkt)))
+	ipv4Header.UpdateChecksum()
+
+	// Fix UDP Length and Checksum
+	binary.BigEndian.PutUint16(pkt[24:26], uint16(len(pkt)-20))
+	binary.BigEndian.PutUint16(pkt[26:28], 0)
+	cs := layers.PktChecksumTcpUdp(pkt[20:], 0, ipv4Header)
+	binary.BigEndian.PutUint16(pkt[26:28], cs)
+
+	l2 := o.Client.GetL2Header(false, uint16(layers.EthernetTypeIPv4))
+	if dstMac != nil {
+		copy(l2[0:6], dstMac)
+	}
+	pktToSend := append(l2, pkt...)
+
+	o.Tctx.Veth.SendBuffer(dstMac == nil, o.Client, pktToSend, false)
+}
+
+// HandleRxClient relays a message of a client to the server.
+func (o *PluginDhcpRelayClient) HandleRxClient(dhcph *layers.DHCPv4) int {
+	o.stats.pktRxClient++
+
+	if dhcph.Operation != layers.DHCPOpRequest {
+		o.stats.pktRxBadOp++
+		return core.PARSER_ERR
+	}
+
+	if dhcph.HardwareOpts >= o.params.MaxHops {
+		o.stats.pktRxMaxHops++
+		return core.PARSER_OK
+	}
+
+	var giaddr core.Ipv4Key
+	copy(giaddr[:], dhcph.RelayAgentIP.To4())
+
+	if giaddr.IsZero() {
+		for _, op := range dhcph.Options {
+			if op.Type == layers.DHCPOptRelayAgentInfo {
+				// RFC 3046 2.1.1, giaddr 0 with Option 82 arrived on an untrusted circuit
+				o.stats.pktRxUntrustedOpt++
+				return core.PARSER_OK
+			}
+		}
+		dhcph.RelayAgentIP = o.giaddr.ToIP()
+		if !o.params.NoOption82 {
+			if opt, ok := o.option82(dhcph.ClientHWAddr); ok {
+				dhcph.Options = append(dhcph.Options, opt)
+				o.stats.opt82Inserted++
+			} else {
+				o.stats.opt82TooLong++
+			}
+		}
+	} else {
+		// Already relayed by another relay agent, keep its giaddr and Option 82
+		o.stats.pktRxRelayed++
+	}
+	dhcph.HardwareOpts++
+
+	if _, ok := o.Client.ResolveIPv4DGMac(); !ok {
+		o.stats.pktTxNoDgMac++
+		return core.PARSER_OK
+	}
+	o.send(dhcph, nil, o.server.ToIP(), DHCPV4_SERVER_PORT)
+	o.stats.pktTxServer++
+	return core.PARSER_OK
+}
+
+// HandleRxServer relays a reply of the server to the client, without Option 82.
+func (o *PluginDhcpRelayClient) HandleRxServer(dhcph *layers.DHCPv4) int {
+	o.stats.pktRxServer++
+
+	if dhcph.Operation != layers.DHCPOpReply {
+		o.stats.pktRxBadOp++
+		return core.PARSER_ERR
+	}
+
+	var giaddr core.Ipv4Key
+	copy(giaddr[:], dhcph.RelayAgentIP.To4())
+	if giaddr != o.giaddr {
+		o.stats.pktRxWrongGiaddr++
+		return core.PARSER_OK
+	}
+
+	options := dhcph.Options[:0]
+	for _, op := range dhcph.Options {
+		if op.Type == layers.DHCPOptRelayAgentInfo {
+			o.stats.opt82Stripped++
+			continue
+		}
+		options = append(options, op)
+	}
+	dhcph.Options = options
+
+	/*
+		RFC 2131 4.1, if the broadcast bit is set the relay broadcasts the reply to the client, otherwise
+		it is sent to the 'yiaddr' and 'chaddr'. DHCPNAK has no 'yiaddr' and is broadcasted.
+	*/
+	dstMac := net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	dstIp := net.IPv4(255, 255, 255, 255)
+	var yiaddr core.Ipv4Key
+	copy(yiaddr[:], dhcph.YourClientIP.To4())
+	if !dhcph.Broadcast() && !yiaddr.IsZero() && len(dhcph.ClientHWAddr) == 6 {
+		dstMac = dhcph.ClientHWAddr
+		dstIp = yiaddr.ToIP()
+	}
+
+	o.send(dhcph, dstMac, dstIp, DHCPV4_CLIENT_PORT)
+	o.stats.pktTxClient++
+	return core.PARSER_OK
+}
+
+// HandleRxDhcpPacket handles an incoming DHCP packet, fromClient is true for a packet from the client port.
+func (o *PluginDhcpRelayClient) HandleRxDhcpPacket(ps *core.ParserPacketState, fromClient bool) int {
+
+	m := ps.M
+	p := m.GetData()
+
+	dhcphlen := ps.L7Len
+	if dhcphlen < 240 {
+		o.stats.pktRxParserErr++
+		return core.PARSER_ERR
+	}
+
+	var dhcph layers.DHCPv4
+	err := dhcph.DecodeFromBytes(p[ps.L7:ps.L7+dhcphlen], gopacket.NilDecodeFeedback)
+	if err != nil {
+		o.stats.pktRxParserErr++
+		return core.PARSER_ERR
+	}
+
+	if fromClient {
+		return o.HandleRxClient(&dhcph)
+	}
+	return o.HandleRxServer(&dhcph)
+}
+
+/*======================================================================================================
+										Plugin DhcpRelay Ns
+======================================================================================================*/
+// PluginDhcpRelayNs represents the namespace layer for DhcpRelay.
+type PluginDhcpRelayNs struct {
+	core.PluginBase
+	relays []*PluginDhcpRelayClient // Relay agents in the namespace, by creation order
+}
+
+// NewDhcpRelayNs creates a new DhcpRelay namespace plugin
+func NewDhcpRelayNs(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
+	o := new(PluginDhcpRelayNs)
+	o.InitPluginBase(ctx, o)
+	o.RegisterEvents(ctx, []string{}, o)
+	return &o.PluginBase, nil
+}
+
+// OnRemove when removing DhcpRelay namespace plugin.
+func (o *PluginDhcpRelayNs) OnRemove(ctx *core.PluginCtx) {}
+
+// OnEvent for events the namespace plugin is registered.
+func (o *PluginDhcpRelayNs) OnEvent(msg string, a, b interface{}) {}
+
+func (o *PluginDhcpRelayNs) addRelay(relay *PluginDhcpRelayClient) {
+	o.relays = append(o.relays, relay)
+}
+
+func (o *PluginDhcpRelayNs) removeRelay(relay *PluginDhcpRelayClient) {
+	for i := range o.relays {
+		if o.relays[i] == relay {
+			o.relays = append(o.relays[:i], o.relays[i+1:]...)
+			return
+		}
+	}
+}
+
+// HandleRxRelayPacket handles the packets of the relays, it returns false in case the packet is not for a relay.
+func (o *PluginDhcpRelayNs) HandleRxRelayPacket(ps *core.ParserPacketState) (int, bool) {
+
+	/*
+		Note: A broadcast from a client is passed to the first relay in the namespace.
+		A unicast is passed to the relay of the destination MAC.
+	*/
+
+	m := ps.M
+	p := m.GetData()
+	var mackey core.MACKey
+	copy(mackey[:], p[0:6])
+	fromClient := binary.BigEndian.Uint16(p[ps.L4:ps.L4+2]) == DHCPV4_CLIENT_PORT
+
+	var relay *PluginDhcpRelayClient
+	if mackey.IsBroadcast() {
+		if !fromClient || len(o.relays) == 0 {
+			return core.PARSER_OK, false
+		}
+		relay = o.relays[0]
+	} else {
+		client := o.Ns.CLookupByMac(&mackey)
+		if client == nil {
+			return core.PARSER_OK, false
+		}
+		cplg := client.PluginCtx.Get(DHCP_RELAY_PLUG)
+		if cplg == nil {
+			return core.PARSER_OK, false
+		}
+		relay = cplg.Ext.(*PluginDhcpRelayClient)
+	}
+	return relay.HandleRxDhcpPacket(ps, fromClient), true
+}
+
+// HandleRxDhcpRelayPacket is called by the parser for the DHCP ports, the packets that are not for a relay are
+// passed to the server.
+func HandleRxDhcpRelayPacket(ps *core.ParserPacketState) int {
+	ns := ps.Tctx.GetNs(ps.Tun)
+	if ns == nil {
+		return core.PARSER_NEXT
+	}
+	nsplg := ns.PluginCtx.Get(DHCP_RELAY_PLUG)
+	if nsplg == nil {
+		return core.PARSER_NEXT
+	}
+	// A relay agent gets the broadcast of the clients in its namespace and the replies to its own address.
+	if rc, ok := nsplg.Ext.(*PluginDhcpRelayNs).HandleRxRelayPacket(ps); ok {
+		return rc
+	}
+	return core.PARSER_NEXT
+}
+
+/*
+======================================================================================================
+
+	Generate Plugin
+
+======================================================================================================
+*/
+type PluginDhcpRelayCReg struct{}
+type PluginDhcpRelayNsReg struct{}
+
+// NewPlugin creates a new DhcpRelay client plugin.
+func (o PluginDhcpRelayCReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
+	return NewDhcpRelayClient(ctx, initJson)
+}
+
+// NewPlugin creates a new DhcpRelay namespace plugin.
+func (o PluginDhcpRelayNsReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
+	return NewDhcpRelayNs(ctx, initJson)
+}
+
+/*======================================================================================================
+											RPC Methods
+======================================================================================================*/
+
+type (
+	ApiDhcpRelayClientCntHandler struct{} // Counter RPC Handler per Client
+)
+
+// getClientPlugin gets the client plugin given the client parameters (Mac & Tunnel Key)
+func getClientPlugin(ctx interface{}, params *fastjson.RawMessage) (*PluginDhcpRelayClient, error) {
+	tctx := ctx.(*core.CThreadCtx)
+
+	plug, err := tctx.GetClientPlugin(params, DHCP_RELAY_PLUG)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pClient := plug.Ext.(*PluginDhcpRelayClient)
+
+	return pClient, nil
+}
+
+// ApiDhcpRelayClientCntHandler gets the counters of the DhcpRelay client.
+func (h ApiDhcpRelayClientCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	c, err := getClientPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return c.cdbv.GeneralCounters(err, tctx, params, &p)
+}
+
+func init() {
+
+	/* register of plugins callbacks for ns,c level  */
+	core.PluginRegister(DHCP_RELAY_PLUG,
+		core.PluginRegisterData{Client: PluginDhcpRelayCReg{},
+			Ns:     PluginDhcpRelayNsReg{},
+			Thread: nil}) /* no need for thread context for now */
+
+	core.RegisterCB("dhcprelay_c_cnt", ApiDhcpRelayClientCntHandler{}, true) // get counters / meta per client
+
+	/* register parser, the relay shares the ports of the server and gets the packets before it */
+	core.ParserRegisterShared(DHCP_RELAY_PLUG, 1, HandleRxDhcpRelayPacket,
+		core.ParserMatch{L3: uint16(layers.EthernetTypeIPv4), IPProto: uint8(layers.IPProtocolUDP), SrcPort: 68, DstPort: 67},
+		core.ParserMatch{L3: uint16(layers.EthernetTypeIPv4), IPProto: uint8(layers.IPProtocolUDP), SrcPort: 67, DstPort: 67})
+}
+
+func Register(ctx *core.CThreadCtx) {
+	// In order for this plugin to be included in the EMU compilation one must provide this empty register
+	// function. In case you remove the function call, then the core will not include EMU.
+	ctx.RegisterParserCb(DHCP_RELAY_PLUG)
+}
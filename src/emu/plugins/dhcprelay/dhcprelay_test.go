@@ -0,0 +1,205 @@
+/*
+Copyright (c) 2021 Cisco Systems and/or its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+that can be found in the LICENSE file in the root of the source
+tree.
+*/
+
+package dhcprelay
+
+import (
+	"bytes"
+	"emu/core"
+	_ "emu/plugins/dhcpv4"
+	dhcpsrv "emu/plugins/dhcpv4srv"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"flag"
+	"os"
+	"testing"
+	"time"
+)
+
+var monitor int
+
+var (
+	srvMac    = core.MACKey{0, 0, 1, 0, 0, 1}
+	relayMac  = core.MACKey{0, 0, 1, 0, 0, 2}
+	clientMac = core.MACKey{0, 0, 1, 0, 0, 3}
+)
+
+// VethDhcpRelaySim is a loopback veth that verifies Option 82 on both sides of the relay.
+type VethDhcpRelaySim struct {
+	t        *testing.T
+	opt82    []byte // Expected Option 82 towards the server, nil for none
+	toServer int    // Packets relayed to the server
+	toClient int    // Packets relayed to the client
+}
+
+// ProcessTxToRx checks the packets of the relay and loops back each packet.
+func (o *VethDhcpRelaySim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
+	p := m.GetData()
+	if !bytes.Equal(p[6:12], relayMac[:]) {
+		return m
+	}
+	packet := gopacket.NewPacket(p, layers.LayerTypeEthernet, gopacket.Default)
+	udp, _ := packet.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	dhcp, _ := packet.Layer(layers.LayerTypeDHCPv4).(*layers.DHCPv4)
+	if udp == nil || dhcp == nil {
+		return m
+	}
+	var opt82 []byte
+	for _, op := range dhcp.Options {
+		if op.Type == layers.DHCPOptRelayAgentInfo {
+			opt82 = op.Data
+		}
+	}
+	if udp.DstPort == DHCPV4_SERVER_PORT {
+		o.toServer++
+		if !bytes.Equal(opt82, o.opt82) {
+			o.t.Errorf("Bad Option 82 to server, want %v, have %v", o.opt82, opt82)
+		}
+		if !bytes.Equal(dhcp.RelayAgentIP.To4(), []byte{1, 1, 2, 1}) || dhcp.HardwareOpts != 1 {
+			o.t.Errorf("Bad giaddr %v or hops %v", dhcp.RelayAgentIP, dhcp.HardwareOpts)
+		}
+	} else {
+		o.toClient++
+		if opt82 != nil {
+			o.t.Errorf("Option 82 was not stripped, have %v", opt82)
+		}
+	}
+	return m
+}
+
+// DhcpRelayTestBase represents the base parameters for a DhcpRelay test.
+type DhcpRelayTestBase struct {
+	testname  string
+	duration  time.Duration
+	vlans     [2]uint32
+	relayJSON []byte
+	opt82     []byte
+	counters  DhcpRelayStats
+	clientIp  core.Ipv4Key
+}
+
+// Run the test.
+func (o *DhcpRelayTestBase) Run(t *testing.T) {
+	simVeth := VethDhcpRelaySim{t: t, opt82: o.opt82}
+	var simrx core.VethIFSim = &simVeth
+
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	defer tctx.Delete()
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: o.vlans})
+	ns := core.NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+
+	// The server and the relay route to each other
+	srv := core.NewClient(ns, srvMac, core.Ipv4Key{1, 1, 1, 1}, core.Ipv6Key{}, core.Ipv4Key{1, 1, 1, 2})
+	srv.ForceDGW = true
+	srv.Ipv4ForcedgMac = relayMac
+	ns.AddClient(srv)
+	relay := core.NewClient(ns, relayMac, core.Ipv4Key{1, 1, 2, 1}, core.Ipv6Key{}, core.Ipv4Key{1, 1, 2, 2})
+	relay.ForceDGW = true
+	relay.Ipv4ForcedgMac = srvMac
+	ns.AddClient(relay)
+	client := core.NewClient(ns, clientMac, core.Ipv4Key{}, core.Ipv6Key{}, core.Ipv4Key{})
+	ns.AddClient(client)
+
+	srvJSON := []byte(`{"pools": [{"min": "1.1.2.10", "max": "1.1.2.20", "prefix": 24}]}`)
+	if err := srv.PluginCtx.CreatePlugins([]string{dhcpsrv.DHCP_SRV_PLUG}, [][]byte{srvJSON}); err != nil {
+		t.Fatal(err)
+	}
+	if err := relay.PluginCtx.CreatePlugins([]string{DHCP_RELAY_PLUG}, [][]byte{o.relayJSON}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.PluginCtx.CreatePlugins([]string{"dhcp"}, [][]byte{[]byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+	Register(tctx)
+	dhcpsrv.Register(tctx)
+	tctx.RegisterParserCb("dhcp")
+
+	m := false
+	if monitor > 0 {
+		m = true
+	}
+	tctx.Veth.SetDebug(m, os.Stdout, false)
+	tctx.MainLoopSim(o.duration)
+
+	relayPlug := relay.PluginCtx.Get(DHCP_RELAY_PLUG).Ext.(*PluginDhcpRelayClient)
+	relayPlug.cdbv.Dump()
+	if o.counters != relayPlug.stats {
+		t.Fatalf("Bad counters, want %+v, have %+v.\n", o.counters, relayPlug.stats)
+	}
+	if simVeth.toServer != int(o.counters.pktTxServer) || simVeth.toClient != int(o.counters.pktTxClient) {
+		t.Fatalf("Bad relayed packets, to server %d, to client %d.\n", simVeth.toServer, simVeth.toClient)
+	}
+	if client.Ipv4 != o.clientIp {
+		t.Fatalf("Bad client address, want %v, have %v.\n", o.clientIp, client.Ipv4)
+	}
+}
+
+// TestDhcpRelay1 relays a DORA with the default Option 82 templates.
+func TestDhcpRelay1(t *testing.T) {
+	a := &DhcpRelayTestBase{
+		testname:  "dhcprelay1",
+		duration:  10 * time.Second,
+		vlans:     [2]uint32{0x81000064, 0x810000c8},
+		relayJSON: []byte(`{"server": "1.1.1.1"}`),
+		opt82: []byte{CircuitIdSubOpt, 7, '1', '0', '0', '.', '2', '0', '0',
+			RemoteIdSubOpt, 17, '0', '0', ':', '0', '0', ':', '0', '1', ':', '0', '0', ':', '0', '0', ':', '0', '3'},
+		counters: DhcpRelayStats{
+			pktRxClient:   2,
+			pktRxServer:   2,
+			pktTxServer:   2,
+			pktTxClient:   2,
+			opt82Inserted: 2,
+			opt82Stripped: 2,
+		},
+		clientIp: core.Ipv4Key{1, 1, 2, 10},
+	}
+	a.Run(t)
+}
+
+// TestDhcpRelay2 relays a DORA with a circuit id template and without a remote id.
+func TestDhcpRelay2(t *testing.T) {
+	a := &DhcpRelayTestBase{
+		testname:  "dhcprelay2",
+		duration:  10 * time.Second,
+		vlans:     [2]uint32{0x81000064, 0},
+		relayJSON: []byte(`{"server": "1.1.1.1", "circuit_id": "eth0:{svlan}-{cvlan}", "remote_id": ""}`),
+		opt82:     []byte{CircuitIdSubOpt, 10, 'e', 't', 'h', '0', ':', '0', '-', '1', '0', '0'},
+		counters: DhcpRelayStats{
+			pktRxClient:   2,
+			pktRxServer:   2,
+			pktTxServer:   2,
+			pktTxClient:   2,
+			opt82Inserted: 2,
+			opt82Stripped: 2,
+		},
+		clientIp: core.Ipv4Key{1, 1, 2, 10},
+	}
+	a.Run(t)
+}
+
+// TestDhcpRelay3 relays a DORA without Option 82.
+func TestDhcpRelay3(t *testing.T) {
+	a := &DhcpRelayTestBase{
+		testname:  "dhcprelay3",
+		duration:  10 * time.Second,
+		relayJSON: []byte(`{"server": "1.1.1.1", "no_option82": true}`),
+		counters: DhcpRelayStats{
+			pktRxClient: 2,
+			pktRxServer: 2,
+			pktTxServer: 2,
+			pktTxClient: 2,
+		},
+		clientIp: core.Ipv4Key{1, 1, 2, 10},
+	}
+	a.Run(t)
+}
+
+func init() {
+	flag.IntVar(&monitor, "monitor", 0, "monitor")
+}
@@ -11,8 +11,16 @@ RFC 2131 DHCP client
 client inijson {
 	TimerDiscoverSec uint32 `json:"timerd"`
 	TimerOfferSec    uint32 `json:"timero"`
+	Inform           bool   `json:"inform"`
 }:
 
+inform - the client has a static IPv4 and asks only for the configuration with DHCPINFORM
+
+A client with the arp plugin probes the address of the first Ack before it uses it, RFC 2131 4.4.1 and RFC 5227.
+It sends DHCP_PROBE_NUM ARP probes with a zero sender address and waits DHCP_PROBE_WAIT_SEC after the last one.
+When the arp plugin reports a reply from another host (MSG_IPV4_CONFLICT) the client sends DHCPDECLINE and
+restarts after DHCP_DECLINE_WAIT_SEC, otherwise it binds the address. A conflict on a bound address is only counted.
+
 */
 
 import (
@@ -22,9 +30,11 @@ import (
 	"external/google/gopacket"
 	"external/google/gopacket/layers"
 	"external/osamingo/jsonrpc"
+	"fmt"
 	"math/rand"
 	"net"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/intel-go/fastjson"
@@ -40,8 +50,19 @@ const (
 	DHCP_STATE_REBINDING  = 4
 	DHCP_STATE_RENEWING   = 5
 	DHCP_STATE_BOUND      = 6
+	DHCP_STATE_INFORMING  = 7
+	DHCP_STATE_INFORMED   = 8
+	DHCP_STATE_PROBING    = 9
+
+	DHCP_DECLINE_WAIT_SEC   = 10 // RFC 2131 3.1.5, wait before restarting after a decline
+	DHCP_PROBE_NUM          = 3  // RFC 5227 PROBE_NUM
+	DHCP_PROBE_INTERVAL_SEC = 1  // RFC 5227 PROBE_MIN
+	DHCP_PROBE_WAIT_SEC     = 2  // RFC 5227 ANNOUNCE_WAIT, wait for replies after the last probe
+	DHCP_ARP_PLUG           = "arp"
 )
 
+var dhcpStateNames = []string{"init", "rebooting", "requesting", "selecting", "rebinding", "renewing", "bound", "informing", "informed", "probing"}
+
 type DhcpOptionsT struct {
 	DiscoverDhcpClassIdOption *string   `json:"discoverDhcpClassIdOption"`
 	RequestDhcpClassIdOption  *string   `json:"requestDhcpClassIdOption"`
@@ -53,9 +74,31 @@ type DhcpOptionsT struct {
 type DhcpInit struct {
 	TimerDiscoverSec uint32        `json:"timerd"`
 	TimerOfferSec    uint32        `json:"timero"`
+	Inform           bool          `json:"inform"`
 	Options          *DhcpOptionsT `json:"options"`
 }
 
+// DhcpLeaseOption is an option received from the server
+type DhcpLeaseOption struct {
+	Type  uint8       `json:"type"`            // Code of the option
+	Name  string      `json:"name"`            // Name of the option
+	Value interface{} `json:"value,omitempty"` // Decoded value, omitted when the option is not known
+	Data  []byte      `json:"data"`            // Raw data of the option
+}
+
+// DhcpLease is the lease of the client with the options of the last Ack
+type DhcpLease struct {
+	State   string            `json:"state"`
+	Ipv4    core.Ipv4Key      `json:"ipv4"`
+	Server  core.Ipv4Key      `json:"server"`
+	Dg      core.Ipv4Key      `json:"dg"`
+	Lease   uint32            `json:"lease"`  // Lease time in seconds, zero for Inform
+	Expire  uint32            `json:"expire"` // Seconds until the lease expires
+	T1      uint32            `json:"t1"`
+	T2      uint32            `json:"t2"`
+	Options []DhcpLeaseOption `json:"options"`
+}
+
 type DhcpStats struct {
 	pktTxDiscover    uint64
 	pktRxOffer       uint64
@@ -72,6 +115,11 @@ type DhcpStats struct {
 	pktRxNack        uint64
 	pktRxRebind      uint64
 	pktRxBroadcast   uint64
+	pktTxInform      uint64
+	pktRxInformAck   uint64
+	pktTxDecline     uint64
+	pktTxProbe       uint64
+	ipv4Conflict     uint64
 }
 
 func NewDhcpStatsDb(o *DhcpStats) *core.CCounterDb {
@@ -194,6 +242,46 @@ func NewDhcpStatsDb(o *DhcpStats) *core.CCounterDb {
 		DumpZero: false,
 		Info:     core.ScERROR})
 
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxInform,
+		Name:     "pktTxInform",
+		Help:     "tx inform",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxInformAck,
+		Name:     "pktRxInformAck",
+		Help:     "ack for inform from the server",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxDecline,
+		Name:     "pktTxDecline",
+		Help:     "tx decline, the address is used by another host",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxProbe,
+		Name:     "pktTxProbe",
+		Help:     "tx arp probe of the address of the ack",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.ipv4Conflict,
+		Name:     "ipv4Conflict",
+		Help:     "arp conflict events for the address of the client, probed or bound",
+		Unit:     "events",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
 	return db
 }
 
@@ -231,14 +319,17 @@ type PluginDhcpClient struct {
 	requestRenewPktTemplate    []byte
 	l3Offset                   uint16
 	xid                        uint32
-	dhcpReqLength              uint16 // Length of template DHCP Request packet including options
-	requestedIpOptOffset       uint16 // Offset of Requested IP address Option in DHCP Request
-	serverIdOptOffset          uint16 // Offset of DHCP Server Identifier Option in DHCP Request
-	dhcpReqRenewLength         uint16 // Length of template DHCP Request Renew packet including options
-	renewMsgTypeOptOffset      uint16 // Offset of Message Type Option in DHCP Request Renew
+	dhcpReqLength              uint16              // Length of template DHCP Request packet including options
+	requestedIpOptOffset       uint16              // Offset of Requested IP address Option in DHCP Request
+	serverIdOptOffset          uint16              // Offset of DHCP Server Identifier Option in DHCP Request
+	dhcpReqRenewLength         uint16              // Length of template DHCP Request Renew packet including options
+	renewMsgTypeOptOffset      uint16              // Offset of Message Type Option in DHCP Request Renew
+	leaseOptions               []layers.DHCPOption // Options of the last Ack
+	leaseTime                  uint32              // Lease time of the last Ack in seconds
+	ticksBound                 uint64              // Ticks of the last Ack
 }
 
-var dhcpEvents = []string{}
+var dhcpEvents = []string{core.MSG_IPV4_CONFLICT}
 
 /*NewDhcpClient create plugin */
 func NewDhcpClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
@@ -248,7 +339,10 @@ func NewDhcpClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, erro
 		return nil, err
 	}
 
-	o.InitPluginBase(ctx, o)             /* init base object*/
+	o.InitPluginBase(ctx, o) /* init base object*/
+	if o.init.Inform && o.Client.Ipv4.IsZero() {
+		return nil, fmt.Errorf("dhcp inform requires a client with IPv4")
+	}
 	o.RegisterEvents(ctx, dhcpEvents, o) /* register events, only if exits*/
 	nsplg := o.Ns.PluginCtx.GetOrCreate(DHCP_PLUG)
 	o.dhcpNsPlug = nsplg.Ext.(*PluginDhcpNs)
@@ -274,7 +368,11 @@ func (o *PluginDhcpClient) OnCreate() {
 	o.cdbv = core.NewCCounterDbVec("dhcp")
 	o.cdbv.Add(o.cdb)
 	o.timer.SetCB(&o.timerCb, o, 0) // set the callback to OnEvent
-	o.SendDiscover()
+	if o.init.Inform {
+		o.SendInform()
+	} else {
+		o.SendDiscover()
+	}
 }
 
 func (o *PluginDhcpClient) preparePacketTemplate() {
@@ -507,14 +605,134 @@ func (o *PluginDhcpClient) SendDiscover() {
 	o.Tctx.Veth.SendBuffer(false, o.Client, o.discoverPktTemplate, false)
 }
 
+// buildPacket builds a DHCP packet from the client port to the server port
+func (o *PluginDhcpClient) buildPacket(dhcp *layers.DHCPv4, src net.IP) []byte {
+	l2 := o.Client.GetL2Header(true, uint16(layers.EthernetTypeIPv4))
+
+	d := core.PacketUtlBuild(
+		&layers.IPv4{Version: 4, IHL: 5, TTL: 128, Id: 0xcc,
+			SrcIP:    src,
+			DstIP:    net.IPv4(255, 255, 255, 255),
+			Protocol: layers.IPProtocolUDP},
+
+		&layers.UDP{SrcPort: 68, DstPort: 67},
+		dhcp,
+	)
+
+	ipv4 := layers.IPv4Header(d[0:20])
+	ipv4.SetLength(uint16(len(d)))
+	ipv4.UpdateChecksum()
+
+	binary.BigEndian.PutUint16(d[24:26], uint16(len(d)-20))
+	binary.BigEndian.PutUint16(d[26:28], 0)
+	cs := layers.PktChecksumTcpUdp(d[20:], 0, ipv4)
+	binary.BigEndian.PutUint16(d[26:28], cs)
+
+	return append(l2, d...)
+}
+
+func (o *PluginDhcpClient) newDhcpHeader(ciaddr net.IP) *layers.DHCPv4 {
+	return &layers.DHCPv4{Operation: layers.DHCPOpRequest,
+		HardwareType: layers.LinkTypeEthernet,
+		HardwareLen:  6,
+		Xid:          o.xid,
+		ClientIP:     ciaddr,
+		YourClientIP: net.IP{0, 0, 0, 0},
+		NextServerIP: net.IP{0, 0, 0, 0},
+		RelayAgentIP: net.IP{0, 0, 0, 0},
+		ClientHWAddr: net.HardwareAddr(o.Client.Mac[:]),
+		ServerName:   make([]byte, 64), File: make([]byte, 128)}
+}
+
+// SendInform asks for the configuration of the static IPv4 of the client, RFC 2131 3.4
+func (o *PluginDhcpClient) SendInform() {
+	o.state = DHCP_STATE_INFORMING
+	o.ipv4 = o.Client.Ipv4
+
+	dhcp := o.newDhcpHeader(o.ipv4.ToIP())
+	dhcp.Options = append(dhcp.Options,
+		layers.NewDHCPOption(layers.DHCPOptMessageType, []byte{byte(layers.DHCPMsgTypeInform)}),
+		layers.NewDHCPOption(layers.DHCPOptParamsRequest, []byte{byte(layers.DHCPOptSubnetMask),
+			byte(layers.DHCPOptRouter),
+			byte(layers.DHCPOptDomainName),
+			byte(layers.DHCPOptDNS),
+			byte(layers.DHCPOptInterfaceMTU),
+			byte(layers.DHCPOptNTPServers)}),
+		layers.NewDHCPOption(layers.DHCPOptClientID, append([]byte{1}, o.Client.Mac[:]...)))
+
+	o.restartTimer(o.timerDiscoverRetransmitSec)
+	o.stats.pktTxInform++
+	o.Tctx.Veth.SendBuffer(false, o.Client, o.buildPacket(dhcp, o.ipv4.ToIP()), false)
+}
+
+// SendDecline tells the server that the address is used by another host, RFC 2131 4.4.1
+func (o *PluginDhcpClient) SendDecline() {
+	dhcp := o.newDhcpHeader(net.IP{0, 0, 0, 0})
+	dhcp.Options = append(dhcp.Options,
+		layers.NewDHCPOption(layers.DHCPOptRequestIP, o.ipv4[:]),
+		layers.NewDHCPOption(layers.DHCPOptMessageType, []byte{byte(layers.DHCPMsgTypeDecline)}),
+		layers.NewDHCPOption(layers.DHCPOptServerID, o.server[:]),
+		layers.NewDHCPOption(layers.DHCPOptClientID, append([]byte{1}, o.Client.Mac[:]...)))
+
+	o.stats.pktTxDecline++
+	o.Tctx.Veth.SendBuffer(false, o.Client, o.buildPacket(dhcp, net.IPv4(0, 0, 0, 0)), false)
+}
+
+// SendProbe asks with ARP whether another host uses the address of the Ack, the sender address is zero as it is not used yet
+func (o *PluginDhcpClient) SendProbe() {
+	l2 := o.Client.GetL2Header(true, uint16(layers.EthernetTypeARP))
+	arp := core.PacketUtlBuild(&layers.ARP{
+		AddrType:          0x1,
+		Protocol:          0x800,
+		HwAddressSize:     0x6,
+		ProtAddressSize:   0x4,
+		Operation:         layers.ARPRequest,
+		SourceHwAddress:   o.Client.Mac[:],
+		SourceProtAddress: []uint8{0x0, 0x0, 0x0, 0x0},
+		DstHwAddress:      []uint8{0x0, 0x0, 0x0, 0x0, 0x0, 0x0},
+		DstProtAddress:    o.ipv4[:]})
+
+	o.cnt++
+	if o.cnt < DHCP_PROBE_NUM {
+		o.restartTimer(DHCP_PROBE_INTERVAL_SEC)
+	} else {
+		o.restartTimer(DHCP_PROBE_WAIT_SEC)
+	}
+	o.stats.pktTxProbe++
+	o.Tctx.Veth.SendBuffer(false, o.Client, append(l2, arp...), false)
+}
+
+// onConflict declines the probed address and restarts the client, a conflict on a bound address is only counted
+func (o *PluginDhcpClient) onConflict(ipv4 core.Ipv4Key) {
+	if ipv4 != o.ipv4 {
+		return
+	}
+	switch o.state {
+	case DHCP_STATE_PROBING:
+		o.stats.ipv4Conflict++
+		o.SendDecline()
+		o.leaseOptions = nil
+		o.state = DHCP_STATE_INIT
+		o.restartTimer(DHCP_DECLINE_WAIT_SEC)
+	case DHCP_STATE_BOUND, DHCP_STATE_RENEWING, DHCP_STATE_REBINDING:
+		o.stats.ipv4Conflict++
+	}
+}
+
 /*OnEvent support event change of IP  */
 func (o *PluginDhcpClient) OnEvent(msg string, a, b interface{}) {
 
+	switch msg {
+	case core.MSG_IPV4_CONFLICT:
+		o.onConflict(a.(core.Ipv4Key))
+	}
 }
 
 func (o *PluginDhcpClient) OnRemove(ctx *core.PluginCtx) {
 	/* force removing the link to the client */
-	o.SendRenewRebind(false, true, 0)
+	if !o.init.Inform {
+		o.SendRenewRebind(false, true, 0)
+	}
 	ctx.UnregisterEvents(&o.PluginBase, dhcpEvents)
 	// TBD send release message
 	if o.timer.IsRunning() {
@@ -690,6 +908,14 @@ func (o *PluginDhcpClient) onTimerEvent() {
 		o.state = DHCP_STATE_REBINDING
 		o.stats.pktRxRebind++
 		o.SendRenewRebind(true, false, o.timerOfferRetransmitSec)
+	case DHCP_STATE_INFORMING:
+		o.SendInform()
+	case DHCP_STATE_PROBING:
+		if o.cnt < DHCP_PROBE_NUM {
+			o.SendProbe()
+		} else {
+			o.bind(o.ipv4.Uint32(), true)
+		}
 	}
 
 }
@@ -707,36 +933,293 @@ func (o *PluginDhcpClient) HandleAckNak(dhcpmt layers.DHCPMsgType,
 		if o.verifyPkt(dhcph, ipv4, false, server) != 0 {
 			return -1
 		}
-		o.state = DHCP_STATE_BOUND
-		if notify {
-			o.stats.pktRxNotify++
-			ipv4addr := ipv4.GetIPDst()
-			if ipv4addr != 0 {
-				var ipv4key core.Ipv4Key
-				ipv4key.SetUint32(ipv4addr)
-				// update ip
-				o.Client.UpdateIPv4(ipv4key)
-				if !o.dg.IsZero() {
-					// update dg
-					ipv4key.SetUint32(o.dg.Uint32())
-				} else {
-					ipv4key.SetUint32(o.server.Uint32())
-				}
-				o.Client.UpdateDgIPv4(ipv4key)
-			}
-		}
-		o.restartTimer(t1)
+		o.saveLease(dhcph)
 		o.t1 = t1
 		o.t2 = t2
 		if o.t2 < o.t1 {
 			o.t2 = o.t1 + 1
 		}
+		if o.state == DHCP_STATE_REQUESTING && o.Client.PluginCtx.Get(DHCP_ARP_PLUG) != nil {
+			// probe the new address before using it
+			o.ipv4 = convert(dhcph.YourClientIP)
+			o.state = DHCP_STATE_PROBING
+			o.cnt = 0
+			o.SendProbe()
+			return 0
+		}
+		o.bind(ipv4.GetIPDst(), notify)
 	case layers.DHCPMsgTypeNak:
 		o.SendDiscover()
 	}
 	return 0
 }
 
+// bind uses the address of the lease and waits for T1 to renew it
+func (o *PluginDhcpClient) bind(ipv4addr uint32, notify bool) {
+	o.state = DHCP_STATE_BOUND
+	if notify {
+		o.stats.pktRxNotify++
+		if ipv4addr != 0 {
+			var ipv4key core.Ipv4Key
+			ipv4key.SetUint32(ipv4addr)
+			// update ip
+			o.Client.UpdateIPv4(ipv4key)
+			if !o.dg.IsZero() {
+				// update dg
+				ipv4key.SetUint32(o.dg.Uint32())
+			} else {
+				ipv4key.SetUint32(o.server.Uint32())
+			}
+			o.Client.UpdateDgIPv4(ipv4key)
+		}
+	}
+	o.restartTimer(o.t1)
+}
+
+// HandleInformAck keeps the configuration of the Ack to an Inform, the address of the client is static
+func (o *PluginDhcpClient) HandleInformAck(dhcph *layers.DHCPv4, server *core.Ipv4Key) int {
+	if dhcph.Xid != o.xid {
+		o.stats.pktRxWrongXid++
+		return -1
+	}
+
+	if dhcph.HardwareType != layers.LinkTypeEthernet || dhcph.HardwareLen != 6 {
+		o.stats.pktRxWrongHwType++
+		return -1
+	}
+
+	o.stats.pktRxInformAck++
+	if server != nil {
+		o.server = *server
+	}
+	o.state = DHCP_STATE_INFORMED
+	o.saveLease(dhcph)
+	if o.timer.IsRunning() {
+		o.timerw.Stop(&o.timer)
+	}
+	return 0
+}
+
+// saveLease copies the options of the Ack, the packet is freed by the parser
+func (o *PluginDhcpClient) saveLease(dhcph *layers.DHCPv4) {
+	o.leaseOptions = o.leaseOptions[:0]
+	o.leaseTime = 0
+	for _, op := range dhcph.Options {
+		if op.Type == layers.DHCPOptPad || op.Type == layers.DHCPOptEnd {
+			continue
+		}
+		data := append([]byte(nil), op.Data...)
+		o.leaseOptions = append(o.leaseOptions, layers.NewDHCPOption(op.Type, data))
+		if op.Type == layers.DHCPOptLeaseTime && len(data) == 4 {
+			o.leaseTime = binary.BigEndian.Uint32(data)
+		}
+	}
+	o.ticksBound = o.timerw.Ticks
+}
+
+// GetLease returns the lease of the client with the options of the last Ack
+func (o *PluginDhcpClient) GetLease() *DhcpLease {
+	lease := &DhcpLease{State: dhcpStateNames[o.state],
+		Options: make([]DhcpLeaseOption, 0, len(o.leaseOptions))}
+
+	if o.leaseOptions == nil {
+		return lease
+	}
+	lease.Ipv4 = o.ipv4
+	lease.Server = o.server
+	lease.Lease = o.leaseTime
+	lease.T1 = o.t1
+	lease.T2 = o.t2
+	elapsed := uint32((o.timerw.Ticks - o.ticksBound) * uint64(o.timerw.MinTickMsec()) / 1000)
+	if elapsed < o.leaseTime {
+		lease.Expire = o.leaseTime - elapsed
+	}
+
+	for i := range o.leaseOptions {
+		op := &o.leaseOptions[i]
+		if op.Type == layers.DHCPOptRouter && len(op.Data) >= 4 {
+			copy(lease.Dg[:], op.Data[0:4])
+		}
+		lease.Options = append(lease.Options, DhcpLeaseOption{
+			Type:  uint8(op.Type),
+			Name:  op.Type.String(),
+			Value: DecodeOption(op),
+			Data:  op.Data})
+	}
+	if lease.Dg.IsZero() && !o.init.Inform {
+		// the server is the default gateway without the Router option
+		lease.Dg = o.server
+	}
+	return lease
+}
+
+// DecodeOption returns the value of the option, nil when the type is not known or the data is malformed
+func DecodeOption(op *layers.DHCPOption) interface{} {
+	d := op.Data
+	switch op.Type {
+	case layers.DHCPOptSubnetMask, layers.DHCPOptBroadcastAddr, layers.DHCPOptSolicitAddr,
+		layers.DHCPOptRequestIP, layers.DHCPOptServerID:
+		if len(d) == 4 {
+			return net.IP(d).String()
+		}
+
+	case layers.DHCPOptRouter, layers.DHCPOptTimeServer, layers.DHCPOptNameServer, layers.DHCPOptDNS,
+		layers.DHCPOptLogServer, layers.DHCPOptCookieServer, layers.DHCPOptLPRServer,
+		layers.DHCPOptImpressServer, layers.DHCPOptResLocServer, layers.DHCPOptSwapServer,
+		layers.DHCPOptNISServers, layers.DHCPOptNTPServers, layers.DHCPOptNetBIOSTCPNS,
+		layers.DHCPOptNetBIOSTCPDDS:
+		if len(d) > 0 && len(d)%4 == 0 {
+			ips := make([]string, 0, len(d)/4)
+			for i := 0; i < len(d); i += 4 {
+				ips = append(ips, net.IP(d[i:i+4]).String())
+			}
+			return ips
+		}
+
+	case layers.DHCPOptStaticRoute:
+		if len(d) > 0 && len(d)%8 == 0 {
+			routes := make([]string, 0, len(d)/8)
+			for i := 0; i < len(d); i += 8 {
+				routes = append(routes, fmt.Sprintf("%v via %v", net.IP(d[i:i+4]), net.IP(d[i+4:i+8])))
+			}
+			return routes
+		}
+
+	case layers.DHCPOptTimeOffset:
+		if len(d) == 4 {
+			return int32(binary.BigEndian.Uint32(d))
+		}
+
+	case layers.DHCPOptLeaseTime, layers.DHCPOptT1, layers.DHCPOptT2, layers.DHCPOptPathMTUAgingTimeout,
+		layers.DHCPOptARPTimeout, layers.DHCPOptTCPKeepAliveInt:
+		if len(d) == 4 {
+			return binary.BigEndian.Uint32(d)
+		}
+
+	case layers.DHCPOptInterfaceMTU, layers.DHCPOptMaxMessageSize, layers.DHCPOptBootfileSize,
+		layers.DHCPOptDatagramMTU:
+		if len(d) == 2 {
+			return binary.BigEndian.Uint16(d)
+		}
+
+	case layers.DHCPOptDefaultTTL, layers.DHCPOptTCPTTL:
+		if len(d) == 1 {
+			return d[0]
+		}
+
+	case layers.DHCPOptMessageType:
+		if len(d) == 1 {
+			return layers.DHCPMsgType(d[0]).String()
+		}
+
+	case layers.DHCPOptHostname, layers.DHCPOptMeritDumpFile, layers.DHCPOptDomainName,
+		layers.DHCPOptRootPath, layers.DHCPOptExtensionsPath, layers.DHCPOptNISDomain,
+		layers.DHCPOptNetBIOSTCPScope, layers.DHCPOptMessage, layers.DHCPOptClassID:
+		return string(d)
+
+	case layers.DHCPOptVendorOption, layers.DHCPOptRelayAgentInfo:
+		return decodeSubOptions(d)
+
+	case layers.DHCPOptDomainSearch:
+		return decodeDomainSearch(d)
+
+	case layers.DHCPOptClasslessStaticRoute:
+		return decodeClasslessRoutes(d)
+	}
+	return nil
+}
+
+// DhcpSubOption is a sub option of an encapsulated option, like the Vendor Specific Information
+type DhcpSubOption struct {
+	Code uint8  `json:"code"`
+	Data []byte `json:"data"`
+}
+
+// decodeSubOptions decodes encapsulated options, RFC 2132 8.4. Vendors are free to use another format,
+// so nil is returned when the data is not a list of options.
+func decodeSubOptions(d []byte) interface{} {
+	subs := make([]DhcpSubOption, 0)
+	for off := 0; off < len(d); {
+		if off+2 > len(d) || off+2+int(d[off+1]) > len(d) {
+			return nil
+		}
+		l := int(d[off+1])
+		subs = append(subs, DhcpSubOption{Code: d[off], Data: d[off+2 : off+2+l]})
+		off += 2 + l
+	}
+	return subs
+}
+
+// decodeDomainSearch decodes the Domain Search option, RFC 3397. The names may be compressed
+// with pointers from the start of the option.
+func decodeDomainSearch(d []byte) interface{} {
+	names := make([]string, 0)
+	for off := 0; off < len(d); {
+		name, next, ok := decodeDomainName(d, off)
+		if !ok {
+			return nil
+		}
+		names = append(names, name)
+		off = next
+	}
+	return names
+}
+
+// decodeDomainName decodes the name at off, it returns the offset after the name
+func decodeDomainName(d []byte, off int) (string, int, bool) {
+	var labels []string
+	next := -1
+	jumps := 0
+	for {
+		if off >= len(d) {
+			return "", 0, false
+		}
+		l := int(d[off])
+		switch {
+		case l == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.Join(labels, "."), next, true
+		case l&0xc0 == 0xc0:
+			if off+1 >= len(d) || jumps >= len(d) {
+				return "", 0, false
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = (l&0x3f)<<8 | int(d[off+1])
+			jumps++
+		case l&0xc0 != 0:
+			return "", 0, false
+		default:
+			if off+1+l > len(d) {
+				return "", 0, false
+			}
+			labels = append(labels, string(d[off+1:off+1+l]))
+			off += 1 + l
+		}
+	}
+}
+
+// decodeClasslessRoutes decodes the Classless Static Route option, RFC 3442
+func decodeClasslessRoutes(d []byte) interface{} {
+	routes := make([]string, 0)
+	for off := 0; off < len(d); {
+		width := int(d[off])
+		n := (width + 7) / 8
+		if width > 32 || off+1+n+4 > len(d) {
+			return nil
+		}
+		var dst core.Ipv4Key
+		copy(dst[:], d[off+1:off+1+n])
+		router := net.IP(d[off+1+n : off+1+n+4])
+		routes = append(routes, fmt.Sprintf("%v/%d via %v", net.IP(dst[:]), width, router))
+		off += 1 + n + 4
+	}
+	return routes
+}
+
 func (o *PluginDhcpClient) HandleRxDhcpPacket(ps *core.ParserPacketState) int {
 
 	m := ps.M
@@ -828,6 +1311,12 @@ func (o *PluginDhcpClient) HandleRxDhcpPacket(ps *core.ParserPacketState) int {
 	case DHCP_STATE_REBINDING:
 		return o.HandleAckNak(dhcpmt, &dhcph, ipv4, t1, t2, true, server)
 
+	case DHCP_STATE_INFORMING:
+		if dhcpmt == layers.DHCPMsgTypeAck {
+			return o.HandleInformAck(&dhcph, server)
+		}
+		o.stats.pktRxUnhandled++
+
 	default:
 		o.stats.pktRxUnhandled++
 
@@ -952,7 +1441,8 @@ func (o PluginDhcpNsReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) (*core.
 /*******************************************/
 /*  RPC commands */
 type (
-	ApiDhcpClientCntHandler struct{}
+	ApiDhcpClientCntHandler      struct{}
+	ApiDhcpClientGetLeaseHandler struct{}
 )
 
 func getNs(ctx interface{}, params *fastjson.RawMessage) (*PluginDhcpNs, *jsonrpc.Error) {
@@ -999,6 +1489,18 @@ func (h ApiDhcpClientCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.
 	return c.cdbv.GeneralCounters(err, tctx, params, &p)
 }
 
+func (h ApiDhcpClientGetLeaseHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	c, err := getClientPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return c.GetLease(), nil
+}
+
 func init() {
 
 	/* register of plugins callbacks for ns,c level  */
@@ -1022,10 +1524,13 @@ func init() {
 	  aa - misc
 	*/
 
-	core.RegisterCB("dhcp_client_cnt", ApiDhcpClientCntHandler{}, false) // get counters/meta
+	core.RegisterCB("dhcp_client_cnt", ApiDhcpClientCntHandler{}, false)       // get counters/meta
+	core.RegisterCB("dhcp_c_get_lease", ApiDhcpClientGetLeaseHandler{}, false) // get the lease with the decoded options
 
 	/* register callback for rx side*/
-	core.ParserRegister("dhcp", HandleRxDhcpPacket)
+	// S -> C, parse by client
+	core.ParserRegister("dhcp", HandleRxDhcpPacket,
+		core.ParserMatch{L3: uint16(layers.EthernetTypeIPv4), IPProto: uint8(layers.IPProtocolUDP), SrcPort: 67, DstPort: 68})
 }
 
 func Register(ctx *core.CThreadCtx) {
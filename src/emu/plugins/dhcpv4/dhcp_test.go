@@ -7,6 +7,7 @@ package dhcp
 
 import (
 	"emu/core"
+	_ "emu/plugins/arp"
 	"encoding/binary"
 	"encoding/hex"
 	"external/google/gopacket"
@@ -34,6 +35,10 @@ type DhcpTestBase struct {
 	cbArg1       interface{}
 	cbArg2       interface{}
 	options      []byte
+	ipv4         core.Ipv4Key // static address of the client
+	arp          bool         // add the arp plugin
+	lease        bool         // record the lease of the client
+	counters     bool         // record the counters of the client
 }
 
 type IgmpTestCb func(tctx *core.CThreadCtx, test *DhcpTestBase) int
@@ -74,6 +79,12 @@ func (o *DhcpTestBase) Run() {
 	dhcpPlug := nsplg.Ext.(*PluginDhcpClient)
 	dhcpPlug.cdbv.Dump()
 	tctx.GetCounterDbVec().Dump()
+	if o.lease {
+		tctx.SimRecordAppend(dhcpPlug.GetLease())
+	}
+	if o.counters {
+		tctx.SimRecordAppend(dhcpPlug.cdbv.MarshalValues(false))
+	}
 
 	//tctx.SimRecordAppend(igmpPlug.cdb.MarshalValues(false))
 	tctx.SimRecordCompare(o.testname, o.t)
@@ -90,7 +101,7 @@ func createSimulationEnv(simRx *core.VethIFSim, num int, test *DhcpTestBase) (*c
 	dg := core.Ipv4Key{0, 0, 0, 0}
 
 	client := core.NewClient(ns, core.MACKey{0, 0, 1, 0, 0, 1},
-		core.Ipv4Key{0, 0, 0, 0},
+		test.ipv4,
 		core.Ipv6Key{},
 		dg)
 	err := ns.AddClient(client)
@@ -98,24 +109,31 @@ func createSimulationEnv(simRx *core.VethIFSim, num int, test *DhcpTestBase) (*c
 		test.t.Fatal(err)
 	}
 	emptyJsonObj := []byte("{}")
-	err = ns.PluginCtx.CreatePlugins([]string{"dhcp"}, [][]byte{emptyJsonObj})
+	plugins := []string{"dhcp"}
+	if test.arp {
+		plugins = append(plugins, "arp")
+	}
+	err = ns.PluginCtx.CreatePlugins(plugins, [][]byte{emptyJsonObj, emptyJsonObj})
 	if err != nil {
 		test.t.Fatal(err)
 	}
 
 	var inijson [][]byte
 	if test.options == nil {
-		inijson = [][]byte{emptyJsonObj}
+		inijson = [][]byte{emptyJsonObj, emptyJsonObj}
 	} else {
-		inijson = [][]byte{test.options}
+		inijson = [][]byte{test.options, emptyJsonObj}
 	}
 
-	err = client.PluginCtx.CreatePlugins([]string{"dhcp"}, inijson)
+	err = client.PluginCtx.CreatePlugins(plugins, inijson)
 	if err != nil {
 		test.t.Fatal(err)
 	}
 	ns.Dump()
 	tctx.RegisterParserCb("dhcp")
+	if test.arp {
+		tctx.RegisterParserCb("arp")
+	}
 
 	nsplg := ns.PluginCtx.Get(DHCP_PLUG)
 	if nsplg == nil {
@@ -127,10 +145,12 @@ func createSimulationEnv(simRx *core.VethIFSim, num int, test *DhcpTestBase) (*c
 }
 
 type VethIgmpSim struct {
-	DropAll bool
-	cnt     uint8
-	match   uint8
-	tctx    *core.CThreadCtx
+	DropAll  bool
+	cnt      uint8
+	match    uint8
+	tctx     *core.CThreadCtx
+	conflict bool // another host claimed the address of the client
+	probes   int  // arp probes of the client
 }
 
 func genMbuf(tctx *core.CThreadCtx, pkt []byte) *core.Mbuf {
@@ -150,6 +170,25 @@ func (o *VethIgmpSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
 
 	off := 14 + 8 + 20 + 8
 
+	if (o.match == 4 || o.match == 6) && binary.BigEndian.Uint16(m.GetData()[20:22]) == uint16(layers.EthernetTypeARP) {
+		arpHeader := layers.ArpHeader(m.GetData()[22:])
+		if arpHeader.GetSrcIpAddress() == 0 {
+			o.probes++
+		}
+		if o.match == 4 && o.probes == 1 && !o.conflict {
+			// the first probe of the client is answered by a host that uses the address
+			o.conflict = true
+			mr = genMbuf(o.tctx, GenerateProbeReplyPacket(net.IPv4(16, 0, 0, 2)))
+		}
+		if o.match == 6 && arpHeader.GetSrcIpAddress() != 0 && !o.conflict {
+			// the bound address is claimed by another host
+			o.conflict = true
+			mr = genMbuf(o.tctx, GenerateConflictArpPacket(net.IPv4(16, 0, 0, 2)))
+		}
+		m.FreeMbuf()
+		return mr
+	}
+
 	if m.PktLen() <= uint32(off) {
 		m.FreeMbuf()
 		return nil
@@ -195,6 +234,19 @@ func (o *VethIgmpSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
 			pkt := GenerateOfferPacket(dhcph.Xid, net.IPv4(16, 0, 0, 1), net.IPv4(16, 0, 0, 2), int(layers.DHCPMsgTypeOffer), true)
 			mr = genMbuf(o.tctx, pkt)
 		}
+	case 4, 6:
+		if dhcpmt == layers.DHCPMsgTypeDiscover {
+			pkt := GenerateOfferPacket(dhcph.Xid, net.IPv4(16, 0, 0, 1), net.IPv4(16, 0, 0, 2), int(layers.DHCPMsgTypeOffer), false)
+			mr = genMbuf(o.tctx, pkt)
+		}
+		if dhcpmt == layers.DHCPMsgTypeRequest {
+			pkt := GenerateOfferPacket(dhcph.Xid, net.IPv4(16, 0, 0, 1), net.IPv4(16, 0, 0, 2), int(layers.DHCPMsgTypeAck), false)
+			mr = genMbuf(o.tctx, pkt)
+		}
+	case 5:
+		if dhcpmt == layers.DHCPMsgTypeInform {
+			mr = genMbuf(o.tctx, GenerateInformAckPacket(dhcph.Xid))
+		}
 	}
 
 	m.FreeMbuf()
@@ -342,6 +394,197 @@ func GenerateOfferPacket(xid uint32, src net.IP, dst net.IP, dt int, broadcast b
 	}
 }
 
+// GenerateInformAckPacket generates an Ack to an Inform of 16.0.0.2 with the options of a typical server
+func GenerateInformAckPacket(xid uint32) []byte {
+
+	dhcpAck := &layers.DHCPv4{Operation: layers.DHCPOpReply,
+		HardwareType: layers.LinkTypeEthernet,
+		HardwareLen:  6,
+		Xid:          xid,
+		ClientIP:     net.IP{16, 0, 0, 2},
+		YourClientIP: net.IP{0, 0, 0, 0},
+		NextServerIP: net.IP{0, 0, 0, 0},
+		RelayAgentIP: net.IP{0, 0, 0, 0},
+		ClientHWAddr: net.HardwareAddr{0, 0, 1, 0, 0, 1},
+		ServerName:   make([]byte, 64), File: make([]byte, 128)}
+	dhcpAck.Options = append(dhcpAck.Options,
+		layers.NewDHCPOption(layers.DHCPOptMessageType, []byte{byte(layers.DHCPMsgTypeAck)}),
+		layers.NewDHCPOption(layers.DHCPOptServerID, []byte{16, 0, 0, 1}),
+		layers.NewDHCPOption(layers.DHCPOptSubnetMask, []byte{255, 255, 255, 0}),
+		layers.NewDHCPOption(layers.DHCPOptTimeOffset, []byte{0xff, 0xff, 0xf1, 0xf0}),
+		layers.NewDHCPOption(layers.DHCPOptRouter, []byte{16, 0, 0, 1}),
+		layers.NewDHCPOption(layers.DHCPOptDNS, []byte{8, 8, 8, 8, 8, 8, 4, 4}),
+		layers.NewDHCPOption(layers.DHCPOptDomainName, []byte("trex.local")),
+		layers.NewDHCPOption(layers.DHCPOptInterfaceMTU, []byte{0x05, 0x78}),
+		layers.NewDHCPOption(layers.DHCPOptVendorOption, []byte{1, 2, 0xa, 0xb, 2, 1, 0xc}),
+		layers.NewDHCPOption(layers.DHCPOptDomainSearch, []byte{4, 't', 'r', 'e', 'x', 5, 'l', 'o', 'c', 'a', 'l', 0,
+			3, 'l', 'a', 'b', 0xc0, 0}),
+		layers.NewDHCPOption(layers.DHCPOptClasslessStaticRoute, []byte{8, 10, 16, 0, 0, 1, 0, 16, 0, 0, 1}),
+		layers.NewDHCPOption(layers.DHCPOpt(224), []byte{1, 2, 3}))
+
+	dr := core.PacketUtlBuild(
+		&layers.IPv4{Version: 4, IHL: 5, TTL: 128, Id: 0xcc,
+			SrcIP:    net.IPv4(16, 0, 0, 1),
+			DstIP:    net.IPv4(16, 0, 0, 2),
+			Protocol: layers.IPProtocolUDP},
+
+		&layers.UDP{SrcPort: 67, DstPort: 68},
+		dhcpAck,
+	)
+
+	ipv4 := layers.IPv4Header(dr[0:20])
+	ipv4.SetLength(uint16(len(dr)))
+	ipv4.UpdateChecksum()
+
+	binary.BigEndian.PutUint16(dr[24:26], uint16(len(dr)-20))
+	binary.BigEndian.PutUint16(dr[26:28], 0)
+
+	return append(getL2(), dr...)
+}
+
+// GenerateConflictArpPacket generates an ARP request of another host that uses ipv4
+func GenerateConflictArpPacket(ipv4 net.IP) []byte {
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{}
+	gopacket.SerializeLayers(buf, opts,
+		&layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0, 0, 2, 0, 0, 1},
+			DstMAC:       net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			EthernetType: layers.EthernetTypeDot1Q,
+		},
+		&layers.Dot1Q{
+			VLANIdentifier: uint16(1),
+			Type:           layers.EthernetTypeDot1Q,
+		},
+		&layers.Dot1Q{
+			VLANIdentifier: uint16(2),
+			Type:           layers.EthernetTypeARP,
+		},
+		&layers.ARP{
+			AddrType:          0x1,
+			Protocol:          0x800,
+			HwAddressSize:     0x6,
+			ProtAddressSize:   0x4,
+			Operation:         layers.ARPRequest,
+			SourceHwAddress:   net.HardwareAddr{0, 0, 2, 0, 0, 1},
+			SourceProtAddress: ipv4.To4(),
+			DstHwAddress:      []uint8{0x0, 0x0, 0x0, 0x0, 0x0, 0x0},
+			DstProtAddress:    []uint8{16, 0, 0, 1}})
+	return buf.Bytes()
+}
+
+// GenerateProbeReplyPacket generates the ARP reply of another host that uses ipv4 to the probe of the client
+func GenerateProbeReplyPacket(ipv4 net.IP) []byte {
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{}
+	gopacket.SerializeLayers(buf, opts,
+		&layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0, 0, 2, 0, 0, 1},
+			DstMAC:       net.HardwareAddr{0, 0, 1, 0, 0, 1},
+			EthernetType: layers.EthernetTypeDot1Q,
+		},
+		&layers.Dot1Q{
+			VLANIdentifier: uint16(1),
+			Type:           layers.EthernetTypeDot1Q,
+		},
+		&layers.Dot1Q{
+			VLANIdentifier: uint16(2),
+			Type:           layers.EthernetTypeARP,
+		},
+		&layers.ARP{
+			AddrType:          0x1,
+			Protocol:          0x800,
+			HwAddressSize:     0x6,
+			ProtAddressSize:   0x4,
+			Operation:         layers.ARPReply,
+			SourceHwAddress:   net.HardwareAddr{0, 0, 2, 0, 0, 1},
+			SourceProtAddress: ipv4.To4(),
+			DstHwAddress:      []uint8{0, 0, 1, 0, 0, 1},
+			DstProtAddress:    []uint8{0, 0, 0, 0}})
+	return buf.Bytes()
+}
+
+// TestPluginDhcp8 the server hands out an address that is used by another host, the client probes it,
+// declines it and binds it after the next probes are not answered
+func TestPluginDhcp8(t *testing.T) {
+	a := &DhcpTestBase{
+		t:            t,
+		testname:     "dhcp8",
+		dropAll:      false,
+		monitor:      false,
+		match:        4,
+		capture:      true,
+		duration:     30 * time.Second,
+		clientsToSim: 1,
+		arp:          true,
+		lease:        true,
+		counters:     true,
+	}
+	a.Run()
+}
+
+// TestPluginDhcp10 the bound address is claimed by another host, the conflict is counted without a decline
+func TestPluginDhcp10(t *testing.T) {
+	a := &DhcpTestBase{
+		t:            t,
+		testname:     "dhcp10",
+		dropAll:      false,
+		monitor:      false,
+		match:        6,
+		capture:      true,
+		duration:     30 * time.Second,
+		clientsToSim: 1,
+		arp:          true,
+		lease:        true,
+		counters:     true,
+	}
+	a.Run()
+}
+
+// TestPluginDhcp9 the client with a static address asks for the configuration with Inform
+func TestPluginDhcp9(t *testing.T) {
+	a := &DhcpTestBase{
+		t:            t,
+		testname:     "dhcp9",
+		dropAll:      false,
+		monitor:      false,
+		match:        5,
+		capture:      true,
+		duration:     30 * time.Second,
+		clientsToSim: 1,
+		options:      []byte(`{"inform": true}`),
+		ipv4:         core.Ipv4Key{16, 0, 0, 2},
+		lease:        true,
+	}
+	a.Run()
+}
+
+// TestPluginDhcpDecodeOption decodes the options of a lease
+func TestPluginDhcpDecodeOption(t *testing.T) {
+	type decodeTest struct {
+		op   layers.DHCPOption
+		want string
+	}
+	tests := []decodeTest{
+		{layers.NewDHCPOption(layers.DHCPOptRouter, []byte{16, 0, 0, 1, 16, 0, 0, 2}), "[16.0.0.1 16.0.0.2]"},
+		{layers.NewDHCPOption(layers.DHCPOptRouter, []byte{16, 0, 0}), "<nil>"},
+		{layers.NewDHCPOption(layers.DHCPOptLeaseTime, []byte{0, 0, 0xe, 0x10}), "3600"},
+		{layers.NewDHCPOption(layers.DHCPOptTimeOffset, []byte{0xff, 0xff, 0xf1, 0xf0}), "-3600"},
+		{layers.NewDHCPOption(layers.DHCPOptMessageType, []byte{byte(layers.DHCPMsgTypeAck)}), "Ack"},
+		{layers.NewDHCPOption(layers.DHCPOptDomainSearch, []byte{3, 'c', 'o', 'm', 0, 1, 'a', 0xc0, 0}), "[com a.com]"},
+		{layers.NewDHCPOption(layers.DHCPOptDomainSearch, []byte{1, 'a', 0xc0, 0}), "<nil>"},
+		{layers.NewDHCPOption(layers.DHCPOptClasslessStaticRoute, []byte{0, 1, 1, 1, 1, 24, 10, 1, 2, 1, 1, 1, 2}),
+			"[0.0.0.0/0 via 1.1.1.1 10.1.2.0/24 via 1.1.1.2]"},
+		{layers.NewDHCPOption(layers.DHCPOptVendorOption, []byte{1, 3, 'a'}), "<nil>"},
+		{layers.NewDHCPOption(layers.DHCPOpt(224), []byte{1}), "<nil>"},
+	}
+	for _, test := range tests {
+		if have := fmt.Sprintf("%v", DecodeOption(&test.op)); have != test.want {
+			t.Errorf("Bad decode of %v, want %v, have %v", test.op.Type, test.want, have)
+		}
+	}
+}
+
 func TestPluginDhcp2(t *testing.T) {
 	// generate a offer packet
 	return
@@ -1185,6 +1185,16 @@ func (o *PluginDhcpSrvClient) OnClientRemove(ctx *DhcpClientCtx) {
 	o.stats.activeClients--
 }
 
+// getRelayAgentInfo returns the relay agent information option (82) of a message, in case it exists.
+func getRelayAgentInfo(dhcph layers.DHCPv4) (layers.DHCPOption, bool) {
+	for _, op := range dhcph.Options {
+		if op.Type == layers.DHCPOptRelayAgentInfo {
+			return op, true
+		}
+	}
+	return layers.DHCPOption{}, false
+}
+
 // SendOffer sends a DHCPOFFER to a client whose DHCPDISCOVER we have received.
 func (o *PluginDhcpSrvClient) SendOffer(dhcph layers.DHCPv4, yiaddr core.Ipv4Key, subnetMask core.Ipv4Key, lease uint32) {
 	dhcp := &layers.DHCPv4{
@@ -1204,6 +1214,10 @@ func (o *PluginDhcpSrvClient) SendOffer(dhcph layers.DHCPv4, yiaddr core.Ipv4Key
 		Options:      o.offerOpt,
 	}
 
+	if opt, ok := getRelayAgentInfo(dhcph); ok {
+		dhcp.Options = append(dhcp.Options, opt) // RFC 3046 2.2, echo the relay agent information
+	}
+
 	/*
 		If the 'giaddr' field in a DHCP message from a client is non-zero,
 		the server sends any return messages to the 'DHCP server' port on the
@@ -1317,6 +1331,10 @@ func (o *PluginDhcpSrvClient) SendAck(dhcph layers.DHCPv4, yiaddr core.Ipv4Key,
 		Options:      options,
 	}
 
+	if opt, ok := getRelayAgentInfo(dhcph); ok {
+		dhcp.Options = append(dhcp.Options, opt) // RFC 3046 2.2, echo the relay agent information
+	}
+
 	/*
 		If the 'giaddr' field in a DHCP message from a client is non-zero,
 		the server sends any return messages to the 'DHCP server' port on the
@@ -1433,6 +1451,10 @@ func (o *PluginDhcpSrvClient) SendNak(dhcph layers.DHCPv4) {
 		Options:      o.nakOpt,
 	}
 
+	if opt, ok := getRelayAgentInfo(dhcph); ok {
+		dhcp.Options = append(dhcp.Options, opt) // RFC 3046 2.2, echo the relay agent information
+	}
+
 	/*
 		If the 'giaddr' field in a DHCP message from a client is non-zero,
 		the server sends any return messages to the 'DHCP server' port on the
@@ -1929,7 +1951,13 @@ func init() {
 	core.RegisterCB("dhcpsrv_c_cnt", ApiDhcpSrvClientCntHandler{}, true) // get counters / meta per client
 
 	/* register parser */
-	core.ParserRegister(DHCP_SRV_PLUG, HandleRxDhcpPacket)
+	// C -> S, parse by server.
+	// If C -> S without relay, the source port is 68.
+	// If C -> S with relay, the relay changes the source port to 67.
+	// The ports are shared with the relay, that gets the packets first.
+	core.ParserRegisterShared(DHCP_SRV_PLUG, 0, HandleRxDhcpPacket,
+		core.ParserMatch{L3: uint16(layers.EthernetTypeIPv4), IPProto: uint8(layers.IPProtocolUDP), SrcPort: 68, DstPort: 67},
+		core.ParserMatch{L3: uint16(layers.EthernetTypeIPv4), IPProto: uint8(layers.IPProtocolUDP), SrcPort: 67, DstPort: 67})
 }
 
 func Register(ctx *core.CThreadCtx) {
@@ -17,6 +17,7 @@ import (
 	"external/google/gopacket"
 	"external/google/gopacket/layers"
 	"external/osamingo/jsonrpc"
+	"fmt"
 	"math/rand"
 	"net"
 	"sort"
@@ -44,6 +45,7 @@ const (
 	STATUS_UseMulticast    = 5
 	STATUS_NoPrefixAvail   = 6
 	REQ_MAX_RC             = 10 /* Max Request retry attempts */
+	PD_CARVE_DEFAULT_LEN   = 64 /* Default prefix length of a carved sibling subnet */
 	DEFAULT_TIMEOUT_T1_SEC = 1800
 	DEFAULT_TIMEOUT_T2_SEC = 3600
 )
@@ -60,11 +62,32 @@ type DhcpOptionsT struct {
 	RemoveVC bool      `json:"rm_vc"` // Remove Default Vendor Class
 }
 
+// DhcpPdInit enables prefix delegation (IA_PD), the client requests a prefix like a CPE router.
+type DhcpPdInit struct {
+	PrefixLen uint8         `json:"prefix_len"` // Prefix length hint, 0 for no hint
+	NoIana    bool          `json:"no_iana"`    // Request only a prefix, without an address (IA_NA)
+	Carve     []core.MACKey `json:"carve"`      // Sibling clients in the namespace that get an address from the first delegated prefix
+	CarveLen  uint8         `json:"carve_len"`  // Prefix length of the subnet of each sibling, 64 by default
+}
+
 // DhcpInit represents the Init Json for Dhcpv6 plugin
 type DhcpInit struct {
 	TimerDiscoverSec uint32        `json:"timerd"`
 	TimerOfferSec    uint32        `json:"timero"`
 	Options          *DhcpOptionsT `json:"options"`
+	Pd               *DhcpPdInit   `json:"pd"`
+}
+
+// DhcpPdPrefix is a delegated prefix with its lifetimes, the times are in seconds.
+type DhcpPdPrefix struct {
+	Prefix        core.Ipv6Key `json:"prefix"`
+	PrefixLen     uint8        `json:"prefix_len"`
+	PreferredLife uint32       `json:"preferred_life"`
+	ValidLife     uint32       `json:"valid_life"`
+	T1            uint32       `json:"t1"`
+	T2            uint32       `json:"t2"`
+	Expire        uint32       `json:"expire"` // Seconds until the prefix is no longer valid
+	ticksBound    uint64       // Ticks of the last reply that had the prefix
 }
 
 // DhcpStats is a struct that aggregates Dhcpv6 statistics.
@@ -96,6 +119,15 @@ type DhcpStats struct {
 	pktRxNotify    uint64
 	pktRxRenew     uint64
 	pktRxRebind    uint64
+
+	pktRxNoIAPD      uint64
+	pktRxWrongIAPDId uint64
+	pdAdd            uint64
+	pdRemove         uint64
+	pdExpired        uint64
+	pdActive         uint64
+	pdCarve          uint64
+	pdCarveErr       uint64
 }
 
 func NewDhcpStatsDb(o *DhcpStats) *core.CCounterDb {
@@ -297,6 +329,70 @@ func NewDhcpStatsDb(o *DhcpStats) *core.CCounterDb {
 		DumpZero: false,
 		Info:     core.ScERROR})
 
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxNoIAPD,
+		Name:     "pktRxNoIAPD",
+		Help:     "rx no IA_PD server information",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxWrongIAPDId,
+		Name:     "pktRxWrongIAPDId",
+		Help:     "rx wrong IA_PD id",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pdAdd,
+		Name:     "pdAdd",
+		Help:     "delegated prefix added",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pdRemove,
+		Name:     "pdRemove",
+		Help:     "delegated prefix removed by the server",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pdExpired,
+		Name:     "pdExpired",
+		Help:     "delegated prefix valid lifetime expired",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pdActive,
+		Name:     "pdActive",
+		Help:     "active delegated prefixes",
+		Unit:     "prefixes",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pdCarve,
+		Name:     "pdCarve",
+		Help:     "sibling addresses carved from the delegated prefix",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pdCarveErr,
+		Name:     "pdCarveErr",
+		Help:     "sibling is missing or does not fit in the delegated prefix",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
 	return db
 }
 
@@ -349,6 +445,8 @@ type PluginDhcpClient struct {
 	iaid                       uint32
 	serverOption               []byte
 	pktIana                    layers.DHCPv6OptionIANA
+	pktIapd                    layers.DHCPv6OptionIAPD
+	prefixes                   []DhcpPdPrefix // Delegated prefixes
 }
 
 var dhcpEvents = []string{}
@@ -367,6 +465,17 @@ func NewDhcpClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, erro
 	if o.init.TimerOfferSec > 0 {
 		o.timerOfferRetransmitSec = o.init.TimerOfferSec
 	}
+	if pd := o.init.Pd; pd != nil {
+		if pd.PrefixLen > 128 {
+			return nil, fmt.Errorf("invalid prefix length hint %d", pd.PrefixLen)
+		}
+		if pd.CarveLen == 0 {
+			pd.CarveLen = PD_CARVE_DEFAULT_LEN
+		}
+		if pd.CarveLen > 64 {
+			return nil, fmt.Errorf("invalid carve length %d, the sibling interface id is 64 bits", pd.CarveLen)
+		}
+	}
 
 	o.InitPluginBase(ctx, o)             /* init base object*/
 	o.RegisterEvents(ctx, dhcpEvents, o) /* register events, only if exits*/
@@ -548,7 +657,12 @@ func (o *PluginDhcpClient) createOptions(dhcpv6 *layers.DHCPv6, msgType layers.D
 	if !removeVendorClass {
 		optionsMap[layers.DHCPv6OptVendorClass] = []byte{0x00, 0x00, 0x01, 0x37, 0x00, 0x08, 0x4d, 0x53, 0x46, 0x54, 0x20, 0x35, 0x2e, 0x30}
 	}
-	optionsMap[layers.DHCPv6OptIANA] = ianaOpt
+	if o.init.Pd == nil || !o.init.Pd.NoIana {
+		optionsMap[layers.DHCPv6OptIANA] = ianaOpt
+	}
+	if o.init.Pd != nil {
+		optionsMap[layers.DHCPv6OptIAPD] = o.iapdOption()
+	}
 	optionsMap[layers.DHCPv6OptElapsedTime] = []byte{0x00, 0x00}
 
 	if optionsBinary != nil {
@@ -577,6 +691,18 @@ func (o *PluginDhcpClient) createOptions(dhcpv6 *layers.DHCPv6, msgType layers.D
 	}
 }
 
+// iapdOption builds the IA_PD option, with an IA prefix in case there is a prefix length hint.
+func (o *PluginDhcpClient) iapdOption() []byte {
+	iapd := make([]byte, 12)
+	binary.BigEndian.PutUint32(iapd[0:4], o.iaid)
+	if o.init.Pd.PrefixLen == 0 {
+		return iapd
+	}
+	prefix := make([]byte, 25)
+	prefix[8] = o.init.Pd.PrefixLen
+	return append(iapd, EncodeOption(layers.NewDHCPv6Option(layers.DHCPv6OptIAPrefix, prefix))...)
+}
+
 // SendDhcpPacket sends the correct DhcpPacket based on the message type. It updates the elapsed time and
 // might append the server option.
 func (o *PluginDhcpClient) SendDhcpPacket(msgType layers.DHCPv6MsgType, serverOption bool) {
@@ -665,6 +791,9 @@ func (o *PluginDhcpClient) OnRemove(ctx *core.PluginCtx) {
 	if o.timer.IsRunning() {
 		o.timerw.Stop(&o.timer)
 	}
+	o.stats.pdActive = 0
+	o.prefixes = nil
+	o.carve()
 }
 
 func (o *PluginDhcpClient) SendRenewRebind(rebind bool, release bool, timerSec uint32) {
@@ -705,6 +834,7 @@ func (o *PluginDhcpClient) verifyPkt(dhcph *layers.DHCPv6,
 	cid []byte,
 	sid []byte,
 	validIana bool,
+	validIapd bool,
 ) int {
 
 	var verifysid bool
@@ -724,6 +854,19 @@ func (o *PluginDhcpClient) verifyPkt(dhcph *layers.DHCPv6,
 		return -1
 	}
 
+	if o.init.Pd != nil {
+		if !validIapd {
+			o.stats.pktRxNoIAPD++
+			if o.init.Pd.NoIana {
+				return -1
+			}
+		} else if o.pktIapd.IAID != o.iaid {
+			o.stats.pktRxWrongIAPDId++
+			return -1
+		}
+		validIana = validIana || o.init.Pd.NoIana
+	}
+
 	if !validIana {
 		o.stats.pktRxNoIANA++
 		return -1
@@ -773,6 +916,7 @@ func (o *PluginDhcpClient) restartTimer(sec uint32) {
 // onTimerEvent on timer event callback
 func (o *PluginDhcpClient) onTimerEvent() {
 	o.cnt++
+	o.expirePrefixes()
 
 	if o.cnt > REQ_MAX_RC {
 		// reset to discover
@@ -817,7 +961,8 @@ func (o *PluginDhcpClient) HandleAckNak(dhcpmt layers.DHCPv6MsgType,
 	dhcph *layers.DHCPv6,
 	ipv6 layers.IPv6Header,
 	notify bool,
-	status uint16) int {
+	status uint16,
+	validIapd bool) int {
 
 	if status != STATUS_Success {
 		o.SendDiscover()
@@ -828,15 +973,31 @@ func (o *PluginDhcpClient) HandleAckNak(dhcpmt layers.DHCPv6MsgType,
 	case layers.DHCPv6MsgTypeReply:
 		o.stats.pktRxAck++
 		o.state = DHCP_STATE_BOUND
-		if notify {
+		iana := o.init.Pd == nil || !o.init.Pd.NoIana
+		if notify && iana {
 			o.stats.pktRxNotify++
 			var NewIpv6 core.Ipv6Key
 			copy(NewIpv6[:], o.pktIana.IPv6)
 			o.Client.UpdateDIPv6(NewIpv6)
 		}
 
-		o.t1 = normTime(o.pktIana.T1, false)
-		o.t2 = normTime(o.pktIana.T2, true)
+		if iana {
+			o.t1 = normTime(o.pktIana.T1, false)
+			o.t2 = normTime(o.pktIana.T2, true)
+		} else {
+			o.t1 = normTime(o.pktIapd.T1, false)
+			o.t2 = normTime(o.pktIapd.T2, true)
+		}
+		if validIapd {
+			o.updatePrefixes()
+			// renew as soon as one of the IAs should be renewed
+			if t1 := normTime(o.pktIapd.T1, false); t1 < o.t1 {
+				o.t1 = t1
+			}
+			if t2 := normTime(o.pktIapd.T2, true); t2 < o.t2 {
+				o.t2 = t2
+			}
+		}
 		if o.t2 < o.t1 {
 			o.t2 = o.t1 + 60
 		}
@@ -846,6 +1007,132 @@ func (o *PluginDhcpClient) HandleAckNak(dhcpmt layers.DHCPv6MsgType,
 	return 0
 }
 
+// updatePrefixes replaces the delegated prefixes with the prefixes of the last reply, a prefix with zero valid lifetime is removed.
+func (o *PluginDhcpClient) updatePrefixes() {
+	var prefixes []DhcpPdPrefix
+	for _, p := range o.pktIapd.Prefixes {
+		if p.ValidLife == 0 || len(p.Prefix) != net.IPv6len {
+			continue
+		}
+		var e DhcpPdPrefix
+		copy(e.Prefix[:], p.Prefix)
+		e.PrefixLen = p.PrefixLen
+		e.PreferredLife = p.PreferredLife
+		e.ValidLife = p.ValidLife
+		e.T1 = o.pktIapd.T1
+		e.T2 = o.pktIapd.T2
+		e.ticksBound = o.timerw.Ticks
+		if o.findPrefix(&e) < 0 {
+			o.stats.pdAdd++
+		}
+		prefixes = append(prefixes, e)
+	}
+	for i := range o.prefixes {
+		found := false
+		for j := range prefixes {
+			if prefixes[j].Prefix == o.prefixes[i].Prefix && prefixes[j].PrefixLen == o.prefixes[i].PrefixLen {
+				found = true
+				break
+			}
+		}
+		if !found {
+			o.stats.pdRemove++
+		}
+	}
+	o.prefixes = prefixes
+	o.stats.pdActive = uint64(len(o.prefixes))
+	o.carve()
+}
+
+// findPrefix returns the index of the prefix in the delegated prefixes, -1 in case it does not exist.
+func (o *PluginDhcpClient) findPrefix(e *DhcpPdPrefix) int {
+	for i := range o.prefixes {
+		if o.prefixes[i].Prefix == e.Prefix && o.prefixes[i].PrefixLen == e.PrefixLen {
+			return i
+		}
+	}
+	return -1
+}
+
+// expirePrefixes removes the delegated prefixes that their valid lifetime has passed without a renew.
+func (o *PluginDhcpClient) expirePrefixes() {
+	if len(o.prefixes) == 0 {
+		return
+	}
+	prefixes := o.prefixes[:0]
+	for _, e := range o.prefixes {
+		if o.prefixElapsed(&e) >= e.ValidLife {
+			o.stats.pdExpired++
+			continue
+		}
+		prefixes = append(prefixes, e)
+	}
+	if len(prefixes) != len(o.prefixes) {
+		o.prefixes = prefixes
+		o.stats.pdActive = uint64(len(o.prefixes))
+		o.carve()
+	}
+}
+
+// prefixElapsed returns the seconds since the prefix was bound or renewed.
+func (o *PluginDhcpClient) prefixElapsed(e *DhcpPdPrefix) uint32 {
+	return uint32((o.timerw.Ticks - e.ticksBound) * uint64(o.timerw.MinTickMsec()) / 1000)
+}
+
+// carve gives each sibling client a subnet of the first delegated prefix, the address is the subnet
+// with the EUI-64 of the sibling MAC. The siblings lose the address when there is no delegated prefix.
+func (o *PluginDhcpClient) carve() {
+	if o.init.Pd == nil || len(o.init.Pd.Carve) == 0 {
+		return
+	}
+	carveLen := o.init.Pd.CarveLen
+	for i := range o.init.Pd.Carve {
+		sibling := o.Ns.CLookupByMac(&o.init.Pd.Carve[i])
+		if sibling == nil {
+			o.stats.pdCarveErr++
+			continue
+		}
+		var addr core.Ipv6Key
+		if len(o.prefixes) > 0 {
+			e := &o.prefixes[0]
+			if e.PrefixLen > carveLen || (carveLen-e.PrefixLen < 64 && uint64(i) >= uint64(1)<<(carveLen-e.PrefixLen)) {
+				o.stats.pdCarveErr++
+				continue
+			}
+			hi := binary.BigEndian.Uint64(e.Prefix[0:8])
+			if e.PrefixLen < 64 {
+				hi &^= (uint64(1) << (64 - e.PrefixLen)) - 1
+			}
+			if carveLen > e.PrefixLen {
+				hi |= uint64(i) << (64 - carveLen)
+			}
+			binary.BigEndian.PutUint64(addr[0:8], hi)
+			var l6 core.Ipv6Key
+			sibling.GetIpv6LocalLink(&l6)
+			copy(addr[8:16], l6[8:16])
+		}
+		if sibling.Dhcpv6 != addr {
+			if !addr.IsZero() {
+				o.stats.pdCarve++
+			}
+			sibling.UpdateDIPv6(addr)
+		}
+	}
+}
+
+// GetPrefixes returns the delegated prefixes.
+func (o *PluginDhcpClient) GetPrefixes() []DhcpPdPrefix {
+	res := make([]DhcpPdPrefix, 0, len(o.prefixes))
+	for _, e := range o.prefixes {
+		elapsed := o.prefixElapsed(&e)
+		if elapsed < e.ValidLife {
+			e.Expire = e.ValidLife - elapsed
+		}
+		res = append(res, e)
+	}
+	return res
+}
+
 // EncodeOption remakes the encode() function of layers.DHCPv6Option.
 func EncodeOption(o layers.DHCPv6Option) []byte {
 	b := make([]byte, 4)
@@ -882,6 +1169,7 @@ func (o *PluginDhcpClient) HandleRxDhcpPacket(ps *core.ParserPacketState) int {
 	var cid []byte
 	var sid []byte
 	var validIana bool
+	var validIapd bool
 	var status uint16
 
 	for _, op := range dhcph.Options {
@@ -895,6 +1183,14 @@ func (o *PluginDhcpClient) HandleRxDhcpPacket(ps *core.ParserPacketState) int {
 			if o.pktIana.Decode(op.Data) == nil {
 				validIana = true
 			}
+		case layers.DHCPv6OptIAPD:
+			if o.init.Pd != nil && o.pktIapd.Decode(op.Data) == nil {
+				validIapd = true
+				if o.pktIapd.Status == STATUS_NoPrefixAvail {
+					o.stats.pktRxSTATUS_NoPrefixAvail++
+					validIapd = len(o.pktIapd.Prefixes) > 0
+				}
+			}
 		case layers.DHCPv6OptStatusCode:
 			if len(op.Data) == 2 {
 				status = binary.BigEndian.Uint16(op.Data[0:2])
@@ -926,7 +1222,7 @@ func (o *PluginDhcpClient) HandleRxDhcpPacket(ps *core.ParserPacketState) int {
 		}
 	}
 
-	if o.verifyPkt(&dhcph, ipv6, cid, sid, validIana) != 0 {
+	if o.verifyPkt(&dhcph, ipv6, cid, sid, validIana, validIapd) != 0 {
 		return -1
 	}
 
@@ -953,16 +1249,16 @@ func (o *PluginDhcpClient) HandleRxDhcpPacket(ps *core.ParserPacketState) int {
 		}
 
 	case DHCP_STATE_REQUESTING:
-		return o.HandleAckNak(dhcpmt, &dhcph, ipv6, true, status)
+		return o.HandleAckNak(dhcpmt, &dhcph, ipv6, true, status, validIapd)
 
 	case DHCP_STATE_BOUND:
 		o.stats.pktRxUnhandled++
 
 	case DHCP_STATE_RENEWING:
-		return o.HandleAckNak(dhcpmt, &dhcph, ipv6, true, status)
+		return o.HandleAckNak(dhcpmt, &dhcph, ipv6, true, status, validIapd)
 
 	case DHCP_STATE_REBINDING:
-		return o.HandleAckNak(dhcpmt, &dhcph, ipv6, true, status)
+		return o.HandleAckNak(dhcpmt, &dhcph, ipv6, true, status, validIapd)
 
 	default:
 		o.stats.pktRxUnhandled++
@@ -1041,7 +1337,8 @@ func (o PluginDhcpNsReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) (*core.
 /*******************************************/
 /*  RPC commands */
 type (
-	ApiDhcpClientCntHandler struct{}
+	ApiDhcpClientCntHandler      struct{}
+	ApiDhcpClientPrefixesHandler struct{}
 )
 
 func getNs(ctx interface{}, params *fastjson.RawMessage) (*PluginDhcpNs, *jsonrpc.Error) {
@@ -1088,6 +1385,18 @@ func (h ApiDhcpClientCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.
 	return c.cdbv.GeneralCounters(err, tctx, params, &p)
 }
 
+func (h ApiDhcpClientPrefixesHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	c, err := getClientPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return c.GetPrefixes(), nil
+}
+
 func init() {
 
 	/* register of plugins callbacks for ns,c level  */
@@ -1111,10 +1420,12 @@ func init() {
 	  aa - misc
 	*/
 
-	core.RegisterCB("dhcpv6_client_cnt", ApiDhcpClientCntHandler{}, false) // get counters/meta
+	core.RegisterCB("dhcpv6_client_cnt", ApiDhcpClientCntHandler{}, false)           // get counters/meta
+	core.RegisterCB("dhcpv6_client_prefixes", ApiDhcpClientPrefixesHandler{}, false) // get the delegated prefixes
 
 	/* register callback for rx side*/
-	core.ParserRegister("dhcpv6", HandleRxDhcpv6Packet)
+	core.ParserRegister("dhcpv6", HandleRxDhcpv6Packet,
+		core.ParserMatch{L3: uint16(layers.EthernetTypeIPv6), IPProto: uint8(layers.IPProtocolUDP), SrcPort: 547, DstPort: 546})
 }
 
 func Register(ctx *core.CThreadCtx) {
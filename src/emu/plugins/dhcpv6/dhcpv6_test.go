@@ -195,6 +195,15 @@ func (o *VethIgmpSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
 			pkt := GenerateOfferPacket(xid, src, dst, int(layers.DHCPv6MsgTypeAdverstise))
 			mr = genMbuf(o.tctx, pkt)
 		}
+	case 3:
+		// prefix delegation
+		if dhcpmt == layers.DHCPv6MsgTypeSolicit {
+			pkt := generatePacket(xid, src, dst, int(layers.DHCPv6MsgTypeAdverstise), true)
+			mr = genMbuf(o.tctx, pkt)
+		} else if dhcpmt != layers.DHCPv6MsgTypeRelease {
+			pkt := generatePacket(xid, src, dst, int(layers.DHCPv6MsgTypeReply), true)
+			mr = genMbuf(o.tctx, pkt)
+		}
 	}
 
 	m.FreeMbuf()
@@ -289,12 +298,63 @@ func TestPluginDhcpv6_5(t *testing.T) {
 	a.Run()
 }
 
+type DhcpRpcCtx struct {
+	tctx  *core.CThreadCtx
+	timer core.CHTimerObj
+}
+
+func (o *DhcpRpcCtx) OnEvent(a, b interface{}) {
+	o.tctx.Veth.AppendSimuationRPC([]byte(`{"jsonrpc": "2.0",
+	"method":"dhcpv6_client_prefixes",
+	"params": {"tun": {"vport":1,"tci":[1,2]}, "mac": [0,0,1,0,0,1]},
+	"id": 3 }`))
+	o.tctx.Veth.AppendSimuationRPC([]byte(`{"jsonrpc": "2.0",
+	"method":"ctx_client_get_info",
+	"params": {"tun": {"vport":1,"tci":[1,2]}, "macs": [[0,0,1,0,0,2]]},
+	"id": 4 }`))
+}
+
+func addSiblingAndRpc(tctx *core.CThreadCtx, test *DhcpTestBase) int {
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: [2]uint32{0x81000001, 0x81000002}})
+	ns := tctx.GetNs(&key)
+	ns.AddClient(core.NewClient(ns, core.MACKey{0, 0, 1, 0, 0, 2}, core.Ipv4Key{}, core.Ipv6Key{}, core.Ipv4Key{}))
+
+	timerw := tctx.GetTimerCtx()
+	var rpcctx DhcpRpcCtx
+	rpcctx.timer.SetCB(&rpcctx, test.cbArg1, test.cbArg2)
+	rpcctx.tctx = tctx
+	timerw.StartTicks(&rpcctx.timer, timerw.DurationToTicks(30*time.Second))
+	return 0
+}
+
+/*TestPluginDhcpv6_6 - IA_PD with a prefix length hint, the sibling client gets an address from the delegated prefix */
+func TestPluginDhcpv6_6(t *testing.T) {
+	a := &DhcpTestBase{
+		t:            t,
+		testname:     "dhcpv6_6",
+		dropAll:      false,
+		monitor:      false,
+		match:        3,
+		capture:      true,
+		duration:     120 * time.Second,
+		clientsToSim: 1,
+		cb:           addSiblingAndRpc,
+		options:      []byte(`{"pd": {"prefix_len": 56, "carve": [[0, 0, 1, 0, 0, 2]]}}`),
+	}
+	a.Run()
+}
+
 func getL2() []byte {
 	l2 := []byte{0, 0, 1, 0, 0, 1, 0, 0, 1, 0, 0, 2, 0x81, 00, 0x00, 0x01, 0x81, 00, 0x00, 0x02, 0x86, 0xdd}
 	return l2
 }
 
 func GenerateOfferPacket(xid uint32, src net.IP, dst net.IP, dt int) []byte {
+	return generatePacket(xid, src, dst, dt, false)
+}
+
+func generatePacket(xid uint32, src net.IP, dst net.IP, dt int, pd bool) []byte {
 
 	dhcp := &layers.DHCPv6{MsgType: layers.DHCPv6MsgType(dt),
 		TransactionID: []byte{(byte((xid & 0xff0000) >> 16)), byte((xid & 0xff00) >> 8), byte(xid & 0xff)}}
@@ -311,6 +371,13 @@ func GenerateOfferPacket(xid uint32, src net.IP, dst net.IP, dt int) []byte {
 	//binary.BigEndian.PutUint32(ianao[0:4], o.iaid)
 
 	dhcp.Options = append(dhcp.Options, layers.NewDHCPv6Option(layers.DHCPv6OptIANA, ianao))
+	if pd {
+		// 2001:db8:100::/56, T1 20 T2 40, preferred 60 valid 90
+		iapdo := []byte{0x12, 0x34, 0x56, 0x78, 0x00, 0x00, 0x00, 0x14, 0x00, 0x00, 0x00, 0x28,
+			0x00, 0x1a, 0x00, 0x19, 0x00, 0x00, 0x00, 0x3c, 0x00, 0x00, 0x00, 0x5a, 56,
+			0x20, 0x01, 0x0d, 0xb8, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+		dhcp.Options = append(dhcp.Options, layers.NewDHCPv6Option(layers.DHCPv6OptIAPD, iapdo))
+	}
 	dhcp.Options = append(dhcp.Options, layers.NewDHCPv6Option(layers.DHCPv6OptElapsedTime, []byte{0x00, 0x00}))
 	dhcp.Options = append(dhcp.Options, layers.NewDHCPv6Option(layers.DHCPv6OptServerID, []byte{0x00, 0x01, 0x00, 0x01, 0x21, 0x54, 0xee, 0xe7, 0x00, 0x0c, 0x29, 0x70, 0x3d, 0xd8}))
 
@@ -0,0 +1,1223 @@
+/*
+Copyright (c) 2021 Cisco Systems and/or its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+that can be found in the LICENSE file in the root of the source
+tree.
+*/
+
+package dhcpv6srv
+
+import (
+	"emu/core"
+	"encoding/binary"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"external/osamingo/jsonrpc"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/intel-go/fastjson"
+)
+
+/*
+DHCPv6 - Dynamic Host Configuration Protocol for IPv6
+
+Implementation based on RFC 8415, Server - https://datatracker.ietf.org/doc/html/rfc8415
+
+The server allocates addresses (IA_NA) and prefixes (IA_PD) from the pools of its init JSON and answers
+Solicit/Request/Renew/Rebind/Release/Decline. A Solicit with Rapid Commit is answered with a Reply in case
+the server allows it.
+
+Limitations
+ - The client DUID is the client identifier, only one IA_NA and one IA_PD per client.
+ - The hints of the client (requested address/prefix) are ignored.
+ - No relay messages (Relay-forward/Relay-reply) and no Reconfigure.
+ - No caching for clients whose lease finished.
+*/
+
+const (
+	DHCPV6_SRV_PLUG            = "dhcpv6srv"
+	DHCPV6_CLIENT_PORT         = 546 // DHCPv6 Client Port
+	DHCPV6_SERVER_PORT         = 547 // DHCPv6 Server Port
+	IPV6_HEADER_SIZE           = 40
+	ADVERTISE_TIMEOUT          = 10  // Timeout to wait for a Request after an Advertise
+	DefaultPreferredLife       = 300 // Default preferred lifetime, 5 minutes
+	DefaultValidLife           = 600 // Default valid lifetime, 10 minutes
+	STATUS_Success             = 0
+	STATUS_NoAddrsAvail        = 2
+	STATUS_NoBinding           = 3
+	STATUS_NoPrefixAvail       = 6
+	DefaultDelegatedLen        = 56
+	MaxPoolIndexes             = 1 << 32 // Maximal number of addresses/prefixes in a pool
+	iaPrefixOptionLen          = 25      // preferred(4) + valid(4) + prefix length(1) + prefix(16)
+	iaAddrOptionLen            = 24      // address(16) + preferred(4) + valid(4)
+	iaHeaderLen                = 12      // IAID(4) + T1(4) + T2(4)
+	optionHeaderLen            = 4       // code(2) + length(2)
+	bindingAdvertised    uint8 = 0
+	bindingBound         uint8 = 1
+)
+
+/*======================================================================================================
+											Stats
+======================================================================================================*/
+
+// Dhcpv6SrvStats is a struct that consolidates all the counters of a Dhcpv6Srv.
+type Dhcpv6SrvStats struct {
+	invalidInitJson    uint64 // Error while decoding client init Json
+	activeBindings     uint64 // Clients that were advertised or bound
+	pktRx              uint64 // Num packets received
+	pktRxParserErr     uint64 // Num packets that could not be decoded
+	pktRxBadMsgType    uint64 // Num packets received with an unsupported message type
+	pktRxNoClientId    uint64 // Num packets received without a client identifier
+	pktRxWrongServerId uint64 // Num packets received with a missing or a different server identifier
+	pktRxSolicit       uint64 // Num of Solicit packets received
+	pktRxRequest       uint64 // Num of Request packets received
+	pktRxRenew         uint64 // Num of Renew packets received
+	pktRxRebind        uint64 // Num of Rebind packets received
+	pktRxRelease       uint64 // Num of Release packets received
+	pktRxDecline       uint64 // Num of Decline packets received
+	pktTx              uint64 // Num packets transmitted
+	pktTxAdvertise     uint64 // Num of Advertise packets sent
+	pktTxReply         uint64 // Num of Reply packets sent
+	rapidCommit        uint64 // Num of Solicit answered with a Reply
+	noAddrAvailable    uint64 // No address available for an IA_NA
+	noPrefixAvailable  uint64 // No prefix available for an IA_PD
+	noBinding          uint64 // Renew/Rebind without a binding
+	declinedAddr       uint64 // Addresses that are not used anymore as a result of Decline
+	bindingExpired     uint64 // Bindings that their valid lifetime passed
+	advertiseExpired   uint64 // Advertised bindings without a Request
+	allocatedAddrs     uint64 // Active addresses
+	allocatedPrefixes  uint64 // Active prefixes
+}
+
+// NewDhcpv6SrvStatsDb creates a new database of Dhcpv6Srv counters.
+func NewDhcpv6SrvStatsDb(o *Dhcpv6SrvStats) *core.CCounterDb {
+	db := core.NewCCounterDb(DHCPV6_SRV_PLUG)
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.invalidInitJson,
+		Name:     "invalidInitJson",
+		Help:     "Error while decoding init Json",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.activeBindings,
+		Name:     "activeBindings",
+		Help:     "Num clients that are Advertised/Bound.",
+		Unit:     "clients",
+		DumpZero: true,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRx,
+		Name:     "pktRx",
+		Help:     "Rx packets",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxParserErr,
+		Name:     "pktRxParserErr",
+		Help:     "Rx DHCPv6 packet decode error",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxBadMsgType,
+		Name:     "pktRxBadMsgType",
+		Help:     "Rx unsupported DHCPv6 message type",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxNoClientId,
+		Name:     "pktRxNoClientId",
+		Help:     "Rx without client identifier",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxWrongServerId,
+		Name:     "pktRxWrongServerId",
+		Help:     "Rx with missing or another server identifier",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxSolicit,
+		Name:     "pktRxSolicit",
+		Help:     "Rx Solicit",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxRequest,
+		Name:     "pktRxRequest",
+		Help:     "Rx Request",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxRenew,
+		Name:     "pktRxRenew",
+		Help:     "Rx Renew",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxRebind,
+		Name:     "pktRxRebind",
+		Help:     "Rx Rebind",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxRelease,
+		Name:     "pktRxRelease",
+		Help:     "Rx Release",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxDecline,
+		Name:     "pktRxDecline",
+		Help:     "Rx Decline",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTx,
+		Name:     "pktTx",
+		Help:     "Tx packets",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxAdvertise,
+		Name:     "pktTxAdvertise",
+		Help:     "Tx Advertise",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxReply,
+		Name:     "pktTxReply",
+		Help:     "Tx Reply",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.rapidCommit,
+		Name:     "rapidCommit",
+		Help:     "Solicit with rapid commit answered with Reply",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.noAddrAvailable,
+		Name:     "noAddrAvailable",
+		Help:     "No address available for IA_NA",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.noPrefixAvailable,
+		Name:     "noPrefixAvailable",
+		Help:     "No prefix available for IA_PD",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.noBinding,
+		Name:     "noBinding",
+		Help:     "Renew/Rebind without a binding",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.declinedAddr,
+		Name:     "declinedAddr",
+		Help:     "Addresses declined by clients",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.bindingExpired,
+		Name:     "bindingExpired",
+		Help:     "Bindings that their valid lifetime passed",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.advertiseExpired,
+		Name:     "advertiseExpired",
+		Help:     "Advertised bindings without a Request",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.allocatedAddrs,
+		Name:     "allocatedAddrs",
+		Help:     "Allocated addresses",
+		Unit:     "addrs",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.allocatedPrefixes,
+		Name:     "allocatedPrefixes",
+		Help:     "Allocated prefixes",
+		Unit:     "prefixes",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	return db
+}
+
+/*======================================================================================================
+										Pools
+======================================================================================================*/
+
+// indexPool allocates indexes in [0, size), the released indexes are reused first.
+type indexPool struct {
+	size uint64   // Num of indexes in the pool
+	next uint64   // Next index that was never allocated
+	free []uint64 // Released indexes
+}
+
+// get returns a free index, false in case the pool is empty.
+func (o *indexPool) get() (uint64, bool) {
+	if n := len(o.free); n > 0 {
+		i := o.free[n-1]
+		o.free = o.free[:n-1]
+		return i, true
+	}
+	if o.next < o.size {
+		o.next++
+		return o.next - 1, true
+	}
+	return 0, false
+}
+
+// put returns an index to the pool.
+func (o *indexPool) put(i uint64) {
+	o.free = append(o.free, i)
+}
+
+// Ipv6AddrPool is a pool of IA_NA addresses in the range [min, max] of one /64.
+type Ipv6AddrPool struct {
+	indexPool
+	min core.Ipv6Key
+}
+
+// CreateIpv6AddrPool creates a pool of addresses, min and max should be in the same /64.
+func CreateIpv6AddrPool(min, max core.Ipv6Key) (*Ipv6AddrPool, error) {
+	if string(min[0:8]) != string(max[0:8]) {
+		return nil, fmt.Errorf("Pool min %v and max %v are not in the same /64", min.ToIP(), max.ToIP())
+	}
+	lo := binary.BigEndian.Uint64(min[8:16])
+	hi := binary.BigEndian.Uint64(max[8:16])
+	if hi < lo {
+		return nil, fmt.Errorf("Pool max %v is lower than min %v", max.ToIP(), min.ToIP())
+	}
+	o := new(Ipv6AddrPool)
+	o.min = min
+	o.size = hi - lo + 1
+	if o.size == 0 || o.size > MaxPoolIndexes {
+		o.size = MaxPoolIndexes
+	}
+	return o, nil
+}
+
+// Addr returns the address of an index.
+func (o *Ipv6AddrPool) Addr(i uint64) (addr core.Ipv6Key) {
+	addr = o.min
+	binary.BigEndian.PutUint64(addr[8:16], binary.BigEndian.Uint64(o.min[8:16])+i)
+	return addr
+}
+
+// Ipv6PrefixPool is a pool of IA_PD prefixes of delegatedLen, carved from prefix/prefixLen.
+type Ipv6PrefixPool struct {
+	indexPool
+	prefix       core.Ipv6Key
+	prefixLen    uint8
+	delegatedLen uint8
+}
+
+// CreateIpv6PrefixPool creates a pool of delegated prefixes, the delegated length is up to 64.
+func CreateIpv6PrefixPool(prefix core.Ipv6Key, prefixLen, delegatedLen uint8) (*Ipv6PrefixPool, error) {
+	if delegatedLen > 64 || delegatedLen < prefixLen {
+		return nil, fmt.Errorf("Invalid delegated length %d for pool %v/%d", delegatedLen, prefix.ToIP(), prefixLen)
+	}
+	o := new(Ipv6PrefixPool)
+	o.prefixLen = prefixLen
+	o.delegatedLen = delegatedLen
+	// clear the host bits
+	_, ipNet, _ := net.ParseCIDR(fmt.Sprintf("%v/%d", prefix.ToIP(), prefixLen))
+	copy(o.prefix[:], ipNet.IP.To16())
+	if bits := delegatedLen - prefixLen; bits >= 32 {
+		o.size = MaxPoolIndexes
+	} else {
+		o.size = uint64(1) << bits
+	}
+	return o, nil
+}
+
+// Prefix returns the delegated prefix of an index.
+func (o *Ipv6PrefixPool) Prefix(i uint64) (prefix core.Ipv6Key) {
+	prefix = o.prefix
+	hi := binary.BigEndian.Uint64(o.prefix[0:8])
+	if o.delegatedLen > 0 {
+		hi |= i << (64 - o.delegatedLen)
+	}
+	binary.BigEndian.PutUint64(prefix[0:8], hi)
+	return prefix
+}
+
+/*======================================================================================================
+										Bindings
+======================================================================================================*/
+
+// Dhcpv6Binding holds the addresses that were allocated to one client (DUID).
+type Dhcpv6Binding struct {
+	srv      *PluginDhcpv6SrvClient // Back pointer to server
+	timerw   *core.TimerCtx         // Timer wheel
+	timer    core.CHTimerObj        // Advertise timeout or valid lifetime
+	duid     string                 // Client Identifier
+	state    uint8                  // Advertised or Bound
+	hasAddr  bool                   // An address was allocated
+	naIaid   uint32                 // IAID of the IA_NA
+	addrPool *Ipv6AddrPool          // Pool of the address
+	addrIdx  uint64                 // Index of the address in the pool
+	hasPd    bool                   // A prefix was allocated
+	pdIaid   uint32                 // IAID of the IA_PD
+	pdPool   *Ipv6PrefixPool        // Pool of the prefix
+	pdIdx    uint64                 // Index of the prefix in the pool
+}
+
+// newDhcpv6Binding creates a binding when the server advertises to a new client.
+func newDhcpv6Binding(srv *PluginDhcpv6SrvClient, duid string) *Dhcpv6Binding {
+	o := new(Dhcpv6Binding)
+	o.srv = srv
+	o.timerw = srv.Tctx.GetTimerCtx()
+	o.duid = duid
+	o.state = bindingAdvertised
+	o.timer.SetCB(o, nil, nil)
+	o.restartTimer(ADVERTISE_TIMEOUT)
+	return o
+}
+
+func (o *Dhcpv6Binding) restartTimer(sec uint32) {
+	if o.timer.IsRunning() {
+		o.timerw.Stop(&o.timer)
+	}
+	o.timerw.StartTicks(&o.timer, o.timerw.DurationToTicks(time.Duration(sec)*time.Second))
+}
+
+// Bind a client, or extend the lifetimes of a bound client.
+func (o *Dhcpv6Binding) Bind() {
+	o.state = bindingBound
+	o.restartTimer(o.srv.params.ValidLife)
+}
+
+// OnEvent is called when the advertise timeout or the valid lifetime finished.
+func (o *Dhcpv6Binding) OnEvent(a, b interface{}) {
+	if o.state == bindingAdvertised {
+		o.srv.stats.advertiseExpired++
+	} else {
+		o.srv.stats.bindingExpired++
+	}
+	o.srv.OnBindingRemove(o, true)
+}
+
+// OnRemove frees the resources of the binding, the addresses return to the pools in case release is true.
+func (o *Dhcpv6Binding) OnRemove(release bool) {
+	if o.timer.IsRunning() {
+		o.timerw.Stop(&o.timer)
+	}
+	if o.hasAddr {
+		if release {
+			o.addrPool.put(o.addrIdx)
+		}
+		o.hasAddr = false
+		o.srv.stats.allocatedAddrs--
+	}
+	if o.hasPd {
+		o.pdPool.put(o.pdIdx)
+		o.hasPd = false
+		o.srv.stats.allocatedPrefixes--
+	}
+}
+
+// Dhcpv6BindingDb maps the client DUID to its binding.
+type Dhcpv6BindingDb map[string]*Dhcpv6Binding
+
+/*======================================================================================================
+										Plugin Dhcpv6Srv Emu Client
+======================================================================================================*/
+
+// Dhcpv6OptionParam is an option that the server adds to Advertise and Reply.
+type Dhcpv6OptionParam struct {
+	Code uint16 `json:"code" validate:"required"` // Code of Option
+	Data []byte `json:"data"`                     // Data for the Option
+}
+
+// Dhcpv6SrvPoolParams is a pool of IA_NA addresses.
+type Dhcpv6SrvPoolParams struct {
+	Min string `json:"min" validate:"required"` // Min IPv6 address
+	Max string `json:"max" validate:"required"` // Max IPv6 address, in the same /64 as min
+}
+
+// Dhcpv6SrvPdPoolParams is a pool of IA_PD prefixes.
+type Dhcpv6SrvPdPoolParams struct {
+	Prefix       string `json:"prefix" validate:"required"`                 // Prefix of the pool
+	PrefixLen    uint8  `json:"prefix_len" validate:"required,gt=0,lte=64"` // Prefix length of the pool
+	DelegatedLen uint8  `json:"delegated_len" validate:"lte=64"`            // Prefix length of each delegated prefix. Default to DefaultDelegatedLen
+}
+
+// Dhcpv6SrvParams represents the init json Api for the Dhcpv6 Server Emu Client.
+type Dhcpv6SrvParams struct {
+	PreferredLife uint32                  `json:"preferred_life"`           // Preferred lifetime in seconds. Default to DefaultPreferredLife
+	ValidLife     uint32                  `json:"valid_life"`               // Valid lifetime in seconds. Default to DefaultValidLife
+	RapidCommit   bool                    `json:"rapid_commit"`             // Answer Solicit with Rapid Commit with a Reply
+	Pools         []Dhcpv6SrvPoolParams   `json:"pools" validate:"dive"`    // Pools of IA_NA addresses
+	PdPools       []Dhcpv6SrvPdPoolParams `json:"pd_pools" validate:"dive"` // Pools of IA_PD prefixes
+	Dns           []string                `json:"dns"`                      // DNS recursive name servers
+	DomainList    []string                `json:"domain_list"`              // Domain search list
+	Options       []Dhcpv6OptionParam     `json:"options" validate:"dive"`  // Options for Advertise and Reply
+}
+
+// dhcpv6SrvEvents holds a list of events on which the Dhcpv6Srv plugin is interested.
+var dhcpv6SrvEvents = []string{}
+
+// PluginDhcpv6SrvClient represents an Emu Client that acts as a Dhcpv6 Server.
+type PluginDhcpv6SrvClient struct {
+	core.PluginBase                       // Plugin Base embedded struct so we get all the base functionality
+	params          Dhcpv6SrvParams       // Init Json params
+	stats           Dhcpv6SrvStats        // Dhcpv6Srv counters
+	cdb             *core.CCounterDb      // Counters database
+	cdbv            *core.CCounterDbVec   // Counters database vector
+	nsPlug          *PluginDhcpv6SrvNs    // Namespace plugin
+	addrPools       []*Ipv6AddrPool       // Pools of IA_NA addresses
+	pdPools         []*Ipv6PrefixPool     // Pools of IA_PD prefixes
+	bindingDb       Dhcpv6BindingDb       // Bindings per client DUID
+	serverId        []byte                // Server DUID
+	options         []layers.DHCPv6Option // Options that are added to Advertise and Reply
+	t1              uint32                // T1 of the IAs
+	t2              uint32                // T2 of the IAs
+}
+
+// NewDhcpv6SrvClient creates a new Dhcpv6Srv Emu Client Plugin.
+func NewDhcpv6SrvClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
+	o := new(PluginDhcpv6SrvClient)
+	o.InitPluginBase(ctx, o)                  // Init base object
+	o.RegisterEvents(ctx, dhcpv6SrvEvents, o) // Register events
+	nsplg := o.Ns.PluginCtx.GetOrCreate(DHCPV6_SRV_PLUG)
+	o.nsPlug = nsplg.Ext.(*PluginDhcpv6SrvNs)
+	o.cdb = NewDhcpv6SrvStatsDb(&o.stats) // Register Stats immediately so we can fail safely.
+	o.cdbv = core.NewCCounterDbVec(DHCPV6_SRV_PLUG)
+	o.cdbv.Add(o.cdb)
+
+	// Set the default paramaters
+	o.params.PreferredLife = DefaultPreferredLife
+	o.params.ValidLife = DefaultValidLife
+
+	err := o.Tctx.UnmarshalValidate(initJson, &o.params) // Unmarshal and validate init json
+	if err != nil {
+		o.stats.invalidInitJson++
+		return nil, err
+	}
+
+	err = o.OnCreate()
+	if err != nil {
+		o.stats.invalidInitJson++
+		return nil, err
+	}
+	o.nsPlug.addServer(o)
+
+	return &o.PluginBase, nil
+}
+
+// parseIPv6 parses an IPv6 address of the init JSON.
+func parseIPv6(s string) (ipv6 core.Ipv6Key, err error) {
+	ip := net.ParseIP(s)
+	if ip == nil || ip.To4() != nil {
+		return ipv6, fmt.Errorf("Invalid IPv6 %s", s)
+	}
+	copy(ipv6[:], ip.To16())
+	return ipv6, nil
+}
+
+// encodeDomainList encodes a list of domains in DNS wire format (RFC 1035 3.1).
+func encodeDomainList(domains []string) ([]byte, error) {
+	var b []byte
+	for _, domain := range domains {
+		for _, label := range strings.Split(strings.TrimSuffix(domain, "."), ".") {
+			if len(label) == 0 || len(label) > 63 {
+				return nil, fmt.Errorf("Invalid domain %s", domain)
+			}
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+		b = append(b, 0)
+	}
+	return b, nil
+}
+
+// OnCreate is called upon the creation of a new Dhcpv6Srv Emu client.
+func (o *PluginDhcpv6SrvClient) OnCreate() error {
+	o.bindingDb = make(Dhcpv6BindingDb)
+
+	if o.params.PreferredLife == 0 || o.params.ValidLife < o.params.PreferredLife {
+		return fmt.Errorf("Invalid lifetimes, preferred %d valid %d", o.params.PreferredLife, o.params.ValidLife)
+	}
+	// RFC 8415 21.4, T1 0.5 and T2 0.8 times the shortest preferred lifetime
+	o.t1 = o.params.PreferredLife / 2
+	o.t2 = o.params.PreferredLife * 4 / 5
+
+	if len(o.params.Pools) == 0 && len(o.params.PdPools) == 0 {
+		return fmt.Errorf("At least one pool or pd pool is required")
+	}
+
+	for _, pool := range o.params.Pools {
+		min, err := parseIPv6(pool.Min)
+		if err != nil {
+			return err
+		}
+		max, err := parseIPv6(pool.Max)
+		if err != nil {
+			return err
+		}
+		p, err := CreateIpv6AddrPool(min, max)
+		if err != nil {
+			return err
+		}
+		o.addrPools = append(o.addrPools, p)
+	}
+
+	for _, pool := range o.params.PdPools {
+		prefix, err := parseIPv6(pool.Prefix)
+		if err != nil {
+			return err
+		}
+		delegatedLen := pool.DelegatedLen
+		if delegatedLen == 0 {
+			delegatedLen = DefaultDelegatedLen
+		}
+		p, err := CreateIpv6PrefixPool(prefix, pool.PrefixLen, delegatedLen)
+		if err != nil {
+			return err
+		}
+		o.pdPools = append(o.pdPools, p)
+	}
+
+	serverId := &layers.DHCPv6DUID{Type: layers.DHCPv6DUIDTypeLL, HardwareType: []byte{0, 1}, LinkLayerAddress: o.Client.Mac[:]}
+	o.serverId = serverId.Encode()
+
+	return o.computeOptions()
+}
+
+// computeOptions computes the configuration options of Advertise and Reply ahead of time.
+func (o *PluginDhcpv6SrvClient) computeOptions() error {
+	optMap := make(map[layers.DHCPv6Opt][]byte)
+
+	for _, op := range o.params.Options {
+		optMap[layers.DHCPv6Opt(op.Code)] = op.Data
+	}
+
+	if len(o.params.Dns) > 0 {
+		var dns []byte
+		for _, s := range o.params.Dns {
+			ipv6, err := parseIPv6(s)
+			if err != nil {
+				return err
+			}
+			dns = append(dns, ipv6[:]...)
+		}
+		optMap[layers.DHCPv6OptDNSServers] = dns
+	}
+
+	if len(o.params.DomainList) > 0 {
+		domains, err := encodeDomainList(o.params.DomainList)
+		if err != nil {
+			return err
+		}
+		optMap[layers.DHCPv6OptDomainList] = domains
+	}
+
+	// The server builds these options per message
+	for _, code := range []layers.DHCPv6Opt{layers.DHCPv6OptClientID, layers.DHCPv6OptServerID,
+		layers.DHCPv6OptIANA, layers.DHCPv6OptIAPD, layers.DHCPv6OptStatusCode, layers.DHCPv6OptRapidCommit} {
+		delete(optMap, code)
+	}
+
+	for k, v := range optMap {
+		o.options = append(o.options, layers.NewDHCPv6Option(k, v))
+	}
+
+	// Sort the slice for predictable outcome
+	sort.Slice(o.options, func(i, j int) bool {
+		return o.options[i].Code < o.options[j].Code
+	})
+	return nil
+}
+
+// OnRemove is called upon removing the Dhcpv6Srv Emu client.
+func (o *PluginDhcpv6SrvClient) OnRemove(ctx *core.PluginCtx) {
+	ctx.UnregisterEvents(&o.PluginBase, dhcpv6SrvEvents)
+	for _, b := range o.bindingDb {
+		b.OnRemove(true)
+	}
+	o.bindingDb = nil
+	o.stats.activeBindings = 0
+	o.nsPlug.removeServer(o)
+}
+
+// OnEvent for events the client plugin is registered.
+func (o *PluginDhcpv6SrvClient) OnEvent(msg string, a, b interface{}) {}
+
+// OnBindingRemove is called when a binding needs to be removed.
+func (o *PluginDhcpv6SrvClient) OnBindingRemove(b *Dhcpv6Binding, release bool) {
+	b.OnRemove(release)
+	delete(o.bindingDb, b.duid)
+	o.stats.activeBindings--
+}
+
+// allocAddr allocates an address to the binding from the first pool that is not empty.
+func (o *PluginDhcpv6SrvClient) allocAddr(b *Dhcpv6Binding) bool {
+	for _, pool := range o.addrPools {
+		if i, ok := pool.get(); ok {
+			b.hasAddr = true
+			b.addrPool = pool
+			b.addrIdx = i
+			o.stats.allocatedAddrs++
+			return true
+		}
+	}
+	o.stats.noAddrAvailable++
+	return false
+}
+
+// allocPrefix allocates a prefix to the binding from the first pool that is not empty.
+func (o *PluginDhcpv6SrvClient) allocPrefix(b *Dhcpv6Binding) bool {
+	for _, pool := range o.pdPools {
+		if i, ok := pool.get(); ok {
+			b.hasPd = true
+			b.pdPool = pool
+			b.pdIdx = i
+			o.stats.allocatedPrefixes++
+			return true
+		}
+	}
+	o.stats.noPrefixAvailable++
+	return false
+}
+
+// dhcpv6Request is the information of a received message that is needed for the response.
+type dhcpv6Request struct {
+	msgType     layers.DHCPv6MsgType
+	xid         []byte
+	clientId    []byte
+	serverId    []byte
+	hasNa       bool   // IA_NA was requested
+	naIaid      uint32 // IAID of the IA_NA
+	hasPd       bool   // IA_PD was requested
+	pdIaid      uint32 // IAID of the IA_PD
+	rapidCommit bool
+	srcMac      core.MACKey
+	srcIp       core.Ipv6Key
+}
+
+// statusOption encodes a status code option.
+func statusOption(status uint16) []byte {
+	b := make([]byte, optionHeaderLen+2)
+	binary.BigEndian.PutUint16(b[0:2], uint16(layers.DHCPv6OptStatusCode))
+	binary.BigEndian.PutUint16(b[2:4], 2)
+	binary.BigEndian.PutUint16(b[4:6], status)
+	return b
+}
+
+// iaHeader encodes the IAID, T1 and T2 of an IA option.
+func iaHeader(iaid, t1, t2 uint32) []byte {
+	b := make([]byte, iaHeaderLen)
+	binary.BigEndian.PutUint32(b[0:4], iaid)
+	binary.BigEndian.PutUint32(b[4:8], t1)
+	binary.BigEndian.PutUint32(b[8:12], t2)
+	return b
+}
+
+// iaNaOption encodes the IA_NA of a binding, with a status in case there is no address.
+func (o *PluginDhcpv6SrvClient) iaNaOption(b *Dhcpv6Binding, iaid uint32, status uint16) layers.DHCPv6Option {
+	if b == nil || !b.hasAddr {
+		return layers.NewDHCPv6Option(layers.DHCPv6OptIANA, append(iaHeader(iaid, 0, 0), statusOption(status)...))
+	}
+	data := iaHeader(iaid, o.t1, o.t2)
+	addr := make([]byte, optionHeaderLen+iaAddrOptionLen)
+	binary.BigEndian.PutUint16(addr[0:2], uint16(layers.DHCPv6OptIAAddr))
+	binary.BigEndian.PutUint16(addr[2:4], iaAddrOptionLen)
+	ipv6 := b.addrPool.Addr(b.addrIdx)
+	copy(addr[4:20], ipv6[:])
+	binary.BigEndian.PutUint32(addr[20:24], o.params.PreferredLife)
+	binary.BigEndian.PutUint32(addr[24:28], o.params.ValidLife)
+	return layers.NewDHCPv6Option(layers.DHCPv6OptIANA, append(data, addr...))
+}
+
+// iaPdOption encodes the IA_PD of a binding, with a status in case there is no prefix.
+func (o *PluginDhcpv6SrvClient) iaPdOption(b *Dhcpv6Binding, iaid uint32, status uint16) layers.DHCPv6Option {
+	if b == nil || !b.hasPd {
+		return layers.NewDHCPv6Option(layers.DHCPv6OptIAPD, append(iaHeader(iaid, 0, 0), statusOption(status)...))
+	}
+	data := iaHeader(iaid, o.t1, o.t2)
+	prefix := make([]byte, optionHeaderLen+iaPrefixOptionLen)
+	binary.BigEndian.PutUint16(prefix[0:2], uint16(layers.DHCPv6OptIAPrefix))
+	binary.BigEndian.PutUint16(prefix[2:4], iaPrefixOptionLen)
+	binary.BigEndian.PutUint32(prefix[4:8], o.params.PreferredLife)
+	binary.BigEndian.PutUint32(prefix[8:12], o.params.ValidLife)
+	prefix[12] = b.pdPool.delegatedLen
+	ipv6 := b.pdPool.Prefix(b.pdIdx)
+	copy(prefix[13:29], ipv6[:])
+	return layers.NewDHCPv6Option(layers.DHCPv6OptIAPD, append(data, prefix...))
+}
+
+// SendMsg sends an Advertise/Reply to the client, the options are added after the client/server identifiers.
+func (o *PluginDhcpv6SrvClient) SendMsg(req *dhcpv6Request, msgType layers.DHCPv6MsgType, options []layers.DHCPv6Option) {
+
+	dhcp := &layers.DHCPv6{MsgType: msgType, TransactionID: req.xid}
+	dhcp.Options = append(dhcp.Options, layers.NewDHCPv6Option(layers.DHCPv6OptClientID, req.clientId))
+	dhcp.Options = append(dhcp.Options, layers.NewDHCPv6Option(layers.DHCPv6OptServerID, o.serverId))
+	dhcp.Options = append(dhcp.Options, options...)
+	dhcp.Options = append(dhcp.Options, o.options...)
+
+	var l6 core.Ipv6Key
+	o.Client.GetIpv6LocalLink(&l6)
+
+	ipv6pkt := core.PacketUtlBuild(
+		&layers.IPv6{
+			Version:    6,
+			Length:     8,
+			NextHeader: layers.IPProtocolUDP,
+			HopLimit:   255,
+			SrcIP:      l6.ToIP(),
+			DstIP:      req.srcIp.ToIP(),
+		},
+		&layers.UDP{SrcPort: DHCPV6_SERVER_PORT, DstPort: DHCPV6_CLIENT_PORT},
+		dhcp,
+	)
+
+	l2 := o.Client.GetL2Header(false, uint16(layers.EthernetTypeIPv6))
+	copy(l2[0:6], req.srcMac[:])
+	ipoffset := len(l2)
+	p := append(l2, ipv6pkt...)
+
+	// Fix IPv6 and UDP length and checksum
+	ipv6 := layers.IPv6Header(p[ipoffset : ipoffset+IPV6_HEADER_SIZE])
+	rcof := ipoffset + IPV6_HEADER_SIZE
+	binary.BigEndian.PutUint16(p[rcof+4:rcof+6], uint16(len(p)-rcof))
+	ipv6.SetPyloadLength(uint16(len(p) - rcof))
+	ipv6.FixUdpL4Checksum(p[rcof:], 0)
+
+	o.stats.pktTx++
+	if msgType == layers.DHCPv6MsgTypeAdverstise {
+		o.stats.pktTxAdvertise++
+	} else {
+		o.stats.pktTxReply++
+	}
+	o.Tctx.Veth.SendBuffer(false, o.Client, p, true)
+}
+
+// iaOptions returns the IA options of the binding for the IAs that the client requested.
+func (o *PluginDhcpv6SrvClient) iaOptions(req *dhcpv6Request, b *Dhcpv6Binding, naStatus, pdStatus uint16) []layers.DHCPv6Option {
+	var options []layers.DHCPv6Option
+	if req.hasNa {
+		options = append(options, o.iaNaOption(b, req.naIaid, naStatus))
+	}
+	if req.hasPd {
+		options = append(options, o.iaPdOption(b, req.pdIaid, pdStatus))
+	}
+	return options
+}
+
+// allocate allocates the requested IAs of a binding that were not allocated yet.
+func (o *PluginDhcpv6SrvClient) allocate(req *dhcpv6Request, b *Dhcpv6Binding) {
+	if req.hasNa {
+		b.naIaid = req.naIaid
+		if !b.hasAddr {
+			o.allocAddr(b)
+		}
+	}
+	if req.hasPd {
+		b.pdIaid = req.pdIaid
+		if !b.hasPd {
+			o.allocPrefix(b)
+		}
+	}
+}
+
+// getOrCreateBinding returns the binding of the client, a new binding is created in case there is none.
+func (o *PluginDhcpv6SrvClient) getOrCreateBinding(req *dhcpv6Request) *Dhcpv6Binding {
+	b, ok := o.bindingDb[string(req.clientId)]
+	if !ok {
+		b = newDhcpv6Binding(o, string(req.clientId))
+		o.bindingDb[b.duid] = b
+		o.stats.activeBindings++
+	}
+	return b
+}
+
+// HandleSolicit answers a Solicit with an Advertise, or with a Reply in case of rapid commit.
+func (o *PluginDhcpv6SrvClient) HandleSolicit(req *dhcpv6Request) {
+	o.stats.pktRxSolicit++
+	if req.serverId != nil {
+		// RFC 8415 16.2, discard Solicit with a server identifier
+		o.stats.pktRxWrongServerId++
+		return
+	}
+	b := o.getOrCreateBinding(req)
+	o.allocate(req, b)
+	if req.rapidCommit && o.params.RapidCommit {
+		o.stats.rapidCommit++
+		b.Bind()
+		options := o.iaOptions(req, b, STATUS_NoAddrsAvail, STATUS_NoPrefixAvail)
+		options = append(options, layers.NewDHCPv6Option(layers.DHCPv6OptRapidCommit, nil))
+		o.SendMsg(req, layers.DHCPv6MsgTypeReply, options)
+		return
+	}
+	o.SendMsg(req, layers.DHCPv6MsgTypeAdverstise, o.iaOptions(req, b, STATUS_NoAddrsAvail, STATUS_NoPrefixAvail))
+}
+
+// HandleRequest binds the client. The addresses are allocated in case the advertised binding expired.
+func (o *PluginDhcpv6SrvClient) HandleRequest(req *dhcpv6Request) {
+	o.stats.pktRxRequest++
+	b := o.getOrCreateBinding(req)
+	o.allocate(req, b)
+	b.Bind()
+	o.SendMsg(req, layers.DHCPv6MsgTypeReply, o.iaOptions(req, b, STATUS_NoAddrsAvail, STATUS_NoPrefixAvail))
+}
+
+// HandleRenewRebind extends the lifetimes of a bound client, NoBinding is returned in case the client is not known.
+func (o *PluginDhcpv6SrvClient) HandleRenewRebind(req *dhcpv6Request) {
+	if req.msgType == layers.DHCPv6MsgTypeRenew {
+		o.stats.pktRxRenew++
+	} else {
+		o.stats.pktRxRebind++
+	}
+	b, ok := o.bindingDb[string(req.clientId)]
+	if !ok || b.state != bindingBound ||
+		(req.hasNa && (!b.hasAddr || b.naIaid != req.naIaid)) ||
+		(req.hasPd && (!b.hasPd || b.pdIaid != req.pdIaid)) {
+		o.stats.noBinding++
+		o.SendMsg(req, layers.DHCPv6MsgTypeReply, o.iaOptions(req, nil, STATUS_NoBinding, STATUS_NoBinding))
+		return
+	}
+	b.Bind()
+	o.SendMsg(req, layers.DHCPv6MsgTypeReply, o.iaOptions(req, b, STATUS_NoBinding, STATUS_NoBinding))
+}
+
+// HandleReleaseDecline removes the binding of the client. The declined address does not return to the pool.
+func (o *PluginDhcpv6SrvClient) HandleReleaseDecline(req *dhcpv6Request) {
+	decline := req.msgType == layers.DHCPv6MsgTypeDecline
+	if decline {
+		o.stats.pktRxDecline++
+	} else {
+		o.stats.pktRxRelease++
+	}
+	if b, ok := o.bindingDb[string(req.clientId)]; ok {
+		if decline && b.hasAddr {
+			o.stats.declinedAddr++
+		}
+		o.OnBindingRemove(b, !decline)
+	}
+	o.SendMsg(req, layers.DHCPv6MsgTypeReply, []layers.DHCPv6Option{
+		layers.NewDHCPv6Option(layers.DHCPv6OptStatusCode, []byte{0, STATUS_Success})})
+}
+
+// HandleRxDhcpv6Packet handles an incoming Dhcpv6 Packet to the server.
+func (o *PluginDhcpv6SrvClient) HandleRxDhcpv6Packet(ps *core.ParserPacketState) int {
+
+	m := ps.M
+	p := m.GetData()
+	o.stats.pktRx++
+
+	dhcphlen := ps.L7Len
+	if dhcphlen < 4 {
+		o.stats.pktRxParserErr++
+		return core.PARSER_ERR
+	}
+
+	var dhcph layers.DHCPv6
+	err := dhcph.DecodeFromBytes(p[ps.L7:ps.L7+dhcphlen], gopacket.NilDecodeFeedback)
+	if err != nil {
+		o.stats.pktRxParserErr++
+		return core.PARSER_ERR
+	}
+
+	var req dhcpv6Request
+	req.msgType = dhcph.MsgType
+	req.xid = dhcph.TransactionID
+	copy(req.srcMac[:], p[6:12])
+	ipv6 := layers.IPv6Header(p[ps.L3 : ps.L3+IPV6_HEADER_SIZE])
+	copy(req.srcIp[:], ipv6.SrcIP())
+
+	for _, op := range dhcph.Options {
+		switch op.Code {
+		case layers.DHCPv6OptClientID:
+			req.clientId = op.Data
+		case layers.DHCPv6OptServerID:
+			req.serverId = op.Data
+		case layers.DHCPv6OptIANA:
+			if len(op.Data) >= iaHeaderLen {
+				req.hasNa = true
+				req.naIaid = binary.BigEndian.Uint32(op.Data[0:4])
+			}
+		case layers.DHCPv6OptIAPD:
+			if len(op.Data) >= iaHeaderLen {
+				req.hasPd = true
+				req.pdIaid = binary.BigEndian.Uint32(op.Data[0:4])
+			}
+		case layers.DHCPv6OptRapidCommit:
+			req.rapidCommit = true
+		}
+	}
+
+	if len(req.clientId) == 0 {
+		o.stats.pktRxNoClientId++
+		return core.PARSER_ERR
+	}
+
+	switch req.msgType {
+	case layers.DHCPv6MsgTypeRequest, layers.DHCPv6MsgTypeRenew,
+		layers.DHCPv6MsgTypeRelease, layers.DHCPv6MsgTypeDecline:
+		// RFC 8415 16, these messages must carry the identifier of this server
+		if string(req.serverId) != string(o.serverId) {
+			o.stats.pktRxWrongServerId++
+			return core.PARSER_OK
+		}
+	}
+
+	switch req.msgType {
+	case layers.DHCPv6MsgTypeSolicit:
+		o.HandleSolicit(&req)
+	case layers.DHCPv6MsgTypeRequest:
+		o.HandleRequest(&req)
+	case layers.DHCPv6MsgTypeRenew, layers.DHCPv6MsgTypeRebind:
+		o.HandleRenewRebind(&req)
+	case layers.DHCPv6MsgTypeRelease, layers.DHCPv6MsgTypeDecline:
+		o.HandleReleaseDecline(&req)
+	default:
+		o.stats.pktRxBadMsgType++
+	}
+
+	return core.PARSER_OK
+}
+
+/*======================================================================================================
+										Plugin Dhcpv6Srv Ns
+======================================================================================================*/
+// PluginDhcpv6SrvNs represents the namespace layer for Dhcpv6 Srv.
+type PluginDhcpv6SrvNs struct {
+	core.PluginBase
+	servers []*PluginDhcpv6SrvClient // Servers in the namespace, by creation order
+}
+
+// NewDhcpv6SrvNs creates a new Dhcpv6Srv namespace plugin
+func NewDhcpv6SrvNs(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
+	o := new(PluginDhcpv6SrvNs)
+	o.InitPluginBase(ctx, o)
+	o.RegisterEvents(ctx, []string{}, o)
+	return &o.PluginBase, nil
+}
+
+// OnRemove when removing Dhcpv6Srv namespace plugin.
+func (o *PluginDhcpv6SrvNs) OnRemove(ctx *core.PluginCtx) {}
+
+// OnEvent for events the namespace plugin is registered.
+func (o *PluginDhcpv6SrvNs) OnEvent(msg string, a, b interface{}) {}
+
+// SetTruncated to complete the gopacket.DecodeFeedback interface.
+func (o *PluginDhcpv6SrvNs) SetTruncated() {}
+
+func (o *PluginDhcpv6SrvNs) addServer(srv *PluginDhcpv6SrvClient) {
+	o.servers = append(o.servers, srv)
+}
+
+func (o *PluginDhcpv6SrvNs) removeServer(srv *PluginDhcpv6SrvClient) {
+	for i := range o.servers {
+		if o.servers[i] == srv {
+			o.servers = append(o.servers[:i], o.servers[i+1:]...)
+			return
+		}
+	}
+}
+
+// HandleRxDhcpv6Packet passes an incoming Dhcpv6 packet to a server.
+func (o *PluginDhcpv6SrvNs) HandleRxDhcpv6Packet(ps *core.ParserPacketState) int {
+
+	/*
+		Note: If the packet is multicast (All_DHCP_Relay_Agents_and_Servers), we pass it to the first server.
+		If the packet is unicast, pass it a specific Dhcpv6Srv.
+	*/
+
+	m := ps.M
+	p := m.GetData()
+	var mackey core.MACKey
+	copy(mackey[:], p[0:6])
+
+	if mackey[0]&1 == 1 {
+		if len(o.servers) == 0 {
+			return core.PARSER_ERR
+		}
+		return o.servers[0].HandleRxDhcpv6Packet(ps)
+	}
+
+	client := o.Ns.CLookupByMac(&mackey)
+	if client == nil {
+		return core.PARSER_ERR
+	}
+
+	cplg := client.PluginCtx.Get(DHCPV6_SRV_PLUG)
+	if cplg == nil {
+		return core.PARSER_ERR
+	}
+	srv := cplg.Ext.(*PluginDhcpv6SrvClient)
+	return srv.HandleRxDhcpv6Packet(ps)
+}
+
+/*======================================================================================================
+												Rx
+======================================================================================================*/
+// HandleRxDhcpv6Packet is called by the parser each time a packet for the Dhcpv6Srv is received.
+func HandleRxDhcpv6Packet(ps *core.ParserPacketState) int {
+
+	ns := ps.Tctx.GetNs(ps.Tun)
+
+	if ns == nil {
+		return core.PARSER_ERR
+	}
+	nsplg := ns.PluginCtx.Get(DHCPV6_SRV_PLUG)
+	if nsplg == nil {
+		return core.PARSER_ERR
+	}
+	dhcpSrvPlug := nsplg.Ext.(*PluginDhcpv6SrvNs)
+	return dhcpSrvPlug.HandleRxDhcpv6Packet(ps)
+}
+
+/*
+======================================================================================================
+
+	Generate Plugin
+
+======================================================================================================
+*/
+type PluginDhcpv6SrvCReg struct{}
+type PluginDhcpv6SrvNsReg struct{}
+
+// NewPlugin creates a new Dhcpv6Srv client plugin.
+func (o PluginDhcpv6SrvCReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
+	return NewDhcpv6SrvClient(ctx, initJson)
+}
+
+// NewPlugin creates a new Dhcpv6Srv namespace plugin.
+func (o PluginDhcpv6SrvNsReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
+	return NewDhcpv6SrvNs(ctx, initJson)
+}
+
+/*======================================================================================================
+											RPC Methods
+======================================================================================================*/
+
+type (
+	ApiDhcpv6SrvClientCntHandler struct{} // Counter RPC Handler per Client
+)
+
+// getClientPlugin gets the client plugin given the client parameters (Mac & Tunnel Key)
+func getClientPlugin(ctx interface{}, params *fastjson.RawMessage) (*PluginDhcpv6SrvClient, error) {
+	tctx := ctx.(*core.CThreadCtx)
+
+	plug, err := tctx.GetClientPlugin(params, DHCPV6_SRV_PLUG)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pClient := plug.Ext.(*PluginDhcpv6SrvClient)
+
+	return pClient, nil
+}
+
+// ApiDhcpv6SrvClientCntHandler gets the counters of the Dhcpv6Srv client.
+func (h ApiDhcpv6SrvClientCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	c, err := getClientPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return c.cdbv.GeneralCounters(err, tctx, params, &p)
+}
+
+func init() {
+
+	/* register of plugins callbacks for ns,c level  */
+	core.PluginRegister(DHCPV6_SRV_PLUG,
+		core.PluginRegisterData{Client: PluginDhcpv6SrvCReg{},
+			Ns:     PluginDhcpv6SrvNsReg{},
+			Thread: nil}) /* no need for thread context for now */
+
+	/* The format of the RPC commands xxx_yy_zz_aa
+
+	  xxx - the plugin name
+
+	  yy  - ns - namespace
+			c  - client
+			t   -thread
+
+	  zz  - cmd  command
+			set  set configuration
+			get  get configuration/counters
+
+	  aa - misc
+	*/
+
+	core.RegisterCB("dhcpv6srv_c_cnt", ApiDhcpv6SrvClientCntHandler{}, true) // get counters / meta per client
+
+	/* register parser */
+	// C -> S, parse by server.
+	core.ParserRegister(DHCPV6_SRV_PLUG, HandleRxDhcpv6Packet,
+		core.ParserMatch{L3: uint16(layers.EthernetTypeIPv6), IPProto: uint8(layers.IPProtocolUDP), SrcPort: DHCPV6_CLIENT_PORT, DstPort: DHCPV6_SERVER_PORT})
+}
+
+func Register(ctx *core.CThreadCtx) {
+	// In order for this plugin to be included in the EMU compilation one must provide this empty register
+	// function. In case you remove the function call, then the core will not include EMU.
+	ctx.RegisterParserCb(DHCPV6_SRV_PLUG)
+}
@@ -0,0 +1,247 @@
+/*
+Copyright (c) 2021 Cisco Systems and/or its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+that can be found in the LICENSE file in the root of the source
+tree.
+*/
+
+package dhcpv6srv
+
+import (
+	"emu/core"
+	"emu/plugins/dhcpv6"
+	"flag"
+	"os"
+	"testing"
+	"time"
+)
+
+var monitor int
+
+func TestIpv6AddrPool(t *testing.T) {
+	min := core.Ipv6Key{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x10}
+	max := core.Ipv6Key{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x12}
+
+	pool, err := CreateIpv6AddrPool(min, max)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// There should be 3 values 2001:db8::10-12
+	for i := 0; i < 3; i++ {
+		idx, ok := pool.get()
+		if !ok {
+			t.Fatalf("Pool is empty after %d addresses", i)
+		}
+		want := min
+		want[15] += uint8(i)
+		if addr := pool.Addr(idx); addr != want {
+			t.Fatalf("Invalid address, want %v and have %v", want.ToIP(), addr.ToIP())
+		}
+	}
+	if _, ok := pool.get(); ok {
+		t.Fatal("Pool should be empty, and is not!")
+	}
+
+	// Released index is reused
+	pool.put(1)
+	if idx, ok := pool.get(); !ok || idx != 1 {
+		t.Fatalf("Released index was not reused, have %v %v", idx, ok)
+	}
+
+	other := core.Ipv6Key{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0x10}
+	if _, err := CreateIpv6AddrPool(min, other); err == nil {
+		t.Fatal("Created a pool that is not in one /64")
+	}
+}
+
+func TestIpv6PrefixPool(t *testing.T) {
+	prefix := core.Ipv6Key{0x20, 0x01, 0x0d, 0xb8, 0xff, 0xff}
+
+	pool, err := CreateIpv6PrefixPool(prefix, 32, 34)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 4 prefixes of /34, host bits are cleared
+	want := []core.Ipv6Key{
+		{0x20, 0x01, 0x0d, 0xb8, 0x00},
+		{0x20, 0x01, 0x0d, 0xb8, 0x40},
+		{0x20, 0x01, 0x0d, 0xb8, 0x80},
+		{0x20, 0x01, 0x0d, 0xb8, 0xc0},
+	}
+	for i := range want {
+		idx, ok := pool.get()
+		if !ok {
+			t.Fatalf("Pool is empty after %d prefixes", i)
+		}
+		if prefix := pool.Prefix(idx); prefix != want[i] {
+			t.Fatalf("Invalid prefix, want %v and have %v", want[i].ToIP(), prefix.ToIP())
+		}
+	}
+	if _, ok := pool.get(); ok {
+		t.Fatal("Pool should be empty, and is not!")
+	}
+
+	if _, err := CreateIpv6PrefixPool(prefix, 32, 72); err == nil {
+		t.Fatal("Created a pool with a delegated length longer than 64")
+	}
+}
+
+// Dhcpv6SrvTestBase represents the base parameters for a Dhcpv6Srv test.
+type Dhcpv6SrvTestBase struct {
+	testname   string
+	monitor    bool
+	capture    bool
+	duration   time.Duration
+	srvJSON    []byte
+	clientJSON []byte
+	counters   Dhcpv6SrvStats
+	clientIpv6 core.Ipv6Key
+	prefixes   int
+}
+
+// VethDhcpv6SrvSim is a loopback veth, the server and the client are in the same namespace.
+type VethDhcpv6SrvSim struct{}
+
+// ProcessTxToRx loops back each packet.
+func (o *VethDhcpv6SrvSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
+	return m
+}
+
+var (
+	srvMac    = core.MACKey{0, 0, 1, 0, 0, 1}
+	clientMac = core.MACKey{0, 0, 1, 0, 0, 2}
+)
+
+// Run the test.
+func (o *Dhcpv6SrvTestBase) Run(t *testing.T) {
+	var simVeth VethDhcpv6SrvSim
+	var simrx core.VethIFSim = &simVeth
+
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	defer tctx.Delete()
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1})
+	ns := core.NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+
+	srv := core.NewClient(ns, srvMac, core.Ipv4Key{}, core.Ipv6Key{}, core.Ipv4Key{})
+	ns.AddClient(srv)
+	client := core.NewClient(ns, clientMac, core.Ipv4Key{}, core.Ipv6Key{}, core.Ipv4Key{})
+	ns.AddClient(client)
+
+	emptyJsonObj := []byte("{}")
+	if err := ns.PluginCtx.CreatePlugins([]string{DHCPV6_SRV_PLUG, "dhcpv6"}, [][]byte{emptyJsonObj, emptyJsonObj}); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.PluginCtx.CreatePlugins([]string{DHCPV6_SRV_PLUG}, [][]byte{o.srvJSON}); err != nil {
+		t.Fatal(err)
+	}
+	clientJSON := o.clientJSON
+	if clientJSON == nil {
+		clientJSON = emptyJsonObj
+	}
+	if err := client.PluginCtx.CreatePlugins([]string{"dhcpv6"}, [][]byte{clientJSON}); err != nil {
+		t.Fatal(err)
+	}
+	tctx.RegisterParserCb(DHCPV6_SRV_PLUG)
+	tctx.RegisterParserCb("dhcpv6")
+
+	m := false
+	if monitor > 0 {
+		m = true
+	}
+	tctx.Veth.SetDebug(m, os.Stdout, o.capture)
+	tctx.MainLoopSim(o.duration)
+
+	srvPlug := srv.PluginCtx.Get(DHCPV6_SRV_PLUG).Ext.(*PluginDhcpv6SrvClient)
+	srvPlug.cdbv.Dump()
+	if o.counters != srvPlug.stats {
+		t.Fatalf("Bad counters, want %+v, have %+v.\n", o.counters, srvPlug.stats)
+	}
+	if client.Dhcpv6 != o.clientIpv6 {
+		t.Fatalf("Bad client address, want %v, have %v.\n", o.clientIpv6.ToIP(), client.Dhcpv6.ToIP())
+	}
+	clientPlug := client.PluginCtx.Get("dhcpv6").Ext.(*dhcpv6.PluginDhcpClient)
+	if prefixes := clientPlug.GetPrefixes(); len(prefixes) != o.prefixes {
+		t.Fatalf("Bad prefixes, want %d, have %+v.\n", o.prefixes, prefixes)
+	}
+}
+
+// TestDhcpv6Srv1 binds an address and a prefix and renews them.
+func TestDhcpv6Srv1(t *testing.T) {
+	a := &Dhcpv6SrvTestBase{
+		testname: "dhcpv6srv1",
+		monitor:  false,
+		capture:  true,
+		duration: 1 * time.Minute,
+		srvJSON: []byte(`{"preferred_life": 40, "valid_life": 80,
+			"pools": [{"min": "2001:db8::100", "max": "2001:db8::1ff"}],
+			"pd_pools": [{"prefix": "2001:db8:1000::", "prefix_len": 40, "delegated_len": 56}],
+			"dns": ["2001:db8::53"], "domain_list": ["example.com"]}`),
+		clientJSON: []byte(`{"pd": {}}`),
+		counters: Dhcpv6SrvStats{
+			activeBindings:    1,
+			pktRx:             5,
+			pktRxSolicit:      1,
+			pktRxRequest:      1,
+			pktRxRenew:        3,
+			pktTx:             5,
+			pktTxAdvertise:    1,
+			pktTxReply:        4,
+			allocatedAddrs:    1,
+			allocatedPrefixes: 1,
+		},
+		clientIpv6: core.Ipv6Key{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01, 0x00},
+		prefixes:   1,
+	}
+	a.Run(t)
+}
+
+// TestDhcpv6Srv2 answers a Solicit with rapid commit. The client expects an Advertise and keeps soliciting.
+func TestDhcpv6Srv2(t *testing.T) {
+	a := &Dhcpv6SrvTestBase{
+		testname: "dhcpv6srv2",
+		monitor:  false,
+		capture:  true,
+		duration: 5 * time.Second,
+		srvJSON: []byte(`{"rapid_commit": true,
+			"pools": [{"min": "2001:db8::100", "max": "2001:db8::1ff"}]}`),
+		clientJSON: []byte(`{"options": {"sol": [[0, 14]]}}`),
+		counters: Dhcpv6SrvStats{
+			activeBindings: 1,
+			pktRx:          2,
+			pktRxSolicit:   2,
+			pktTx:          2,
+			pktTxReply:     2,
+			rapidCommit:    2,
+			allocatedAddrs: 1,
+		},
+	}
+	a.Run(t)
+}
+
+// TestDhcpv6Srv3 does not allocate an address since the pool is empty.
+func TestDhcpv6Srv3(t *testing.T) {
+	a := &Dhcpv6SrvTestBase{
+		testname: "dhcpv6srv3",
+		monitor:  false,
+		capture:  true,
+		duration: 5 * time.Second,
+		srvJSON:  []byte(`{"pd_pools": [{"prefix": "2001:db8:1000::", "prefix_len": 56, "delegated_len": 56}]}`),
+		counters: Dhcpv6SrvStats{
+			activeBindings:  1,
+			pktRx:           2,
+			pktRxSolicit:    2,
+			pktTx:           2,
+			pktTxAdvertise:  2,
+			noAddrAvailable: 2,
+		},
+	}
+	a.Run(t)
+}
+
+func init() {
+	flag.IntVar(&monitor, "monitor", 0, "monitor")
+}
@@ -10,12 +10,16 @@ RFC 8415  dot1x client with
 
 EAP-MD5
 EAP-MSCHAPv2
+EAP-TLS
+PEAPv0 with inner EAP-MSCHAPv2
+EAP-TTLSv0 with inner EAP-MSCHAPv2
 
 
 */
 
 import (
 	"bytes"
+	"crypto/tls"
 	"emu/core"
 	"encoding/binary"
 	"external/google/gopacket"
@@ -54,17 +58,26 @@ const (
 
 	EAP_MD5_MASK      = 1
 	EAP_MSCHAPv2_MASK = 2
+	EAP_TLS_MASK      = 4
+	EAP_PEAP_MASK     = 8
+	EAP_TTLS_MASK     = 16
 )
 
 var dot1xDefaultDestMAC = []byte{0x01, 0x80, 0xc2, 0x00, 0x00, 0x03}
 
 type Dot1xCfg struct {
-	User       *string `json:"user"`       // user name
-	Password   *string `json:"password"`   // password
-	Nthash     *string `json:"nthash"`     // hash string for MSCHAPv2
-	Flags      uint32  `json:"flags"`      // not used
-	TimeoutSec uint32  `json:"timeo_idle"` // timeout for success in sec
-	MaxStart   uint32  `json:"max_start"`  // max number of retries
+	User              *string `json:"user"`               // user name
+	Password          *string `json:"password"`           // password
+	Nthash            *string `json:"nthash"`             // hash string for MSCHAPv2
+	Flags             uint32  `json:"flags"`              // mask of the disabled methods, EAP_MD5_MASK...
+	TimeoutSec        uint32  `json:"timeo_idle"`         // timeout for success in sec
+	MaxStart          uint32  `json:"max_start"`          // max number of retries
+	AnonymousIdentity *string `json:"anonymous_identity"` // outer identity of PEAP/TTLS, the user is the inner one
+	CaCert            string  `json:"ca_cert"`            // PEM of the CA of the server, the server is not verified without it
+	ServerName        string  `json:"server_name"`        // name in the certificate of the server
+	Cert              string  `json:"cert"`               // PEM certificate of the client, EAP-TLS is enabled with it
+	Key               string  `json:"key"`                // PEM private key of the client
+	FragmentSize      uint32  `json:"fragment_size"`      // max TLS data in an EAP-TLS/PEAP/TTLS packet
 }
 
 type Dot1xStats struct {
@@ -82,6 +95,12 @@ type Dot1xStats struct {
 	pktMethodNoPassword    uint64
 	pktMethodWrongLen      uint64
 	pktMethodFailErr       uint64
+	pktTlsHandshakeOk      uint64
+	pktTlsHandshakeErr     uint64
+	pktTlsFragRx           uint64
+	pktTlsFragTx           uint64
+	pktTlsInvalidFrag      uint64
+	pktTlsInnerErr         uint64
 }
 
 func NewDot1xStatsDb(o *Dot1xStats) *core.CCounterDb {
@@ -199,6 +218,54 @@ func NewDot1xStatsDb(o *Dot1xStats) *core.CCounterDb {
 		DumpZero: false,
 		Info:     core.ScERROR})
 
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTlsHandshakeOk,
+		Name:     "pktTlsHandshakeOk",
+		Help:     "tls handshake of EAP-TLS/PEAP/TTLS ended",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTlsHandshakeErr,
+		Name:     "pktTlsHandshakeErr",
+		Help:     "tls handshake failed",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTlsFragRx,
+		Name:     "pktTlsFragRx",
+		Help:     "rx tls fragments",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTlsFragTx,
+		Name:     "pktTlsFragTx",
+		Help:     "tx tls fragments",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTlsInvalidFrag,
+		Name:     "pktTlsInvalidFrag",
+		Help:     "rx invalid tls fragment",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTlsInnerErr,
+		Name:     "pktTlsInnerErr",
+		Help:     "inner method of PEAP/TTLS failed",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
 	return db
 }
 
@@ -257,6 +324,7 @@ type PluginDot1xClient struct {
 	eapPktTemplate   []byte
 	l3Offset         uint16
 	nack             []byte
+	tlsCfg           *tls.Config // config of EAP-TLS/PEAP/TTLS
 }
 
 var dot1xEvents = []string{}
@@ -272,6 +340,10 @@ func NewDot1xClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, err
 	if err != nil {
 		return nil, err
 	}
+	o.tlsCfg, err = o.cfg.tlsClientConfig()
+	if err != nil {
+		return nil, err
+	}
 	o.OnCreate()
 
 	return &o.PluginBase, nil
@@ -280,6 +352,7 @@ func NewDot1xClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, err
 func (o *PluginDot1xClient) LoadCfg(initJson []byte) error {
 	o.cfg.TimeoutSec = TIMEOUT_TIMER_SEC
 	o.cfg.MaxStart = MAX_STARTS_CNT
+	o.cfg.FragmentSize = EAP_TLS_DEF_FRAGMENT_SIZE
 	return fastjson.Unmarshal(initJson, &o.cfg)
 }
 
@@ -302,10 +375,25 @@ func (o *PluginDot1xClient) OnCreate() {
 	if o.cfg.Flags&EAP_MSCHAPv2_MASK == 0 {
 		o.mapHandler[EAP_TYPE_MSCHAPV2] = NewEapMschapv2()
 	}
+	// EAP-TLS requires the certificate of the client
+	if o.cfg.Flags&EAP_TLS_MASK == 0 && len(o.tlsCfg.Certificates) > 0 {
+		o.mapHandler[EAP_TYPE_TLS] = NewEapTls(EAP_TYPE_TLS, o.tlsCfg)
+	}
+	if o.cfg.Flags&EAP_PEAP_MASK == 0 {
+		o.mapHandler[EAP_TYPE_PEAP] = NewEapTls(EAP_TYPE_PEAP, o.tlsCfg)
+	}
+	if o.cfg.Flags&EAP_TTLS_MASK == 0 {
+		o.mapHandler[EAP_TYPE_TTLS] = NewEapTls(EAP_TYPE_TTLS, o.tlsCfg)
+	}
 
-	if o.cfg.Flags&EAP_MD5_MASK == 0 {
-		o.nack = []byte{EAP_TYPE_MD5}
-	} else {
+	// the nack has the supported methods by preference
+	o.nack = make([]byte, 0)
+	for _, t := range []uint8{EAP_TYPE_MD5, EAP_TYPE_MSCHAPV2, EAP_TYPE_TLS, EAP_TYPE_PEAP, EAP_TYPE_TTLS} {
+		if _, ok := o.mapHandler[t]; ok {
+			o.nack = append(o.nack, t)
+		}
+	}
+	if len(o.nack) == 0 {
 		o.nack = []byte{EAP_TYPE_MSCHAPV2}
 	}
 
@@ -405,6 +493,9 @@ func (o *PluginDot1xClient) OnRemove(ctx *core.PluginCtx) {
 	if o.timer.IsRunning() {
 		o.timerw.Stop(&o.timer)
 	}
+	for _, h := range o.mapHandler {
+		h.OnRemove()
+	}
 }
 
 func (o *PluginDot1xClient) makeSurereTimerIsRunning() {
@@ -556,10 +647,14 @@ func (o *PluginDot1xClient) handleRequest(eap *layers.EAP) {
 	if eap.Type == layers.EAPTypeIdentity {
 		// accepted on all states
 		if o.cfg.User != nil {
+			identity := o.cfg.User
+			if o.cfg.AnonymousIdentity != nil {
+				identity = o.cfg.AnonymousIdentity
+			}
 			o.stats.pktTxIdentity++
 			o.SendResponsePacket(uint8(layers.EAPCodeResponse),
 				eap.Id, uint8(layers.EAPTypeIdentity),
-				[]byte(*o.cfg.User))
+				[]byte(*identity))
 			o.smState = EAP_WAIT_FOR_METHOD
 			o.makeSurereTimerIsRunning()
 		} else {
@@ -784,7 +879,9 @@ func init() {
 	// TBD getter for the client info
 
 	/* register callback for rx side*/
-	core.ParserRegister("dot1x", HandleRxDot1xPacket)
+	// the EAPOL frames are shared with the authenticator that gets them first
+	core.ParserRegisterShared("dot1x", 0, HandleRxDot1xPacket,
+		core.ParserMatch{EthType: uint16(layers.EthernetTypeEAPOL)})
 }
 
 func Register(ctx *core.CThreadCtx) {
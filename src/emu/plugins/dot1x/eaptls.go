@@ -0,0 +1,406 @@
+// Copyright (c) 2021 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dot1x
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"emu/plugins/tlspipe"
+	"encoding/binary"
+	"errors"
+	"external/google/gopacket/layers"
+)
+
+/*
+EAP-TLS rfc5216, PEAPv0 draft-josefsson-pppext-eap-tls-eap and EAP-TTLSv0 rfc5281
+
+The three methods run a TLS handshake in EAP, the TLS records are fragmented by the flags of the method header.
+EAP-TLS authenticates the client by its certificate. PEAP and TTLS authenticate the server by its certificate and run
+an inner EAP-MSCHAPv2 in the tunnel, PEAP in compressed inner EAP packets and TTLS in EAP-Message AVPs.
+
+Limitations
+ - TLS 1.2 at most, the TLS 1.3 variants of the methods (rfc9190) are not supported.
+ - PEAPv0 only, without the Crypto-Binding TLV.
+ - TTLS runs EAP-MSCHAPv2 only, not the non EAP inner methods.
+*/
+
+const (
+	EAP_TYPE_TLS  = 13
+	EAP_TYPE_TTLS = 21
+	EAP_TYPE_PEAP = 25
+	EAP_TYPE_TLV  = 33 // PEAP Extensions
+
+	EAP_TLS_FLAG_LENGTH = 0x80 // the fragment has the total length of the message
+	EAP_TLS_FLAG_MORE   = 0x40 // more fragments follow
+	EAP_TLS_FLAG_START  = 0x20 // start of the method
+	EAP_TLS_VER_MASK    = 0x07 // version of PEAP/TTLS
+
+	EAP_TLS_DEF_FRAGMENT_SIZE = 1024
+	EAP_TLS_MAX_MESSAGE_SIZE  = 64 * 1024
+
+	PEAP_TLV_RESULT  = 3
+	PEAP_TLV_MANDATE = 0x8000
+	PEAP_RESULT_OK   = 1
+	PEAP_RESULT_FAIL = 2
+
+	TTLS_AVP_USER_NAME   = 1
+	TTLS_AVP_EAP_MESSAGE = 79
+	TTLS_AVP_MANDATORY   = 0x40
+	TTLS_AVP_VENDOR      = 0x80
+)
+
+// tlsClientConfig builds the TLS configuration of the methods from the certificates of the init json.
+func (o *Dot1xCfg) tlsClientConfig() (*tls.Config, error) {
+	cfg := &tls.Config{
+		MaxVersion: tls.VersionTLS12,
+		ServerName: o.ServerName,
+	}
+
+	if o.Cert != "" || o.Key != "" {
+		cert, err := tls.X509KeyPair([]byte(o.Cert), []byte(o.Key))
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if o.CaCert == "" {
+		// the server is not verified
+		cfg.InsecureSkipVerify = true
+		return cfg, nil
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM([]byte(o.CaCert)) {
+		return nil, errors.New("dot1x: invalid ca_cert")
+	}
+	cfg.RootCAs = roots
+	if o.ServerName == "" {
+		// crypto/tls verifies the name, verify only the chain
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			certs := make([]*x509.Certificate, len(rawCerts))
+			for i, raw := range rawCerts {
+				cert, err := x509.ParseCertificate(raw)
+				if err != nil {
+					return err
+				}
+				certs[i] = cert
+			}
+			if len(certs) == 0 {
+				return errors.New("dot1x: no server certificate")
+			}
+			opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+			for _, cert := range certs[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			_, err := certs[0].Verify(opts)
+			return err
+		}
+	}
+	return cfg, nil
+}
+
+type EapTlsHandler struct {
+	eapType       uint8
+	cfg           *tls.Config
+	pipe          *tlspipe.TlsPipe
+	rxMsg         []byte // reassembled message of the server
+	txMsg         []byte // message to the server that was not sent yet
+	txTotal       int    // size of the message in txMsg
+	handshakeDone bool
+	inner         Dot1xMethodIF // inner EAP-MSCHAPv2 of PEAP/TTLS
+	innerDone     bool          // the inner method ended, waiting for the result
+}
+
+func (o *EapTlsHandler) GetName() string {
+	switch o.eapType {
+	case EAP_TYPE_PEAP:
+		return "eap-peap"
+	case EAP_TYPE_TTLS:
+		return "eap-ttls"
+	}
+	return "eap-tls"
+}
+
+func (o *EapTlsHandler) reset() {
+	if o.pipe != nil {
+		o.pipe.Close()
+		o.pipe = nil
+	}
+	o.rxMsg = o.rxMsg[:0]
+	o.txMsg = nil
+	o.txTotal = 0
+	o.handshakeDone = false
+	o.innerDone = false
+	if o.inner != nil {
+		o.inner.OnRemove()
+	}
+	o.inner = NewEapMschapv2()
+}
+
+// fragment returns the method data of the next response, a fragment of txMsg or an ack.
+func (o *EapTlsHandler) fragment(d *Dot1xMethodData) []byte {
+	size := int(d.plug.cfg.FragmentSize)
+	if len(o.txMsg) == 0 {
+		return []byte{0} // ack
+	}
+	var flags uint8
+	var hdr []byte
+	if len(o.txMsg) == o.txTotal && o.txTotal > size {
+		flags |= EAP_TLS_FLAG_LENGTH
+		hdr = make([]byte, 4)
+		binary.BigEndian.PutUint32(hdr, uint32(o.txTotal))
+	}
+	n := len(o.txMsg)
+	if n > size {
+		n = size
+		flags |= EAP_TLS_FLAG_MORE
+	}
+	r := append([]byte{flags}, hdr...)
+	r = append(r, o.txMsg[:n]...)
+	o.txMsg = o.txMsg[n:]
+	d.plug.stats.pktTlsFragTx++
+	return r
+}
+
+// send queues the records of the pipe as the next message to the server.
+func (o *EapTlsHandler) send() {
+	o.txMsg = o.pipe.Pull()
+	o.txTotal = len(o.txMsg)
+}
+
+func (o *EapTlsHandler) BuildResp(d *Dot1xMethodData) (bool, bool, []byte) {
+	stats := &d.plug.stats
+	data := d.eap.TypeData
+	if d.eap.Length < 6 || len(data) < int(d.eap.Length)-5 {
+		stats.pktMethodWrongLen++
+		return false, false, []byte{}
+	}
+	data = data[:d.eap.Length-5] // without the padding of the frame
+	flags := data[0]
+	data = data[1:]
+	if flags&EAP_TLS_FLAG_LENGTH != 0 {
+		if len(data) < 4 || binary.BigEndian.Uint32(data[0:4]) > EAP_TLS_MAX_MESSAGE_SIZE {
+			stats.pktTlsInvalidFrag++
+			return false, false, []byte{}
+		}
+		data = data[4:]
+	}
+
+	if flags&EAP_TLS_FLAG_START != 0 {
+		o.reset()
+		o.pipe = tlspipe.NewTlsPipe(o.cfg, false)
+		o.pipe.Start()
+		o.send()
+		return true, false, o.fragment(d)
+	}
+	if o.pipe == nil {
+		stats.pktMethodWrongstate++
+		return false, false, []byte{}
+	}
+
+	if len(o.txMsg) > 0 {
+		// the server acks a fragment
+		if len(data) > 0 || flags&EAP_TLS_FLAG_MORE != 0 {
+			stats.pktTlsInvalidFrag++
+			return false, false, []byte{}
+		}
+		return true, false, o.fragment(d)
+	}
+
+	stats.pktTlsFragRx++
+	if len(o.rxMsg)+len(data) > EAP_TLS_MAX_MESSAGE_SIZE {
+		stats.pktTlsInvalidFrag++
+		return false, false, []byte{}
+	}
+	o.rxMsg = append(o.rxMsg, data...)
+	if flags&EAP_TLS_FLAG_MORE != 0 {
+		return true, false, []byte{0} // ack
+	}
+
+	o.pipe.Push(o.rxMsg)
+	o.rxMsg = o.rxMsg[:0]
+	if o.pipe.Failed() {
+		if !o.handshakeDone {
+			stats.pktTlsHandshakeErr++
+		}
+		// the alert is sent, the server ends with a failure
+		o.send()
+		return true, true, o.fragment(d)
+	}
+
+	finish := false
+	if !o.handshakeDone && o.pipe.HandshakeDone() {
+		o.handshakeDone = true
+		stats.pktTlsHandshakeOk++
+		switch o.eapType {
+		case EAP_TYPE_TLS:
+			finish = true
+		case EAP_TYPE_TTLS:
+			// the client starts the inner method with its identity
+			id := []byte(*d.plug.cfg.User)
+			avps := ttlsAvp(TTLS_AVP_USER_NAME, id)
+			avps = append(avps, ttlsAvp(TTLS_AVP_EAP_MESSAGE, innerEap(d.eap.Id, uint8(layers.EAPTypeIdentity), id))...)
+			o.pipe.Write(avps)
+		}
+	}
+	if o.handshakeDone && o.eapType != EAP_TYPE_TLS {
+		finish = o.handleTunnel(d)
+	}
+	o.send()
+	return true, finish, o.fragment(d)
+}
+
+// handleTunnel handles the application data of PEAP/TTLS, it returns true when the inner method ended.
+func (o *EapTlsHandler) handleTunnel(d *Dot1xMethodData) bool {
+	app := o.pipe.Read()
+	if len(app) == 0 {
+		return false
+	}
+	var req []byte // inner EAP request with the header
+	if o.eapType == EAP_TYPE_PEAP {
+		if app[0] == EAP_TYPE_TLV || (len(app) >= 5 && app[0] == uint8(layers.EAPCodeRequest) && app[4] == EAP_TYPE_TLV) {
+			return o.handlePeapResult(d, app)
+		}
+		// PEAPv0 omits the header of the inner packets, the identifier is the one of the outer packet
+		req = append([]byte{uint8(layers.EAPCodeRequest), d.eap.Id, 0, 0}, app...)
+		binary.BigEndian.PutUint16(req[2:4], uint16(len(req)))
+	} else {
+		req = ttlsEapMessage(app)
+	}
+
+	var eap layers.EAP
+	if len(req) < 5 || eap.DecodeFromBytes(req, nil) != nil || eap.Code != layers.EAPCodeRequest {
+		d.plug.stats.pktTlsInnerErr++
+		return false
+	}
+
+	var resp []byte
+	switch uint8(eap.Type) {
+	case uint8(layers.EAPTypeIdentity):
+		resp = innerEap(eap.Id, uint8(layers.EAPTypeIdentity), []byte(*d.plug.cfg.User))
+	case EAP_TYPE_MSCHAPV2:
+		inner := Dot1xMethodData{plug: d.plug, eap: &eap}
+		ok, finish, r := o.inner.BuildResp(&inner)
+		if !ok {
+			// acknowledge the failure, the server ends with a failure result
+			d.plug.stats.pktTlsInnerErr++
+			r = []byte{MS_CHAPV2_FAILURE}
+			finish = true
+		}
+		resp = innerEap(eap.Id, EAP_TYPE_MSCHAPV2, r)
+		o.innerDone = finish
+	default:
+		resp = innerEap(eap.Id, uint8(layers.EAPTypeNACK), []byte{EAP_TYPE_MSCHAPV2})
+	}
+
+	if o.eapType == EAP_TYPE_PEAP {
+		o.pipe.Write(resp[4:])
+		return false // PEAP ends with the result TLV
+	}
+	o.pipe.Write(ttlsAvp(TTLS_AVP_EAP_MESSAGE, resp))
+	return o.innerDone
+}
+
+// handlePeapResult answers the Result TLV of the server with the same result.
+func (o *EapTlsHandler) handlePeapResult(d *Dot1xMethodData, app []byte) bool {
+	id := d.eap.Id
+	if app[0] != EAP_TYPE_TLV {
+		id = app[1]
+		app = app[4:]
+	}
+	result := uint16(PEAP_RESULT_FAIL)
+	for tlvs := app[1:]; len(tlvs) >= 4; {
+		t := binary.BigEndian.Uint16(tlvs[0:2]) &^ PEAP_TLV_MANDATE
+		l := int(binary.BigEndian.Uint16(tlvs[2:4]))
+		if len(tlvs) < 4+l {
+			break
+		}
+		if t == PEAP_TLV_RESULT && l == 2 {
+			result = binary.BigEndian.Uint16(tlvs[4:6])
+		}
+		tlvs = tlvs[4+l:]
+	}
+	if result != PEAP_RESULT_OK || !o.innerDone {
+		d.plug.stats.pktTlsInnerErr++
+		result = PEAP_RESULT_FAIL
+	}
+	tlv := make([]byte, 6)
+	binary.BigEndian.PutUint16(tlv[0:2], PEAP_TLV_MANDATE|PEAP_TLV_RESULT)
+	binary.BigEndian.PutUint16(tlv[2:4], 2)
+	binary.BigEndian.PutUint16(tlv[4:6], result)
+	o.pipe.Write(innerEap(id, EAP_TYPE_TLV, tlv))
+	return true
+}
+
+// innerEap builds an EAP response of the tunnel.
+func innerEap(id uint8, eapType uint8, data []byte) []byte {
+	b := []byte{uint8(layers.EAPCodeResponse), id, 0, 0, eapType}
+	b = append(b, data...)
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	return b
+}
+
+// ttlsAvp builds a mandatory AVP padded to 4 bytes, rfc5281 10.1.
+func ttlsAvp(code uint32, data []byte) []byte {
+	b := make([]byte, 8, 8+len(data)+3)
+	binary.BigEndian.PutUint32(b[0:4], code)
+	binary.BigEndian.PutUint32(b[4:8], uint32(8+len(data)))
+	b[4] = TTLS_AVP_MANDATORY
+	b = append(b, data...)
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// ttlsEapMessage returns the concatenated EAP-Message AVPs, nil in case there is none.
+func ttlsEapMessage(avps []byte) []byte {
+	var msg []byte
+	for len(avps) >= 8 {
+		code := binary.BigEndian.Uint32(avps[0:4])
+		flags := avps[4]
+		l := int(binary.BigEndian.Uint32(avps[4:8]) & 0xffffff)
+		hdr := 8
+		if flags&TTLS_AVP_VENDOR != 0 {
+			hdr = 12
+		}
+		if l < hdr || l > len(avps) {
+			return msg
+		}
+		if code == TTLS_AVP_EAP_MESSAGE && hdr == 8 {
+			msg = append(msg, avps[hdr:l]...)
+		}
+		l = (l + 3) &^ 3
+		if l > len(avps) {
+			l = len(avps)
+		}
+		avps = avps[l:]
+	}
+	return msg
+}
+
+func (o *EapTlsHandler) Success(d *Dot1xMethodData) bool {
+	if o.eapType == EAP_TYPE_TLS {
+		return o.handshakeDone
+	}
+	return o.innerDone
+}
+
+func (o *EapTlsHandler) OnRemove() {
+	if o.pipe != nil {
+		o.pipe.Close()
+		o.pipe = nil
+	}
+}
+
+func NewEapTls(eapType uint8, cfg *tls.Config) Dot1xMethodIF {
+	p := new(EapTlsHandler)
+	p.eapType = eapType
+	p.cfg = cfg
+	p.rxMsg = make([]byte, 0)
+	return p
+}
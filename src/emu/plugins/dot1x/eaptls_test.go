@@ -0,0 +1,341 @@
+// Copyright (c) 2021 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dot1x
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"emu/core"
+	"emu/plugins/tlspipe"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"external/google/gopacket/layers"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate and its key for the tests.
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPem string
+	keyPem  string
+}
+
+// newTestCert creates a certificate signed by ca, a self signed CA in case ca is nil.
+func newTestCert(t *testing.T, cn string, ca *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	parent, signer := tmpl, key
+	if ca == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPem: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		keyPem:  string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})),
+	}
+}
+
+// VethEapTlsSim is the authenticator and the server of EAP-TLS, PEAP and TTLS with an inner EAP-MSCHAPv2.
+type VethEapTlsSim struct {
+	tctx      *core.CThreadCtx
+	method    uint8
+	cfg       *tls.Config
+	fragSize  int
+	user      string
+	password  string
+	pipe      *tlspipe.TlsPipe
+	id        uint8
+	rxMsg     []byte
+	txMsg     []byte
+	challenge []byte
+}
+
+func (o *VethEapTlsSim) reply(code layers.EAPCode, eapType uint8, d []byte) *core.Mbuf {
+	if code == layers.EAPCodeRequest {
+		o.id++
+	}
+	return genMbuf(o.tctx, GenerateOfferPacket(uint8(code), o.id, eapType, d))
+}
+
+// fragment sends the next fragment of txMsg, an empty request in case there is nothing to send.
+func (o *VethEapTlsSim) fragment() *core.Mbuf {
+	n := len(o.txMsg)
+	var flags uint8
+	if n > o.fragSize {
+		n = o.fragSize
+		flags = EAP_TLS_FLAG_MORE
+	}
+	d := append([]byte{flags}, o.txMsg[:n]...)
+	o.txMsg = o.txMsg[n:]
+	return o.reply(layers.EAPCodeRequest, o.method, d)
+}
+
+// tunnel answers the inner EAP-MSCHAPv2 in the tunnel, it returns true in case the outer success should be sent.
+func (o *VethEapTlsSim) tunnel(app []byte) bool {
+	var resp []byte // inner response with the header
+	if o.method == EAP_TYPE_PEAP {
+		if len(app) == 0 {
+			// the client acked the finished of the server, start with the compressed identity request
+			o.pipe.Write([]byte{uint8(layers.EAPTypeIdentity)})
+			return false
+		}
+		if app[0] == uint8(layers.EAPCodeResponse) && len(app) > 4 && app[4] == EAP_TYPE_TLV {
+			return bytes.Equal(app[5:], []byte{0x80, 0x03, 0, 2, 0, PEAP_RESULT_OK})
+		}
+		resp = append([]byte{uint8(layers.EAPCodeResponse), o.id, 0, 0}, app...)
+	} else {
+		resp = ttlsEapMessage(app)
+	}
+
+	var req []byte // inner request without the header
+	switch {
+	case resp[4] == uint8(layers.EAPTypeIdentity):
+		o.challenge = []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+		req = []byte{EAP_TYPE_MSCHAPV2, MS_CHAPV2_CHALLENGE, 1, 0, 5 + 16 + 3, 16}
+		req = append(req, o.challenge...)
+		req = append(req, []byte("srv")...)
+	case resp[4] == EAP_TYPE_MSCHAPV2 && resp[5] == MS_CHAPV2_RESPONSE:
+		peerChallenge := resp[10:26]
+		res, _ := Encryptv2(o.challenge, peerChallenge, o.user, o.password)
+		if !bytes.Equal(res.ChallengeResponse, resp[34:58]) {
+			return false
+		}
+		msg := res.AuthenticatorResponse + " M=ok"
+		req = []byte{EAP_TYPE_MSCHAPV2, MS_CHAPV2_SUCCESS, 1, 0, uint8(4 + len(msg))}
+		req = append(req, []byte(msg)...)
+	case resp[4] == EAP_TYPE_MSCHAPV2 && resp[5] == MS_CHAPV2_SUCCESS:
+		if o.method == EAP_TYPE_TTLS {
+			return true
+		}
+		req = []byte{uint8(layers.EAPCodeRequest), o.id, 0, 11, EAP_TYPE_TLV, 0x80, 0x03, 0, 2, 0, PEAP_RESULT_OK}
+		o.pipe.Write(req)
+		return false
+	default:
+		return false
+	}
+
+	if o.method == EAP_TYPE_PEAP {
+		o.pipe.Write(req)
+	} else {
+		full := append([]byte{uint8(layers.EAPCodeRequest), o.id, 0, 0}, req...)
+		binary.BigEndian.PutUint16(full[2:4], uint16(len(full)))
+		o.pipe.Write(ttlsAvp(TTLS_AVP_EAP_MESSAGE, full))
+	}
+	return false
+}
+
+func (o *VethEapTlsSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
+	p := append([]byte{}, m.GetData()...)
+	m.FreeMbuf()
+	l3 := len(getL2())
+	if p[l3+1] == byte(layers.EAPOLTypeStart) {
+		o.id = 0
+		return o.reply(layers.EAPCodeRequest, uint8(layers.EAPTypeIdentity), []byte{})
+	}
+
+	var eap layers.EAP
+	if eap.DecodeFromBytes(p[l3+4:], nil) != nil || eap.Code != layers.EAPCodeResponse {
+		return nil
+	}
+	if eap.Type == layers.EAPTypeIdentity {
+		o.pipe = tlspipe.NewTlsPipe(o.cfg, true)
+		o.pipe.Start()
+		return o.reply(layers.EAPCodeRequest, o.method, []byte{EAP_TLS_FLAG_START})
+	}
+	if uint8(eap.Type) != o.method {
+		return o.reply(layers.EAPCodeFailure, 0, []byte{})
+	}
+
+	data := eap.TypeData[:eap.Length-5]
+	flags := data[0]
+	data = data[1:]
+	if flags&EAP_TLS_FLAG_LENGTH != 0 {
+		data = data[4:]
+	}
+	if len(o.txMsg) > 0 {
+		return o.fragment()
+	}
+	o.rxMsg = append(o.rxMsg, data...)
+	if flags&EAP_TLS_FLAG_MORE != 0 {
+		return o.reply(layers.EAPCodeRequest, o.method, []byte{0})
+	}
+
+	done := o.pipe.HandshakeDone()
+	o.pipe.Push(o.rxMsg)
+	o.rxMsg = o.rxMsg[:0]
+	if o.pipe.Failed() {
+		return o.reply(layers.EAPCodeFailure, 0, []byte{})
+	}
+	if done {
+		app := o.pipe.Read()
+		if o.method == EAP_TYPE_TLS || o.tunnel(app) {
+			o.pipe.Close()
+			return o.reply(layers.EAPCodeSuccess, 0, []byte{})
+		}
+	}
+	o.txMsg = o.pipe.Pull()
+	return o.fragment()
+}
+
+// EapTlsTestBase represents the base parameters for an EAP-TLS/PEAP/TTLS test.
+type EapTlsTestBase struct {
+	method     uint8
+	clientCfg  map[string]interface{}
+	srvCert    *testCert
+	clientCA   *testCert // CA of the client certificates, for EAP-TLS
+	smState    uint8
+	handshake  uint64 // expected pktTlsHandshakeOk
+	handshakeE uint64 // expected pktTlsHandshakeErr
+	fragmented bool   // a flight is fragmented in both directions, 2 fragments each way without it
+}
+
+// Run the test.
+func (o *EapTlsTestBase) Run(t *testing.T) {
+	simVeth := VethEapTlsSim{method: o.method, fragSize: 400, user: "hhaim", password: "432768ec1d"}
+	simVeth.cfg = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{o.srvCert.cert.Raw}, PrivateKey: o.srvCert.key}},
+		MaxVersion:   tls.VersionTLS12,
+	}
+	if o.clientCA != nil {
+		pool := x509.NewCertPool()
+		pool.AddCert(o.clientCA.cert)
+		simVeth.cfg.ClientCAs = pool
+		simVeth.cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	var simrx core.VethIFSim = &simVeth
+
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	defer tctx.Delete()
+	simVeth.tctx = tctx
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: [2]uint32{0x81000001, 0x81000002}})
+	ns := core.NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+	client := core.NewClient(ns, core.MACKey{0, 0, 1, 0, 0, 1}, core.Ipv4Key{}, core.Ipv6Key{}, core.Ipv4Key{})
+	ns.AddClient(client)
+
+	cfg := map[string]interface{}{"user": "hhaim", "password": "432768ec1d", "fragment_size": 300}
+	for k, v := range o.clientCfg {
+		cfg[k] = v
+	}
+	initJson, _ := json.Marshal(cfg)
+	ns.PluginCtx.CreatePlugins([]string{DOT1X_PLUG}, [][]byte{})
+	if err := client.PluginCtx.CreatePlugins([]string{DOT1X_PLUG}, [][]byte{initJson}); err != nil {
+		t.Fatal(err)
+	}
+	tctx.RegisterParserCb(DOT1X_PLUG)
+
+	m := false
+	if monitor > 0 {
+		m = true
+	}
+	tctx.Veth.SetDebug(m, os.Stdout, false)
+	tctx.MainLoopSim(5 * time.Second)
+
+	plug := client.PluginCtx.Get(DOT1X_PLUG).Ext.(*PluginDot1xClient)
+	plug.cdbv.Dump()
+	if plug.smState != o.smState || plug.selectedMethod != o.method {
+		t.Fatalf("Bad state %d method %d, want %d %d", plug.smState, plug.selectedMethod, o.smState, o.method)
+	}
+	s := plug.stats
+	if s.pktTlsHandshakeOk != o.handshake || s.pktTlsHandshakeErr != o.handshakeE || s.pktTlsInnerErr != 0 ||
+		s.pktTlsInvalidFrag != 0 || s.pktMethodWrongLen != 0 || s.pktTxNack != 0 {
+		t.Fatalf("Bad counters %+v", s)
+	}
+	if o.fragmented && (s.pktTlsFragTx <= 2 || s.pktTlsFragRx <= 2) {
+		t.Fatalf("Messages are not fragmented %+v", s)
+	}
+	client.PluginCtx.RemovePlugins(DOT1X_PLUG) // stops the tls goroutines
+}
+
+func TestEapTls(t *testing.T) {
+	ca := newTestCert(t, "ca", nil)
+	clientCert := newTestCert(t, "hhaim", ca)
+	a := &EapTlsTestBase{
+		method: EAP_TYPE_TLS,
+		clientCfg: map[string]interface{}{"ca_cert": ca.certPem, "server_name": "radius",
+			"cert": clientCert.certPem, "key": clientCert.keyPem, "flags": EAP_MD5_MASK | EAP_MSCHAPv2_MASK},
+		srvCert:    newTestCert(t, "radius", ca),
+		clientCA:   ca,
+		smState:    EAP_DONE_OK,
+		handshake:  1,
+		fragmented: true,
+	}
+	a.Run(t)
+}
+
+// TestEapTlsBadServer fails since the server certificate is not signed by the CA of the client.
+func TestEapTlsBadServer(t *testing.T) {
+	ca := newTestCert(t, "ca", nil)
+	other := newTestCert(t, "other", nil)
+	clientCert := newTestCert(t, "hhaim", ca)
+	a := &EapTlsTestBase{
+		method: EAP_TYPE_TLS,
+		clientCfg: map[string]interface{}{"ca_cert": ca.certPem,
+			"cert": clientCert.certPem, "key": clientCert.keyPem, "flags": EAP_MD5_MASK | EAP_MSCHAPv2_MASK},
+		srvCert:    newTestCert(t, "radius", other),
+		clientCA:   ca,
+		smState:    EAP_DONE_FAIL,
+		handshakeE: 1,
+	}
+	a.Run(t)
+}
+
+func TestEapPeap(t *testing.T) {
+	ca := newTestCert(t, "ca", nil)
+	a := &EapTlsTestBase{
+		method: EAP_TYPE_PEAP,
+		clientCfg: map[string]interface{}{"ca_cert": ca.certPem, "anonymous_identity": "anonymous",
+			"flags": EAP_MD5_MASK | EAP_MSCHAPv2_MASK},
+		srvCert:   newTestCert(t, "radius", ca),
+		smState:   EAP_DONE_OK,
+		handshake: 1,
+	}
+	a.Run(t)
+}
+
+func TestEapTtls(t *testing.T) {
+	ca := newTestCert(t, "ca", nil)
+	a := &EapTlsTestBase{
+		method:    EAP_TYPE_TTLS,
+		clientCfg: map[string]interface{}{"flags": EAP_MD5_MASK | EAP_MSCHAPv2_MASK | EAP_PEAP_MASK},
+		srvCert:   newTestCert(t, "radius", ca),
+		smState:   EAP_DONE_OK,
+		handshake: 1,
+	}
+	a.Run(t)
+}
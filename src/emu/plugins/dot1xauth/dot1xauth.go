@@ -0,0 +1,1189 @@
+/*
+Copyright (c) 2021 Cisco Systems and/or its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+that can be found in the LICENSE file in the root of the source
+tree.
+*/
+
+package dot1xauth
+
+import (
+	"crypto/rand"
+	"emu/core"
+	"emu/plugins/dot1x"
+	"emu/plugins/transport"
+	"encoding/binary"
+	"external/google/gopacket/layers"
+	"external/osamingo/jsonrpc"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/intel-go/fastjson"
+)
+
+/*
+802.1X Authenticator - https://standards.ieee.org/standard/802_1X-2020.html
+
+RADIUS - https://datatracker.ietf.org/doc/html/rfc2865, RADIUS Support For EAP - rfc3579, 802.1X RADIUS - rfc3580
+
+The authenticator answers the EAPOL-Start of the supplicants in its namespace by EAP-Request/Identity and relays
+the EAP conversation to a RADIUS server over a UDP socket of the transport layer. Each EAP-Response is sent in an
+Access-Request, and the EAP of the Access-Challenge/Accept/Reject is sent to the supplicant. All the RADIUS packets
+are protected by Message-Authenticator.
+
+In server mode the client is a minimal RADIUS server that authenticates the users of its init JSON by EAP-MD5 or
+EAP-MSCHAPv2, hence the supplicant, the authenticator and the server can all run in EMU.
+
+The EAPOL frames are shared with the dot1x supplicant, the authenticator gets the frames first and passes the frames
+that are not for an authenticator to the supplicant.
+
+Limitations
+ - The authenticator does not control the port, the traffic of a supplicant is not blocked before it is authenticated.
+ - No re-authentication, accounting or MPPE keys.
+ - The server supports EAP-MD5 and EAP-MSCHAPv2 only, the TLS based methods need an external server.
+*/
+
+const (
+	DOT1X_AUTH_PLUG      = "dot1xauth"
+	ModeAuthenticator    = "authenticator"
+	ModeServer           = "server"
+	MethodMD5            = "md5"
+	MethodMSCHAPv2       = "mschapv2"
+	DefaultRadiusPort    = 1812
+	DefaultNasIdentifier = "trex-emu"
+	DefaultSuppTimeout   = 30   // Default seconds to wait for an EAP-Response of the supplicant
+	DefaultServerTimeout = 5    // Default seconds to wait for a response of the RADIUS server
+	DefaultMaxReq        = 2    // Default retransmissions of an EAP-Request
+	DefaultMaxRetries    = 3    // Default retransmissions of an Access-Request
+	DefaultConvTimeout   = 60   // Seconds the server keeps a conversation without requests
+	FramedMtu            = 1400 // Framed-MTU of the Access-Request, rfc3579
+	EapolHeaderLen       = 4
+	EapHeaderLen         = 4
+
+	// EAPOL packet types
+	EapolTypeEAP    = 0
+	EapolTypeStart  = 1
+	EapolTypeLogoff = 2
+
+	// EAP codes
+	EapCodeRequest  = 1
+	EapCodeResponse = 2
+	EapCodeSuccess  = 3
+	EapCodeFailure  = 4
+
+	// EAP types
+	EapTypeIdentity = 1
+	EapTypeNak      = 3
+)
+
+// Session states
+const (
+	SessionConnecting     = iota // EAP-Request/Identity was sent
+	SessionAuthenticating        // EAP is relayed to the server
+	SessionAuthenticated         // The server accepted the supplicant
+	SessionFailed                // The server rejected the supplicant or did not answer
+)
+
+var sessionStateNames = []string{"connecting", "authenticating", "authenticated", "failed"}
+
+// paeGroupMac is the destination of the EAPOL frames of the supplicants.
+var paeGroupMac = core.MACKey{0x01, 0x80, 0xc2, 0x00, 0x00, 0x03}
+
+/*======================================================================================================
+											Stats
+======================================================================================================*/
+
+// Dot1xAuthStats is a struct that consolidates all the counters of an authenticator/server.
+type Dot1xAuthStats struct {
+	invalidInitJson    uint64 // Error while decoding client init Json
+	activeSessions     uint64 // Num of sessions
+	pktRxStart         uint64 // Num EAPOL-Start received
+	pktRxLogoff        uint64 // Num EAPOL-Logoff received
+	pktRxResp          uint64 // Num EAP-Response received
+	pktRxRespId        uint64 // Num EAP-Response/Identity received
+	pktRxInvalid       uint64 // Num EAPOL frames that could not be decoded
+	pktRxWrongId       uint64 // Num EAP-Response with an unexpected identifier
+	pktRxWrongState    uint64 // Num EAP-Response in a state that does not expect it
+	pktRxNoSession     uint64 // Num EAP-Response of a supplicant without session
+	pktTxReqId         uint64 // Num EAP-Request/Identity sent
+	pktTxReq           uint64 // Num EAP-Request relayed to supplicants
+	pktTxReqRetransmit uint64 // Num EAP-Request retransmitted
+	pktTxSuccess       uint64 // Num EAP-Success sent
+	pktTxFailure       uint64 // Num EAP-Failure sent
+	suppTimeout        uint64 // Num supplicants that did not answer
+	authSuccess        uint64 // Num successful authentications
+	authFailure        uint64 // Num failed authentications
+	radiusTxRequest    uint64 // Num Access-Request sent
+	radiusRetransmit   uint64 // Num Access-Request retransmitted
+	radiusTimeout      uint64 // Num Access-Request without response
+	radiusTxErr        uint64 // Num Access-Request that could not be sent
+	radiusNoId         uint64 // Num Access-Request not sent since all the identifiers are in use
+	radiusRxChallenge  uint64 // Num Access-Challenge received
+	radiusRxAccept     uint64 // Num Access-Accept received
+	radiusRxReject     uint64 // Num Access-Reject received
+	radiusRxInvalid    uint64 // Num RADIUS packets that could not be decoded or have a bad code
+	radiusRxInvalidMa  uint64 // Num RADIUS packets with a wrong authenticator
+	radiusRxUnknownId  uint64 // Num RADIUS packets with an identifier that is not pending
+	srvRxRequest       uint64 // Num Access-Request received by the server
+	srvRxDuplicate     uint64 // Num retransmitted Access-Request, the last response is retransmitted
+	srvRxInvalid       uint64 // Num Access-Request that could not be decoded
+	srvRxInvalidMa     uint64 // Num Access-Request with a wrong Message-Authenticator
+	srvRxWrongId       uint64 // Num EAP-Response with an unexpected identifier
+	srvUnknownUser     uint64 // Num Access-Request of an unknown user
+	srvUnknownState    uint64 // Num Access-Request of an unknown conversation
+	srvRxNak           uint64 // Num EAP-Nak received by the server
+	srvConvTimeout     uint64 // Num conversations removed without result
+	srvTxChallenge     uint64 // Num Access-Challenge sent
+	srvTxAccept        uint64 // Num Access-Accept sent
+	srvTxReject        uint64 // Num Access-Reject sent
+}
+
+// NewDot1xAuthStatsDb creates a new database of Dot1xAuth counters.
+func NewDot1xAuthStatsDb(o *Dot1xAuthStats) *core.CCounterDb {
+	db := core.NewCCounterDb(DOT1X_AUTH_PLUG)
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.invalidInitJson,
+		Name:     "invalidInitJson",
+		Help:     "Error while decoding init Json",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.activeSessions,
+		Name:     "activeSessions",
+		Help:     "Sessions of supplicants",
+		Unit:     "sessions",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxStart,
+		Name:     "pktRxStart",
+		Help:     "Rx EAPOL-Start",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxLogoff,
+		Name:     "pktRxLogoff",
+		Help:     "Rx EAPOL-Logoff",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxResp,
+		Name:     "pktRxResp",
+		Help:     "Rx EAP-Response",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxRespId,
+		Name:     "pktRxRespId",
+		Help:     "Rx EAP-Response/Identity",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxInvalid,
+		Name:     "pktRxInvalid",
+		Help:     "Rx EAPOL decode error",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxWrongId,
+		Name:     "pktRxWrongId",
+		Help:     "Rx EAP-Response with unexpected identifier",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxWrongState,
+		Name:     "pktRxWrongState",
+		Help:     "Rx EAP-Response in wrong state",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxNoSession,
+		Name:     "pktRxNoSession",
+		Help:     "Rx EAP-Response without session",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxReqId,
+		Name:     "pktTxReqId",
+		Help:     "Tx EAP-Request/Identity",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxReq,
+		Name:     "pktTxReq",
+		Help:     "Tx EAP-Request of the server",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxReqRetransmit,
+		Name:     "pktTxReqRetransmit",
+		Help:     "Tx EAP-Request retransmission",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxSuccess,
+		Name:     "pktTxSuccess",
+		Help:     "Tx EAP-Success",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxFailure,
+		Name:     "pktTxFailure",
+		Help:     "Tx EAP-Failure",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.suppTimeout,
+		Name:     "suppTimeout",
+		Help:     "Supplicant did not answer",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.authSuccess,
+		Name:     "authSuccess",
+		Help:     "Successful authentication",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.authFailure,
+		Name:     "authFailure",
+		Help:     "Failed authentication",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.radiusTxRequest,
+		Name:     "radiusTxRequest",
+		Help:     "Tx Access-Request",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.radiusRetransmit,
+		Name:     "radiusRetransmit",
+		Help:     "Tx Access-Request retransmission",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.radiusTimeout,
+		Name:     "radiusTimeout",
+		Help:     "Access-Request without response",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.radiusTxErr,
+		Name:     "radiusTxErr",
+		Help:     "Access-Request was not sent",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.radiusNoId,
+		Name:     "radiusNoId",
+		Help:     "All the RADIUS identifiers are in use",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.radiusRxChallenge,
+		Name:     "radiusRxChallenge",
+		Help:     "Rx Access-Challenge",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.radiusRxAccept,
+		Name:     "radiusRxAccept",
+		Help:     "Rx Access-Accept",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.radiusRxReject,
+		Name:     "radiusRxReject",
+		Help:     "Rx Access-Reject",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.radiusRxInvalid,
+		Name:     "radiusRxInvalid",
+		Help:     "Rx RADIUS decode error",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.radiusRxInvalidMa,
+		Name:     "radiusRxInvalidMa",
+		Help:     "Rx RADIUS wrong authenticator",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.radiusRxUnknownId,
+		Name:     "radiusRxUnknownId",
+		Help:     "Rx RADIUS identifier is not pending",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.srvRxRequest,
+		Name:     "srvRxRequest",
+		Help:     "Server Rx Access-Request",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.srvRxDuplicate,
+		Name:     "srvRxDuplicate",
+		Help:     "Server Rx retransmitted Access-Request",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.srvRxInvalid,
+		Name:     "srvRxInvalid",
+		Help:     "Server Rx decode error",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.srvRxInvalidMa,
+		Name:     "srvRxInvalidMa",
+		Help:     "Server Rx wrong Message-Authenticator",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.srvRxWrongId,
+		Name:     "srvRxWrongId",
+		Help:     "Server Rx EAP-Response with unexpected identifier",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.srvUnknownUser,
+		Name:     "srvUnknownUser",
+		Help:     "Server Rx unknown user",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.srvUnknownState,
+		Name:     "srvUnknownState",
+		Help:     "Server Rx unknown conversation",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.srvRxNak,
+		Name:     "srvRxNak",
+		Help:     "Server Rx EAP-Nak",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.srvConvTimeout,
+		Name:     "srvConvTimeout",
+		Help:     "Server conversation timeout",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.srvTxChallenge,
+		Name:     "srvTxChallenge",
+		Help:     "Server Tx Access-Challenge",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.srvTxAccept,
+		Name:     "srvTxAccept",
+		Help:     "Server Tx Access-Accept",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.srvTxReject,
+		Name:     "srvTxReject",
+		Help:     "Server Tx Access-Reject",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	return db
+}
+
+/*======================================================================================================
+											Session
+======================================================================================================*/
+
+// Dot1xAuthSession is the authentication of a supplicant.
+type Dot1xAuthSession struct {
+	auth        *PluginDot1xAuthClient // Authenticator of the session
+	mac         core.MACKey            // MAC of the supplicant
+	state       uint8                  // Session state
+	eapolVer    uint8                  // EAPOL version of the supplicant
+	eapId       uint8                  // Identifier of the last EAP-Request
+	eapReq      []byte                 // Last EAP-Request, retransmitted in case the supplicant does not answer
+	reqCnt      uint32                 // Retransmissions of eapReq
+	identity    string                 // Identity of the supplicant
+	radiusId    uint8                  // Identifier of the pending Access-Request
+	radiusReq   []byte                 // Pending Access-Request, nil in case there is none
+	radiusAuth  []byte                 // Request Authenticator of radiusReq
+	radiusState []byte                 // State of the last Access-Challenge
+	retries     uint32                 // Retransmissions of radiusReq
+	timer       core.CHTimerObj        // Retransmission timer
+}
+
+// newDot1xAuthSession creates a new session.
+func newDot1xAuthSession(auth *PluginDot1xAuthClient, mac core.MACKey) *Dot1xAuthSession {
+	o := new(Dot1xAuthSession)
+	o.auth = auth
+	o.mac = mac
+	o.timer.SetCB(o, nil, nil)
+	return o
+}
+
+// restartTimer restarts the retransmission timer.
+func (o *Dot1xAuthSession) restartTimer(sec uint32) {
+	timerCtx := o.auth.Tctx.GetTimerCtx()
+	if o.timer.IsRunning() {
+		timerCtx.Stop(&o.timer)
+	}
+	timerCtx.Start(&o.timer, time.Duration(sec)*time.Second)
+}
+
+// OnEvent retransmits the pending Access-Request or EAP-Request, the authentication fails after the last retry.
+func (o *Dot1xAuthSession) OnEvent(a, b interface{}) {
+	auth := o.auth
+	if o.radiusReq != nil {
+		if o.retries < auth.params.MaxRetries {
+			o.retries++
+			auth.stats.radiusRetransmit++
+			auth.writeRadius(o.radiusReq)
+			o.restartTimer(auth.params.ServerTimeout)
+			return
+		}
+		auth.stats.radiusTimeout++
+		o.fail(nil)
+		return
+	}
+	if o.state == SessionConnecting || o.state == SessionAuthenticating {
+		if o.reqCnt < auth.params.MaxReq {
+			o.reqCnt++
+			auth.stats.pktTxReqRetransmit++
+			auth.sendEapol(o.mac, o.eapolVer, EapolTypeEAP, o.eapReq)
+			o.restartTimer(auth.params.SuppTimeout)
+			return
+		}
+		auth.stats.suppTimeout++
+		o.fail(nil)
+	}
+}
+
+// cancelRadius drops the pending Access-Request.
+func (o *Dot1xAuthSession) cancelRadius() {
+	if o.radiusReq != nil {
+		delete(o.auth.pending, o.radiusId)
+		o.radiusReq = nil
+	}
+}
+
+// close stops the session.
+func (o *Dot1xAuthSession) close() {
+	if o.timer.IsRunning() {
+		o.auth.Tctx.GetTimerCtx().Stop(&o.timer)
+	}
+	o.cancelRadius()
+}
+
+// start starts the authentication by EAP-Request/Identity.
+func (o *Dot1xAuthSession) start(eapolVer uint8) {
+	o.cancelRadius()
+	o.state = SessionConnecting
+	o.eapolVer = eapolVer
+	o.identity = ""
+	o.radiusState = nil
+	o.auth.stats.pktTxReqId++
+	o.sendEapRequest([]byte{EapCodeRequest, o.eapId + 1, 0, EapHeaderLen + 1, EapTypeIdentity})
+}
+
+// sendEapRequest sends an EAP-Request and waits for the response.
+func (o *Dot1xAuthSession) sendEapRequest(eap []byte) {
+	o.eapId = eap[1]
+	o.eapReq = eap
+	o.reqCnt = 0
+	o.auth.sendEapol(o.mac, o.eapolVer, EapolTypeEAP, eap)
+	o.restartTimer(o.auth.params.SuppTimeout)
+}
+
+// succeed ends the authentication by EAP-Success, eap is the EAP of the server, nil in case there is none.
+func (o *Dot1xAuthSession) succeed(eap []byte) {
+	if len(eap) < EapHeaderLen || eap[0] != EapCodeSuccess {
+		eap = []byte{EapCodeSuccess, o.eapId, 0, EapHeaderLen}
+	}
+	o.close()
+	o.state = SessionAuthenticated
+	o.auth.stats.authSuccess++
+	o.auth.stats.pktTxSuccess++
+	o.auth.sendEapol(o.mac, o.eapolVer, EapolTypeEAP, eap)
+}
+
+// fail ends the authentication by EAP-Failure, eap is the EAP of the server, nil in case there is none.
+func (o *Dot1xAuthSession) fail(eap []byte) {
+	if len(eap) < EapHeaderLen || eap[0] != EapCodeFailure {
+		eap = []byte{EapCodeFailure, o.eapId, 0, EapHeaderLen}
+	}
+	o.close()
+	o.state = SessionFailed
+	o.auth.stats.authFailure++
+	o.auth.stats.pktTxFailure++
+	o.auth.sendEapol(o.mac, o.eapolVer, EapolTypeEAP, eap)
+}
+
+// handleEap relays an EAP-Response of the supplicant to the server.
+func (o *Dot1xAuthSession) handleEap(eap []byte) {
+	auth := o.auth
+	if eap[0] != EapCodeResponse || len(eap) <= EapHeaderLen {
+		auth.stats.pktRxInvalid++
+		return
+	}
+	auth.stats.pktRxResp++
+	if eap[1] != o.eapId {
+		auth.stats.pktRxWrongId++
+		return
+	}
+	if o.radiusReq != nil || (o.state != SessionConnecting && o.state != SessionAuthenticating) {
+		// A retransmission of the response that is already relayed
+		auth.stats.pktRxWrongState++
+		return
+	}
+	if eap[EapHeaderLen] == EapTypeIdentity {
+		auth.stats.pktRxRespId++
+		if o.state == SessionConnecting {
+			o.identity = string(eap[EapHeaderLen+1:])
+			o.state = SessionAuthenticating
+		}
+	} else if o.state == SessionConnecting {
+		auth.stats.pktRxWrongState++
+		return
+	}
+	o.sendAccessRequest(eap)
+}
+
+// sendAccessRequest sends an EAP-Response to the server in an Access-Request.
+func (o *Dot1xAuthSession) sendAccessRequest(eap []byte) {
+	auth := o.auth
+	if !auth.allocRadiusId(o) {
+		auth.stats.radiusNoId++
+		return
+	}
+	pkt := RadiusPacket{Code: RadiusAccessRequest, Identifier: o.radiusId}
+	rand.Read(pkt.Authenticator[:])
+	pkt.Add(RadiusAttrUserName, []byte(o.identity))
+	if !auth.Client.Ipv4.IsZero() {
+		pkt.Add(RadiusAttrNasIpAddress, auth.Client.Ipv4[:])
+	}
+	pkt.Add(RadiusAttrNasIdentifier, []byte(auth.params.NasIdentifier))
+	pkt.AddUint32(RadiusAttrNasPortType, RadiusNasPortTypeEthernet)
+	pkt.AddUint32(RadiusAttrFramedMtu, FramedMtu)
+	pkt.Add(RadiusAttrCalledStationId, []byte(stationId(auth.Client.Mac)))
+	pkt.Add(RadiusAttrCallingStationId, []byte(stationId(o.mac)))
+	if o.radiusState != nil {
+		pkt.Add(RadiusAttrState, o.radiusState)
+	}
+	pkt.AddEap(eap)
+	b, err := pkt.Encode(auth.secret, pkt.Authenticator[:])
+	if err != nil {
+		delete(auth.pending, o.radiusId)
+		auth.stats.radiusTxErr++
+		return
+	}
+	o.radiusReq = b
+	o.radiusAuth = append([]byte{}, pkt.Authenticator[:]...)
+	o.retries = 0
+	auth.stats.radiusTxRequest++
+	auth.writeRadius(b)
+	o.restartTimer(auth.params.ServerTimeout)
+}
+
+// handleRadius handles the response of the server to the pending Access-Request.
+func (o *Dot1xAuthSession) handleRadius(pkt *RadiusPacket) {
+	auth := o.auth
+	o.cancelRadius()
+	eap := pkt.Eap()
+	if len(eap) >= EapHeaderLen && int(binary.BigEndian.Uint16(eap[2:4])) <= len(eap) {
+		eap = eap[:binary.BigEndian.Uint16(eap[2:4])]
+	} else {
+		eap = nil
+	}
+
+	switch pkt.Code {
+	case RadiusAccessChallenge:
+		auth.stats.radiusRxChallenge++
+		if len(eap) <= EapHeaderLen || eap[0] != EapCodeRequest {
+			o.fail(nil)
+			return
+		}
+		o.radiusState = append([]byte{}, pkt.Get(RadiusAttrState)...)
+		auth.stats.pktTxReq++
+		o.sendEapRequest(eap)
+	case RadiusAccessAccept:
+		auth.stats.radiusRxAccept++
+		o.succeed(eap)
+	case RadiusAccessReject:
+		auth.stats.radiusRxReject++
+		o.fail(eap)
+	}
+}
+
+// stationId formats a MAC as Called/Calling-Station-Id, rfc3580.
+func stationId(mac core.MACKey) string {
+	return strings.ToUpper(strings.ReplaceAll(mac.String(), ":", "-"))
+}
+
+/*======================================================================================================
+										Plugin Dot1xAuth Emu Client
+======================================================================================================*/
+
+// Dot1xAuthParams represents the init json Api for the authenticator/server Emu Client.
+type Dot1xAuthParams struct {
+	Mode          string            `json:"mode"`                       // authenticator or server. Default to authenticator
+	Secret        string            `json:"secret" validate:"required"` // RADIUS shared secret
+	Server        string            `json:"server"`                     // Authenticator: RADIUS server, ip or ip:port
+	NasIdentifier string            `json:"nas_identifier"`             // Authenticator: NAS-Identifier. Default to DefaultNasIdentifier
+	SuppTimeout   uint32            `json:"supp_timeout"`               // Authenticator: seconds to wait for the supplicant. Default to DefaultSuppTimeout
+	ServerTimeout uint32            `json:"server_timeout"`             // Authenticator: seconds to wait for the server. Default to DefaultServerTimeout
+	MaxReq        uint32            `json:"max_req"`                    // Authenticator: retransmissions of an EAP-Request. Default to DefaultMaxReq
+	MaxRetries    uint32            `json:"max_retries"`                // Authenticator: retransmissions of an Access-Request. Default to DefaultMaxRetries
+	Port          uint16            `json:"port"`                       // Server: UDP port. Default to DefaultRadiusPort
+	Users         map[string]string `json:"users"`                      // Server: password per user
+	Method        string            `json:"method"`                     // Server: md5 or mschapv2. Default to mschapv2
+}
+
+// dot1xAuthEvents holds a list of events on which the Dot1xAuth plugin is interested.
+var dot1xAuthEvents = []string{}
+
+// PluginDot1xAuthClient represents an Emu Client that acts as an 802.1X authenticator or as a RADIUS server.
+type PluginDot1xAuthClient struct {
+	core.PluginBase                                   // Plugin Base embedded struct so we get all the base functionality
+	params          Dot1xAuthParams                   // Init Json params
+	stats           Dot1xAuthStats                    // Dot1xAuth counters
+	cdb             *core.CCounterDb                  // Counters database
+	cdbv            *core.CCounterDbVec               // Counters database vector
+	nsPlug          *PluginDot1xAuthNs                // Namespace plugin
+	server          bool                              // Server mode
+	secret          []byte                            // RADIUS shared secret
+	socket          transport.SocketApi               // Authenticator: socket of the RADIUS server
+	sessions        map[core.MACKey]*Dot1xAuthSession // Authenticator: sessions by supplicant MAC
+	pending         map[uint8]*Dot1xAuthSession       // Authenticator: sessions by the Identifier of their Access-Request
+	nextRadiusId    uint8                             // Authenticator: last allocated RADIUS Identifier
+	listenAddr      string                            // Server: listening address
+	method          uint8                             // Server: preferred EAP method
+	convs           map[string]*radiusConv            // Server: conversations by State
+	nextConvId      uint32                            // Server: last allocated conversation id
+}
+
+// NewDot1xAuthClient creates a new Dot1xAuth Emu Client Plugin.
+func NewDot1xAuthClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
+	o := new(PluginDot1xAuthClient)
+	o.InitPluginBase(ctx, o)                  // Init base object
+	o.RegisterEvents(ctx, dot1xAuthEvents, o) // Register events
+	nsplg := o.Ns.PluginCtx.GetOrCreate(DOT1X_AUTH_PLUG)
+	o.nsPlug = nsplg.Ext.(*PluginDot1xAuthNs)
+	o.cdb = NewDot1xAuthStatsDb(&o.stats) // Register Stats immediately so we can fail safely.
+	o.cdbv = core.NewCCounterDbVec(DOT1X_AUTH_PLUG)
+	o.cdbv.Add(o.cdb)
+
+	// Set the default paramaters
+	o.params.Mode = ModeAuthenticator
+	o.params.NasIdentifier = DefaultNasIdentifier
+	o.params.SuppTimeout = DefaultSuppTimeout
+	o.params.ServerTimeout = DefaultServerTimeout
+	o.params.MaxReq = DefaultMaxReq
+	o.params.MaxRetries = DefaultMaxRetries
+	o.params.Port = DefaultRadiusPort
+	o.params.Method = MethodMSCHAPv2
+
+	err := o.Tctx.UnmarshalValidate(initJson, &o.params) // Unmarshal and validate init json
+	if err != nil {
+		o.stats.invalidInitJson++
+		return nil, err
+	}
+
+	err = o.OnCreate()
+	if err != nil {
+		o.stats.invalidInitJson++
+		return nil, err
+	}
+	o.nsPlug.addAuthenticator(o)
+
+	return &o.PluginBase, nil
+}
+
+// OnCreate is called upon the creation of a new Dot1xAuth Emu client.
+func (o *PluginDot1xAuthClient) OnCreate() (err error) {
+	o.secret = []byte(o.params.Secret)
+	transportCtx := transport.GetTransportCtx(o.Client)
+
+	switch o.params.Mode {
+	case ModeAuthenticator:
+		if o.params.SuppTimeout == 0 || o.params.ServerTimeout == 0 {
+			return fmt.Errorf("Invalid supp_timeout %d or server_timeout %d", o.params.SuppTimeout, o.params.ServerTimeout)
+		}
+		host, port, err := net.SplitHostPort(o.params.Server)
+		if err != nil {
+			host, port = o.params.Server, fmt.Sprint(DefaultRadiusPort)
+		}
+		if net.ParseIP(host) == nil {
+			return fmt.Errorf("Invalid RADIUS server %s", o.params.Server)
+		}
+		o.sessions = make(map[core.MACKey]*Dot1xAuthSession)
+		o.pending = make(map[uint8]*Dot1xAuthSession)
+		o.socket, err = transportCtx.Dial("udp", net.JoinHostPort(host, port), o, nil, nil, 0)
+		if err != nil {
+			return fmt.Errorf("could not create dialing socket: %w", err)
+		}
+	case ModeServer:
+		o.server = true
+		switch o.params.Method {
+		case MethodMD5:
+			o.method = dot1x.EAP_TYPE_MD5
+		case MethodMSCHAPv2:
+			o.method = dot1x.EAP_TYPE_MSCHAPV2
+		default:
+			return fmt.Errorf("Invalid method %s, should be %s or %s", o.params.Method, MethodMD5, MethodMSCHAPv2)
+		}
+		if len(o.params.Users) == 0 {
+			return fmt.Errorf("At least one user is required")
+		}
+		o.convs = make(map[string]*radiusConv)
+		o.listenAddr = fmt.Sprintf(":%d", o.params.Port)
+		if err = transportCtx.Listen("udp", o.listenAddr, o); err != nil {
+			return fmt.Errorf("could not create listening socket: %w", err)
+		}
+	default:
+		return fmt.Errorf("Invalid mode %s, should be %s or %s", o.params.Mode, ModeAuthenticator, ModeServer)
+	}
+	return nil
+}
+
+// OnRemove is called upon removing the Dot1xAuth Emu client.
+func (o *PluginDot1xAuthClient) OnRemove(ctx *core.PluginCtx) {
+	ctx.UnregisterEvents(&o.PluginBase, dot1xAuthEvents)
+	for _, s := range o.sessions {
+		s.close()
+	}
+	for _, c := range o.convs {
+		c.close()
+	}
+	if o.server {
+		transport.GetTransportCtx(o.Client).UnListen("udp", o.listenAddr, o)
+	} else if o.socket != nil {
+		o.socket.Close()
+	}
+	o.nsPlug.removeAuthenticator(o)
+}
+
+// OnEvent for events the client plugin is registered.
+func (o *PluginDot1xAuthClient) OnEvent(msg string, a, b interface{}) {}
+
+// sendEapol sends an EAPOL frame to a supplicant.
+func (o *PluginDot1xAuthClient) sendEapol(dst core.MACKey, ver uint8, eapolType uint8, eap []byte) {
+	l2 := o.Client.GetL2Header(false, uint16(layers.EthernetTypeEAPOL))
+	copy(l2[0:6], dst[:])
+	eapol := []byte{ver, eapolType, 0, 0}
+	binary.BigEndian.PutUint16(eapol[2:4], uint16(len(eap)))
+
+	m := o.Ns.AllocMbuf(uint16(len(l2) + len(eapol) + len(eap)))
+	m.Append(l2)
+	m.Append(eapol)
+	m.Append(eap)
+	o.Tctx.Veth.Send(m)
+}
+
+// allocRadiusId allocates a free RADIUS Identifier to the Access-Request of a session.
+func (o *PluginDot1xAuthClient) allocRadiusId(s *Dot1xAuthSession) bool {
+	for i := 0; i < 256; i++ {
+		o.nextRadiusId++
+		if _, ok := o.pending[o.nextRadiusId]; !ok {
+			o.pending[o.nextRadiusId] = s
+			s.radiusId = o.nextRadiusId
+			return true
+		}
+	}
+	return false
+}
+
+// writeRadius sends a RADIUS packet to the server.
+func (o *PluginDot1xAuthClient) writeRadius(b []byte) {
+	if err, _ := o.socket.Write(b); err != transport.SeOK {
+		o.stats.radiusTxErr++
+	}
+}
+
+// removeSession removes the session of a supplicant.
+func (o *PluginDot1xAuthClient) removeSession(s *Dot1xAuthSession) {
+	s.close()
+	delete(o.sessions, s.mac)
+	o.stats.activeSessions--
+}
+
+// HandleRxEapolPacket handles an EAPOL frame of a supplicant.
+func (o *PluginDot1xAuthClient) HandleRxEapolPacket(ps *core.ParserPacketState) int {
+	p := ps.M.GetData()
+	l3 := int(ps.L3)
+	if len(p) < l3+EapolHeaderLen {
+		o.stats.pktRxInvalid++
+		return core.PARSER_ERR
+	}
+	var mac core.MACKey
+	copy(mac[:], p[6:12])
+	s := o.sessions[mac]
+
+	switch p[l3+1] {
+	case EapolTypeStart:
+		o.stats.pktRxStart++
+		if s == nil {
+			s = newDot1xAuthSession(o, mac)
+			o.sessions[mac] = s
+			o.stats.activeSessions++
+		}
+		s.start(p[l3])
+	case EapolTypeLogoff:
+		o.stats.pktRxLogoff++
+		if s != nil {
+			o.removeSession(s)
+		}
+	case EapolTypeEAP:
+		l := int(binary.BigEndian.Uint16(p[l3+2 : l3+4]))
+		eap := p[l3+EapolHeaderLen:]
+		if l < EapHeaderLen || l > len(eap) || int(binary.BigEndian.Uint16(eap[2:4])) > l {
+			o.stats.pktRxInvalid++
+			return core.PARSER_ERR
+		}
+		if s == nil {
+			o.stats.pktRxNoSession++
+			return core.PARSER_OK
+		}
+		// The frame is padded, and the mbuf is freed after the parser
+		eap = append([]byte{}, eap[:binary.BigEndian.Uint16(eap[2:4])]...)
+		s.handleEap(eap)
+	default:
+		o.stats.pktRxInvalid++
+		return core.PARSER_ERR
+	}
+	return core.PARSER_OK
+}
+
+// OnRxEvent function to complete the ISocketCb interface.
+func (o *PluginDot1xAuthClient) OnRxEvent(event transport.SocketEventType) {}
+
+// OnRxData is called with the packets of the RADIUS server.
+func (o *PluginDot1xAuthClient) OnRxData(d []byte) {
+	pkt, err := DecodeRadius(d)
+	if err != nil {
+		o.stats.radiusRxInvalid++
+		return
+	}
+	switch pkt.Code {
+	case RadiusAccessChallenge, RadiusAccessAccept, RadiusAccessReject:
+	default:
+		o.stats.radiusRxInvalid++
+		return
+	}
+	s, ok := o.pending[pkt.Identifier]
+	if !ok {
+		o.stats.radiusRxUnknownId++
+		return
+	}
+	if err = VerifyRadius(d, o.secret, s.radiusAuth); err != nil {
+		o.stats.radiusRxInvalidMa++
+		return
+	}
+	s.handleRadius(pkt)
+}
+
+// OnTxEvent function to complete the ISocketCb interface.
+func (o *PluginDot1xAuthClient) OnTxEvent(event transport.SocketEventType) {}
+
+// Dot1xAuthSessionInfo is the RPC information of a session.
+type Dot1xAuthSessionInfo struct {
+	Mac      string `json:"mac"`      // MAC of the supplicant
+	State    string `json:"state"`    // Session state
+	Identity string `json:"identity"` // Identity of the supplicant
+}
+
+// GetSessions returns the information of the sessions, sorted by MAC.
+func (o *PluginDot1xAuthClient) GetSessions() []Dot1xAuthSessionInfo {
+	res := make([]Dot1xAuthSessionInfo, 0, len(o.sessions))
+	for _, s := range o.sessions {
+		res = append(res, Dot1xAuthSessionInfo{
+			Mac:      s.mac.String(),
+			State:    sessionStateNames[s.state],
+			Identity: s.identity,
+		})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Mac < res[j].Mac })
+	return res
+}
+
+/*======================================================================================================
+										Plugin Dot1xAuth Ns
+======================================================================================================*/
+// PluginDot1xAuthNs represents the namespace layer for Dot1xAuth.
+type PluginDot1xAuthNs struct {
+	core.PluginBase
+	authenticators []*PluginDot1xAuthClient // Authenticators in the namespace, by creation order
+}
+
+// NewDot1xAuthNs creates a new Dot1xAuth namespace plugin
+func NewDot1xAuthNs(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
+	o := new(PluginDot1xAuthNs)
+	o.InitPluginBase(ctx, o)
+	o.RegisterEvents(ctx, []string{}, o)
+	return &o.PluginBase, nil
+}
+
+// OnRemove when removing Dot1xAuth namespace plugin.
+func (o *PluginDot1xAuthNs) OnRemove(ctx *core.PluginCtx) {}
+
+// OnEvent for events the namespace plugin is registered.
+func (o *PluginDot1xAuthNs) OnEvent(msg string, a, b interface{}) {}
+
+func (o *PluginDot1xAuthNs) addAuthenticator(auth *PluginDot1xAuthClient) {
+	if !auth.server {
+		o.authenticators = append(o.authenticators, auth)
+	}
+}
+
+func (o *PluginDot1xAuthNs) removeAuthenticator(auth *PluginDot1xAuthClient) {
+	for i := range o.authenticators {
+		if o.authenticators[i] == auth {
+			o.authenticators = append(o.authenticators[:i], o.authenticators[i+1:]...)
+			return
+		}
+	}
+}
+
+// HandleRxDot1xAuthPacket handles the frames of the authenticators, it returns false in case the frame is not for one.
+func (o *PluginDot1xAuthNs) HandleRxDot1xAuthPacket(ps *core.ParserPacketState) (int, bool) {
+
+	/*
+		Note: Only the frames of supplicants are handled, EAPOL-Start/Logoff and EAP-Response.
+		A frame to the PAE group address is passed to the first authenticator in the namespace.
+		A unicast is passed to the authenticator of the destination MAC.
+	*/
+
+	p := ps.M.GetData()
+	l3 := int(ps.L3)
+	if len(p) < l3+EapolHeaderLen {
+		return core.PARSER_OK, false
+	}
+	switch p[l3+1] {
+	case EapolTypeStart, EapolTypeLogoff:
+	case EapolTypeEAP:
+		if len(p) < l3+EapolHeaderLen+EapHeaderLen || p[l3+EapolHeaderLen] != EapCodeResponse {
+			return core.PARSER_OK, false
+		}
+	default:
+		return core.PARSER_OK, false
+	}
+
+	var mackey core.MACKey
+	copy(mackey[:], p[0:6])
+	if mackey == paeGroupMac {
+		if len(o.authenticators) == 0 {
+			return core.PARSER_OK, false
+		}
+		return o.authenticators[0].HandleRxEapolPacket(ps), true
+	}
+
+	client := o.Ns.CLookupByMac(&mackey)
+	if client == nil {
+		return core.PARSER_OK, false
+	}
+	cplg := client.PluginCtx.Get(DOT1X_AUTH_PLUG)
+	if cplg == nil {
+		return core.PARSER_OK, false
+	}
+	auth := cplg.Ext.(*PluginDot1xAuthClient)
+	if auth.server {
+		return core.PARSER_OK, false
+	}
+	return auth.HandleRxEapolPacket(ps), true
+}
+
+// HandleRxDot1xAuthPacket is called by the parser for the EAPOL frames, the frames that are not for an authenticator
+// are passed to the supplicant.
+func HandleRxDot1xAuthPacket(ps *core.ParserPacketState) int {
+	ns := ps.Tctx.GetNs(ps.Tun)
+	if ns == nil {
+		return core.PARSER_NEXT
+	}
+	nsplg := ns.PluginCtx.Get(DOT1X_AUTH_PLUG)
+	if nsplg == nil {
+		return core.PARSER_NEXT
+	}
+	if rc, ok := nsplg.Ext.(*PluginDot1xAuthNs).HandleRxDot1xAuthPacket(ps); ok {
+		return rc
+	}
+	return core.PARSER_NEXT
+}
+
+/*
+======================================================================================================
+
+	Generate Plugin
+
+======================================================================================================
+*/
+type PluginDot1xAuthCReg struct{}
+type PluginDot1xAuthNsReg struct{}
+
+// NewPlugin creates a new Dot1xAuth client plugin.
+func (o PluginDot1xAuthCReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
+	return NewDot1xAuthClient(ctx, initJson)
+}
+
+// NewPlugin creates a new Dot1xAuth namespace plugin.
+func (o PluginDot1xAuthNsReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
+	return NewDot1xAuthNs(ctx, initJson)
+}
+
+/*======================================================================================================
+											RPC Methods
+======================================================================================================*/
+
+type (
+	ApiDot1xAuthClientCntHandler      struct{} // Counter RPC Handler per Client
+	ApiDot1xAuthClientSessionsHandler struct{} // List the sessions
+)
+
+// getClientPlugin gets the client plugin given the client parameters (Mac & Tunnel Key)
+func getClientPlugin(ctx interface{}, params *fastjson.RawMessage) (*PluginDot1xAuthClient, error) {
+	tctx := ctx.(*core.CThreadCtx)
+
+	plug, err := tctx.GetClientPlugin(params, DOT1X_AUTH_PLUG)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pClient := plug.Ext.(*PluginDot1xAuthClient)
+
+	return pClient, nil
+}
+
+// ApiDot1xAuthClientCntHandler gets the counters of the Dot1xAuth client.
+func (h ApiDot1xAuthClientCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	c, err := getClientPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return c.cdbv.GeneralCounters(err, tctx, params, &p)
+}
+
+// ApiDot1xAuthClientSessionsHandler lists the sessions of the authenticator.
+func (h ApiDot1xAuthClientSessionsHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	c, err := getClientPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return c.GetSessions(), nil
+}
+
+func init() {
+
+	/* register of plugins callbacks for ns,c level  */
+	core.PluginRegister(DOT1X_AUTH_PLUG,
+		core.PluginRegisterData{Client: PluginDot1xAuthCReg{},
+			Ns:     PluginDot1xAuthNsReg{},
+			Thread: nil}) /* no need for thread context for now */
+
+	core.RegisterCB("dot1xauth_c_cnt", ApiDot1xAuthClientCntHandler{}, true)            // get counters / meta per client
+	core.RegisterCB("dot1xauth_c_sessions", ApiDot1xAuthClientSessionsHandler{}, false) // list the sessions
+
+	/* register parser, the authenticator shares the EAPOL frames of the supplicant and gets them before it */
+	core.ParserRegisterShared(DOT1X_AUTH_PLUG, 1, HandleRxDot1xAuthPacket,
+		core.ParserMatch{EthType: uint16(layers.EthernetTypeEAPOL)})
+}
+
+func Register(ctx *core.CThreadCtx) {
+	// In order for this plugin to be included in the EMU compilation one must provide this empty register
+	// function. In case you remove the function call, then the core will not include EMU.
+	ctx.RegisterParserCb(DOT1X_AUTH_PLUG)
+}
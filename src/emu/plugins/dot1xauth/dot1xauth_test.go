@@ -0,0 +1,360 @@
+/*
+Copyright (c) 2021 Cisco Systems and/or its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+that can be found in the LICENSE file in the root of the source
+tree.
+*/
+
+package dot1xauth
+
+import (
+	"bytes"
+	"emu/core"
+	"emu/plugins/dot1x"
+	"emu/plugins/transport"
+	"flag"
+	"os"
+	"testing"
+	"time"
+)
+
+var monitor int
+
+func TestRadiusEncode(t *testing.T) {
+	secret := []byte("secret")
+	eap := bytes.Repeat([]byte{1}, 600)
+
+	req := RadiusPacket{Code: RadiusAccessRequest, Identifier: 7}
+	copy(req.Authenticator[:], "0123456789abcdef")
+	req.Add(RadiusAttrUserName, []byte("test"))
+	req.AddEap(eap)
+	b, err := req.Encode(secret, req.Authenticator[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = VerifyRadius(b, secret, req.Authenticator[:]); err != nil {
+		t.Fatal(err)
+	}
+	if err = VerifyRadius(b, []byte("other"), req.Authenticator[:]); err == nil {
+		t.Fatal("Request with a wrong secret was verified")
+	}
+
+	// The EAP is split into 3 EAP-Message attributes
+	pkt, err := DecodeRadius(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkt.Attrs) != 5 || !bytes.Equal(pkt.Eap(), eap) || string(pkt.Get(RadiusAttrUserName)) != "test" {
+		t.Fatalf("Bad decoded request %+v", pkt)
+	}
+
+	resp := RadiusPacket{Code: RadiusAccessAccept, Identifier: 7}
+	resp.AddEap([]byte{EapCodeSuccess, 1, 0, 4})
+	b, err = resp.Encode(secret, req.Authenticator[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = VerifyRadius(b, secret, req.Authenticator[:]); err != nil {
+		t.Fatal(err)
+	}
+	if err = VerifyRadius(b, secret, make([]byte, 16)); err == nil {
+		t.Fatal("Response to another request was verified")
+	}
+}
+
+// Dot1xAuthTestBase represents the base parameters for a Dot1xAuth test.
+type Dot1xAuthTestBase struct {
+	testname     string
+	monitor      bool
+	capture      bool
+	duration     time.Duration
+	authJSON     []byte
+	srvJSON      []byte // nil for no server
+	suppJSON     []byte
+	authCounters Dot1xAuthStats
+	srvCounters  Dot1xAuthStats
+	sessions     []Dot1xAuthSessionInfo // Expected sessions of the authenticator
+}
+
+// VethDot1xAuthSim is a loopback veth, the supplicant, the authenticator and the server are in the same namespace.
+type VethDot1xAuthSim struct{}
+
+// ProcessTxToRx loops back each packet.
+func (o *VethDot1xAuthSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
+	return m
+}
+
+var (
+	authMac = core.MACKey{0, 0, 1, 0, 0, 1}
+	srvMac  = core.MACKey{0, 0, 1, 0, 0, 2}
+	suppMac = core.MACKey{0, 0, 1, 0, 0, 3}
+)
+
+// Run the test.
+func (o *Dot1xAuthTestBase) Run(t *testing.T) {
+	var simVeth VethDot1xAuthSim
+	var simrx core.VethIFSim = &simVeth
+
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	defer tctx.Delete()
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1})
+	ns := core.NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+
+	var srv *core.CClient
+	if o.srvJSON != nil {
+		srv = core.NewClient(ns, srvMac, core.Ipv4Key{10, 0, 0, 2}, core.Ipv6Key{}, core.Ipv4Key{10, 0, 0, 1})
+		srv.ForceDGW = true
+		srv.Ipv4ForcedgMac = authMac
+		ns.AddClient(srv)
+		if err := srv.PluginCtx.CreatePlugins([]string{transport.TRANS_PLUG, DOT1X_AUTH_PLUG}, [][]byte{nil, o.srvJSON}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	auth := core.NewClient(ns, authMac, core.Ipv4Key{10, 0, 0, 1}, core.Ipv6Key{}, core.Ipv4Key{10, 0, 0, 2})
+	auth.ForceDGW = true
+	auth.Ipv4ForcedgMac = srvMac
+	ns.AddClient(auth)
+	if err := auth.PluginCtx.CreatePlugins([]string{transport.TRANS_PLUG, DOT1X_AUTH_PLUG}, [][]byte{nil, o.authJSON}); err != nil {
+		t.Fatal(err)
+	}
+
+	supp := core.NewClient(ns, suppMac, core.Ipv4Key{}, core.Ipv6Key{}, core.Ipv4Key{})
+	ns.AddClient(supp)
+	if err := supp.PluginCtx.CreatePlugins([]string{dot1x.DOT1X_PLUG}, [][]byte{o.suppJSON}); err != nil {
+		t.Fatal(err)
+	}
+	Register(tctx)
+	dot1x.Register(tctx)
+	tctx.RegisterParserCb(transport.TRANS_PLUG)
+
+	m := false
+	if monitor > 0 {
+		m = true
+	}
+	tctx.Veth.SetDebug(m, os.Stdout, o.capture)
+	tctx.MainLoopSim(o.duration)
+
+	authPlug := auth.PluginCtx.Get(DOT1X_AUTH_PLUG).Ext.(*PluginDot1xAuthClient)
+	authPlug.cdbv.Dump()
+	if o.authCounters != authPlug.stats {
+		t.Fatalf("Bad authenticator counters, want %+v, have %+v.\n", o.authCounters, authPlug.stats)
+	}
+	if srv != nil {
+		srvPlug := srv.PluginCtx.Get(DOT1X_AUTH_PLUG).Ext.(*PluginDot1xAuthClient)
+		srvPlug.cdbv.Dump()
+		if o.srvCounters != srvPlug.stats {
+			t.Fatalf("Bad server counters, want %+v, have %+v.\n", o.srvCounters, srvPlug.stats)
+		}
+	}
+	sessions := authPlug.GetSessions()
+	if len(sessions) != len(o.sessions) {
+		t.Fatalf("Bad sessions, want %+v, have %+v.\n", o.sessions, sessions)
+	}
+	for i := range sessions {
+		if sessions[i] != o.sessions[i] {
+			t.Fatalf("Bad session %d, want %+v, have %+v.\n", i, o.sessions[i], sessions[i])
+		}
+	}
+}
+
+// TestDot1xAuth1 authenticates the supplicant by EAP-MSCHAPv2 with the server.
+func TestDot1xAuth1(t *testing.T) {
+	a := &Dot1xAuthTestBase{
+		testname: "dot1xauth1",
+		monitor:  false,
+		capture:  true,
+		duration: 30 * time.Second,
+		authJSON: []byte(`{"server": "10.0.0.2", "secret": "switch1"}`),
+		srvJSON:  []byte(`{"mode": "server", "secret": "switch1", "users": {"test1": "test1"}}`),
+		suppJSON: []byte(`{"user": "test1", "password": "test1"}`),
+		authCounters: Dot1xAuthStats{
+			activeSessions:    1,
+			pktRxStart:        1,
+			pktRxResp:         3,
+			pktRxRespId:       1,
+			pktTxReqId:        1,
+			pktTxReq:          2,
+			pktTxSuccess:      1,
+			authSuccess:       1,
+			radiusTxRequest:   3,
+			radiusRxChallenge: 2,
+			radiusRxAccept:    1,
+		},
+		srvCounters: Dot1xAuthStats{
+			srvRxRequest:   3,
+			srvTxChallenge: 2,
+			srvTxAccept:    1,
+		},
+		sessions: []Dot1xAuthSessionInfo{{Mac: suppMac.String(), State: "authenticated", Identity: "test1"}},
+	}
+	a.Run(t)
+}
+
+// TestDot1xAuth2 authenticates the supplicant by EAP-MD5.
+func TestDot1xAuth2(t *testing.T) {
+	a := &Dot1xAuthTestBase{
+		testname: "dot1xauth2",
+		monitor:  false,
+		capture:  true,
+		duration: 30 * time.Second,
+		authJSON: []byte(`{"server": "10.0.0.2:1812", "secret": "switch1"}`),
+		srvJSON:  []byte(`{"mode": "server", "secret": "switch1", "method": "md5", "users": {"test1": "test1"}}`),
+		suppJSON: []byte(`{"user": "test1", "password": "test1"}`),
+		authCounters: Dot1xAuthStats{
+			activeSessions:    1,
+			pktRxStart:        1,
+			pktRxResp:         2,
+			pktRxRespId:       1,
+			pktTxReqId:        1,
+			pktTxReq:          1,
+			pktTxSuccess:      1,
+			authSuccess:       1,
+			radiusTxRequest:   2,
+			radiusRxChallenge: 1,
+			radiusRxAccept:    1,
+		},
+		srvCounters: Dot1xAuthStats{
+			srvRxRequest:   2,
+			srvTxChallenge: 1,
+			srvTxAccept:    1,
+		},
+		sessions: []Dot1xAuthSessionInfo{{Mac: suppMac.String(), State: "authenticated", Identity: "test1"}},
+	}
+	a.Run(t)
+}
+
+// TestDot1xAuth3 moves to EAP-MSCHAPv2 by the Nak of a supplicant without EAP-MD5.
+func TestDot1xAuth3(t *testing.T) {
+	a := &Dot1xAuthTestBase{
+		testname: "dot1xauth3",
+		monitor:  false,
+		capture:  true,
+		duration: 30 * time.Second,
+		authJSON: []byte(`{"server": "10.0.0.2", "secret": "switch1"}`),
+		srvJSON:  []byte(`{"mode": "server", "secret": "switch1", "method": "md5", "users": {"test1": "test1"}}`),
+		suppJSON: []byte(`{"user": "test1", "password": "test1", "flags": 1}`),
+		authCounters: Dot1xAuthStats{
+			activeSessions:    1,
+			pktRxStart:        1,
+			pktRxResp:         4,
+			pktRxRespId:       1,
+			pktTxReqId:        1,
+			pktTxReq:          3,
+			pktTxSuccess:      1,
+			authSuccess:       1,
+			radiusTxRequest:   4,
+			radiusRxChallenge: 3,
+			radiusRxAccept:    1,
+		},
+		srvCounters: Dot1xAuthStats{
+			srvRxRequest:   4,
+			srvRxNak:       1,
+			srvTxChallenge: 3,
+			srvTxAccept:    1,
+		},
+		sessions: []Dot1xAuthSessionInfo{{Mac: suppMac.String(), State: "authenticated", Identity: "test1"}},
+	}
+	a.Run(t)
+}
+
+// TestDot1xAuth4 rejects a wrong password.
+func TestDot1xAuth4(t *testing.T) {
+	a := &Dot1xAuthTestBase{
+		testname: "dot1xauth4",
+		monitor:  false,
+		capture:  true,
+		duration: 5 * time.Second,
+		authJSON: []byte(`{"server": "10.0.0.2", "secret": "switch1"}`),
+		srvJSON:  []byte(`{"mode": "server", "secret": "switch1", "method": "md5", "users": {"test1": "other"}}`),
+		suppJSON: []byte(`{"user": "test1", "password": "test1"}`),
+		authCounters: Dot1xAuthStats{
+			activeSessions:    1,
+			pktRxStart:        1,
+			pktRxResp:         2,
+			pktRxRespId:       1,
+			pktTxReqId:        1,
+			pktTxReq:          1,
+			pktTxFailure:      1,
+			authFailure:       1,
+			radiusTxRequest:   2,
+			radiusRxChallenge: 1,
+			radiusRxReject:    1,
+		},
+		srvCounters: Dot1xAuthStats{
+			srvRxRequest:   2,
+			srvTxChallenge: 1,
+			srvTxReject:    1,
+		},
+		sessions: []Dot1xAuthSessionInfo{{Mac: suppMac.String(), State: "failed", Identity: "test1"}},
+	}
+	a.Run(t)
+}
+
+// TestDot1xAuth5 retransmits the Access-Request to a server with another secret, and fails.
+func TestDot1xAuth5(t *testing.T) {
+	a := &Dot1xAuthTestBase{
+		testname: "dot1xauth5",
+		monitor:  false,
+		capture:  true,
+		duration: 9 * time.Second,
+		authJSON: []byte(`{"server": "10.0.0.2", "secret": "switch1", "server_timeout": 2}`),
+		srvJSON:  []byte(`{"mode": "server", "secret": "other", "users": {"test1": "test1"}}`),
+		suppJSON: []byte(`{"user": "test1", "password": "test1"}`),
+		authCounters: Dot1xAuthStats{
+			activeSessions:   1,
+			pktRxStart:       1,
+			pktRxResp:        1,
+			pktRxRespId:      1,
+			pktTxReqId:       1,
+			pktTxFailure:     1,
+			authFailure:      1,
+			radiusTxRequest:  1,
+			radiusRetransmit: 3,
+			radiusTimeout:    1,
+		},
+		srvCounters: Dot1xAuthStats{
+			srvRxRequest:   4,
+			srvRxInvalidMa: 4,
+		},
+		sessions: []Dot1xAuthSessionInfo{{Mac: suppMac.String(), State: "failed", Identity: "test1"}},
+	}
+	a.Run(t)
+}
+
+// TestDot1xAuth6 rejects an unknown user.
+func TestDot1xAuth6(t *testing.T) {
+	a := &Dot1xAuthTestBase{
+		testname: "dot1xauth6",
+		monitor:  false,
+		capture:  true,
+		duration: 5 * time.Second,
+		authJSON: []byte(`{"server": "10.0.0.2", "secret": "switch1"}`),
+		srvJSON:  []byte(`{"mode": "server", "secret": "switch1", "users": {"test1": "test1"}}`),
+		suppJSON: []byte(`{"user": "test2", "password": "test2"}`),
+		authCounters: Dot1xAuthStats{
+			activeSessions:  1,
+			pktRxStart:      1,
+			pktRxResp:       1,
+			pktRxRespId:     1,
+			pktTxReqId:      1,
+			pktTxFailure:    1,
+			authFailure:     1,
+			radiusTxRequest: 1,
+			radiusRxReject:  1,
+		},
+		srvCounters: Dot1xAuthStats{
+			srvRxRequest:   1,
+			srvUnknownUser: 1,
+			srvTxReject:    1,
+		},
+		sessions: []Dot1xAuthSessionInfo{{Mac: suppMac.String(), State: "failed", Identity: "test2"}},
+	}
+	a.Run(t)
+}
+
+func init() {
+	flag.IntVar(&monitor, "monitor", 0, "monitor")
+}
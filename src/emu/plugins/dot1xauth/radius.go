@@ -0,0 +1,215 @@
+/*
+Copyright (c) 2021 Cisco Systems and/or its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+that can be found in the LICENSE file in the root of the source
+tree.
+*/
+
+package dot1xauth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+)
+
+/*
+RADIUS - https://datatracker.ietf.org/doc/html/rfc2865
+
+RADIUS Support For EAP - https://datatracker.ietf.org/doc/html/rfc3579
+
+Only the packets and the attributes of the EAP authentication are supported. Each encoded packet has a
+Message-Authenticator, the Response Authenticator of a response is computed after it.
+*/
+
+const (
+	RadiusAccessRequest   = 1
+	RadiusAccessAccept    = 2
+	RadiusAccessReject    = 3
+	RadiusAccessChallenge = 11
+
+	RadiusAttrUserName             = 1
+	RadiusAttrNasIpAddress         = 4
+	RadiusAttrFramedMtu            = 12
+	RadiusAttrState                = 24
+	RadiusAttrCalledStationId      = 30
+	RadiusAttrCallingStationId     = 31
+	RadiusAttrNasIdentifier        = 32
+	RadiusAttrNasPortType          = 61
+	RadiusAttrEapMessage           = 79
+	RadiusAttrMessageAuthenticator = 80
+
+	RadiusNasPortTypeEthernet = 15   // NAS-Port-Type of an 802.1X port, rfc3580
+	RadiusHeaderLen           = 20   // Code, Identifier, Length and Authenticator
+	RadiusMaxPacketLen        = 4096 // Maximal length of a RADIUS packet
+	RadiusMaxAttrValue        = 253  // Maximal length of an attribute value
+	radiusAuthenticatorLen    = 16
+)
+
+// RadiusAttr is an attribute of a RADIUS packet.
+type RadiusAttr struct {
+	Type  uint8
+	Value []byte
+}
+
+// RadiusPacket is a decoded RADIUS packet.
+type RadiusPacket struct {
+	Code          uint8
+	Identifier    uint8
+	Authenticator [radiusAuthenticatorLen]byte
+	Attrs         []RadiusAttr
+}
+
+// Add adds an attribute.
+func (o *RadiusPacket) Add(t uint8, v []byte) {
+	o.Attrs = append(o.Attrs, RadiusAttr{Type: t, Value: v})
+}
+
+// AddUint32 adds an attribute of an integer value.
+func (o *RadiusPacket) AddUint32(t uint8, v uint32) {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	o.Add(t, b)
+}
+
+// AddEap adds an EAP packet, split into EAP-Message attributes.
+func (o *RadiusPacket) AddEap(eap []byte) {
+	for len(eap) > RadiusMaxAttrValue {
+		o.Add(RadiusAttrEapMessage, eap[:RadiusMaxAttrValue])
+		eap = eap[RadiusMaxAttrValue:]
+	}
+	o.Add(RadiusAttrEapMessage, eap)
+}
+
+// Get returns the value of the first attribute of a type, nil in case there is no such attribute.
+func (o *RadiusPacket) Get(t uint8) []byte {
+	for i := range o.Attrs {
+		if o.Attrs[i].Type == t {
+			return o.Attrs[i].Value
+		}
+	}
+	return nil
+}
+
+// Eap returns the EAP packet of the EAP-Message attributes, nil in case there is no such attribute.
+func (o *RadiusPacket) Eap() []byte {
+	var eap []byte
+	for i := range o.Attrs {
+		if o.Attrs[i].Type == RadiusAttrEapMessage {
+			eap = append(eap, o.Attrs[i].Value...)
+		}
+	}
+	return eap
+}
+
+// Encode serializes the packet and signs it by secret. reqAuth is the Request Authenticator, for a request it
+// should be the Authenticator of the packet.
+func (o *RadiusPacket) Encode(secret []byte, reqAuth []byte) ([]byte, error) {
+	b := make([]byte, RadiusHeaderLen, RadiusMaxPacketLen)
+	b[0] = o.Code
+	b[1] = o.Identifier
+	copy(b[4:RadiusHeaderLen], reqAuth)
+	for _, attr := range o.Attrs {
+		if attr.Type == RadiusAttrMessageAuthenticator {
+			continue
+		}
+		if len(attr.Value) > RadiusMaxAttrValue {
+			return nil, fmt.Errorf("RADIUS attribute %d is too long %d", attr.Type, len(attr.Value))
+		}
+		b = append(b, attr.Type, uint8(len(attr.Value)+2))
+		b = append(b, attr.Value...)
+	}
+	b = append(b, RadiusAttrMessageAuthenticator, radiusAuthenticatorLen+2)
+	ma := len(b)
+	b = append(b, make([]byte, radiusAuthenticatorLen)...)
+	if len(b) > RadiusMaxPacketLen {
+		return nil, fmt.Errorf("RADIUS packet is too long %d", len(b))
+	}
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+
+	mac := hmac.New(md5.New, secret)
+	mac.Write(b)
+	copy(b[ma:], mac.Sum(nil))
+
+	if o.Code != RadiusAccessRequest {
+		// Response Authenticator, MD5(Code+Identifier+Length+RequestAuth+Attributes+Secret)
+		h := md5.New()
+		h.Write(b)
+		h.Write(secret)
+		copy(b[4:RadiusHeaderLen], h.Sum(nil))
+	}
+	return b, nil
+}
+
+// DecodeRadius decodes a RADIUS packet.
+func DecodeRadius(b []byte) (*RadiusPacket, error) {
+	if len(b) < RadiusHeaderLen {
+		return nil, fmt.Errorf("RADIUS packet is too short %d", len(b))
+	}
+	l := int(binary.BigEndian.Uint16(b[2:4]))
+	if l < RadiusHeaderLen || l > len(b) || l > RadiusMaxPacketLen {
+		return nil, fmt.Errorf("Invalid RADIUS length %d", l)
+	}
+	o := new(RadiusPacket)
+	o.Code = b[0]
+	o.Identifier = b[1]
+	copy(o.Authenticator[:], b[4:RadiusHeaderLen])
+	for p := b[RadiusHeaderLen:l]; len(p) > 0; {
+		if len(p) < 2 || p[1] < 2 || int(p[1]) > len(p) {
+			return nil, fmt.Errorf("Invalid RADIUS attribute")
+		}
+		o.Add(p[0], p[2:p[1]])
+		p = p[p[1]:]
+	}
+	return o, nil
+}
+
+// VerifyRadius verifies the Message-Authenticator of a packet, and the Response Authenticator in case of a
+// response. reqAuth is the Request Authenticator, for a request it should be the Authenticator of the packet.
+// A packet without Message-Authenticator is valid only if it has no EAP-Message.
+func VerifyRadius(b []byte, secret []byte, reqAuth []byte) error {
+	pkt, err := DecodeRadius(b)
+	if err != nil {
+		return err
+	}
+	b = append([]byte{}, b[:binary.BigEndian.Uint16(b[2:4])]...)
+
+	if pkt.Code != RadiusAccessRequest {
+		h := md5.New()
+		h.Write(b[:4])
+		h.Write(reqAuth)
+		h.Write(b[RadiusHeaderLen:])
+		h.Write(secret)
+		if !bytes.Equal(h.Sum(nil), b[4:RadiusHeaderLen]) {
+			return fmt.Errorf("Invalid RADIUS Response Authenticator")
+		}
+	}
+
+	ma := -1
+	for p := RadiusHeaderLen; p < len(b); p += int(b[p+1]) {
+		if b[p] == RadiusAttrMessageAuthenticator {
+			if b[p+1] != radiusAuthenticatorLen+2 {
+				return fmt.Errorf("Invalid RADIUS Message-Authenticator")
+			}
+			ma = p + 2
+			break
+		}
+	}
+	if ma < 0 {
+		if pkt.Get(RadiusAttrEapMessage) != nil {
+			return fmt.Errorf("RADIUS Message-Authenticator is missing")
+		}
+		return nil
+	}
+	value := append([]byte{}, b[ma:ma+radiusAuthenticatorLen]...)
+	copy(b[4:RadiusHeaderLen], reqAuth)
+	copy(b[ma:ma+radiusAuthenticatorLen], make([]byte, radiusAuthenticatorLen))
+	mac := hmac.New(md5.New, secret)
+	mac.Write(b)
+	if !hmac.Equal(mac.Sum(nil), value) {
+		return fmt.Errorf("Invalid RADIUS Message-Authenticator")
+	}
+	return nil
+}
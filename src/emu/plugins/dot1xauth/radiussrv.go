@@ -0,0 +1,280 @@
+/*
+Copyright (c) 2021 Cisco Systems and/or its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+that can be found in the LICENSE file in the root of the source
+tree.
+*/
+
+package dot1xauth
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"emu/core"
+	"emu/plugins/dot1x"
+	"emu/plugins/transport"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+/*
+The minimal RADIUS server of the server mode. Each EAP authentication is a conversation, identified by the State
+attribute of its Access-Challenge:
+
+	Access-Request(EAP-Response/Identity) -> Access-Challenge(EAP-Request/MD5-Challenge or MSCHAPv2 Challenge)
+	Access-Request(EAP-Response/MD5)      -> Access-Accept(EAP-Success) or Access-Reject(EAP-Failure)
+
+	Access-Request(EAP-Response/MSCHAPv2 Response) -> Access-Challenge(EAP-Request/MSCHAPv2 Success)
+	Access-Request(EAP-Response/MSCHAPv2 Success)  -> Access-Accept(EAP-Success)
+
+A Nak of the supplicant moves the conversation to the other method. The last response of each flow is kept, a
+retransmitted Access-Request is answered by it.
+*/
+
+const (
+	radiusServerName = "trex-emu" // Name of the server in the challenges
+)
+
+// radiusFlow is a flow of an authenticator.
+type radiusFlow struct {
+	srv      *PluginDot1xAuthClient // Server of the flow
+	socket   transport.SocketApi    // Socket of the flow
+	lastId   uint8                  // Identifier of the last Access-Request
+	lastAuth [16]byte               // Request Authenticator of the last Access-Request
+	lastResp []byte                 // Response to the last Access-Request
+}
+
+// OnRxEvent function to complete the ISocketCb interface.
+func (o *radiusFlow) OnRxEvent(event transport.SocketEventType) {}
+
+// OnRxData is called with the Access-Request of the authenticator.
+func (o *radiusFlow) OnRxData(d []byte) {
+	o.srv.handleAccessRequest(o, d)
+}
+
+// OnTxEvent function to complete the ISocketCb interface.
+func (o *radiusFlow) OnTxEvent(event transport.SocketEventType) {}
+
+// radiusConv is the EAP authentication of a user.
+type radiusConv struct {
+	srv          *PluginDot1xAuthClient // Server of the conversation
+	state        string                 // State attribute
+	user         string                 // Identity of the user
+	password     string                 // Password of the user
+	method       uint8                  // EAP method
+	eapId        uint8                  // Identifier of the last EAP-Request
+	challenge    []byte                 // Challenge of the method
+	authResponse string                 // MSCHAPv2 Authenticator Response, empty until the NT-Response is verified
+	timer        core.CHTimerObj        // Removes the conversation in case the authenticator gives up
+}
+
+// OnEvent removes a conversation without result.
+func (o *radiusConv) OnEvent(a, b interface{}) {
+	o.srv.stats.srvConvTimeout++
+	delete(o.srv.convs, o.state)
+}
+
+// close stops the conversation.
+func (o *radiusConv) close() {
+	if o.timer.IsRunning() {
+		o.srv.Tctx.GetTimerCtx().Stop(&o.timer)
+	}
+	delete(o.srv.convs, o.state)
+}
+
+// challengeEap builds the EAP-Request that starts the method.
+func (o *radiusConv) challengeEap() []byte {
+	o.eapId++
+	o.authResponse = ""
+	o.challenge = make([]byte, 16)
+	rand.Read(o.challenge)
+
+	var data []byte
+	if o.method == dot1x.EAP_TYPE_MD5 {
+		data = append([]byte{16}, o.challenge...)
+		data = append(data, radiusServerName...)
+	} else {
+		data = []byte{dot1x.MS_CHAPV2_CHALLENGE, o.eapId, 0, 0, 16}
+		data = append(data, o.challenge...)
+		data = append(data, radiusServerName...)
+		binary.BigEndian.PutUint16(data[2:4], uint16(len(data)))
+	}
+	return buildEap(EapCodeRequest, o.eapId, o.method, data)
+}
+
+// verifyMD5 verifies an EAP-MD5 response.
+func (o *radiusConv) verifyMD5(id uint8, data []byte) bool {
+	if len(data) < 17 || data[0] != 16 {
+		return false
+	}
+	b := append([]byte{id}, o.password...)
+	b = append(b, o.challenge...)
+	r := md5.Sum(b)
+	return bytes.Equal(r[:], data[1:17])
+}
+
+// verifyMSCHAPv2 verifies an MSCHAPv2 Response and returns the Success message, nil in case it is wrong.
+func (o *radiusConv) verifyMSCHAPv2(data []byte) []byte {
+	/*
+		OpCode, MS-CHAPv2-ID, MS-Length, Value-Size(49),
+		16 octets Peer-Challenge, 8 octets Reserved, 24 octets NT-Response, 1 octet Flags, Name
+	*/
+	if len(data) < 54 || data[0] != dot1x.MS_CHAPV2_RESPONSE || data[4] != 49 {
+		return nil
+	}
+	peerChallenge := data[5:21]
+	res, err := dot1x.Encryptv2(o.challenge, peerChallenge, string(data[54:]), o.password)
+	if err != nil || !bytes.Equal(res.ChallengeResponse, data[29:53]) {
+		return nil
+	}
+	o.authResponse = res.AuthenticatorResponse
+	msg := []byte(o.authResponse + " M=OK")
+	success := []byte{dot1x.MS_CHAPV2_SUCCESS, data[1], 0, 0}
+	success = append(success, msg...)
+	binary.BigEndian.PutUint16(success[2:4], uint16(len(success)))
+	return success
+}
+
+// buildEap builds an EAP packet of a type.
+func buildEap(code uint8, id uint8, eapType uint8, data []byte) []byte {
+	eap := []byte{code, id, 0, 0, eapType}
+	eap = append(eap, data...)
+	binary.BigEndian.PutUint16(eap[2:4], uint16(len(eap)))
+	return eap
+}
+
+// sendRadiusResponse answers an Access-Request on its flow.
+func (o *PluginDot1xAuthClient) sendRadiusResponse(flow *radiusFlow, req *RadiusPacket, code uint8, eap []byte, conv *radiusConv) {
+	pkt := RadiusPacket{Code: code, Identifier: req.Identifier}
+	switch code {
+	case RadiusAccessChallenge:
+		pkt.Add(RadiusAttrState, []byte(conv.state))
+		o.stats.srvTxChallenge++
+	case RadiusAccessAccept:
+		pkt.Add(RadiusAttrUserName, req.Get(RadiusAttrUserName))
+		o.stats.srvTxAccept++
+	case RadiusAccessReject:
+		o.stats.srvTxReject++
+	}
+	pkt.AddEap(eap)
+	b, err := pkt.Encode(o.secret, req.Authenticator[:])
+	if err != nil {
+		return
+	}
+	flow.lastId = req.Identifier
+	flow.lastAuth = req.Authenticator
+	flow.lastResp = b
+	flow.socket.Write(b)
+}
+
+// handleAccessRequest handles an Access-Request of an authenticator.
+func (o *PluginDot1xAuthClient) handleAccessRequest(flow *radiusFlow, d []byte) {
+	o.stats.srvRxRequest++
+	req, err := DecodeRadius(d)
+	if err != nil || req.Code != RadiusAccessRequest {
+		o.stats.srvRxInvalid++
+		return
+	}
+	if flow.lastResp != nil && req.Identifier == flow.lastId && req.Authenticator == flow.lastAuth {
+		o.stats.srvRxDuplicate++
+		flow.socket.Write(flow.lastResp)
+		return
+	}
+	if err = VerifyRadius(d, o.secret, req.Authenticator[:]); err != nil {
+		o.stats.srvRxInvalidMa++
+		return
+	}
+	eap := req.Eap()
+	if len(eap) <= EapHeaderLen || eap[0] != EapCodeResponse || int(binary.BigEndian.Uint16(eap[2:4])) > len(eap) {
+		o.stats.srvRxInvalid++
+		o.sendRadiusResponse(flow, req, RadiusAccessReject, []byte{EapCodeFailure, 0, 0, EapHeaderLen}, nil)
+		return
+	}
+	eap = eap[:binary.BigEndian.Uint16(eap[2:4])]
+	id, eapType, data := eap[1], eap[EapHeaderLen], eap[EapHeaderLen+1:]
+	reject := func() {
+		o.sendRadiusResponse(flow, req, RadiusAccessReject, []byte{EapCodeFailure, id, 0, EapHeaderLen}, nil)
+	}
+
+	state := req.Get(RadiusAttrState)
+	if state == nil {
+		// A new conversation
+		if eapType != EapTypeIdentity {
+			o.stats.srvUnknownState++
+			reject()
+			return
+		}
+		password, ok := o.params.Users[string(data)]
+		if !ok {
+			o.stats.srvUnknownUser++
+			reject()
+			return
+		}
+		o.nextConvId++
+		conv := &radiusConv{srv: o, state: fmt.Sprintf("%s-%d", radiusServerName, o.nextConvId), user: string(data),
+			password: password, method: o.method, eapId: id}
+		conv.timer.SetCB(conv, nil, nil)
+		o.convs[conv.state] = conv
+		o.Tctx.GetTimerCtx().Start(&conv.timer, DefaultConvTimeout*time.Second)
+		o.sendRadiusResponse(flow, req, RadiusAccessChallenge, conv.challengeEap(), conv)
+		return
+	}
+
+	conv, ok := o.convs[string(state)]
+	if !ok {
+		o.stats.srvUnknownState++
+		reject()
+		return
+	}
+	if id != conv.eapId {
+		o.stats.srvRxWrongId++
+		return
+	}
+	o.Tctx.GetTimerCtx().Stop(&conv.timer)
+	o.Tctx.GetTimerCtx().Start(&conv.timer, DefaultConvTimeout*time.Second)
+
+	switch {
+	case eapType == EapTypeNak:
+		o.stats.srvRxNak++
+		for _, t := range data {
+			if t != conv.method && (t == dot1x.EAP_TYPE_MD5 || t == dot1x.EAP_TYPE_MSCHAPV2) {
+				conv.method = t
+				o.sendRadiusResponse(flow, req, RadiusAccessChallenge, conv.challengeEap(), conv)
+				return
+			}
+		}
+	case eapType != conv.method:
+	case eapType == dot1x.EAP_TYPE_MD5:
+		if conv.verifyMD5(id, data) {
+			conv.close()
+			o.sendRadiusResponse(flow, req, RadiusAccessAccept, []byte{EapCodeSuccess, id, 0, EapHeaderLen}, nil)
+			return
+		}
+	case conv.authResponse != "":
+		// MSCHAPv2 Success was sent, this is the ack of the peer
+		if len(data) > 0 && data[0] == dot1x.MS_CHAPV2_SUCCESS {
+			conv.close()
+			o.sendRadiusResponse(flow, req, RadiusAccessAccept, []byte{EapCodeSuccess, id, 0, EapHeaderLen}, nil)
+			return
+		}
+	default:
+		if success := conv.verifyMSCHAPv2(data); success != nil {
+			conv.eapId++
+			o.sendRadiusResponse(flow, req, RadiusAccessChallenge,
+				buildEap(EapCodeRequest, conv.eapId, dot1x.EAP_TYPE_MSCHAPV2, success), conv)
+			return
+		}
+	}
+	conv.close()
+	reject()
+}
+
+// OnAccept is called when a new flow is received. This completed the IServerSocketCb interface.
+func (o *PluginDot1xAuthClient) OnAccept(socket transport.SocketApi) transport.ISocketCb {
+	if !o.server {
+		return nil
+	}
+	return &radiusFlow{srv: o, socket: socket}
+}
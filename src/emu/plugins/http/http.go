@@ -0,0 +1,473 @@
+// Copyright (c) 2021 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License")
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package http
+
+/*
+http plugin
+
+Each client can run a server with static routes and/or a client that sends requests to one server at a fixed rate.
+The latency of the responses, from the issue of the request to the end of the response, is kept in a histogram.
+
+{
+	"server": {"addr": ":80", "tls": false,
+			   "routes": [{"path": "/", "status": 200, "size": 1024, "content_type": "text/html", "chunked": false}]},
+	"client": {"addr": "48.0.0.1:80", "tls": false, "rate": 10, "limit": 0, "method": "GET", "path": "/",
+			   "body_size": 0, "conns": 1, "queue": 1000, "keepalive": true, "timeout": 5000}
+}
+*/
+
+import (
+	"emu/core"
+	"emu/plugins/transport"
+	"external/osamingo/jsonrpc"
+	"net"
+	"time"
+
+	"github.com/intel-go/fastjson"
+)
+
+const (
+	HTTP_PLUG = "http"
+)
+
+type HttpRouteInit struct {
+	Path        string `json:"path" validate:"required"`
+	Status      int    `json:"status"`       /* 200 in case it is zero */
+	Size        uint32 `json:"size"`         /* size of the body */
+	ContentType string `json:"content_type"` /* text/plain in case it is empty */
+	Chunked     bool   `json:"chunked"`      /* send the body in chunks */
+}
+
+type HttpServerInit struct {
+	Addr   string          `json:"addr"` /* :80 in case it is empty */
+	Tls    bool            `json:"tls"`
+	Routes []HttpRouteInit `json:"routes" validate:"dive"`
+}
+
+type HttpClientInit struct {
+	Addr      string  `json:"addr" validate:"required"`
+	Tls       bool    `json:"tls"`
+	Rate      float64 `json:"rate" validate:"gte=0"` /* requests per sec */
+	Limit     uint64  `json:"limit"`                 /* number of requests, zero for no limit */
+	Method    string  `json:"method"`                /* GET in case it is empty */
+	Path      string  `json:"path"`                  /* / in case it is empty */
+	Host      string  `json:"host"`                  /* Host header, the host of addr in case it is empty */
+	BodySize  uint32  `json:"body_size"`             /* size of the body of the requests */
+	Conns     int     `json:"conns"`                 /* parallel connections */
+	Queue     int     `json:"queue"`                 /* requests that wait for a connection */
+	KeepAlive *bool   `json:"keepalive"`             /* true in case it is not set */
+	Timeout   uint32  `json:"timeout"`               /* connect and response timeout in msec, zero for none */
+}
+
+type HttpInit struct {
+	Server *HttpServerInit `json:"server"`
+	Client *HttpClientInit `json:"client"`
+}
+
+type HttpStats struct {
+	http_start_err uint64 /* server or client failed to start */
+}
+
+func NewHttpStatsDb(o *HttpStats) *core.CCounterDb {
+	db := core.NewCCounterDb("http")
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.http_start_err,
+		Name:     "http_start_err",
+		Help:     "server or client failed to start",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	return db
+}
+
+// httpBody returns a body of printable characters
+func httpBody(size uint32) []byte {
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = 97 + byte(i%22)
+	}
+	return b
+}
+
+type PluginHttpClientTimer struct {
+}
+
+func (o *PluginHttpClientTimer) OnEvent(a, b interface{}) {
+	pi := a.(*PluginHttpClient)
+	pi.onTimerEvent()
+}
+
+// PluginHttpClient information per client
+type PluginHttpClient struct {
+	core.PluginBase
+	httpNsPlug        *PluginHttpNs
+	init              HttpInit
+	timerw            *core.TimerCtx
+	timer             core.CHTimerObj
+	timerCb           PluginHttpClientTimer
+	ticks             uint32  /* ticks between the bursts of requests */
+	burst             float64 /* requests per burst */
+	credit            float64 /* fraction of a request that was not sent */
+	sent              uint64
+	body              []byte
+	ipv6              bool /* the server of the client is ipv6 */
+	client            *HttpClient
+	server            *HttpServer
+	stats             HttpStats
+	cstats            HttpClientStats
+	sstats            HttpServerStats
+	hist              httpLatencyHist
+	cdbv              *core.CCounterDbVec
+	dgMacResolvedIpv4 bool
+	dgMacResolvedIpv6 bool
+}
+
+var httpEvents = []string{core.MSG_DG_MAC_RESOLVED}
+
+/*NewHttpPluginClient create plugin */
+func NewHttpPluginClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
+	o := new(PluginHttpClient)
+	if err := ctx.Tctx.UnmarshalValidate(initJson, &o.init); err != nil {
+		return nil, err
+	}
+	if c := o.init.Client; c != nil {
+		host, _, err := net.SplitHostPort(c.Addr)
+		if err != nil {
+			return nil, err
+		}
+		if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+			o.ipv6 = true
+		}
+	}
+	o.InitPluginBase(ctx, o)             /* init base object*/
+	o.RegisterEvents(ctx, httpEvents, o) /* register events, only if exits*/
+	nsplg := o.Ns.PluginCtx.GetOrCreate(HTTP_PLUG)
+	o.httpNsPlug = nsplg.Ext.(*PluginHttpNs)
+	o.OnCreate()
+	if o.Client.ForceDGW {
+		o.OnResolve(false)
+	}
+	if o.Client.Ipv6ForceDGW {
+		o.OnResolve(true)
+	}
+	return &o.PluginBase, nil
+}
+
+func (o *PluginHttpClient) OnCreate() {
+	o.timerw = o.Tctx.GetTimerCtx()
+	o.cdbv = core.NewCCounterDbVec(HTTP_PLUG)
+	o.cdbv.Add(NewHttpStatsDb(&o.stats))
+	o.cdbv.Add(NewHttpClientStatsDb(&o.cstats))
+	o.cdbv.Add(NewHttpServerStatsDb(&o.sstats))
+	o.timer.SetCB(&o.timerCb, o, nil)
+}
+
+func httpNetwork(tls bool) string {
+	if tls {
+		return "tls"
+	}
+	return "tcp"
+}
+
+func (o *PluginHttpClient) startServer() {
+	cfg := o.init.Server
+	addr := cfg.Addr
+	if addr == "" {
+		addr = ":80"
+	}
+	ctx := transport.GetTransportCtx(o.Client)
+	s, err := NewHttpServer(ctx, httpNetwork(cfg.Tls), addr, &o.sstats)
+	if err != nil {
+		o.stats.http_start_err++
+		return
+	}
+	routes := cfg.Routes
+	if len(routes) == 0 {
+		routes = []HttpRouteInit{{Path: "/"}}
+	}
+	for _, r := range routes {
+		status := r.Status
+		if status == 0 {
+			status = 200
+		}
+		contentType := r.ContentType
+		if contentType == "" {
+			contentType = "text/plain"
+		}
+		body := httpBody(r.Size)
+		chunked := r.Chunked
+		s.HandleFunc(r.Path, func(req *HttpRequest) *HttpResponse {
+			resp := NewHttpResponse(status, body)
+			resp.Header.Set("Server", "trex-emu")
+			resp.Header.Set("Content-Type", contentType)
+			if chunked {
+				resp.Header.Set("Transfer-Encoding", "chunked")
+			}
+			return resp
+		})
+	}
+	if err := s.Start(); err != nil {
+		o.stats.http_start_err++
+		return
+	}
+	o.server = s
+}
+
+func (o *PluginHttpClient) startClient() {
+	cfg := o.init.Client
+	ccfg := HttpClientCfg{
+		Network:   httpNetwork(cfg.Tls),
+		Addr:      cfg.Addr,
+		Host:      cfg.Host,
+		MaxConns:  cfg.Conns,
+		MaxQueue:  cfg.Queue,
+		KeepAlive: cfg.KeepAlive == nil || *cfg.KeepAlive,
+		Timeout:   time.Duration(cfg.Timeout) * time.Millisecond,
+	}
+	c, err := NewHttpClient(transport.GetTransportCtx(o.Client), &ccfg, o, &o.cstats)
+	if err != nil {
+		o.stats.http_start_err++
+		return
+	}
+	o.client = c
+	o.body = httpBody(cfg.BodySize)
+	if cfg.Rate == 0 {
+		return
+	}
+	/* a burst every interval, or every tick in case the interval is shorter */
+	interval := time.Duration(float64(time.Second) / cfg.Rate)
+	o.ticks = o.timerw.DurationToTicks(interval)
+	if o.ticks == 0 {
+		o.ticks = 1
+	}
+	o.burst = cfg.Rate * float64(o.ticks) * o.timerw.TickDuration.Seconds()
+	o.timerw.StartTicks(&o.timer, o.ticks)
+}
+
+func (o *PluginHttpClient) OnResolve(ipv6 bool) {
+	if ipv6 {
+		if o.dgMacResolvedIpv6 {
+			return
+		}
+		o.dgMacResolvedIpv6 = true
+	} else {
+		if o.dgMacResolvedIpv4 {
+			return
+		}
+		o.dgMacResolvedIpv4 = true
+	}
+	if o.init.Server != nil && o.server == nil {
+		o.startServer()
+	}
+	if o.init.Client != nil && o.client == nil && o.ipv6 == ipv6 {
+		o.startClient()
+	}
+}
+
+// issue sends one request, returns false in case the limit was reached
+func (o *PluginHttpClient) issue() bool {
+	cfg := o.init.Client
+	if cfg.Limit > 0 && o.sent >= cfg.Limit {
+		return false
+	}
+	method := cfg.Method
+	if method == "" {
+		method = "GET"
+	}
+	path := cfg.Path
+	if path == "" {
+		path = "/"
+	}
+	var body []byte
+	if len(o.body) > 0 {
+		body = o.body
+	}
+	req := NewHttpRequest(method, path, body)
+	req.Header.Set("User-Agent", "trex-emu")
+	o.sent++
+	o.client.Do(req)
+	return true
+}
+
+// onTimerEvent sends a burst of requests
+func (o *PluginHttpClient) onTimerEvent() {
+	o.credit += o.burst
+	for o.credit >= 1 {
+		o.credit--
+		if !o.issue() {
+			return
+		}
+	}
+	o.timerw.StartTicks(&o.timer, o.ticks)
+}
+
+func (o *PluginHttpClient) OnResponse(req *HttpRequest, resp *HttpResponse, err error) {
+	if err != nil {
+		return
+	}
+	o.hist.add(resp.Latency)
+}
+
+/*OnEvent support of messages */
+func (o *PluginHttpClient) OnEvent(msg string, a, b interface{}) {
+	switch msg {
+	case core.MSG_DG_MAC_RESOLVED:
+		bitMask, ok := a.(uint8)
+		if !ok {
+			// failed at type assertion
+			return
+		}
+		resolvedIPv4 := (bitMask & core.RESOLVED_IPV4_DG_MAC) == core.RESOLVED_IPV4_DG_MAC
+		resolvedIPv6 := (bitMask & core.RESOLVED_IPV6_DG_MAC) == core.RESOLVED_IPV6_DG_MAC
+		if resolvedIPv4 {
+			o.OnResolve(false)
+		}
+		if resolvedIPv6 {
+			o.OnResolve(true)
+		}
+	}
+}
+
+func (o *PluginHttpClient) OnRemove(ctx *core.PluginCtx) {
+	ctx.UnregisterEvents(&o.PluginBase, httpEvents)
+	if o.timer.IsRunning() {
+		o.timerw.Stop(&o.timer)
+	}
+	if o.client != nil {
+		o.client.Close()
+	}
+	if o.server != nil {
+		o.server.Close()
+	}
+}
+
+// PluginHttpNs information per namespace
+type PluginHttpNs struct {
+	core.PluginBase
+}
+
+func NewHttpPluginNs(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
+	o := new(PluginHttpNs)
+	o.InitPluginBase(ctx, o)
+	o.RegisterEvents(ctx, []string{}, o)
+	return &o.PluginBase, nil
+}
+
+func (o *PluginHttpNs) OnRemove(ctx *core.PluginCtx) {
+}
+
+func (o *PluginHttpNs) OnEvent(msg string, a, b interface{}) {
+}
+
+func (o *PluginHttpNs) SetTruncated() {
+}
+
+type PluginHttpCReg struct{}
+type PluginHttpNsReg struct{}
+
+func (o PluginHttpCReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
+	return NewHttpPluginClient(ctx, initJson)
+}
+
+func (o PluginHttpNsReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
+	return NewHttpPluginNs(ctx, initJson)
+}
+
+/*******************************************/
+/*  RPC commands */
+type (
+	ApiHttpClientCntHandler  struct{}
+	ApiHttpClientHistHandler struct{}
+	ApiHttpClientHistParams  struct {
+		Clear bool `json:"clear"` /* clear the histogram after it was read */
+	}
+)
+
+func getClientPlugin(ctx interface{}, params *fastjson.RawMessage) (*PluginHttpClient, error) {
+	tctx := ctx.(*core.CThreadCtx)
+
+	plug, err := tctx.GetClientPlugin(params, HTTP_PLUG)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pClient := plug.Ext.(*PluginHttpClient)
+
+	return pClient, nil
+}
+
+func (h ApiHttpClientCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	c, err := getClientPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return c.cdbv.GeneralCounters(err, tctx, params, &p)
+}
+
+func (h ApiHttpClientHistHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	var p ApiHttpClientHistParams
+	tctx := ctx.(*core.CThreadCtx)
+	c, err := getClientPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	err = tctx.UnmarshalValidate(*params, &p)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	res := c.hist.get()
+	if p.Clear {
+		c.hist.clear()
+	}
+	return res, nil
+}
+
+func init() {
+
+	/* register of plugins callbacks for ns,c level  */
+	core.PluginRegister(HTTP_PLUG,
+		core.PluginRegisterData{Client: PluginHttpCReg{},
+			Ns:     PluginHttpNsReg{},
+			Thread: nil}) /* no need for thread context for now */
+
+	/* The format of the RPC commands xxx_yy_zz_aa
+
+	  xxx - the plugin name
+
+	  yy  - ns - namespace
+			c  - client
+			t   -thread
+
+	  zz  - cmd  command like ping etc
+			set  set configuration
+			get  get configuration/counters
+
+	  aa - misc
+	*/
+
+	core.RegisterCB("http_c_cnt", ApiHttpClientCntHandler{}, false)   // get counters/meta
+	core.RegisterCB("http_c_hist", ApiHttpClientHistHandler{}, false) // get the latency histogram
+}
+
+func Register(ctx *core.CThreadCtx) {
+	//pass
+}
@@ -0,0 +1,477 @@
+// Copyright (c) 2021 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License")
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package http
+
+/*
+HTTP/1.1 client
+
+The client keeps a pool of up to MaxConns connections to one server, each connection serves one request at a time
+(no pipelining). Requests are queued until a connection is idle and the response, or the error, is returned by
+IHttpClientCb. Connections are reused unless KeepAlive is false or the server asks to close them. A request that
+was sent on a reused connection that the server closed before any response byte is resent once.
+*/
+
+import (
+	"emu/core"
+	"emu/plugins/transport"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+var (
+	errHttpConnClosed   = errors.New("http connection was closed before the response")
+	errHttpTimeout      = errors.New("http connect or response timeout")
+	errHttpQueueFull    = errors.New("http request queue is full")
+	errHttpClientClosed = errors.New("http client is closed")
+	errHttpUnexpected   = errors.New("http response without a request")
+)
+
+var httpStart = time.Now()
+
+// httpNow returns the time in sec, the simulation time in case of simulation
+func httpNow(tctx *core.CThreadCtx) float64 {
+	if tctx.Simulation {
+		return tctx.GetTickSimInSec()
+	}
+	return time.Since(httpStart).Seconds()
+}
+
+// httpWriter keeps the buffers that were written while the socket queue is full
+type httpWriter struct {
+	s     transport.SocketApi
+	queue [][]byte
+	drain bool
+}
+
+func (o *httpWriter) write(b []byte) transport.SocketErr {
+	if o.drain {
+		o.queue = append(o.queue, b)
+		return transport.SeOK
+	}
+	err, queued := o.s.Write(b)
+	if err != transport.SeOK {
+		return err
+	}
+	o.drain = !queued
+	return transport.SeOK
+}
+
+// onTxMore writes the queued buffers
+func (o *httpWriter) onTxMore() transport.SocketErr {
+	o.drain = false
+	for len(o.queue) > 0 && !o.drain {
+		b := o.queue[0]
+		o.queue[0] = nil
+		o.queue = o.queue[1:]
+		if err := o.write(b); err != transport.SeOK {
+			return err
+		}
+	}
+	return transport.SeOK
+}
+
+func (o *httpWriter) empty() bool {
+	return !o.drain && len(o.queue) == 0
+}
+
+type IHttpClientCb interface {
+	// OnResponse is called once for each request that was accepted by Do, resp is nil in case of an error
+	OnResponse(req *HttpRequest, resp *HttpResponse, err error)
+}
+
+type HttpClientCfg struct {
+	Network   string             /* tcp or tls */
+	Addr      string             /* host:port of the server */
+	Host      string             /* Host header of the requests, the host of Addr in case it is empty */
+	MaxConns  int                /* max parallel connections, one in case it is zero */
+	MaxQueue  int                /* max requests that wait for a connection, 1000 in case it is zero */
+	KeepAlive bool               /* reuse the connections */
+	Timeout   time.Duration      /* connect and response timeout, zero for none */
+	Ioctl     transport.IoctlMap /* ioctl of the sockets */
+}
+
+type HttpClient struct {
+	ctx    *transport.TransportCtx
+	timerw *core.TimerCtx
+	cfg    HttpClientCfg
+	cb     IHttpClientCb
+	stats  *HttpClientStats
+	conns  []*httpClientConn
+	queue  []*HttpRequest
+	closed bool
+}
+
+// NewHttpClient creates a client of one server, stats can be nil
+func NewHttpClient(ctx *transport.TransportCtx, cfg *HttpClientCfg, cb IHttpClientCb, stats *HttpClientStats) (*HttpClient, error) {
+	if cfg.Network != "tcp" && cfg.Network != "tls" {
+		return nil, fmt.Errorf("unsupported http network %v", cfg.Network)
+	}
+	host, _, err := net.SplitHostPort(cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	o := new(HttpClient)
+	o.ctx = ctx
+	o.timerw = ctx.Tctx.GetTimerCtx()
+	o.cfg = *cfg
+	o.cb = cb
+	o.stats = stats
+	if o.stats == nil {
+		o.stats = new(HttpClientStats)
+	}
+	if o.cfg.Host == "" {
+		o.cfg.Host = host
+		if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+			o.cfg.Host = "[" + host + "]"
+		}
+	}
+	if o.cfg.MaxConns <= 0 {
+		o.cfg.MaxConns = 1
+	}
+	if o.cfg.MaxQueue <= 0 {
+		o.cfg.MaxQueue = 1000
+	}
+	return o, nil
+}
+
+// Do queues the request, the callback is called with the response
+func (o *HttpClient) Do(req *HttpRequest) error {
+	o.stats.httpc_req++
+	if o.closed {
+		return errHttpClientClosed
+	}
+	if len(o.queue) >= o.cfg.MaxQueue {
+		o.stats.httpc_queue_full++
+		return errHttpQueueFull
+	}
+	if req.Proto == "" {
+		req.Proto = HTTP_PROTO
+	}
+	if req.Header == nil {
+		req.Header = make(HttpHeader)
+	}
+	if req.Header.Get("Host") == "" {
+		req.Header.Set("Host", o.cfg.Host)
+	}
+	if !o.cfg.KeepAlive {
+		req.Header.Set("Connection", "close")
+	}
+	req.issued = httpNow(o.ctx.Tctx)
+	o.queue = append(o.queue, req)
+	o.dispatch()
+	return nil
+}
+
+// Close closes the connections, the requests that did not get a response fail
+func (o *HttpClient) Close() {
+	if o.closed {
+		return
+	}
+	o.closed = true
+	for _, c := range o.conns {
+		c.terminate(errHttpClientClosed, c.req != nil)
+	}
+	o.failQueue(errHttpClientClosed)
+}
+
+// GetStats returns the counters of the client
+func (o *HttpClient) GetStats() *HttpClientStats {
+	return o.stats
+}
+
+// Pending returns the number of requests that did not get a response
+func (o *HttpClient) Pending() int {
+	n := len(o.queue)
+	for _, c := range o.conns {
+		if c.req != nil {
+			n++
+		}
+	}
+	return n
+}
+
+func (o *HttpClient) failQueue(err error) {
+	q := o.queue
+	o.queue = nil
+	for _, req := range q {
+		o.stats.httpc_req_err++
+		o.cb.OnResponse(req, nil, err)
+	}
+}
+
+func (o *HttpClient) pop() *HttpRequest {
+	req := o.queue[0]
+	o.queue[0] = nil
+	o.queue = o.queue[1:]
+	return req
+}
+
+// dispatch sends the queued requests on the idle connections and dials new connections if needed
+func (o *HttpClient) dispatch() {
+	if o.closed {
+		return
+	}
+	connecting := 0
+	for _, c := range o.conns {
+		if len(o.queue) == 0 {
+			return
+		}
+		if c.detached {
+			continue
+		}
+		if !c.connected {
+			connecting++
+			continue
+		}
+		if c.req == nil {
+			c.send(o.pop())
+		}
+	}
+	for len(o.queue) > connecting && len(o.conns) < o.cfg.MaxConns && !o.closed {
+		if err := o.dial(); err != nil {
+			if len(o.conns) == 0 {
+				o.failQueue(err)
+			}
+			return
+		}
+		connecting++
+	}
+}
+
+func (o *HttpClient) dial() error {
+	c := new(httpClientConn)
+	c.client = o
+	c.timer.SetCB(c, nil, nil)
+	s, err := o.ctx.Dial(o.cfg.Network, o.cfg.Addr, c, o.cfg.Ioctl, nil, 0)
+	if err != nil {
+		o.stats.httpc_conn_err++
+		return err
+	}
+	o.stats.httpc_conn++
+	c.s = s
+	c.w.s = s
+	o.conns = append(o.conns, c)
+	if o.cfg.Timeout > 0 {
+		o.timerw.Start(&c.timer, o.cfg.Timeout)
+	}
+	return nil
+}
+
+// connected returns true in case one of the connections is connected
+func (o *HttpClient) connected() bool {
+	for _, c := range o.conns {
+		if c.connected {
+			return true
+		}
+	}
+	return false
+}
+
+// remove removes the connection from the pool, the slice is copied as dispatch could iterate it
+func (o *HttpClient) remove(c *httpClientConn) {
+	conns := make([]*httpClientConn, 0, len(o.conns))
+	for _, v := range o.conns {
+		if v != c {
+			conns = append(conns, v)
+		}
+	}
+	o.conns = conns
+}
+
+// httpClientConn is one connection of the client
+type httpClientConn struct {
+	client    *HttpClient
+	s         transport.SocketApi
+	w         httpWriter
+	parser    httpParser
+	timer     core.CHTimerObj
+	req       *HttpRequest /* the request that waits for a response */
+	served    uint32       /* requests that were sent on the connection */
+	rxdata    bool         /* part of the response was received */
+	connected bool
+	sclosed   bool /* the socket is closed */
+	detached  bool /* removed from the client, the events are ignored */
+}
+
+func (o *httpClientConn) send(req *HttpRequest) {
+	sts := o.client.stats
+	o.req = req
+	o.rxdata = false
+	o.parser.method = req.Method
+	if o.served > 0 {
+		sts.httpc_req_reuse++
+	}
+	o.served++
+	b := req.encode()
+	sts.httpc_req_tx++
+	sts.httpc_txbyte += uint64(len(b))
+	if err := o.w.write(b); err != transport.SeOK {
+		o.terminate(err.Error(), true)
+		return
+	}
+	if o.client.cfg.Timeout > 0 {
+		o.client.timerw.Start(&o.timer, o.client.cfg.Timeout)
+	}
+}
+
+// detach removes the connection from the client and closes the socket, abort resets the connection
+func (o *httpClientConn) detach(abort bool) {
+	o.detached = true
+	if o.timer.IsRunning() {
+		o.client.timerw.Stop(&o.timer)
+	}
+	o.client.remove(o)
+	if o.sclosed {
+		return
+	}
+	o.sclosed = true
+	if abort {
+		o.s.Shutdown()
+	} else {
+		o.s.Close()
+	}
+}
+
+// terminate closes the connection and fails or resends the request
+func (o *httpClientConn) terminate(err error, abort bool) {
+	if o.detached {
+		return
+	}
+	o.detach(abort)
+	if err != errHttpClientClosed && err != errHttpConnClosed {
+		o.client.stats.httpc_conn_err++
+	}
+	if !o.connected && err != errHttpClientClosed && !o.client.connected() {
+		/* the server can't be reached, fail the requests instead of dialing again */
+		o.client.failQueue(err)
+	}
+	if req := o.req; req != nil {
+		o.req = nil
+		if err == errHttpConnClosed && !o.rxdata && o.served > 1 && !req.retried {
+			/* the server closed an idle connection while the request was sent */
+			req.retried = true
+			o.client.stats.httpc_req_retry++
+			o.client.queue = append([]*HttpRequest{req}, o.client.queue...)
+		} else {
+			o.client.stats.httpc_req_err++
+			o.client.cb.OnResponse(req, nil, err)
+		}
+	}
+	o.client.dispatch()
+}
+
+// onClose handles the end of the connection, a response that is delimited by the end of the connection is completed
+func (o *httpClientConn) onClose() {
+	if o.detached {
+		return
+	}
+	if m := o.parser.eof(); m != nil {
+		if !o.onMessage(m, false) {
+			return
+		}
+	}
+	err := errHttpConnClosed
+	if o.sclosed {
+		if e := o.s.GetLastError(); e != transport.SeOK {
+			err = e.Error()
+		}
+	}
+	o.terminate(err, false)
+}
+
+func (o *httpClientConn) onMessage(m *httpMessage, chunked bool) bool {
+	sts := o.client.stats
+	if o.req == nil {
+		sts.httpc_parse_err++
+		o.terminate(errHttpUnexpected, true)
+		return false
+	}
+	if m.code < 200 {
+		/* interim response */
+		return true
+	}
+	req := o.req
+	o.req = nil
+	if o.timer.IsRunning() {
+		o.client.timerw.Stop(&o.timer)
+	}
+	resp := m.response()
+	resp.Latency = httpNow(o.client.ctx.Tctx) - req.issued
+	sts.httpc_resp++
+	switch resp.StatusCode / 100 {
+	case 2:
+		sts.httpc_resp_2xx++
+	case 3:
+		sts.httpc_resp_3xx++
+	case 4:
+		sts.httpc_resp_4xx++
+	case 5:
+		sts.httpc_resp_5xx++
+	}
+	if chunked {
+		sts.httpc_resp_chunked++
+	}
+	if !o.client.cfg.KeepAlive || !keepAlive(resp.Proto, resp.Header) {
+		o.detach(false)
+	}
+	o.client.cb.OnResponse(req, resp, nil)
+	o.client.dispatch()
+	return !o.detached
+}
+
+func (o *httpClientConn) OnRxEvent(event transport.SocketEventType) {
+	if o.detached {
+		return
+	}
+	if (event & transport.SocketEventConnected) > 0 {
+		o.connected = true
+		if o.timer.IsRunning() {
+			o.client.timerw.Stop(&o.timer)
+		}
+		o.client.dispatch()
+	}
+	if (event & transport.SocketClosed) > 0 {
+		o.sclosed = true
+		o.onClose()
+		return
+	}
+	if (event & transport.SocketRemoteDisconnect) > 0 {
+		o.onClose()
+	}
+}
+
+func (o *httpClientConn) OnRxData(d []byte) {
+	if o.detached {
+		return
+	}
+	o.client.stats.httpc_rxbyte += uint64(len(d))
+	o.rxdata = true
+	if err := o.parser.input(d, o.onMessage); err != nil {
+		o.client.stats.httpc_parse_err++
+		o.terminate(err, true)
+	}
+}
+
+func (o *httpClientConn) OnTxEvent(event transport.SocketEventType) {
+	if o.detached {
+		return
+	}
+	if (event & transport.SocketTxMore) > 0 {
+		if err := o.w.onTxMore(); err != transport.SeOK {
+			o.terminate(err.Error(), true)
+		}
+	}
+}
+
+// OnEvent is the connect or the response timeout
+func (o *httpClientConn) OnEvent(a, b interface{}) {
+	if o.req != nil {
+		o.client.stats.httpc_req_timeout++
+	}
+	o.terminate(errHttpTimeout, true)
+}
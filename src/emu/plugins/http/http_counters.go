@@ -0,0 +1,260 @@
+// Copyright (c) 2021 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License")
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package http
+
+import "emu/core"
+
+type HttpClientStats struct {
+	httpc_req          uint64 /* requests issued */
+	httpc_req_tx       uint64 /* requests sent */
+	httpc_req_reuse    uint64 /* requests sent on a reused connection */
+	httpc_req_retry    uint64 /* requests resent after the server closed an idle connection */
+	httpc_req_err      uint64 /* requests that failed without a response */
+	httpc_req_timeout  uint64 /* requests without a response in time */
+	httpc_queue_full   uint64 /* requests dropped, the queue is full */
+	httpc_resp         uint64 /* responses */
+	httpc_resp_2xx     uint64 /* 2xx responses */
+	httpc_resp_3xx     uint64 /* 3xx responses */
+	httpc_resp_4xx     uint64 /* 4xx responses */
+	httpc_resp_5xx     uint64 /* 5xx responses */
+	httpc_resp_chunked uint64 /* chunked responses */
+	httpc_parse_err    uint64 /* malformed responses */
+	httpc_conn         uint64 /* connections */
+	httpc_conn_err     uint64 /* connections that failed or were closed with an error */
+	httpc_txbyte       uint64 /* request bytes sent */
+	httpc_rxbyte       uint64 /* response bytes received */
+}
+
+func NewHttpClientStatsDb(o *HttpClientStats) *core.CCounterDb {
+	db := core.NewCCounterDb("httpc")
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.httpc_req,
+		Name:     "httpc_req",
+		Help:     "requests issued",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.httpc_req_tx,
+		Name:     "httpc_req_tx",
+		Help:     "requests sent",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.httpc_req_reuse,
+		Name:     "httpc_req_reuse",
+		Help:     "requests sent on a reused connection",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.httpc_req_retry,
+		Name:     "httpc_req_retry",
+		Help:     "requests resent after the server closed an idle connection",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.httpc_req_err,
+		Name:     "httpc_req_err",
+		Help:     "requests that failed without a response",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.httpc_req_timeout,
+		Name:     "httpc_req_timeout",
+		Help:     "requests without a response in time",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.httpc_queue_full,
+		Name:     "httpc_queue_full",
+		Help:     "requests dropped, the queue is full",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.httpc_resp,
+		Name:     "httpc_resp",
+		Help:     "responses",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.httpc_resp_2xx,
+		Name:     "httpc_resp_2xx",
+		Help:     "2xx responses",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.httpc_resp_3xx,
+		Name:     "httpc_resp_3xx",
+		Help:     "3xx responses",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.httpc_resp_4xx,
+		Name:     "httpc_resp_4xx",
+		Help:     "4xx responses",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.httpc_resp_5xx,
+		Name:     "httpc_resp_5xx",
+		Help:     "5xx responses",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.httpc_resp_chunked,
+		Name:     "httpc_resp_chunked",
+		Help:     "chunked responses",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.httpc_parse_err,
+		Name:     "httpc_parse_err",
+		Help:     "malformed responses",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.httpc_conn,
+		Name:     "httpc_conn",
+		Help:     "connections",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.httpc_conn_err,
+		Name:     "httpc_conn_err",
+		Help:     "connections that failed or were closed with an error",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.httpc_txbyte,
+		Name:     "httpc_txbyte",
+		Help:     "request bytes sent",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.httpc_rxbyte,
+		Name:     "httpc_rxbyte",
+		Help:     "response bytes received",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	return db
+}
+
+type HttpServerStats struct {
+	httpsrv_accept      uint64 /* connections */
+	httpsrv_req         uint64 /* requests */
+	httpsrv_req_chunked uint64 /* chunked requests */
+	httpsrv_resp        uint64 /* responses */
+	httpsrv_not_found   uint64 /* requests without a route */
+	httpsrv_bad_req     uint64 /* malformed requests */
+	httpsrv_txbyte      uint64 /* response bytes sent */
+	httpsrv_rxbyte      uint64 /* request bytes received */
+}
+
+func NewHttpServerStatsDb(o *HttpServerStats) *core.CCounterDb {
+	db := core.NewCCounterDb("httpsrv")
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.httpsrv_accept,
+		Name:     "httpsrv_accept",
+		Help:     "connections",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.httpsrv_req,
+		Name:     "httpsrv_req",
+		Help:     "requests",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.httpsrv_req_chunked,
+		Name:     "httpsrv_req_chunked",
+		Help:     "chunked requests",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.httpsrv_resp,
+		Name:     "httpsrv_resp",
+		Help:     "responses",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.httpsrv_not_found,
+		Name:     "httpsrv_not_found",
+		Help:     "requests without a route",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.httpsrv_bad_req,
+		Name:     "httpsrv_bad_req",
+		Help:     "malformed requests",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.httpsrv_txbyte,
+		Name:     "httpsrv_txbyte",
+		Help:     "response bytes sent",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.httpsrv_rxbyte,
+		Name:     "httpsrv_rxbyte",
+		Help:     "request bytes received",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	return db
+}
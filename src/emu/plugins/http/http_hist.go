@@ -0,0 +1,84 @@
+// Copyright (c) 2021 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License")
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package http
+
+/*
+Latency histogram
+
+The buckets are 1-2-5 per decade from 100 usec to 10 sec, the last bucket counts the latencies above 10 sec.
+*/
+
+var httpHistBuckets = []uint64{
+	100, 200, 500,
+	1000, 2000, 5000,
+	10000, 20000, 50000,
+	100000, 200000, 500000,
+	1000000, 2000000, 5000000,
+	10000000}
+
+type HttpHistBucket struct {
+	Usec uint64 `json:"usec"` /* upper bound of the bucket, zero for the last bucket */
+	Cnt  uint64 `json:"cnt"`
+}
+
+type HttpLatencyHist struct {
+	Cnt     uint64           `json:"cnt"`
+	MinUsec uint64           `json:"min_usec"`
+	MaxUsec uint64           `json:"max_usec"`
+	AvgUsec float64          `json:"avg_usec"`
+	Hist    []HttpHistBucket `json:"hist"` /* buckets that are not empty */
+}
+
+type httpLatencyHist struct {
+	buckets [17]uint64 /* len(httpHistBuckets) + 1 */
+	cnt     uint64
+	sum     uint64
+	min     uint64
+	max     uint64
+}
+
+// add adds a latency in sec
+func (o *httpLatencyHist) add(sec float64) {
+	if sec < 0 {
+		sec = 0
+	}
+	usec := uint64(sec*1e6 + 0.5)
+	i := 0
+	for i < len(httpHistBuckets) && usec > httpHistBuckets[i] {
+		i++
+	}
+	o.buckets[i]++
+	if o.cnt == 0 || usec < o.min {
+		o.min = usec
+	}
+	if usec > o.max {
+		o.max = usec
+	}
+	o.cnt++
+	o.sum += usec
+}
+
+func (o *httpLatencyHist) clear() {
+	*o = httpLatencyHist{}
+}
+
+func (o *httpLatencyHist) get() *HttpLatencyHist {
+	r := &HttpLatencyHist{Cnt: o.cnt, MinUsec: o.min, MaxUsec: o.max, Hist: []HttpHistBucket{}}
+	if o.cnt > 0 {
+		r.AvgUsec = float64(o.sum) / float64(o.cnt)
+	}
+	for i, c := range o.buckets {
+		if c == 0 {
+			continue
+		}
+		var usec uint64
+		if i < len(httpHistBuckets) {
+			usec = httpHistBuckets[i]
+		}
+		r.Hist = append(r.Hist, HttpHistBucket{Usec: usec, Cnt: c})
+	}
+	return r
+}
@@ -0,0 +1,479 @@
+// Copyright (c) 2021 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License")
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package http
+
+/*
+HTTP/1.1 messages
+
+The parser is fed with the byte stream of the socket and returns a message once the start line, the headers
+and the body were received. The body is delimited by Content-Length, by the chunked transfer coding or, for
+responses only, by the end of the connection (RFC 7230 section 3.3.3). Pipelined messages are supported.
+*/
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	HTTP_PROTO       = "HTTP/1.1"
+	HTTP_MAX_LINE    = 8 * 1024         /* max size of the start line, a header line or a chunk size line */
+	HTTP_MAX_HEADERS = 128              /* max number of header lines */
+	HTTP_MAX_BODY    = 16 * 1024 * 1024 /* max size of a body */
+	HTTP_CHUNK_SIZE  = 4 * 1024         /* chunk size of a chunked body */
+
+	/* parser states */
+	hpSTART      = 0
+	hpHEADER     = 1
+	hpBODY       = 2 /* Content-Length body */
+	hpBODY_EOF   = 3 /* body until the end of the connection */
+	hpCHUNK_SIZE = 4
+	hpCHUNK_DATA = 5
+	hpCHUNK_CRLF = 6
+	hpTRAILER    = 7
+)
+
+var (
+	errHttpLineTooLong  = errors.New("http line is too long")
+	errHttpBodyTooLarge = errors.New("http body is too large")
+	errHttpStartLine    = errors.New("malformed http start line")
+	errHttpHeader       = errors.New("malformed http header")
+	errHttpChunk        = errors.New("malformed http chunk")
+	errHttpLength       = errors.New("malformed http content length")
+)
+
+var httpStatusText = map[int]string{
+	100: "Continue",
+	200: "OK",
+	201: "Created",
+	202: "Accepted",
+	204: "No Content",
+	206: "Partial Content",
+	301: "Moved Permanently",
+	302: "Found",
+	304: "Not Modified",
+	400: "Bad Request",
+	401: "Unauthorized",
+	403: "Forbidden",
+	404: "Not Found",
+	405: "Method Not Allowed",
+	408: "Request Timeout",
+	413: "Payload Too Large",
+	500: "Internal Server Error",
+	501: "Not Implemented",
+	502: "Bad Gateway",
+	503: "Service Unavailable",
+	504: "Gateway Timeout",
+}
+
+// HttpStatusText returns the reason phrase of a status code, empty in case it is not known
+func HttpStatusText(code int) string {
+	return httpStatusText[code]
+}
+
+// HttpHeader maps the canonical header name to its values, like net/http Header
+type HttpHeader map[string][]string
+
+func (h HttpHeader) Add(key, value string) {
+	k := textproto.CanonicalMIMEHeaderKey(key)
+	h[k] = append(h[k], value)
+}
+
+func (h HttpHeader) Set(key, value string) {
+	h[textproto.CanonicalMIMEHeaderKey(key)] = []string{value}
+}
+
+// Get returns the first value of the key, empty in case it does not exist
+func (h HttpHeader) Get(key string) string {
+	v := h[textproto.CanonicalMIMEHeaderKey(key)]
+	if len(v) == 0 {
+		return ""
+	}
+	return v[0]
+}
+
+func (h HttpHeader) Del(key string) {
+	delete(h, textproto.CanonicalMIMEHeaderKey(key))
+}
+
+// hasToken returns true in case one of the comma separated values of the key is token, case insensitive
+func (h HttpHeader) hasToken(key, token string) bool {
+	for _, v := range h[textproto.CanonicalMIMEHeaderKey(key)] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var httpHeaderValueReplacer = strings.NewReplacer("\r", " ", "\n", " ")
+
+// write writes the header lines sorted by name
+func (h HttpHeader) write(b *bytes.Buffer) {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range h[k] {
+			b.WriteString(k)
+			b.WriteString(": ")
+			b.WriteString(httpHeaderValueReplacer.Replace(v))
+			b.WriteString("\r\n")
+		}
+	}
+}
+
+// HttpRequest is a request of the client or a request that was received by the server
+type HttpRequest struct {
+	Method string
+	Path   string /* the request target e.g. /index.html?a=1 */
+	Proto  string
+	Header HttpHeader
+	Body   []byte
+
+	issued  float64 /* time the request was issued, in sec */
+	retried bool
+}
+
+// HttpResponse is a response that was received by the client or a response of a server handler
+type HttpResponse struct {
+	Proto      string
+	StatusCode int
+	Status     string /* the reason phrase */
+	Header     HttpHeader
+	Body       []byte
+	Latency    float64 /* time from the issue of the request to the end of the response, in sec */
+}
+
+func NewHttpRequest(method, path string, body []byte) *HttpRequest {
+	return &HttpRequest{Method: method, Path: path, Proto: HTTP_PROTO, Header: make(HttpHeader), Body: body}
+}
+
+func NewHttpResponse(code int, body []byte) *HttpResponse {
+	return &HttpResponse{Proto: HTTP_PROTO, StatusCode: code, Status: HttpStatusText(code), Header: make(HttpHeader), Body: body}
+}
+
+// keepAlive returns true in case the connection can be reused after the message, RFC 7230 section 6.3
+func keepAlive(proto string, h HttpHeader) bool {
+	if h.hasToken("Connection", "close") {
+		return false
+	}
+	if proto == "HTTP/1.0" {
+		return h.hasToken("Connection", "keep-alive")
+	}
+	return true
+}
+
+// responseHasBody returns false for responses that never have a body
+func responseHasBody(method string, code int) bool {
+	if method == "HEAD" || (code >= 100 && code < 200) || code == 204 || code == 304 {
+		return false
+	}
+	return true
+}
+
+// writeBody writes the framing headers, the end of the headers and the body. The body is chunked in case
+// Transfer-Encoding is chunked, otherwise Content-Length is set unless length is false.
+func writeBody(b *bytes.Buffer, h HttpHeader, body []byte, length bool, hasBody bool) {
+	chunked := h.hasToken("Transfer-Encoding", "chunked")
+	if chunked {
+		h.Del("Content-Length")
+	} else if length {
+		h.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	h.write(b)
+	b.WriteString("\r\n")
+	if !hasBody {
+		return
+	}
+	if !chunked {
+		b.Write(body)
+		return
+	}
+	for len(body) > 0 {
+		n := len(body)
+		if n > HTTP_CHUNK_SIZE {
+			n = HTTP_CHUNK_SIZE
+		}
+		fmt.Fprintf(b, "%x\r\n", n)
+		b.Write(body[:n])
+		b.WriteString("\r\n")
+		body = body[n:]
+	}
+	b.WriteString("0\r\n\r\n")
+}
+
+func (o *HttpRequest) encode() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s %s\r\n", o.Method, o.Path, o.Proto)
+	/* a request without a body does not need a length, RFC 7230 section 3.3.2 */
+	writeBody(&b, o.Header, o.Body, len(o.Body) > 0 || o.Method == "POST" || o.Method == "PUT", true)
+	return b.Bytes()
+}
+
+func (o *HttpResponse) encode(method string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %03d %s\r\n", o.Proto, o.StatusCode, o.Status)
+	hasBody := responseHasBody("", o.StatusCode)
+	writeBody(&b, o.Header, o.Body, hasBody, hasBody && method != "HEAD")
+	return b.Bytes()
+}
+
+type httpMessage struct {
+	start  [3]string /* method, target, proto of a request or proto, code, reason of a response */
+	code   int
+	header HttpHeader
+	body   []byte
+}
+
+func (o *httpMessage) request() *HttpRequest {
+	return &HttpRequest{Method: o.start[0], Path: o.start[1], Proto: o.start[2], Header: o.header, Body: o.body}
+}
+
+func (o *httpMessage) response() *HttpResponse {
+	return &HttpResponse{Proto: o.start[0], StatusCode: o.code, Status: o.start[2], Header: o.header, Body: o.body}
+}
+
+type httpParser struct {
+	request bool   /* parse requests, otherwise responses */
+	method  string /* method of the request of the next response, a response to HEAD has no body */
+	state   uint8
+	line    []byte /* partial line */
+	remain  int64  /* bytes left in the body or the chunk */
+	headers int
+	chunked bool
+	msg     *httpMessage
+}
+
+// idle returns true in case no part of a message was received
+func (o *httpParser) idle() bool {
+	return o.state == hpSTART && len(o.line) == 0
+}
+
+// readLine returns a line without the CRLF and the rest of the data, ok is false in case the line is partial
+func (o *httpParser) readLine(d []byte) (line []byte, rest []byte, ok bool, err error) {
+	i := bytes.IndexByte(d, '\n')
+	if i < 0 {
+		if len(o.line)+len(d) > HTTP_MAX_LINE {
+			return nil, nil, false, errHttpLineTooLong
+		}
+		o.line = append(o.line, d...)
+		return nil, nil, false, nil
+	}
+	if len(o.line)+i > HTTP_MAX_LINE {
+		return nil, nil, false, errHttpLineTooLong
+	}
+	if len(o.line) > 0 {
+		line = append(o.line, d[:i]...)
+		o.line = nil
+	} else {
+		line = d[:i]
+	}
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	return line, d[i+1:], true, nil
+}
+
+func (o *httpParser) parseStartLine(line []byte) error {
+	f := strings.SplitN(string(line), " ", 3)
+	if o.request {
+		if len(f) != 3 || f[0] == "" || f[1] == "" || !strings.HasPrefix(f[2], "HTTP/") {
+			return errHttpStartLine
+		}
+	} else {
+		if len(f) < 2 || !strings.HasPrefix(f[0], "HTTP/") || len(f[1]) != 3 {
+			return errHttpStartLine
+		}
+		code, err := strconv.Atoi(f[1])
+		if err != nil || code < 100 {
+			return errHttpStartLine
+		}
+		o.msg.code = code
+	}
+	copy(o.msg.start[:], f)
+	return nil
+}
+
+func (o *httpParser) parseHeaderLine(line []byte) error {
+	o.headers++
+	if o.headers > HTTP_MAX_HEADERS {
+		return errHttpHeader
+	}
+	i := bytes.IndexByte(line, ':')
+	/* no obsolete line folding and no white space before the colon, RFC 7230 section 3.2.4 */
+	if i <= 0 || line[0] == ' ' || line[0] == '\t' || bytes.ContainsAny(line[:i], " \t") {
+		return errHttpHeader
+	}
+	o.msg.header.Add(string(line[:i]), strings.Trim(string(line[i+1:]), " \t"))
+	return nil
+}
+
+// endOfHeaders selects the framing of the body, returns true in case the message has no body
+func (o *httpParser) endOfHeaders() (bool, error) {
+	h := o.msg.header
+	if !o.request && !responseHasBody(o.method, o.msg.code) {
+		return true, nil
+	}
+	if te := h.Get("Transfer-Encoding"); te != "" {
+		if !h.hasToken("Transfer-Encoding", "chunked") {
+			return false, errHttpHeader
+		}
+		o.chunked = true
+		o.state = hpCHUNK_SIZE
+		return false, nil
+	}
+	if cl := h["Content-Length"]; len(cl) > 0 {
+		n, err := strconv.ParseInt(cl[0], 10, 64)
+		if err != nil || n < 0 {
+			return false, errHttpLength
+		}
+		for _, v := range cl[1:] {
+			if v != cl[0] {
+				return false, errHttpLength
+			}
+		}
+		if n > HTTP_MAX_BODY {
+			return false, errHttpBodyTooLarge
+		}
+		if n == 0 {
+			return true, nil
+		}
+		o.remain = n
+		o.msg.body = make([]byte, 0, n)
+		o.state = hpBODY
+		return false, nil
+	}
+	if o.request {
+		return true, nil
+	}
+	o.state = hpBODY_EOF
+	return false, nil
+}
+
+func (o *httpParser) reset() {
+	o.state = hpSTART
+	o.remain = 0
+	o.headers = 0
+	o.chunked = false
+	o.msg = nil
+}
+
+// input consumes the data and calls cb for each complete message, parsing stops in case cb returns false.
+// The parser must not be used after an error.
+func (o *httpParser) input(d []byte, cb func(m *httpMessage, chunked bool) bool) error {
+	var line []byte
+	var ok bool
+	var err error
+	for len(d) > 0 {
+		done := false
+		switch o.state {
+		case hpSTART, hpHEADER, hpCHUNK_SIZE, hpCHUNK_CRLF, hpTRAILER:
+			line, d, ok, err = o.readLine(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return nil
+			}
+			switch o.state {
+			case hpSTART:
+				if len(line) == 0 {
+					/* ignore empty lines before the start line, RFC 7230 section 3.5 */
+					continue
+				}
+				o.msg = &httpMessage{header: make(HttpHeader)}
+				if err = o.parseStartLine(line); err != nil {
+					return err
+				}
+				o.state = hpHEADER
+			case hpHEADER:
+				if len(line) > 0 {
+					if err = o.parseHeaderLine(line); err != nil {
+						return err
+					}
+					continue
+				}
+				if done, err = o.endOfHeaders(); err != nil {
+					return err
+				}
+			case hpCHUNK_SIZE:
+				if i := bytes.IndexByte(line, ';'); i >= 0 {
+					line = line[:i] /* chunk extensions are ignored */
+				}
+				n, err := strconv.ParseUint(strings.TrimSpace(string(line)), 16, 63)
+				if err != nil {
+					return errHttpChunk
+				}
+				if int64(n)+int64(len(o.msg.body)) > HTTP_MAX_BODY {
+					return errHttpBodyTooLarge
+				}
+				if n == 0 {
+					o.state = hpTRAILER
+				} else {
+					o.remain = int64(n)
+					o.state = hpCHUNK_DATA
+				}
+			case hpCHUNK_CRLF:
+				if len(line) != 0 {
+					return errHttpChunk
+				}
+				o.state = hpCHUNK_SIZE
+			case hpTRAILER:
+				/* the trailer fields are ignored */
+				if len(line) == 0 {
+					done = true
+				}
+			}
+		case hpBODY, hpCHUNK_DATA:
+			n := int64(len(d))
+			if n > o.remain {
+				n = o.remain
+			}
+			o.msg.body = append(o.msg.body, d[:n]...)
+			d = d[n:]
+			o.remain -= n
+			if o.remain == 0 {
+				if o.state == hpBODY {
+					done = true
+				} else {
+					o.state = hpCHUNK_CRLF
+				}
+			}
+		case hpBODY_EOF:
+			if len(o.msg.body)+len(d) > HTTP_MAX_BODY {
+				return errHttpBodyTooLarge
+			}
+			o.msg.body = append(o.msg.body, d...)
+			d = nil
+		}
+		if done {
+			m, chunked := o.msg, o.chunked
+			o.reset()
+			if !cb(m, chunked) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// eof completes a body that is delimited by the end of the connection, returns nil in case there is no such message
+func (o *httpParser) eof() *httpMessage {
+	if o.state != hpBODY_EOF {
+		return nil
+	}
+	m := o.msg
+	o.reset()
+	return m
+}
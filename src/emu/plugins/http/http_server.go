@@ -0,0 +1,230 @@
+// Copyright (c) 2021 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License")
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package http
+
+/*
+HTTP/1.1 server
+
+The server listens on a port of the client and calls the handler of the route of each request. A route that ends
+with / matches all the paths under it, the longest route wins. The handlers are synchronous, the responses are sent
+in the order of the requests. Connections are kept open unless the client asks to close them.
+*/
+
+import (
+	"emu/plugins/transport"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// HttpHandlerFunc returns the response of a request
+type HttpHandlerFunc func(req *HttpRequest) *HttpResponse
+
+type HttpServer struct {
+	ctx       *transport.TransportCtx
+	network   string
+	addr      string
+	routes    map[string]HttpHandlerFunc
+	prefixes  []string /* routes that end with /, the longest first */
+	conns     map[*httpServerConn]bool
+	stats     *HttpServerStats
+	listening bool
+}
+
+// NewHttpServer creates a server on addr e.g. :80, stats can be nil
+func NewHttpServer(ctx *transport.TransportCtx, network, addr string, stats *HttpServerStats) (*HttpServer, error) {
+	if network != "tcp" && network != "tls" {
+		return nil, fmt.Errorf("unsupported http network %v", network)
+	}
+	o := new(HttpServer)
+	o.ctx = ctx
+	o.network = network
+	o.addr = addr
+	o.routes = make(map[string]HttpHandlerFunc)
+	o.conns = make(map[*httpServerConn]bool)
+	o.stats = stats
+	if o.stats == nil {
+		o.stats = new(HttpServerStats)
+	}
+	return o, nil
+}
+
+// HandleFunc registers the handler of a route
+func (o *HttpServer) HandleFunc(path string, h HttpHandlerFunc) {
+	if _, ok := o.routes[path]; !ok && strings.HasSuffix(path, "/") {
+		o.prefixes = append(o.prefixes, path)
+		sort.Slice(o.prefixes, func(i, j int) bool { return len(o.prefixes[i]) > len(o.prefixes[j]) })
+	}
+	o.routes[path] = h
+}
+
+// Start listens for connections
+func (o *HttpServer) Start() error {
+	if o.listening {
+		return nil
+	}
+	if err := o.ctx.Listen(o.network, o.addr, o); err != nil {
+		return err
+	}
+	o.listening = true
+	return nil
+}
+
+// Close stops listening and resets the connections
+func (o *HttpServer) Close() {
+	if o.listening {
+		o.ctx.UnListen(o.network, o.addr, o)
+		o.listening = false
+	}
+	for c := range o.conns {
+		c.detach(true)
+	}
+}
+
+// GetStats returns the counters of the server
+func (o *HttpServer) GetStats() *HttpServerStats {
+	return o.stats
+}
+
+func (o *HttpServer) lookup(path string) HttpHandlerFunc {
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path = path[:i]
+	}
+	if h, ok := o.routes[path]; ok {
+		return h
+	}
+	for _, p := range o.prefixes {
+		if strings.HasPrefix(path, p) {
+			return o.routes[p]
+		}
+	}
+	return nil
+}
+
+func (o *HttpServer) OnAccept(socket transport.SocketApi) transport.ISocketCb {
+	o.stats.httpsrv_accept++
+	c := new(httpServerConn)
+	c.server = o
+	c.s = socket
+	c.w.s = socket
+	c.parser.request = true
+	o.conns[c] = true
+	return c
+}
+
+// httpServerConn is one connection of the server
+type httpServerConn struct {
+	server   *HttpServer
+	s        transport.SocketApi
+	w        httpWriter
+	parser   httpParser
+	closing  bool /* close after the responses were sent */
+	detached bool
+}
+
+// detach removes the connection from the server and closes the socket, abort resets the connection
+func (o *httpServerConn) detach(abort bool) {
+	if o.detached {
+		return
+	}
+	o.detached = true
+	delete(o.server.conns, o)
+	if abort {
+		o.s.Shutdown()
+	} else {
+		o.s.Close()
+	}
+}
+
+func (o *httpServerConn) respond(method string, resp *HttpResponse) {
+	sts := o.server.stats
+	if resp.Proto == "" {
+		resp.Proto = HTTP_PROTO
+	}
+	if resp.Header == nil {
+		resp.Header = make(HttpHeader)
+	}
+	if o.closing {
+		resp.Header.Set("Connection", "close")
+	}
+	b := resp.encode(method)
+	sts.httpsrv_resp++
+	sts.httpsrv_txbyte += uint64(len(b))
+	if err := o.w.write(b); err != transport.SeOK {
+		o.detach(true)
+		return
+	}
+	if o.closing && o.w.empty() {
+		o.detach(false)
+	}
+}
+
+func (o *httpServerConn) onMessage(m *httpMessage, chunked bool) bool {
+	sts := o.server.stats
+	req := m.request()
+	sts.httpsrv_req++
+	if chunked {
+		sts.httpsrv_req_chunked++
+	}
+	if !keepAlive(req.Proto, req.Header) {
+		o.closing = true
+	}
+	var resp *HttpResponse
+	if h := o.server.lookup(req.Path); h != nil {
+		resp = h(req)
+	}
+	if resp == nil {
+		sts.httpsrv_not_found++
+		resp = NewHttpResponse(404, nil)
+	}
+	o.respond(req.Method, resp)
+	return !o.detached && !o.closing
+}
+
+func (o *httpServerConn) OnRxEvent(event transport.SocketEventType) {
+	if o.detached {
+		return
+	}
+	if (event & transport.SocketClosed) > 0 {
+		o.detached = true
+		delete(o.server.conns, o)
+		return
+	}
+	if (event & transport.SocketRemoteDisconnect) > 0 {
+		/* the client half closed, close once the responses were sent */
+		o.closing = true
+		if o.w.empty() {
+			o.detach(false)
+		}
+	}
+}
+
+func (o *httpServerConn) OnRxData(d []byte) {
+	if o.detached || o.closing {
+		return
+	}
+	o.server.stats.httpsrv_rxbyte += uint64(len(d))
+	if err := o.parser.input(d, o.onMessage); err != nil {
+		o.server.stats.httpsrv_bad_req++
+		o.closing = true
+		o.respond("", NewHttpResponse(400, nil))
+	}
+}
+
+func (o *httpServerConn) OnTxEvent(event transport.SocketEventType) {
+	if o.detached {
+		return
+	}
+	if (event & transport.SocketTxMore) > 0 {
+		if err := o.w.onTxMore(); err != transport.SeOK {
+			o.detach(true)
+			return
+		}
+		if o.closing && o.w.empty() {
+			o.detach(false)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+// Copyright (c) 2021 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License")
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package http
+
+import (
+	"emu/core"
+	"emu/plugins/transport"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// parse feeds the data one byte at a time and returns the messages
+func parse(t *testing.T, p *httpParser, data string) []*httpMessage {
+	var res []*httpMessage
+	for i := 0; i < len(data); i++ {
+		err := p.input([]byte(data[i:i+1]), func(m *httpMessage, chunked bool) bool {
+			res = append(res, m)
+			return true
+		})
+		if err != nil {
+			t.Fatalf(" parse error %v at %d", err, i)
+		}
+	}
+	return res
+}
+
+func TestHttpParser1(t *testing.T) {
+	var p httpParser
+	data := "HTTP/1.1 200 OK\r\nContent-Length: 5\r\nX-A: a\r\nx-a:  b \r\n\r\nhello" +
+		"HTTP/1.1 404 Not Found\r\nTransfer-Encoding: chunked\r\n\r\n3;ext=1\r\nabc\r\n2\r\nde\r\n0\r\nTrailer: x\r\n\r\n" +
+		"HTTP/1.1 204 No Content\r\n\r\n" +
+		"HTTP/1.0 200 OK\r\nServer: x\r\n\r\nuntil the end"
+	m := parse(t, &p, data)
+	if len(m) != 3 {
+		t.Fatalf(" expected 3 messages, got %d", len(m))
+	}
+	r := m[0].response()
+	if r.StatusCode != 200 || r.Status != "OK" || string(r.Body) != "hello" || len(r.Header["X-A"]) != 2 || r.Header["X-A"][1] != "b" {
+		t.Fatalf(" bad response %+v", r)
+	}
+	r = m[1].response()
+	if r.StatusCode != 404 || string(r.Body) != "abcde" {
+		t.Fatalf(" bad chunked response %+v", r)
+	}
+	r = m[2].response()
+	if r.StatusCode != 204 || len(r.Body) != 0 {
+		t.Fatalf(" bad response %+v", r)
+	}
+	e := p.eof()
+	if e == nil || string(e.body) != "until the end" || !p.idle() {
+		t.Fatalf(" bad response at the end of the connection %+v", e)
+	}
+
+	/* a response to HEAD has no body */
+	p.method = "HEAD"
+	m = parse(t, &p, "HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\n")
+	if len(m) != 1 || len(m[0].body) != 0 || !p.idle() {
+		t.Fatalf(" bad response to HEAD")
+	}
+}
+
+func TestHttpParser2(t *testing.T) {
+	p := httpParser{request: true}
+	req := NewHttpRequest("POST", "/a?b=1", []byte("body"))
+	req.Header.Set("Host", "h")
+	req.Header.Set("Transfer-Encoding", "chunked")
+	data := "\r\nGET / HTTP/1.1\r\nHost: h\r\n\r\n" + string(req.encode())
+	m := parse(t, &p, data)
+	if len(m) != 2 {
+		t.Fatalf(" expected 2 messages, got %d", len(m))
+	}
+	r := m[0].request()
+	if r.Method != "GET" || r.Path != "/" || r.Proto != HTTP_PROTO || len(r.Body) != 0 {
+		t.Fatalf(" bad request %+v", r)
+	}
+	r = m[1].request()
+	if r.Method != "POST" || r.Path != "/a?b=1" || string(r.Body) != "body" || r.Header.Get("host") != "h" {
+		t.Fatalf(" bad request %+v", r)
+	}
+
+	bad := []string{
+		"GET /\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost : h\r\n\r\n",
+		"GET / HTTP/1.1\r\nA: b\r\n c\r\n\r\n",
+		"POST / HTTP/1.1\r\nContent-Length: x\r\n\r\n",
+		"POST / HTTP/1.1\r\nContent-Length: 1\r\nContent-Length: 2\r\n\r\n",
+		"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\nz\r\n",
+		"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n1\r\nab\r\n",
+		"POST / HTTP/1.1\r\nTransfer-Encoding: gzip\r\n\r\n",
+	}
+	for _, b := range bad {
+		p := httpParser{request: true}
+		err := p.input([]byte(b), func(m *httpMessage, chunked bool) bool { return true })
+		if err == nil {
+			t.Fatalf(" no error for %q", b)
+		}
+	}
+}
+
+func TestHttpHist1(t *testing.T) {
+	var h httpLatencyHist
+	for _, v := range []float64{0.00005, 0.0001, 0.0002, 0.3, 0.3, 20} {
+		h.add(v)
+	}
+	r := h.get()
+	if r.Cnt != 6 || r.MinUsec != 50 || r.MaxUsec != 20000000 || len(r.Hist) != 4 {
+		t.Fatalf(" bad histogram %+v", r)
+	}
+	if r.Hist[0] != (HttpHistBucket{100, 2}) || r.Hist[1] != (HttpHistBucket{200, 1}) ||
+		r.Hist[2] != (HttpHistBucket{500000, 2}) || r.Hist[3] != (HttpHistBucket{0, 1}) {
+		t.Fatalf(" bad histogram buckets %+v", r.Hist)
+	}
+	h.clear()
+	if r = h.get(); r.Cnt != 0 || len(r.Hist) != 0 {
+		t.Fatalf(" histogram was not cleared %+v", r)
+	}
+}
+
+/* simulation of a client and a server in the same namespace */
+
+type httpSim struct {
+	tctx  *core.CThreadCtx
+	ns    *core.CNSCtx
+	delay time.Duration
+}
+
+type httpSimPkt struct {
+	sim   *httpSim
+	m     *core.Mbuf
+	timer core.CHTimerObj
+}
+
+func (o *httpSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
+	e := &httpSimPkt{sim: o, m: m}
+	e.timer.SetCB(e, nil, nil)
+	o.tctx.GetTimerCtx().Start(&e.timer, o.delay)
+	return nil
+}
+
+// OnEvent delivers the packet to the transport of the destination client
+func (o *httpSimPkt) OnEvent(a, b interface{}) {
+	defer o.m.FreeMbuf()
+	p := o.m.GetData()
+	var mac core.MACKey
+	copy(mac[:], p[0:6])
+	c := o.sim.ns.CLookupByMac(&mac)
+	if c == nil {
+		return
+	}
+	var ps core.ParserPacketState
+	ps.Tctx = o.sim.tctx
+	ps.Tun = &o.sim.ns.Key
+	ps.M = o.m
+	ps.L3 = 14 + 8
+	if p[20] == 0x86 && p[21] == 0xdd {
+		ps.NextHeader = p[ps.L3+6]
+		ps.L4 = ps.L3 + 40
+	} else {
+		ps.L4 = ps.L3 + 20
+	}
+	ps.L7 = ps.L4 + uint16(p[ps.L4+12]>>4)<<2
+	ps.L7Len = o.m.DataLen() - ps.L7
+	transport.GetTransportCtx(c).DebugSimulationHandleRxPacket(&ps)
+}
+
+func newHttpSim(t *testing.T, clientJson, serverJson string, duration time.Duration) (*PluginHttpClient, *PluginHttpClient) {
+	rand.Seed(0x1234)
+	sim := &httpSim{delay: 100 * time.Millisecond}
+	var simrx core.VethIFSim = sim
+	sim.tctx = core.NewThreadCtx(0, 4510, true, &simrx)
+	defer sim.tctx.Delete()
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: [2]uint32{0x81000001, 0x81000002}})
+	sim.ns = core.NewNSCtx(sim.tctx, &key)
+	sim.tctx.AddNs(&key, sim.ns)
+
+	client := core.NewClient(sim.ns, core.MACKey{0, 0, 1, 0, 0, 1},
+		core.Ipv4Key{16, 0, 0, 1},
+		core.Ipv6Key{0x20, 0x01, 0x0d, 0xb8, 0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 16, 0x00, 0x00, 0x01},
+		core.Ipv4Key{16, 0, 0, 2})
+	client.ForceDGW = true
+	client.Ipv4ForcedgMac = core.MACKey{0, 0, 1, 0, 0, 2}
+	client.Ipv6ForceDGW = true
+	client.Ipv6ForcedgMac = client.Ipv4ForcedgMac
+
+	server := core.NewClient(sim.ns, core.MACKey{0, 0, 1, 0, 0, 2},
+		core.Ipv4Key{48, 0, 0, 1},
+		core.Ipv6Key{0x20, 0x01, 0x0d, 0xb8, 0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 48, 0x00, 0x00, 0x01},
+		core.Ipv4Key{48, 0, 0, 2})
+	server.ForceDGW = true
+	server.Ipv4ForcedgMac = core.MACKey{0, 0, 1, 0, 0, 1}
+	server.Ipv6ForceDGW = true
+	server.Ipv6ForcedgMac = server.Ipv4ForcedgMac
+
+	sim.ns.AddClient(client)
+	sim.ns.AddClient(server)
+	if err := server.PluginCtx.CreatePlugins([]string{HTTP_PLUG}, [][]byte{[]byte(serverJson)}); err != nil {
+		t.Fatalf(" can't create the server plugin %v", err)
+	}
+	if err := client.PluginCtx.CreatePlugins([]string{HTTP_PLUG}, [][]byte{[]byte(clientJson)}); err != nil {
+		t.Fatalf(" can't create the client plugin %v", err)
+	}
+	sim.tctx.MainLoopSim(duration)
+	c := client.PluginCtx.Get(HTTP_PLUG).Ext.(*PluginHttpClient)
+	s := server.PluginCtx.Get(HTTP_PLUG).Ext.(*PluginHttpClient)
+	c.cdbv.Dump()
+	s.cdbv.Dump()
+	return c, s
+}
+
+func TestPluginHttp1(t *testing.T) {
+	c, s := newHttpSim(t,
+		`{"client": {"addr": "48.0.0.1:80", "rate": 10, "limit": 20, "conns": 4}}`,
+		`{"server": {"routes": [{"path": "/", "size": 3000}]}}`,
+		10*time.Second)
+
+	cs := &c.cstats
+	if cs.httpc_req != 20 || cs.httpc_resp != 20 || cs.httpc_resp_2xx != 20 || cs.httpc_req_err != 0 || cs.httpc_parse_err != 0 {
+		t.Fatalf(" bad client counters %+v", *cs)
+	}
+	if cs.httpc_conn == 0 || cs.httpc_conn > 4 || cs.httpc_req_reuse != 20-cs.httpc_conn {
+		t.Fatalf(" connections were not reused %+v", *cs)
+	}
+	ss := &s.sstats
+	if ss.httpsrv_req != 20 || ss.httpsrv_resp != 20 || ss.httpsrv_accept != cs.httpc_conn || ss.httpsrv_rxbyte != cs.httpc_txbyte || ss.httpsrv_txbyte != cs.httpc_rxbyte {
+		t.Fatalf(" bad server counters %+v", *ss)
+	}
+	h := c.hist.get()
+	if h.Cnt != 20 || h.MinUsec < 200000 || h.MaxUsec < h.MinUsec {
+		t.Fatalf(" bad histogram %+v", h)
+	}
+}
+
+func TestPluginHttp2(t *testing.T) {
+	/* POST without keep-alive, chunked responses */
+	c, s := newHttpSim(t,
+		`{"client": {"addr": "48.0.0.1:80", "rate": 5, "limit": 10, "conns": 10, "keepalive": false, "method": "POST", "path": "/post/1", "body_size": 100}}`,
+		`{"server": {"routes": [{"path": "/post/", "size": 10000, "chunked": true}, {"path": "/", "status": 500}]}}`,
+		10*time.Second)
+
+	cs := &c.cstats
+	if cs.httpc_resp != 10 || cs.httpc_resp_2xx != 10 || cs.httpc_resp_chunked != 10 || cs.httpc_conn != 10 || cs.httpc_req_reuse != 0 {
+		t.Fatalf(" bad client counters %+v", *cs)
+	}
+	ss := &s.sstats
+	if ss.httpsrv_req != 10 || ss.httpsrv_accept != 10 || ss.httpsrv_rxbyte != cs.httpc_txbyte || ss.httpsrv_txbyte != cs.httpc_rxbyte {
+		t.Fatalf(" bad server counters %+v", *ss)
+	}
+	if c.hist.get().Cnt != 10 {
+		t.Fatalf(" bad histogram %+v", c.hist.get())
+	}
+}
+
+func TestPluginHttp3(t *testing.T) {
+	/* TLS over IPv6 */
+	c, s := newHttpSim(t,
+		`{"client": {"addr": "[2001:db8::3000:1]:443", "tls": true, "rate": 2, "limit": 6}}`,
+		`{"server": {"addr": ":443", "tls": true, "routes": [{"path": "/", "size": 20000}]}}`,
+		20*time.Second)
+
+	cs := &c.cstats
+	if cs.httpc_resp != 6 || cs.httpc_resp_2xx != 6 || cs.httpc_conn != 1 || cs.httpc_req_reuse != 5 {
+		t.Fatalf(" bad client counters %+v", *cs)
+	}
+	if s.sstats.httpsrv_req != 6 || s.sstats.httpsrv_txbyte != cs.httpc_rxbyte {
+		t.Fatalf(" bad server counters %+v", s.sstats)
+	}
+	if c.hist.get().Cnt != 6 {
+		t.Fatalf(" bad histogram %+v", c.hist.get())
+	}
+}
+
+func TestPluginHttp4(t *testing.T) {
+	/* unknown route and a server that does not listen on the port */
+	c, s := newHttpSim(t,
+		`{"client": {"addr": "48.0.0.1:80", "rate": 1, "limit": 3, "path": "/missing"}}`,
+		`{"server": {"routes": [{"path": "/index.html"}]}}`,
+		10*time.Second)
+	if c.cstats.httpc_resp_4xx != 3 || s.sstats.httpsrv_not_found != 3 {
+		t.Fatalf(" bad counters %+v %+v", c.cstats, s.sstats)
+	}
+
+	c, _ = newHttpSim(t,
+		`{"client": {"addr": "48.0.0.1:8080", "rate": 1, "limit": 3, "timeout": 2000}}`,
+		`{"server": {}}`,
+		10*time.Second)
+	cs := &c.cstats
+	if cs.httpc_req != 3 || cs.httpc_resp != 0 || cs.httpc_req_err != 3 || cs.httpc_conn != 2 || cs.httpc_conn_err != 2 {
+		t.Fatalf(" bad client counters %+v", *cs)
+	}
+	if c.hist.get().Cnt != 0 {
+		t.Fatalf(" bad histogram %+v", c.hist.get())
+	}
+}
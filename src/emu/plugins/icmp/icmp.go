@@ -681,5 +681,6 @@ func init() {
 	core.RegisterCB("icmp_c_get_ping_stats", ApiIcmpClientGetPingStatsHandler{}, true)
 
 	/* register callback for rx side*/
-	core.ParserRegister("icmp", HandleRxIcmpPacket)
+	core.ParserRegister("icmp", HandleRxIcmpPacket,
+		core.ParserMatch{L3: uint16(layers.EthernetTypeIPv4), IPProto: uint8(layers.IPProtocolICMPv4)})
 }
@@ -667,7 +667,7 @@ type PluginIgmpClient struct {
 	igmpNsPlug *PluginIgmpNs
 }
 
-var igmpEvents = []string{}
+var igmpEvents = []string{core.MSG_UPDATE_CLIENT}
 
 /*NewIgmpClient create plugin */
 func NewIgmpClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
@@ -682,7 +682,16 @@ func NewIgmpClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, erro
 
 /*OnEvent support event change of IP  */
 func (o *PluginIgmpClient) OnEvent(msg string, a, b interface{}) {
-
+	switch msg {
+	case core.MSG_UPDATE_CLIENT:
+		ev := a.(*core.CClientUpdate)
+		if ev.Changed&core.CLIENT_UPDATE_IPV4 != 0 &&
+			o.Client.Mac == o.igmpNsPlug.designatorMac &&
+			!o.Client.Ipv4.IsZero() {
+			/* the reports are sent from the designator IPv4, report all the groups again from the new one */
+			o.igmpNsPlug.HandleRxIgmpCmn(true, 0)
+		}
+	}
 }
 
 func (o *PluginIgmpClient) OnRemove(ctx *core.PluginCtx) {
@@ -2038,7 +2047,8 @@ func init() {
 	core.RegisterCB("igmp_ns_set_cfg", ApiIgmpSetHandler{}, false)          // Set
 
 	/* register callback for rx side*/
-	core.ParserRegister("igmp", HandleRxIgmpPacket)
+	core.ParserRegister("igmp", HandleRxIgmpPacket,
+		core.ParserMatch{L3: uint16(layers.EthernetTypeIPv4), IPProto: uint8(layers.IPProtocolIGMP)})
 }
 
 func Register(ctx *core.CThreadCtx) {
@@ -125,7 +125,8 @@ type PluginIpv6Client struct {
 
 var icmpEvents = []string{core.MSG_UPDATE_IPV6_ADDR,
 	core.MSG_UPDATE_DGIPV6_ADDR,
-	core.MSG_UPDATE_DIPV6_ADDR}
+	core.MSG_UPDATE_DIPV6_ADDR,
+	core.MSG_UPDATE_IPV6_IFID}
 
 /*NewIpv6Client create plugin */
 func NewIpv6Client(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
@@ -1097,7 +1098,8 @@ func init() {
 	core.RegisterCB("ipv6_get_ping_stats", ApiIpv6GetPingStatsHandler{}, true) // get ping stats
 
 	/* register callback for rx side*/
-	core.ParserRegister("icmpv6", HandleRxIcmpv6Packet) // support mld/icmp/nd
+	core.ParserRegister("icmpv6", HandleRxIcmpv6Packet, // support mld/icmp/nd
+		core.ParserMatch{L3: uint16(layers.EthernetTypeIPv6), IPProto: uint8(layers.IPProtocolICMPv6)})
 }
 
 func Register(ctx *core.CThreadCtx) {
@@ -995,6 +995,17 @@ func (o *NdClientCtx) OnEvent(msg string, a, b interface{}) {
 			}
 		}
 
+	case core.MSG_UPDATE_IPV6_IFID:
+		oldIPv6 := a.(core.Ipv6Key)
+		newIPv6 := b.(core.Ipv6Key)
+		if newIPv6 != oldIPv6 {
+			o.nsPlug.stats.eventsChangeSrc++
+			o.preparePacketTemplate() // the link local is the source of the templates
+			o.addMcCache(&newIPv6)
+			o.SendUnsolicitedNA()
+			o.AdvIPv6()
+		}
+
 	case core.MSG_UPDATE_DGIPV6_ADDR:
 		oldIPv6 := a.(core.Ipv6Key)
 		newIPv6 := b.(core.Ipv6Key)
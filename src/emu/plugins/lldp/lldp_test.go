@@ -176,6 +176,211 @@ func TestPluginLldp2(t *testing.T) {
 	a.Run()
 }
 
+// VethLldpLoopback loops the packets back, so the clients get the LLDPDUs of each other
+type VethLldpLoopback struct {
+}
+
+func (o *VethLldpLoopback) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
+	return m
+}
+
+// runNeighbors runs two lldp clients on the same namespace and returns their plugins
+func runNeighbors(t *testing.T, optionsA, optionsB []byte, duration time.Duration) (*PluginLldpClient, *PluginLldpClient) {
+	var simVeth VethLldpLoopback
+	var simrx core.VethIFSim
+	simrx = &simVeth
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	defer tctx.Delete()
+	Register(tctx)
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: [2]uint32{0x81000001, 0x81000002}})
+	ns := core.NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+
+	var plugs []*PluginLldpClient
+	for i, options := range [][]byte{optionsA, optionsB} {
+		client := core.NewClient(ns, core.MACKey{0, 0, 1, 0, 0, byte(i + 1)},
+			core.Ipv4Key{0, 0, 0, 0},
+			core.Ipv6Key{},
+			core.Ipv4Key{0, 0, 0, 0})
+		if err := ns.AddClient(client); err != nil {
+			t.Fatal(err)
+		}
+		if options == nil {
+			options = []byte("{}")
+		}
+		if err := client.PluginCtx.CreatePlugins([]string{LLDP_PLUG}, [][]byte{options}); err != nil {
+			t.Fatal(err)
+		}
+		plugs = append(plugs, client.PluginCtx.Get(LLDP_PLUG).Ext.(*PluginLldpClient))
+	}
+	m := false
+	if monitor > 0 {
+		m = true
+	}
+	tctx.Veth.SetDebug(m, os.Stdout, false)
+	tctx.MainLoopSim(duration)
+	for _, plug := range plugs {
+		plug.cdbv.Dump()
+	}
+	tctx.GetCounterDbVec().Dump()
+	return plugs[0], plugs[1]
+}
+
+func TestPluginLldpNeighbors(t *testing.T) {
+	lldp_options := "081753756d6d69743330302d34382d506f72742031303031000a0d53756d6d69743330302d3438000c4c53756d6d69743330302d3438202d2056657273696f6e20372e34652e3120284275696c642035292062792052656c656173655f4d61737465722030352f32372f30352030343a35333a3131000e0400140014100e0706000130f9ada002000003e900fe0700120f02070100fe0900120f01036c000010fe0900120f030100000000fe0600120f0405f2fe060080c20101e8fe070080c202010000fe170080c20301e81076322d303438382d30332d3035303500fe050080c20400"
+	raw, _ := hex.DecodeString(lldp_options)
+	optionsA, _ := json.Marshal(&LldpInit{TimerSec: 10, Options: &LldpOptionsT{Raw: &raw}})
+	a, b := runNeighbors(t, optionsA, nil, 45*time.Second)
+
+	expStats := LldpStats{pktTx: 2, pktRx: 5, neighborAdd: 1, neighborUpdate: 4}
+	if b.stats != expStats {
+		t.Fatalf("Bad stats of b %+v, expected %+v", b.stats, expStats)
+	}
+	res := b.GetNeighbors()
+	if len(res) != 1 {
+		t.Fatalf("Bad neighbors of b %+v", res)
+	}
+	n := res[0]
+	if n.ChassisId != "00:00:01:00:00:01" || n.PortId != "1/1" || n.Ttl != 120 || n.SrcMac != "00:00:01:00:00:01" ||
+		n.SystemName != "Summit300-48" || n.PortDescription != "Summit300-48-Port 1001" ||
+		n.MgmtAddress != "00:01:30:f9:ad:a0" || n.Vlan != 488 || n.Rx != 5 ||
+		len(n.Capabilities) != 2 || n.Capabilities[0] != "bridge" || n.Capabilities[1] != "router" {
+		t.Fatalf("Bad neighbor of b %+v", n)
+	}
+
+	res = a.GetNeighbors()
+	if len(res) != 1 || res[0].ChassisId != "00:00:01:00:00:02" || res[0].SystemName != "" || res[0].Vlan != 0 {
+		t.Fatalf("Bad neighbors of a %+v", res)
+	}
+}
+
+func TestPluginLldpNeighborsAging(t *testing.T) {
+	// Chassis ID local "a", Port ID local "p", TTL 5
+	raw, _ := hex.DecodeString("020207610402076106020005")
+	optionsA, _ := json.Marshal(&LldpInit{TimerSec: 100, Options: &LldpOptionsT{Raw: &raw, RemoveDefault: true}})
+	// Chassis ID without a subtype
+	raw, _ = hex.DecodeString("020106040207610602000a")
+	optionsB, _ := json.Marshal(&LldpInit{TimerSec: 100, Options: &LldpOptionsT{Raw: &raw, RemoveDefault: true}})
+	a, b := runNeighbors(t, optionsA, optionsB, 10*time.Second)
+
+	expStats := LldpStats{pktTx: 1, pktRx: 1, neighborAdd: 1, neighborAged: 1}
+	if b.stats != expStats || len(b.GetNeighbors()) != 0 {
+		t.Fatalf("Bad stats of b %+v, expected %+v", b.stats, expStats)
+	}
+	expStats = LldpStats{pktTx: 1, pktRx: 1, pktRxMalformed: 1}
+	if a.stats != expStats || len(a.GetNeighbors()) != 0 {
+		t.Fatalf("Bad stats of a %+v, expected %+v", a.stats, expStats)
+	}
+}
+
+func TestPluginLldpStructuredTlvs(t *testing.T) {
+	optionsA := []byte(`{"timer": 10, "options": {
+		"port_description": "port 1", "system_name": "sw-a", "system_description": "emu switch",
+		"capabilities": {"system": ["bridge", "router"], "enabled": ["bridge"]},
+		"mgmt_address": {"address": "10.0.0.1", "if_number": 3},
+		"port_vlan": 100,
+		"power": {"support": 7, "class": 4, "type": 1, "priority": 2, "requested": 255, "allocated": 200},
+		"network_policy": [{"app": 1, "tagged": true, "vlan": 200, "priority": 5, "dscp": 46}]}}`)
+	a, b := runNeighbors(t, optionsA, nil, 15*time.Second)
+
+	res := b.GetNeighbors()
+	if len(res) != 1 {
+		t.Fatalf("Bad neighbors of b %+v", res)
+	}
+	n := res[0]
+	if n.PortDescription != "port 1" || n.SystemName != "sw-a" || n.SystemDescription != "emu switch" ||
+		n.MgmtAddress != "10.0.0.1" || n.Vlan != 100 ||
+		len(n.Capabilities) != 2 || n.Capabilities[0] != "bridge" || n.Capabilities[1] != "router" ||
+		len(n.EnabledCapabilities) != 1 || n.EnabledCapabilities[0] != "bridge" {
+		t.Fatalf("Bad neighbor of b %+v", n)
+	}
+
+	_, info := decodeLldp(a.pktTemplate[a.l3Offset:])
+	if info == nil {
+		t.Fatalf("Bad LLDPDU of a")
+	}
+	if info.MgmtAddress.InterfaceNumber != 3 {
+		t.Fatalf("Bad management address of a %+v", info.MgmtAddress)
+	}
+	i8023, err := info.Decode8023()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := i8023.PowerViaMDI
+	if p.PortClassPSE != true || p.PSESupported != true || p.PSEEnabled != true || p.PSEPairsAbility != false ||
+		p.PSEPowerPair != 1 || p.PSEClass != 4 || p.Type != 1 || p.Priority != 2 ||
+		p.Requested != 255 || p.Allocated != 200 {
+		t.Fatalf("Bad power of a %+v", p)
+	}
+	med, err := info.DecodeMedia()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mc := med.MediaCapabilities
+	if mc.Class != 3 || !mc.Capabilities || !mc.NetworkPolicy || !mc.PowerPD {
+		t.Fatalf("Bad LLDP-MED capabilities of a %+v", mc)
+	}
+	np := med.NetworkPolicy
+	if np.ApplicationType != 1 || !np.Tagged || np.Defined != true || np.VLANId != 200 || np.L2Priority != 5 ||
+		np.DSCPValue != 46 {
+		t.Fatalf("Bad network policy of a %+v", np)
+	}
+}
+
+func TestPluginLldpEngines(t *testing.T) {
+	optionsA := []byte(`{"timer": 10, "options": {"system_name": "sw", "port_vlan": 1},
+		"engines": [
+			{"engine_name": "port_vlan", "engine_type": "uint",
+			 "params": {"size": 2, "offset": 0, "op": "inc", "step": 1, "min": 10, "max": 12}},
+			{"engine_name": "system_name", "engine_type": "string_list",
+			 "params": {"size": 8, "offset": 0, "op": "inc", "list": ["sw-a", "switch-b"], "padding_value": 0}}]}`)
+	a, b := runNeighbors(t, optionsA, nil, 35*time.Second)
+
+	// a sends at 0, 10, 20 and 30 seconds
+	if a.stats.pktTx != 4 {
+		t.Fatalf("Bad stats of a %+v", a.stats)
+	}
+	res := b.GetNeighbors()
+	if len(res) != 1 || res[0].Vlan != 10 || res[0].SystemName != "switch-b" || res[0].Rx != 4 {
+		t.Fatalf("Bad neighbors of b %+v", res)
+	}
+}
+
+func TestPluginLldpBadOptions(t *testing.T) {
+	var simrx core.VethIFSim = &VethLldpLoopback{}
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1})
+	ns := core.NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+
+	tests := []string{
+		`{"options": {"capabilities": {"system": ["bridge", "hub"]}}}`,
+		`{"options": {"mgmt_address": {"address": "10.0.0"}}}`,
+		`{"options": {"port_vlan": 1}, "engines": [{"engine_name": "vlan", "engine_type": "uint",
+			"params": {"size": 2, "offset": 0, "op": "inc", "min": 1, "max": 2}}]}`,
+		`{"options": {"port_vlan": 1}, "engines": [{"engine_name": "port_vlan", "engine_type": "uint",
+			"params": {"size": 2, "offset": 1, "op": "inc", "min": 1, "max": 2}}]}`,
+	}
+	for i, options := range tests {
+		client := core.NewClient(ns, core.MACKey{0, 0, 1, 0, 0, byte(i + 1)},
+			core.Ipv4Key{0, 0, 0, 0},
+			core.Ipv6Key{},
+			core.Ipv4Key{0, 0, 0, 0})
+		if err := ns.AddClient(client); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.PluginCtx.CreatePlugins([]string{LLDP_PLUG}, [][]byte{[]byte(options)}); err == nil {
+			t.Fatalf("Options %s should fail", options)
+		}
+	}
+	nsplg := ns.PluginCtx.Get(LLDP_PLUG)
+	if nsplg == nil || len(nsplg.Ext.(*PluginLldpNs).clients) != 0 {
+		t.Fatalf("Failed clients should not get LLDPDUs")
+	}
+}
+
 func init() {
 	flag.IntVar(&monitor, "monitor", 0, "monitor")
 }
@@ -8,6 +8,10 @@ package lldp
 /*
 lldp client send every 30 sec information from initJson
 
+The LLDPDUs of the neighbors are kept in a neighbor table per client, see neighbor.go
+
+The optional TLVs could be given as structured options, with field engines to vary them across clients, see tlv.go
+
 */
 
 import (
@@ -27,17 +31,34 @@ const (
 var lldpDefaultDestMAC = []byte{0x01, 0x80, 0xc2, 0x00, 0x00, 0x0e}
 
 type LldpOptionsT struct {
-	Raw           *[]byte `json:"raw"`            // raw options to add
-	RemoveDefault bool    `json:"remove_default"` // remove the default 		ChassisID/PortID/TTL
+	Raw               *[]byte              `json:"raw"`                // raw options to add
+	RemoveDefault     bool                 `json:"remove_default"`     // remove the default 		ChassisID/PortID/TTL
+	PortDescription   *string              `json:"port_description"`   // Port Description TLV
+	SystemName        *string              `json:"system_name"`        // System Name TLV
+	SystemDescription *string              `json:"system_description"` // System Description TLV
+	Capabilities      *LldpCapabilitiesT   `json:"capabilities"`       // System Capabilities TLV
+	MgmtAddress       *LldpMgmtAddressT    `json:"mgmt_address"`       // Management Address TLV
+	PortVlan          *uint16              `json:"port_vlan"`          // 802.1 Port VLAN ID TLV
+	Power             *LldpPowerT          `json:"power"`              // 802.3 Power via MDI TLV
+	NetworkPolicy     []LldpNetworkPolicyT `json:"network_policy"`     // LLDP-MED Network Policy TLVs
+	MedClass          uint8                `json:"med_class"`          // LLDP-MED device class of the network policies. Default to 3
 }
 
 type LldpInit struct {
-	TimerSec uint32        `json:"timer"`
-	Options  *LldpOptionsT `json:"options"`
+	TimerSec uint32               `json:"timer"`
+	Options  *LldpOptionsT        `json:"options"`
+	Engines  *fastjson.RawMessage `json:"engines"` // field engines of the TLVs, by TLV name
 }
 
 type LldpStats struct {
-	pktTx uint64
+	pktTx            uint64
+	pktRx            uint64
+	pktRxMalformed   uint64
+	neighborAdd      uint64
+	neighborUpdate   uint64
+	neighborAged     uint64
+	neighborShutdown uint64
+	neighborTblFull  uint64
 }
 
 func NewLldpStatsDb(o *LldpStats) *core.CCounterDb {
@@ -51,6 +72,62 @@ func NewLldpStatsDb(o *LldpStats) *core.CCounterDb {
 		DumpZero: false,
 		Info:     core.ScINFO})
 
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRx,
+		Name:     "pktRx",
+		Help:     "received lldp packets",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxMalformed,
+		Name:     "pktRxMalformed",
+		Help:     "malformed lldp packets",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.neighborAdd,
+		Name:     "neighborAdd",
+		Help:     "new neighbors",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.neighborUpdate,
+		Name:     "neighborUpdate",
+		Help:     "neighbor updates",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.neighborAged,
+		Name:     "neighborAged",
+		Help:     "neighbors removed as their ttl expired",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.neighborShutdown,
+		Name:     "neighborShutdown",
+		Help:     "neighbors removed by a shutdown packet",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.neighborTblFull,
+		Name:     "neighborTblFull",
+		Help:     "neighbors ignored as the table is full",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
 	return db
 }
 
@@ -76,6 +153,10 @@ type PluginLldpClient struct {
 	timerSec    uint32
 	l3Offset    uint16
 	pktTemplate []byte
+	mandatory   []byte                   // L2 header and the mandatory TLVs, in case of engines
+	tlvs        []*lldpTlv               // structured TLVs
+	hasEngines  bool                     // the packet is built on each send
+	neighbors   map[string]*lldpNeighbor // neighbor table, by Chassis ID and Port ID
 }
 
 var lldpEvents = []string{}
@@ -93,14 +174,20 @@ func NewLldpClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, erro
 	o.RegisterEvents(ctx, lldpEvents, o) /* register events, only if exits*/
 	nsplg := o.Ns.PluginCtx.GetOrCreate(LLDP_PLUG)
 	o.lldpNsPlug = nsplg.Ext.(*PluginLldpNs)
-	o.OnCreate()
+	if err = o.OnCreate(); err != nil {
+		return nil, err
+	}
+	o.lldpNsPlug.clients = append(o.lldpNsPlug.clients, o)
 
 	return &o.PluginBase, nil
 }
 
-func (o *PluginLldpClient) OnCreate() {
+func (o *PluginLldpClient) OnCreate() error {
 	o.timerw = o.Tctx.GetTimerCtx()
-	o.preparePacketTemplate()
+	o.neighbors = make(map[string]*lldpNeighbor)
+	if err := o.preparePacketTemplate(); err != nil {
+		return err
+	}
 	o.timerSec = 30
 	if o.init.TimerSec > 0 {
 		o.timerSec = o.init.TimerSec
@@ -111,9 +198,10 @@ func (o *PluginLldpClient) OnCreate() {
 	o.cdbv.Add(o.cdb)
 	o.timer.SetCB(&o.timerCb, o, 0) // set the callback to OnEvent
 	o.SendLldp()
+	return nil
 }
 
-func (o *PluginLldpClient) preparePacketTemplate() {
+func (o *PluginLldpClient) preparePacketTemplate() error {
 
 	l2 := o.Client.GetL2Header(true, uint16(layers.EthernetTypeLinkLayerDiscovery))
 	copy(l2[0:6], lldpDefaultDestMAC[:])
@@ -132,18 +220,55 @@ func (o *PluginLldpClient) preparePacketTemplate() {
 		d = []byte{0, 0}
 	}
 
+	d = d[:len(d)-2] // the End TLV is added last
+
+	if o.init.Options != nil {
+		tlvs, err := o.init.Options.buildTlvs()
+		if err != nil {
+			return err
+		}
+		o.tlvs = tlvs
+	}
+	if o.init.Engines != nil {
+		if err := attachEngines(o.Tctx, o.init.Engines, o.tlvs); err != nil {
+			return err
+		}
+		o.hasEngines = true
+	}
+
+	o.mandatory = append(l2, d...)
+	o.pktTemplate = o.buildPacket()
+	return nil
+}
+
+// buildPacket builds the packet from the mandatory TLVs, the structured TLVs and the raw options.
+func (o *PluginLldpClient) buildPacket() []byte {
+	p := append([]byte{}, o.mandatory...)
+	for _, tlv := range o.tlvs {
+		p = tlv.encode(p)
+	}
 	if (o.init.Options != nil) && (o.init.Options.Raw != nil) {
-		d = d[:len(d)-2]
-		d = append(d, *(o.init.Options.Raw)...)
-		d = append(d, []byte{0, 0}...)
+		p = append(p, *(o.init.Options.Raw)...)
 	}
+	return append(p, 0, 0)
+}
 
-	o.pktTemplate = append(l2, d...)
+// updateEngines runs the field engines, in the order of the TLVs, and rebuilds the packet.
+func (o *PluginLldpClient) updateEngines() {
+	for _, tlv := range o.tlvs {
+		if tlv.eng != nil {
+			tlv.update()
+		}
+	}
+	o.pktTemplate = o.buildPacket()
 }
 
 func (o *PluginLldpClient) SendLldp() {
 	o.restartTimer(o.timerSec)
 	o.stats.pktTx++
+	if o.hasEngines {
+		o.updateEngines()
+	}
 	o.Tctx.Veth.SendBuffer(false, o.Client, o.pktTemplate, false)
 }
 
@@ -159,6 +284,8 @@ func (o *PluginLldpClient) OnRemove(ctx *core.PluginCtx) {
 	if o.timer.IsRunning() {
 		o.timerw.Stop(&o.timer)
 	}
+	o.flushNeighbors()
+	o.lldpNsPlug.removeClient(o)
 }
 
 func (o *PluginLldpClient) restartTimer(sec uint32) {
@@ -179,7 +306,8 @@ func (o *PluginLldpClient) onTimerEvent() {
 // PluginLldpNs icmp information per namespace
 type PluginLldpNs struct {
 	core.PluginBase
-	stats LldpStats
+	stats   LldpStats
+	clients []*PluginLldpClient // lldp clients of the namespace, they get the received LLDPDUs
 }
 
 func NewLldpNs(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
@@ -200,6 +328,15 @@ func (o *PluginLldpNs) SetTruncated() {
 
 }
 
+func (o *PluginLldpNs) removeClient(c *PluginLldpClient) {
+	for i := range o.clients {
+		if o.clients[i] == c {
+			o.clients = append(o.clients[:i], o.clients[i+1:]...)
+			return
+		}
+	}
+}
+
 // Tx side client get an event and decide to act !
 // let's see how it works and add some tests
 
@@ -217,7 +354,8 @@ func (o PluginLldpNsReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) (*core.
 /*******************************************/
 /*  RPC commands */
 type (
-	ApiLldpClientCntHandler struct{}
+	ApiLldpClientCntHandler          struct{}
+	ApiLldpClientGetNeighborsHandler struct{}
 )
 
 func getNs(ctx interface{}, params *fastjson.RawMessage) (*PluginLldpNs, *jsonrpc.Error) {
@@ -264,6 +402,18 @@ func (h ApiLldpClientCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.
 	return c.cdbv.GeneralCounters(err, tctx, params, &p)
 }
 
+func (h ApiLldpClientGetNeighborsHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	c, err := getClientPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return c.GetNeighbors(), nil
+}
+
 func init() {
 
 	/* register of plugins callbacks for ns,c level  */
@@ -287,10 +437,14 @@ func init() {
 	  aa - misc
 	*/
 
-	core.RegisterCB("lldp_client_cnt", ApiLldpClientCntHandler{}, false) // get counters/meta
+	core.RegisterCB("lldp_client_cnt", ApiLldpClientCntHandler{}, false)               // get counters/meta
+	core.RegisterCB("lldp_c_get_neighbors", ApiLldpClientGetNeighborsHandler{}, false) // get the neighbor table
 
+	/* register callback for rx side*/
+	core.ParserRegister(LLDP_PLUG, HandleRxLldpPacket,
+		core.ParserMatch{EthType: uint16(layers.EthernetTypeLinkLayerDiscovery)})
 }
 
 func Register(ctx *core.CThreadCtx) {
-	//pass
+	ctx.RegisterParserCb(LLDP_PLUG)
 }
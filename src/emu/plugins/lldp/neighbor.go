@@ -0,0 +1,237 @@
+// Copyright (c) 2021 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package lldp
+
+/*
+lldp neighbor table
+
+Each LLDPDU received in the namespace is handed to all the lldp clients of the namespace, besides the client that sent
+it. A neighbor is identified by its Chassis ID and Port ID, it is removed when its TTL expires without a new LLDPDU or
+when it sends a shutdown LLDPDU (TTL zero).
+*/
+
+import (
+	"emu/core"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	maxNeighbors = 256 // Maximal number of neighbors per client
+)
+
+// LldpNeighbor is the information of a neighbor as returned by lldp_c_get_neighbors.
+type LldpNeighbor struct {
+	ChassisIdType       uint8    `json:"chassis_id_type"`      // Subtype of the Chassis ID
+	ChassisId           string   `json:"chassis_id"`           // Chassis ID
+	PortIdType          uint8    `json:"port_id_type"`         // Subtype of the Port ID
+	PortId              string   `json:"port_id"`              // Port ID
+	Ttl                 uint16   `json:"ttl"`                  // TTL of the last LLDPDU in seconds
+	SrcMac              string   `json:"src_mac"`              // Source MAC of the last LLDPDU
+	PortDescription     string   `json:"port_description"`     // Port Description
+	SystemName          string   `json:"system_name"`          // System Name
+	SystemDescription   string   `json:"system_description"`   // System Description
+	Capabilities        []string `json:"capabilities"`         // System capabilities
+	EnabledCapabilities []string `json:"enabled_capabilities"` // Enabled capabilities
+	MgmtAddress         string   `json:"mgmt_address"`         // Management Address, empty if not advertised
+	Vlan                uint16   `json:"vlan"`                 // Port VLAN ID of the 802.1 TLV, zero if not advertised
+	Rx                  uint64   `json:"rx"`                   // LLDPDUs received from the neighbor
+}
+
+// lldpNeighbor is an entry of the neighbor table of a client.
+type lldpNeighbor struct {
+	key   string
+	info  LldpNeighbor
+	timer core.CHTimerObj // TTL of the neighbor
+}
+
+// OnEvent removes a neighbor whose TTL has expired.
+func (o *lldpNeighbor) OnEvent(a, b interface{}) {
+	c := a.(*PluginLldpClient)
+	c.stats.neighborAged++
+	delete(c.neighbors, o.key)
+}
+
+// lldpCapNames converts LLDP capabilities to names.
+func lldpCapNames(c layers.LLDPCapabilities) []string {
+	res := []string{}
+	caps := []struct {
+		on   bool
+		name string
+	}{
+		{c.Other, "other"}, {c.Repeater, "repeater"}, {c.Bridge, "bridge"}, {c.WLANAP, "wlan_ap"},
+		{c.Router, "router"}, {c.Phone, "phone"}, {c.DocSis, "docsis"}, {c.StationOnly, "station_only"},
+		{c.CVLAN, "cvlan"}, {c.SVLAN, "svlan"}, {c.TMPR, "tpmr"},
+	}
+	for _, e := range caps {
+		if e.on {
+			res = append(res, e.name)
+		}
+	}
+	return res
+}
+
+// lldpString converts a string TLV, some devices terminate it by NUL.
+func lldpString(s string) string {
+	return strings.TrimRight(s, "\x00")
+}
+
+// lldpIdString formats a Chassis ID or a Port ID by its subtype.
+func lldpIdString(id []byte, isMac, isAddr bool) string {
+	switch {
+	case isMac && len(id) == 6:
+		return net.HardwareAddr(id).String()
+	case isAddr && len(id) > 1:
+		return lldpAddrString(layers.IANAAddressFamily(id[0]), id[1:])
+	}
+	return lldpString(string(id))
+}
+
+// lldpAddrString formats an address of an IANA address family.
+func lldpAddrString(family layers.IANAAddressFamily, addr []byte) string {
+	switch {
+	case family == layers.IANAAddressFamilyIPV4 && len(addr) == net.IPv4len,
+		family == layers.IANAAddressFamilyIPV6 && len(addr) == net.IPv6len:
+		return net.IP(addr).String()
+	case family == layers.IANAAddressFamily802 && len(addr) == 6:
+		return net.HardwareAddr(addr).String()
+	}
+	return fmt.Sprintf("%x", addr)
+}
+
+// decodeLldp decodes an LLDPDU, returns nil in case it is malformed.
+func decodeLldp(d []byte) (*layers.LinkLayerDiscovery, *layers.LinkLayerDiscoveryInfo) {
+	pkt := gopacket.NewPacket(d, layers.LayerTypeLinkLayerDiscovery, gopacket.Default)
+	if pkt.ErrorLayer() != nil {
+		return nil, nil
+	}
+	lldp, ok := pkt.Layer(layers.LayerTypeLinkLayerDiscovery).(*layers.LinkLayerDiscovery)
+	if !ok {
+		return nil, nil
+	}
+	info, ok := pkt.Layer(layers.LayerTypeLinkLayerDiscoveryInfo).(*layers.LinkLayerDiscoveryInfo)
+	if !ok {
+		return nil, nil
+	}
+	return lldp, info
+}
+
+// handleRxLldp updates the neighbor table by a received LLDPDU.
+func (o *PluginLldpClient) handleRxLldp(srcMac []byte, lldp *layers.LinkLayerDiscovery, info *layers.LinkLayerDiscoveryInfo) {
+	o.stats.pktRx++
+	if lldp == nil {
+		o.stats.pktRxMalformed++
+		return
+	}
+
+	key := fmt.Sprintf("%d/%x/%d/%x", lldp.ChassisID.Subtype, lldp.ChassisID.ID, lldp.PortID.Subtype, lldp.PortID.ID)
+	n, ok := o.neighbors[key]
+	if lldp.TTL == 0 {
+		// shutdown LLDPDU
+		if ok {
+			o.stats.neighborShutdown++
+			o.timerw.Stop(&n.timer)
+			delete(o.neighbors, key)
+		}
+		return
+	}
+	if !ok {
+		if len(o.neighbors) >= maxNeighbors {
+			o.stats.neighborTblFull++
+			return
+		}
+		n = &lldpNeighbor{key: key}
+		n.timer.SetCB(n, o, nil)
+		o.neighbors[key] = n
+		o.stats.neighborAdd++
+	} else {
+		o.stats.neighborUpdate++
+		o.timerw.Stop(&n.timer)
+	}
+	o.timerw.Start(&n.timer, time.Duration(lldp.TTL)*time.Second)
+
+	rx := n.info.Rx + 1
+	n.info = LldpNeighbor{
+		ChassisIdType: uint8(lldp.ChassisID.Subtype),
+		ChassisId: lldpIdString(lldp.ChassisID.ID, lldp.ChassisID.Subtype == layers.LLDPChassisIDSubTypeMACAddr,
+			lldp.ChassisID.Subtype == layers.LLDPChassisIDSubTypeNetworkAddr),
+		PortIdType: uint8(lldp.PortID.Subtype),
+		PortId: lldpIdString(lldp.PortID.ID, lldp.PortID.Subtype == layers.LLDPPortIDSubtypeMACAddr,
+			lldp.PortID.Subtype == layers.LLDPPortIDSubtypeNetworkAddr),
+		Ttl:                 lldp.TTL,
+		SrcMac:              net.HardwareAddr(srcMac).String(),
+		PortDescription:     lldpString(info.PortDescription),
+		SystemName:          lldpString(info.SysName),
+		SystemDescription:   lldpString(info.SysDescription),
+		Capabilities:        lldpCapNames(info.SysCapabilities.SystemCap),
+		EnabledCapabilities: lldpCapNames(info.SysCapabilities.EnabledCap),
+		Rx:                  rx,
+	}
+	if len(info.MgmtAddress.Address) > 0 {
+		n.info.MgmtAddress = lldpAddrString(info.MgmtAddress.Subtype, info.MgmtAddress.Address)
+	}
+	if i8021, err := info.Decode8021(); err == nil {
+		n.info.Vlan = i8021.PVID
+	}
+}
+
+// GetNeighbors returns the neighbors of the client, sorted by Chassis ID and Port ID.
+func (o *PluginLldpClient) GetNeighbors() []LldpNeighbor {
+	res := make([]LldpNeighbor, 0, len(o.neighbors))
+	for _, n := range o.neighbors {
+		res = append(res, n.info)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].ChassisId != res[j].ChassisId {
+			return res[i].ChassisId < res[j].ChassisId
+		}
+		return res[i].PortId < res[j].PortId
+	})
+	return res
+}
+
+// flushNeighbors removes all the neighbors.
+func (o *PluginLldpClient) flushNeighbors() {
+	for key, n := range o.neighbors {
+		if n.timer.IsRunning() {
+			o.timerw.Stop(&n.timer)
+		}
+		delete(o.neighbors, key)
+	}
+}
+
+// HandleRxLldpPacket hands an LLDPDU to the clients of the namespace.
+func (o *PluginLldpNs) HandleRxLldpPacket(ps *core.ParserPacketState) int {
+	p := ps.M.GetData()
+	var srcMac core.MACKey
+	copy(srcMac[:], p[6:12])
+	lldp, info := decodeLldp(p[ps.L3:])
+	for _, c := range o.clients {
+		if c.Client.Mac == srcMac {
+			continue // our own LLDPDU
+		}
+		c.handleRxLldp(srcMac[:], lldp, info)
+	}
+	return core.PARSER_OK
+}
+
+// HandleRxLldpPacket Parser call this function with mbuf from the pool
+func HandleRxLldpPacket(ps *core.ParserPacketState) int {
+	ns := ps.Tctx.GetNs(ps.Tun)
+	if ns == nil {
+		return core.PARSER_ERR
+	}
+	nsplg := ns.PluginCtx.Get(LLDP_PLUG)
+	if nsplg == nil {
+		return core.PARSER_ERR
+	}
+	return nsplg.Ext.(*PluginLldpNs).HandleRxLldpPacket(ps)
+}
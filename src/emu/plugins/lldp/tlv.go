@@ -0,0 +1,311 @@
+// Copyright (c) 2021 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package lldp
+
+/*
+lldp structured TLVs
+
+The TLVs of the options are encoded after the mandatory TLVs, in this order, and before the raw options:
+
+	port_description, system_name, system_description, capabilities, mgmt_address,
+	port_vlan (802.1), power (802.3), med_capabilities and network_policy_<i> (LLDP-MED)
+
+Each TLV can have a field engine, the name of the engine is the name of the TLV. The engine of a string TLV generates
+the whole string, the zero padding is removed. The engine of any other TLV updates its information string at the
+engine offset, for an organizationally specific TLV the offset is after the OUI and the subtype.
+*/
+
+import (
+	"emu/core"
+	engines "emu/plugins/field_engine"
+	"encoding/binary"
+	"external/google/gopacket/layers"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/intel-go/fastjson"
+)
+
+const (
+	lldpMaxStrLen       = 255 // Maximal length of a string TLV
+	lldpMedCapsLldp     = 0x01
+	lldpMedCapsNetwork  = 0x02
+	lldpMedCapsPowerPD  = 0x10
+	lldpDefaultMedClass = 3 // Endpoint Class III, voice
+)
+
+// lldpCapBits are the bits of the system capabilities by name
+var lldpCapBits = map[string]uint16{
+	"other":        layers.LLDPCapsOther,
+	"repeater":     layers.LLDPCapsRepeater,
+	"bridge":       layers.LLDPCapsBridge,
+	"wlan_ap":      layers.LLDPCapsWLANAP,
+	"router":       layers.LLDPCapsRouter,
+	"phone":        layers.LLDPCapsPhone,
+	"docsis":       layers.LLDPCapsDocSis,
+	"station_only": layers.LLDPCapsStationOnly,
+	"cvlan":        layers.LLDPCapsCVLAN,
+	"svlan":        layers.LLDPCapsSVLAN,
+	"tpmr":         layers.LLDPCapsTmpr,
+}
+
+// LldpCapabilitiesT is the System Capabilities TLV, by the capability names of lldp_c_get_neighbors.
+type LldpCapabilitiesT struct {
+	System  []string `json:"system"`  // System capabilities
+	Enabled []string `json:"enabled"` // Enabled capabilities
+}
+
+// LldpMgmtAddressT is the Management Address TLV.
+type LldpMgmtAddressT struct {
+	Address   string `json:"address"`    // IPv4, IPv6 or MAC
+	IfSubtype uint8  `json:"if_subtype"` // Interface numbering subtype. Default to unknown (1)
+	IfNumber  uint32 `json:"if_number"`  // Interface number
+}
+
+// LldpPowerT is the 802.3 Power via MDI TLV. Type, source, priority, requested and allocated are the 802.3at extension,
+// it is added in case requested or allocated is set.
+type LldpPowerT struct {
+	Support   uint8  `json:"support"`   // MDI power support, bits of port class PSE, PSE supported, PSE enabled and pairs control
+	PsePair   uint8  `json:"pse_pair"`  // PSE power pair, signal (1) or spare (2). Default to signal
+	Class     uint8  `json:"class"`     // Power class, class 0 is 1
+	Type      uint8  `json:"type"`      // Power type, 0-3
+	Source    uint8  `json:"source"`    // Power source, 0-3
+	Priority  uint8  `json:"priority"`  // Power priority, 0-15
+	Requested uint16 `json:"requested"` // PD requested power in 0.1W
+	Allocated uint16 `json:"allocated"` // PSE allocated power in 0.1W
+}
+
+// LldpNetworkPolicyT is an LLDP-MED Network Policy TLV.
+type LldpNetworkPolicyT struct {
+	App      uint8  `json:"app"`      // Application type, e.g. voice (1)
+	Unknown  bool   `json:"unknown"`  // Unknown policy flag
+	Tagged   bool   `json:"tagged"`   // The VLAN is tagged
+	Vlan     uint16 `json:"vlan"`     // VLAN ID
+	Priority uint8  `json:"priority"` // L2 priority
+	Dscp     uint8  `json:"dscp"`     // DSCP
+}
+
+// lldpTlv is a TLV encoded by the plugin.
+type lldpTlv struct {
+	name   string                // Name of the TLV, the name of its engine
+	typ    layers.LLDPTLVType    // Type of the TLV
+	prefix []byte                // OUI and subtype of an organizationally specific TLV
+	value  []byte                // Information string, after the prefix
+	str    bool                  // A string TLV, the engine generates the whole value
+	eng    engines.FieldEngineIF // Field engine of the TLV, nil if there is no engine
+}
+
+// update runs the engine of the TLV.
+func (o *lldpTlv) update() {
+	if o.str {
+		b := make([]byte, o.eng.GetSize())
+		n, _ := o.eng.Update(b)
+		o.value = []byte(strings.TrimRight(string(b[:n]), "\x00"))
+		if len(o.value) > lldpMaxStrLen {
+			o.value = o.value[:lldpMaxStrLen]
+		}
+		return
+	}
+	o.eng.Update(o.value[o.eng.GetOffset() : o.eng.GetOffset()+o.eng.GetSize()])
+}
+
+// encode appends the TLV to b.
+func (o *lldpTlv) encode(b []byte) []byte {
+	l := len(o.prefix) + len(o.value)
+	b = append(b, byte(o.typ)<<1|byte(l>>8), byte(l))
+	b = append(b, o.prefix...)
+	return append(b, o.value...)
+}
+
+// newOrgTlv creates an organizationally specific TLV.
+func newOrgTlv(name string, oui layers.IEEEOUI, subtype uint8, value []byte) *lldpTlv {
+	prefix := []byte{byte(oui >> 16), byte(oui >> 8), byte(oui), subtype}
+	return &lldpTlv{name: name, typ: layers.LLDPTLVOrgSpecific, prefix: prefix, value: value}
+}
+
+// newStrTlv creates a string TLV.
+func newStrTlv(name string, typ layers.LLDPTLVType, s string) (*lldpTlv, error) {
+	if len(s) > lldpMaxStrLen {
+		return nil, fmt.Errorf("lldp %s is too long %d", name, len(s))
+	}
+	return &lldpTlv{name: name, typ: typ, value: []byte(s), str: true}, nil
+}
+
+// lldpCapValue converts capability names to bits.
+func lldpCapValue(names []string) (uint16, error) {
+	var v uint16
+	for _, name := range names {
+		bit, ok := lldpCapBits[name]
+		if !ok {
+			return 0, fmt.Errorf("lldp capability %s is not valid", name)
+		}
+		v |= bit
+	}
+	return v, nil
+}
+
+// mgmtAddressValue encodes the information string of a Management Address TLV.
+func mgmtAddressValue(o *LldpMgmtAddressT) ([]byte, error) {
+	var family layers.IANAAddressFamily
+	var addr []byte
+	if ip := net.ParseIP(o.Address); ip != nil {
+		if ipv4 := ip.To4(); ipv4 != nil {
+			family, addr = layers.IANAAddressFamilyIPV4, ipv4
+		} else {
+			family, addr = layers.IANAAddressFamilyIPV6, ip
+		}
+	} else if mac, err := net.ParseMAC(o.Address); err == nil && len(mac) == 6 {
+		family, addr = layers.IANAAddressFamily802, mac
+	} else {
+		return nil, fmt.Errorf("lldp management address %s is not valid", o.Address)
+	}
+	ifSubtype := o.IfSubtype
+	if ifSubtype == 0 {
+		ifSubtype = uint8(layers.LLDPInterfaceSubtypeUnknown)
+	}
+	b := []byte{byte(len(addr) + 1), byte(family)}
+	b = append(b, addr...)
+	b = append(b, ifSubtype, 0, 0, 0, 0, 0) // interface number and an empty OID
+	binary.BigEndian.PutUint32(b[len(b)-5:], o.IfNumber)
+	return b, nil
+}
+
+// powerValue encodes the information string of an 802.3 Power via MDI TLV.
+func powerValue(o *LldpPowerT) []byte {
+	pair, class := o.PsePair, o.Class
+	if pair == 0 {
+		pair = 1
+	}
+	if class == 0 {
+		class = 1
+	}
+	b := []byte{o.Support, pair, class}
+	if o.Requested > 0 || o.Allocated > 0 {
+		b = append(b, (o.Type&0x3)<<6|(o.Source&0x3)<<4|(o.Priority&0xf), 0, 0, 0, 0)
+		binary.BigEndian.PutUint16(b[4:6], o.Requested)
+		binary.BigEndian.PutUint16(b[6:8], o.Allocated)
+	}
+	return b
+}
+
+// networkPolicyValue encodes the information string of an LLDP-MED Network Policy TLV.
+func networkPolicyValue(o *LldpNetworkPolicyT) []byte {
+	// App(8) Unknown(1) Tagged(1) Reserved(1) VLAN(12) Priority(3) DSCP(6)
+	v := uint32(o.Vlan&0xfff)<<9 | uint32(o.Priority&0x7)<<6 | uint32(o.Dscp&0x3f)
+	if o.Unknown {
+		v |= 1 << 23
+	}
+	if o.Tagged {
+		v |= 1 << 22
+	}
+	return []byte{o.App, byte(v >> 16), byte(v >> 8), byte(v)}
+}
+
+// buildTlvs creates the TLVs of the options.
+func (o *LldpOptionsT) buildTlvs() ([]*lldpTlv, error) {
+	var tlvs []*lldpTlv
+	strs := []struct {
+		name string
+		typ  layers.LLDPTLVType
+		s    *string
+	}{
+		{"port_description", layers.LLDPTLVPortDescription, o.PortDescription},
+		{"system_name", layers.LLDPTLVSysName, o.SystemName},
+		{"system_description", layers.LLDPTLVSysDescription, o.SystemDescription},
+	}
+	for _, e := range strs {
+		if e.s == nil {
+			continue
+		}
+		tlv, err := newStrTlv(e.name, e.typ, *e.s)
+		if err != nil {
+			return nil, err
+		}
+		tlvs = append(tlvs, tlv)
+	}
+
+	if o.Capabilities != nil {
+		system, err := lldpCapValue(o.Capabilities.System)
+		if err != nil {
+			return nil, err
+		}
+		enabled, err := lldpCapValue(o.Capabilities.Enabled)
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, 4)
+		binary.BigEndian.PutUint16(value[0:2], system)
+		binary.BigEndian.PutUint16(value[2:4], enabled)
+		tlvs = append(tlvs, &lldpTlv{name: "capabilities", typ: layers.LLDPTLVSysCapabilities, value: value})
+	}
+
+	if o.MgmtAddress != nil {
+		value, err := mgmtAddressValue(o.MgmtAddress)
+		if err != nil {
+			return nil, err
+		}
+		tlvs = append(tlvs, &lldpTlv{name: "mgmt_address", typ: layers.LLDPTLVMgmtAddress, value: value})
+	}
+
+	if o.PortVlan != nil {
+		value := make([]byte, 2)
+		binary.BigEndian.PutUint16(value, *o.PortVlan)
+		tlvs = append(tlvs, newOrgTlv("port_vlan", layers.IEEEOUI8021, layers.LLDP8021SubtypePortVLANID, value))
+	}
+
+	if o.Power != nil {
+		tlvs = append(tlvs, newOrgTlv("power", layers.IEEEOUI8023, layers.LLDP8023SubtypeMDIPower, powerValue(o.Power)))
+	}
+
+	if len(o.NetworkPolicy) > 0 {
+		// LLDP-MED TLVs require the LLDP-MED Capabilities TLV
+		caps := uint16(lldpMedCapsLldp | lldpMedCapsNetwork)
+		if o.Power != nil {
+			caps |= lldpMedCapsPowerPD
+		}
+		class := o.MedClass
+		if class == 0 {
+			class = lldpDefaultMedClass
+		}
+		value := []byte{byte(caps >> 8), byte(caps), class}
+		tlvs = append(tlvs, newOrgTlv("med_capabilities", layers.IEEEOUIMedia, uint8(layers.LLDPMediaTypeCapabilities), value))
+		for i := range o.NetworkPolicy {
+			tlvs = append(tlvs, newOrgTlv(fmt.Sprintf("network_policy_%d", i), layers.IEEEOUIMedia,
+				uint8(layers.LLDPMediaTypeNetwork), networkPolicyValue(&o.NetworkPolicy[i])))
+		}
+	}
+	return tlvs, nil
+}
+
+// attachEngines creates the field engines and attaches each of them to its TLV.
+func attachEngines(tctx *core.CThreadCtx, data *fastjson.RawMessage, tlvs []*lldpTlv) error {
+	mgr, err := engines.NewEngineManager(tctx, data)
+	if err != nil {
+		return fmt.Errorf("could not create engine manager: %w", err)
+	}
+	for name, eng := range mgr.GetEngineMap() {
+		var tlv *lldpTlv
+		for _, t := range tlvs {
+			if t.name == name {
+				tlv = t
+				break
+			}
+		}
+		if tlv == nil {
+			return fmt.Errorf("Got engine for unexisting TLV %s", name)
+		}
+		if tlv.str {
+			if eng.GetSize() > lldpMaxStrLen {
+				return fmt.Errorf("Engine of TLV %s is too long %d", name, eng.GetSize())
+			}
+		} else if int(eng.GetOffset())+int(eng.GetSize()) > len(tlv.value) {
+			return fmt.Errorf("Engine of TLV %s is out of the TLV, offset %d size %d", name, eng.GetOffset(), eng.GetSize())
+		}
+		tlv.eng = eng
+	}
+	return nil
+}
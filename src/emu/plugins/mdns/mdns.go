@@ -1232,7 +1232,8 @@ func init() {
 	core.RegisterCB("mdns_ns_cache_flush", ApiMDnsCacheFlushHandler{}, false)         // flush the cache
 
 	/* register callback for rx side*/
-	core.ParserRegister(MDNS_PLUG, HandleRxMDnsPacket)
+	core.ParserRegister(MDNS_PLUG, HandleRxMDnsPacket,
+		core.ParserMatch{IPProto: uint8(layers.IPProtocolUDP), DstPort: 5353})
 }
 
 func Register(ctx *core.CThreadCtx) {
@@ -7,6 +7,7 @@ package point2point
 
 import (
 	"emu/core"
+	"external/google/gopacket/layers"
 	"external/osamingo/jsonrpc"
 	"github.com/intel-go/fastjson"
 )
@@ -15,6 +16,7 @@ type (
 	ApiClientGetPPPSessionID struct {}
 	ApiClientGetPPPClientIP struct {}
 	ApiClientGetPPPServerMac struct {}
+	ApiClientGetPPPClientIpv6 struct {}
 )
 
 /* ServeJSONRPC for ApiIcmpClientGetPingStatsHandler returns the statistics of an ongoing ping. If there is no ongoing ping
@@ -70,6 +72,23 @@ func (h ApiClientGetPPPServerMac) ServeJSONRPC(ctx interface{}, params *fastjson
 	return pppClient.GetPPPServerMac(), nil
 }
 
+func (h ApiClientGetPPPClientIpv6) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	tctx := ctx.(*core.CThreadCtx)
+	plug, err := tctx.GetClientPlugin(params, PPPPlugin)
+
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+
+	pppClient := plug.Ext.(*PluginPPPClient)
+
+	return pppClient.GetPPPClientIpv6(), nil
+}
+
 func init() {
 
 	/* register of plugins callbacks for ns,c level  */
@@ -82,9 +101,12 @@ func init() {
 	core.RegisterCB("ppp_c_client_session", ApiClientGetPPPSessionID{}, false)
 	core.RegisterCB("ppp_c_client_ip", ApiClientGetPPPClientIP{}, false)
 	core.RegisterCB("ppp_c_server_mac", ApiClientGetPPPServerMac{}, false)
+	core.RegisterCB("ppp_c_client_ipv6", ApiClientGetPPPClientIpv6{}, false)
 
-	/* register callback for rx side*/
-	core.ParserRegister("ppp", HandleRxPPPPacket)
+	/* register callback for rx side, the PPPoE frames are shared with the access concentrator that gets them first */
+	core.ParserRegisterShared("ppp", 0, HandleRxPPPPacket,
+		core.ParserMatch{EthType: uint16(layers.EthernetTypePPPoEDiscovery)},
+		core.ParserMatch{EthType: uint16(layers.EthernetTypePPPoESession)})
 }
 
 // Register is a common entry for TRex EMU shell?
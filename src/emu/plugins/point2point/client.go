@@ -7,7 +7,9 @@ package point2point
 
 import (
 	"bytes"
+	"crypto/md5"
 	"emu/core"
+	"emu/plugins/dot1x"
 	"encoding/binary"
 	"external/google/gopacket"
 	"external/google/gopacket/layers"
@@ -33,6 +35,16 @@ func (o *PluginPPPClient) GetPPPServerMac() string {
 	return o.serverMac.String()
 }
 
+// GetPPPClientIpv6 is a wrapper to return the PPP IPv6 link local to JSONRPC, empty until IPv6CP is opened
+func (o *PluginPPPClient) GetPPPClientIpv6() string {
+	if !o.ipv6Up {
+		return ""
+	}
+	var l6 core.Ipv6Key
+	o.Client.GetIpv6LocalLink(&l6)
+	return l6.ToIP().String()
+}
+
 var pppEvents = []string{}
 
 // NewPPPClient create plugin
@@ -70,6 +82,14 @@ func NewPPPClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error
 		o.timeout = 3
 	}
 
+	o.ipv6 = init.Ipv6
+	if o.ipv6 {
+		// propose the EUI-64 of the client as interface identifier
+		var l6 core.Ipv6Key
+		o.Client.GetIpv6LocalLink(&l6)
+		copy(o.localIfId[:], l6[8:])
+	}
+
 	return &o.PluginBase, nil
 }
 
@@ -305,6 +325,7 @@ func (o *PluginPPPClient) OnRemove(ctx *core.PluginCtx) {
 	if o.state == PPPStateLinkUp {
 		o.sendPADT()
 	}
+	o.onIpv6Down()
 
 	/* force removing the link to the client */
 	ctx.UnregisterEvents(&o.PluginBase, pppEvents)
@@ -356,6 +377,9 @@ func (o *PluginPPPClient) HandleRxPPPPacket(ps *core.ParserPacketState) int {
 		if o.isPPP(ps, layers.PPPTypePAP) {
 			// verify PAP Auth and move to PPPStateIPCPNegotiation
 			o.handlePAPRes(ps)
+		} else if o.isPPP(ps, layers.PPPTypeCHAP) {
+			// answer CHAP Challenge, verify CHAP Success and move to PPPStateIPCPNegotiation
+			o.handleCHAP(ps)
 		} else if o.isLCP(ps, layers.LCPTypeConfigurationRequest) {
 			o.handleLCPNegotiation(ps)
 		} else if o.isLCP(ps, layers.LCPTypeEchoRequest) {
@@ -366,6 +390,8 @@ func (o *PluginPPPClient) HandleRxPPPPacket(ps *core.ParserPacketState) int {
 		// Parsing PPP IPCP pkts
 		if o.isPPP(ps, layers.PPPTypeIPCP) {
 			o.handleIPCPNegotiation(ps)
+		} else if o.isPPP(ps, layers.PPPTypeIPV6CP) {
+			o.handleIPV6CPNegotiation(ps)
 		} else if o.isLCP(ps, layers.LCPTypeEchoRequest) {
 			o.answerLCPEcho(ps)
 		}
@@ -377,10 +403,13 @@ func (o *PluginPPPClient) HandleRxPPPPacket(ps *core.ParserPacketState) int {
 			LogTimeFormatted(INFO, ">> PPPStateLinkUp >> IPCP on Mac %v -> Ack with negotiated IP %v",
 				o.Client.Mac, o.negClientIP)
 			o.handleIPCPNegotiation(ps)
+		} else if o.isPPP(ps, layers.PPPTypeIPV6CP) {
+			o.handleIPV6CPNegotiation(ps)
 		} else if o.isLCP(ps, layers.LCPTypeEchoRequest) {
 			o.answerLCPEcho(ps)
 		} else if o.isLCP(ps, layers.LCPTypeTerminateRequest) {
 			o.answerLCPTerminate(ps)
+			o.onIpv6Down()
 			o.sendPADT()
 			o.state = PPPStatePADTSent
 		}
@@ -581,8 +610,23 @@ func (o *PluginPPPClient) handleLCPNegotiation(ps *core.ParserPacketState) {
 					o.peerMagicNumber = make([]byte, 4)
 					copy(o.peerMagicNumber, option.Value[0:])
 				} else if option.Type == layers.LCPOptionTypeAuthenticationProtocol {
-					tmp := binary.BigEndian.Uint16(option.Value[0:])
-					o.authMethod = layers.PPPType(tmp)
+					var method layers.PPPType
+					var algorithm uint8
+					if len(option.Value) >= 2 {
+						method = layers.PPPType(binary.BigEndian.Uint16(option.Value[0:]))
+					}
+					if method == layers.PPPTypeCHAP && len(option.Value) > 2 {
+						algorithm = option.Value[2]
+					}
+					if !isAuthSupported(method, algorithm) {
+						// suggest CHAP with MD5, the peer sends a new request
+						LogTimeFormatted(WARNING, ">> PluginPPPClient.handleLCPNegotiation >> Unsupported auth %#x/%#x on Mac %v",
+							uint16(method), algorithm, o.Client.Mac)
+						o.sendLCPMsg(layers.LCPTypeConfigurationNak, lcp.Identifier)
+						return
+					}
+					o.authMethod = method
+					o.authAlgorithm = algorithm
 				}
 			}
 		}
@@ -596,6 +640,17 @@ func (o *PluginPPPClient) handleLCPNegotiation(ps *core.ParserPacketState) {
 	o.evaluateLCPNegotiationOver()
 }
 
+// isAuthSupported returns true if the client can authenticate with this protocol
+func isAuthSupported(method layers.PPPType, algorithm uint8) bool {
+	switch method {
+	case layers.PPPTypePAP:
+		return true
+	case layers.PPPTypeCHAP:
+		return algorithm == layers.CHAPAlgorithmMD5 || algorithm == layers.CHAPAlgorithmMSCHAPv2
+	}
+	return false
+}
+
 func (o *PluginPPPClient) evaluateLCPNegotiationOver() {
 
 	if o.lcpAckSent && o.lcpAckReceived {
@@ -705,6 +760,10 @@ func (o *PluginPPPClient) sendLCPMsg(lcpCode layers.LCPType, identifier uint8) {
 	case layers.LCPTypeConfigurationAck:
 		authMethod := make([]byte, 2)
 		binary.BigEndian.PutUint16(authMethod[0:], uint16(o.authMethod))
+		if o.authMethod == layers.PPPTypeCHAP {
+			// CHAP carries the algorithm after the protocol
+			authMethod = append(authMethod, o.authAlgorithm)
+		}
 		lcp = &layers.LCP{
 			Code:       layers.LCPTypeConfigurationAck,
 			Identifier: identifier,
@@ -722,7 +781,24 @@ func (o *PluginPPPClient) sendLCPMsg(lcpCode layers.LCPType, identifier uint8) {
 				},
 				{
 					Type:   layers.LCPOptionTypeAuthenticationProtocol,
-					Length: 0x4,
+					Length: uint8(2 + len(authMethod)),
+					Value:  authMethod,
+				},
+			},
+		}
+	case layers.LCPTypeConfigurationNak:
+		// the only option we Nak is the authentication protocol
+		authMethod := make([]byte, 3)
+		binary.BigEndian.PutUint16(authMethod[0:], uint16(layers.PPPTypeCHAP))
+		authMethod[2] = layers.CHAPAlgorithmMD5
+		lcp = &layers.LCP{
+			Code:       layers.LCPTypeConfigurationNak,
+			Identifier: identifier,
+			Length:     0, // filled after
+			Options: []layers.LCPOption{
+				{
+					Type:   layers.LCPOptionTypeAuthenticationProtocol,
+					Length: uint8(2 + len(authMethod)),
 					Value:  authMethod,
 				},
 			},
@@ -845,6 +921,10 @@ func (o *PluginPPPClient) handleIPCPNegotiation(ps *core.ParserPacketState) {
 				o.Client.Mac, o.negClientIP, o.pppSessionID)
 			copy(o.clientIP[:], ipcp.GetProposedIPAddress())
 			o.state = PPPStateLinkUp
+			if o.ipv6 && !o.ipv6Up {
+				// keep the timer for IPv6CP retransmission
+				o.restartTimer(o.minTimerRetransmitSec)
+			}
 		} else {
 			// get proposed IP Address as candidate
 			copy(o.negClientIP[:], ipcp.GetProposedIPAddress())
@@ -915,3 +995,222 @@ func (o *PluginPPPClient) sendIPCPConfReq() {
 func (o *PluginPPPClient) sendIPCPAck(identifier uint8) {
 	o.sendIPCPMsg(layers.IPCPTypeConfigurationAck, identifier)
 }
+
+func (o *PluginPPPClient) handleCHAP(ps *core.ParserPacketState) {
+	m := ps.M
+	p := m.GetData()
+
+	tmp := gopacket.NewPacket(p, layers.LayerTypeEthernet, gopacket.Default)
+
+	// CHAP
+	chapLayer := tmp.Layer(layers.LayerTypeCHAP)
+	chap, ok := chapLayer.(*layers.CHAP)
+	if !ok {
+		LogTimeFormatted(WARNING, ">> PluginPPPClient.handleCHAP >> Malformed CHAP on Mac %v", o.Client.Mac)
+		return
+	}
+
+	switch chap.Code {
+	case layers.CHAPTypeChallenge:
+		o.sendCHAPResponse(chap)
+	case layers.CHAPTypeSuccess:
+		if o.authAlgorithm == layers.CHAPAlgorithmMSCHAPv2 {
+			// the authenticator proves it knows the password too, "S=<auth_string> M=<message>"
+			if !bytes.HasPrefix(chap.Message, []byte(o.authResponse)) {
+				LogTimeFormatted(WARNING, ">> PluginPPPClient.handleCHAP >> Bad MS-CHAPv2 Authenticator Response on Mac %v",
+					o.Client.Mac)
+				return
+			}
+		}
+		o.state = PPPStateIPCPNegotiation
+		o.restartTimer(o.minTimerRetransmitSec)
+	case layers.CHAPTypeFailure:
+		LogTimeFormatted(WARNING, ">> PluginPPPClient.handleCHAP >> CHAP Failure [%s] on Mac %v",
+			string(chap.Message), o.Client.Mac)
+	}
+}
+
+// sendCHAPResponse answers a CHAP Challenge with the MD5 or MS-CHAPv2 response
+func (o *PluginPPPClient) sendCHAPResponse(challenge *layers.CHAP) {
+	var value []byte
+
+	switch o.authAlgorithm {
+	case layers.CHAPAlgorithmMD5:
+		// rfc1994, MD5 of Identifier, secret and Challenge
+		h := md5.New()
+		h.Write([]byte{challenge.Identifier})
+		h.Write([]byte(o.password))
+		h.Write(challenge.Value)
+		value = h.Sum(nil)
+	case layers.CHAPAlgorithmMSCHAPv2:
+		if len(challenge.Value) != 16 {
+			LogTimeFormatted(WARNING, ">> PluginPPPClient.sendCHAPResponse >> Bad MS-CHAPv2 Challenge size %d on Mac %v",
+				len(challenge.Value), o.Client.Mac)
+			return
+		}
+		peerChallenge := make([]byte, 16)
+		if o.Tctx.Simulation {
+			copy(peerChallenge, []byte{1, 2, 3, 4, 5, 6, 7, 8, 1, 2, 3, 4, 5, 6, 7, 8})
+		} else {
+			rand.Read(peerChallenge)
+		}
+		res, err := dot1x.Encryptv2(challenge.Value, peerChallenge, o.userID, o.password)
+		if err != nil {
+			LogTimeFormatted(WARNING, ">> PluginPPPClient.sendCHAPResponse >> MS-CHAPv2 error %v on Mac %v",
+				err, o.Client.Mac)
+			return
+		}
+		// rfc2759, Peer-Challenge, 8 reserved octets, NT-Response and Flags
+		value = append(value, peerChallenge...)
+		value = append(value, 0, 0, 0, 0, 0, 0, 0, 0)
+		value = append(value, res.ChallengeResponse...)
+		value = append(value, 0)
+		o.authResponse = res.AuthenticatorResponse
+	default:
+		// LCP Naks the unsupported algorithms, drop the Challenge anyway
+		LogTimeFormatted(ERROR, ">> PluginPPPClient.sendCHAPResponse >> Unsupported CHAP Algorithm %#x on Mac %v",
+			o.authAlgorithm, o.Client.Mac)
+		return
+	}
+
+	// PPPoES
+	pppoes := &layers.PPPoE{
+		Version:   0x1,
+		Type:      0x1,
+		Code:      layers.PPPoECodeSession,
+		SessionID: o.pppSessionID,
+		Length:    0x0, // filled in next lines of code
+		Tags:      []layers.PPPoEDTag{},
+	}
+	// PPP
+	ppp := &layers.PPP{
+		PPPType: layers.PPPTypeCHAP,
+	}
+	// CHAP
+	chap := &layers.CHAP{
+		Code:       layers.CHAPTypeResponse,
+		Identifier: challenge.Identifier,
+		Value:      value,
+		Name:       []byte(o.userID),
+	}
+	chap.Length = chap.GetCHAPSize()
+	pppoes.Length = chap.Length + 2 // PPP layer size in byte
+
+	// build raw CHAP with layerTwoSession structure and send it
+	tmpChap := append(o.layerTwoSession, pppoes, ppp, chap)
+	rawChap := core.PacketUtlBuild(tmpChap...)
+
+	o.restartTimer(o.maxTimerRetransmitSec)
+	o.Tctx.Veth.SendBuffer(false, o.Client, rawChap, false)
+}
+
+func (o *PluginPPPClient) handleIPV6CPNegotiation(ps *core.ParserPacketState) {
+	if !o.ipv6 {
+		return
+	}
+	m := ps.M
+	p := m.GetData()
+
+	tmp := gopacket.NewPacket(p, layers.LayerTypeEthernet, gopacket.Default)
+
+	// IPV6CP
+	ipv6cpLayer := tmp.Layer(layers.LayerTypeIPV6CP)
+	ipv6cp, ok := ipv6cpLayer.(*layers.IPV6CP)
+	if !ok {
+		LogTimeFormatted(WARNING, ">> PluginPPPClient.handleIPV6CPNegotiation >> Malformed IPV6CP on Mac %v", o.Client.Mac)
+		return
+	}
+	ifId := ipv6cp.GetInterfaceIdentifier()
+
+	switch ipv6cp.Code {
+	case layers.IPV6CPTypeConfigurationRequest:
+		if ifId != nil {
+			copy(o.peerIfId[:], ifId)
+		}
+		o.sendIPV6CPMsg(layers.IPV6CPTypeConfigurationAck, ipv6cp.Identifier, o.peerIfId)
+		o.ipv6cpAckSent = true
+	case layers.IPV6CPTypeConfigurationAck:
+		if ifId != nil && bytes.Equal(o.localIfId[:], ifId) {
+			o.ipv6cpAckReceived = true
+		}
+	case layers.IPV6CPTypeConfigurationNak:
+		// the peer suggests another interface identifier
+		if ifId != nil {
+			copy(o.localIfId[:], ifId)
+		}
+		o.sendIPV6CPConfReq()
+	case layers.IPV6CPTypeConfigurationReject:
+		LogTimeFormatted(WARNING, ">> PluginPPPClient.handleIPV6CPNegotiation >> IPV6CP rejected on Mac %v",
+			o.Client.Mac)
+		o.ipv6 = false
+	}
+
+	if o.ipv6cpAckSent && o.ipv6cpAckReceived && !o.ipv6Up {
+		o.onIpv6Up()
+	}
+}
+
+// onIpv6Up hands the session and the negotiated link local to the client, so the ipv6/dhcpv6 plugins run over it
+func (o *PluginPPPClient) onIpv6Up() {
+	if err := o.Ns.UpdateClientIpv6IfId(o.Client, o.localIfId); err != nil {
+		LogTimeFormatted(ERROR, ">> PluginPPPClient.onIpv6Up >> %v on Mac %v", err, o.Client.Mac)
+		return
+	}
+	o.Client.SetPPPoE(&core.CClientPPPoE{SessionId: o.pppSessionID, ServerMac: o.serverMac})
+	o.ipv6Up = true
+	LogTimeFormatted(INFO, "Client on Mac %v has IPv6 interface identifier %x with PPP Id %04X !",
+		o.Client.Mac, o.localIfId, o.pppSessionID)
+}
+
+// onIpv6Down detaches the client from the session
+func (o *PluginPPPClient) onIpv6Down() {
+	if !o.ipv6Up {
+		return
+	}
+	o.ipv6Up = false
+	o.Client.SetPPPoE(nil)
+}
+
+// sendIPV6CPMsg is a generic function to send IPV6CP messages
+func (o *PluginPPPClient) sendIPV6CPMsg(ipv6cpCode2Send layers.IPV6CPType, identifier uint8, ifId [8]byte) {
+	// PPPoES
+	pppoes := &layers.PPPoE{
+		Version:   0x1,
+		Type:      0x1,
+		Code:      layers.PPPoECodeSession,
+		SessionID: o.pppSessionID,
+		Length:    0x0, // filled in next lines of code
+		Tags:      []layers.PPPoEDTag{},
+	}
+	// PPP
+	ppp := &layers.PPP{
+		PPPType: layers.PPPTypeIPV6CP,
+	}
+	// IPV6CP
+	ipv6cp := &layers.IPV6CP{
+		Code:       ipv6cpCode2Send,
+		Identifier: identifier,
+		Length:     0x0, // filled in next lines of code
+		Options: []layers.IPV6CPOption{
+			{
+				Type:   layers.IPV6CPOptionTypeInterfaceIdentifier,
+				Length: 0xa,
+				Value:  ifId[:],
+			},
+		},
+	}
+	ipv6cp.Length = ipv6cp.GetIPV6CPSize()
+	pppoes.Length = ipv6cp.Length + 2 // PPP layer size in byte
+
+	// build raw IPV6CP Msg with layerTwoSession structure and send it
+	tmpIpv6cpMsg := append(o.layerTwoSession, pppoes, ppp, ipv6cp)
+	rawIpv6cpMsg := core.PacketUtlBuild(tmpIpv6cpMsg...)
+
+	o.restartTimer(o.maxTimerRetransmitSec)
+	o.Tctx.Veth.SendBuffer(false, o.Client, rawIpv6cpMsg, false)
+}
+
+func (o *PluginPPPClient) sendIPV6CPConfReq() {
+	o.ipv6cpSendCounter++
+	o.sendIPV6CPMsg(layers.IPV6CPTypeConfigurationRequest, o.ipv6cpSendCounter, o.localIfId)
+}
@@ -65,6 +65,8 @@ func (o *PluginPPPClient) onTimerEvent() {
 		// send Again PAP Request
 		if o.authMethod == layers.PPPTypePAP {
 			o.sendPAPReq()
+		} else if o.authMethod == layers.PPPTypeCHAP {
+			// CHAP is driven by the Challenge of the authenticator, nothing to send
 		} else {
 			msg := fmt.Sprintf("Unsupported Authentication Protocol on Mac %v", o.Client.Mac)
 			LogTimeFormatted(ERROR, msg)
@@ -73,7 +75,14 @@ func (o *PluginPPPClient) onTimerEvent() {
 	case PPPStateIPCPNegotiation:
 		// send Again IPCP configuration request/ack
 		o.sendIPCPConfReq()
+		if o.ipv6 && !o.ipv6cpAckReceived {
+			o.sendIPV6CPConfReq()
+		}
 	case PPPStateLinkUp:
+		// IPv6CP might still be negotiated
+		if o.ipv6 && !o.ipv6Up {
+			o.sendIPV6CPConfReq()
+		}
 		// send LCP Echo Request
 		//o.SendLCPEchoRequest()
 	case PPPStatePADTSent:
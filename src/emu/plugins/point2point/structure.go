@@ -29,7 +29,7 @@ const (
 	PPPStatePADS PluginState = 4
 	// PPPStateLCPNegotiation describes LCP negotiation
 	PPPStateLCPNegotiation PluginState = 5
-	// PPPStatePAPSent describes sent PAP
+	// PPPStatePAPSent describes authentication phase, sent PAP or waiting for CHAP Challenge
 	PPPStatePAPSent PluginState = 6
 	// PPPStateIPCPNegotiation describes IPCP negotiation
 	PPPStateIPCPNegotiation PluginState = 7
@@ -46,6 +46,7 @@ type PPPInit struct {
 	UserID   string `json:"user"`
 	Password string `json:"password"`
 	Timeout  uint8  `json:"timeout"`
+	Ipv6     bool   `json:"ipv6"` // negotiate IPv6CP and run IPv6 over the session
 }
 
 // PluginPPPClient information per client
@@ -69,17 +70,26 @@ type PluginPPPClient struct {
 	localMagicNumber      []byte
 	peerMagicNumber       []byte
 	authMethod            layers.PPPType
+	authAlgorithm         uint8  // CHAP algorithm, MD5 or MS-CHAPv2
+	authResponse          string // expected MS-CHAPv2 Authenticator Response
 	lcpSendCounter        uint8
 	ipcpSendCounter       uint8
 	lcpAckSent            bool
 	lcpAckReceived        bool
 	maxRecUnitBytes       []byte
-	negClientIP           core.Ipv4Key     // this variable is used during IPCP Negotiation
-	rcvByNak              bool             // IP negotiated is received by Nak
-	clientIP              core.Ipv4Key     // this variable is final IP assigned to client
+	negClientIP           core.Ipv4Key // this variable is used during IPCP Negotiation
+	rcvByNak              bool         // IP negotiated is received by Nak
+	clientIP              core.Ipv4Key // this variable is final IP assigned to client
 	timerCb               PluginPPPClientTimer
 	vlanTagged            bool
-	layerTwoDiscovery     []gopacket.SerializableLayer    // support array during PPPoED
-	layerTwoSession       []gopacket.SerializableLayer    // support array during PPPoES
+	layerTwoDiscovery     []gopacket.SerializableLayer // support array during PPPoED
+	layerTwoSession       []gopacket.SerializableLayer // support array during PPPoES
 	padtSent              uint8
-}
\ No newline at end of file
+	ipv6                  bool // IPv6CP is negotiated
+	ipv6cpSendCounter     uint8
+	ipv6cpAckSent         bool
+	ipv6cpAckReceived     bool
+	ipv6Up                bool    // IPv6CP is opened and the session carries the IPv6 of the client
+	localIfId             [8]byte // interface identifier negotiated by IPv6CP
+	peerIfId              [8]byte
+}
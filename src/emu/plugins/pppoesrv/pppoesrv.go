@@ -0,0 +1,1537 @@
+/*
+Copyright (c) 2021 Cisco Systems and/or its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+that can be found in the LICENSE file in the root of the source
+tree.
+*/
+
+package pppoesrv
+
+import (
+	"bytes"
+	"crypto/md5"
+	"emu/core"
+	"emu/plugins/dot1x"
+	"encoding/binary"
+	"encoding/hex"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"external/osamingo/jsonrpc"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/intel-go/fastjson"
+)
+
+/*
+PPPoE Access Concentrator - https://datatracker.ietf.org/doc/html/rfc2516
+
+PPP - https://datatracker.ietf.org/doc/html/rfc1661, PAP/CHAP - rfc1334/rfc1994, MS-CHAPv2 - rfc2759,
+IPCP - rfc1332/rfc1877, IPv6CP - rfc5072
+
+The access concentrator answers the PADI/PADR of the clients in its namespace and opens a session per PADR. On each
+session it negotiates LCP, authenticates the peer by PAP, CHAP-MD5 or MS-CHAPv2 against the users of its init JSON,
+and negotiates IPCP with an address of its pool. IPv6CP is negotiated in case the peer asks for it. An opened session
+is kept alive by LCP Echo-Request, and is terminated by LCP Terminate-Request and PADT.
+
+The PPPoE frames are shared with the ppp client, the access concentrator gets the frames first and passes the frames
+that are not for an access concentrator to the client.
+
+Limitations
+ - The access concentrator does not forward IP, the sessions carry only the control protocols.
+ - A Nak/Reject of the LCP options of the access concentrator is not negotiated, the request is retransmitted.
+ - A PADI is answered by all the access concentrators of the namespace that serve its Service-Name.
+*/
+
+const (
+	PPPOE_SRV_PLUG      = "pppoesrv"
+	DefaultACName       = "trex-emu"
+	DefaultMRU          = 1492
+	DefaultEchoInterval = 10     // Default seconds between LCP Echo-Requests of an opened session
+	DefaultEchoFailures = 3      // Default unanswered LCP Echo-Requests before the session is terminated
+	RestartTimer        = 3      // rfc1661 Restart timer in seconds
+	MaxConfigure        = 10     // rfc1661 Max-Configure
+	MaxTerminate        = 2      // rfc1661 Max-Terminate
+	MaxSessionId        = 0xfffe // Session ids are in [1, MaxSessionId]
+	AuthPAP             = "pap"
+	AuthCHAP            = "chap"
+	AuthMSCHAPv2        = "mschapv2"
+	sessionLCP          = 0 // LCP is negotiated
+	sessionAuth         = 1 // The peer is authenticated
+	sessionNetwork      = 2 // IPCP and IPv6CP are negotiated
+	sessionOpened       = 3 // IPCP or IPv6CP is opened
+	sessionTerminating  = 4 // LCP Terminate-Request was sent
+)
+
+var sessionStateNames = []string{"lcp", "auth", "network", "opened", "terminating"}
+
+/*======================================================================================================
+											Stats
+======================================================================================================*/
+
+// PPPoESrvStats is a struct that consolidates all the counters of a PPPoESrv.
+type PPPoESrvStats struct {
+	invalidInitJson     uint64 // Error while decoding client init Json
+	activeSessions      uint64 // Sessions that were not terminated
+	pktRx               uint64 // Num packets received
+	pktRxParserErr      uint64 // Num packets that could not be decoded
+	pktRxPadi           uint64 // Num of PADI packets received
+	pktRxPadr           uint64 // Num of PADR packets received
+	pktRxPadt           uint64 // Num of PADT packets received
+	pktRxBadCode        uint64 // Num of discovery packets with an unexpected code
+	pktRxBadServiceName uint64 // Num of PADI/PADR with a Service-Name that is not served
+	pktRxUnknownSession uint64 // Num of session packets of an unknown session
+	pktRxProtoRejected  uint64 // Num of session packets of an unsupported PPP protocol
+	pktTx               uint64 // Num packets transmitted
+	pktTxPado           uint64 // Num of PADO packets sent
+	pktTxPads           uint64 // Num of PADS packets sent
+	pktTxPadt           uint64 // Num of PADT packets sent
+	lcpOpened           uint64 // Num of sessions that opened LCP
+	authSuccess         uint64 // Num of peers that were authenticated
+	authFailure         uint64 // Num of peers that failed to authenticate
+	ipcpOpened          uint64 // Num of sessions that opened IPCP
+	ipv6cpOpened        uint64 // Num of sessions that opened IPv6CP
+	noSessionAvailable  uint64 // Num of PADR that were not answered since there is no free session
+	noAddrAvailable     uint64 // Num of sessions that were terminated since the pool is empty
+	negotiationTimeout  uint64 // Num of sessions that were terminated since the negotiation did not end
+	echoTimeout         uint64 // Num of sessions that were terminated since the Echo-Requests were not answered
+	sessionsKilled      uint64 // Num of sessions that were terminated by RPC
+}
+
+// NewPPPoESrvStatsDb creates a new database of PPPoESrv counters.
+func NewPPPoESrvStatsDb(o *PPPoESrvStats) *core.CCounterDb {
+	db := core.NewCCounterDb(PPPOE_SRV_PLUG)
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.invalidInitJson,
+		Name:     "invalidInitJson",
+		Help:     "Error while decoding init Json",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.activeSessions,
+		Name:     "activeSessions",
+		Help:     "Num of active sessions",
+		Unit:     "sessions",
+		DumpZero: true,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRx,
+		Name:     "pktRx",
+		Help:     "Rx packets",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxParserErr,
+		Name:     "pktRxParserErr",
+		Help:     "Rx PPPoE packet decode error",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxPadi,
+		Name:     "pktRxPadi",
+		Help:     "Rx PADI",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxPadr,
+		Name:     "pktRxPadr",
+		Help:     "Rx PADR",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxPadt,
+		Name:     "pktRxPadt",
+		Help:     "Rx PADT",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxBadCode,
+		Name:     "pktRxBadCode",
+		Help:     "Rx discovery packet with an unexpected code",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxBadServiceName,
+		Name:     "pktRxBadServiceName",
+		Help:     "Rx PADI/PADR with a Service-Name that is not served",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxUnknownSession,
+		Name:     "pktRxUnknownSession",
+		Help:     "Rx packet of an unknown session",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxProtoRejected,
+		Name:     "pktRxProtoRejected",
+		Help:     "Rx packet of an unsupported PPP protocol",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTx,
+		Name:     "pktTx",
+		Help:     "Tx packets",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxPado,
+		Name:     "pktTxPado",
+		Help:     "Tx PADO",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxPads,
+		Name:     "pktTxPads",
+		Help:     "Tx PADS",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxPadt,
+		Name:     "pktTxPadt",
+		Help:     "Tx PADT",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.lcpOpened,
+		Name:     "lcpOpened",
+		Help:     "Sessions that opened LCP",
+		Unit:     "sessions",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.authSuccess,
+		Name:     "authSuccess",
+		Help:     "Peers that were authenticated",
+		Unit:     "sessions",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.authFailure,
+		Name:     "authFailure",
+		Help:     "Peers that failed to authenticate",
+		Unit:     "sessions",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.ipcpOpened,
+		Name:     "ipcpOpened",
+		Help:     "Sessions that opened IPCP",
+		Unit:     "sessions",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.ipv6cpOpened,
+		Name:     "ipv6cpOpened",
+		Help:     "Sessions that opened IPv6CP",
+		Unit:     "sessions",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.noSessionAvailable,
+		Name:     "noSessionAvailable",
+		Help:     "PADR that was not answered since there is no free session",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.noAddrAvailable,
+		Name:     "noAddrAvailable",
+		Help:     "Sessions that were terminated since the pool is empty",
+		Unit:     "sessions",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.negotiationTimeout,
+		Name:     "negotiationTimeout",
+		Help:     "Sessions that were terminated since the negotiation did not end",
+		Unit:     "sessions",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.echoTimeout,
+		Name:     "echoTimeout",
+		Help:     "Sessions that were terminated since the Echo-Requests were not answered",
+		Unit:     "sessions",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.sessionsKilled,
+		Name:     "sessionsKilled",
+		Help:     "Sessions that were terminated by RPC",
+		Unit:     "sessions",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	return db
+}
+
+/*======================================================================================================
+										Ipv4 Pool
+======================================================================================================*/
+
+// Ipv4RangePool allocates the addresses in [min, max], the released addresses are reused first.
+type Ipv4RangePool struct {
+	min      uint32         // First address of the pool
+	size     uint64         // Num of addresses in the pool
+	next     uint64         // Next index that was never allocated
+	free     []core.Ipv4Key // Released addresses
+	excluded core.Ipv4Key   // Address that is never allocated, the address of the access concentrator
+}
+
+// CreateIpv4RangePool creates a pool of the addresses in [min, max] without excluded.
+func CreateIpv4RangePool(min, max, excluded core.Ipv4Key) (*Ipv4RangePool, error) {
+	if max.Uint32() < min.Uint32() {
+		return nil, fmt.Errorf("Pool max %v is lower than min %v", max.ToIP(), min.ToIP())
+	}
+	o := new(Ipv4RangePool)
+	o.min = min.Uint32()
+	o.size = uint64(max.Uint32()-min.Uint32()) + 1
+	o.excluded = excluded
+	return o, nil
+}
+
+// Get returns a free address, false in case the pool is empty.
+func (o *Ipv4RangePool) Get() (ipv4 core.Ipv4Key, ok bool) {
+	if n := len(o.free); n > 0 {
+		ipv4 = o.free[n-1]
+		o.free = o.free[:n-1]
+		return ipv4, true
+	}
+	for o.next < o.size {
+		ipv4.SetUint32(o.min + uint32(o.next))
+		o.next++
+		if ipv4 != o.excluded {
+			return ipv4, true
+		}
+	}
+	return ipv4, false
+}
+
+// Put returns an address to the pool.
+func (o *Ipv4RangePool) Put(ipv4 core.Ipv4Key) {
+	o.free = append(o.free, ipv4)
+}
+
+/*======================================================================================================
+										PPPoE Session
+======================================================================================================*/
+
+// PPPoESessionStats are the counters of one session.
+type PPPoESessionStats struct {
+	PktRx       uint64 `json:"pkt_rx"`      // Packets received on the session
+	PktTx       uint64 `json:"pkt_tx"`      // Packets sent on the session
+	EchoTx      uint64 `json:"echo_tx"`     // LCP Echo-Requests sent
+	EchoRx      uint64 `json:"echo_rx"`     // LCP Echo-Replies received
+	Retransmits uint64 `json:"retransmits"` // Requests that were retransmitted
+}
+
+// PPPoESession is a session of the access concentrator with one peer.
+type PPPoESession struct {
+	srv             *PluginPPPoESrvClient // Back pointer to the access concentrator
+	timerw          *core.TimerCtx        // Timer wheel
+	timer           core.CHTimerObj       // Retransmission, keepalive and termination timer
+	id              uint16                // Session id
+	mac             core.MACKey           // MAC of the peer
+	state           uint8                 // State of the session
+	user            string                // Authenticated user
+	peerMagic       []byte                // Magic-Number of the peer
+	identifier      uint8                 // Identifier of the last request of the access concentrator
+	retransmits     uint32                // Requests without an answer
+	lcpAckSent      bool                  // The LCP request of the peer was acked
+	lcpAckRcvd      bool                  // The LCP request of the access concentrator was acked
+	challenge       []byte                // Last CHAP Challenge
+	challengeId     uint8                 // Identifier of the last CHAP Challenge
+	authResponse    []byte                // Last Success/Failure, sent again for a retransmitted response
+	authMsg         layers.PPPType        // PAP or CHAP of authResponse
+	hasIpv4         bool                  // An address was allocated
+	ipv4            core.Ipv4Key          // Address of the peer
+	ipcpAckSent     bool                  // The IPCP request of the peer was acked
+	ipcpAckRcvd     bool                  // The IPCP request of the access concentrator was acked
+	ipcpIdentifier  uint8                 // Identifier of the last IPCP request of the access concentrator
+	ipv6cp          bool                  // The peer negotiates IPv6CP
+	ipv6cpAckSent   bool                  // The IPv6CP request of the peer was acked
+	ipv6cpAckRcvd   bool                  // The IPv6CP request of the access concentrator was acked
+	ipv6Identifier  uint8                 // Identifier of the last IPv6CP request of the access concentrator
+	peerIfId        [8]byte               // Interface identifier of the peer
+	echoOutstanding uint32                // Echo-Requests without a reply
+	stats           PPPoESessionStats     // Counters of the session
+}
+
+// newPPPoESession creates a session when the access concentrator answers a PADR.
+func newPPPoESession(srv *PluginPPPoESrvClient, id uint16, mac core.MACKey) *PPPoESession {
+	o := new(PPPoESession)
+	o.srv = srv
+	o.timerw = srv.Tctx.GetTimerCtx()
+	o.id = id
+	o.mac = mac
+	o.state = sessionLCP
+	o.timer.SetCB(o, nil, nil)
+	return o
+}
+
+func (o *PPPoESession) restartTimer(sec uint32) {
+	if o.timer.IsRunning() {
+		o.timerw.Stop(&o.timer)
+	}
+	o.timerw.StartTicks(&o.timer, o.timerw.DurationToTicks(time.Duration(sec)*time.Second))
+}
+
+// OnEvent is called by the timer, it retransmits the pending requests or sends an Echo-Request.
+func (o *PPPoESession) OnEvent(a, b interface{}) {
+	srv := o.srv
+	switch o.state {
+	case sessionOpened:
+		if o.echoOutstanding >= srv.params.EchoFailures {
+			srv.stats.echoTimeout++
+			o.terminate()
+			return
+		}
+		o.sendEchoRequest()
+		o.restartTimer(srv.params.EchoInterval)
+		return
+	case sessionTerminating:
+		if o.retransmits >= MaxTerminate {
+			srv.closeSession(o)
+			return
+		}
+		o.retransmits++
+		o.stats.Retransmits++
+		o.sendLCP(layers.LCPTypeTerminateRequest, o.identifier, nil)
+		o.restartTimer(RestartTimer)
+		return
+	}
+
+	if o.retransmits >= MaxConfigure {
+		srv.stats.negotiationTimeout++
+		o.terminate()
+		return
+	}
+	o.retransmits++
+	o.stats.Retransmits++
+	switch o.state {
+	case sessionLCP:
+		if !o.lcpAckRcvd {
+			o.sendLCPConfReq()
+		}
+	case sessionAuth:
+		if srv.authProto == layers.PPPTypeCHAP {
+			o.sendChallenge()
+		}
+	case sessionNetwork:
+		if !o.ipcpAckRcvd {
+			o.sendIPCPConfReq()
+		}
+	}
+	o.restartTimer(RestartTimer)
+}
+
+// OnRemove frees the resources of the session.
+func (o *PPPoESession) OnRemove() {
+	if o.timer.IsRunning() {
+		o.timerw.Stop(&o.timer)
+	}
+	if o.hasIpv4 {
+		o.srv.pool.Put(o.ipv4)
+		o.hasIpv4 = false
+	}
+}
+
+// terminate starts the termination of the session by LCP Terminate-Request, the session is closed by PADT.
+func (o *PPPoESession) terminate() {
+	if o.state == sessionTerminating {
+		return
+	}
+	o.state = sessionTerminating
+	o.retransmits = 0
+	o.identifier++
+	o.sendLCP(layers.LCPTypeTerminateRequest, o.identifier, nil)
+	o.restartTimer(RestartTimer)
+}
+
+// send sends a PPP packet on the session, the length of msg should be set.
+func (o *PPPoESession) send(proto layers.PPPType, msg gopacket.SerializableLayer) {
+	payload := core.PacketUtlBuild(msg)
+	pppoes := &layers.PPPoE{
+		Version:   0x1,
+		Type:      0x1,
+		Code:      layers.PPPoECodeSession,
+		SessionID: o.id,
+		Length:    uint16(len(payload) + 2), // PPP protocol and payload
+	}
+	l2 := o.srv.Client.GetL2Header(false, uint16(layers.EthernetTypePPPoESession))
+	copy(l2[0:6], o.mac[:])
+	pkt := append(l2, core.PacketUtlBuild(pppoes, &layers.PPP{PPPType: proto})...)
+	pkt = append(pkt, payload...)
+	o.srv.Tctx.Veth.SendBuffer(false, o.srv.Client, pkt, false)
+	o.srv.stats.pktTx++
+	o.stats.PktTx++
+}
+
+// sendLCP sends an LCP packet with options, or with the magic number for Echo-Request/Reply.
+func (o *PPPoESession) sendLCP(code layers.LCPType, identifier uint8, options []layers.LCPOption) {
+	lcp := &layers.LCP{
+		Code:       code,
+		Identifier: identifier,
+		Options:    options,
+	}
+	if code == layers.LCPTypeEchoRequest || code == layers.LCPTypeEchoReply {
+		lcp.MagicNumber = o.srv.magic
+	}
+	lcp.Length = lcp.GetLCPSize()
+	o.send(layers.PPPTypeLCP, lcp)
+}
+
+// sendLCPConfReq sends the LCP options of the access concentrator, MRU, the authentication protocol and Magic-Number.
+func (o *PPPoESession) sendLCPConfReq() {
+	srv := o.srv
+	mru := make([]byte, 2)
+	binary.BigEndian.PutUint16(mru, srv.params.Mru)
+	auth := make([]byte, 2)
+	binary.BigEndian.PutUint16(auth, uint16(srv.authProto))
+	if srv.authProto == layers.PPPTypeCHAP {
+		auth = append(auth, srv.authAlgorithm)
+	}
+	o.identifier++
+	o.sendLCP(layers.LCPTypeConfigurationRequest, o.identifier, []layers.LCPOption{
+		{Type: layers.LCPOptionTypeMaximumReceiveUnit, Length: 4, Value: mru},
+		{Type: layers.LCPOptionTypeAuthenticationProtocol, Length: uint8(2 + len(auth)), Value: auth},
+		{Type: layers.LCPOptionTypeMagicNumber, Length: 6, Value: srv.magic},
+	})
+}
+
+// sendEchoRequest sends an LCP Echo-Request of the keepalive.
+func (o *PPPoESession) sendEchoRequest() {
+	o.echoOutstanding++
+	o.identifier++
+	o.sendLCP(layers.LCPTypeEchoRequest, o.identifier, nil)
+	o.stats.EchoTx++
+}
+
+// sendProtocolReject rejects a PPP protocol that is not supported, rfc1661 5.7.
+func (o *PPPoESession) sendProtocolReject(proto layers.PPPType, info []byte) {
+	o.identifier++
+	data := make([]byte, 6, 6+len(info))
+	data[0] = byte(layers.LCPTypeProtocolReject)
+	data[1] = o.identifier
+	binary.BigEndian.PutUint16(data[4:6], uint16(proto))
+	data = append(data, info...)
+	if maxLen := int(o.srv.params.Mru) - 2; len(data) > maxLen {
+		data = data[:maxLen]
+	}
+	binary.BigEndian.PutUint16(data[2:4], uint16(len(data)))
+	o.send(layers.PPPTypeLCP, gopacket.Payload(data))
+}
+
+// handleLCP handles an LCP packet of the peer.
+func (o *PPPoESession) handleLCP(lcp *layers.LCP) {
+	srv := o.srv
+	switch lcp.Code {
+	case layers.LCPTypeConfigurationRequest:
+		// MRU and Magic-Number are acked, the other options are rejected
+		var rejected []layers.LCPOption
+		for _, option := range lcp.Options {
+			switch option.Type {
+			case layers.LCPOptionTypeMaximumReceiveUnit:
+			case layers.LCPOptionTypeMagicNumber:
+				o.peerMagic = append([]byte{}, option.Value...)
+			default:
+				rejected = append(rejected, option)
+			}
+		}
+		if len(rejected) > 0 {
+			o.sendLCP(layers.LCPTypeConfigurationReject, lcp.Identifier, rejected)
+			return
+		}
+		o.sendLCP(layers.LCPTypeConfigurationAck, lcp.Identifier, lcp.Options)
+		o.lcpAckSent = true
+	case layers.LCPTypeConfigurationAck:
+		if o.state != sessionLCP || lcp.Identifier != o.identifier {
+			return
+		}
+		o.lcpAckRcvd = true
+	case layers.LCPTypeEchoRequest:
+		if o.state != sessionTerminating {
+			o.sendLCP(layers.LCPTypeEchoReply, lcp.Identifier, nil)
+		}
+		return
+	case layers.LCPTypeEchoReply:
+		o.echoOutstanding = 0
+		o.stats.EchoRx++
+		return
+	case layers.LCPTypeTerminateRequest:
+		o.sendLCP(layers.LCPTypeTerminateAck, lcp.Identifier, nil)
+		srv.closeSession(o)
+		return
+	case layers.LCPTypeTerminateAck:
+		if o.state == sessionTerminating {
+			srv.closeSession(o)
+		}
+		return
+	default:
+		return
+	}
+
+	if o.state == sessionLCP && o.lcpAckSent && o.lcpAckRcvd {
+		srv.stats.lcpOpened++
+		o.state = sessionAuth
+		o.retransmits = 0
+		if srv.authProto == layers.PPPTypeCHAP {
+			o.sendChallenge()
+		}
+		o.restartTimer(RestartTimer)
+	}
+}
+
+// sendChallenge sends a CHAP Challenge with a new challenge value.
+func (o *PPPoESession) sendChallenge() {
+	srv := o.srv
+	o.challenge = make([]byte, 16)
+	if srv.Tctx.Simulation {
+		copy(o.challenge, []byte{8, 7, 6, 5, 4, 3, 2, 1, 8, 7, 6, 5, 4, 3, 2, 1})
+	} else {
+		rand.Read(o.challenge)
+	}
+	o.challengeId++
+	chap := &layers.CHAP{
+		Code:       layers.CHAPTypeChallenge,
+		Identifier: o.challengeId,
+		Value:      o.challenge,
+		Name:       []byte(srv.params.ACName),
+	}
+	chap.Length = chap.GetCHAPSize()
+	o.send(layers.PPPTypeCHAP, chap)
+}
+
+// onAuth completes the authentication of the peer, the session moves to the network phase or is terminated.
+func (o *PPPoESession) onAuth(user string, ok bool) {
+	srv := o.srv
+	if !ok {
+		srv.stats.authFailure++
+		o.terminate()
+		return
+	}
+	srv.stats.authSuccess++
+	o.user = user
+	o.state = sessionNetwork
+	o.retransmits = 0
+	if !o.hasIpv4 {
+		if o.ipv4, o.hasIpv4 = srv.pool.Get(); !o.hasIpv4 {
+			srv.stats.noAddrAvailable++
+			o.terminate()
+			return
+		}
+	}
+	o.sendIPCPConfReq()
+	o.restartTimer(RestartTimer)
+}
+
+// handlePAP verifies a PAP Authenticate-Request against the users.
+func (o *PPPoESession) handlePAP(pap *layers.PAP) {
+	if pap.Code != layers.PAPTypeAuthRequest || len(pap.Data) != 2 {
+		return
+	}
+	if o.state != sessionAuth {
+		// the Ack was lost, answer again
+		if o.authMsg == layers.PPPTypePAP && o.authResponse != nil {
+			o.sendPAPResponse(layers.PAPTypeAuthAck, pap.Identifier, o.authResponse)
+		}
+		return
+	}
+	user := string(pap.Data[0].Value)
+	password, ok := o.srv.params.Users[user]
+	ok = ok && password == string(pap.Data[1].Value)
+	msg := []byte("Login ok")
+	code := layers.PAPTypeAuthAck
+	if !ok {
+		msg = []byte("Login incorrect")
+		code = layers.PAPTypeAuthNak
+	}
+	o.authMsg = layers.PPPTypePAP
+	o.authResponse = msg
+	o.sendPAPResponse(code, pap.Identifier, msg)
+	o.onAuth(user, ok)
+}
+
+func (o *PPPoESession) sendPAPResponse(code layers.PAPType, identifier uint8, msg []byte) {
+	pap := &layers.PAP{
+		Code:       code,
+		Identifier: identifier,
+		Data:       []layers.PAPData{{Length: uint8(len(msg)), Value: msg}},
+	}
+	pap.Length = pap.GetPAPSize()
+	o.send(layers.PPPTypePAP, pap)
+}
+
+// verifyCHAP verifies the value of a CHAP Response, it returns the message of the Success/Failure.
+func (o *PPPoESession) verifyCHAP(chap *layers.CHAP) (msg []byte, ok bool) {
+	srv := o.srv
+	user := string(chap.Name)
+	password, found := srv.params.Users[user]
+
+	if srv.authAlgorithm == layers.CHAPAlgorithmMD5 {
+		h := md5.New()
+		h.Write([]byte{chap.Identifier})
+		h.Write([]byte(password))
+		h.Write(o.challenge)
+		if found && bytes.Equal(h.Sum(nil), chap.Value) {
+			return []byte("Welcome"), true
+		}
+		return []byte("Authentication failure"), false
+	}
+
+	// rfc2759, Peer-Challenge, 8 reserved octets, NT-Response and Flags
+	failure := []byte(fmt.Sprintf("E=691 R=0 C=%s V=3 M=Authentication failure", strings.ToUpper(hex.EncodeToString(o.challenge))))
+	if !found || len(chap.Value) != 49 {
+		return failure, false
+	}
+	res, err := dot1x.Encryptv2(o.challenge, chap.Value[0:16], user, password)
+	if err != nil || !bytes.Equal(res.ChallengeResponse, chap.Value[24:48]) {
+		return failure, false
+	}
+	return []byte(res.AuthenticatorResponse + " M=Welcome"), true
+}
+
+// handleCHAP verifies a CHAP Response against the users.
+func (o *PPPoESession) handleCHAP(chap *layers.CHAP) {
+	if chap.Code != layers.CHAPTypeResponse || chap.Identifier != o.challengeId {
+		return
+	}
+	if o.state != sessionAuth {
+		// the Success was lost, answer again
+		if o.authMsg == layers.PPPTypeCHAP && o.authResponse != nil {
+			o.sendCHAPResult(layers.CHAPTypeSuccess, chap.Identifier, o.authResponse)
+		}
+		return
+	}
+	msg, ok := o.verifyCHAP(chap)
+	code := layers.CHAPTypeSuccess
+	if !ok {
+		code = layers.CHAPTypeFailure
+	}
+	o.authMsg = layers.PPPTypeCHAP
+	o.authResponse = msg
+	o.sendCHAPResult(code, chap.Identifier, msg)
+	o.onAuth(string(chap.Name), ok)
+}
+
+func (o *PPPoESession) sendCHAPResult(code layers.CHAPType, identifier uint8, msg []byte) {
+	chap := &layers.CHAP{
+		Code:       code,
+		Identifier: identifier,
+		Message:    msg,
+	}
+	chap.Length = chap.GetCHAPSize()
+	o.send(layers.PPPTypeCHAP, chap)
+}
+
+// sendIPCP sends an IPCP packet.
+func (o *PPPoESession) sendIPCP(code layers.IPCPType, identifier uint8, options []layers.IPCPOption) {
+	ipcp := &layers.IPCP{
+		Code:       code,
+		Identifier: identifier,
+		Options:    options,
+	}
+	ipcp.Length = ipcp.GetIPCPSize()
+	o.send(layers.PPPTypeIPCP, ipcp)
+}
+
+// sendIPCPConfReq sends the address of the access concentrator.
+func (o *PPPoESession) sendIPCPConfReq() {
+	o.ipcpIdentifier++
+	local := o.srv.localIpv4
+	o.sendIPCP(layers.IPCPTypeConfigurationRequest, o.ipcpIdentifier, []layers.IPCPOption{
+		{Type: layers.IPCPOptionTypeIPAddress, Length: 6, Value: local[:]},
+	})
+}
+
+// handleIPCP assigns the address of the pool and the DNS servers to the peer.
+func (o *PPPoESession) handleIPCP(ipcp *layers.IPCP) {
+	srv := o.srv
+	if o.state != sessionNetwork && o.state != sessionOpened {
+		return
+	}
+	switch ipcp.Code {
+	case layers.IPCPTypeConfigurationRequest:
+		var naks, rejects []layers.IPCPOption
+		for _, option := range ipcp.Options {
+			var want []byte
+			switch option.Type {
+			case layers.IPCPOptionTypeIPAddress:
+				want = o.ipv4[:]
+			case layers.IPCPOptionTypePrimaryDNS:
+				if len(srv.dns) > 0 {
+					want = srv.dns[0][:]
+				}
+			case layers.IPCPOptionTypeSecondaryDNS:
+				if len(srv.dns) > 1 {
+					want = srv.dns[1][:]
+				}
+			}
+			if want == nil {
+				rejects = append(rejects, option)
+			} else if !bytes.Equal(option.Value, want) {
+				naks = append(naks, layers.IPCPOption{Type: option.Type, Length: 6, Value: want})
+			}
+		}
+		if len(rejects) > 0 {
+			o.sendIPCP(layers.IPCPTypeConfigurationReject, ipcp.Identifier, rejects)
+		} else if len(naks) > 0 {
+			o.sendIPCP(layers.IPCPTypeConfigurationNak, ipcp.Identifier, naks)
+		} else {
+			o.sendIPCP(layers.IPCPTypeConfigurationAck, ipcp.Identifier, ipcp.Options)
+			o.ipcpAckSent = true
+		}
+	case layers.IPCPTypeConfigurationAck:
+		if ipcp.Identifier == o.ipcpIdentifier {
+			o.ipcpAckRcvd = true
+		}
+	default:
+		return
+	}
+
+	if o.state == sessionNetwork && o.ipcpAckSent && o.ipcpAckRcvd {
+		srv.stats.ipcpOpened++
+		o.onOpened()
+	}
+}
+
+// sendIPV6CP sends an IPv6CP packet with an interface identifier.
+func (o *PPPoESession) sendIPV6CP(code layers.IPV6CPType, identifier uint8, ifId []byte) {
+	ipv6cp := &layers.IPV6CP{
+		Code:       code,
+		Identifier: identifier,
+		Options: []layers.IPV6CPOption{
+			{Type: layers.IPV6CPOptionTypeInterfaceIdentifier, Length: 10, Value: ifId},
+		},
+	}
+	ipv6cp.Length = ipv6cp.GetIPV6CPSize()
+	o.send(layers.PPPTypeIPV6CP, ipv6cp)
+}
+
+// handleIPV6CP negotiates the interface identifiers, the one of the peer is kept unless it is zero or ours.
+func (o *PPPoESession) handleIPV6CP(ipv6cp *layers.IPV6CP) {
+	srv := o.srv
+	if o.state != sessionNetwork && o.state != sessionOpened {
+		return
+	}
+	switch ipv6cp.Code {
+	case layers.IPV6CPTypeConfigurationRequest:
+		o.ipv6cp = true
+		ifId := ipv6cp.GetInterfaceIdentifier()
+		if ifId == nil || len(ipv6cp.Options) != 1 {
+			o.sendIPV6CP(layers.IPV6CPTypeConfigurationReject, ipv6cp.Identifier, srv.ifId[:])
+			return
+		}
+		if bytes.Equal(ifId, make([]byte, 8)) || bytes.Equal(ifId, srv.ifId[:]) {
+			suggested := eui64(o.mac)
+			o.sendIPV6CP(layers.IPV6CPTypeConfigurationNak, ipv6cp.Identifier, suggested[:])
+		} else {
+			copy(o.peerIfId[:], ifId)
+			o.sendIPV6CP(layers.IPV6CPTypeConfigurationAck, ipv6cp.Identifier, ifId)
+			o.ipv6cpAckSent = true
+		}
+		if !o.ipv6cpAckRcvd {
+			o.ipv6Identifier++
+			o.sendIPV6CP(layers.IPV6CPTypeConfigurationRequest, o.ipv6Identifier, srv.ifId[:])
+		}
+	case layers.IPV6CPTypeConfigurationAck:
+		if ipv6cp.Identifier != o.ipv6Identifier || o.ipv6cpAckRcvd {
+			return
+		}
+		o.ipv6cpAckRcvd = true
+	default:
+		return
+	}
+
+	if o.ipv6cpAckSent && o.ipv6cpAckRcvd && ipv6cp.Code == layers.IPV6CPTypeConfigurationAck {
+		srv.stats.ipv6cpOpened++
+		if o.state == sessionNetwork {
+			o.onOpened()
+		}
+	}
+}
+
+// onOpened starts the keepalive of an opened session.
+func (o *PPPoESession) onOpened() {
+	o.state = sessionOpened
+	o.echoOutstanding = 0
+	o.restartTimer(o.srv.params.EchoInterval)
+}
+
+// handleRx handles a session packet of the peer.
+func (o *PPPoESession) handleRx(packet gopacket.Packet) int {
+	srv := o.srv
+	o.stats.PktRx++
+
+	pppLayer, ok := packet.Layer(layers.LayerTypePPP).(*layers.PPP)
+	if !ok {
+		srv.stats.pktRxParserErr++
+		return core.PARSER_ERR
+	}
+	if o.state == sessionTerminating && pppLayer.PPPType != layers.PPPTypeLCP {
+		return core.PARSER_OK
+	}
+
+	switch pppLayer.PPPType {
+	case layers.PPPTypeLCP:
+		if lcp, ok := packet.Layer(layers.LayerTypeLCP).(*layers.LCP); ok {
+			o.handleLCP(lcp)
+			return core.PARSER_OK
+		}
+	case layers.PPPTypePAP:
+		if pap, ok := packet.Layer(layers.LayerTypePAP).(*layers.PAP); ok {
+			if srv.authProto == layers.PPPTypePAP {
+				o.handlePAP(pap)
+			}
+			return core.PARSER_OK
+		}
+	case layers.PPPTypeCHAP:
+		if chap, ok := packet.Layer(layers.LayerTypeCHAP).(*layers.CHAP); ok {
+			if srv.authProto == layers.PPPTypeCHAP {
+				o.handleCHAP(chap)
+			}
+			return core.PARSER_OK
+		}
+	case layers.PPPTypeIPCP:
+		if ipcp, ok := packet.Layer(layers.LayerTypeIPCP).(*layers.IPCP); ok {
+			o.handleIPCP(ipcp)
+			return core.PARSER_OK
+		}
+	case layers.PPPTypeIPV6CP:
+		if ipv6cp, ok := packet.Layer(layers.LayerTypeIPV6CP).(*layers.IPV6CP); ok {
+			o.handleIPV6CP(ipv6cp)
+			return core.PARSER_OK
+		}
+	default:
+		// rfc1661 5.7, a Protocol-Reject is sent only in the Opened state of LCP
+		srv.stats.pktRxProtoRejected++
+		if o.state != sessionLCP {
+			o.sendProtocolReject(pppLayer.PPPType, pppLayer.Payload)
+		}
+		return core.PARSER_OK
+	}
+	srv.stats.pktRxParserErr++
+	return core.PARSER_ERR
+}
+
+// eui64 returns the interface identifier of a MAC, rfc4291 appendix A.
+func eui64(mac core.MACKey) (ifId [8]byte) {
+	copy(ifId[0:3], mac[0:3])
+	ifId[3], ifId[4] = 0xff, 0xfe
+	copy(ifId[5:8], mac[3:6])
+	ifId[0] ^= 0x02
+	return ifId
+}
+
+/*======================================================================================================
+										Plugin PPPoESrv Emu Client
+======================================================================================================*/
+
+// PPPoESrvPoolParams is the range of the addresses that IPCP assigns.
+type PPPoESrvPoolParams struct {
+	Min string `json:"min" validate:"required"` // Min IPv4 address
+	Max string `json:"max" validate:"required"` // Max IPv4 address
+}
+
+// PPPoESrvParams represents the init json Api for the PPPoE access concentrator Emu Client.
+type PPPoESrvParams struct {
+	ACName       string             `json:"ac_name"`                           // AC-Name tag. Default to DefaultACName
+	ServiceName  string             `json:"service_name"`                      // Served Service-Name, empty serves any
+	Auth         string             `json:"auth"`                              // pap, chap or mschapv2. Default to pap
+	Users        map[string]string  `json:"users"`                             // Password per user
+	Pool         PPPoESrvPoolParams `json:"pool" validate:"required"`          // Addresses of the peers
+	LocalIp      string             `json:"local_ip"`                          // Address of the access concentrator. Default to the client IPv4
+	Dns          []string           `json:"dns" validate:"max=2"`              // Primary and secondary DNS of IPCP
+	Mru          uint16             `json:"mru"`                               // MRU of LCP. Default to DefaultMRU
+	EchoInterval uint32             `json:"echo_interval"`                     // Seconds between LCP Echo-Requests. Default to DefaultEchoInterval
+	EchoFailures uint32             `json:"echo_failures"`                     // Unanswered Echo-Requests before termination. Default to DefaultEchoFailures
+	MaxSessions  uint32             `json:"max_sessions" validate:"lte=65534"` // Maximal sessions, 0 for MaxSessionId
+}
+
+// pppoeSrvEvents holds a list of events on which the PPPoESrv plugin is interested.
+var pppoeSrvEvents = []string{}
+
+// PluginPPPoESrvClient represents an Emu Client that acts as a PPPoE access concentrator.
+type PluginPPPoESrvClient struct {
+	core.PluginBase                          // Plugin Base embedded struct so we get all the base functionality
+	params          PPPoESrvParams           // Init Json params
+	stats           PPPoESrvStats            // PPPoESrv counters
+	cdb             *core.CCounterDb         // Counters database
+	cdbv            *core.CCounterDbVec      // Counters database vector
+	nsPlug          *PluginPPPoESrvNs        // Namespace plugin
+	sessions        map[uint16]*PPPoESession // Sessions by id
+	nextSessionId   uint16                   // Last allocated session id
+	pool            *Ipv4RangePool           // Addresses of the peers
+	localIpv4       core.Ipv4Key             // Address of the access concentrator
+	dns             []core.Ipv4Key           // DNS servers of IPCP
+	authProto       layers.PPPType           // PAP or CHAP
+	authAlgorithm   uint8                    // CHAP algorithm, MD5 or MS-CHAPv2
+	magic           []byte                   // Magic-Number of the access concentrator
+	ifId            [8]byte                  // Interface identifier of the access concentrator
+}
+
+// NewPPPoESrvClient creates a new PPPoESrv Emu Client Plugin.
+func NewPPPoESrvClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
+	o := new(PluginPPPoESrvClient)
+	o.InitPluginBase(ctx, o)                 // Init base object
+	o.RegisterEvents(ctx, pppoeSrvEvents, o) // Register events
+	nsplg := o.Ns.PluginCtx.GetOrCreate(PPPOE_SRV_PLUG)
+	o.nsPlug = nsplg.Ext.(*PluginPPPoESrvNs)
+	o.cdb = NewPPPoESrvStatsDb(&o.stats) // Register Stats immediately so we can fail safely.
+	o.cdbv = core.NewCCounterDbVec(PPPOE_SRV_PLUG)
+	o.cdbv.Add(o.cdb)
+
+	// Set the default paramaters
+	o.params.ACName = DefaultACName
+	o.params.Auth = AuthPAP
+	o.params.Mru = DefaultMRU
+	o.params.EchoInterval = DefaultEchoInterval
+	o.params.EchoFailures = DefaultEchoFailures
+
+	err := o.Tctx.UnmarshalValidate(initJson, &o.params) // Unmarshal and validate init json
+	if err != nil {
+		o.stats.invalidInitJson++
+		return nil, err
+	}
+
+	err = o.OnCreate()
+	if err != nil {
+		o.stats.invalidInitJson++
+		return nil, err
+	}
+	o.nsPlug.addServer(o)
+
+	return &o.PluginBase, nil
+}
+
+// parseIPv4 parses an IPv4 address of the init JSON.
+func parseIPv4(s string) (ipv4 core.Ipv4Key, err error) {
+	ip := net.ParseIP(s).To4()
+	if ip == nil {
+		return ipv4, fmt.Errorf("Invalid IPv4 %s", s)
+	}
+	copy(ipv4[:], ip)
+	return ipv4, nil
+}
+
+// OnCreate is called upon the creation of a new PPPoESrv Emu client.
+func (o *PluginPPPoESrvClient) OnCreate() (err error) {
+	o.sessions = make(map[uint16]*PPPoESession)
+
+	switch o.params.Auth {
+	case AuthPAP:
+		o.authProto = layers.PPPTypePAP
+	case AuthCHAP:
+		o.authProto = layers.PPPTypeCHAP
+		o.authAlgorithm = layers.CHAPAlgorithmMD5
+	case AuthMSCHAPv2:
+		o.authProto = layers.PPPTypeCHAP
+		o.authAlgorithm = layers.CHAPAlgorithmMSCHAPv2
+	default:
+		return fmt.Errorf("Invalid auth %s, should be %s, %s or %s", o.params.Auth, AuthPAP, AuthCHAP, AuthMSCHAPv2)
+	}
+	if len(o.params.Users) == 0 {
+		return fmt.Errorf("At least one user is required")
+	}
+	if o.params.Mru == 0 || o.params.EchoInterval == 0 || o.params.EchoFailures == 0 {
+		return fmt.Errorf("Invalid mru %d, echo_interval %d or echo_failures %d", o.params.Mru,
+			o.params.EchoInterval, o.params.EchoFailures)
+	}
+	if o.params.MaxSessions == 0 {
+		o.params.MaxSessions = MaxSessionId
+	}
+
+	if o.params.LocalIp != "" {
+		if o.localIpv4, err = parseIPv4(o.params.LocalIp); err != nil {
+			return err
+		}
+	} else {
+		o.localIpv4 = o.Client.Ipv4
+	}
+	if o.localIpv4.IsZero() {
+		return fmt.Errorf("Access concentrator address is not set, set local_ip or the client IPv4")
+	}
+
+	min, err := parseIPv4(o.params.Pool.Min)
+	if err != nil {
+		return err
+	}
+	max, err := parseIPv4(o.params.Pool.Max)
+	if err != nil {
+		return err
+	}
+	if o.pool, err = CreateIpv4RangePool(min, max, o.localIpv4); err != nil {
+		return err
+	}
+
+	for _, s := range o.params.Dns {
+		dns, err := parseIPv4(s)
+		if err != nil {
+			return err
+		}
+		o.dns = append(o.dns, dns)
+	}
+
+	o.magic = make([]byte, 4)
+	binary.BigEndian.PutUint32(o.magic, rand.Uint32())
+	o.ifId = eui64(o.Client.Mac)
+	return nil
+}
+
+// OnRemove is called upon removing the PPPoESrv Emu client, the sessions are closed by PADT.
+func (o *PluginPPPoESrvClient) OnRemove(ctx *core.PluginCtx) {
+	ctx.UnregisterEvents(&o.PluginBase, pppoeSrvEvents)
+	for _, s := range o.sessions {
+		o.closeSession(s)
+	}
+	o.nsPlug.removeServer(o)
+}
+
+// OnEvent for events the client plugin is registered.
+func (o *PluginPPPoESrvClient) OnEvent(msg string, a, b interface{}) {}
+
+// sendDiscovery sends a PPPoE Discovery packet to a peer.
+func (o *PluginPPPoESrvClient) sendDiscovery(dst core.MACKey, code layers.PPPoECode, sessionId uint16, tags []layers.PPPoEDTag) {
+	pppoed := &layers.PPPoE{
+		Version:   0x1,
+		Type:      0x1,
+		Code:      code,
+		SessionID: sessionId,
+		Tags:      tags,
+	}
+	pppoed.Length = pppoed.GetPPPoEDTagsSize()
+	l2 := o.Client.GetL2Header(false, uint16(layers.EthernetTypePPPoEDiscovery))
+	copy(l2[0:6], dst[:])
+	pkt := append(l2, core.PacketUtlBuild(pppoed)...)
+	o.Tctx.Veth.SendBuffer(false, o.Client, pkt, false)
+	o.stats.pktTx++
+}
+
+// closeSession sends PADT and removes the session.
+func (o *PluginPPPoESrvClient) closeSession(s *PPPoESession) {
+	o.sendDiscovery(s.mac, layers.PPPoECodePADT, s.id, nil)
+	o.stats.pktTxPadt++
+	o.removeSession(s)
+}
+
+// removeSession removes a session without PADT.
+func (o *PluginPPPoESrvClient) removeSession(s *PPPoESession) {
+	s.OnRemove()
+	delete(o.sessions, s.id)
+	o.stats.activeSessions--
+}
+
+// allocSessionId returns a free session id, false in case there is none.
+func (o *PluginPPPoESrvClient) allocSessionId() (uint16, bool) {
+	if uint32(len(o.sessions)) >= o.params.MaxSessions {
+		return 0, false
+	}
+	for i := 0; i < MaxSessionId; i++ {
+		o.nextSessionId++
+		if o.nextSessionId == 0 || o.nextSessionId > MaxSessionId {
+			o.nextSessionId = 1
+		}
+		if _, ok := o.sessions[o.nextSessionId]; !ok {
+			return o.nextSessionId, true
+		}
+	}
+	return 0, false
+}
+
+// replyTags returns the tags of PADO/PADS, the Service-Name, AC-Name and the tags that the peer expects back.
+func (o *PluginPPPoESrvClient) replyTags(tags []layers.PPPoEDTag) ([]layers.PPPoEDTag, bool) {
+	var serviceName []byte
+	found := false
+	var echoed []layers.PPPoEDTag
+	for _, tag := range tags {
+		switch tag.Type {
+		case layers.PPPoEDTagTypeServiceName:
+			serviceName, found = tag.Value, true
+		case layers.PPPoEDTagTypeHostUniq, layers.PPPoEDTagTypeRelaySessionId:
+			echoed = append(echoed, tag)
+		}
+	}
+	// an empty Service-Name asks for any service
+	if !found || (len(serviceName) > 0 && string(serviceName) != o.params.ServiceName) {
+		return nil, false
+	}
+	serviceName = []byte(o.params.ServiceName)
+	reply := []layers.PPPoEDTag{
+		{Type: layers.PPPoEDTagTypeServiceName, Length: uint16(len(serviceName)), Value: serviceName},
+		{Type: layers.PPPoEDTagTypeACName, Length: uint16(len(o.params.ACName)), Value: []byte(o.params.ACName)},
+	}
+	return append(reply, echoed...), true
+}
+
+// handleDiscovery handles PADI, PADR and PADT.
+func (o *PluginPPPoESrvClient) handleDiscovery(pppoe *layers.PPPoE, peer core.MACKey) int {
+	switch pppoe.Code {
+	case layers.PPPoECodePADI:
+		o.stats.pktRxPadi++
+		tags, ok := o.replyTags(pppoe.Tags)
+		if !ok {
+			o.stats.pktRxBadServiceName++
+			return core.PARSER_OK
+		}
+		o.sendDiscovery(peer, layers.PPPoECodePADO, 0, tags)
+		o.stats.pktTxPado++
+	case layers.PPPoECodePADR:
+		o.stats.pktRxPadr++
+		tags, ok := o.replyTags(pppoe.Tags)
+		if !ok {
+			o.stats.pktRxBadServiceName++
+			return core.PARSER_OK
+		}
+		id, ok := o.allocSessionId()
+		if !ok {
+			o.stats.noSessionAvailable++
+			return core.PARSER_OK
+		}
+		s := newPPPoESession(o, id, peer)
+		o.sessions[id] = s
+		o.stats.activeSessions++
+		o.sendDiscovery(peer, layers.PPPoECodePADS, id, tags)
+		o.stats.pktTxPads++
+		s.sendLCPConfReq()
+		s.restartTimer(RestartTimer)
+	case layers.PPPoECodePADT:
+		o.stats.pktRxPadt++
+		if s, ok := o.sessions[pppoe.SessionID]; ok && s.mac == peer {
+			o.removeSession(s)
+		}
+	default:
+		o.stats.pktRxBadCode++
+	}
+	return core.PARSER_OK
+}
+
+// HandleRxPPPoEPacket handles an incoming PPPoE Discovery or Session packet.
+func (o *PluginPPPoESrvClient) HandleRxPPPoEPacket(ps *core.ParserPacketState) int {
+	m := ps.M
+	p := m.GetData()
+	o.stats.pktRx++
+
+	var peer core.MACKey
+	copy(peer[:], p[6:12])
+	packet := gopacket.NewPacket(p[ps.L3:], layers.LayerTypePPPoE, gopacket.Default)
+	pppoe, ok := packet.Layer(layers.LayerTypePPPoE).(*layers.PPPoE)
+	if !ok {
+		o.stats.pktRxParserErr++
+		return core.PARSER_ERR
+	}
+
+	if pppoe.Code != layers.PPPoECodeSession {
+		return o.handleDiscovery(pppoe, peer)
+	}
+	s, ok := o.sessions[pppoe.SessionID]
+	if !ok || s.mac != peer {
+		o.stats.pktRxUnknownSession++
+		return core.PARSER_OK
+	}
+	return s.handleRx(packet)
+}
+
+// KillSessions terminates the sessions of ids, all the sessions in case ids is empty.
+func (o *PluginPPPoESrvClient) KillSessions(ids []uint16) {
+	if len(ids) == 0 {
+		for id := range o.sessions {
+			ids = append(ids, id)
+		}
+	}
+	for _, id := range ids {
+		if s, ok := o.sessions[id]; ok && s.state != sessionTerminating {
+			o.stats.sessionsKilled++
+			s.terminate()
+		}
+	}
+}
+
+// PPPoESrvSessionInfo describes a session for the RPC.
+type PPPoESrvSessionInfo struct {
+	SessionId uint16            `json:"session_id"`
+	Mac       string            `json:"mac"`
+	State     string            `json:"state"`
+	User      string            `json:"user"`
+	Ipv4      string            `json:"ipv4"`      // Address of the peer, empty until it is allocated
+	Ipv6IfId  string            `json:"ipv6_ifid"` // Interface identifier of the peer, empty without IPv6CP
+	Counters  PPPoESessionStats `json:"counters"`
+}
+
+// GetSessions returns the sessions sorted by id.
+func (o *PluginPPPoESrvClient) GetSessions() []PPPoESrvSessionInfo {
+	res := make([]PPPoESrvSessionInfo, 0, len(o.sessions))
+	for _, s := range o.sessions {
+		info := PPPoESrvSessionInfo{
+			SessionId: s.id,
+			Mac:       s.mac.String(),
+			State:     sessionStateNames[s.state],
+			User:      s.user,
+			Counters:  s.stats,
+		}
+		if s.hasIpv4 {
+			info.Ipv4 = s.ipv4.ToIP().String()
+		}
+		if s.ipv6cpAckSent {
+			info.Ipv6IfId = hex.EncodeToString(s.peerIfId[:])
+		}
+		res = append(res, info)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].SessionId < res[j].SessionId })
+	return res
+}
+
+/*======================================================================================================
+										Plugin PPPoESrv Ns
+======================================================================================================*/
+// PluginPPPoESrvNs represents the namespace layer for PPPoESrv.
+type PluginPPPoESrvNs struct {
+	core.PluginBase
+	servers []*PluginPPPoESrvClient // Access concentrators in the namespace, by creation order
+}
+
+// NewPPPoESrvNs creates a new PPPoESrv namespace plugin
+func NewPPPoESrvNs(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
+	o := new(PluginPPPoESrvNs)
+	o.InitPluginBase(ctx, o)
+	o.RegisterEvents(ctx, []string{}, o)
+	return &o.PluginBase, nil
+}
+
+// OnRemove when removing PPPoESrv namespace plugin.
+func (o *PluginPPPoESrvNs) OnRemove(ctx *core.PluginCtx) {}
+
+// OnEvent for events the namespace plugin is registered.
+func (o *PluginPPPoESrvNs) OnEvent(msg string, a, b interface{}) {}
+
+func (o *PluginPPPoESrvNs) addServer(srv *PluginPPPoESrvClient) {
+	o.servers = append(o.servers, srv)
+}
+
+func (o *PluginPPPoESrvNs) removeServer(srv *PluginPPPoESrvClient) {
+	for i := range o.servers {
+		if o.servers[i] == srv {
+			o.servers = append(o.servers[:i], o.servers[i+1:]...)
+			return
+		}
+	}
+}
+
+// HandleRxPPPoESrvPacket handles the frames of the access concentrators, it returns false in case the frame is not for one.
+func (o *PluginPPPoESrvNs) HandleRxPPPoESrvPacket(ps *core.ParserPacketState) (int, bool) {
+
+	/*
+		Note: A broadcast PADI is passed to all the access concentrators in the namespace.
+		A unicast is passed to the access concentrator of the destination MAC.
+	*/
+
+	m := ps.M
+	p := m.GetData()
+	var mackey core.MACKey
+	copy(mackey[:], p[0:6])
+
+	if mackey.IsBroadcast() {
+		if len(o.servers) == 0 || p[ps.L3+1] != byte(layers.PPPoECodePADI) {
+			return core.PARSER_OK, false
+		}
+		for _, srv := range o.servers {
+			srv.HandleRxPPPoEPacket(ps)
+		}
+		return core.PARSER_OK, true
+	}
+
+	client := o.Ns.CLookupByMac(&mackey)
+	if client == nil {
+		return core.PARSER_OK, false
+	}
+	cplg := client.PluginCtx.Get(PPPOE_SRV_PLUG)
+	if cplg == nil {
+		return core.PARSER_OK, false
+	}
+	return cplg.Ext.(*PluginPPPoESrvClient).HandleRxPPPoEPacket(ps), true
+}
+
+// HandleRxPPPoESrvPacket is called by the parser for the PPPoE frames, the frames that are not for an access
+// concentrator are passed to the ppp client.
+func HandleRxPPPoESrvPacket(ps *core.ParserPacketState) int {
+	ns := ps.Tctx.GetNs(ps.Tun)
+	if ns == nil {
+		return core.PARSER_NEXT
+	}
+	nsplg := ns.PluginCtx.Get(PPPOE_SRV_PLUG)
+	if nsplg == nil {
+		return core.PARSER_NEXT
+	}
+	// an access concentrator gets the PADI of the clients in its namespace and the frames to its own MAC
+	if rc, ok := nsplg.Ext.(*PluginPPPoESrvNs).HandleRxPPPoESrvPacket(ps); ok {
+		return rc
+	}
+	return core.PARSER_NEXT
+}
+
+/*
+======================================================================================================
+
+	Generate Plugin
+
+======================================================================================================
+*/
+type PluginPPPoESrvCReg struct{}
+type PluginPPPoESrvNsReg struct{}
+
+// NewPlugin creates a new PPPoESrv client plugin.
+func (o PluginPPPoESrvCReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
+	return NewPPPoESrvClient(ctx, initJson)
+}
+
+// NewPlugin creates a new PPPoESrv namespace plugin.
+func (o PluginPPPoESrvNsReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
+	return NewPPPoESrvNs(ctx, initJson)
+}
+
+/*======================================================================================================
+											RPC Methods
+======================================================================================================*/
+
+type (
+	ApiPPPoESrvClientCntHandler      struct{} // Counter RPC Handler per Client
+	ApiPPPoESrvClientSessionsHandler struct{} // List the sessions
+	ApiPPPoESrvClientKillHandler     struct{} // Terminate sessions
+	ApiPPPoESrvClientKillParams      struct {
+		Sessions []uint16 `json:"sessions"` // Session ids, empty for all the sessions
+	}
+)
+
+// getClientPlugin gets the client plugin given the client parameters (Mac & Tunnel Key)
+func getClientPlugin(ctx interface{}, params *fastjson.RawMessage) (*PluginPPPoESrvClient, error) {
+	tctx := ctx.(*core.CThreadCtx)
+
+	plug, err := tctx.GetClientPlugin(params, PPPOE_SRV_PLUG)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pClient := plug.Ext.(*PluginPPPoESrvClient)
+
+	return pClient, nil
+}
+
+// ApiPPPoESrvClientCntHandler gets the counters of the PPPoESrv client.
+func (h ApiPPPoESrvClientCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	c, err := getClientPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return c.cdbv.GeneralCounters(err, tctx, params, &p)
+}
+
+// ApiPPPoESrvClientSessionsHandler lists the sessions and their counters.
+func (h ApiPPPoESrvClientSessionsHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	c, err := getClientPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return c.GetSessions(), nil
+}
+
+// ApiPPPoESrvClientKillHandler terminates sessions by LCP Terminate-Request and PADT.
+func (h ApiPPPoESrvClientKillHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	c, err := getClientPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+
+	var p ApiPPPoESrvClientKillParams
+	tctx := ctx.(*core.CThreadCtx)
+	err = tctx.UnmarshalValidate(*params, &p)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	c.KillSessions(p.Sessions)
+	return nil, nil
+}
+
+func init() {
+
+	/* register of plugins callbacks for ns,c level  */
+	core.PluginRegister(PPPOE_SRV_PLUG,
+		core.PluginRegisterData{Client: PluginPPPoESrvCReg{},
+			Ns:     PluginPPPoESrvNsReg{},
+			Thread: nil}) /* no need for thread context for now */
+
+	core.RegisterCB("pppoesrv_c_cnt", ApiPPPoESrvClientCntHandler{}, true)            // get counters / meta per client
+	core.RegisterCB("pppoesrv_c_sessions", ApiPPPoESrvClientSessionsHandler{}, false) // list the sessions
+	core.RegisterCB("pppoesrv_c_kill", ApiPPPoESrvClientKillHandler{}, false)         // terminate sessions
+
+	/* register parser, the access concentrator shares the PPPoE frames of the ppp client and gets them before it */
+	core.ParserRegisterShared(PPPOE_SRV_PLUG, 1, HandleRxPPPoESrvPacket,
+		core.ParserMatch{EthType: uint16(layers.EthernetTypePPPoEDiscovery)},
+		core.ParserMatch{EthType: uint16(layers.EthernetTypePPPoESession)})
+}
+
+func Register(ctx *core.CThreadCtx) {
+	// In order for this plugin to be included in the EMU compilation one must provide this empty register
+	// function. In case you remove the function call, then the core will not include EMU.
+	ctx.RegisterParserCb(PPPOE_SRV_PLUG)
+}
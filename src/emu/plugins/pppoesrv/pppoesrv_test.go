@@ -0,0 +1,324 @@
+/*
+Copyright (c) 2021 Cisco Systems and/or its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+that can be found in the LICENSE file in the root of the source
+tree.
+*/
+
+package pppoesrv
+
+import (
+	"emu/core"
+	ppp "emu/plugins/point2point"
+	"flag"
+	"os"
+	"testing"
+	"time"
+)
+
+var monitor int
+
+func TestIpv4RangePool(t *testing.T) {
+	pool, err := CreateIpv4RangePool(core.Ipv4Key{10, 0, 0, 1}, core.Ipv4Key{10, 0, 0, 3}, core.Ipv4Key{10, 0, 0, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// There should be 2 values, the excluded address is skipped
+	want := []core.Ipv4Key{{10, 0, 0, 1}, {10, 0, 0, 3}}
+	for i := range want {
+		ipv4, ok := pool.Get()
+		if !ok || ipv4 != want[i] {
+			t.Fatalf("Invalid address, want %v and have %v %v", want[i].ToIP(), ipv4.ToIP(), ok)
+		}
+	}
+	if _, ok := pool.Get(); ok {
+		t.Fatal("Pool should be empty, and is not!")
+	}
+
+	// Released address is reused
+	pool.Put(want[0])
+	if ipv4, ok := pool.Get(); !ok || ipv4 != want[0] {
+		t.Fatalf("Released address was not reused, have %v %v", ipv4.ToIP(), ok)
+	}
+
+	if _, err := CreateIpv4RangePool(core.Ipv4Key{10, 0, 0, 3}, core.Ipv4Key{10, 0, 0, 1}, core.Ipv4Key{}); err == nil {
+		t.Fatal("Created a pool with max lower than min")
+	}
+}
+
+// PPPoESrvTestBase represents the base parameters for a PPPoESrv test.
+type PPPoESrvTestBase struct {
+	testname   string
+	monitor    bool
+	capture    bool
+	duration   time.Duration
+	kill       time.Duration // Kill the sessions after kill, 0 for never
+	srvJSON    []byte
+	clientJSON []byte
+	counters   PPPoESrvStats
+	clientIp   string
+	clientIpv6 bool     // The client opens IPv6CP
+	sessions   []string // Expected state of each session
+}
+
+// VethPPPoESrvSim is a loopback veth, the access concentrator and the client are in the same namespace.
+type VethPPPoESrvSim struct{}
+
+// ProcessTxToRx loops back each packet.
+func (o *VethPPPoESrvSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
+	return m
+}
+
+// PPPoESrvTestKill kills the sessions of the access concentrator.
+type PPPoESrvTestKill struct {
+	srv *PluginPPPoESrvClient
+}
+
+// OnEvent kills all the sessions.
+func (o *PPPoESrvTestKill) OnEvent(a, b interface{}) {
+	o.srv.KillSessions(nil)
+}
+
+var (
+	srvMac    = core.MACKey{0, 0, 1, 0, 0, 1}
+	clientMac = core.MACKey{0, 0, 1, 0, 0, 2}
+)
+
+// Run the test.
+func (o *PPPoESrvTestBase) Run(t *testing.T) {
+	var simVeth VethPPPoESrvSim
+	var simrx core.VethIFSim = &simVeth
+
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	defer tctx.Delete()
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1})
+	ns := core.NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+
+	srv := core.NewClient(ns, srvMac, core.Ipv4Key{10, 0, 0, 1}, core.Ipv6Key{}, core.Ipv4Key{})
+	ns.AddClient(srv)
+	client := core.NewClient(ns, clientMac, core.Ipv4Key{}, core.Ipv6Key{}, core.Ipv4Key{})
+	ns.AddClient(client)
+
+	if err := srv.PluginCtx.CreatePlugins([]string{PPPOE_SRV_PLUG}, [][]byte{o.srvJSON}); err != nil {
+		t.Fatal(err)
+	}
+	clientJSON := o.clientJSON
+	if clientJSON == nil {
+		clientJSON = []byte("{}")
+	}
+	if err := client.PluginCtx.CreatePlugins([]string{ppp.PPPPlugin}, [][]byte{clientJSON}); err != nil {
+		t.Fatal(err)
+	}
+	Register(tctx)
+	ppp.Register(tctx)
+
+	srvPlug := srv.PluginCtx.Get(PPPOE_SRV_PLUG).Ext.(*PluginPPPoESrvClient)
+	var timer core.CHTimerObj
+	kill := PPPoESrvTestKill{srv: srvPlug}
+	if o.kill > 0 {
+		timer.SetCB(&kill, nil, nil)
+		tctx.GetTimerCtx().Start(&timer, o.kill)
+	}
+
+	m := false
+	if monitor > 0 {
+		m = true
+	}
+	tctx.Veth.SetDebug(m, os.Stdout, o.capture)
+	tctx.MainLoopSim(o.duration)
+
+	srvPlug.cdbv.Dump()
+	if o.counters != srvPlug.stats {
+		t.Fatalf("Bad counters, want %+v, have %+v.\n", o.counters, srvPlug.stats)
+	}
+	sessions := srvPlug.GetSessions()
+	if len(sessions) != len(o.sessions) {
+		t.Fatalf("Bad sessions, want %v, have %+v.\n", o.sessions, sessions)
+	}
+	for i := range sessions {
+		if sessions[i].State != o.sessions[i] || sessions[i].Mac != clientMac.String() {
+			t.Fatalf("Bad session %d, want %v, have %+v.\n", i, o.sessions[i], sessions[i])
+		}
+	}
+	clientPlug := client.PluginCtx.Get(ppp.PPPPlugin).Ext.(*ppp.PluginPPPClient)
+	if ip := clientPlug.GetPPPClientIP(); ip != o.clientIp {
+		t.Fatalf("Bad client address, want %v, have %v.\n", o.clientIp, ip)
+	}
+	if ipv6 := clientPlug.GetPPPClientIpv6(); (ipv6 != "") != o.clientIpv6 {
+		t.Fatalf("Bad client IPv6, have %v.\n", ipv6)
+	}
+}
+
+// TestPPPoESrv1 opens a session with PAP and keeps it alive.
+func TestPPPoESrv1(t *testing.T) {
+	a := &PPPoESrvTestBase{
+		testname: "pppoesrv1",
+		monitor:  false,
+		capture:  true,
+		duration: 30 * time.Second,
+		srvJSON: []byte(`{"ac_name": "bras", "users": {"test": "test"},
+			"pool": {"min": "10.0.0.1", "max": "10.0.0.100"}, "dns": ["8.8.8.8"]}`),
+		counters: PPPoESrvStats{
+			activeSessions: 1,
+			pktRx:          12,
+			pktRxPadi:      1,
+			pktRxPadr:      1,
+			pktTx:          12,
+			pktTxPado:      1,
+			pktTxPads:      1,
+			lcpOpened:      1,
+			authSuccess:    1,
+			ipcpOpened:     1,
+		},
+		clientIp: "10.0.0.2",
+		sessions: []string{"opened"},
+	}
+	a.Run(t)
+}
+
+// TestPPPoESrv2 opens a session with CHAP-MD5 and IPv6CP.
+func TestPPPoESrv2(t *testing.T) {
+	a := &PPPoESrvTestBase{
+		testname: "pppoesrv2",
+		monitor:  false,
+		capture:  true,
+		duration: 30 * time.Second,
+		srvJSON: []byte(`{"auth": "chap", "users": {"alice": "secret"},
+			"pool": {"min": "10.0.0.1", "max": "10.0.0.100"}}`),
+		clientJSON: []byte(`{"user": "alice", "password": "secret", "ipv6": true}`),
+		counters: PPPoESrvStats{
+			activeSessions: 1,
+			pktRx:          14,
+			pktRxPadi:      1,
+			pktRxPadr:      1,
+			pktTx:          16,
+			pktTxPado:      1,
+			pktTxPads:      1,
+			lcpOpened:      1,
+			authSuccess:    1,
+			ipcpOpened:     1,
+			ipv6cpOpened:   1,
+		},
+		clientIp:   "10.0.0.2",
+		clientIpv6: true,
+		sessions:   []string{"opened"},
+	}
+	a.Run(t)
+}
+
+// TestPPPoESrv3 opens a session with MS-CHAPv2.
+func TestPPPoESrv3(t *testing.T) {
+	a := &PPPoESrvTestBase{
+		testname: "pppoesrv3",
+		monitor:  false,
+		capture:  true,
+		duration: 30 * time.Second,
+		srvJSON: []byte(`{"auth": "mschapv2", "users": {"alice": "secret"}, "local_ip": "10.0.0.254",
+			"pool": {"min": "10.0.1.1", "max": "10.0.1.100"}}`),
+		clientJSON: []byte(`{"user": "alice", "password": "secret"}`),
+		counters: PPPoESrvStats{
+			activeSessions: 1,
+			pktRx:          12,
+			pktRxPadi:      1,
+			pktRxPadr:      1,
+			pktTx:          14,
+			pktTxPado:      1,
+			pktTxPads:      1,
+			lcpOpened:      1,
+			authSuccess:    1,
+			ipcpOpened:     1,
+		},
+		clientIp: "10.0.1.1",
+		sessions: []string{"opened"},
+	}
+	a.Run(t)
+}
+
+// TestPPPoESrv4 terminates the session of a client with a wrong password.
+func TestPPPoESrv4(t *testing.T) {
+	a := &PPPoESrvTestBase{
+		testname: "pppoesrv4",
+		monitor:  false,
+		capture:  true,
+		duration: 30 * time.Second,
+		srvJSON: []byte(`{"users": {"test": "other"},
+			"pool": {"min": "10.0.0.1", "max": "10.0.0.100"}}`),
+		counters: PPPoESrvStats{
+			pktRx:               12,
+			pktRxPadi:           1,
+			pktRxPadr:           1,
+			pktRxUnknownSession: 4,
+			pktTx:               11,
+			pktTxPado:           1,
+			pktTxPads:           1,
+			pktTxPadt:           1,
+			lcpOpened:           1,
+			authFailure:         1,
+		},
+		clientIp: "0.0.0.0",
+	}
+	a.Run(t)
+}
+
+// TestPPPoESrv5 kills an opened session by RPC.
+func TestPPPoESrv5(t *testing.T) {
+	a := &PPPoESrvTestBase{
+		testname: "pppoesrv5",
+		monitor:  false,
+		capture:  true,
+		duration: 30 * time.Second,
+		kill:     20 * time.Second,
+		srvJSON: []byte(`{"users": {"test": "test"},
+			"pool": {"min": "10.0.0.1", "max": "10.0.0.100"}}`),
+		counters: PPPoESrvStats{
+			pktRx:          13,
+			pktRxPadi:      1,
+			pktRxPadr:      1,
+			pktRxPadt:      1,
+			pktTx:          13,
+			pktTxPado:      1,
+			pktTxPads:      1,
+			pktTxPadt:      1,
+			lcpOpened:      1,
+			authSuccess:    1,
+			ipcpOpened:     1,
+			sessionsKilled: 1,
+		},
+		clientIp: "10.0.0.2",
+	}
+	a.Run(t)
+}
+
+// TestPPPoESrv6 serves a Service-Name, the empty Service-Name of the client asks for any service.
+func TestPPPoESrv6(t *testing.T) {
+	a := &PPPoESrvTestBase{
+		testname: "pppoesrv6",
+		monitor:  false,
+		capture:  true,
+		duration: 10 * time.Second,
+		srvJSON: []byte(`{"service_name": "internet", "users": {"test": "test"},
+			"pool": {"min": "10.0.0.1", "max": "10.0.0.100"}}`),
+		counters: PPPoESrvStats{
+			activeSessions: 1,
+			pktRx:          10,
+			pktRxPadi:      1,
+			pktRxPadr:      1,
+			pktTx:          10,
+			pktTxPado:      1,
+			pktTxPads:      1,
+			lcpOpened:      1,
+			authSuccess:    1,
+			ipcpOpened:     1,
+		},
+		clientIp: "10.0.0.2",
+		sessions: []string{"opened"},
+	}
+	a.Run(t)
+}
+
+func init() {
+	flag.IntVar(&monitor, "monitor", 0, "monitor")
+}
@@ -0,0 +1,192 @@
+// Copyright (c) 2021 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// Package tlspipe runs crypto/tls over memory buffers in lockstep with the main loop.
+// It is shared by the EAP-TLS methods of dot1x and the TLS sockets of transport.
+package tlspipe
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	TLS_PIPE_READ_SIZE = 16 * 1024 // the maximum size of a TLS record
+)
+
+/*
+TlsPipe runs crypto/tls over memory buffers. The TLS records of the peer are pushed by Push and the records to send
+are taken by Pull. crypto/tls is blocking, so the connection runs in a goroutine that is driven step by step by the
+main loop: Push wakes the goroutine up and waits until it blocks on reading the next records (or exits). The goroutine
+never runs concurrently with the main loop, so the buffers need no lock and the result does not depend on scheduling.
+
+	p := tlspipe.NewTlsPipe(cfg, false)
+	p.Start()              // the client hello is ready in Pull
+	p.Push(serverRecords)  // after the handshake HandshakeDone is true and Read returns the application data
+	p.Close()
+*/
+type TlsPipe struct {
+	conn          *tls.Conn
+	rx            bytes.Buffer // records of the peer that were not read yet
+	tx            bytes.Buffer // records to send to the peer
+	app           bytes.Buffer // application data of the peer
+	wake          chan bool    // main loop to goroutine, true for new records, false to close
+	idle          chan bool    // goroutine to main loop, it waits for records or it exited
+	running       bool         // the goroutine was started and did not exit
+	closing       bool         // Close was called
+	handshakeDone bool         // the handshake ended successfully
+	err           error        // the error that stopped the goroutine
+}
+
+// NewTlsPipe creates a pipe, server for the server side of the handshake.
+func NewTlsPipe(cfg *tls.Config, server bool) *TlsPipe {
+	o := new(TlsPipe)
+	o.wake = make(chan bool)
+	o.idle = make(chan bool)
+	if server {
+		o.conn = tls.Server(&tlsPipeConn{pipe: o}, cfg)
+	} else {
+		o.conn = tls.Client(&tlsPipeConn{pipe: o}, cfg)
+	}
+	return o
+}
+
+// run is the goroutine, it runs the handshake and then reads the application data until an error.
+func (o *TlsPipe) run() {
+	err := o.conn.Handshake()
+	if err == nil {
+		o.handshakeDone = true
+		buf := make([]byte, TLS_PIPE_READ_SIZE)
+		for err == nil {
+			var n int
+			n, err = o.conn.Read(buf)
+			o.app.Write(buf[:n])
+		}
+	}
+	o.err = err
+	o.running = false
+	o.idle <- false
+}
+
+// Start starts the handshake, the records of a client are ready once it returns.
+func (o *TlsPipe) Start() {
+	o.running = true
+	go o.run()
+	<-o.idle
+}
+
+// Push gives the records of the peer to the connection and waits until they are processed.
+func (o *TlsPipe) Push(records []byte) {
+	o.rx.Write(records)
+	if !o.running {
+		return
+	}
+	o.wake <- true
+	<-o.idle
+}
+
+// Pull returns the records to send and clears them.
+func (o *TlsPipe) Pull() []byte {
+	b := append([]byte{}, o.tx.Bytes()...)
+	o.tx.Reset()
+	return b
+}
+
+// Read returns the application data of the peer and clears it.
+func (o *TlsPipe) Read() []byte {
+	b := append([]byte{}, o.app.Bytes()...)
+	o.app.Reset()
+	return b
+}
+
+// Write encrypts application data, the records are ready in Pull. The peer may have closed its side already.
+func (o *TlsPipe) Write(data []byte) error {
+	if !o.handshakeDone {
+		return io.ErrClosedPipe
+	}
+	_, err := o.conn.Write(data)
+	return err
+}
+
+// CloseWrite sends close_notify, the records are ready in Pull.
+func (o *TlsPipe) CloseWrite() error {
+	if !o.handshakeDone {
+		return io.ErrClosedPipe
+	}
+	return o.conn.CloseWrite()
+}
+
+// ConnectionState returns the negotiated parameters of the connection.
+func (o *TlsPipe) ConnectionState() tls.ConnectionState {
+	return o.conn.ConnectionState()
+}
+
+// HandshakeDone returns true in case the handshake ended successfully.
+func (o *TlsPipe) HandshakeDone() bool {
+	return o.handshakeDone
+}
+
+// Running returns true in case the goroutine was started and did not exit.
+func (o *TlsPipe) Running() bool {
+	return o.running
+}
+
+// Err returns the error that stopped the goroutine, io.EOF in case the peer or Close ended the connection.
+func (o *TlsPipe) Err() error {
+	return o.err
+}
+
+// Failed returns true in case the connection stopped on an error.
+func (o *TlsPipe) Failed() bool {
+	return !o.running && o.err != nil && !o.closing
+}
+
+// Close stops the goroutine, the application data that was received before is still returned by Read.
+func (o *TlsPipe) Close() {
+	o.closing = true
+	if o.running {
+		o.wake <- false
+		<-o.idle
+	}
+}
+
+// tlsPipeConn is the net.Conn of the TLS connection of a pipe.
+type tlsPipeConn struct {
+	pipe *TlsPipe
+}
+
+// Read blocks until the main loop pushes records.
+func (o *tlsPipeConn) Read(b []byte) (int, error) {
+	p := o.pipe
+	for p.rx.Len() == 0 {
+		if p.closing {
+			return 0, io.EOF
+		}
+		p.idle <- true
+		if !<-p.wake {
+			return 0, io.EOF
+		}
+	}
+	return p.rx.Read(b)
+}
+
+func (o *tlsPipeConn) Write(b []byte) (int, error) {
+	return o.pipe.tx.Write(b)
+}
+
+func (o *tlsPipeConn) Close() error                       { return nil }
+func (o *tlsPipeConn) LocalAddr() net.Addr                { return tlsPipeAddr{} }
+func (o *tlsPipeConn) RemoteAddr() net.Addr               { return tlsPipeAddr{} }
+func (o *tlsPipeConn) SetDeadline(t time.Time) error      { return nil }
+func (o *tlsPipeConn) SetReadDeadline(t time.Time) error  { return nil }
+func (o *tlsPipeConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type tlsPipeAddr struct{}
+
+func (tlsPipeAddr) Network() string { return "pipe" }
+func (tlsPipeAddr) String() string  { return "pipe" }
@@ -25,6 +25,15 @@ func getAsInt(val interface{}) (int, bool) {
 	return 0, false
 }
 
+const (
+	/* ECN field of the ipv4 tos/ipv6 traffic class, RFC 3168 */
+	IP_ECN_NOT_ECT = 0x0
+	IP_ECN_ECT1    = 0x1
+	IP_ECN_ECT0    = 0x2
+	IP_ECN_CE      = 0x3
+	IP_ECN_MASK    = 0x3
+)
+
 func (o *baseSocket) init(client *core.CClient, ctx *TransportCtx) {
 	o.client = client
 	o.ns = client.Ns
@@ -70,7 +79,38 @@ func (o *baseSocket) setIoctlBase(m IoctlMap) error {
 	return nil
 }
 
+// setPktEcn sets the ECN field of the ip header of a packet that was built from the template
+func (o *baseSocket) setPktEcn(p []byte, ecn uint8) {
+	if o.ipv6 {
+		ipv6 := layers.IPv6Header(p[o.l3Offset : o.l3Offset+core.IPV6_HEADER_SIZE])
+		ipv6.SetTOS((ipv6.TOS() & ^uint8(IP_ECN_MASK)) | ecn)
+	} else {
+		ipv4 := layers.IPv4Header(p[o.l3Offset : o.l3Offset+20])
+		ipv4.SetTOS((ipv4.GetTOS() & ^uint8(IP_ECN_MASK)) | ecn)
+		ipv4.UpdateChecksum()
+	}
+}
+
+// getPktEcn returns the ECN field of the ip header of a received packet
+func getPktEcn(ps *core.ParserPacketState) uint8 {
+	p := ps.M.GetData()
+	if (p[ps.L3] >> 4) == 6 {
+		return layers.IPv6Header(p[ps.L3:ps.L3+core.IPV6_HEADER_SIZE]).TOS() & IP_ECN_MASK
+	}
+	return layers.IPv4Header(p[ps.L3:ps.L3+20]).GetTOS() & IP_ECN_MASK
+}
+
+// ecnInputBase counts the CE marks of a received packet, returns true in case of a CE mark
+func (o *baseSocket) ecnInputBase(ps *core.ParserPacketState) bool {
+	if getPktEcn(ps) != IP_ECN_CE {
+		return false
+	}
+	o.ecnCe++
+	return true
+}
+
 func (o *baseSocket) getIoctlBase(m IoctlMap) error {
+	m[IP_IOCTL_ECN_CE] = int(o.ecnCe)
 	// TOS/TTL
 	if o.ipv6 {
 		ipv6 := layers.IPv6Header(o.pktTemplate[o.l3Offset : o.l3Offset+core.IPV6_HEADER_SIZE])
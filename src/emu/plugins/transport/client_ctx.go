@@ -6,6 +6,7 @@
 package transport
 
 import (
+	"crypto/tls"
 	"emu/core"
 	"encoding/binary"
 	"encoding/hex"
@@ -31,6 +32,10 @@ type TransportCtxCfg struct {
 	TcpTxBufSize    *uint32 `json:"txbufsize" validate:"gte=8192 &lte=1048576"`
 	TcpDorfc1323    *bool   `json:"do_rfc1323"`
 	TcpMss          *uint16 `json:"mss" validate:"gte=10 &lte=9000"`
+	TcpSack         *bool   `json:"sack"`
+	TcpCc           *string `json:"cc"`
+	TcpEcn          *bool   `json:"ecn"`
+	Tls             *TlsCfg `json:"tls"`
 }
 
 type prototbl map[uint8]IServerSocketCb // per protocol accept callback
@@ -194,6 +199,8 @@ type ftStats struct {
 	dial               uint64 // dial
 	dial_wrong_network uint64 // dial - wrong network
 	dial_wrong_addr    uint64 // dial - wrong addr
+
+	ft_flush_addr_change uint64 // flows that were shutdown because the client address was changed
 }
 
 func newftStatsDb(o *ftStats) *core.CCounterDb {
@@ -295,6 +302,14 @@ func newftStatsDb(o *ftStats) *core.CCounterDb {
 		DumpZero: false,
 		Info:     core.ScERROR})
 
+	db.Add(&core.CCounterRec{
+		Counter:  &o.ft_flush_addr_change,
+		Name:     "ft_flush_addr_change",
+		Help:     "flows shutdown on client address change",
+		Unit:     "flows",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
 	db.Add(&core.CCounterRec{
 		Counter:  &o.src_port_alloc,
 		Name:     "src_port_alloc",
@@ -457,13 +472,18 @@ type TransportCtx struct {
 	Tctx     *core.CThreadCtx
 	tcpStats TcpStats
 	udpStats UdpStats
+	tlsStats TlsStats
 	timerw   *core.TimerCtx
 	cdbv     *core.CCounterDbVec
 	cdbtcp   *core.CCounterDb
 	cdbudp   *core.CCounterDb
+	cdbtls   *core.CCounterDb
 	timer    core.CHTimerObj
 	timerCb  ctxClientTimer
 
+	ipv4FragId uint16 /* identification of fragmented ipv4 datagrams */
+	ipv6FragId uint32 /* identification of fragmented ipv6 datagrams */
+
 	/* TCP global info */
 	tcp_now uint32 /* for RFC 1323 timestamps */
 	tcp_iss uint32 /* tcp initial send seq # */
@@ -476,6 +496,9 @@ type TransportCtx struct {
 	tcp_initwnd          uint32 /*  tcp_initwnd_factor *tcp_mssdflt*/
 	tcp_rttdflt          int16
 	tcp_do_rfc1323       bool
+	tcp_do_sack          bool
+	tcp_cc               string
+	tcp_do_ecn           bool
 	tcp_no_delay         uint8
 	tcp_no_delay_counter uint16 /* number of recv bytes to wait until ack them */
 	tcp_keepinit         uint16
@@ -494,6 +517,11 @@ type TransportCtx struct {
 	ftv6           flowTablev6
 	srcPorts       srcPortManager
 	serverCb       serverft // server callbacks
+
+	/* TLS, see tls.go */
+	tlsCfg     *tls.Config
+	tlsCfgErr  error
+	tlsSockets map[*TlsSocket]bool /* sockets with a running goroutine */
 }
 
 func updateInitwnd(mss uint16, initwnd uint16) uint16 {
@@ -517,6 +545,8 @@ func newCtx(c *core.CClient) *TransportCtx {
 	o.cdbv = core.NewCCounterDbVec("tcp")
 	o.cdbv.Add(o.cdbtcp)
 	o.cdbv.Add(o.cdbudp)
+	o.cdbtls = NewTlsStatsDb(&o.tlsStats)
+	o.cdbv.Add(o.cdbtls)
 	o.timer.SetCB(&o.timerCb, o, 0) // set the callback to OnEvent
 	o.restartTimer()
 
@@ -526,6 +556,7 @@ func newCtx(c *core.CClient) *TransportCtx {
 	o.ftv6 = make(flowTablev6)
 	o.srcPorts.init(o)
 	o.serverCb = make(serverft)
+	o.tlsSockets = make(map[*TlsSocket]bool)
 	return o
 }
 
@@ -567,6 +598,22 @@ func (o *TransportCtx) setCfg(cfg *TransportCtxCfg) {
 		o.tcp_mssdflt_ = *cfg.TcpMss
 	}
 
+	if cfg.TcpSack != nil {
+		o.tcp_do_sack = *cfg.TcpSack
+	}
+
+	if cfg.TcpCc != nil && newTcpCc(*cfg.TcpCc) != nil {
+		o.tcp_cc = *cfg.TcpCc
+	}
+
+	if cfg.TcpEcn != nil {
+		o.tcp_do_ecn = *cfg.TcpEcn
+	}
+
+	if cfg.Tls != nil {
+		o.tlsCfg, o.tlsCfgErr = newTlsConfig(cfg.Tls)
+	}
+
 }
 
 func (o *TransportCtx) getActiveFlows() uint64 {
@@ -577,6 +624,8 @@ func (o *TransportCtx) getActiveFlows() uint64 {
 /* we assume that there relatively small number of flows per
 client  so we could iterate it in atomic way wihtout stalling the scheduler*/
 func (o *TransportCtx) onRemove() {
+	o.onRemoveTls()
+
 	for _, flow := range o.ftv4 {
 		var or socketRemoveIf
 		or = flow.(socketRemoveIf)
@@ -594,6 +643,26 @@ func (o *TransportCtx) onRemove() {
 	}
 }
 
+/* onAddrChange shutdown the flows of the address family that was changed,
+the flows are bound to the old address */
+func (o *TransportCtx) onAddrChange(ipv4 bool, ipv6 bool) {
+	var flows []SocketApi
+	if ipv4 {
+		for _, flow := range o.ftv4 {
+			flows = append(flows, flow.(SocketApi))
+		}
+	}
+	if ipv6 {
+		for _, flow := range o.ftv6 {
+			flows = append(flows, flow.(SocketApi))
+		}
+	}
+	for _, s := range flows {
+		o.flowTableStats.ft_flush_addr_change++
+		s.Shutdown()
+	}
+}
+
 func (o *TransportCtx) removeFlowv4(tuple *c5tuplekeyv4, f interface{}) bool {
 	v, ok := o.ftv4[*tuple]
 	if !ok {
@@ -682,6 +751,7 @@ func (o *TransportCtx) init() {
 	o.tcp_rx_socket_bsize = 32 * 1024
 	o.tcp_tx_socket_bsize = 32 * 1024
 	o.tcprexmtthresh = 3
+	o.tcp_cc = TCP_CC_RENO
 	o.tcp_rttdflt = int16(TCPTV_SRTTDFLT / uint16(PR_SLOWHZ))
 	o.tcp_keepcnt = TCPTV_KEEPCNT          /* max idle probes */
 	o.tcp_maxpersistidle = TCPTV_KEEP_IDLE /* max idle time in persist */
@@ -977,12 +1047,13 @@ func (o *TransportCtx) handleRxPacket(ps *core.ParserPacketState) int {
 //	Dial("tcp", "[2001:db8::1]:80",cb,{"tos":12}, 0)
 //	Dial("udp", "192.0.2.1:80",cb,nil, &core.MACKey{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
 //	Dial("tcp", "192.0.2.1:80",cb,nil, nil, 5353)
+//	Dial("tls", "192.0.2.1:443",cb,nil, nil, 0)
 func (o *TransportCtx) Dial(network, address string, cb ISocketCb, ioctl IoctlMap, dstMac *core.MACKey, srcPort uint16) (SocketApi, error) {
 
 	o.flowTableStats.dial++
 
 	switch network {
-	case "tcp", "udp":
+	case "tcp", "udp", "tls":
 	default:
 		o.flowTableStats.dial_wrong_network++
 		return nil, fmt.Errorf(" unsupported %v network", network)
@@ -1010,6 +1081,8 @@ func (o *TransportCtx) Dial(network, address string, cb ISocketCb, ioctl IoctlMa
 		return o.dialTcp(dst, port16, cb, ioctl, dstMac, srcPort)
 	case "udp":
 		return o.dialUdp(dst, port16, cb, ioctl, dstMac, srcPort)
+	case "tls":
+		return o.dialTls(dst, port16, cb, ioctl, dstMac, srcPort)
 	}
 	return nil, fmt.Errorf(" unsupported %v network", network)
 }
@@ -1157,7 +1230,7 @@ func (o *TransportCtx) lookupServerPort(port uint16, proto uint8) IServerSocketC
 func (o *TransportCtx) parseNA(network, address string, port *uint16, proto *uint8) error {
 	var proid uint8
 	switch network {
-	case "tcp":
+	case "tcp", "tls":
 		proid = TCP_PROTO
 	case "udp":
 		proid = UDP_PROTO
@@ -1193,6 +1266,10 @@ create a TCP server
 
 ctx.Listen("tcp",":8080",cb)
 
+create a TLS server, see tls.go
+
+ctx.Listen("tls",":443",cb)
+
 to remove the callback
 
 ctx.UnListen("tcp",":8080",cb)
@@ -1204,6 +1281,13 @@ func (o *TransportCtx) Listen(network, address string, cb IServerSocketCb) error
 	if err := o.parseNA(network, address, &port, &proto); err != nil {
 		return err
 	}
+	if network == "tls" {
+		if _, err := o.getTlsConfig(true, nil); err != nil {
+			o.tlsStats.tls_cfg_err++
+			return err
+		}
+		cb = &tlsServerCb{ctx: o, cb: cb}
+	}
 	if !o.addServerCb(port, proto, cb) {
 		return fmt.Errorf(" port %v already register for %s network", port, network)
 	}
@@ -1219,6 +1303,11 @@ func (o *TransportCtx) UnListen(network, address string, cb IServerSocketCb) err
 	if err := o.parseNA(network, address, &port, &proto); err != nil {
 		return err
 	}
+	if network == "tls" {
+		if t, ok := o.lookupServerPort(port, proto).(*tlsServerCb); ok && t.cb == cb {
+			cb = t
+		}
+	}
 	if !o.removeServerCb(port, proto, cb) {
 		return fmt.Errorf(" port %v is no register for %s network", port, network)
 	}
@@ -9,6 +9,7 @@ package transport
 
 import (
 	"emu/core"
+	"external/google/gopacket/layers"
 	"external/osamingo/jsonrpc"
 	"fmt"
 
@@ -50,7 +51,7 @@ type PluginTransClient struct {
 func NewTransClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, error) {
 	o := new(PluginTransClient)
 	o.InitPluginBase(ctx, o) /* init base object*/
-	o.RegisterEvents(ctx, []string{}, o)
+	o.RegisterEvents(ctx, transEvents, o)
 	nsplg := o.Ns.PluginCtx.GetOrCreate(TRANS_PLUG)
 	o.ns = nsplg.Ext.(*PluginTransNs)
 
@@ -58,11 +59,24 @@ func NewTransClient(ctx *core.PluginCtx, initJson []byte) (*core.PluginBase, err
 	return &o.PluginBase, nil
 }
 
-func (o *PluginTransClient) OnEvent(msg string, a, b interface{}) {
+var transEvents = []string{core.MSG_UPDATE_CLIENT}
 
+func (o *PluginTransClient) OnEvent(msg string, a, b interface{}) {
+	switch msg {
+	case core.MSG_UPDATE_CLIENT:
+		ev := a.(*core.CClientUpdate)
+		tl := o.Client.GetTransportCtx()
+		if tl == nil {
+			return
+		}
+		tx := tl.(*TransportCtx)
+		tx.onAddrChange(ev.Changed&core.CLIENT_UPDATE_IPV4 != 0,
+			ev.Changed&core.CLIENT_UPDATE_IPV6 != 0)
+	}
 }
 
 func (o *PluginTransClient) OnRemove(ctx *core.PluginCtx) {
+	ctx.UnregisterEvents(&o.PluginBase, transEvents)
 	tl := o.Client.GetTransportCtx()
 	if tl == nil {
 		return
@@ -204,7 +218,9 @@ func init() {
 	core.RegisterCB("transport_client_cnt", ApiTransClientCntHandler{}, false) // get counters/meta
 
 	/* register callback for rx side*/
-	core.ParserRegister("transport", HandleRxTransPacket)
+	core.ParserRegister("transport", HandleRxTransPacket,
+		core.ParserMatch{IPProto: uint8(layers.IPProtocolTCP)},
+		core.ParserMatch{IPProto: uint8(layers.IPProtocolUDP)})
 }
 
 func Register(ctx *core.CThreadCtx) {
@@ -0,0 +1,288 @@
+// Copyright (c) 2021 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License")
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package transport
+
+/*
+TCP congestion control
+
+The congestion control algorithm owns snd_cwnd and snd_ssthresh of the socket, the hooks follow the BSD cc_algo.
+The algorithm is selected per client by the cc field of the transport configuration and per socket by the cc ioctl.
+
+reno    - the classic BSD Reno, the default
+newreno - Reno with NewReno fast recovery, RFC 6582
+cubic   - CUBIC window growth, RFC 8312, with NewReno fast recovery
+*/
+
+import (
+	"math"
+)
+
+const (
+	TCP_CC_RENO    = "reno"
+	TCP_CC_NEWRENO = "newreno"
+	TCP_CC_CUBIC   = "cubic"
+
+	/* congestion signals */
+	CC_NDUPACK = 1 /* dup ack threshold was reached */
+	CC_RTO     = 2 /* retransmit timeout */
+	CC_ECN     = 3 /* ECN echo */
+
+	CUBIC_C    = 0.4
+	CUBIC_BETA = 0.7
+)
+
+type tcpCongestionControl interface {
+	getName() string
+	// ackReceived opens the window on an ACK of new data, it is not called during loss recovery
+	ackReceived(tp *TcpSocket, acked uint32)
+	// congSignal sets the slow start threshold (and the window in case of a timeout or ECN) on congestion
+	congSignal(tp *TcpSocket, signal uint8)
+	// postRecovery sets the window at the end of loss recovery
+	postRecovery(tp *TcpSocket)
+	// afterIdle restarts the window after an idle period
+	afterIdle(tp *TcpSocket)
+	// newReno returns true in case fast recovery should continue on partial ACKs
+	newReno() bool
+}
+
+var tcpCcAlgos = map[string]func() tcpCongestionControl{
+	TCP_CC_RENO:    func() tcpCongestionControl { return new(tcpCcReno) },
+	TCP_CC_NEWRENO: func() tcpCongestionControl { return new(tcpCcNewReno) },
+	TCP_CC_CUBIC:   func() tcpCongestionControl { return new(tcpCcCubic) },
+}
+
+// newTcpCc returns a new instance of the algorithm, nil in case the name is not known
+func newTcpCc(name string) tcpCongestionControl {
+	f, ok := tcpCcAlgos[name]
+	if !ok {
+		return nil
+	}
+	return f()
+}
+
+/* reno */
+
+type tcpCcReno struct {
+}
+
+func (o *tcpCcReno) getName() string {
+	return TCP_CC_RENO
+}
+
+func (o *tcpCcReno) ackReceived(tp *TcpSocket, acked uint32) {
+	/*
+	 * When new data is acked, open the congestion window.
+	 * If the window gives us less than ssthresh packets
+	 * in flight, open exponentially (maxseg per packet).
+	 * Otherwise open linearly: maxseg per window
+	 * (maxseg * (maxseg / cwnd) per packet).
+	 */
+	var cw uint32
+	var incr uint32
+	cw = tp.snd_cwnd
+	incr = uint32(tp.maxseg)
+
+	if cw > tp.snd_ssthresh {
+		incr = incr * incr / cw
+	}
+	tp.snd_cwnd = bsd_umin(cw+incr, TCP_MAXWIN<<tp.snd_scale)
+}
+
+func (o *tcpCcReno) congSignal(tp *TcpSocket, signal uint8) {
+	/*
+	 * Use half the current window size, truncated to a multiple of the mss
+	 * and not less than 2 mss as the threshold.
+	 */
+	win := bsd_umin(tp.snd_wnd, tp.snd_cwnd) / 2 / uint32(tp.maxseg)
+	if win < 2 {
+		win = 2
+	}
+	tp.snd_ssthresh = win * uint32(tp.maxseg)
+	if signal == CC_RTO {
+		tp.snd_cwnd = uint32(tp.maxseg)
+	} else if signal == CC_ECN {
+		tp.snd_cwnd = tp.snd_ssthresh
+	}
+}
+
+func (o *tcpCcReno) postRecovery(tp *TcpSocket) {
+	/*
+	 * If the congestion window was inflated to account
+	 * for the other side's cached packets, retract it.
+	 */
+	if tp.snd_cwnd > tp.snd_ssthresh {
+		tp.snd_cwnd = tp.snd_ssthresh
+	}
+}
+
+func (o *tcpCcReno) afterIdle(tp *TcpSocket) {
+	tp.snd_cwnd = uint32(tp.maxseg)
+}
+
+func (o *tcpCcReno) newReno() bool {
+	return false
+}
+
+/* newreno */
+
+type tcpCcNewReno struct {
+	tcpCcReno
+}
+
+func (o *tcpCcNewReno) getName() string {
+	return TCP_CC_NEWRENO
+}
+
+func (o *tcpCcNewReno) newReno() bool {
+	return true
+}
+
+/* cubic */
+
+type tcpCcCubic struct {
+	tcpCcNewReno
+	wmax       float64 /* window before the last reduction, in bytes */
+	k          float64 /* time to grow back to origin, in sec */
+	origin     float64 /* the plateau of the cubic function, in bytes */
+	epochStart float64 /* start of the congestion avoidance epoch, in sec */
+	epochValid bool
+}
+
+func (o *tcpCcCubic) getName() string {
+	return TCP_CC_CUBIC
+}
+
+// srttSec returns the smoothed rtt in sec
+func (o *tcpCcCubic) srttSec(tp *TcpSocket) float64 {
+	return float64(tp.srtt) / float64(int(1)<<TCP_RTT_SHIFT) / float64(PR_SLOWHZ)
+}
+
+func (o *tcpCcCubic) ackReceived(tp *TcpSocket, acked uint32) {
+	if tp.snd_cwnd <= tp.snd_ssthresh {
+		/* slow start */
+		o.tcpCcReno.ackReceived(tp, acked)
+		return
+	}
+
+	mss := float64(tp.maxseg)
+	cwnd := float64(tp.snd_cwnd)
+	now := tp.tctx.GetTickSimInSec()
+	if !o.epochValid {
+		o.epochValid = true
+		o.epochStart = now
+		if cwnd < o.wmax {
+			o.k = math.Cbrt((o.wmax - cwnd) / mss / CUBIC_C)
+			o.origin = o.wmax
+		} else {
+			o.k = 0
+			o.origin = cwnd
+		}
+	}
+
+	rtt := o.srttSec(tp)
+	t := now - o.epochStart + rtt
+	target := o.origin + CUBIC_C*math.Pow(t-o.k, 3)*mss
+	if target > 1.5*cwnd {
+		target = 1.5 * cwnd
+	}
+
+	var incr float64
+	if target > cwnd {
+		incr = (target - cwnd) / cwnd * mss
+	} else {
+		/* close to the plateau, grow very slowly */
+		incr = mss * mss / (100 * cwnd)
+	}
+
+	/* TCP friendly region, don't grow slower than Reno */
+	if rtt > 0 {
+		west := o.wmax*CUBIC_BETA + 3*(1-CUBIC_BETA)/(1+CUBIC_BETA)*(t/rtt)*mss
+		if west > cwnd+incr {
+			incr = math.Min(west-cwnd, mss)
+		}
+	}
+
+	if acked < uint32(tp.maxseg) {
+		incr = incr * float64(acked) / mss
+	}
+	tp.snd_cwnd = bsd_umin(tp.snd_cwnd+uint32(incr), TCP_MAXWIN<<tp.snd_scale)
+}
+
+func (o *tcpCcCubic) congSignal(tp *TcpSocket, signal uint8) {
+	cwnd := float64(bsd_umin(tp.snd_wnd, tp.snd_cwnd))
+	o.epochValid = false
+	/* fast convergence */
+	if cwnd < o.wmax {
+		o.wmax = cwnd * (1 + CUBIC_BETA) / 2
+	} else {
+		o.wmax = cwnd
+	}
+	ssthresh := uint32(cwnd * CUBIC_BETA)
+	if ssthresh < 2*uint32(tp.maxseg) {
+		ssthresh = 2 * uint32(tp.maxseg)
+	}
+	tp.snd_ssthresh = ssthresh
+	if signal == CC_RTO {
+		tp.snd_cwnd = uint32(tp.maxseg)
+	} else if signal == CC_ECN {
+		tp.snd_cwnd = tp.snd_ssthresh
+	}
+}
+
+func (o *tcpCcCubic) afterIdle(tp *TcpSocket) {
+	o.epochValid = false
+	o.tcpCcReno.afterIdle(tp)
+}
+
+/* socket side */
+
+// ccSet replaces the congestion control algorithm of the socket, returns false in case the name is not known
+func (o *TcpSocket) ccSet(name string) bool {
+	cc := newTcpCc(name)
+	if cc == nil {
+		return false
+	}
+	o.cc = cc
+	return true
+}
+
+func (o *TcpSocket) ccAckReceived(acked uint32) {
+	o.cc.ackReceived(o, acked)
+}
+
+func (o *TcpSocket) ccCongSignal(signal uint8) {
+	o.ctx.tcpStats.tcps_cc_signal++
+	o.cc.congSignal(o, signal)
+}
+
+func (o *TcpSocket) ccPostRecovery() {
+	o.cc.postRecovery(o)
+}
+
+// ccPartialAck retransmits the first unacked segment on a partial ACK during NewReno fast recovery, RFC 6582
+func (o *TcpSocket) ccPartialAck(acked uint32) {
+	onxt := o.snd_nxt
+	ocwnd := o.snd_cwnd
+	o.timer[TCPT_REXMT] = 0
+	o.rtt = 0
+	o.snd_nxt = o.snd_una
+	o.snd_cwnd = uint32(o.maxseg)
+	o.output()
+	if seq_gt(onxt, o.snd_nxt) {
+		o.snd_nxt = onxt
+	}
+	/*
+	 * Partial window deflation, deflate by the amount of new data
+	 * acked and add back one segment.
+	 */
+	if ocwnd > acked {
+		o.snd_cwnd = ocwnd - acked
+	} else {
+		o.snd_cwnd = 0
+	}
+	o.snd_cwnd += uint32(o.maxseg)
+	o.ctx.tcpStats.tcps_cc_partialack++
+}
@@ -81,11 +81,140 @@ type TcpStats struct {
 	tcps_already_closed    uint64 /* close  API error */
 	tcps_already_opened    uint64 /* connect/listen  API error */
 	tcps_write_while_drain uint64 /* write  API error */
+
+	tcps_sack_sndblocks        uint64 /* SACK blocks sent */
+	tcps_sack_rcvblocks        uint64 /* SACK blocks received */
+	tcps_sack_recovery_episode uint64 /* SACK loss recovery episodes */
+	tcps_sack_rexmitpack       uint64 /* data packets retransmitted by SACK loss recovery */
+	tcps_sack_rexmitbyte       uint64 /* data bytes retransmitted by SACK loss recovery */
+	tcps_sack_rexmit_avoided   uint64 /* SACKed bytes that were not retransmitted after a timeout */
+
+	tcps_cc_signal     uint64 /* congestion signals to the congestion control */
+	tcps_cc_partialack uint64 /* NewReno partial acks */
+
+	tcps_ecn_ce     uint64 /* packets received with a CE mark */
+	tcps_ecn_sndect uint64 /* data packets sent with ECT(0) */
+	tcps_ecn_sndece uint64 /* packets sent with ECE */
+	tcps_ecn_rcvece uint64 /* packets received with ECE */
+	tcps_ecn_sndcwr uint64 /* packets sent with CWR */
+	tcps_ecn_rcvcwr uint64 /* packets received with CWR */
 }
 
 func NewTcpStatsDb(o *TcpStats) *core.CCounterDb {
 	db := core.NewCCounterDb("tcp")
 
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tcps_ecn_ce,
+		Name:     "ecn_ce",
+		Help:     "packets received with a CE mark",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tcps_ecn_sndect,
+		Name:     "ecn_sndect",
+		Help:     "data packets sent with ECT(0)",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tcps_ecn_sndece,
+		Name:     "ecn_sndece",
+		Help:     "packets sent with ECE",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tcps_ecn_rcvece,
+		Name:     "ecn_rcvece",
+		Help:     "packets received with ECE",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tcps_ecn_sndcwr,
+		Name:     "ecn_sndcwr",
+		Help:     "packets sent with CWR",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tcps_ecn_rcvcwr,
+		Name:     "ecn_rcvcwr",
+		Help:     "packets received with CWR",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tcps_cc_signal,
+		Name:     "cc_signal",
+		Help:     "congestion signals to the congestion control",
+		Unit:     "event",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tcps_cc_partialack,
+		Name:     "cc_partialack",
+		Help:     "NewReno partial acks",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tcps_sack_sndblocks,
+		Name:     "sack_sndblocks",
+		Help:     "SACK blocks sent",
+		Unit:     "blocks",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tcps_sack_rcvblocks,
+		Name:     "sack_rcvblocks",
+		Help:     "SACK blocks received",
+		Unit:     "blocks",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tcps_sack_recovery_episode,
+		Name:     "sack_recovery_episode",
+		Help:     "SACK loss recovery episodes",
+		Unit:     "event",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tcps_sack_rexmitpack,
+		Name:     "sack_rexmitpack",
+		Help:     "data packets retransmitted by SACK loss recovery",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tcps_sack_rexmitbyte,
+		Name:     "sack_rexmitbyte",
+		Help:     "data bytes retransmitted by SACK loss recovery",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tcps_sack_rexmit_avoided,
+		Name:     "sack_rexmit_avoided",
+		Help:     "SACKed bytes that were not retransmitted after a timeout",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
 	db.Add(&core.CCounterRec{
 		Counter:  &o.tcps_write_while_drain,
 		Name:     "write_while_drain",
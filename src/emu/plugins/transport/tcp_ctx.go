@@ -38,6 +38,7 @@ const (
 	TF_CLOSE_NOTIFY uint16 = 0x0800 /* CLOSE was notified  */
 	TF_WRITE_DRAIN  uint16 = 0x1000 /* write with a buffer to drain, not allowed to add more */
 	TF_CLOSE_DEFER  uint16 = 0x2000 /* mask as closed  */
+	TF_REQ_SACK     uint16 = 0x4000 /* have/will request SACK */
 
 	TH_FIN        = 0x01
 	TH_SYN        = 0x02
@@ -45,6 +46,8 @@ const (
 	TH_PUSH       = 0x08
 	TH_ACK        = 0x10
 	TH_URG        = 0x20
+	TH_ECE        = 0x40
+	TH_CWR        = 0x80
 	TCP_MAXWIN    = 65535 /* largest value for (unscaled) window */
 	MAX_TCPOPTLEN = 32    /* max # bytes that go in options */
 
@@ -192,6 +195,7 @@ type baseSocket struct {
 	multicast    bool
 	interrupt    bool
 	srcPortAlloc bool
+	ecnCe        uint32 /* packets received with a CE mark */
 
 	client      *core.CClient
 	ns          *core.CNSCtx
@@ -296,6 +300,24 @@ type TcpSocket struct {
 	socket         *socketData
 	txqueue        []byte /* tx pointer for user data */
 
+	/* SACK, see tcp_sack.go */
+	reassq         []reassSeg  /* out-of-order segments, sorted and merged */
+	rcv_lastsack   sackBlock   /* the most recent out-of-order segment, the first SACK block */
+	rxdata         []byte      /* in order data pulled from the reassembly queue, handed to the application */
+	sackRcv        []sackBlock /* SACK blocks of the current input segment */
+	sackBlocks     []sackBlock /* scoreboard, SACKed blocks above snd_una */
+	sack_recovery  bool        /* in loss recovery */
+	snd_recover    uint32      /* recovery point, snd_max when loss recovery started */
+	sack_rxmit_nxt uint32      /* highest retransmitted sequence number in loss recovery */
+
+	/* congestion control, see tcp_cc.go */
+	cc            tcpCongestionControl
+	fast_recovery bool /* in NewReno fast recovery */
+
+	/* ECN, see tcp_ecn.go */
+	ecn_flags       uint8
+	snd_ecn_recover uint32 /* snd_max when the window was reduced by ECN */
+
 	// tunables that can be set in SetIoctl
 	tun_mss         uint16
 	tun_init_window uint16
@@ -0,0 +1,108 @@
+// Copyright (c) 2021 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License")
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package transport
+
+/*
+TCP explicit congestion notification, RFC 3168
+
+ECN is requested by the ecn ioctl (or the ecn field of the transport configuration). The SYN is sent with ECE and CWR,
+the SYN-ACK with ECE. Once negotiated new data is sent with ECT(0).
+
+Receiver side: a CE mark sets ECE on the ACKs until a segment with CWR is received.
+Sender side: an ACK with ECE reduces the window by the congestion control, at most once per window of data, and the
+next new data is sent with CWR.
+*/
+
+import (
+	"emu/core"
+)
+
+const (
+	ECN_REQ     = 0x01 /* have/will request ECN */
+	ECN_PERMIT  = 0x02 /* ECN was negotiated */
+	ECN_SND_ECE = 0x04 /* CE was received, send ECE until CWR is received */
+	ECN_SND_CWR = 0x08 /* the window was reduced, send CWR with the next new data */
+)
+
+// ecnEnabled returns true in case ECN was negotiated
+func (o *TcpSocket) ecnEnabled() bool {
+	return (o.ecn_flags & ECN_PERMIT) > 0
+}
+
+// ecnSynInput negotiates ECN on a received SYN or SYN-ACK
+func (o *TcpSocket) ecnSynInput(tiflags uint8) {
+	if (o.ecn_flags & ECN_REQ) == 0 {
+		return
+	}
+	if (tiflags & TH_ACK) > 0 {
+		/* SYN-ACK, only ECE */
+		if (tiflags & (TH_ECE | TH_CWR)) == TH_ECE {
+			o.ecn_flags |= ECN_PERMIT
+		}
+	} else {
+		if (tiflags & (TH_ECE | TH_CWR)) == (TH_ECE | TH_CWR) {
+			o.ecn_flags |= ECN_PERMIT
+		}
+	}
+}
+
+// ecnInput handles the ECN of a received segment, receiver side
+func (o *TcpSocket) ecnInput(ps *core.ParserPacketState, tiflags uint8) {
+	sts := &o.ctx.tcpStats
+	if (tiflags & TH_CWR) > 0 {
+		sts.tcps_ecn_rcvcwr++
+		o.ecn_flags &= ^uint8(ECN_SND_ECE)
+	}
+	if o.ecnInputBase(ps) {
+		sts.tcps_ecn_ce++
+		o.ecn_flags |= ECN_SND_ECE
+	}
+}
+
+// ecnAckInput reduces the window on an ACK with ECE, sender side
+func (o *TcpSocket) ecnAckInput(ack uint32) {
+	sts := &o.ctx.tcpStats
+	sts.tcps_ecn_rcvece++
+	if o.sack_recovery || o.fast_recovery || seq_leq(ack, o.snd_ecn_recover) {
+		/* the window was already reduced for this window of data */
+		return
+	}
+	o.snd_ecn_recover = o.snd_max
+	o.ccCongSignal(CC_ECN)
+	o.ecn_flags |= ECN_SND_CWR
+}
+
+// ecnOutput returns the flags of a segment with the ECN flags, marks new data with ECT(0)
+func (o *TcpSocket) ecnOutput(flags uint8, datalen int32, rexmt bool, p []byte) uint8 {
+	sts := &o.ctx.tcpStats
+	if (flags & TH_SYN) > 0 {
+		if (flags & TH_ACK) == 0 {
+			if (o.ecn_flags & ECN_REQ) > 0 {
+				flags |= TH_ECE | TH_CWR
+			}
+		} else if o.ecnEnabled() {
+			flags |= TH_ECE
+		}
+		return flags
+	}
+	if !o.ecnEnabled() || (flags&TH_RST) > 0 {
+		return flags
+	}
+	if (o.ecn_flags & ECN_SND_ECE) > 0 {
+		flags |= TH_ECE
+		sts.tcps_ecn_sndece++
+	}
+	if datalen > 0 && !rexmt {
+		if (o.ecn_flags & ECN_SND_CWR) > 0 {
+			flags |= TH_CWR
+			o.ecn_flags &= ^uint8(ECN_SND_CWR)
+			sts.tcps_ecn_sndcwr++
+		}
+		o.setPktEcn(p, IP_ECN_ECT0)
+		sts.tcps_ecn_sndect++
+	}
+	return flags
+}
@@ -62,7 +62,14 @@ func (o *TcpSocket) input(ps *core.ParserPacketState) int {
 	r := o._input(ps)
 	if o.cbmask > 0 {
 		if o.cbmask&SocketRxData > 0 {
-			o.cb.OnRxData(ps.M.GetData()[:])
+			if o.rxdata != nil {
+				/* data that was pulled from the reassembly queue */
+				rxdata := o.rxdata
+				o.rxdata = nil
+				o.cb.OnRxData(rxdata)
+			} else {
+				o.cb.OnRxData(ps.M.GetData()[:])
+			}
 		}
 		if (o.cbmask & SocketRxMask) > 0 {
 			o.cb.OnRxEvent(SocketEventType(o.cbmask))
@@ -126,6 +133,7 @@ func (o *TcpSocket) _input(ps *core.ParserPacketState) int {
 
 	so := o.socket
 	sts := &o.ctx.tcpStats
+	o.sackRcv = o.sackRcv[:0]
 
 	if o.state == TCPS_LISTEN {
 
@@ -170,6 +178,10 @@ func (o *TcpSocket) _input(ps *core.ParserPacketState) int {
 			&ts_present, &ts_val, &ts_ecr)
 	}
 
+	if o.ecnEnabled() {
+		o.ecnInput(ps, tiflags)
+	}
+
 	/*
 	 * Header prediction: check for the two common cases
 	 * of a uni-directional data xfer.  If the packet has
@@ -204,7 +216,9 @@ func (o *TcpSocket) _input(ps *core.ParserPacketState) int {
 		if ti_len == 0 {
 			if seq_gt(tcph.Ack, o.snd_una) &&
 				seq_leq(tcph.Ack, o.snd_max) &&
-				o.snd_cwnd >= o.snd_wnd {
+				o.snd_cwnd >= o.snd_wnd &&
+				len(o.sackRcv) == 0 && len(o.sackBlocks) == 0 &&
+				!o.fast_recovery && ((tiflags & TH_ECE) == 0) {
 				/*
 				 * this is a pure ack for outstanding data.
 				 */
@@ -332,6 +346,7 @@ func (o *TcpSocket) _input(ps *core.ParserPacketState) int {
 				o.dooptions(&tcph,
 					&ts_present, &ts_val, &ts_ecr)
 			}
+			o.ecnSynInput(tiflags)
 			if iss > 0 {
 				o.iss = iss
 			} else {
@@ -376,6 +391,7 @@ func (o *TcpSocket) _input(ps *core.ParserPacketState) int {
 		if !tisyn {
 			goto drop
 		}
+		o.ecnSynInput(tiflags)
 		if tiack {
 			o.snd_una = tcph.Ack
 			if seq_lt(o.snd_nxt, o.snd_una) {
@@ -663,6 +679,12 @@ func (o *TcpSocket) _input(ps *core.ParserPacketState) int {
 		TCPS_CLOSING,
 		TCPS_LAST_ACK,
 		TCPS_TIME_WAIT:
+		if o.sackEnabled() {
+			o.sackUpdate(tcph.Ack)
+		}
+		if o.ecnEnabled() && ((tiflags & TH_ECE) > 0) {
+			o.ecnAckInput(tcph.Ack)
+		}
 		if seq_leq(tcph.Ack, o.snd_una) {
 			if (ti_len == 0) && (tiwin == o.snd_wnd) {
 				if o.state != TCPS_FIN_WAIT_2 {
@@ -695,16 +717,28 @@ func (o *TcpSocket) _input(ps *core.ParserPacketState) int {
 				if (o.timer[TCPT_REXMT] == 0) ||
 					(tcph.Ack != o.snd_una) {
 					o.dupacks = 0
+				} else if o.sackEnabled() {
+					o.dupacks++
+					if o.sackDupAck() {
+						goto drop
+					}
 				} else {
 					o.dupacks++
-					if o.dupacks == o.ctx.tcprexmtthresh {
+					if o.fast_recovery {
+						/* NewReno fast recovery, inflate the window for each dup ack */
+						o.snd_cwnd += uint32(o.maxseg)
+						o.output()
+						goto drop
+					}
+					if o.dupacks == o.ctx.tcprexmtthresh &&
+						!(o.cc.newReno() && seq_leq(tcph.Ack, o.snd_recover)) {
 						var onxt uint32
 						onxt = o.snd_nxt
-						win := bsd_umin(o.snd_wnd, o.snd_cwnd) / 2 / uint32(o.maxseg)
-						if win < 2 {
-							win = 2
+						if o.cc.newReno() {
+							o.fast_recovery = true
+							o.snd_recover = o.snd_max
 						}
-						o.snd_ssthresh = win * uint32(o.maxseg)
+						o.ccCongSignal(CC_NDUPACK)
 						o.timer[TCPT_REXMT] = 0
 						o.rtt = 0
 						o.snd_nxt = tcph.Ack
@@ -715,7 +749,7 @@ func (o *TcpSocket) _input(ps *core.ParserPacketState) int {
 							o.snd_nxt = onxt
 						}
 						goto drop
-					} else if o.dupacks > o.ctx.tcprexmtthresh {
+					} else if o.dupacks > o.ctx.tcprexmtthresh && !o.cc.newReno() {
 						o.snd_cwnd += uint32(o.maxseg)
 						o.output()
 						goto drop
@@ -726,12 +760,19 @@ func (o *TcpSocket) _input(ps *core.ParserPacketState) int {
 			}
 		} else {
 			/*
-			 * If the congestion window was inflated to account
-			 * for the other side's cached packets, retract it.
+			 * End the fast recovery, in case of NewReno only when
+			 * all the data of the recovery point was acked.
 			 */
-			if (o.dupacks > o.ctx.tcprexmtthresh) &&
-				(o.snd_cwnd > o.snd_ssthresh) {
-				o.snd_cwnd = o.snd_ssthresh
+			partialack := false
+			if o.fast_recovery {
+				if seq_lt(tcph.Ack, o.snd_recover) {
+					partialack = true
+				} else {
+					o.fast_recovery = false
+					o.ccPostRecovery()
+				}
+			} else if o.dupacks > o.ctx.tcprexmtthresh {
+				o.ccPostRecovery()
 			}
 			o.dupacks = 0
 			if seq_gt(tcph.Ack, o.snd_max) {
@@ -769,22 +810,11 @@ func (o *TcpSocket) _input(ps *core.ParserPacketState) int {
 				o.timer[TCPT_REXMT] = o.rxtcur
 			}
 			/*
-			 * When new data is acked, open the congestion window.
-			 * If the window gives us less than ssthresh packets
-			 * in flight, open exponentially (maxseg per packet).
-			 * Otherwise open linearly: maxseg per window
-			 * (maxseg * (maxseg / cwnd) per packet).
+			 * When new data is acked, let the congestion control
+			 * open the congestion window.
 			 */
-			{
-				var cw uint32
-				var incr uint32
-				cw = o.snd_cwnd
-				incr = uint32(o.maxseg)
-
-				if cw > o.snd_ssthresh {
-					incr = incr * incr / cw
-				}
-				o.snd_cwnd = bsd_umin(cw+incr, TCP_MAXWIN<<o.snd_scale)
+			if !o.sack_recovery && !o.fast_recovery {
+				o.ccAckReceived(acked)
 			}
 
 			if acked > so.so_snd.getSize() {
@@ -807,6 +837,15 @@ func (o *TcpSocket) _input(ps *core.ParserPacketState) int {
 			if seq_lt(o.snd_nxt, o.snd_una) {
 				o.snd_nxt = o.snd_una
 			}
+			if o.sackEnabled() {
+				o.sackClean()
+				if o.sack_recovery {
+					o.sackPartialAck()
+				}
+			}
+			if partialack {
+				o.ccPartialAck(acked)
+			}
 
 			switch o.state {
 
@@ -1155,6 +1194,8 @@ func (o *TcpSocket) sendseqinit() {
 	o.snd_max = o.snd_up
 	o.snd_nxt = o.snd_max
 	o.snd_una = o.snd_nxt
+	o.snd_recover = o.snd_una
+	o.snd_ecn_recover = o.snd_una
 }
 
 func (o *TcpSocket) rcvseqinit() {
@@ -1162,9 +1203,9 @@ func (o *TcpSocket) rcvseqinit() {
 	o.rcv_adv = o.rcv_nxt
 }
 
-/* for now reass is not supported, segment need to come in order ! */
+/* reassembly is supported only in case SACK was negotiated, otherwise segment need to come in order ! */
 func (o *TcpSocket) reass_is_exists() bool {
-	return false
+	return len(o.reassq) > 0
 }
 
 func (o *TcpSocket) soisconnected_cb() {
@@ -1195,6 +1236,18 @@ func (o *TcpSocket) dooptions(tcph *layers.TCP,
 				}
 			}
 
+		case layers.TCPOptionKindSACKPermitted:
+			if obj.OptionLength == TCPOLEN_SACK_PERMITTED {
+				if (tcph.Flags & TH_SYN) > 0 {
+					o.flags |= TF_SACK_PERMIT
+				}
+			}
+
+		case layers.TCPOptionKindSACK:
+			if o.sackEnabled() && ((tcph.Flags & TH_SYN) == 0) {
+				o.sackParseOption(&obj)
+			}
+
 		case layers.TCPOptionKindTimestamps:
 			if obj.OptionLength == 10 {
 				if len(obj.OptionData) == 8 {
@@ -1228,6 +1281,10 @@ func (o *TcpSocket) reass(tcph *layers.TCP,
 	flags *uint8,
 	ti_len uint16,
 	sts *TcpStats) {
+	if o.sackEnabled() && o.state == TCPS_ESTABLISHED {
+		o.sackReass(tcph.Seq, m.GetData()[:ti_len], flags, sts)
+		return
+	}
 	// in order
 	if tcph.Seq == o.rcv_nxt &&
 		o.reass_is_exists() == false &&
@@ -202,6 +202,32 @@ func (o *TcpSocket) mss(offer uint32) uint16 {
 	}
 }
 
+// tstmpOption writes the timestamp option, returns the length of the option
+func (o *TcpSocket) tstmpOption(opt []byte) uint16 {
+	binary.BigEndian.PutUint32(opt[0:4], TCPOPT_TSTAMP_HDR)
+	binary.BigEndian.PutUint32(opt[4:8], o.ctx.tcp_now)
+	binary.BigEndian.PutUint32(opt[8:12], o.ts_recent)
+	return TCPOLEN_TSTAMP_APPA
+}
+
+// rcvWindow calculates the receive window to advertise
+func (o *TcpSocket) rcvWindow(win uint32) uint32 {
+	/*
+	 * Calculate receive window.  Don't shrink window,
+	 * but avoid silly window syndrome.
+	 */
+	if (win < (o.socket.so_rcv.sb_hiwat / 4)) && (win < uint32(o.maxseg)) {
+		win = 0
+	}
+	if win < (uint32)(o.rcv_adv-o.rcv_nxt) {
+		win = (uint32)(o.rcv_adv - o.rcv_nxt)
+	}
+	if win > (uint32)(TCP_MAXWIN)<<o.rcv_scale {
+		win = (uint32)(TCP_MAXWIN) << o.rcv_scale
+	}
+	return win
+}
+
 func (o *TcpSocket) output() int {
 	var len int32
 	var win uint32
@@ -227,12 +253,16 @@ func (o *TcpSocket) output() int {
 		 * expected to clock out any data we send --
 		 * slow start to get ack "clock" running again.
 		 */
-		o.snd_cwnd = uint32(o.maxseg)
+		o.cc.afterIdle(o)
 	}
 
 again:
 
 	sendalot = false
+	if o.sackEnabled() && seq_lt(o.snd_nxt, o.snd_max) {
+		/* don't retransmit data that was SACKed */
+		o.sackSkip()
+	}
 	off = int32(o.snd_nxt - o.snd_una)
 	win = bsd_umin(o.snd_wnd, o.snd_cwnd)
 
@@ -303,6 +333,14 @@ again:
 		sendalot = true
 	}
 
+	if o.sackEnabled() && seq_lt(o.snd_nxt, o.snd_max) {
+		/* retransmit up to the next SACKed block */
+		if nb := o.sackNextBlock(); len > nb {
+			len = nb
+			sendalot = true
+		}
+	}
+
 	if seq_lt(o.snd_nxt+uint32(len), o.snd_una+uint32(so.so_snd.getSize())) {
 		flags &= (^TH_FIN)
 	}
@@ -450,6 +488,13 @@ send:
 				binary.BigEndian.PutUint32(opt[optlen:optlen+4], a)
 				optlen += 4
 			}
+
+			if ((o.flags & TF_REQ_SACK) > 0) &&
+				(((flags & TH_ACK) == 0) ||
+					((o.flags & TF_SACK_PERMIT) > 0)) {
+				binary.BigEndian.PutUint32(opt[optlen:optlen+4], TCPOPT_SACK_PERMIT_HDR)
+				optlen += 4
+			}
 		}
 	}
 
@@ -463,11 +508,14 @@ send:
 		(((flags & (TH_SYN | TH_ACK)) == TH_SYN) ||
 			((o.flags & TF_RCVD_TSTMP) > 0)) {
 
-		l := optlen
-		binary.BigEndian.PutUint32(opt[l+0:l+4], TCPOPT_TSTAMP_HDR)
-		binary.BigEndian.PutUint32(opt[l+4:l+8], o.ctx.tcp_now)
-		binary.BigEndian.PutUint32(opt[l+8:l+12], o.ts_recent)
-		optlen += TCPOLEN_TSTAMP_APPA
+		optlen += o.tstmpOption(opt[optlen:])
+	}
+
+	/*
+	 * Send the SACK blocks of the out-of-order data we hold.
+	 */
+	if o.sackEnabled() && ((flags & (TH_SYN | TH_RST)) == 0) && o.reass_is_exists() {
+		optlen += o.sackOption(opt[optlen:])
 	}
 
 	hdrlen += optlen
@@ -569,21 +617,12 @@ send:
 		copy(pkt.options[:], opt[0:optlen])
 		tcph.SetHeaderLength(uint8(TCP_HEADER_LEN) + uint8(optlen))
 	}
+	if o.ecn_flags != 0 {
+		flags = int32(o.ecnOutput(uint8(flags&0xff), len, o.force || seq_lt(o.snd_nxt, o.snd_max), pkt.m.GetData()))
+	}
 	tcph.SetFlags(uint8(flags & 0xff))
 
-	/*
-	 * Calculate receive window.  Don't shrink window,
-	 * but avoid silly window syndrome.
-	 */
-	if (win < (so.so_rcv.sb_hiwat / 4)) && (win < uint32(o.maxseg)) {
-		win = 0
-	}
-	if win < (uint32)(o.rcv_adv-o.rcv_nxt) {
-		win = (uint32)(o.rcv_adv - o.rcv_nxt)
-	}
-	if win > (uint32)(TCP_MAXWIN)<<o.rcv_scale {
-		win = (uint32)(TCP_MAXWIN) << o.rcv_scale
-	}
+	win = o.rcvWindow(win)
 
 	tcph.SetWindowSize(uint16((win >> o.rcv_scale)))
 
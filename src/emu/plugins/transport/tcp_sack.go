@@ -0,0 +1,538 @@
+// Copyright (c) 2021 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License")
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package transport
+
+/*
+TCP selective acknowledgment, RFC 2018 and RFC 6675
+
+SACK is requested by the sack ioctl (or the sack field of the transport configuration) and is used only in case both
+sides sent SACK-permitted in the SYN.
+
+Receiver side: out-of-order segments are kept in a reassembly queue, the queue is the source of the SACK blocks of
+each ACK. Once the missing data arrives the queued data is handed to the application with it.
+
+Sender side: the SACK blocks of the peer are kept in a scoreboard. Loss recovery follows RFC 6675, the pipe is
+estimated from the scoreboard and only lost holes are retransmitted. After a retransmit timeout the SACKed data is
+not retransmitted again.
+*/
+
+import (
+	"encoding/binary"
+	"external/google/gopacket/layers"
+)
+
+const (
+	TCPOPT_SACK_PERMITTED  = 4
+	TCPOLEN_SACK_PERMITTED = 2
+	TCPOPT_SACK            = 5
+	TCPOLEN_SACK           = 8 /* length of a SACK block */
+	TCP_MAX_SACK           = 4 /* maximum number of SACK blocks in an option */
+	TCP_MAX_SACK_HOLES     = 32
+
+	TCPOPT_SACK_PERMIT_HDR = (TCPOPT_NOP<<24 | TCPOPT_NOP<<16 | TCPOPT_SACK_PERMITTED<<8 | TCPOLEN_SACK_PERMITTED)
+	TCPOPT_SACK_HDR        = (TCPOPT_NOP<<24 | TCPOPT_NOP<<16 | TCPOPT_SACK<<8)
+)
+
+// sackBlock is a range of sequence numbers [start, end)
+type sackBlock struct {
+	start uint32
+	end   uint32
+}
+
+// reassSeg is an out-of-order segment in the reassembly queue
+type reassSeg struct {
+	seq  uint32
+	data []byte
+	fin  bool
+}
+
+func (o *reassSeg) end() uint32 {
+	return o.seq + uint32(len(o.data))
+}
+
+func seq_max(a uint32, b uint32) uint32 {
+	if seq_gt(a, b) {
+		return a
+	}
+	return b
+}
+
+func seq_min(a uint32, b uint32) uint32 {
+	if seq_lt(a, b) {
+		return a
+	}
+	return b
+}
+
+// sackEnabled returns true in case SACK was negotiated
+func (o *TcpSocket) sackEnabled() bool {
+	return (o.flags & (TF_REQ_SACK | TF_SACK_PERMIT)) == (TF_REQ_SACK | TF_SACK_PERMIT)
+}
+
+/*
+ * Receiver side
+ */
+
+// sackReass inserts a segment into the reassembly queue and hands the in order data to the application.
+func (o *TcpSocket) sackReass(seq uint32, data []byte, flags *uint8, sts *TcpStats) {
+	fin := (*flags & TH_FIN) > 0
+	push := (*flags & TH_PUSH) > 0
+	*flags = 0
+
+	if seq != o.rcv_nxt {
+		o.reassInsert(seq, data, fin, sts)
+		o.flags |= TF_ACKNOW
+		return
+	}
+
+	if len(data) > 0 {
+		o.rcv_nxt += uint32(len(data))
+		sts.tcps_rcvpack++
+		sts.tcps_rcvbyte += uint64(len(data))
+	}
+
+	if len(o.reassq) == 0 {
+		if fin {
+			*flags = TH_FIN
+		}
+		if push {
+			o.flags |= TF_ACKNOW
+		} else {
+			o.flags |= TF_DELACK
+		}
+		if o.countCheckNoDelay(uint16(len(data))) {
+			o.flags |= TF_ACKNOW
+		}
+		if len(data) > 0 {
+			o.cbmask |= SocketRxData
+		}
+		return
+	}
+
+	// pull the queued data that is now in order
+	rxdata := append([]byte{}, data...)
+	for len(o.reassq) > 0 && seq_leq(o.reassq[0].seq, o.rcv_nxt) {
+		s := &o.reassq[0]
+		if seq_gt(s.end(), o.rcv_nxt) {
+			d := s.data[o.rcv_nxt-s.seq:]
+			rxdata = append(rxdata, d...)
+			o.rcv_nxt += uint32(len(d))
+		}
+		fin = s.fin
+		o.reassq = o.reassq[1:]
+	}
+	if len(o.reassq) == 0 {
+		o.reassq = nil
+		sts.tcps_reasfree++
+	}
+	if fin {
+		*flags = TH_FIN
+	}
+	o.flags |= TF_ACKNOW
+	if len(rxdata) > 0 {
+		o.rxdata = rxdata
+		o.cbmask |= SocketRxData
+	}
+}
+
+// reassQueueSize returns the number of bytes in the reassembly queue
+func (o *TcpSocket) reassQueueSize() uint32 {
+	var size uint32
+	for i := range o.reassq {
+		size += uint32(len(o.reassq[i].data))
+	}
+	return size
+}
+
+// reassInsert copies an out-of-order segment into the reassembly queue, the queue is kept sorted and merged.
+func (o *TcpSocket) reassInsert(seq uint32, data []byte, fin bool, sts *TcpStats) {
+	if len(data) == 0 {
+		return
+	}
+	end := seq + uint32(len(data))
+	if o.reassQueueSize()+uint32(len(data)) > o.socket.so_rcv.sb_hiwat ||
+		len(o.reassq) >= TCP_MAX_SACK_HOLES {
+		sts.tcps_rcvoopackdrop++
+		sts.tcps_rcvoobytesdrop += uint64(len(data))
+		return
+	}
+	sts.tcps_rcvoopack++
+	sts.tcps_rcvoobyte += uint64(len(data))
+	if len(o.reassq) == 0 {
+		sts.tcps_reasalloc++
+	}
+	o.rcv_lastsack = sackBlock{seq, end}
+
+	// find the segments that overlap or touch the new one and merge all of them
+	var i, j int
+	for i = 0; i < len(o.reassq) && seq_lt(o.reassq[i].end(), seq); i++ {
+	}
+	for j = i; j < len(o.reassq) && seq_leq(o.reassq[j].seq, end); j++ {
+	}
+	n := reassSeg{seq: seq, data: append([]byte{}, data...), fin: fin}
+	if i < j {
+		first, last := &o.reassq[i], &o.reassq[j-1]
+		if seq_lt(first.seq, n.seq) {
+			n.data = append(append([]byte{}, first.data[:n.seq-first.seq]...), n.data...)
+			n.seq = first.seq
+		}
+		if seq_gt(last.end(), end) {
+			n.data = append(n.data, last.data[end-last.seq:]...)
+			n.fin = last.fin
+		} else {
+			n.fin = n.fin || (last.end() == end && last.fin)
+		}
+	}
+	q := make([]reassSeg, 0, len(o.reassq)-(j-i)+1)
+	q = append(q, o.reassq[:i]...)
+	q = append(q, n)
+	q = append(q, o.reassq[j:]...)
+	o.reassq = q
+}
+
+// sackOption adds the SACK blocks of the reassembly queue to the options, returns the length of the option.
+// The first block holds the most recent out-of-order segment.
+func (o *TcpSocket) sackOption(opt []byte) uint16 {
+	nblocks := (len(opt) - 4) / TCPOLEN_SACK
+	if nblocks > TCP_MAX_SACK {
+		nblocks = TCP_MAX_SACK
+	}
+	if nblocks > len(o.reassq) {
+		nblocks = len(o.reassq)
+	}
+	if nblocks <= 0 {
+		return 0
+	}
+	first := 0
+	for i := range o.reassq {
+		if seq_leq(o.reassq[i].seq, o.rcv_lastsack.start) && seq_lt(o.rcv_lastsack.start, o.reassq[i].end()) {
+			first = i
+			break
+		}
+	}
+	binary.BigEndian.PutUint32(opt[0:4], uint32(TCPOPT_SACK_HDR|(2+TCPOLEN_SACK*nblocks)))
+	l := 4
+	for k := 0; k < nblocks; k++ {
+		i := first
+		if k > 0 {
+			i = k - 1
+			if i >= first {
+				i++
+			}
+		}
+		binary.BigEndian.PutUint32(opt[l:l+4], o.reassq[i].seq)
+		binary.BigEndian.PutUint32(opt[l+4:l+8], o.reassq[i].end())
+		l += TCPOLEN_SACK
+	}
+	o.ctx.tcpStats.tcps_sack_sndblocks += uint64(nblocks)
+	return uint16(l)
+}
+
+/*
+ * Sender side
+ */
+
+// sackParseOption saves the SACK blocks of a received segment
+func (o *TcpSocket) sackParseOption(obj *layers.TCPOption) {
+	d := obj.OptionData
+	for len(d) >= TCPOLEN_SACK {
+		o.sackRcv = append(o.sackRcv, sackBlock{binary.BigEndian.Uint32(d[0:4]), binary.BigEndian.Uint32(d[4:8])})
+		d = d[TCPOLEN_SACK:]
+	}
+}
+
+// sackUpdate adds the received SACK blocks to the scoreboard
+func (o *TcpSocket) sackUpdate(ack uint32) {
+	sts := &o.ctx.tcpStats
+	for _, b := range o.sackRcv {
+		sts.tcps_sack_rcvblocks++
+		// ignore D-SACK and invalid blocks
+		if !seq_lt(b.start, b.end) || seq_leq(b.start, ack) || seq_leq(b.start, o.snd_una) ||
+			seq_gt(b.end, o.snd_max) {
+			continue
+		}
+		o.sackAddBlock(b)
+	}
+}
+
+// sackAddBlock merges a block into the scoreboard
+func (o *TcpSocket) sackAddBlock(b sackBlock) {
+	var i, j int
+	for i = 0; i < len(o.sackBlocks) && seq_lt(o.sackBlocks[i].end, b.start); i++ {
+	}
+	for j = i; j < len(o.sackBlocks) && seq_leq(o.sackBlocks[j].start, b.end); j++ {
+	}
+	if i < j {
+		b.start = seq_min(b.start, o.sackBlocks[i].start)
+		b.end = seq_max(b.end, o.sackBlocks[j-1].end)
+	} else if len(o.sackBlocks) >= TCP_MAX_SACK_HOLES {
+		return
+	}
+	q := make([]sackBlock, 0, len(o.sackBlocks)-(j-i)+1)
+	q = append(q, o.sackBlocks[:i]...)
+	q = append(q, b)
+	q = append(q, o.sackBlocks[j:]...)
+	o.sackBlocks = q
+}
+
+// sackClean removes the blocks that were acked
+func (o *TcpSocket) sackClean() {
+	i := 0
+	for i < len(o.sackBlocks) && seq_leq(o.sackBlocks[i].end, o.snd_una) {
+		i++
+	}
+	o.sackBlocks = o.sackBlocks[i:]
+	if len(o.sackBlocks) > 0 && seq_lt(o.sackBlocks[0].start, o.snd_una) {
+		o.sackBlocks[0].start = o.snd_una
+	}
+	if len(o.sackBlocks) == 0 {
+		o.sackBlocks = nil
+	}
+	if seq_lt(o.sack_rxmit_nxt, o.snd_una) {
+		o.sack_rxmit_nxt = o.snd_una
+	}
+}
+
+// sackIsLost returns true in case the segment at seq is lost, RFC 6675 IsLost
+func (o *TcpSocket) sackIsLost(seq uint32) bool {
+	var blocks int
+	var bytes uint32
+	for i := len(o.sackBlocks) - 1; i >= 0; i-- {
+		b := &o.sackBlocks[i]
+		if seq_leq(b.end, seq) {
+			break
+		}
+		blocks++
+		bytes += b.end - seq_max(b.start, seq)
+	}
+	thresh := uint32(o.ctx.tcprexmtthresh)
+	return uint32(blocks) >= thresh || bytes > (thresh-1)*uint32(o.maxseg)
+}
+
+// sackPipe estimates the bytes in flight, RFC 6675 SetPipe
+func (o *TcpSocket) sackPipe() uint32 {
+	var pipe uint32
+	start := o.snd_una
+	for i := 0; i <= len(o.sackBlocks); i++ {
+		end := o.snd_max
+		if i < len(o.sackBlocks) {
+			end = o.sackBlocks[i].start
+		}
+		if seq_lt(start, end) {
+			// a hole
+			if !o.sackIsLost(start) {
+				pipe += end - start
+			}
+			if seq_gt(o.sack_rxmit_nxt, start) {
+				pipe += seq_min(o.sack_rxmit_nxt, end) - start
+			}
+		}
+		if i < len(o.sackBlocks) {
+			start = o.sackBlocks[i].end
+		}
+	}
+	return pipe
+}
+
+// sackNextHole returns the next lost hole to retransmit, RFC 6675 NextSeg rule 1
+func (o *TcpSocket) sackNextHole() (uint32, uint32, bool) {
+	start := o.snd_una
+	for i := range o.sackBlocks {
+		end := o.sackBlocks[i].start
+		s := seq_max(start, o.sack_rxmit_nxt)
+		if seq_lt(s, end) && o.sackIsLost(s) {
+			return s, bsd_umin(end-s, uint32(o.maxseg)), true
+		}
+		start = o.sackBlocks[i].end
+	}
+	return 0, 0, false
+}
+
+// sackEnterRecovery starts loss recovery, RFC 6675 section 5 step 4
+func (o *TcpSocket) sackEnterRecovery() {
+	sts := &o.ctx.tcpStats
+	sts.tcps_sack_recovery_episode++
+	o.sack_recovery = true
+	o.snd_recover = o.snd_max
+	o.sack_rxmit_nxt = o.snd_una
+	o.ccCongSignal(CC_NDUPACK)
+	o.snd_cwnd = o.snd_ssthresh
+	o.rtt = 0
+	o.timer[TCPT_REXMT] = 0
+
+	// retransmit the first segment in any case
+	l := bsd_umin(o.snd_recover-o.snd_una, uint32(o.maxseg))
+	if len(o.sackBlocks) > 0 {
+		l = bsd_umin(l, o.sackBlocks[0].start-o.snd_una)
+	}
+	o.sackSend(o.snd_una, l)
+	o.sackOutput()
+}
+
+// sackExitRecovery ends loss recovery once the recovery point is acked
+func (o *TcpSocket) sackExitRecovery() {
+	o.sack_recovery = false
+	o.ccPostRecovery()
+}
+
+// sackDupAck handles a duplicate ACK, returns true in case it was handled by loss recovery
+func (o *TcpSocket) sackDupAck() bool {
+	if o.sack_recovery {
+		o.sackOutput()
+		return true
+	}
+	if seq_lt(o.snd_una, o.snd_recover) {
+		// the loss was already recovered, don't reduce the window again
+		return false
+	}
+	if o.dupacks >= o.ctx.tcprexmtthresh || o.sackIsLost(o.snd_una) {
+		o.sackEnterRecovery()
+		return true
+	}
+	return false
+}
+
+// sackPartialAck continues loss recovery after an ACK that advanced snd_una, returns true in case the recovery ended
+func (o *TcpSocket) sackPartialAck() bool {
+	if seq_geq(o.snd_una, o.snd_recover) {
+		o.sackExitRecovery()
+		return true
+	}
+	o.sackOutput()
+	return false
+}
+
+// sackOutput sends lost holes and new data as long as the pipe allows, RFC 6675 section 5 step (C)
+func (o *TcpSocket) sackOutput() {
+	so := o.socket
+	for i := 0; i < TCP_MAX_SACK_HOLES; i++ {
+		if o.sackPipe()+uint32(o.maxseg) > o.snd_cwnd {
+			return
+		}
+		if seq, l, ok := o.sackNextHole(); ok {
+			if !o.sackSend(seq, l) {
+				return
+			}
+			continue
+		}
+		// new data, in the send window
+		off := o.snd_max - o.snd_una
+		if o.snd_nxt != o.snd_max || off >= so.so_snd.getSize() || off >= o.snd_wnd {
+			return
+		}
+		l := bsd_umin(bsd_umin(so.so_snd.getSize()-off, o.snd_wnd-off), uint32(o.maxseg))
+		if !o.sackSend(o.snd_max, l) {
+			return
+		}
+	}
+}
+
+// sackSkip moves snd_nxt beyond the SACKed data, so it is not retransmitted after a timeout
+func (o *TcpSocket) sackSkip() {
+	for i := range o.sackBlocks {
+		b := &o.sackBlocks[i]
+		if seq_geq(o.snd_nxt, b.start) && seq_lt(o.snd_nxt, b.end) {
+			o.ctx.tcpStats.tcps_sack_rexmit_avoided += uint64(b.end - o.snd_nxt)
+			o.snd_nxt = b.end
+		}
+	}
+}
+
+// sackNextBlock returns the number of bytes from snd_nxt to the next SACKed block
+func (o *TcpSocket) sackNextBlock() int32 {
+	for i := range o.sackBlocks {
+		if seq_gt(o.sackBlocks[i].start, o.snd_nxt) {
+			return int32(o.sackBlocks[i].start - o.snd_nxt)
+		}
+	}
+	return int32(o.snd_max - o.snd_nxt)
+}
+
+// sackOnRexmtTimeout ends loss recovery on a retransmit timeout, the scoreboard is kept
+func (o *TcpSocket) sackOnRexmtTimeout() {
+	o.sack_recovery = false
+	o.snd_recover = o.snd_max
+	o.sack_rxmit_nxt = o.snd_una
+}
+
+// sackSend sends the data [seq, seq+l), a retransmission of a hole or new data during loss recovery
+func (o *TcpSocket) sackSend(seq uint32, l uint32) bool {
+	var opt [MAX_TCPOPTLEN]byte
+	var optlen uint16
+	var pkt tcpPkt
+	so := o.socket
+	sts := &o.ctx.tcpStats
+
+	if l == 0 {
+		return false
+	}
+	if (o.flags & (TF_REQ_TSTMP | TF_NOOPT | TF_RCVD_TSTMP)) == (TF_REQ_TSTMP | TF_RCVD_TSTMP) {
+		optlen += o.tstmpOption(opt[optlen:])
+	}
+	if len(o.reassq) > 0 {
+		optlen += o.sackOption(opt[optlen:])
+	}
+	if l > uint32(o.maxseg-optlen) {
+		l = uint32(o.maxseg - optlen)
+	}
+	off := seq - o.snd_una
+	if o.buildDpkt(int32(off), int32(l), TCP_HEADER_LEN+optlen, &pkt) != 0 {
+		o.quench()
+		return false
+	}
+
+	newdata := (seq == o.snd_max)
+	if newdata {
+		sts.tcps_sndpack++
+		sts.tcps_sndbyte_ok += uint64(l)
+	} else {
+		sts.tcps_sndrexmitpack++
+		sts.tcps_sndrexmitbyte += uint64(l)
+		sts.tcps_sack_rexmitpack++
+		sts.tcps_sack_rexmitbyte += uint64(l)
+	}
+
+	flags := uint8(TH_ACK)
+	if (off+l == so.so_snd.getSize()) || ((o.flags & TF_NODELAY_PUSH) > 0) {
+		flags |= TH_PUSH
+	}
+	tcph := pkt.tcph
+	tcph.SetSeqNumber(seq)
+	tcph.SetAckNumber(o.rcv_nxt)
+	if optlen > 0 {
+		copy(pkt.options[:], opt[0:optlen])
+		tcph.SetHeaderLength(uint8(TCP_HEADER_LEN) + uint8(optlen))
+	}
+	if o.ecn_flags != 0 {
+		flags = o.ecnOutput(flags, int32(l), !newdata, pkt.m.GetData())
+	}
+	tcph.SetFlags(flags)
+	win := o.rcvWindow(so.so_rcv.sbspace())
+	tcph.SetWindowSize(uint16((win >> o.rcv_scale)))
+
+	if newdata {
+		o.snd_max += l
+		o.snd_nxt = o.snd_max
+		if o.rtt == 0 {
+			o.rtt = 1
+			o.rtseq = seq
+			sts.tcps_segstimed++
+		}
+	} else if seq_gt(seq+l, o.sack_rxmit_nxt) {
+		o.sack_rxmit_nxt = seq + l
+	}
+	if o.timer[TCPT_REXMT] == 0 {
+		o.timer[TCPT_REXMT] = o.rxtcur
+	}
+	o.send(&pkt)
+	sts.tcps_sndtotal++
+	if win > 0 && seq_gt(o.rcv_nxt+win, o.rcv_adv) {
+		o.rcv_adv = o.rcv_nxt + win
+	}
+	o.last_ack_sent = o.rcv_nxt
+	o.flags &= ^(TF_ACKNOW | TF_DELACK)
+	return true
+}
@@ -7,6 +7,7 @@ package transport
 
 import (
 	"emu/core"
+	"fmt"
 	"net"
 	"time"
 )
@@ -14,6 +15,8 @@ import (
 const (
 	IP_IOCTL_TOS             = "tos"              // change the ipv4/ipv6 tos
 	IP_IOCTL_TTL             = "ttl"              // change the ipv4/ipv6 ttl
+	IP_IOCTL_ECN             = "ecn"              // 1 - use ECN, in case of TCP it is requested before the connection is established
+	IP_IOCTL_ECN_CE          = "ecn_ce"           // get only, number of packets received with a CE mark
 	TCP_IOCTL_MSS            = "mss"              // sender tcp mss
 	TCP_IOCTL_INITWND        = "initwnd"          // init window, send_window= init_wnd * mss
 	TCP_IOCTL_NODELAY        = "no_delay"         // 0x1- no_delay  ,0x2 - force push by client for each packet, 0 - delay of delay counter
@@ -21,6 +24,11 @@ const (
 	TCP_IOCTL_DELAY_ACK_MSEC = "delay_ack_msec"   // msec of fast tcp time
 	TCP_IOCTL_TX_BUF_SIZE    = "txbufsize"        // tx queue in bytes, can be change only in case the queue if empty
 	TCP_IOCTL_RX_BUF_SIZE    = "rxbufsize"        // rx queue in bytes
+	TCP_IOCTL_SACK           = "sack"             // 1 - request SACK, 0 - don't, before the connection is established
+	TCP_IOCTL_CC             = "cc"               // congestion control algorithm e.g. "cubic"
+	TCP_IOCTL_CWND           = "cwnd"             // get only, congestion window in bytes
+	TCP_IOCTL_SSTHRESH       = "ssthresh"         // get only, slow start threshold in bytes
+	TCP_IOCTL_SRTT           = "srtt"             // get only, smoothed rtt in msec
 )
 
 func (o *TcpSocket) SetIoctl(m IoctlMap) error {
@@ -31,6 +39,8 @@ func (o *TcpSocket) SetIoctl(m IoctlMap) error {
 	}
 	o.setIoctlBase(m)
 
+	var err error
+
 	// TOS
 	// mss
 	val, prs := m[TCP_IOCTL_MSS]
@@ -149,7 +159,39 @@ func (o *TcpSocket) SetIoctl(m IoctlMap) error {
 		}
 	}
 
-	return nil
+	val, prs = m[TCP_IOCTL_CC]
+	if prs {
+		name, ok := val.(string)
+		if !ok || !o.ccSet(name) {
+			err = fmt.Errorf("unknown congestion control %v", val)
+		}
+	}
+
+	val, prs = m[IP_IOCTL_ECN]
+	if prs {
+		ecn, ok := getAsInt(val)
+		if ok && (o.state == TCPS_CLOSED || o.state == TCPS_LISTEN) {
+			if ecn > 0 {
+				o.ecn_flags |= ECN_REQ
+			} else {
+				o.ecn_flags &= ^uint8(ECN_REQ)
+			}
+		}
+	}
+
+	val, prs = m[TCP_IOCTL_SACK]
+	if prs {
+		sack, ok := getAsInt(val)
+		if ok && (o.state == TCPS_CLOSED || o.state == TCPS_LISTEN) {
+			if sack > 0 {
+				o.flags |= TF_REQ_SACK
+			} else {
+				o.flags &= ^TF_REQ_SACK
+			}
+		}
+	}
+
+	return err
 }
 
 func (o *TcpSocket) GetIoctl(m IoctlMap) error {
@@ -173,6 +215,20 @@ func (o *TcpSocket) GetIoctl(m IoctlMap) error {
 	m[TCP_IOCTL_NODELAY_CNT] = int(o.fastMsec)
 	m[TCP_IOCTL_TX_BUF_SIZE] = int(o.socket.so_snd.sb_hiwat)
 	m[TCP_IOCTL_RX_BUF_SIZE] = int(o.socket.so_rcv.sb_hiwat)
+	m[TCP_IOCTL_CC] = o.cc.getName()
+	m[TCP_IOCTL_CWND] = int(o.snd_cwnd)
+	m[TCP_IOCTL_SSTHRESH] = int(o.snd_ssthresh)
+	m[TCP_IOCTL_SRTT] = int(o.srtt>>TCP_RTT_SHIFT) * 1000 / PR_SLOWHZ
+	if o.ecnEnabled() {
+		m[IP_IOCTL_ECN] = 1
+	} else {
+		m[IP_IOCTL_ECN] = 0
+	}
+	if o.sackEnabled() {
+		m[TCP_IOCTL_SACK] = 1
+	} else {
+		m[TCP_IOCTL_SACK] = 0
+	}
 	return nil
 }
 
@@ -508,6 +564,16 @@ func (o *TcpSocket) init(client *core.CClient, ctx *TransportCtx) {
 		o.flags |= (TF_REQ_SCALE | TF_REQ_TSTMP)
 	}
 
+	if ctx.tcp_do_sack {
+		o.flags |= TF_REQ_SACK
+	}
+
+	o.ccSet(ctx.tcp_cc)
+
+	if ctx.tcp_do_ecn {
+		o.ecn_flags |= ECN_REQ
+	}
+
 	if (ctx.tcp_no_delay & NO_DELAY_MASK_NAGLE) > 0 {
 		o.flags |= TF_NODELAY
 	}
@@ -546,6 +612,8 @@ func (o *TcpSocket) startTimers() {
 func (o *TcpSocket) onRemove() {
 	/* stop the timers */
 	o.socket.so_snd.onRemove()
+	o.reassq = nil
+	o.sackBlocks = nil
 	if o.slowtimer.IsRunning() {
 		o.timerw.Stop(&o.slowtimer)
 	}
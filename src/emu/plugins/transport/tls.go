@@ -0,0 +1,450 @@
+// Copyright (c) 2021 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License")
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package transport
+
+/*
+TLS over the transport TCP socket
+
+	ctx.Dial("tls", "48.0.0.1:443", cb, ioctl, nil, 0)
+	ctx.Listen("tls", ":443", cb)
+
+TlsSocket implements SocketApi on top of a TCP socket, the application gets the plain data and SocketEventConnected
+only after the handshake was completed.
+
+crypto/tls works on a blocking net.Conn, so each connection runs in its own goroutine on top of tlspipe.TlsPipe. The
+goroutine runs in lockstep with the main loop: the main loop pushes the received records to the pipe and waits until the
+goroutine blocks again on the next read (or exits), so only one of them runs at a time. The records written by
+crypto/tls are sent on the TCP socket and the events are delivered by the main loop.
+
+The certificates are taken from the tls section of the transport init JSON. The server uses a self-signed certificate
+in case there is none. The peer certificate is verified only in case a CA is given.
+*/
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"emu/core"
+	"emu/plugins/tlspipe"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	TLS_IOCTL_VERSION = "tls_version" // read only, the negotiated version e.g. "TLS 1.3"
+	TLS_IOCTL_CIPHER  = "tls_cipher"  // read only, the negotiated cipher suite
+)
+
+type TlsCfg struct {
+	Cert       *string `json:"cert"`        // PEM certificate chain
+	Key        *string `json:"key"`         // PEM private key of the certificate
+	Ca         *string `json:"ca"`          // PEM CA certificates to verify the peer with
+	ServerName *string `json:"server_name"` // client SNI and the name to verify, the dial address in case it is not set
+}
+
+// newTlsConfig builds the crypto/tls configuration from the init JSON
+func newTlsConfig(cfg *TlsCfg) (*tls.Config, error) {
+	c := &tls.Config{InsecureSkipVerify: true}
+
+	if cfg.Cert != nil || cfg.Key != nil {
+		if cfg.Cert == nil || cfg.Key == nil {
+			return nil, fmt.Errorf(" tls cert and key should be set together")
+		}
+		cert, err := tls.X509KeyPair([]byte(*cfg.Cert), []byte(*cfg.Key))
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+
+	if cfg.Ca != nil {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(*cfg.Ca)) {
+			return nil, fmt.Errorf(" tls ca is not a valid PEM certificate")
+		}
+		c.RootCAs = pool
+		c.ClientCAs = pool
+		c.ClientAuth = tls.VerifyClientCertIfGiven
+		c.InsecureSkipVerify = false
+	}
+
+	if cfg.ServerName != nil {
+		c.ServerName = *cfg.ServerName
+	}
+	return c, nil
+}
+
+var tlsSelfSigned struct {
+	once sync.Once
+	cert tls.Certificate
+	err  error
+}
+
+// tlsSelfSignedCert returns the certificate of a server without a configured one, it is generated only once
+func tlsSelfSignedCert() (tls.Certificate, error) {
+	o := &tlsSelfSigned
+	o.once.Do(func() {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			o.err = err
+			return
+		}
+		now := time.Now()
+		tmpl := x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: "trex-emu"},
+			DNSNames:              []string{"trex-emu"},
+			NotBefore:             now.Add(-time.Hour),
+			NotAfter:              now.AddDate(10, 0, 0),
+			KeyUsage:              x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			BasicConstraintsValid: true,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+		if err != nil {
+			o.err = err
+			return
+		}
+		o.cert = tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	})
+	return o.cert, o.err
+}
+
+// getTlsConfig returns a copy of the configuration of the client for a new socket
+func (o *TransportCtx) getTlsConfig(server bool, dst net.IP) (*tls.Config, error) {
+	if o.tlsCfgErr != nil {
+		return nil, o.tlsCfgErr
+	}
+	var c *tls.Config
+	if o.tlsCfg != nil {
+		c = o.tlsCfg.Clone()
+	} else {
+		c = &tls.Config{InsecureSkipVerify: true}
+	}
+	if server {
+		if len(c.Certificates) == 0 {
+			cert, err := tlsSelfSignedCert()
+			if err != nil {
+				return nil, err
+			}
+			c.Certificates = []tls.Certificate{cert}
+		}
+	} else if c.ServerName == "" && dst != nil {
+		c.ServerName = dst.String()
+	}
+	return c, nil
+}
+
+type TlsSocket struct {
+	ctx      *TransportCtx
+	socket   SocketApi // the TCP socket
+	cb       ISocketCb // the application
+	pipe     *tlspipe.TlsPipe
+	isClient bool
+
+	running     bool     // the goroutine is running, its exit was not handled yet
+	established bool     // SocketEventConnected was sent to the application
+	pending     [][]byte // data written by the application before the handshake was completed
+	txq         [][]byte // records that were not written to the TCP socket yet
+	drain       bool     // the TCP socket queue is full, wait for SocketTxMore
+	userDrain   bool     // the application should wait for SocketTxMore
+	closing     bool     // Close or Shutdown was called
+	closeSent   bool     // Close of the TCP socket was called
+	closed      bool     // the TCP socket was closed
+	lastErr     SocketErr
+}
+
+func newTlsSocket(ctx *TransportCtx, cfg *tls.Config, isClient bool) *TlsSocket {
+	o := new(TlsSocket)
+	o.ctx = ctx
+	o.isClient = isClient
+	o.pipe = tlspipe.NewTlsPipe(cfg, !isClient)
+	return o
+}
+
+// start starts the goroutine and waits until it blocks
+func (o *TlsSocket) start() {
+	o.running = true
+	o.ctx.tlsSockets[o] = true
+	o.pipe.Start()
+	o.process()
+}
+
+// stop lets the goroutine exit, the TCP socket can't receive more
+func (o *TlsSocket) stop() {
+	if !o.running {
+		return
+	}
+	o.pipe.Close()
+	o.process()
+}
+
+// process handles the output of the goroutine in the main loop
+func (o *TlsSocket) process() {
+	sts := &o.ctx.tlsStats
+	o.flush()
+
+	if o.pipe.HandshakeDone() && !o.established {
+		o.established = true
+		sts.tls_handshake++
+		for _, b := range o.pending {
+			o.pipe.Write(b)
+			sts.tls_sndbyte += uint64(len(b))
+		}
+		o.pending = nil
+		if o.closing {
+			o.pipe.CloseWrite()
+		}
+		o.flush()
+		if o.cb != nil && !o.closed {
+			o.cb.OnRxEvent(SocketEventConnected)
+		}
+	}
+
+	if d := o.pipe.Read(); len(d) > 0 {
+		sts.tls_rcvbyte += uint64(len(d))
+		if o.cb != nil && !o.closed {
+			o.cb.OnRxData(d)
+		}
+	}
+
+	if o.running && !o.pipe.Running() {
+		o.running = false
+		delete(o.ctx.tlsSockets, o)
+		if err := o.pipe.Err(); err != nil && !errors.Is(err, io.EOF) {
+			if o.pipe.HandshakeDone() {
+				sts.tls_err++
+			} else {
+				sts.tls_handshake_err++
+			}
+			o.lastErr = SeTLS_ERROR
+			o.closing = true
+		} else if !o.pipe.HandshakeDone() {
+			sts.tls_handshake_err++
+		}
+		o.flush()
+	}
+}
+
+// flush writes the records to the TCP socket, closes it in case all the records were written after Close
+func (o *TlsSocket) flush() {
+	if b := o.pipe.Pull(); len(b) > 0 {
+		o.txq = append(o.txq, b)
+	}
+	if o.closed {
+		o.txq = nil
+		return
+	}
+	for len(o.txq) > 0 && !o.drain {
+		err, queued := o.socket.Write(o.txq[0])
+		if err != SeOK {
+			o.txq = nil
+			break
+		}
+		o.txq = o.txq[1:]
+		if !queued {
+			o.drain = true
+		}
+	}
+	if o.closing && !o.closeSent && len(o.txq) == 0 && (o.established || !o.running) {
+		o.closeSent = true
+		o.socket.Close()
+	}
+}
+
+/* SocketApi */
+
+func (o *TlsSocket) Close() SocketErr {
+	if o.closing || o.closed {
+		return SeCONNECTION_IS_CLOSED
+	}
+	o.closing = true
+	if o.established {
+		/* send close_notify */
+		o.pipe.CloseWrite()
+	}
+	o.flush()
+	return SeOK
+}
+
+func (o *TlsSocket) Shutdown() SocketErr {
+	o.closing = true
+	o.closeSent = true
+	if o.closed {
+		return SeOK
+	}
+	return o.socket.Shutdown()
+}
+
+func (o *TlsSocket) LocalAddr() net.Addr {
+	return o.socket.LocalAddr()
+}
+
+func (o *TlsSocket) RemoteAddr() net.Addr {
+	return o.socket.RemoteAddr()
+}
+
+func (o *TlsSocket) GetCap() SocketCapType {
+	return o.socket.GetCap()
+}
+
+func (o *TlsSocket) GetLastError() SocketErr {
+	if o.lastErr != SeOK {
+		return o.lastErr
+	}
+	return o.socket.GetLastError()
+}
+
+func (o *TlsSocket) SetIoctl(m IoctlMap) error {
+	return o.socket.SetIoctl(m)
+}
+
+func (o *TlsSocket) GetIoctl(m IoctlMap) error {
+	if o.established {
+		st := o.pipe.ConnectionState()
+		m[TLS_IOCTL_VERSION] = tls.VersionName(st.Version)
+		m[TLS_IOCTL_CIPHER] = tls.CipherSuiteName(st.CipherSuite)
+	}
+	return o.socket.GetIoctl(m)
+}
+
+// Write encrypts the buffer, it is queued until the handshake is completed
+func (o *TlsSocket) Write(buf []byte) (err SocketErr, queued bool) {
+	if o.closing || o.closed {
+		return SeCONNECTION_IS_CLOSED, false
+	}
+	if o.userDrain {
+		return SeWRITE_WHILE_DRAIN, false
+	}
+	if !o.established {
+		b := make([]byte, len(buf))
+		copy(b, buf)
+		o.pending = append(o.pending, b)
+		return SeOK, true
+	}
+	if werr := o.pipe.Write(buf); werr != nil {
+		return SeCONNECTION_IS_CLOSED, false
+	}
+	o.ctx.tlsStats.tls_sndbyte += uint64(len(buf))
+	o.flush()
+	if o.drain || len(o.txq) > 0 {
+		o.userDrain = true
+		return SeOK, false
+	}
+	return SeOK, true
+}
+
+func (o *TlsSocket) GetL7MTU() uint16 {
+	return o.socket.GetL7MTU()
+}
+
+func (o *TlsSocket) IsIPv6() bool {
+	return o.socket.IsIPv6()
+}
+
+func (o *TlsSocket) GetSocket() interface{} {
+	return o.socket.GetSocket()
+}
+
+/* ISocketCb of the TCP socket */
+
+func (o *TlsSocket) OnRxEvent(event SocketEventType) {
+	if (event&SocketEventConnected) > 0 && o.isClient && !o.running && !o.closing {
+		o.start()
+	}
+	if (event & SocketClosed) > 0 {
+		o.closed = true
+	}
+	if (event & (SocketRemoteDisconnect | SocketClosed)) > 0 {
+		o.stop()
+	}
+	/* SocketEventConnected is sent after the handshake */
+	event &= ^SocketEventType(SocketEventConnected)
+	if event != 0 && o.cb != nil {
+		o.cb.OnRxEvent(event)
+	}
+}
+
+func (o *TlsSocket) OnRxData(d []byte) {
+	if !o.running {
+		return
+	}
+	o.pipe.Push(d)
+	o.process()
+}
+
+func (o *TlsSocket) OnTxEvent(event SocketEventType) {
+	if (event & SocketTxMore) > 0 {
+		o.drain = false
+		o.flush()
+	}
+	if o.drain || len(o.txq) > 0 || !o.established || o.cb == nil {
+		return
+	}
+	if o.userDrain {
+		o.userDrain = false
+		event |= SocketTxMore
+	}
+	o.cb.OnTxEvent(event)
+}
+
+/* server */
+
+type tlsServerCb struct {
+	ctx *TransportCtx
+	cb  IServerSocketCb
+}
+
+func (o *tlsServerCb) OnAccept(socket SocketApi) ISocketCb {
+	cfg, err := o.ctx.getTlsConfig(true, nil)
+	if err != nil {
+		o.ctx.tlsStats.tls_cfg_err++
+		return nil
+	}
+	s := newTlsSocket(o.ctx, cfg, false)
+	s.socket = socket
+	cb := o.cb.OnAccept(s)
+	if cb == nil {
+		return nil
+	}
+	s.cb = cb
+	o.ctx.tlsStats.tls_accept++
+	s.start()
+	return s
+}
+
+func (o *TransportCtx) dialTls(dst net.IP, port uint16, cb ISocketCb, ioctl IoctlMap, dstMac *core.MACKey, srcPort uint16) (SocketApi, error) {
+	cfg, err := o.getTlsConfig(false, dst)
+	if err != nil {
+		o.tlsStats.tls_cfg_err++
+		return nil, err
+	}
+	t := newTlsSocket(o, cfg, true)
+	t.cb = cb
+	s := new(TcpSocket)
+	t.socket = s
+	if _, err := o.dialCmn(s, s, dst, port, t, ioctl, dstMac, srcPort); err != nil {
+		return nil, err
+	}
+	o.tlsStats.tls_dial++
+	return t, nil
+}
+
+// onRemoveTls stops the goroutines of the sockets that are still running
+func (o *TransportCtx) onRemoveTls() {
+	for s := range o.tlsSockets {
+		s.closed = true
+		s.stop()
+	}
+}
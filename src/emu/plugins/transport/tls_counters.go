@@ -0,0 +1,89 @@
+// Copyright (c) 2021 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License")
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package transport
+
+import "emu/core"
+
+type TlsStats struct {
+	tls_dial          uint64 /* tls client sockets */
+	tls_accept        uint64 /* tls server sockets */
+	tls_handshake     uint64 /* completed handshakes */
+	tls_handshake_err uint64 /* failed handshakes */
+	tls_err           uint64 /* errors after the handshake e.g. alert or bad record */
+	tls_cfg_err       uint64 /* dial/listen with an invalid tls configuration */
+	tls_sndbyte       uint64 /* application bytes sent */
+	tls_rcvbyte       uint64 /* application bytes received */
+}
+
+func NewTlsStatsDb(o *TlsStats) *core.CCounterDb {
+	db := core.NewCCounterDb("tls")
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tls_dial,
+		Name:     "tls_dial",
+		Help:     "tls client sockets",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tls_accept,
+		Name:     "tls_accept",
+		Help:     "tls server sockets",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tls_handshake,
+		Name:     "tls_handshake",
+		Help:     "completed handshakes",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tls_handshake_err,
+		Name:     "tls_handshake_err",
+		Help:     "failed handshakes",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tls_err,
+		Name:     "tls_err",
+		Help:     "errors after the handshake",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tls_cfg_err,
+		Name:     "tls_cfg_err",
+		Help:     "invalid tls configuration",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tls_sndbyte,
+		Name:     "tls_sndbyte",
+		Help:     "application bytes sent",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tls_rcvbyte,
+		Name:     "tls_rcvbyte",
+		Help:     "application bytes received",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	return db
+}
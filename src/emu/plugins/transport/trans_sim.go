@@ -30,12 +30,21 @@ func newSimCtx(app iSockeApp, c *core.CClient, server bool, ioctl *map[string]in
 	o.Ns = c.Ns
 	o.Tctx = c.Ns.ThreadCtx
 	o.ctx = newCtx(c)
+	if server && params.tlss != nil {
+		o.ctx.setCfg(&TransportCtxCfg{Tls: params.tlss})
+	}
+	if !server && params.tlsc != nil {
+		o.ctx.setCfg(&TransportCtxCfg{Tls: params.tlsc})
+	}
 	app.setCtx(o.Tctx)
 	app.setSim(o)
 	net := "tcp"
 	if params.udp {
 		net = "udp"
 	}
+	if params.tls {
+		net = "tls"
+	}
 
 	if server {
 		o.ctx.Listen(net, ":80", app.getServerAcceptCb())
@@ -192,6 +201,11 @@ func (o *SocketAppTx1) addBuffer(size int) bool {
 	o.cnt += uint32(len(b))
 
 	if o.cnt == o.params.totalClientToServerSize && o.params.closeByClient {
+		if o.params.recordIoctl {
+			m := make(IoctlMap)
+			o.socket.GetIoctl(m)
+			o.tctx.SimRecordAppend(m)
+		}
 		if !o.params.CloseByRst {
 			o.socket.Close()
 		} else {
@@ -452,6 +466,12 @@ func (o *SocketAppRR1) stop() {
 
 func (o *SocketAppRR1) start() {
 	o.request = []byte(`{"method" :"request"}`)
+	if o.params.reqSize > 0 {
+		o.request = make([]byte, o.params.reqSize)
+		for i := range o.request {
+			o.request[i] = byte(i)
+		}
+	}
 	o.response = []byte(`{"method" :"response"}`)
 
 	if o.isClient && o.socket != nil && (o.socket.GetCap()&SocketCapStream == 0) {
@@ -610,11 +630,18 @@ type transportSimParam struct {
 	closeForce              bool // force to close without flush the Tx queue
 	CloseByRst              bool // the server will send the first request
 	drop                    float32
+	ce                      float32 // mark ECT packets with CE, a congested router
 	debug                   bool
 	ioctlc                  *map[string]interface{}
 	ioctls                  *map[string]interface{}
+	recordIoctl             bool // record the ioctl of the client socket before it is closed
 	ipv6                    bool
 	udp                     bool
+	mtu                     uint16 // L3 MTU of the clients, the fragments are reassembled by the peer
+	reqSize                 int    // size of the r_r request, zero for a short json
+	tls                     bool
+	tlsc                    *TlsCfg // tls init json of the client
+	tlss                    *TlsCfg // tls init json of the server
 }
 
 type transportSim struct {
@@ -660,6 +687,13 @@ func newTransportSim(params *transportSimParam) *transportSim {
 	server.Ipv4ForcedgMac = core.MACKey{0, 0, 1, 0, 0, 1}
 	server.Ipv6ForcedgMac = server.Ipv4ForcedgMac
 
+	if params.mtu > 0 {
+		for _, c := range []*core.CClient{client, server} {
+			c.MTU = params.mtu
+			c.Ipv6Router = &core.CClientIpv6Nd{MTU: params.mtu}
+		}
+	}
+
 	ns.AddClient(client)
 	ns.AddClient(server)
 
@@ -690,6 +724,13 @@ type pktEventTxRx struct {
 
 func (o *pktEventTxRx) OnEvent(a, b interface{}) {
 
+	if o.sim.param.mtu > 0 {
+		o.m = o.sim.reassemble(o.m)
+		if o.m == nil {
+			return // wait for the other fragments
+		}
+	}
+
 	var ps core.ParserPacketState
 	ps.Tctx = o.sim.tctx
 	ps.Tun = &o.sim.client.Ns.Key
@@ -714,12 +755,28 @@ func (o *pktEventTxRx) OnEvent(a, b interface{}) {
 	}
 	ps.L7Len = ps.M.DataLen() - ps.L7
 
+	if isudp && !o.sim.isUdpChecksumValid(&ps) {
+		panic(" wrong udp checksum ")
+	}
+
 	if (o.sim.param.drop > 0.0) && (rand.Float32() < o.sim.param.drop) {
 		fmt.Printf(" drop pkt : %d, to_server: %v\n", o.cnt, o.sendToServer)
 		o.m.FreeMbuf()
 		return
 	}
 
+	if (o.sim.param.ce > 0.0) && (getPktEcn(&ps) != IP_ECN_NOT_ECT) && (rand.Float32() < o.sim.param.ce) {
+		p := o.m.GetData()
+		if o.sim.param.ipv6 {
+			ipv6 := layers.IPv6Header(p[ps.L3 : ps.L3+core.IPV6_HEADER_SIZE])
+			ipv6.SetTOS(ipv6.TOS() | IP_ECN_CE)
+		} else {
+			ipv4 := layers.IPv4Header(p[ps.L3 : ps.L3+20])
+			ipv4.SetTOS(ipv4.GetTOS() | IP_ECN_CE)
+			ipv4.UpdateChecksum()
+		}
+	}
+
 	if o.sendToServer {
 		o.sim.server.ctx.handleRxPacket(&ps)
 	} else {
@@ -728,7 +785,48 @@ func (o *pktEventTxRx) OnEvent(a, b interface{}) {
 	ps.M.FreeMbuf()
 }
 
+// reassemble return the datagram of a fragment once all the fragments are received, nil in case the fragment is kept
+func (o *transportSim) reassemble(m *core.Mbuf) *core.Mbuf {
+	p := m.GetData()
+	l3 := uint16(14 + 8)
+	reass := o.client.Ns.GetIpReass()
+	var r *core.Mbuf
+	if o.param.ipv6 {
+		if p[l3+6] != core.IPV6_EXT_Fragment {
+			return m
+		}
+		r = reass.AddIPv6(m, l3, l3+6, l3+core.IPV6_HEADER_SIZE)
+	} else {
+		if !layers.IPv4Header(p[l3 : l3+20]).IsFragment() {
+			return m
+		}
+		r = reass.AddIPv4(m, l3)
+	}
+	m.FreeMbuf()
+	return r
+}
+
+// isUdpChecksumValid verify the UDP length and checksum of a datagram, the way the parser does
+func (o *transportSim) isUdpChecksumValid(ps *core.ParserPacketState) bool {
+	p := ps.M.GetData()
+	l4len := uint16(ps.M.DataLen()) - ps.L4
+	udp := layers.UDPHeader(p[ps.L4 : ps.L4+8])
+	if udp.Length() != l4len {
+		return false
+	}
+	var pcs uint32
+	if o.param.ipv6 {
+		pcs = layers.IPv6Header(p[ps.L3:ps.L4]).GetPhCs(0, uint8(layers.IPProtocolUDP))
+	} else {
+		pcs = layers.IPv4Header(p[ps.L3:ps.L4]).GetPhCs()
+	}
+	return udp.Checksum() == 0 || layers.PktChecksum(p[ps.L4:ps.L4+l4len], pcs) == 0
+}
+
 func (o *transportSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
+	if o.param.mtu > 0 && m.PktLen()-(14+8) > uint32(o.param.mtu) {
+		panic(" packet is bigger than the mtu ")
+	}
 	o.cnt++
 	//if o.cnt == 2 {
 	//   fmt.Printf(" this is it ! \n")
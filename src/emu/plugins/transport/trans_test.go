@@ -7,6 +7,7 @@ package transport
 
 import (
 	"emu/core"
+	"encoding/pem"
 	"flag"
 	"fmt"
 	"math/rand"
@@ -282,6 +283,7 @@ type TransportSimTestBase struct {
 	cbArg1       interface{}
 	cbArg2       interface{}
 	param        transportSimParam
+	recordCnt    bool // add the counters of the client and the server to the golden file
 }
 
 func (o *TransportSimTestBase) Run(t *testing.T, compare bool) {
@@ -306,6 +308,10 @@ func (o *TransportSimTestBase) Run(t *testing.T, compare bool) {
 	}
 
 	defer sim.tctx.Delete()
+	if o.recordCnt {
+		sim.tctx.SimRecordAppend(sim.client.ctx.cdbv.MarshalValues(false))
+		sim.tctx.SimRecordAppend(sim.server.ctx.cdbv.MarshalValues(false))
+	}
 	sim.tctx.SimRecordCompare(o.testname, t)
 }
 
@@ -697,6 +703,282 @@ func TestPluginTransIoctl5(t *testing.T) {
 	a.Run(t, false)
 }
 
+// SACK on both sides with random packet drop
+func TestPluginTransSack1(t *testing.T) {
+	a := &TransportSimTestBase{
+		testname:     "sack1",
+		monitor:      false,
+		match:        0,
+		capture:      true,
+		duration:     200 * time.Second,
+		clientsToSim: 1,
+		param: transportSimParam{
+			name:                    "a",
+			sendRandom:              false,
+			totalClientToServerSize: 60024,
+			chunkSize:               5000,
+			closeByClient:           true,
+			drop:                    0.1,
+			ioctlc:                  &map[string]interface{}{"sack": 1},
+			ioctls:                  &map[string]interface{}{"sack": 1},
+		},
+	}
+	a.Run(t, false)
+}
+
+// SACK on both sides, server -> client with random packet drop
+func TestPluginTransSack2(t *testing.T) {
+	a := &TransportSimTestBase{
+		testname:     "sack2",
+		monitor:      false,
+		match:        0,
+		capture:      true,
+		duration:     200 * time.Second,
+		clientsToSim: 1,
+		param: transportSimParam{
+			name:                    "s_c",
+			sendRandom:              false,
+			totalClientToServerSize: 60024,
+			chunkSize:               5000,
+			closeByClient:           true,
+			drop:                    0.02,
+			ioctlc:                  &map[string]interface{}{"sack": 1},
+			ioctls:                  &map[string]interface{}{"sack": 1},
+		},
+	}
+	a.Run(t, false)
+}
+
+// SACK requested only by the client, it should not be used
+func TestPluginTransSack3(t *testing.T) {
+	a := &TransportSimTestBase{
+		testname:     "sack3",
+		monitor:      false,
+		match:        0,
+		capture:      true,
+		duration:     200 * time.Second,
+		clientsToSim: 1,
+		param: transportSimParam{
+			name:                    "a",
+			sendRandom:              false,
+			totalClientToServerSize: 60024,
+			chunkSize:               5000,
+			closeByClient:           true,
+			drop:                    0.1,
+			ioctlc:                  &map[string]interface{}{"sack": 1},
+		},
+	}
+	a.Run(t, false)
+}
+
+// NewReno with random packet drop
+func TestPluginTransCc1(t *testing.T) {
+	a := &TransportSimTestBase{
+		testname:     "cc1",
+		monitor:      false,
+		match:        0,
+		capture:      true,
+		duration:     200 * time.Second,
+		clientsToSim: 1,
+		param: transportSimParam{
+			name:                    "a",
+			sendRandom:              false,
+			totalClientToServerSize: 60024,
+			chunkSize:               5000,
+			closeByClient:           true,
+			drop:                    0.1,
+			ioctlc:                  &map[string]interface{}{"cc": "newreno"},
+			recordIoctl:             true,
+		},
+	}
+	a.Run(t, false)
+}
+
+// CUBIC, server -> client with random packet drop
+func TestPluginTransCc2(t *testing.T) {
+	a := &TransportSimTestBase{
+		testname:     "cc2",
+		monitor:      false,
+		match:        0,
+		capture:      true,
+		duration:     200 * time.Second,
+		clientsToSim: 1,
+		param: transportSimParam{
+			name:                    "s_c",
+			sendRandom:              false,
+			totalClientToServerSize: 200000,
+			chunkSize:               5000,
+			closeByClient:           true,
+			drop:                    0.02,
+			ioctls:                  &map[string]interface{}{"cc": "cubic"},
+		},
+	}
+	a.Run(t, false)
+}
+
+// CUBIC with SACK
+func TestPluginTransCc3(t *testing.T) {
+	a := &TransportSimTestBase{
+		testname:     "cc3",
+		monitor:      false,
+		match:        0,
+		capture:      true,
+		duration:     200 * time.Second,
+		clientsToSim: 1,
+		param: transportSimParam{
+			name:                    "a",
+			sendRandom:              false,
+			totalClientToServerSize: 200000,
+			chunkSize:               5000,
+			closeByClient:           true,
+			drop:                    0.02,
+			ioctlc:                  &map[string]interface{}{"cc": "cubic", "sack": 1},
+			recordIoctl:             true,
+			ioctls:                  &map[string]interface{}{"sack": 1},
+		},
+	}
+	a.Run(t, false)
+}
+
+// ECN on both sides, a congested router marks the packets with CE
+func TestPluginTransEcn1(t *testing.T) {
+	a := &TransportSimTestBase{
+		testname:     "ecn1",
+		monitor:      false,
+		match:        0,
+		capture:      true,
+		duration:     60 * time.Second,
+		clientsToSim: 1,
+		param: transportSimParam{
+			name:                    "a",
+			sendRandom:              false,
+			totalClientToServerSize: 100000,
+			chunkSize:               5000,
+			closeByClient:           true,
+			ce:                      0.05,
+			ioctlc:                  &map[string]interface{}{"ecn": 1},
+			ioctls:                  &map[string]interface{}{"ecn": 1},
+		},
+	}
+	a.Run(t, false)
+}
+
+// ECN requested only by the client, not negotiated
+func TestPluginTransEcn2(t *testing.T) {
+	a := &TransportSimTestBase{
+		testname:     "ecn2",
+		monitor:      false,
+		match:        0,
+		capture:      true,
+		duration:     60 * time.Second,
+		clientsToSim: 1,
+		param: transportSimParam{
+			name:                    "a",
+			sendRandom:              false,
+			totalClientToServerSize: 100000,
+			chunkSize:               5000,
+			closeByClient:           true,
+			ce:                      0.05,
+			ioctlc:                  &map[string]interface{}{"ecn": 1},
+		},
+	}
+	a.Run(t, false)
+}
+
+// runTls runs a TLS simulation, the records are random so the TLS counters are verified instead of a golden file
+func runTls(t *testing.T, param transportSimParam, duration time.Duration) (TlsStats, TlsStats) {
+	rand.Seed(0x1234)
+	sim := newTransportSim(&param)
+	sim.tctx.MainLoopSim(duration)
+	defer sim.tctx.Delete()
+	fmt.Printf("\n== Client counters === \n")
+	sim.client.ctx.cdbv.Dump()
+	fmt.Printf("\n== Server counters === \n")
+	sim.server.ctx.cdbv.Dump()
+
+	acf := sim.client.ctx.getActiveFlows() + sim.server.ctx.getActiveFlows()
+	if acf > 0 {
+		t.Fatalf(" active flows exists")
+	}
+	if len(sim.client.ctx.tlsSockets)+len(sim.server.ctx.tlsSockets) > 0 {
+		t.Fatalf(" tls goroutines are still running")
+	}
+	return sim.client.ctx.tlsStats, sim.server.ctx.tlsStats
+}
+
+// tlsCaPem returns the self-signed certificate of the server as a CA
+func tlsCaPem(t *testing.T) *string {
+	cert, err := tlsSelfSignedCert()
+	if err != nil {
+		t.Fatalf(" %v", err)
+	}
+	s := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}))
+	return &s
+}
+
+// TLS client -> server, the peer is not verified
+func TestPluginTransTls1(t *testing.T) {
+	c, s := runTls(t, transportSimParam{
+		name:                    "a",
+		sendRandom:              false,
+		totalClientToServerSize: 100000,
+		chunkSize:               5000,
+		closeByClient:           true,
+		tls:                     true,
+	}, 60*time.Second)
+
+	if c.tls_dial != 1 || c.tls_handshake != 1 || c.tls_sndbyte != 100000 {
+		t.Fatalf(" bad client counters %+v", c)
+	}
+	if s.tls_accept != 1 || s.tls_handshake != 1 || s.tls_rcvbyte != 100000 {
+		t.Fatalf(" bad server counters %+v", s)
+	}
+}
+
+// TLS over IPv6 with random packet drop, the client verifies the server certificate
+func TestPluginTransTls2(t *testing.T) {
+	name := "trex-emu"
+	c, s := runTls(t, transportSimParam{
+		name:                    "a",
+		sendRandom:              true,
+		totalClientToServerSize: 100000,
+		chunkSize:               5000,
+		closeByClient:           true,
+		drop:                    0.05,
+		ipv6:                    true,
+		tls:                     true,
+		tlsc:                    &TlsCfg{Ca: tlsCaPem(t), ServerName: &name},
+	}, 120*time.Second)
+
+	if c.tls_handshake != 1 || c.tls_handshake_err != 0 || c.tls_sndbyte != 100000 {
+		t.Fatalf(" bad client counters %+v", c)
+	}
+	if s.tls_handshake != 1 || s.tls_rcvbyte != 100000 {
+		t.Fatalf(" bad server counters %+v", s)
+	}
+}
+
+// the server certificate doesn't match the server name, the handshake fails
+func TestPluginTransTls3(t *testing.T) {
+	name := "other"
+	c, s := runTls(t, transportSimParam{
+		name:                    "a",
+		sendRandom:              false,
+		totalClientToServerSize: 10000,
+		chunkSize:               5000,
+		closeByClient:           true,
+		tls:                     true,
+		tlsc:                    &TlsCfg{Ca: tlsCaPem(t), ServerName: &name},
+	}, 60*time.Second)
+
+	if c.tls_handshake != 0 || c.tls_handshake_err != 1 || c.tls_sndbyte != 0 {
+		t.Fatalf(" bad client counters %+v", c)
+	}
+	if s.tls_handshake != 0 || s.tls_handshake_err != 1 || s.tls_rcvbyte != 0 {
+		t.Fatalf(" bad server counters %+v", s)
+	}
+}
+
 func MyDial(network, address string) error {
 	fmt.Printf(" %v %v \n", network, address)
 	host, port, err := net.SplitHostPort(address)
@@ -794,6 +1076,74 @@ func TestPluginUdp2(t *testing.T) {
 	a.Run(t, false)
 }
 
+// UDP with ECT(0), all the packets are marked with CE
+func TestPluginUdpEcn1(t *testing.T) {
+	a := &TransportSimTestBase{
+		testname:     "tcp-udp-ecn1",
+		monitor:      false,
+		match:        0,
+		capture:      true,
+		duration:     10 * time.Second,
+		clientsToSim: 1,
+		param: transportSimParam{
+			name:                    "r_r",
+			sendRandom:              false,
+			totalClientToServerSize: 1024,
+			chunkSize:               1024,
+			closeByClient:           true,
+			udp:                     true,
+			ce:                      1.0,
+			ioctlc:                  &map[string]interface{}{"ecn": 1},
+			ioctls:                  &map[string]interface{}{"ecn": 1},
+		},
+	}
+	a.Run(t, false)
+}
+
+// UDP request bigger than the MTU with ECT(0), sent as IPv4 fragments and reassembled by the server
+func TestPluginUdpFrag1(t *testing.T) {
+	a := &TransportSimTestBase{
+		testname:     "tcp-udp-frag1",
+		monitor:      false,
+		match:        0,
+		capture:      true,
+		duration:     10 * time.Second,
+		clientsToSim: 1,
+		recordCnt:    true,
+		param: transportSimParam{
+			name:          "r_r",
+			closeByClient: true,
+			udp:           true,
+			mtu:           1280,
+			reqSize:       3000,
+			ioctlc:        &map[string]interface{}{"ecn": 1},
+		},
+	}
+	a.Run(t, false)
+}
+
+// UDP request bigger than the MTU, sent as IPv6 fragments and reassembled by the server
+func TestPluginUdpFrag2(t *testing.T) {
+	a := &TransportSimTestBase{
+		testname:     "tcp-udp-frag2",
+		monitor:      false,
+		match:        0,
+		capture:      true,
+		duration:     10 * time.Second,
+		clientsToSim: 1,
+		recordCnt:    true,
+		param: transportSimParam{
+			name:          "r_r",
+			closeByClient: true,
+			udp:           true,
+			ipv6:          true,
+			mtu:           1280,
+			reqSize:       3000,
+		},
+	}
+	a.Run(t, false)
+}
+
 func init() {
 	flag.IntVar(&monitor, "monitor", 0, "monitor")
 }
@@ -21,6 +21,7 @@ type udpPkt struct {
 type UdpSocket struct {
 	baseSocket
 	isClosed bool
+	ecn      bool /* send with ECT(0) */
 }
 
 func (o *UdpSocket) init(client *core.CClient, ctx *TransportCtx) {
@@ -28,10 +29,22 @@ func (o *UdpSocket) init(client *core.CClient, ctx *TransportCtx) {
 }
 
 func (o *UdpSocket) SetIoctl(m IoctlMap) error {
+	val, prs := m[IP_IOCTL_ECN]
+	if prs {
+		ecn, ok := getAsInt(val)
+		if ok {
+			o.ecn = ecn > 0
+		}
+	}
 	return o.baseSocket.setIoctlBase(m)
 }
 
 func (o *UdpSocket) GetIoctl(m IoctlMap) error {
+	if o.ecn {
+		m[IP_IOCTL_ECN] = 1
+	} else {
+		m[IP_IOCTL_ECN] = 0
+	}
 	return o.baseSocket.getIoctlBase(m)
 }
 
@@ -96,9 +109,8 @@ func (o *UdpSocket) Write(buf []byte) (res SocketErr, queued bool) {
 	}
 	var pkt udpPkt
 
-	if uint16(len(buf)) > o.GetL7MTU() {
-		o.ctx.udpStats.udp_drop_msg_bigger_mtu++
-		return SeENOBUFS, false
+	if len(buf) > int(o.GetL7MTU()) {
+		return o.writeFragmented(buf)
 	}
 
 	if o.buildDpkt(&pkt, buf) < 0 {
@@ -107,12 +119,79 @@ func (o *UdpSocket) Write(buf []byte) (res SocketErr, queued bool) {
 		}
 		return SeENOBUFS, false
 	}
+	if o.ecn {
+		o.setPktEcn(pkt.m.GetData(), IP_ECN_ECT0)
+		o.ctx.udpStats.udp_ecn_sndect++
+	}
 	o.ctx.udpStats.udp_sndpack++
 	o.ctx.udpStats.udp_sndbyte += uint64(len(buf))
 	o.send(&pkt)
 	return SeOK, true
 }
 
+// writeFragmented send a datagram that is bigger than the MTU as IP fragments
+func (o *UdpSocket) writeFragmented(buf []byte) (res SocketErr, queued bool) {
+	dl := len(buf) + UDP_HEADER_LEN
+	var cnt uint32
+	if o.ipv6 {
+		cnt = core.IPv6FragmentCount(uint32(dl), o.client.GetIPv6MTU())
+	} else {
+		cnt = core.IPv4FragmentCount(uint32(dl), o.client.MTU)
+	}
+	if cnt == 0 {
+		o.ctx.udpStats.udp_drop_msg_bigger_mtu++
+		return SeENOBUFS, false
+	}
+
+	if o.resolve() == false {
+		return SeUNRESOLVED, false
+	}
+
+	l3 := o.l3Offset
+	l4 := o.l4Offset
+	hdr := make([]byte, l4)
+	copy(hdr, o.pktTemplate[:l4])
+	payload := make([]byte, dl)
+	copy(payload, o.pktTemplate[l4:l4+UDP_HEADER_LEN])
+	copy(payload[UDP_HEADER_LEN:], buf)
+	binary.BigEndian.PutUint16(payload[4:6], uint16(dl))
+
+	if o.ecn {
+		o.setPktEcn(hdr, IP_ECN_ECT0)
+		o.ctx.udpStats.udp_ecn_sndect++
+	}
+
+	var frags []*core.Mbuf
+	if o.ipv6 == false {
+		ipv4 := layers.IPv4Header(hdr[l3 : l3+20])
+		ipv4.SetLength(uint16(20 + dl))
+		binary.BigEndian.PutUint16(payload[6:8], 0)
+		cs := layers.PktChecksumTcpUdp(payload, 0, ipv4)
+		binary.BigEndian.PutUint16(payload[6:8], cs)
+		o.ctx.ipv4FragId++
+		frags = core.IPv4Fragment(o.ns, hdr, l3, payload, o.client.MTU, o.ctx.ipv4FragId)
+	} else {
+		ipv6 := layers.IPv6Header(hdr[l3 : l3+core.IPV6_HEADER_SIZE])
+		ipv6.SetPyloadLength(uint16(dl))
+		ipv6.FixUdpL4Checksum(payload, 0)
+		o.ctx.ipv6FragId++
+		frags = core.IPv6Fragment(o.ns, hdr, l3, payload, o.client.GetIPv6MTU(), o.ctx.ipv6FragId)
+	}
+	if frags == nil {
+		o.ctx.udpStats.udp_drop_msg_bigger_mtu++
+		return SeENOBUFS, false
+	}
+
+	o.ctx.udpStats.udp_sndpack++
+	o.ctx.udpStats.udp_sndbyte += uint64(len(buf))
+	o.ctx.udpStats.udp_snd_frag_msg++
+	o.ctx.udpStats.udp_snd_frag_pkts += uint64(len(frags))
+	for _, m := range frags {
+		o.tctx.Veth.Send(m)
+	}
+	return SeOK, true
+}
+
 func (o *UdpSocket) Close() SocketErr {
 	if o.isClosed {
 		return SeCONNECTION_IS_CLOSED
@@ -235,6 +314,9 @@ func (o *UdpSocket) input(ps *core.ParserPacketState) int {
 		p := m.GetData()
 		o.ctx.udpStats.udp_rcvpkt++
 		o.ctx.udpStats.udp_rcvbyte += uint64(len(p[ps.L7:]))
+		if o.ecnInputBase(ps) {
+			o.ctx.udpStats.udp_ecn_ce++
+		}
 		if o.cb != nil {
 			o.cb.OnRxData(p[ps.L7:])
 		}
@@ -15,13 +15,35 @@ type UdpStats struct {
 	udp_rcvpkt  uint64 /* bytes received in sequence */
 
 	udp_drop_unresolved     uint64 /* not resolved  */
-	udp_drop_msg_bigger_mtu uint64 /* msg is bigger than mtu */
+	udp_drop_msg_bigger_mtu uint64 /* msg is bigger than mtu and can't be fragmented */
+
+	udp_snd_frag_msg  uint64 /* msgs sent as ip fragments */
+	udp_snd_frag_pkts uint64 /* ip fragments sent */
+
+	udp_ecn_sndect uint64 /* packets sent with ECT(0) */
+	udp_ecn_ce     uint64 /* packets received with a CE mark */
 
 }
 
 func NewUdpStatsDb(o *UdpStats) *core.CCounterDb {
 	db := core.NewCCounterDb("udp")
 
+	db.Add(&core.CCounterRec{
+		Counter:  &o.udp_ecn_sndect,
+		Name:     "udp_ecn_sndect",
+		Help:     "packets sent with ECT(0)",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.udp_ecn_ce,
+		Name:     "udp_ecn_ce",
+		Help:     "packets received with a CE mark",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
 	db.Add(&core.CCounterRec{
 		Counter:  &o.udp_sndpack,
 		Name:     "udp_sndpack",
@@ -63,5 +85,21 @@ func NewUdpStatsDb(o *UdpStats) *core.CCounterDb {
 		DumpZero: false,
 		Info:     core.ScERROR})
 
+	db.Add(&core.CCounterRec{
+		Counter:  &o.udp_snd_frag_msg,
+		Name:     "udp_snd_frag_msg",
+		Help:     "msgs sent as ip fragments",
+		Unit:     "event",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.udp_snd_frag_pkts,
+		Name:     "udp_snd_frag_pkts",
+		Help:     "ip fragments sent",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
 	return db
 }
@@ -329,6 +329,7 @@ const (
 	DHCPOptT2                    DHCPOpt = 59  // 4, uint32
 	DHCPOptClassID               DHCPOpt = 60  // n, []byte
 	DHCPOptClientID              DHCPOpt = 61  // n >=  2, []byte
+	DHCPOptRelayAgentInfo        DHCPOpt = 82  // n, sub-options
 	DHCPOptDomainSearch          DHCPOpt = 119 // n, string
 	DHCPOptSIPServers            DHCPOpt = 120 // n, url
 	DHCPOptClasslessStaticRoute  DHCPOpt = 121 //
@@ -466,6 +467,8 @@ func (o DHCPOpt) String() string {
 		return "ClassID"
 	case DHCPOptClientID:
 		return "ClientID"
+	case DHCPOptRelayAgentInfo:
+		return "RelayAgentInfo"
 	case DHCPOptDomainSearch:
 		return "DomainSearch"
 	case DHCPOptClasslessStaticRoute:
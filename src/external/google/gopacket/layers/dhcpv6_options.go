@@ -668,3 +668,60 @@ func (o *DHCPv6OptionIANA) Decode(data []byte) error {
 	o.ValidLife = binary.BigEndian.Uint32(p[20:24])
 	return nil
 }
+
+// DHCPv6IAPrefix is a delegated prefix of an IA_PD option (RFC 8415 21.22)
+type DHCPv6IAPrefix struct {
+	PreferredLife uint32
+	ValidLife     uint32
+	PrefixLen     uint8
+	Prefix        net.IP
+}
+
+type DHCPv6OptionIAPD struct {
+	IAID     uint32
+	T1       uint32
+	T2       uint32
+	Status   uint16
+	Prefixes []DHCPv6IAPrefix
+}
+
+func (o *DHCPv6OptionIAPD) Decode(data []byte) error {
+
+	if len(data) < 12 {
+		return errors.New("not enough data to decode")
+	}
+	o.IAID = binary.BigEndian.Uint32(data[0:4])
+	o.T1 = binary.BigEndian.Uint32(data[4:8])
+	o.T2 = binary.BigEndian.Uint32(data[8:12])
+	o.Status = 0
+	o.Prefixes = o.Prefixes[:0]
+
+	p := data[12:]
+	for len(p) >= 4 {
+		code := DHCPv6Opt(binary.BigEndian.Uint16(p[0:2]))
+		length := int(binary.BigEndian.Uint16(p[2:4]))
+		if len(p) < 4+length {
+			return errors.New("not enough data to decode")
+		}
+		v := p[4 : 4+length]
+		switch code {
+		case DHCPv6OptIAPrefix:
+			if length < 25 {
+				return errors.New("not enough data to decode")
+			}
+			o.Prefixes = append(o.Prefixes, DHCPv6IAPrefix{
+				PreferredLife: binary.BigEndian.Uint32(v[0:4]),
+				ValidLife:     binary.BigEndian.Uint32(v[4:8]),
+				PrefixLen:     v[8],
+				Prefix:        append(net.IP(nil), v[9:25]...),
+			})
+		case DHCPv6OptStatusCode:
+			if length < 2 {
+				return errors.New("not enough data to decode")
+			}
+			o.Status = binary.BigEndian.Uint16(v[0:2])
+		}
+		p = p[4+length:]
+	}
+	return nil
+}
@@ -152,6 +152,8 @@ var (
 	LayerTypeLCP                          = gopacket.RegisterLayerType(146, gopacket.LayerTypeMetadata{Name: "LCP", Decoder: gopacket.DecodeFunc(decodeLCP)})
 	LayerTypePAP                          = gopacket.RegisterLayerType(147, gopacket.LayerTypeMetadata{Name: "PAP", Decoder: gopacket.DecodeFunc(decodePAP)})
 	LayerTypeIPCP                         = gopacket.RegisterLayerType(148, gopacket.LayerTypeMetadata{Name: "IPCP", Decoder: gopacket.DecodeFunc(decodeIPCP)})
+	LayerTypeCHAP                         = gopacket.RegisterLayerType(149, gopacket.LayerTypeMetadata{Name: "CHAP", Decoder: gopacket.DecodeFunc(decodeCHAP)})
+	LayerTypeIPV6CP                       = gopacket.RegisterLayerType(150, gopacket.LayerTypeMetadata{Name: "IPV6CP", Decoder: gopacket.DecodeFunc(decodeIPV6CP)})
 )
 
 var (
@@ -86,8 +86,12 @@ func (p *LCP) LayerType() gopacket.LayerType {
 const (
 	LCPTypeConfigurationRequest LCPType = 0x01
 	LCPTypeConfigurationAck     LCPType = 0x02
+	LCPTypeConfigurationNak     LCPType = 0x03
+	LCPTypeConfigurationReject  LCPType = 0x04
 	LCPTypeTerminateRequest     LCPType = 0x05
 	LCPTypeTerminateAck         LCPType = 0x06
+	LCPTypeCodeReject           LCPType = 0x07
+	LCPTypeProtocolReject       LCPType = 0x08
 	LCPTypeEchoRequest          LCPType = 0x09
 	LCPTypeEchoReply            LCPType = 0x0a
 )
@@ -346,6 +350,7 @@ const (
 	IPCPTypeConfigurationRequest IPCPType = 0x01
 	IPCPTypeConfigurationAck     IPCPType = 0x02
 	IPCPTypeConfigurationNak     IPCPType = 0x03
+	IPCPTypeConfigurationReject  IPCPType = 0x04
 )
 
 // IPCPOption struct holds all possible data carried by PAP: Peer-ID, Password and Message
@@ -360,7 +365,9 @@ type IPCPOptionType uint8
 
 // set of supported IPCP Option type
 const (
-	IPCPOptionTypeIPAddress IPCPOptionType = 0x03
+	IPCPOptionTypeIPAddress    IPCPOptionType = 0x03
+	IPCPOptionTypePrimaryDNS   IPCPOptionType = 0x81 // rfc1877
+	IPCPOptionTypeSecondaryDNS IPCPOptionType = 0x83 // rfc1877
 )
 
 func decodeIPCP(data []byte, p gopacket.PacketBuilder) error {
@@ -432,3 +439,222 @@ func (p *IPCP) GetProposedIPAddress() []byte {
 	}
 	return ans
 }
+
+// CHAP describes layer for PPP Challenge Handshake Authentication Protocol, rfc1994
+type CHAP struct {
+	BaseLayer
+	Code       CHAPType
+	Identifier uint8
+	Length     uint16
+	Value      []byte // applicable only CHAP Challenge/Response
+	Name       []byte // applicable only CHAP Challenge/Response
+	Message    []byte // applicable only CHAP Success/Failure
+}
+
+// CHAPType describes CHAP message type
+type CHAPType uint8
+
+// LayerType returns gopacket.LayerTypeCHAP
+func (p *CHAP) LayerType() gopacket.LayerType {
+	return LayerTypeCHAP
+}
+
+// set of supported CHAP message type
+const (
+	CHAPTypeChallenge CHAPType = 0x01
+	CHAPTypeResponse  CHAPType = 0x02
+	CHAPTypeSuccess   CHAPType = 0x03
+	CHAPTypeFailure   CHAPType = 0x04
+)
+
+// set of supported CHAP algorithms, carried after the protocol in the LCP Authentication Protocol option
+const (
+	CHAPAlgorithmMD5      uint8 = 0x05 // rfc1994
+	CHAPAlgorithmMSCHAPv2 uint8 = 0x81 // rfc2759
+)
+
+// GetCHAPSize returns size in byte of CHAP layer
+func (p *CHAP) GetCHAPSize() uint16 {
+	ans := uint16(0)
+	switch p.Code {
+	case CHAPTypeChallenge, CHAPTypeResponse:
+		ans += 1                    // value size field
+		ans += uint16(len(p.Value)) // value
+		ans += uint16(len(p.Name))  // name
+	default:
+		ans += uint16(len(p.Message)) // message
+	}
+	ans += 1 // code
+	ans += 1 // identifier
+	ans += 2 // chap length field size
+	return ans
+}
+
+func decodeCHAP(data []byte, p gopacket.PacketBuilder) error {
+	if len(data) < 4 {
+		return errors.New("CHAP packet too short")
+	}
+	chap := &CHAP{
+		Code:       CHAPType(data[0]),
+		Identifier: data[1],
+		Length:     binary.BigEndian.Uint16(data[2:4]),
+	}
+	if int(chap.Length) < 4 || int(chap.Length) > len(data) {
+		return errors.New("CHAP has invalid length")
+	}
+	switch chap.Code {
+	case CHAPTypeChallenge, CHAPTypeResponse:
+		if chap.Length < 5 || int(data[4])+5 > int(chap.Length) {
+			return errors.New("CHAP has invalid value size")
+		}
+		valueSize := int(data[4])
+		chap.Value = data[5 : 5+valueSize]
+		chap.Name = data[5+valueSize : chap.Length]
+	default:
+		chap.Message = data[4:chap.Length]
+	}
+	chap.BaseLayer = BaseLayer{data[:chap.Length], data[chap.Length:]}
+	p.AddLayer(chap)
+	return nil
+}
+
+// SerializeTo for CHAP layer
+func (p *CHAP) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	if opts.FixLengths {
+		p.Length = p.GetCHAPSize()
+	}
+	bytes, err := b.PrependBytes(int(p.Length))
+	if err != nil {
+		return err
+	}
+	bytes[0] = uint8(p.Code)
+	bytes[1] = p.Identifier
+	binary.BigEndian.PutUint16(bytes[2:], p.Length)
+	switch p.Code {
+	case CHAPTypeChallenge, CHAPTypeResponse:
+		bytes[4] = uint8(len(p.Value))
+		copy(bytes[5:], p.Value)
+		copy(bytes[5+len(p.Value):], p.Name)
+	default:
+		copy(bytes[4:], p.Message)
+	}
+	return nil
+}
+
+// IPV6CP describes layer for IPv6 Control Protocol, rfc5072
+type IPV6CP struct {
+	BaseLayer
+	Code       IPV6CPType
+	Identifier uint8
+	Length     uint16
+	Options    []IPV6CPOption
+}
+
+// IPV6CPType describes IPV6CP message type
+type IPV6CPType uint8
+
+// LayerType returns gopacket.LayerTypeIPV6CP
+func (p *IPV6CP) LayerType() gopacket.LayerType {
+	return LayerTypeIPV6CP
+}
+
+// set of supported IPV6CP message type
+const (
+	IPV6CPTypeConfigurationRequest IPV6CPType = 0x01
+	IPV6CPTypeConfigurationAck     IPV6CPType = 0x02
+	IPV6CPTypeConfigurationNak     IPV6CPType = 0x03
+	IPV6CPTypeConfigurationReject  IPV6CPType = 0x04
+)
+
+// IPV6CPOption struct holds an option carried by IPV6CP
+type IPV6CPOption struct {
+	Type   IPV6CPOptionType
+	Length uint8
+	Value  []uint8
+}
+
+// IPV6CPOptionType describes IPV6CP Option type
+type IPV6CPOptionType uint8
+
+// set of supported IPV6CP Option type
+const (
+	IPV6CPOptionTypeInterfaceIdentifier IPV6CPOptionType = 0x01
+)
+
+func decodeIPV6CP(data []byte, p gopacket.PacketBuilder) error {
+	if len(data) < 4 {
+		return errors.New("IPV6CP packet too short")
+	}
+	ipv6cp := &IPV6CP{
+		Code:       IPV6CPType(data[0]),
+		Identifier: data[1],
+		Length:     binary.BigEndian.Uint16(data[2:4]),
+		Options:    []IPV6CPOption{},
+	}
+	if int(ipv6cp.Length) < 4 || int(ipv6cp.Length) > len(data) {
+		return errors.New("IPV6CP has invalid length")
+	}
+	// decode IPV6CPOption
+	for offset := uint16(4); offset < ipv6cp.Length; {
+		if offset+2 > ipv6cp.Length {
+			return errors.New("IPV6CP option too short")
+		}
+		optionLength := uint16(data[offset+1])
+		if optionLength < 2 || offset+optionLength > ipv6cp.Length {
+			return errors.New("IPV6CP option has invalid length")
+		}
+		ipv6cp.Options = append(ipv6cp.Options, IPV6CPOption{
+			Type:   IPV6CPOptionType(data[offset]),
+			Length: uint8(optionLength),
+			Value:  data[offset+2 : offset+optionLength],
+		})
+		offset += optionLength
+	}
+	ipv6cp.BaseLayer = BaseLayer{data[:ipv6cp.Length], data[ipv6cp.Length:]}
+	p.AddLayer(ipv6cp)
+	return nil
+}
+
+// SerializeTo for IPV6CP layer
+func (p *IPV6CP) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	if opts.FixLengths {
+		p.Length = p.GetIPV6CPSize()
+	}
+	bytes, err := b.PrependBytes(int(p.Length))
+	if err != nil {
+		return err
+	}
+	bytes[0] = uint8(p.Code)
+	bytes[1] = p.Identifier
+	binary.BigEndian.PutUint16(bytes[2:], p.Length)
+	offset := uint8(4)
+	for _, opt := range p.Options {
+		bytes[offset] = uint8(opt.Type)
+		bytes[offset+1] = opt.Length
+		copy(bytes[offset+2:], opt.Value)
+		offset += opt.Length
+	}
+	return nil
+}
+
+// GetIPV6CPSize returns size in byte of IPV6CP layer
+func (p *IPV6CP) GetIPV6CPSize() uint16 {
+	ans := uint16(0)
+	for _, data := range p.Options {
+		ans += uint16(data.Length) // option length size
+	}
+	ans += 1 // code
+	ans += 1 // identifier
+	ans += 2 // ipv6cp length field size
+	return ans
+}
+
+// GetInterfaceIdentifier analyzes Options and returns the Interface Identifier, nil if it is not carried
+func (p *IPV6CP) GetInterfaceIdentifier() []byte {
+	for _, option := range p.Options {
+		if option.Type == IPV6CPOptionTypeInterfaceIdentifier && len(option.Value) == 8 {
+			return option.Value
+		}
+	}
+	return nil
+}
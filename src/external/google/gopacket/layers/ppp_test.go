@@ -24,6 +24,12 @@ PPP_PAP_Response
 >> MANCA rfc1332 FATTO
 PPP_IPCP
 PPP_IPCP_Option_IPAddress
+
+rfc1994
+PPP_CHAP_Challenge
+
+rfc5072
+PPP_IPV6CP_Option_InterfaceIdentifier
 **/
 
 func TestDecodeSerializeLCP(t *testing.T) {
@@ -92,3 +98,49 @@ func TestDecodeSerializeIPCP(t *testing.T) {
 
 	CoreTestSerialize(packet, rawIpcp)
 }
+
+func TestDecodeSerializeCHAP(t *testing.T) {
+
+	// PPP CHAP Challenge
+	var rawChap = []byte{
+		0xba, 0x1a, 0x23, 0x87, 0x07, 0x93, 0x52, 0x55, 0x00, 0xe3, 0x0a, 0xb5, 0x88, 0x64, 0x11, 0x00,
+		0x00, 0x40, 0x00, 0x1a, 0xc2, 0x23, 0x01, 0x01, 0x00, 0x18, 0x10, 0x00, 0x01, 0x02, 0x03, 0x04,
+		0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x73, 0x72, 0x76,
+	}
+
+	packet := CoreTestDecode(rawChap)
+	chap, ok := packet.Layer(LayerTypeCHAP).(*CHAP)
+	if !ok {
+		t.Fatal("CHAP layer was not decoded")
+	}
+	if chap.Code != CHAPTypeChallenge || len(chap.Value) != 16 || string(chap.Name) != "srv" {
+		t.Fatalf("Bad CHAP Challenge %+v", chap)
+	}
+	if chap.GetCHAPSize() != chap.Length {
+		t.Fatalf("Bad CHAP size %d, length %d", chap.GetCHAPSize(), chap.Length)
+	}
+
+	CoreTestSerialize(packet, rawChap)
+}
+
+func TestDecodeSerializeIPV6CP(t *testing.T) {
+
+	// PPP IPV6CP
+	var rawIpv6cp = []byte{
+		0xba, 0x1a, 0x23, 0x87, 0x07, 0x93, 0x52, 0x55, 0x00, 0xe3, 0x0a, 0xb5, 0x88, 0x64, 0x11, 0x00,
+		0x00, 0x40, 0x00, 0x10, 0x80, 0x57, 0x01, 0x01, 0x00, 0x0e, 0x01, 0x0a, 0x50, 0x55, 0x00, 0xff,
+		0xfe, 0xe3, 0x0a, 0xb5,
+	}
+
+	packet := CoreTestDecode(rawIpv6cp)
+	ipv6cp, ok := packet.Layer(LayerTypeIPV6CP).(*IPV6CP)
+	if !ok {
+		t.Fatal("IPV6CP layer was not decoded")
+	}
+	iid := ipv6cp.GetInterfaceIdentifier()
+	if ipv6cp.Code != IPV6CPTypeConfigurationRequest || len(iid) != 8 || iid[0] != 0x50 {
+		t.Fatalf("Bad IPV6CP Configuration Request %+v", ipv6cp)
+	}
+
+	CoreTestSerialize(packet, rawIpv6cp)
+}
@@ -352,8 +352,43 @@ func (w *NgWriter) WriteInterfaceStats(intf int, stats NgInterfaceStatistics) er
 	return err
 }
 
+// NgPacketOptions holds the options of an enhanced packet block. Empty values are not written.
+type NgPacketOptions struct {
+	// Flags is the epb_flags option, see NgEPBFlagDirection*
+	Flags uint32
+	// Comment is a free text comment about the packet
+	Comment string
+}
+
+// Direction values of the epb_flags option
+const (
+	NgEPBFlagDirectionInbound  uint32 = 1
+	NgEPBFlagDirectionOutbound uint32 = 2
+)
+
 // WritePacket writes out packet with the given data and capture info. The given InterfaceIndex must already be added to the file. InterfaceIndex 0 is automatically added by the NewWriter* methods.
 func (w *NgWriter) WritePacket(ci gopacket.CaptureInfo, data []byte) error {
+	return w.writePacket(ci, data, nil)
+}
+
+// WritePacketWithOptions writes out packet with the given data, capture info and enhanced packet block options. The given InterfaceIndex must already be added to the file.
+func (w *NgWriter) WritePacketWithOptions(ci gopacket.CaptureInfo, data []byte, options NgPacketOptions) error {
+	var scratch [2]ngOption
+	i := 0
+	if options.Comment != "" {
+		scratch[i].code = ngOptionCodeComment
+		scratch[i].raw = options.Comment
+		i++
+	}
+	if options.Flags != 0 {
+		scratch[i].code = ngOptionCodeEPBFlags
+		scratch[i].raw = options.Flags
+		i++
+	}
+	return w.writePacket(ci, data, scratch[:i])
+}
+
+func (w *NgWriter) writePacket(ci gopacket.CaptureInfo, data []byte, options []ngOption) error {
 	if ci.InterfaceIndex >= int(w.intf) || ci.InterfaceIndex < 0 {
 		return fmt.Errorf("Can't send statistics for non existent interface %d; have only %d interfaces", ci.InterfaceIndex, w.intf)
 	}
@@ -366,7 +401,7 @@ func (w *NgWriter) WritePacket(ci gopacket.CaptureInfo, data []byte) error {
 
 	length := uint32(len(data)) + 32
 	padding := (4 - length&3) & 3
-	length += padding
+	length += padding + prepareNgOptions(options)
 
 	ts := ci.Timestamp.UnixNano()
 
@@ -387,7 +422,16 @@ func (w *NgWriter) WritePacket(ci gopacket.CaptureInfo, data []byte) error {
 	}
 
 	binary.LittleEndian.PutUint32(w.buf[:4], 0)
-	_, err := w.w.Write(w.buf[4-padding : 8]) // padding + length
+	if _, err := w.w.Write(w.buf[4-padding : 4]); err != nil { // padding
+		return err
+	}
+
+	if err := w.writeOptions(options); err != nil {
+		return err
+	}
+
+	binary.LittleEndian.PutUint32(w.buf[:4], length)
+	_, err := w.w.Write(w.buf[:4])
 	return err
 }
 
@@ -237,3 +237,47 @@ func BenchmarkNgWritePacket(b *testing.B) {
 		w.WritePacket(ci, data)
 	}
 }
+
+func TestNgWritePacketOptions(t *testing.T) {
+	buffer := &bytes.Buffer{}
+
+	w, err := NewNgWriter(buffer, layers.LinkTypeEthernet)
+	if err != nil {
+		t.Fatal("Opening file failed with: ", err)
+	}
+	ci := gopacket.CaptureInfo{
+		Timestamp:      time.Unix(0, 0).UTC(),
+		Length:         len(ngPacketSource[0]),
+		CaptureLength:  len(ngPacketSource[0]),
+		InterfaceIndex: 0,
+	}
+	options := NgPacketOptions{Flags: NgEPBFlagDirectionOutbound, Comment: "tx"}
+	if err = w.WritePacketWithOptions(ci, ngPacketSource[0], options); err != nil {
+		t.Fatal("Couldn't write packet", err)
+	}
+	ci.Length = len(ngPacketSource[1])
+	ci.CaptureLength = len(ngPacketSource[1])
+	if err = w.WritePacket(ci, ngPacketSource[1]); err != nil {
+		t.Fatal("Couldn't write packet", err)
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatal("Couldn't flush buffer", err)
+	}
+
+	r, err := NewNgReader(bytes.NewReader(buffer.Bytes()), DefaultNgReaderOptions)
+	if err != nil {
+		t.Fatal("Couldn't read buffer", err)
+	}
+	for i := 0; i < 2; i++ {
+		data, _, err := r.ReadPacketData()
+		if err != nil {
+			t.Fatal("Couldn't read packet", err)
+		}
+		if !bytes.Equal(data, ngPacketSource[i]) {
+			t.Fatalf("Packet %d is different", i)
+		}
+	}
+	if !bytes.Contains(buffer.Bytes(), []byte{2, 0, 4, 0, 2, 0, 0, 0}) {
+		t.Fatal("epb_flags option is missing")
+	}
+}
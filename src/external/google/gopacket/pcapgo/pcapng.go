@@ -66,6 +66,10 @@ const (
 	ngOptionCodeInterfaceTimestampOffset                             // offset (in seconds) that must be added to packet timestamp
 )
 
+const (
+	ngOptionCodeEPBFlags ngOptionCode = 2 // enhanced packet block flags (direction, reception type)
+)
+
 const (
 	ngOptionCodeInterfaceStatisticsStartTime         ngOptionCode = iota + 2 // Start of capture
 	ngOptionCodeInterfaceStatisticsEndTime                                   // End of capture
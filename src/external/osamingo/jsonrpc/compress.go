@@ -65,3 +65,18 @@ func compressBuff(msg []byte) []byte {
 	w.Flush()
 	return out.Bytes()
 }
+
+// IsCompress return true in case the message is compressed
+func IsCompress(msg []byte) bool {
+	return isCompress(msg)
+}
+
+// Uncompress return the uncompressed message, the message itself in case it is not compressed
+func Uncompress(msg []byte) []byte {
+	return uncompressBuff(msg)
+}
+
+// Compress return the compressed message
+func Compress(msg []byte) []byte {
+	return compressBuff(msg)
+}